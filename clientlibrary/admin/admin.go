@@ -0,0 +1,166 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package admin administers the lease table of a KCL application, e.g. to inspect the leases and checkpoints,
+// release the lease of a crashed worker or reset a checkpoint, with the checkpointers used by the workers.
+package admin
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+// ErrUnsupported is returned for checkpointers which do not implement checkpoint.LeaseAdmin
+var ErrUnsupported = errors.New("the checkpointer does not support administering the lease table")
+
+type (
+	// Lease is the lease of a shard and its checkpoint
+	Lease struct {
+		ShardID           string
+		Owner             string
+		LeaseTimeout      time.Time
+		Checkpoint        string
+		SubSequenceNumber *int64
+		PendingCheckpoint string
+		ClaimRequest      string
+	}
+
+	// Admin administers the lease table of a checkpointer
+	Admin struct {
+		checkpointer chk.Checkpointer
+		leases       chk.LeaseAdmin
+	}
+)
+
+// New returns an Admin of the lease table of an initialised checkpointer
+func New(checkpointer chk.Checkpointer) (*Admin, error) {
+	leases, ok := checkpointer.(chk.LeaseAdmin)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+
+	return &Admin{checkpointer: checkpointer, leases: leases}, nil
+}
+
+// NewFromConfig returns an Admin of the lease table of the configured checkpoint backend. Unlike the workers it
+// doesn't create the lease table, checkpoint.ErrLeaseTableNotFound is returned if it doesn't exist.
+func NewFromConfig(kclConfig *config.KinesisClientLibConfiguration) (*Admin, error) {
+	checkpointer := chk.NewCheckpointer(kclConfig)
+	leases, ok := checkpointer.(chk.LeaseAdmin)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	if err := leases.Open(); err != nil {
+		return nil, err
+	}
+
+	return &Admin{checkpointer: checkpointer, leases: leases}, nil
+}
+
+// Leases returns the leases of all shards ordered by shard ID
+func (a *Admin) Leases() ([]Lease, error) {
+	shards, err := a.leases.ListLeases()
+	if err != nil {
+		return nil, err
+	}
+
+	leases := make([]Lease, len(shards))
+	for i, shard := range shards {
+		leases[i] = toLease(shard)
+	}
+	return leases, nil
+}
+
+// Lease returns the lease of a shard, or checkpoint.ErrLeaseNotFound
+func (a *Admin) Lease(shardID string) (*Lease, error) {
+	shard, err := a.fetchLease(shardID)
+	if err != nil {
+		return nil, err
+	}
+
+	lease := toLease(shard)
+	return &lease, nil
+}
+
+// ReleaseLease removes the lease owner of a shard, so that any worker can take the shard over without waiting for
+// the lease to expire, e.g. after the lease owner crashed. A worker still holding the lease renews it again.
+func (a *Admin) ReleaseLease(shardID string) error {
+	return a.leases.ReleaseLease(shardID)
+}
+
+// ResetCheckpoint sets the checkpoint of a shard, e.g. to reprocess records or to skip records. The checkpoint
+// is overwritten by the lease owner when it checkpoints, so the shard should not be processed meanwhile.
+func (a *Admin) ResetCheckpoint(shardID, sequenceNumber string) error {
+	shard, err := a.fetchLease(shardID)
+	if err != nil {
+		return err
+	}
+
+	shard.SetCheckpoint(sequenceNumber)
+	shard.SetSubSequenceNumber(nil)
+	shard.SetPendingCheckpoint("")
+	shard.SetCheckpointMetadata(nil)
+	return a.checkpointer.CheckpointSequence(shard)
+}
+
+// DeleteLease deletes the lease and the checkpoint of a shard, so that the shard is processed again from the
+// initial position in the stream
+func (a *Admin) DeleteLease(shardID string) error {
+	if _, err := a.fetchLease(shardID); err != nil {
+		return err
+	}
+
+	return a.checkpointer.RemoveLeaseInfo(shardID)
+}
+
+// DeleteLeaseTable deletes the lease table with the leases and checkpoints of all shards
+func (a *Admin) DeleteLeaseTable() error {
+	return a.leases.DeleteLeaseTable()
+}
+
+// fetchLease reads the lease of a shard, a lease without owner, lease timeout and checkpoint does not exist
+func (a *Admin) fetchLease(shardID string) (*par.ShardStatus, error) {
+	shard := &par.ShardStatus{ID: shardID, Mux: &sync.RWMutex{}}
+	err := a.checkpointer.FetchCheckpoint(shard)
+	if errors.Is(err, chk.ErrSequenceIDNotFound) && shard.GetLeaseOwner() == "" && shard.GetLeaseTimeout().IsZero() {
+		return nil, chk.ErrLeaseNotFound
+	}
+	if err != nil && !errors.Is(err, chk.ErrSequenceIDNotFound) {
+		return nil, err
+	}
+
+	return shard, nil
+}
+
+func toLease(shard *par.ShardStatus) Lease {
+	return Lease{
+		ShardID:           shard.ID,
+		Owner:             shard.GetLeaseOwner(),
+		LeaseTimeout:      shard.GetLeaseTimeout(),
+		Checkpoint:        shard.GetCheckpoint(),
+		SubSequenceNumber: shard.GetSubSequenceNumber(),
+		PendingCheckpoint: shard.GetPendingCheckpoint(),
+		ClaimRequest:      shard.GetClaimRequest(),
+	}
+}
@@ -0,0 +1,104 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+func TestAdmin(t *testing.T) {
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker")
+	table, _ := chk.NewMemoryLeaseTable("")
+	checkpointer := chk.NewMemoryCheckpoint(kclConfig).WithLeaseTable(table)
+	for _, shardID := range []string{"0002", "0001"} {
+		shard := &par.ShardStatus{ID: shardID, Checkpoint: "100", Mux: &sync.RWMutex{}}
+		assert.Nil(t, checkpointer.GetLease(shard, "worker"))
+		assert.Nil(t, checkpointer.CheckpointSequence(shard))
+	}
+
+	admin, err := New(checkpointer)
+	assert.Nil(t, err)
+
+	leases, err := admin.Leases()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(leases))
+	assert.Equal(t, "0001", leases[0].ShardID)
+	assert.Equal(t, "worker", leases[0].Owner)
+	assert.Equal(t, "100", leases[0].Checkpoint)
+
+	// the lease is released whichever worker holds it
+	assert.Nil(t, admin.ReleaseLease("0001"))
+	lease, err := admin.Lease("0001")
+	assert.Nil(t, err)
+	assert.Equal(t, "", lease.Owner)
+
+	assert.Nil(t, admin.ResetCheckpoint("0001", "42"))
+	lease, err = admin.Lease("0001")
+	assert.Nil(t, err)
+	assert.Equal(t, "42", lease.Checkpoint)
+
+	assert.Nil(t, admin.DeleteLease("0001"))
+	_, err = admin.Lease("0001")
+	assert.Equal(t, chk.ErrLeaseNotFound, err)
+	assert.Equal(t, chk.ErrLeaseNotFound, admin.ReleaseLease("0001"))
+
+	assert.Nil(t, admin.DeleteLeaseTable())
+	leases, err = admin.Leases()
+	assert.Nil(t, err)
+	assert.Empty(t, leases)
+}
+
+type customCheckpointer struct {
+	chk.Checkpointer
+}
+
+func TestAdminUnsupported(t *testing.T) {
+	_, err := New(customCheckpointer{})
+	assert.Equal(t, ErrUnsupported, err)
+}
+
+func TestNewFromConfigDoesNotCreateLeaseTable(t *testing.T) {
+	var targets []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		targets = append(targets, r.Header.Get("X-Amz-Target"))
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"__type":"com.amazonaws.dynamodb.v20120810#ResourceNotFoundException","message":"not found"}`))
+	}))
+	defer server.Close()
+
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+		WithDynamoDBEndpoint(server.URL)
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+
+	_, err := NewFromConfig(kclConfig)
+	assert.Equal(t, chk.ErrLeaseTableNotFound, err)
+	assert.Equal(t, []string{"DynamoDB_20120810.DescribeTable"}, targets)
+}
@@ -30,44 +30,45 @@
 package checkpoint
 
 import (
-	"errors"
-	"fmt"
+	"encoding/base64"
 
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
 	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
 )
 
 const (
-	LeaseKeyKey       = "ShardID"
-	LeaseOwnerKey     = "AssignedTo"
-	LeaseTimeoutKey   = "LeaseTimeout"
-	SequenceNumberKey = "Checkpoint"
-	ParentShardIdKey  = "ParentShardId"
-	ClaimRequestKey   = "ClaimRequest"
+	LeaseKeyKey           = "ShardID"
+	LeaseOwnerKey         = "AssignedTo"
+	LeaseTimeoutKey       = "LeaseTimeout"
+	SequenceNumberKey     = "Checkpoint"
+	SubSequenceNumberKey  = "SubSequenceNumber"
+	PendingCheckpointKey  = "PendingCheckpoint"
+	CheckpointMetadataKey = "CheckpointMetadata"
+	ParentShardIdKey      = "ParentShardId"
+	ClaimRequestKey       = "ClaimRequest"
+	LeaseCounterKey       = "LeaseCounter"
+	LeaseSchemaVersionKey = "LeaseSchemaVersion"
 
 	// ShardEnd We've completely processed all records in this shard.
 	ShardEnd = "SHARD_END"
 
-	// ErrShardClaimed is returned when shard is claimed
+	// ErrShardClaimed is the message of ErrShardAlreadyClaimed
 	ErrShardClaimed = "shard is already claimed by another node"
 )
 
-type ErrLeaseNotAcquired struct {
-	cause string
-}
-
-func (e ErrLeaseNotAcquired) Error() string {
-	return fmt.Sprintf("lease not acquired: %s", e.cause)
-}
-
 // Checkpointer handles checkpointing when a record has been processed
 type Checkpointer interface {
 	// Init initialises the Checkpoint
 	Init() error
 
-	// GetLease attempts to gain a lock on the given shard
+	// GetLease attempts to gain a lock on the given shard. With lease stealing, the lease owner fails to renew a
+	// lease claimed by another worker with ErrShardAlreadyClaimed and the claim request is set on the shard.
 	GetLease(*par.ShardStatus, string) error
 
-	// CheckpointSequence writes a checkpoint at the designated sequence ID
+	// CheckpointSequence writes a checkpoint at the designated sequence ID. With lease stealing, a claim request
+	// placed by another worker survives the checkpoint, so that the lease owner can hand off the shard with a last
+	// checkpoint before the steal completes. The checkpoint is only written if the claim request is known to the
+	// lease owner, otherwise the claim request is read into the shard and the lease owner is signaled to hand off.
 	CheckpointSequence(*par.ShardStatus) error
 
 	// FetchCheckpoint retrieves the checkpoint for the given shard
@@ -89,8 +90,29 @@ type Checkpointer interface {
 	ClaimShard(*par.ShardStatus, string) error
 }
 
-// ErrSequenceIDNotFound is returned by FetchCheckpoint when no SequenceID is found
-var ErrSequenceIDNotFound = errors.New("SequenceIDNotFoundForShard")
+// NewCheckpointer returns the checkpointer of the configured backend, it has to be initialised with Init
+func NewCheckpointer(kclConfig *config.KinesisClientLibConfiguration) Checkpointer {
+	switch kclConfig.CheckpointBackend {
+	case config.RedisBackend:
+		return NewRedisCheckpoint(kclConfig)
+	case config.SQLBackend:
+		return NewSQLCheckpoint(kclConfig)
+	case config.MemoryBackend:
+		return NewMemoryCheckpoint(kclConfig)
+	default:
+		return NewDynamoCheckpoint(kclConfig)
+	}
+}
+
+// encodeCheckpointMetadata encodes the metadata of a checkpoint for the backends storing strings
+func encodeCheckpointMetadata(metadata []byte) string {
+	return base64.StdEncoding.EncodeToString(metadata)
+}
 
-// ErrShardNotAssigned is returned by ListActiveWorkers when no AssignedTo is found
-var ErrShardNotAssigned = errors.New("AssignedToNotFoundForShard")
+// decodeCheckpointMetadata decodes the metadata of a checkpoint stored as a string, nil if there is none
+func decodeCheckpointMetadata(metadata string) ([]byte, error) {
+	if metadata == "" {
+		return nil, nil
+	}
+	return base64.StdEncoding.DecodeString(metadata)
+}
@@ -104,3 +104,21 @@ type DynamoDBAPI interface {
 	// Otherwise, the item is not deleted.
 	DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
 }
+
+// DeleteTableAPI is implemented by the DynamoDB clients which can delete the lease table, see
+// DynamoCheckpoint.DeleteLeaseTable. It is kept apart from DynamoDBAPI so that the mocks of the workers don't
+// have to implement it.
+type DeleteTableAPI interface {
+	// The DeleteTable operation deletes a table and all of its items. After a
+	// DeleteTable request, the specified table is in the DELETING state until
+	// DynamoDB completes the deletion.
+	DeleteTable(ctx context.Context, params *dynamodb.DeleteTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteTableOutput, error)
+}
+
+// ContinuousBackupsAPI is implemented by the DynamoDB clients which can enable the point-in-time recovery of the
+// lease table, see LeaseTablePointInTimeRecovery. It is kept apart from DynamoDBAPI for the same reason as
+// DeleteTableAPI.
+type ContinuousBackupsAPI interface {
+	// UpdateContinuousBackups enables or disables point in time recovery for the specified table.
+	UpdateContinuousBackups(ctx context.Context, params *dynamodb.UpdateContinuousBackupsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateContinuousBackupsOutput, error)
+}
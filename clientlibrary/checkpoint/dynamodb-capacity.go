@@ -0,0 +1,120 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package checkpoint
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/metrics"
+)
+
+// capacityReportingDynamoDB requests the capacity consumed by the calls to the lease table and reports it to the
+// MonitoringService and the LeaseTableCapacityHandler of the configuration
+type capacityReportingDynamoDB struct {
+	DynamoDBAPI
+	kclConfig *config.KinesisClientLibConfiguration
+}
+
+func newCapacityReportingDynamoDB(svc DynamoDBAPI, kclConfig *config.KinesisClientLibConfiguration) DynamoDBAPI {
+	if _, ok := svc.(*capacityReportingDynamoDB); ok || svc == nil {
+		return svc
+	}
+	return &capacityReportingDynamoDB{DynamoDBAPI: svc, kclConfig: kclConfig}
+}
+
+// unwrappedDynamoDB returns the DynamoDB client of the checkpointer without the capacity reporting, so that the
+// optional APIs of the client, e.g. DeleteTableAPI, can be asserted
+func (checkpointer *DynamoCheckpoint) unwrappedDynamoDB() DynamoDBAPI {
+	if capacityReporting, ok := checkpointer.svc.(*capacityReportingDynamoDB); ok {
+		return capacityReporting.DynamoDBAPI
+	}
+	return checkpointer.svc
+}
+
+func (d *capacityReportingDynamoDB) Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	params.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+	output, err := d.DynamoDBAPI.Scan(ctx, params, optFns...)
+	if err == nil && output != nil {
+		d.report("Scan", output.ConsumedCapacity, false)
+	}
+	return output, err
+}
+
+func (d *capacityReportingDynamoDB) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	params.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+	output, err := d.DynamoDBAPI.GetItem(ctx, params, optFns...)
+	if err == nil && output != nil {
+		d.report("GetItem", output.ConsumedCapacity, false)
+	}
+	return output, err
+}
+
+func (d *capacityReportingDynamoDB) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	params.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+	output, err := d.DynamoDBAPI.PutItem(ctx, params, optFns...)
+	if err == nil && output != nil {
+		d.report("PutItem", output.ConsumedCapacity, true)
+	}
+	return output, err
+}
+
+func (d *capacityReportingDynamoDB) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	params.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+	output, err := d.DynamoDBAPI.UpdateItem(ctx, params, optFns...)
+	if err == nil && output != nil {
+		d.report("UpdateItem", output.ConsumedCapacity, true)
+	}
+	return output, err
+}
+
+func (d *capacityReportingDynamoDB) DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	params.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+	output, err := d.DynamoDBAPI.DeleteItem(ctx, params, optFns...)
+	if err == nil && output != nil {
+		d.report("DeleteItem", output.ConsumedCapacity, true)
+	}
+	return output, err
+}
+
+// report reports the consumed capacity of a call, conditional writes rejected by DynamoDB consume capacity too but
+// are not reported as their output is not returned by the SDK
+func (d *capacityReportingDynamoDB) report(operation string, consumed *types.ConsumedCapacity, write bool) {
+	if consumed == nil || consumed.CapacityUnits == nil {
+		return
+	}
+
+	capacity := &config.LeaseTableCapacity{Operation: operation}
+	if write {
+		capacity.WriteCapacityUnits = *consumed.CapacityUnits
+	} else {
+		capacity.ReadCapacityUnits = *consumed.CapacityUnits
+	}
+
+	if m, ok := d.kclConfig.MonitoringService.(metrics.LeaseTableMonitoringService); ok {
+		m.IncrLeaseTableConsumedCapacity(operation, capacity.ReadCapacityUnits, capacity.WriteCapacityUnits)
+	}
+	if d.kclConfig.LeaseTableCapacityHandler != nil {
+		d.kclConfig.LeaseTableCapacityHandler(capacity)
+	}
+}
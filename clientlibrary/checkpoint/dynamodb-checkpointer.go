@@ -33,11 +33,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/aws/retry"
-	awsConfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 
@@ -49,6 +49,9 @@ import (
 const (
 	// NumMaxRetries is the max times of doing retry
 	NumMaxRetries = 10
+
+	// tableCreationTimeout is the max time to wait for the lease table to become active after it has been created
+	tableCreationTimeout = 5 * time.Minute
 )
 
 var (
@@ -67,69 +70,88 @@ type DynamoCheckpoint struct {
 	kclConfig     *config.KinesisClientLibConfiguration
 	Retries       int
 	lastLeaseSync time.Time
+
+	// leases observes the lease counters of the other workers to tell whether their leases have expired
+	leases *leaseObserver
+
+	// serializer stores the checkpoints in the items of the leases
+	serializer LeaseSerializer
 }
 
 func NewDynamoCheckpoint(kclConfig *config.KinesisClientLibConfiguration) *DynamoCheckpoint {
 	checkpointer := &DynamoCheckpoint{
-		log:                     kclConfig.Logger,
+		log:                     kclConfig.Logger.WithFields(logger.Fields{"workerID": kclConfig.WorkerID}),
 		TableName:               kclConfig.TableName,
 		leaseTableReadCapacity:  int64(kclConfig.InitialLeaseTableReadCapacity),
 		leaseTableWriteCapacity: int64(kclConfig.InitialLeaseTableWriteCapacity),
 		LeaseDuration:           kclConfig.FailoverTimeMillis,
 		kclConfig:               kclConfig,
 		Retries:                 NumMaxRetries,
+		leases:                  newLeaseObserver(),
+		serializer:              NewLeaseSerializer(kclConfig.LeaseAttributeCompressionThreshold),
 	}
 
 	return checkpointer
 }
 
-// WithDynamoDB is used to provide DynamoDB service
+// WithDynamoDB is used to provide DynamoDB service, the capacity consumed by the calls to the lease table is reported
+// like for the service created by Init
 func (checkpointer *DynamoCheckpoint) WithDynamoDB(svc DynamoDBAPI) *DynamoCheckpoint {
-	checkpointer.svc = svc
+	checkpointer.svc = newCapacityReportingDynamoDB(svc, checkpointer.kclConfig)
+	return checkpointer
+}
+
+// WithLeaseSerializer is used to store the checkpoints in the items of the leases with a custom schema
+func (checkpointer *DynamoCheckpoint) WithLeaseSerializer(serializer LeaseSerializer) *DynamoCheckpoint {
+	checkpointer.serializer = serializer
 	return checkpointer
 }
 
 // Init initialises the DynamoDB Checkpoint
 func (checkpointer *DynamoCheckpoint) Init() error {
-	checkpointer.log.Infof("Creating DynamoDB session")
+	checkpointer.connect()
 
-	if checkpointer.svc == nil {
-		resolver := aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
-			if service == dynamodb.ServiceID && len(checkpointer.kclConfig.DynamoDBEndpoint) > 0 {
-				return aws.Endpoint{
-					PartitionID:   "aws",
-					URL:           checkpointer.kclConfig.DynamoDBEndpoint,
-					SigningRegion: checkpointer.kclConfig.RegionName,
-				}, nil
-			}
-			// returning EndpointNotFoundError will allow the service to fallback to it's default resolution
-			return aws.Endpoint{}, &aws.EndpointNotFoundError{}
-		})
-
-		cfg, err := awsConfig.LoadDefaultConfig(
-			context.TODO(),
-			awsConfig.WithRegion(checkpointer.kclConfig.RegionName),
-			awsConfig.WithCredentialsProvider(checkpointer.kclConfig.DynamoDBCredentials),
-			awsConfig.WithEndpointResolverWithOptions(resolver),
-			awsConfig.WithRetryer(func() aws.Retryer {
-				return retry.AddWithMaxBackoffDelay(retry.NewStandard(), retry.DefaultMaxBackoff)
-			}),
-		)
-
-		if err != nil {
-			checkpointer.log.Fatalf("unable to load SDK config, %v", err)
+	if !checkpointer.doesTableExist() {
+		if err := checkpointer.createTable(); err != nil {
+			return err
 		}
+	}
 
-		checkpointer.svc = dynamodb.NewFromConfig(cfg)
+	// the point-in-time recovery is enabled by every start, so that a failed attempt is retried
+	if checkpointer.kclConfig.LeaseTablePointInTimeRecovery {
+		return checkpointer.enablePointInTimeRecovery()
 	}
 
+	return nil
+}
+
+// Open initialises the DynamoDB Checkpoint without creating the lease table, it returns ErrLeaseTableNotFound
+// if the table doesn't exist
+func (checkpointer *DynamoCheckpoint) Open() error {
+	checkpointer.connect()
+
 	if !checkpointer.doesTableExist() {
-		return checkpointer.createTable()
+		return ErrLeaseTableNotFound
 	}
 
 	return nil
 }
 
+func (checkpointer *DynamoCheckpoint) connect() {
+	checkpointer.log.Infof("Creating DynamoDB session")
+
+	if checkpointer.svc == nil {
+		cfg, err := checkpointer.kclConfig.LoadAWSConfig(dynamodb.ServiceID, checkpointer.kclConfig.DynamoDBEndpoint,
+			checkpointer.kclConfig.DynamoDBCredentials, checkpointer.kclConfig.DynamoDBRoleARN, checkpointer.kclConfig.MonitoringService)
+
+		if err != nil {
+			checkpointer.log.Fatalf("unable to load SDK config, %v", err)
+		}
+
+		checkpointer.svc = newCapacityReportingDynamoDB(dynamodb.NewFromConfig(cfg), checkpointer.kclConfig)
+	}
+}
+
 // GetLease attempts to gain a lock on the given shard
 func (checkpointer *DynamoCheckpoint) GetLease(shard *par.ShardStatus, newAssignTo string) error {
 	newLeaseTimeout := time.Now().Add(time.Duration(checkpointer.LeaseDuration) * time.Millisecond).UTC()
@@ -139,6 +161,16 @@ func (checkpointer *DynamoCheckpoint) GetLease(shard *par.ShardStatus, newAssign
 		return err
 	}
 
+	// the attributes of a later schema would be lost by the write
+	if _, err := checkpointer.checkLeaseSchema(currentCheckpoint, shard.ID); err != nil {
+		return err
+	}
+
+	leaseCounter, err := parseLeaseCounter(currentCheckpoint)
+	if err != nil {
+		return err
+	}
+
 	isClaimRequestExpired := shard.IsClaimRequestExpired(checkpointer.kclConfig)
 
 	var claimRequest string
@@ -148,7 +180,8 @@ func (checkpointer *DynamoCheckpoint) GetLease(shard *par.ShardStatus, newAssign
 			claimRequest = currentCheckpointClaimRequest.(*types.AttributeValueMemberS).Value
 			if newAssignTo != claimRequest && !isClaimRequestExpired {
 				checkpointer.log.Debugf("another worker: %s has a claim on this shard. Not going to renew the lease", claimRequest)
-				return errors.New(ErrShardClaimed)
+				shard.SetClaimRequest(claimRequest)
+				return ErrShardAlreadyClaimed
 			}
 		}
 	}
@@ -165,26 +198,19 @@ func (checkpointer *DynamoCheckpoint) GetLease(shard *par.ShardStatus, newAssign
 		assignedTo := assignedVar.(*types.AttributeValueMemberS).Value
 		leaseTimeout := leaseVar.(*types.AttributeValueMemberS).Value
 
-		currentLeaseTimeout, err := time.Parse(time.RFC3339Nano, leaseTimeout)
-		if err != nil {
-			return err
-		}
-
-		if checkpointer.kclConfig.EnableLeaseStealing {
-			if time.Now().UTC().Before(currentLeaseTimeout) && assignedTo != newAssignTo && !isClaimRequestExpired {
-				return ErrLeaseNotAcquired{"current lease timeout not yet expired"}
-			}
-		} else {
-			if time.Now().UTC().Before(currentLeaseTimeout) && assignedTo != newAssignTo {
-				return ErrLeaseNotAcquired{"current lease timeout not yet expired"}
+		// the lease timeout is written from the clock of the lease owner, whether the lease has expired is told by
+		// the changes of the lease counter instead
+		if assignedTo != newAssignTo && assignedTo != "" && !checkpointer.isLeaseExpired(shard.ID, assignedTo, leaseCounter) {
+			if !checkpointer.kclConfig.EnableLeaseStealing || !isClaimRequestExpired {
+				return ErrLeaseNotAcquired{cause: "current lease not yet expired"}
 			}
 		}
 
-		checkpointer.log.Debugf("Attempting to get a lock for shard: %s, leaseTimeout: %s, assignedTo: %s, newAssignedTo: %s", shard.ID, currentLeaseTimeout, assignedTo, newAssignTo)
+		checkpointer.log.Debugf("Attempting to get a lock for shard: %s, leaseTimeout: %s, assignedTo: %s, newAssignedTo: %s", shard.ID, leaseTimeout, assignedTo, newAssignTo)
 		conditionalExpression = "ShardID = :id AND AssignedTo = :assigned_to AND LeaseTimeout = :lease_timeout"
 		expressionAttributeValues = map[string]types.AttributeValue{
 			":id": &types.AttributeValueMemberS{
-				Value: shard.ID,
+				Value: checkpointer.leaseKey(shard.ID),
 			},
 			":assigned_to": &types.AttributeValueMemberS{
 				Value: assignedTo,
@@ -197,7 +223,7 @@ func (checkpointer *DynamoCheckpoint) GetLease(shard *par.ShardStatus, newAssign
 
 	marshalledCheckpoint := map[string]types.AttributeValue{
 		LeaseKeyKey: &types.AttributeValueMemberS{
-			Value: shard.ID,
+			Value: checkpointer.leaseKey(shard.ID),
 		},
 		LeaseOwnerKey: &types.AttributeValueMemberS{
 			Value: newAssignTo,
@@ -205,6 +231,9 @@ func (checkpointer *DynamoCheckpoint) GetLease(shard *par.ShardStatus, newAssign
 		LeaseTimeoutKey: &types.AttributeValueMemberS{
 			Value: newLeaseTimeoutString,
 		},
+		LeaseCounterKey: &types.AttributeValueMemberN{
+			Value: strconv.FormatInt(leaseCounter+1, 10),
+		},
 	}
 
 	if len(shard.ParentShardId) > 0 {
@@ -219,6 +248,11 @@ func (checkpointer *DynamoCheckpoint) GetLease(shard *par.ShardStatus, newAssign
 		}
 	}
 
+	// keep the prepared checkpoint and the metadata so that the new lease owner can resume from them
+	if err := checkpointer.marshalLease(marshalledCheckpoint, shard); err != nil {
+		return err
+	}
+
 	if checkpointer.kclConfig.EnableLeaseStealing {
 		if claimRequest != "" && claimRequest == newAssignTo && !isClaimRequestExpired {
 			if expressionAttributeValues == nil {
@@ -235,7 +269,7 @@ func (checkpointer *DynamoCheckpoint) GetLease(shard *par.ShardStatus, newAssign
 	if err != nil {
 		var conditionalCheckErr *types.ConditionalCheckFailedException
 		if errors.As(err, &conditionalCheckErr) {
-			return ErrLeaseNotAcquired{conditionalCheckErr.ErrorMessage()}
+			return ErrLeaseNotAcquired{cause: conditionalCheckErr.ErrorMessage(), err: err}
 		}
 		return err
 	}
@@ -243,6 +277,9 @@ func (checkpointer *DynamoCheckpoint) GetLease(shard *par.ShardStatus, newAssign
 	shard.Mux.Lock()
 	shard.AssignedTo = newAssignTo
 	shard.LeaseTimeout = newLeaseTimeout
+	shard.LeaseCounter = leaseCounter + 1
+	// the lease has been written without the claim request
+	shard.ClaimRequest = ""
 	shard.Mux.Unlock()
 
 	return nil
@@ -253,7 +290,7 @@ func (checkpointer *DynamoCheckpoint) CheckpointSequence(shard *par.ShardStatus)
 	leaseTimeout := shard.GetLeaseTimeout().UTC().Format(time.RFC3339Nano)
 	marshalledCheckpoint := map[string]types.AttributeValue{
 		LeaseKeyKey: &types.AttributeValueMemberS{
-			Value: shard.ID,
+			Value: checkpointer.leaseKey(shard.ID),
 		},
 		SequenceNumberKey: &types.AttributeValueMemberS{
 			Value: shard.GetCheckpoint(),
@@ -270,19 +307,92 @@ func (checkpointer *DynamoCheckpoint) CheckpointSequence(shard *par.ShardStatus)
 		marshalledCheckpoint[ParentShardIdKey] = &types.AttributeValueMemberS{Value: shard.ParentShardId}
 	}
 
-	return checkpointer.saveItem(marshalledCheckpoint)
+	// The whole item is replaced, so the checkpoint and the pending checkpoint are committed
+	// (or cleared) atomically. The metadata of the record processor is stored with the checkpoint it belongs to.
+	if err := checkpointer.marshalLease(marshalledCheckpoint, shard); err != nil {
+		return err
+	}
+
+	// The checkpoint is fenced by the lease, so that a worker which lost its lease cannot overwrite the checkpoints
+	// of the new lease owner.
+	fence := leaseFence{owner: shard.GetLeaseOwner(), counter: shard.GetLeaseCounter()}
+	if fence.counter > 0 {
+		marshalledCheckpoint[LeaseCounterKey] = &types.AttributeValueMemberN{Value: strconv.FormatInt(fence.counter, 10)}
+	}
+
+	if !checkpointer.kclConfig.EnableLeaseStealing {
+		err := checkpointer.conditionalUpdate(fence.condition(), fence.values(), marshalledCheckpoint)
+		return checkpointer.checkFencing(shard.ID, fence, err)
+	}
+
+	err := checkpointer.saveClaimedItem(marshalledCheckpoint, shard.GetClaimRequest(), fence)
+	var conditionalCheckErr *types.ConditionalCheckFailedException
+	if !errors.As(err, &conditionalCheckErr) {
+		return err
+	}
+
+	currentCheckpoint, err := checkpointer.getItem(shard.ID)
+	if err != nil {
+		return err
+	}
+	if fencingErr := fence.check(shard.ID, currentCheckpoint, conditionalCheckErr); fencingErr != nil {
+		return fencingErr
+	}
+	var claimRequest string
+	if currentClaimRequest, ok := currentCheckpoint[ClaimRequestKey]; ok {
+		claimRequest = currentClaimRequest.(*types.AttributeValueMemberS).Value
+	}
+	shard.SetClaimRequest(claimRequest)
+
+	err = checkpointer.saveClaimedItem(marshalledCheckpoint, claimRequest, fence)
+	return checkpointer.checkFencing(shard.ID, fence, err)
+}
+
+// checkFencing returns a FencingError if the checkpoint has been rejected because the lease changed
+func (checkpointer *DynamoCheckpoint) checkFencing(shardID string, fence leaseFence, err error) error {
+	var conditionalCheckErr *types.ConditionalCheckFailedException
+	if !errors.As(err, &conditionalCheckErr) {
+		return err
+	}
+
+	currentCheckpoint, getErr := checkpointer.getItem(shardID)
+	if getErr != nil {
+		return getErr
+	}
+	if fencingErr := fence.check(shardID, currentCheckpoint, err); fencingErr != nil {
+		return fencingErr
+	}
+	return err
 }
 
 // FetchCheckpoint retrieves the checkpoint for the given shard
 func (checkpointer *DynamoCheckpoint) FetchCheckpoint(shard *par.ShardStatus) error {
+	_, err := checkpointer.fetchCheckpoint(shard)
+	return err
+}
+
+// fetchCheckpoint retrieves the checkpoint for the given shard and returns the item of the lease. The lease counter
+// is not updated, it only changes when the lease is acquired.
+func (checkpointer *DynamoCheckpoint) fetchCheckpoint(shard *par.ShardStatus) (map[string]types.AttributeValue, error) {
 	checkpoint, err := checkpointer.getItem(shard.ID)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	if err := checkpointer.unmarshalLease(checkpoint, shard); err != nil {
+		return nil, err
+	}
+
+	// another worker may be attempting to steal the shard
+	if claimRequest, ok := checkpoint[ClaimRequestKey]; ok {
+		shard.SetClaimRequest(claimRequest.(*types.AttributeValueMemberS).Value)
+	} else {
+		shard.SetClaimRequest("")
 	}
 
 	sequenceID, ok := checkpoint[SequenceNumberKey]
 	if !ok {
-		return ErrSequenceIDNotFound
+		return checkpoint, ErrSequenceIDNotFound
 	}
 
 	checkpointer.log.Debugf("Retrieved Shard Iterator %s", sequenceID.(*types.AttributeValueMemberS).Value)
@@ -290,23 +400,27 @@ func (checkpointer *DynamoCheckpoint) FetchCheckpoint(shard *par.ShardStatus) er
 
 	if assignedTo, ok := checkpoint[LeaseOwnerKey]; ok {
 		shard.SetLeaseOwner(assignedTo.(*types.AttributeValueMemberS).Value)
+		if leaseCounter, err := parseLeaseCounter(checkpoint); err == nil {
+			checkpointer.leases.observe(shard.ID, assignedTo.(*types.AttributeValueMemberS).Value, leaseCounter)
+		}
 	}
 
 	// Use up-to-date leaseTimeout to avoid ConditionalCheckFailedException when claiming
 	if leaseTimeout, ok := checkpoint[LeaseTimeoutKey]; ok && leaseTimeout.(*types.AttributeValueMemberS).Value != "" {
 		currentLeaseTimeout, err := time.Parse(time.RFC3339Nano, leaseTimeout.(*types.AttributeValueMemberS).Value)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		shard.LeaseTimeout = currentLeaseTimeout
 	}
 
-	return nil
+	return checkpoint, nil
 }
 
 // RemoveLeaseInfo to remove lease info for shard entry in dynamoDB because the shard no longer exists in Kinesis
 func (checkpointer *DynamoCheckpoint) RemoveLeaseInfo(shardID string) error {
 	err := checkpointer.removeItem(shardID)
+	checkpointer.leases.forget(shardID)
 
 	if err != nil {
 		checkpointer.log.Errorf("Error in removing lease info for shard: %s, Error: %+v", shardID, err)
@@ -323,7 +437,7 @@ func (checkpointer *DynamoCheckpoint) RemoveLeaseOwner(shardID string) error {
 		TableName: aws.String(checkpointer.TableName),
 		Key: map[string]types.AttributeValue{
 			LeaseKeyKey: &types.AttributeValueMemberS{
-				Value: shardID,
+				Value: checkpointer.leaseKey(shardID),
 			},
 		},
 		UpdateExpression: aws.String("remove " + LeaseOwnerKey),
@@ -337,6 +451,81 @@ func (checkpointer *DynamoCheckpoint) RemoveLeaseOwner(shardID string) error {
 
 	_, err := checkpointer.svc.UpdateItem(context.TODO(), input)
 
+	return WrapThrottlingError("UpdateItem", err)
+}
+
+// ReleaseLease removes the lease owner of a shard whichever worker holds the lease
+func (checkpointer *DynamoCheckpoint) ReleaseLease(shardID string) error {
+	_, err := checkpointer.svc.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName: aws.String(checkpointer.TableName),
+		Key: map[string]types.AttributeValue{
+			LeaseKeyKey: &types.AttributeValueMemberS{
+				Value: checkpointer.leaseKey(shardID),
+			},
+		},
+		UpdateExpression:    aws.String("remove " + LeaseOwnerKey),
+		ConditionExpression: aws.String("attribute_exists(ShardID)"),
+	})
+
+	var conditionalCheckErr *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionalCheckErr) {
+		return ErrLeaseNotFound
+	}
+	return WrapThrottlingError("UpdateItem", err)
+}
+
+// ListLeases returns the leases of all shards in the lease table ordered by shard ID
+func (checkpointer *DynamoCheckpoint) ListLeases() ([]*par.ShardStatus, error) {
+	var shardIDs []string
+	input := &dynamodb.ScanInput{
+		ConsistentRead:       checkpointer.consistentRead(checkpointer.kclConfig.LeaseScanConsistency),
+		ProjectionExpression: aws.String(LeaseKeyKey),
+		Select:               "SPECIFIC_ATTRIBUTES",
+		TableName:            aws.String(checkpointer.TableName),
+	}
+	checkpointer.filterLeaseKeys(input)
+	paginator := dynamodb.NewScanPaginator(checkpointer.svc, input)
+	for paginator.HasMorePages() {
+		scanOutput, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+
+		for _, result := range scanOutput.Items {
+			if shardID, ok := checkpointer.shardIDOf(result); ok {
+				shardIDs = append(shardIDs, shardID)
+			}
+		}
+	}
+
+	return fetchLeases(checkpointer, shardIDs)
+}
+
+// DeleteLeaseTable deletes the DynamoDB table with all leases and checkpoints. The table is deleted
+// asynchronously by DynamoDB. A lease table shared with other applications is kept, only the leases with the
+// LeaseKeyPrefix of the application are deleted. The DynamoDB client has to implement DeleteTableAPI.
+func (checkpointer *DynamoCheckpoint) DeleteLeaseTable() error {
+	if checkpointer.kclConfig.LeaseKeyPrefix != "" {
+		leases, err := checkpointer.ListLeases()
+		if err != nil {
+			return err
+		}
+		for _, lease := range leases {
+			if err := checkpointer.RemoveLeaseInfo(lease.ID); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	deleter, ok := checkpointer.unwrappedDynamoDB().(DeleteTableAPI)
+	if !ok {
+		return errors.New("the DynamoDB client does not implement DeleteTable")
+	}
+
+	_, err := deleter.DeleteTable(context.TODO(), &dynamodb.DeleteTableInput{
+		TableName: aws.String(checkpointer.TableName),
+	})
 	return err
 }
 
@@ -387,8 +576,8 @@ func (checkpointer *DynamoCheckpoint) ListActiveWorkers(shardStatus map[string]*
 
 // ClaimShard places a claim request on a shard to signal a steal attempt
 func (checkpointer *DynamoCheckpoint) ClaimShard(shard *par.ShardStatus, claimID string) error {
-	err := checkpointer.FetchCheckpoint(shard)
-	if err != nil && err != ErrSequenceIDNotFound {
+	currentCheckpoint, err := checkpointer.fetchCheckpoint(shard)
+	if err != nil && !errors.Is(err, ErrSequenceIDNotFound) {
 		return err
 	}
 	leaseTimeoutString := shard.GetLeaseTimeout().Format(time.RFC3339Nano)
@@ -396,7 +585,7 @@ func (checkpointer *DynamoCheckpoint) ClaimShard(shard *par.ShardStatus, claimID
 	conditionalExpression := `ShardID = :id AND LeaseTimeout = :lease_timeout AND attribute_not_exists(ClaimRequest)`
 	expressionAttributeValues := map[string]types.AttributeValue{
 		":id": &types.AttributeValueMemberS{
-			Value: shard.ID,
+			Value: checkpointer.leaseKey(shard.ID),
 		},
 		":lease_timeout": &types.AttributeValueMemberS{
 			Value: leaseTimeoutString,
@@ -405,7 +594,7 @@ func (checkpointer *DynamoCheckpoint) ClaimShard(shard *par.ShardStatus, claimID
 
 	marshalledCheckpoint := map[string]types.AttributeValue{
 		LeaseKeyKey: &types.AttributeValueMemberS{
-			Value: shard.ID,
+			Value: checkpointer.leaseKey(shard.ID),
 		},
 		LeaseTimeoutKey: &types.AttributeValueMemberS{
 			Value: leaseTimeoutString,
@@ -418,6 +607,11 @@ func (checkpointer *DynamoCheckpoint) ClaimShard(shard *par.ShardStatus, claimID
 		},
 	}
 
+	// the lease owner keeps checkpointing until the lease is handed over
+	if err := checkpointer.marshalLease(marshalledCheckpoint, shard); err != nil {
+		return err
+	}
+
 	if leaseOwner := shard.GetLeaseOwner(); leaseOwner == "" {
 		conditionalExpression += " AND attribute_not_exists(AssignedTo)"
 	} else {
@@ -436,6 +630,15 @@ func (checkpointer *DynamoCheckpoint) ClaimShard(shard *par.ShardStatus, claimID
 		expressionAttributeValues[":checkpoint"] = &types.AttributeValueMemberS{Value: checkpoint}
 	}
 
+	// the lease counter of the lease owner is kept, so that its checkpoints are not fenced by the claim
+	if leaseCounter, ok := currentCheckpoint[LeaseCounterKey]; ok {
+		marshalledCheckpoint[LeaseCounterKey] = leaseCounter
+		conditionalExpression += " AND LeaseCounter = :lease_counter"
+		expressionAttributeValues[":lease_counter"] = leaseCounter
+	} else {
+		conditionalExpression += " AND attribute_not_exists(LeaseCounter)"
+	}
+
 	if shard.ParentShardId == "" {
 		conditionalExpression += " AND attribute_not_exists(ParentShardId)"
 	} else {
@@ -448,46 +651,90 @@ func (checkpointer *DynamoCheckpoint) ClaimShard(shard *par.ShardStatus, claimID
 }
 
 func (checkpointer *DynamoCheckpoint) syncLeases(shardStatus map[string]*par.ShardStatus) error {
-	log := checkpointer.kclConfig.Logger
+	log := checkpointer.log
 
 	if (checkpointer.lastLeaseSync.Add(time.Duration(checkpointer.kclConfig.LeaseSyncingTimeIntervalMillis) * time.Millisecond)).After(time.Now()) {
 		return nil
 	}
 
 	checkpointer.lastLeaseSync = time.Now()
-	input := &dynamodb.ScanInput{
-		ProjectionExpression: aws.String(fmt.Sprintf("%s,%s,%s", LeaseKeyKey, LeaseOwnerKey, SequenceNumberKey)),
-		Select:               "SPECIFIC_ATTRIBUTES",
-		TableName:            aws.String(checkpointer.kclConfig.TableName),
-	}
 
-	scanOutput, err := checkpointer.svc.Scan(context.TODO(), input)
+	// the segments of the lease table are scanned in parallel, the first error fails the sync
+	segments := checkpointer.kclConfig.LeaseTableScanSegments
+	if segments < 1 {
+		segments = 1
+	}
+	errs := make(chan error, segments)
+	for segment := 0; segment < segments; segment++ {
+		go func(segment int) {
+			errs <- checkpointer.scanLeases(shardStatus, segment, segments)
+		}(segment)
+	}
 
+	var err error
+	for i := 0; i < segments; i++ {
+		if segmentErr := <-errs; segmentErr != nil && err == nil {
+			err = segmentErr
+		}
+	}
 	if err != nil {
-		log.Debugf("Error performing DynamoDB Scan. Error: %+v ", err)
+		log.Debugf("Error performing SyncLeases. Error: %+v ", err)
 		return err
 	}
+	log.Debugf("Lease sync completed. Next lease sync will occur in %s", time.Duration(checkpointer.kclConfig.LeaseSyncingTimeIntervalMillis)*time.Millisecond)
+	return nil
+}
 
-	results := scanOutput.Items
-	for _, result := range results {
-		shardId, foundShardId := result[LeaseKeyKey]
-		assignedTo, foundAssignedTo := result[LeaseOwnerKey]
-		checkpoint, foundCheckpoint := result[SequenceNumberKey]
-		if !foundShardId || !foundAssignedTo || !foundCheckpoint {
-			continue
+// scanLeases updates the shard status from a segment of the lease table. The segment is scanned page by page, so
+// that lease tables beyond the 1 MB limit of a Scan are synced without holding all leases in memory.
+func (checkpointer *DynamoCheckpoint) scanLeases(shardStatus map[string]*par.ShardStatus, segment, totalSegments int) error {
+	input := &dynamodb.ScanInput{
+		ConsistentRead:       checkpointer.consistentRead(checkpointer.kclConfig.LeaseScanConsistency),
+		ProjectionExpression: aws.String(fmt.Sprintf("%s,%s,%s,%s,%s", LeaseKeyKey, LeaseOwnerKey, SequenceNumberKey, ClaimRequestKey, LeaseCounterKey)),
+		Select:               "SPECIFIC_ATTRIBUTES",
+		TableName:            aws.String(checkpointer.kclConfig.TableName),
+	}
+	checkpointer.filterLeaseKeys(input)
+	if totalSegments > 1 {
+		input.Segment = aws.Int32(int32(segment))
+		input.TotalSegments = aws.Int32(int32(totalSegments))
+	}
+
+	paginator := dynamodb.NewScanPaginator(checkpointer.svc, input)
+	for paginator.HasMorePages() {
+		scanOutput, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			checkpointer.log.Debugf("Error performing DynamoDB Scan. Error: %+v ", err)
+			return WrapThrottlingError("Scan", err)
 		}
 
-		if shard, ok := shardStatus[shardId.(*types.AttributeValueMemberS).Value]; ok {
-			shard.SetLeaseOwner(assignedTo.(*types.AttributeValueMemberS).Value)
-			shard.SetCheckpoint(checkpoint.(*types.AttributeValueMemberS).Value)
+		for _, result := range scanOutput.Items {
+			shardId, foundShardId := checkpointer.shardIDOf(result)
+			assignedTo, foundAssignedTo := result[LeaseOwnerKey]
+			checkpoint, foundCheckpoint := result[SequenceNumberKey]
+			if !foundShardId || !foundAssignedTo || !foundCheckpoint {
+				continue
+			}
+
+			// the lease counters are observed with each sync, so that expired leases are told from the time they
+			// stopped being renewed
+			if leaseCounter, err := parseLeaseCounter(result); err == nil {
+				checkpointer.leases.observe(shardId, assignedTo.(*types.AttributeValueMemberS).Value, leaseCounter)
+			}
+
+			if shard, ok := shardStatus[shardId]; ok {
+				shard.SetLeaseOwner(assignedTo.(*types.AttributeValueMemberS).Value)
+				shard.SetCheckpoint(checkpoint.(*types.AttributeValueMemberS).Value)
+				// the lease owner learns about claim requests without renewing the lease
+				if claimRequest, ok := result[ClaimRequestKey]; ok {
+					shard.SetClaimRequest(claimRequest.(*types.AttributeValueMemberS).Value)
+				} else {
+					shard.SetClaimRequest("")
+				}
+			}
 		}
 	}
 
-	if err != nil {
-		log.Debugf("Error performing SyncLeases. Error: %+v ", err)
-		return err
-	}
-	log.Debugf("Lease sync completed. Next lease sync will occur in %s", time.Duration(checkpointer.kclConfig.LeaseSyncingTimeIntervalMillis)*time.Millisecond)
 	return nil
 }
 
@@ -505,14 +752,55 @@ func (checkpointer *DynamoCheckpoint) createTable() error {
 				KeyType:       types.KeyTypeHash,
 			},
 		},
-		ProvisionedThroughput: &types.ProvisionedThroughput{
+		BillingMode: checkpointer.kclConfig.LeaseTableBillingMode,
+		TableName:   aws.String(checkpointer.TableName),
+	}
+
+	// the capacity must not be specified for on-demand tables
+	if input.BillingMode != types.BillingModePayPerRequest {
+		input.ProvisionedThroughput = &types.ProvisionedThroughput{
 			ReadCapacityUnits:  aws.Int64(checkpointer.leaseTableReadCapacity),
 			WriteCapacityUnits: aws.Int64(checkpointer.leaseTableWriteCapacity),
-		},
-		TableName: aws.String(checkpointer.TableName),
+		}
+	}
+
+	for key, value := range checkpointer.kclConfig.LeaseTableTags {
+		input.Tags = append(input.Tags, types.Tag{Key: aws.String(key), Value: aws.String(value)})
+	}
+
+	if checkpointer.kclConfig.LeaseTableSSEEnabled {
+		input.SSESpecification = &types.SSESpecification{
+			Enabled: aws.Bool(true),
+			SSEType: types.SSETypeKms,
+		}
+		if checkpointer.kclConfig.LeaseTableSSEKMSKeyID != "" {
+			input.SSESpecification.KMSMasterKeyId = aws.String(checkpointer.kclConfig.LeaseTableSSEKMSKeyID)
+		}
 	}
+
 	_, err := checkpointer.svc.CreateTable(context.Background(), input)
+	return err
+}
+
+// enablePointInTimeRecovery enables the continuous backups of the lease table once it is active. The DynamoDB client
+// has to implement ContinuousBackupsAPI.
+func (checkpointer *DynamoCheckpoint) enablePointInTimeRecovery() error {
+	backups, ok := checkpointer.unwrappedDynamoDB().(ContinuousBackupsAPI)
+	if !ok {
+		return errors.New("the DynamoDB client does not implement UpdateContinuousBackups")
+	}
 
+	waiter := dynamodb.NewTableExistsWaiter(checkpointer.svc)
+	if err := waiter.Wait(context.Background(), &dynamodb.DescribeTableInput{TableName: aws.String(checkpointer.TableName)}, tableCreationTimeout); err != nil {
+		return err
+	}
+
+	_, err := backups.UpdateContinuousBackups(context.Background(), &dynamodb.UpdateContinuousBackupsInput{
+		TableName: aws.String(checkpointer.TableName),
+		PointInTimeRecoverySpecification: &types.PointInTimeRecoverySpecification{
+			PointInTimeRecoveryEnabled: aws.Bool(true),
+		},
+	})
 	return err
 }
 
@@ -532,6 +820,23 @@ func (checkpointer *DynamoCheckpoint) saveItem(item map[string]types.AttributeVa
 	})
 }
 
+// saveClaimedItem writes the item with the claim request, if the claim request of the stored item is the same and
+// the lease is still held by the fence
+func (checkpointer *DynamoCheckpoint) saveClaimedItem(item map[string]types.AttributeValue, claimRequest string, fence leaseFence) error {
+	values := fence.values()
+	if claimRequest == "" {
+		delete(item, ClaimRequestKey)
+		return checkpointer.conditionalUpdate(fence.condition()+" AND attribute_not_exists(ClaimRequest)", values, item)
+	}
+
+	item[ClaimRequestKey] = &types.AttributeValueMemberS{Value: claimRequest}
+	if values == nil {
+		values = make(map[string]types.AttributeValue)
+	}
+	values[":claim_request"] = &types.AttributeValueMemberS{Value: claimRequest}
+	return checkpointer.conditionalUpdate(fence.condition()+" AND ClaimRequest = :claim_request", values, item)
+}
+
 func (checkpointer *DynamoCheckpoint) conditionalUpdate(conditionExpression string, expressionAttributeValues map[string]types.AttributeValue, item map[string]types.AttributeValue) error {
 	return checkpointer.putItem(&dynamodb.PutItemInput{
 		ConditionExpression:       aws.String(conditionExpression),
@@ -541,22 +846,28 @@ func (checkpointer *DynamoCheckpoint) conditionalUpdate(conditionExpression stri
 	})
 }
 
+// putItem writes the item unless it exceeds the item size limit of DynamoDB
 func (checkpointer *DynamoCheckpoint) putItem(input *dynamodb.PutItemInput) error {
+	if err := checkItemSize(input.Item); err != nil {
+		return err
+	}
 	_, err := checkpointer.svc.PutItem(context.Background(), input)
-	return err
+	return WrapThrottlingError("PutItem", err)
 }
 
 func (checkpointer *DynamoCheckpoint) getItem(shardID string) (map[string]types.AttributeValue, error) {
 	item, err := checkpointer.svc.GetItem(context.Background(), &dynamodb.GetItemInput{
 		TableName:      aws.String(checkpointer.TableName),
-		ConsistentRead: aws.Bool(true),
+		ConsistentRead: checkpointer.consistentRead(checkpointer.kclConfig.LeaseGetConsistency),
 		Key: map[string]types.AttributeValue{
 			LeaseKeyKey: &types.AttributeValueMemberS{
-				Value: shardID,
+				Value: checkpointer.leaseKey(shardID),
 			},
 		},
 	})
 
+	err = WrapThrottlingError("GetItem", err)
+
 	// fix problem when starts the environment from scratch (dynamo table is empty)
 	if item == nil {
 		return nil, err
@@ -565,15 +876,136 @@ func (checkpointer *DynamoCheckpoint) getItem(shardID string) (map[string]types.
 	return item.Item, err
 }
 
+// leaseKey returns the key of the lease of the shard in the lease table, the shard ID prefixed by the
+// LeaseKeyPrefix of the application
+func (checkpointer *DynamoCheckpoint) leaseKey(shardID string) string {
+	return checkpointer.kclConfig.LeaseKeyPrefix + shardID
+}
+
+// shardIDOf returns the shard ID of the lease of an item. It returns false for the items without lease key and for
+// the leases of the other applications sharing the lease table.
+func (checkpointer *DynamoCheckpoint) shardIDOf(item map[string]types.AttributeValue) (string, bool) {
+	key, ok := item[LeaseKeyKey].(*types.AttributeValueMemberS)
+	if !ok || !strings.HasPrefix(key.Value, checkpointer.kclConfig.LeaseKeyPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(key.Value, checkpointer.kclConfig.LeaseKeyPrefix), true
+}
+
+// filterLeaseKeys scopes a scan of a shared lease table to the leases with the LeaseKeyPrefix of the application, so
+// that the leases of the other applications are not returned by DynamoDB
+func (checkpointer *DynamoCheckpoint) filterLeaseKeys(input *dynamodb.ScanInput) {
+	if checkpointer.kclConfig.LeaseKeyPrefix == "" {
+		return
+	}
+	input.FilterExpression = aws.String("begins_with(" + LeaseKeyKey + ", :prefix)")
+	input.ExpressionAttributeValues = map[string]types.AttributeValue{
+		":prefix": &types.AttributeValueMemberS{
+			Value: checkpointer.kclConfig.LeaseKeyPrefix,
+		},
+	}
+}
+
+// IsLeaseExpired tells whether the lease of the shard has expired by the time from the changes of its lease counter
+// observed by the lease syncs and the checkpoint fetches. The lease timeout is compared with the time for the leases
+// which have not been observed yet.
+func (checkpointer *DynamoCheckpoint) IsLeaseExpired(shard *par.ShardStatus, at time.Time) bool {
+	owner := shard.GetLeaseOwner()
+	if owner == "" {
+		return true
+	}
+	if expired, observed := checkpointer.leases.isExpiredAt(shard.ID, owner, at, time.Duration(checkpointer.LeaseDuration)*time.Millisecond); observed {
+		return expired
+	}
+	return !shard.GetLeaseTimeout().After(at)
+}
+
+// isLeaseExpired tells whether the lease of the shard has not been renewed by its owner for the lease duration
+func (checkpointer *DynamoCheckpoint) isLeaseExpired(shardID, owner string, leaseCounter int64) bool {
+	return checkpointer.leases.isExpired(shardID, owner, leaseCounter, time.Duration(checkpointer.LeaseDuration)*time.Millisecond)
+}
+
+// consistentRead returns the ConsistentRead parameter of the read consistency, the reads are strongly consistent
+// unless they are configured to be eventually consistent
+func (checkpointer *DynamoCheckpoint) consistentRead(consistency config.ReadConsistency) *bool {
+	return aws.Bool(consistency != config.EventuallyConsistent)
+}
+
 func (checkpointer *DynamoCheckpoint) removeItem(shardID string) error {
 	_, err := checkpointer.svc.DeleteItem(context.Background(), &dynamodb.DeleteItemInput{
 		TableName: aws.String(checkpointer.TableName),
 		Key: map[string]types.AttributeValue{
 			LeaseKeyKey: &types.AttributeValueMemberS{
-				Value: shardID,
+				Value: checkpointer.leaseKey(shardID),
 			},
 		},
 	})
 
-	return err
+	return WrapThrottlingError("DeleteItem", err)
+}
+
+// leaseFence is the lease a checkpoint is conditioned on
+type leaseFence struct {
+	owner   string
+	counter int64
+}
+
+func (f leaseFence) condition() string {
+	condition := "AssignedTo = :assigned_to"
+	if f.owner == "" {
+		condition = "attribute_not_exists(AssignedTo)"
+	}
+	// leases acquired before the lease counter was introduced are only fenced by their owner
+	if f.counter > 0 {
+		condition += " AND LeaseCounter = :lease_counter"
+	}
+	return condition
+}
+
+func (f leaseFence) values() map[string]types.AttributeValue {
+	values := make(map[string]types.AttributeValue)
+	if f.owner != "" {
+		values[":assigned_to"] = &types.AttributeValueMemberS{Value: f.owner}
+	}
+	if f.counter > 0 {
+		values[":lease_counter"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(f.counter, 10)}
+	}
+	// DynamoDB rejects empty expression attribute values
+	if len(values) == 0 {
+		return nil
+	}
+	return values
+}
+
+// check returns a FencingError if the lease of the item is not held by the fence anymore
+func (f leaseFence) check(shardID string, item map[string]types.AttributeValue, err error) error {
+	var owner string
+	if assignedTo, ok := item[LeaseOwnerKey]; ok {
+		owner = assignedTo.(*types.AttributeValueMemberS).Value
+	}
+	counter, parseErr := parseLeaseCounter(item)
+	if parseErr != nil {
+		return parseErr
+	}
+
+	if owner == f.owner && (f.counter == 0 || counter == f.counter) {
+		return nil
+	}
+	return &FencingError{
+		ShardID:             shardID,
+		LeaseOwner:          f.owner,
+		LeaseCounter:        f.counter,
+		CurrentLeaseOwner:   owner,
+		CurrentLeaseCounter: counter,
+		err:                 err,
+	}
+}
+
+// parseLeaseCounter returns the lease counter of the item, 0 if the lease has never been acquired
+func parseLeaseCounter(item map[string]types.AttributeValue) (int64, error) {
+	leaseCounter, ok := item[LeaseCounterKey]
+	if !ok {
+		return 0, nil
+	}
+	return strconv.ParseInt(leaseCounter.(*types.AttributeValueMemberN).Value, 10, 64)
 }
@@ -28,8 +28,12 @@
 package checkpoint
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
 	"errors"
+	"fmt"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -43,6 +47,14 @@ import (
 	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
 )
 
+// expireLease lets the checkpointer observe the lease of the owner as not renewed for the lease duration
+func expireLease(checkpoint *DynamoCheckpoint, shardID, owner string) {
+	checkpoint.leases.leases[shardID] = observedLease{
+		owner:   owner,
+		changed: time.Now().Add(-time.Duration(checkpoint.LeaseDuration) * time.Millisecond),
+	}
+}
+
 func TestDoesTableExist(t *testing.T) {
 	svc := &mockDynamoDB{client: nil, tableExist: true, item: map[string]types.AttributeValue{}}
 	checkpoint := &DynamoCheckpoint{
@@ -60,6 +72,65 @@ func TestDoesTableExist(t *testing.T) {
 	}
 }
 
+func TestCreateTableProvisioned(t *testing.T) {
+	svc := &mockDynamoDB{tableExist: false, item: map[string]types.AttributeValue{}}
+	kclConfig := cfg.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc")
+
+	checkpoint := NewDynamoCheckpoint(kclConfig).WithDynamoDB(svc)
+	assert.Nil(t, checkpoint.Init())
+
+	input := svc.createTableInput
+	assert.NotNil(t, input)
+	assert.Equal(t, types.BillingModeProvisioned, input.BillingMode)
+	assert.Equal(t, int64(kclConfig.InitialLeaseTableReadCapacity), aws.ToInt64(input.ProvisionedThroughput.ReadCapacityUnits))
+	assert.Nil(t, input.SSESpecification)
+	assert.Empty(t, input.Tags)
+	assert.Nil(t, svc.continuousBackupsInput)
+}
+
+func TestCreateTableOnDemand(t *testing.T) {
+	svc := &mockDynamoDB{tableExist: false, item: map[string]types.AttributeValue{}}
+	kclConfig := cfg.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithLeaseTableBillingMode(types.BillingModePayPerRequest).
+		WithLeaseTableTags(map[string]string{"team": "kcl"}).
+		WithLeaseTablePointInTimeRecovery(true).
+		WithLeaseTableServerSideEncryption("alias/kcl")
+
+	checkpoint := NewDynamoCheckpoint(kclConfig).WithDynamoDB(svc)
+	assert.Nil(t, checkpoint.Init())
+
+	input := svc.createTableInput
+	assert.NotNil(t, input)
+	assert.Equal(t, types.BillingModePayPerRequest, input.BillingMode)
+	assert.Nil(t, input.ProvisionedThroughput)
+	assert.Equal(t, []types.Tag{{Key: aws.String("team"), Value: aws.String("kcl")}}, input.Tags)
+	assert.True(t, aws.ToBool(input.SSESpecification.Enabled))
+	assert.Equal(t, types.SSETypeKms, input.SSESpecification.SSEType)
+	assert.Equal(t, "alias/kcl", aws.ToString(input.SSESpecification.KMSMasterKeyId))
+
+	assert.NotNil(t, svc.continuousBackupsInput)
+	assert.Equal(t, "appName", aws.ToString(svc.continuousBackupsInput.TableName))
+	assert.True(t, aws.ToBool(svc.continuousBackupsInput.PointInTimeRecoverySpecification.PointInTimeRecoveryEnabled))
+}
+
+func TestPointInTimeRecoveryRetried(t *testing.T) {
+	svc := &mockDynamoDB{tableExist: false, item: map[string]types.AttributeValue{}, continuousBackupsErr: errors.New("throttled")}
+	kclConfig := cfg.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithLeaseTablePointInTimeRecovery(true)
+
+	// the point-in-time recovery of the created table failed to be enabled
+	checkpoint := NewDynamoCheckpoint(kclConfig).WithDynamoDB(svc)
+	assert.Equal(t, svc.continuousBackupsErr, checkpoint.Init())
+	assert.NotNil(t, svc.createTableInput)
+
+	// it is enabled by the next start although the table exists
+	svc.createTableInput = nil
+	svc.continuousBackupsErr = nil
+	assert.Nil(t, NewDynamoCheckpoint(kclConfig).WithDynamoDB(svc).Init())
+	assert.Nil(t, svc.createTableInput)
+	assert.True(t, aws.ToBool(svc.continuousBackupsInput.PointInTimeRecoverySpecification.PointInTimeRecoveryEnabled))
+}
+
 func TestGetLeaseNotAcquired(t *testing.T) {
 	svc := &mockDynamoDB{tableExist: true, item: map[string]types.AttributeValue{}}
 	kclConfig := cfg.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
@@ -127,6 +198,7 @@ func TestGetLeaseAquired(t *testing.T) {
 		Checkpoint: "deadbeef",
 		Mux:        &sync.RWMutex{},
 	}
+	expireLease(checkpoint, shard.ID, "abcd-efgh")
 	err := checkpoint.GetLease(shard, "ijkl-mnop")
 
 	if err != nil {
@@ -356,6 +428,7 @@ func TestGetLeaseConditional(t *testing.T) {
 		t.Errorf("Could not fetch checkpoint %s", err)
 	}
 
+	expireLease(checkpoint, shard.ID, "abcd-efgh")
 	err = checkpoint.GetLease(shard, "ijkl-mnop")
 	if err != nil {
 		t.Errorf("Lease not aquired after timeout %s", err)
@@ -423,6 +496,67 @@ func TestListActiveWorkersErrShardNotAssigned(t *testing.T) {
 	}
 }
 
+func TestSyncLeasesScansSegmentsAndPages(t *testing.T) {
+	// each of the segments holds two pages of one lease
+	var mux sync.Mutex
+	var scans []string
+	svc := &mockDynamoDB{tableExist: true, item: map[string]types.AttributeValue{}}
+	svc.scan = func(params *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+		segment := aws.ToInt32(params.Segment)
+		page := "0"
+		if params.ExclusiveStartKey != nil {
+			page = params.ExclusiveStartKey[LeaseKeyKey].(*types.AttributeValueMemberS).Value[2:]
+		}
+		mux.Lock()
+		scans = append(scans, fmt.Sprintf("%d/%d:%s", segment, aws.ToInt32(params.TotalSegments), page))
+		mux.Unlock()
+
+		shardID := fmt.Sprintf("%d-%s", segment, page)
+		output := &dynamodb.ScanOutput{Items: []map[string]types.AttributeValue{{
+			LeaseKeyKey:       &types.AttributeValueMemberS{Value: shardID},
+			LeaseOwnerKey:     &types.AttributeValueMemberS{Value: "worker_" + shardID},
+			SequenceNumberKey: &types.AttributeValueMemberS{Value: "checkpoint_" + shardID},
+		}}}
+		if page == "0" {
+			output.LastEvaluatedKey = map[string]types.AttributeValue{
+				LeaseKeyKey: &types.AttributeValueMemberS{Value: fmt.Sprintf("%d-1", segment)},
+			}
+		}
+		return output, nil
+	}
+	kclConfig := cfg.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithLeaseStealing(true).
+		WithLeaseTableScanSegments(2)
+
+	checkpoint := NewDynamoCheckpoint(kclConfig).WithDynamoDB(svc)
+	assert.Nil(t, checkpoint.Init())
+
+	shardStatus := map[string]*par.ShardStatus{}
+	for _, shardID := range []string{"0-0", "0-1", "1-0", "1-1"} {
+		shardStatus[shardID] = &par.ShardStatus{ID: shardID, Mux: &sync.RWMutex{}}
+	}
+
+	workers, err := checkpoint.ListActiveWorkers(shardStatus)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(workers))
+	for shardID, shard := range shardStatus {
+		assert.Equal(t, "worker_"+shardID, shard.GetLeaseOwner())
+		assert.Equal(t, "checkpoint_"+shardID, shard.GetCheckpoint())
+	}
+	assert.ElementsMatch(t, []string{"0/2:0", "0/2:1", "1/2:0", "1/2:1"}, scans)
+
+	// a failed segment fails the sync
+	checkpoint.lastLeaseSync = time.Time{}
+	svc.scan = func(params *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+		if aws.ToInt32(params.Segment) == 1 {
+			return nil, errors.New("scan failed")
+		}
+		return &dynamodb.ScanOutput{}, nil
+	}
+	_, err = checkpoint.ListActiveWorkers(shardStatus)
+	assert.EqualError(t, err, "scan failed")
+}
+
 func TestClaimShard(t *testing.T) {
 	svc := &mockDynamoDB{tableExist: true, item: map[string]types.AttributeValue{}}
 	kclConfig := cfg.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
@@ -484,4 +618,556 @@ func TestClaimShard(t *testing.T) {
 	assert.Equal(t, shard.AssignedTo, status.AssignedTo)
 	assert.Equal(t, shard.Checkpoint, status.Checkpoint)
 	assert.Equal(t, shard.ParentShardId, status.ParentShardId)
+
+	// the claim is visible to the lease owner through the fetched checkpoint
+	assert.Equal(t, "ijkl-mnop", status.GetClaimRequest())
+}
+
+func TestCheckpointSequenceWithPendingCheckpoint(t *testing.T) {
+	svc := &mockDynamoDB{tableExist: true, item: map[string]types.AttributeValue{
+		LeaseOwnerKey: &types.AttributeValueMemberS{Value: "abcd-efgh"},
+	}}
+	kclConfig := cfg.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithInitialPositionInStream(cfg.LATEST).
+		WithFailoverTimeMillis(300000)
+
+	checkpoint := NewDynamoCheckpoint(kclConfig).WithDynamoDB(svc)
+	_ = checkpoint.Init()
+
+	shard := &par.ShardStatus{
+		ID:                "0001",
+		Checkpoint:        "deadbeef",
+		PendingCheckpoint: "deadcafe",
+		AssignedTo:        "abcd-efgh",
+		Mux:               &sync.RWMutex{},
+	}
+	err := checkpoint.CheckpointSequence(shard)
+	assert.Nil(t, err)
+
+	status := &par.ShardStatus{
+		ID:  shard.ID,
+		Mux: &sync.RWMutex{},
+	}
+	err = checkpoint.FetchCheckpoint(status)
+	assert.Nil(t, err)
+	assert.Equal(t, "deadbeef", status.GetCheckpoint())
+	assert.Equal(t, "deadcafe", status.GetPendingCheckpoint())
+
+	// committing the pending checkpoint clears it
+	shard.SetCheckpoint("deadcafe")
+	shard.SetPendingCheckpoint("")
+	err = checkpoint.CheckpointSequence(shard)
+	assert.Nil(t, err)
+
+	err = checkpoint.FetchCheckpoint(status)
+	assert.Nil(t, err)
+	assert.Equal(t, "deadcafe", status.GetCheckpoint())
+	assert.Equal(t, "", status.GetPendingCheckpoint())
+}
+
+func TestCheckpointSequenceWithSubSequenceNumber(t *testing.T) {
+	svc := &mockDynamoDB{tableExist: true, item: map[string]types.AttributeValue{
+		LeaseOwnerKey: &types.AttributeValueMemberS{Value: "abcd-efgh"},
+	}}
+	kclConfig := cfg.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithInitialPositionInStream(cfg.LATEST).
+		WithFailoverTimeMillis(300000)
+
+	checkpoint := NewDynamoCheckpoint(kclConfig).WithDynamoDB(svc)
+	_ = checkpoint.Init()
+
+	shard := &par.ShardStatus{
+		ID:                "0001",
+		Checkpoint:        "deadbeef",
+		SubSequenceNumber: aws.Int64(3),
+		AssignedTo:        "abcd-efgh",
+		Mux:               &sync.RWMutex{},
+	}
+	err := checkpoint.CheckpointSequence(shard)
+	assert.Nil(t, err)
+	assert.Equal(t, "3", svc.item[SubSequenceNumberKey].(*types.AttributeValueMemberN).Value)
+
+	status := &par.ShardStatus{
+		ID:  shard.ID,
+		Mux: &sync.RWMutex{},
+	}
+	err = checkpoint.FetchCheckpoint(status)
+	assert.Nil(t, err)
+	assert.Equal(t, "deadbeef", status.GetCheckpoint())
+	assert.Equal(t, int64(3), *status.GetSubSequenceNumber())
+
+	// a regular checkpoint covers the whole record
+	shard.SetSubSequenceNumber(nil)
+	err = checkpoint.CheckpointSequence(shard)
+	assert.Nil(t, err)
+
+	err = checkpoint.FetchCheckpoint(status)
+	assert.Nil(t, err)
+	assert.Nil(t, status.GetSubSequenceNumber())
+}
+
+func TestCheckpointSequenceCompressesAttributes(t *testing.T) {
+	svc := &mockDynamoDB{tableExist: true, item: map[string]types.AttributeValue{
+		LeaseOwnerKey: &types.AttributeValueMemberS{Value: "abcd-efgh"},
+	}}
+	kclConfig := cfg.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithInitialPositionInStream(cfg.LATEST).
+		WithFailoverTimeMillis(300000).
+		WithLeaseAttributeCompressionThreshold(1024)
+
+	checkpoint := NewDynamoCheckpoint(kclConfig).WithDynamoDB(svc)
+	_ = checkpoint.Init()
+
+	metadata := bytes.Repeat([]byte("metadata"), 1024)
+	shard := &par.ShardStatus{
+		ID:                 "0001",
+		Checkpoint:         "deadbeef",
+		PendingCheckpoint:  "deadcafe",
+		CheckpointMetadata: metadata,
+		AssignedTo:         "abcd-efgh",
+		Mux:                &sync.RWMutex{},
+	}
+	err := checkpoint.CheckpointSequence(shard)
+	assert.Nil(t, err)
+	assert.Equal(t, "deadcafe", svc.item[PendingCheckpointKey].(*types.AttributeValueMemberS).Value)
+	compressed := svc.item[CheckpointMetadataKey].(*types.AttributeValueMemberS).Value
+	assert.True(t, strings.HasPrefix(compressed, compressedValuePrefix))
+	assert.Less(t, len(compressed), len(metadata))
+
+	status := &par.ShardStatus{
+		ID:  shard.ID,
+		Mux: &sync.RWMutex{},
+	}
+	err = checkpoint.FetchCheckpoint(status)
+	assert.Nil(t, err)
+	assert.Equal(t, "deadcafe", status.GetPendingCheckpoint())
+	assert.Equal(t, metadata, status.GetCheckpointMetadata())
+
+	// small metadata is stored as is
+	shard.SetCheckpointMetadata([]byte("metadata"))
+	err = checkpoint.CheckpointSequence(shard)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("metadata"), svc.item[CheckpointMetadataKey].(*types.AttributeValueMemberB).Value)
+
+	// the lease is not written beyond the item size limit of DynamoDB
+	random := make([]byte, MaxItemSize)
+	_, _ = rand.Read(random)
+	shard.SetCheckpointMetadata(random)
+	err = checkpoint.CheckpointSequence(shard)
+	var tooLarge *ItemTooLargeError
+	assert.ErrorAs(t, err, &tooLarge)
+	assert.ErrorIs(t, err, ErrItemTooLarge)
+	assert.Equal(t, "0001", tooLarge.ShardID)
+	assert.Equal(t, []byte("metadata"), svc.item[CheckpointMetadataKey].(*types.AttributeValueMemberB).Value)
+
+	// corrupted attributes are reported
+	svc.item[CheckpointMetadataKey] = &types.AttributeValueMemberS{Value: compressedValuePrefix + "!"}
+	assert.NotNil(t, checkpoint.FetchCheckpoint(status))
+}
+
+func TestLeaseSchemaMigration(t *testing.T) {
+	// a lease written before the schema has been versioned
+	svc := &mockDynamoDB{tableExist: true, item: map[string]types.AttributeValue{
+		LeaseKeyKey:          &types.AttributeValueMemberS{Value: "0001"},
+		SequenceNumberKey:    &types.AttributeValueMemberS{Value: "deadbeef"},
+		SubSequenceNumberKey: &types.AttributeValueMemberN{Value: "3"},
+		PendingCheckpointKey: &types.AttributeValueMemberS{Value: "deadcafe"},
+	}}
+	kclConfig := cfg.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithInitialPositionInStream(cfg.LATEST).
+		WithFailoverTimeMillis(300000)
+
+	checkpoint := NewDynamoCheckpoint(kclConfig).WithDynamoDB(svc)
+	_ = checkpoint.Init()
+
+	shard := &par.ShardStatus{
+		ID:  "0001",
+		Mux: &sync.RWMutex{},
+	}
+	err := checkpoint.FetchCheckpoint(shard)
+	assert.Nil(t, err)
+	assert.Equal(t, "deadbeef", shard.GetCheckpoint())
+	assert.Equal(t, int64(3), *shard.GetSubSequenceNumber())
+	assert.Equal(t, "deadcafe", shard.GetPendingCheckpoint())
+
+	// the lease is migrated by its first write
+	err = checkpoint.GetLease(shard, "abcd-efgh")
+	assert.Nil(t, err)
+	assert.Equal(t, fmt.Sprint(CurrentLeaseSchemaVersion), svc.item[LeaseSchemaVersionKey].(*types.AttributeValueMemberN).Value)
+	assert.Equal(t, "3", svc.item[SubSequenceNumberKey].(*types.AttributeValueMemberN).Value)
+	assert.Equal(t, "deadcafe", svc.item[PendingCheckpointKey].(*types.AttributeValueMemberS).Value)
+
+	// a lease of a later schema is neither read nor taken
+	svc.item[LeaseSchemaVersionKey] = &types.AttributeValueMemberN{Value: fmt.Sprint(CurrentLeaseSchemaVersion + 1)}
+	var schemaErr *LeaseSchemaError
+	err = checkpoint.FetchCheckpoint(shard)
+	assert.ErrorAs(t, err, &schemaErr)
+	assert.ErrorIs(t, err, ErrUnsupportedLeaseSchema)
+	assert.Equal(t, CurrentLeaseSchemaVersion+1, schemaErr.Version)
+	err = checkpoint.GetLease(shard, "abcd-efgh")
+	assert.ErrorIs(t, err, ErrUnsupportedLeaseSchema)
+}
+
+const heartbeatCounterKey = "HeartbeatCounter"
+
+// heartbeatSerializer adds a heartbeat counter to the leases of the default schema
+type heartbeatSerializer struct {
+	LeaseSerializer
+	heartbeats int
+	versions   []int
+}
+
+func (s *heartbeatSerializer) SchemaVersion() int {
+	return CurrentLeaseSchemaVersion + 1
+}
+
+func (s *heartbeatSerializer) MarshalCheckpoint(shard *par.ShardStatus, item map[string]types.AttributeValue) error {
+	s.heartbeats++
+	item[heartbeatCounterKey] = &types.AttributeValueMemberN{Value: fmt.Sprint(s.heartbeats)}
+	return s.LeaseSerializer.MarshalCheckpoint(shard, item)
+}
+
+func (s *heartbeatSerializer) UnmarshalCheckpoint(item map[string]types.AttributeValue, version int, shard *par.ShardStatus) error {
+	s.versions = append(s.versions, version)
+	return s.LeaseSerializer.UnmarshalCheckpoint(item, version, shard)
+}
+
+func TestWithLeaseSerializer(t *testing.T) {
+	svc := &mockDynamoDB{tableExist: true, item: map[string]types.AttributeValue{}}
+	kclConfig := cfg.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithInitialPositionInStream(cfg.LATEST).
+		WithFailoverTimeMillis(300000)
+
+	serializer := &heartbeatSerializer{LeaseSerializer: NewLeaseSerializer(0)}
+	checkpoint := NewDynamoCheckpoint(kclConfig).WithDynamoDB(svc).WithLeaseSerializer(serializer)
+	_ = checkpoint.Init()
+
+	shard := &par.ShardStatus{
+		ID:         "0001",
+		Checkpoint: "deadbeef",
+		Mux:        &sync.RWMutex{},
+	}
+	err := checkpoint.GetLease(shard, "abcd-efgh")
+	assert.Nil(t, err)
+	err = checkpoint.CheckpointSequence(shard)
+	assert.Nil(t, err)
+	assert.Equal(t, "2", svc.item[heartbeatCounterKey].(*types.AttributeValueMemberN).Value)
+	assert.Equal(t, fmt.Sprint(CurrentLeaseSchemaVersion+1), svc.item[LeaseSchemaVersionKey].(*types.AttributeValueMemberN).Value)
+
+	err = checkpoint.FetchCheckpoint(shard)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{CurrentLeaseSchemaVersion + 1}, serializer.versions)
+	assert.Equal(t, "deadbeef", shard.GetCheckpoint())
+}
+
+func TestCheckpointSequenceKeepsClaimRequest(t *testing.T) {
+	svc := &mockDynamoDB{tableExist: true, item: map[string]types.AttributeValue{
+		LeaseKeyKey:     &types.AttributeValueMemberS{Value: "0001"},
+		LeaseOwnerKey:   &types.AttributeValueMemberS{Value: "abcd-efgh"},
+		ClaimRequestKey: &types.AttributeValueMemberS{Value: "ijkl-mnop"},
+	}}
+	kclConfig := cfg.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithInitialPositionInStream(cfg.LATEST).
+		WithFailoverTimeMillis(300000).
+		WithLeaseStealing(true)
+
+	checkpoint := NewDynamoCheckpoint(kclConfig).WithDynamoDB(svc)
+	_ = checkpoint.Init()
+
+	// the lease owner does not know about the claim request yet
+	shard := &par.ShardStatus{
+		ID:         "0001",
+		Checkpoint: "deadbeef",
+		AssignedTo: "abcd-efgh",
+		Mux:        &sync.RWMutex{},
+	}
+	err := checkpoint.CheckpointSequence(shard)
+	assert.Nil(t, err)
+	assert.Equal(t, "ijkl-mnop", shard.GetClaimRequest())
+	assert.Equal(t, "deadbeef", svc.item[SequenceNumberKey].(*types.AttributeValueMemberS).Value)
+	assert.Equal(t, "ijkl-mnop", svc.item[ClaimRequestKey].(*types.AttributeValueMemberS).Value)
+	assert.Equal(t, "AssignedTo = :assigned_to AND ClaimRequest = :claim_request", svc.conditionalExpression)
+
+	// the checkpoint written while handing off the shard keeps the claim request
+	shard.SetCheckpoint("deadcafe")
+	err = checkpoint.CheckpointSequence(shard)
+	assert.Nil(t, err)
+	assert.Equal(t, "deadcafe", svc.item[SequenceNumberKey].(*types.AttributeValueMemberS).Value)
+	assert.Equal(t, "ijkl-mnop", svc.item[ClaimRequestKey].(*types.AttributeValueMemberS).Value)
+}
+
+func TestCheckpointSequenceFencing(t *testing.T) {
+	svc := &mockDynamoDB{tableExist: true, item: map[string]types.AttributeValue{}}
+	kclConfig := cfg.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithInitialPositionInStream(cfg.LATEST).
+		WithFailoverTimeMillis(300000)
+
+	checkpoint := NewDynamoCheckpoint(kclConfig).WithDynamoDB(svc)
+	_ = checkpoint.Init()
+
+	zombie := &par.ShardStatus{ID: "0001", Checkpoint: "deadbeef", Mux: &sync.RWMutex{}}
+	assert.Nil(t, checkpoint.GetLease(zombie, "abcd-efgh"))
+	assert.Equal(t, int64(1), zombie.GetLeaseCounter())
+	assert.Nil(t, checkpoint.CheckpointSequence(zombie))
+	assert.Equal(t, "1", svc.item[LeaseCounterKey].(*types.AttributeValueMemberN).Value)
+
+	// another instance of the worker takes over the expired lease
+	svc.item[LeaseTimeoutKey] = &types.AttributeValueMemberS{Value: time.Now().Add(-time.Minute).UTC().Format(time.RFC3339Nano)}
+	shard := &par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}}
+	assert.Nil(t, checkpoint.FetchCheckpoint(shard))
+	assert.Nil(t, checkpoint.GetLease(shard, "abcd-efgh"))
+	assert.Equal(t, int64(2), shard.GetLeaseCounter())
+
+	zombie.SetCheckpoint("deadcafe")
+	err := checkpoint.CheckpointSequence(zombie)
+	var fencingErr *FencingError
+	assert.True(t, errors.As(err, &fencingErr))
+	assert.Equal(t, &FencingError{
+		ShardID:             "0001",
+		LeaseOwner:          "abcd-efgh",
+		LeaseCounter:        1,
+		CurrentLeaseOwner:   "abcd-efgh",
+		CurrentLeaseCounter: 2,
+		err:                 fencingErr.err,
+	}, fencingErr)
+	assert.True(t, IsConditionalCheckFailed(err))
+	assert.Equal(t, "deadbeef", svc.item[SequenceNumberKey].(*types.AttributeValueMemberS).Value)
+
+	// the new lease owner keeps checkpointing
+	shard.SetCheckpoint("deadcafe")
+	assert.Nil(t, checkpoint.CheckpointSequence(shard))
+	assert.Equal(t, "deadcafe", svc.item[SequenceNumberKey].(*types.AttributeValueMemberS).Value)
+}
+
+func TestLeaseAdmin(t *testing.T) {
+	svc := &mockDynamoDB{tableExist: true, item: map[string]types.AttributeValue{
+		LeaseKeyKey:       &types.AttributeValueMemberS{Value: "0001"},
+		LeaseOwnerKey:     &types.AttributeValueMemberS{Value: "abcd-efgh"},
+		SequenceNumberKey: &types.AttributeValueMemberS{Value: "deadbeef"},
+	}}
+	svc.scan = func(params *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+		return &dynamodb.ScanOutput{Items: []map[string]types.AttributeValue{svc.item}}, nil
+	}
+	kclConfig := cfg.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc")
+
+	checkpoint := NewDynamoCheckpoint(kclConfig).WithDynamoDB(svc)
+	_ = checkpoint.Init()
+
+	leases, err := checkpoint.ListLeases()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(leases))
+	assert.Equal(t, "0001", leases[0].ID)
+	assert.Equal(t, "abcd-efgh", leases[0].GetLeaseOwner())
+	assert.Equal(t, "deadbeef", leases[0].GetCheckpoint())
+
+	// the lease is released although it is held by another worker
+	assert.Nil(t, checkpoint.ReleaseLease("0001"))
+	_, ok := svc.item[LeaseOwnerKey]
+	assert.False(t, ok)
+
+	assert.Nil(t, checkpoint.DeleteLeaseTable())
+	assert.False(t, svc.tableExist)
+
+	// the lease table is opened without being created
+	assert.Equal(t, ErrLeaseTableNotFound, checkpoint.Open())
+	assert.False(t, svc.tableExist)
+}
+
+func TestLeaseTableReadConsistencyAndCapacity(t *testing.T) {
+	var consistentReads []string
+	svc := &mockDynamoDB{tableExist: true, item: map[string]types.AttributeValue{}}
+	svc.scan = func(params *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+		consistentReads = append(consistentReads, fmt.Sprintf("Scan:%t", aws.ToBool(params.ConsistentRead)))
+		assert.Equal(t, types.ReturnConsumedCapacityTotal, params.ReturnConsumedCapacity)
+		return &dynamodb.ScanOutput{
+			Items: []map[string]types.AttributeValue{{
+				LeaseKeyKey:       &types.AttributeValueMemberS{Value: "0001"},
+				LeaseOwnerKey:     &types.AttributeValueMemberS{Value: "abc"},
+				SequenceNumberKey: &types.AttributeValueMemberS{Value: "1"},
+			}},
+			ConsumedCapacity: &types.ConsumedCapacity{CapacityUnits: aws.Float64(2)},
+		}, nil
+	}
+	svc.getItem = func(params *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+		consistentReads = append(consistentReads, fmt.Sprintf("GetItem:%t", aws.ToBool(params.ConsistentRead)))
+		return &dynamodb.GetItemOutput{Item: svc.item, ConsumedCapacity: &types.ConsumedCapacity{CapacityUnits: aws.Float64(0.5)}}, nil
+	}
+
+	var capacities []cfg.LeaseTableCapacity
+	kclConfig := cfg.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithLeaseStealing(true).
+		WithLeaseTableCapacityHandler(func(capacity *cfg.LeaseTableCapacity) {
+			capacities = append(capacities, *capacity)
+		})
+
+	checkpoint := NewDynamoCheckpoint(kclConfig).WithDynamoDB(svc)
+	assert.Nil(t, checkpoint.Init())
+
+	shard := &par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}}
+	_, err := checkpoint.ListActiveWorkers(map[string]*par.ShardStatus{"0001": shard})
+	assert.Nil(t, err)
+	_, err = checkpoint.getItem("0001")
+	assert.Nil(t, err)
+
+	// the scans are eventually and the gets strongly consistent by default
+	assert.Equal(t, []string{"Scan:false", "GetItem:true"}, consistentReads)
+	assert.Equal(t, []cfg.LeaseTableCapacity{
+		{Operation: "Scan", ReadCapacityUnits: 2},
+		{Operation: "GetItem", ReadCapacityUnits: 0.5},
+	}, capacities)
+
+	consistentReads = nil
+	kclConfig.WithLeaseTableReadConsistency(cfg.StronglyConsistent, cfg.EventuallyConsistent)
+	checkpoint.lastLeaseSync = time.Time{}
+	_, err = checkpoint.ListActiveWorkers(map[string]*par.ShardStatus{"0001": shard})
+	assert.Nil(t, err)
+	_, err = checkpoint.getItem("0001")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Scan:true", "GetItem:false"}, consistentReads)
+}
+
+func TestGetLeaseToleratesClockSkew(t *testing.T) {
+	// the lease timeout was written by an owner whose clock is an hour behind
+	svc := &mockDynamoDB{
+		tableExist: true,
+		item: map[string]types.AttributeValue{
+			LeaseKeyKey:     &types.AttributeValueMemberS{Value: "0001"},
+			LeaseOwnerKey:   &types.AttributeValueMemberS{Value: "abcd-efgh"},
+			LeaseTimeoutKey: &types.AttributeValueMemberS{Value: time.Now().Add(-time.Hour).UTC().Format(time.RFC3339Nano)},
+			LeaseCounterKey: &types.AttributeValueMemberN{Value: "5"},
+		},
+	}
+	kclConfig := cfg.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithFailoverTimeMillis(300000)
+
+	checkpoint := NewDynamoCheckpoint(kclConfig).WithDynamoDB(svc)
+	assert.Nil(t, checkpoint.Init())
+	shard := &par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}}
+
+	// the lease has just been observed
+	err := checkpoint.GetLease(shard, "ijkl-mnop")
+	assert.True(t, errors.As(err, &ErrLeaseNotAcquired{}))
+
+	// a renewal of the lease restarts the failover time
+	checkpoint.leases.leases["0001"] = observedLease{owner: "abcd-efgh", counter: 5, changed: time.Now().Add(-time.Hour)}
+	svc.item[LeaseCounterKey] = &types.AttributeValueMemberN{Value: "6"}
+	err = checkpoint.GetLease(shard, "ijkl-mnop")
+	assert.True(t, errors.As(err, &ErrLeaseNotAcquired{}))
+
+	// the lease has expired once its counter has not changed for the failover time, whatever the lease timeout
+	svc.item[LeaseTimeoutKey] = &types.AttributeValueMemberS{Value: time.Now().Add(time.Hour).UTC().Format(time.RFC3339Nano)}
+	checkpoint.leases.leases["0001"] = observedLease{owner: "abcd-efgh", counter: 6, changed: time.Now().Add(-5 * time.Minute)}
+	assert.Nil(t, checkpoint.GetLease(shard, "ijkl-mnop"))
+	assert.Equal(t, "ijkl-mnop", shard.GetLeaseOwner())
+	assert.Equal(t, int64(7), shard.GetLeaseCounter())
+}
+
+func TestIsLeaseExpired(t *testing.T) {
+	// the lease timeout was written by an owner whose clock is an hour ahead
+	svc := &mockDynamoDB{
+		tableExist: true,
+		item: map[string]types.AttributeValue{
+			LeaseKeyKey:       &types.AttributeValueMemberS{Value: "0001"},
+			LeaseOwnerKey:     &types.AttributeValueMemberS{Value: "abcd-efgh"},
+			LeaseTimeoutKey:   &types.AttributeValueMemberS{Value: time.Now().Add(time.Hour).UTC().Format(time.RFC3339Nano)},
+			LeaseCounterKey:   &types.AttributeValueMemberN{Value: "5"},
+			SequenceNumberKey: &types.AttributeValueMemberS{Value: "1"},
+		},
+	}
+	kclConfig := cfg.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithFailoverTimeMillis(300000)
+	checkpoint := NewDynamoCheckpoint(kclConfig).WithDynamoDB(svc)
+	assert.Nil(t, checkpoint.Init())
+
+	// the lease timeout tells the expiry until the lease counter has been observed
+	shard := &par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}, AssignedTo: "abcd-efgh", LeaseTimeout: time.Now().Add(-time.Second)}
+	assert.True(t, checkpoint.IsLeaseExpired(shard, time.Now()))
+
+	// the fetched lease counter has just been observed to change
+	assert.Nil(t, checkpoint.FetchCheckpoint(shard))
+	assert.False(t, checkpoint.IsLeaseExpired(shard, time.Now()))
+	assert.True(t, checkpoint.IsLeaseExpired(shard, time.Now().Add(5*time.Minute)))
+
+	// the lease expires once its counter has not changed for the failover time, whatever the lease timeout
+	checkpoint.leases.leases["0001"] = observedLease{owner: "abcd-efgh", counter: 5, changed: time.Now().Add(-5 * time.Minute)}
+	assert.True(t, checkpoint.IsLeaseExpired(shard, time.Now()))
+}
+
+func TestSyncLeasesObservesLeaseCounters(t *testing.T) {
+	svc := &mockDynamoDB{tableExist: true, item: map[string]types.AttributeValue{}}
+	svc.scan = func(params *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+		assert.Contains(t, aws.ToString(params.ProjectionExpression), LeaseCounterKey)
+		return &dynamodb.ScanOutput{Items: []map[string]types.AttributeValue{{
+			LeaseKeyKey:       &types.AttributeValueMemberS{Value: "0001"},
+			LeaseOwnerKey:     &types.AttributeValueMemberS{Value: "abcd-efgh"},
+			SequenceNumberKey: &types.AttributeValueMemberS{Value: "1"},
+			LeaseCounterKey:   &types.AttributeValueMemberN{Value: "3"},
+		}}}, nil
+	}
+	kclConfig := cfg.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc")
+	checkpoint := NewDynamoCheckpoint(kclConfig).WithDynamoDB(svc)
+	assert.Nil(t, checkpoint.Init())
+
+	shard := &par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}}
+	_, err := checkpoint.ListActiveWorkers(map[string]*par.ShardStatus{"0001": shard})
+	assert.Nil(t, err)
+	assert.Equal(t, "abcd-efgh", checkpoint.leases.leases["0001"].owner)
+	assert.Equal(t, int64(3), checkpoint.leases.leases["0001"].counter)
+
+	// the observed lease of a removed shard is forgotten
+	assert.Nil(t, checkpoint.RemoveLeaseInfo("0001"))
+	assert.NotContains(t, checkpoint.leases.leases, "0001")
+}
+
+func TestSharedLeaseTable(t *testing.T) {
+	items := map[string]map[string]types.AttributeValue{
+		"appName#0001": {
+			LeaseKeyKey:       &types.AttributeValueMemberS{Value: "appName#0001"},
+			LeaseOwnerKey:     &types.AttributeValueMemberS{Value: "abcd-efgh"},
+			SequenceNumberKey: &types.AttributeValueMemberS{Value: "1"},
+		},
+		"otherApp#0001": {
+			LeaseKeyKey:       &types.AttributeValueMemberS{Value: "otherApp#0001"},
+			LeaseOwnerKey:     &types.AttributeValueMemberS{Value: "other"},
+			SequenceNumberKey: &types.AttributeValueMemberS{Value: "2"},
+		},
+	}
+	svc := &mockDynamoDB{tableExist: true, item: map[string]types.AttributeValue{}}
+	svc.scan = func(params *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+		assert.Equal(t, "leases", aws.ToString(params.TableName))
+		assert.Equal(t, "begins_with(ShardID, :prefix)", aws.ToString(params.FilterExpression))
+		assert.Equal(t, &types.AttributeValueMemberS{Value: "appName#"}, params.ExpressionAttributeValues[":prefix"])
+		// the leases of the other application are still skipped if they are returned
+		return &dynamodb.ScanOutput{Items: []map[string]types.AttributeValue{items["appName#0001"], items["otherApp#0001"]}}, nil
+	}
+	svc.getItem = func(params *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+		key := params.Key[LeaseKeyKey].(*types.AttributeValueMemberS).Value
+		return &dynamodb.GetItemOutput{Item: items[key]}, nil
+	}
+	kclConfig := cfg.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithSharedLeaseTable("leases")
+	assert.Equal(t, "appName#", kclConfig.LeaseKeyPrefix)
+	checkpoint := NewDynamoCheckpoint(kclConfig).WithDynamoDB(svc)
+	assert.Nil(t, checkpoint.Init())
+
+	// the leases of the other application are ignored
+	leases, err := checkpoint.ListLeases()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(leases))
+	assert.Equal(t, "0001", leases[0].ID)
+	assert.Equal(t, "abcd-efgh", leases[0].GetLeaseOwner())
+
+	shard := &par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}}
+	workers, err := checkpoint.ListActiveWorkers(map[string]*par.ShardStatus{"0001": shard})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(workers["abcd-efgh"]))
+	assert.Equal(t, "1", shard.GetCheckpoint())
+
+	// the lease of a new shard is stored with the prefix
+	assert.Nil(t, checkpoint.GetLease(&par.ShardStatus{ID: "0002", Mux: &sync.RWMutex{}}, "abc"))
+	assert.Equal(t, "appName#0002", svc.item[LeaseKeyKey].(*types.AttributeValueMemberS).Value)
+
+	// the shared lease table is kept
+	assert.Nil(t, checkpoint.DeleteLeaseTable())
+	assert.True(t, svc.tableExist)
 }
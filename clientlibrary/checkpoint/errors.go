@@ -0,0 +1,229 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package checkpoint
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+)
+
+var (
+	// ErrSequenceIDNotFound is returned by FetchCheckpoint when no SequenceID is found
+	ErrSequenceIDNotFound = errors.New("SequenceIDNotFoundForShard")
+
+	// ErrShardNotAssigned is returned by ListActiveWorkers when no AssignedTo is found
+	ErrShardNotAssigned = errors.New("AssignedToNotFoundForShard")
+
+	// ErrConditionalCheckFailed is returned when a lease has been modified by another worker in the meantime
+	ErrConditionalCheckFailed = errors.New("lease has been modified by another worker")
+
+	// ErrShardAlreadyClaimed is returned by GetLease when the shard has been claimed by another worker
+	ErrShardAlreadyClaimed = errors.New(ErrShardClaimed)
+
+	// ErrLeaseLost is matched by errors.Is for every LeaseLostError and FencingError
+	ErrLeaseLost = errors.New("the lease of the shard has been lost")
+
+	// ErrThrottled is matched by errors.Is for every ThrottlingError
+	ErrThrottled = errors.New("the request has been throttled")
+
+	// ErrShardClosed is matched by errors.Is for every ShardClosedError
+	ErrShardClosed = errors.New("the shard has been closed")
+
+	// ErrItemTooLarge is matched by errors.Is for every ItemTooLargeError
+	ErrItemTooLarge = errors.New("the lease exceeds the DynamoDB item size limit")
+
+	// ErrUnsupportedLeaseSchema is matched by errors.Is for every LeaseSchemaError
+	ErrUnsupportedLeaseSchema = errors.New("the lease has been written with an unsupported schema version")
+)
+
+type ErrLeaseNotAcquired struct {
+	cause string
+	// err is the failed conditional write if another worker modified the lease in the meantime
+	err error
+}
+
+// NewLeaseNotAcquiredError returns an ErrLeaseNotAcquired with the cause, err is an optional error it wraps, e.g.
+// ErrShardAlreadyClaimed if the lease is handed off to another worker
+func NewLeaseNotAcquiredError(cause string, err error) ErrLeaseNotAcquired {
+	return ErrLeaseNotAcquired{cause: cause, err: err}
+}
+
+func (e ErrLeaseNotAcquired) Error() string {
+	return fmt.Sprintf("lease not acquired: %s", e.cause)
+}
+
+func (e ErrLeaseNotAcquired) Unwrap() error {
+	return e.err
+}
+
+// LeaseLostError is returned when the worker does not hold the lease of a shard anymore, because it expired or
+// another worker took it over. The record processor must stop processing the shard, the records are processed by
+// the new lease owner.
+type LeaseLostError struct {
+	ShardID string
+
+	err error
+}
+
+// NewLeaseLostError returns a LeaseLostError of the shard caused by err, which may be nil
+func NewLeaseLostError(shardID string, err error) *LeaseLostError {
+	return &LeaseLostError{ShardID: shardID, err: err}
+}
+
+func (e *LeaseLostError) Error() string {
+	if e.err == nil {
+		return fmt.Sprintf("lease of shard %s has been lost", e.ShardID)
+	}
+	return fmt.Sprintf("lease of shard %s has been lost: %v", e.ShardID, e.err)
+}
+
+func (e *LeaseLostError) Unwrap() error {
+	return e.err
+}
+
+func (e *LeaseLostError) Is(target error) bool {
+	return target == ErrLeaseLost
+}
+
+// FencingError is returned when a checkpoint is rejected because the lease has been taken by another worker, or
+// by another instance of the same worker, since the lease owner acquired or renewed it. The record processor
+// must stop processing the shard, the records are processed by the new lease owner.
+type FencingError struct {
+	ShardID string
+	// LeaseOwner and LeaseCounter are the lease held by the checkpointing worker
+	LeaseOwner   string
+	LeaseCounter int64
+	// CurrentLeaseOwner and CurrentLeaseCounter are the lease stored in the lease table
+	CurrentLeaseOwner   string
+	CurrentLeaseCounter int64
+
+	err error
+}
+
+func (e *FencingError) Error() string {
+	return fmt.Sprintf("checkpoint of shard %s fenced: lease %s/%d superseded by %s/%d",
+		e.ShardID, e.LeaseOwner, e.LeaseCounter, e.CurrentLeaseOwner, e.CurrentLeaseCounter)
+}
+
+func (e *FencingError) Unwrap() error {
+	return e.err
+}
+
+func (e *FencingError) Is(target error) bool {
+	return target == ErrLeaseLost
+}
+
+// ThrottlingError is returned when a call to Kinesis or DynamoDB has been throttled after the retries of the AWS
+// SDK, it wraps the error of the call. The operation can be retried after backing off.
+type ThrottlingError struct {
+	// Operation is the throttled API call, e.g. GetRecords or PutItem
+	Operation string
+
+	err error
+}
+
+// NewThrottlingError returns a ThrottlingError of the operation caused by err
+func NewThrottlingError(operation string, err error) *ThrottlingError {
+	return &ThrottlingError{Operation: operation, err: err}
+}
+
+// WrapThrottlingError returns a ThrottlingError of the operation if err is caused by throttling, and err otherwise
+func WrapThrottlingError(operation string, err error) error {
+	var throttlingErr *ThrottlingError
+	if err == nil || errors.As(err, &throttlingErr) || !config.IsThrottlingError(err) {
+		return err
+	}
+	return NewThrottlingError(operation, err)
+}
+
+func (e *ThrottlingError) Error() string {
+	return fmt.Sprintf("%s throttled: %v", e.Operation, e.err)
+}
+
+func (e *ThrottlingError) Unwrap() error {
+	return e.err
+}
+
+func (e *ThrottlingError) Is(target error) bool {
+	return target == ErrThrottled
+}
+
+// ShardClosedError is returned when a record processor checkpoints a sequence number of a shard which has been
+// checkpointed at its end already, no more records can be read from a closed shard.
+type ShardClosedError struct {
+	ShardID string
+}
+
+func (e *ShardClosedError) Error() string {
+	return fmt.Sprintf("shard %s has been closed", e.ShardID)
+}
+
+func (e *ShardClosedError) Is(target error) bool {
+	return target == ErrShardClosed
+}
+
+// ItemTooLargeError is returned when the lease of a shard is not written because it exceeds the 400 KB item size
+// limit of DynamoDB, mostly because of the metadata stored with the checkpoint. The size can be reduced by storing
+// less metadata or by compressing it with LeaseAttributeCompressionThreshold.
+type ItemTooLargeError struct {
+	ShardID string
+	// Size is the size of the item in bytes, as counted by DynamoDB
+	Size int
+}
+
+func (e *ItemTooLargeError) Error() string {
+	return fmt.Sprintf("lease of shard %s is %d bytes, exceeding the DynamoDB item size limit of %d bytes",
+		e.ShardID, e.Size, MaxItemSize)
+}
+
+func (e *ItemTooLargeError) Is(target error) bool {
+	return target == ErrItemTooLarge
+}
+
+// LeaseSchemaError is returned when the lease of a shard has been written with a later schema version than the
+// LeaseSerializer of the checkpointer, e.g. by a worker of a newer release. The lease is not taken, so that the
+// attributes of the later schema are not dropped.
+type LeaseSchemaError struct {
+	ShardID string
+	// Version is the schema version of the lease
+	Version int
+	// SupportedVersion is the schema version of the serializer
+	SupportedVersion int
+}
+
+func (e *LeaseSchemaError) Error() string {
+	return fmt.Sprintf("lease of shard %s has schema version %d, the latest supported version is %d",
+		e.ShardID, e.Version, e.SupportedVersion)
+}
+
+func (e *LeaseSchemaError) Is(target error) bool {
+	return target == ErrUnsupportedLeaseSchema
+}
+
+// IsConditionalCheckFailed reports whether err is caused by a conditional write to the lease table which has been
+// rejected because another worker modified the lease in the meantime
+func IsConditionalCheckFailed(err error) bool {
+	var conditionalCheckErr *types.ConditionalCheckFailedException
+	return errors.Is(err, ErrConditionalCheckFailed) || errors.As(err, &conditionalCheckErr)
+}
@@ -0,0 +1,76 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package checkpoint
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeaseLostError(t *testing.T) {
+	cause := errors.New("lease expired")
+	err := error(NewLeaseLostError("0001", cause))
+	assert.Equal(t, "lease of shard 0001 has been lost: lease expired", err.Error())
+	assert.ErrorIs(t, err, ErrLeaseLost)
+	assert.ErrorIs(t, err, cause)
+
+	var leaseLostErr *LeaseLostError
+	assert.True(t, errors.As(err, &leaseLostErr))
+	assert.Equal(t, "0001", leaseLostErr.ShardID)
+	assert.Equal(t, "lease of shard 0001 has been lost", NewLeaseLostError("0001", nil).Error())
+
+	// a fenced checkpoint has lost the lease as well
+	fencingErr := &FencingError{ShardID: "0001", err: &types.ConditionalCheckFailedException{}}
+	assert.ErrorIs(t, fencingErr, ErrLeaseLost)
+	assert.True(t, IsConditionalCheckFailed(fencingErr))
+	assert.False(t, errors.Is(ErrLeaseNotAcquired{cause: "busy"}, ErrLeaseLost))
+}
+
+func TestWrapThrottlingError(t *testing.T) {
+	throttled := &types.ProvisionedThroughputExceededException{Message: aws.String("slow down")}
+	err := WrapThrottlingError("PutItem", throttled)
+	assert.ErrorIs(t, err, ErrThrottled)
+	assert.Contains(t, err.Error(), "PutItem throttled: ")
+
+	var throttlingErr *ThrottlingError
+	assert.True(t, errors.As(err, &throttlingErr))
+	assert.Equal(t, "PutItem", throttlingErr.Operation)
+	var throughputErr *types.ProvisionedThroughputExceededException
+	assert.True(t, errors.As(err, &throughputErr))
+
+	// throttling errors are wrapped once
+	assert.Equal(t, err, WrapThrottlingError("GetItem", err))
+
+	// other errors are returned as they are
+	conditionalErr := &types.ConditionalCheckFailedException{}
+	assert.Equal(t, error(conditionalErr), WrapThrottlingError("PutItem", conditionalErr))
+	assert.Nil(t, WrapThrottlingError("PutItem", nil))
+}
+
+func TestShardClosedError(t *testing.T) {
+	err := error(&ShardClosedError{ShardID: "0001"})
+	assert.Equal(t, "shard 0001 has been closed", err.Error())
+	assert.ErrorIs(t, err, ErrShardClosed)
+	assert.False(t, errors.Is(err, ErrLeaseLost))
+}
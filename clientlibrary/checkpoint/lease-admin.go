@@ -0,0 +1,76 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package checkpoint
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+var (
+	// ErrLeaseNotFound is returned when a lease does not exist in the lease table
+	ErrLeaseNotFound = errors.New("lease not found")
+
+	// ErrLeaseTableNotFound is returned by Open when the lease table does not exist
+	ErrLeaseTableNotFound = errors.New("lease table not found")
+)
+
+// LeaseAdmin is implemented by the checkpointers whose lease table can be administered, see the admin package.
+// It is kept apart from Checkpointer so that custom checkpointers don't have to implement it.
+type LeaseAdmin interface {
+	// Open initialises the checkpointer like Init, but neither creates nor migrates the lease table
+	Open() error
+
+	// ListLeases returns the leases of all shards in the lease table ordered by shard ID
+	ListLeases() ([]*par.ShardStatus, error)
+
+	// ReleaseLease removes the lease owner of a shard whichever worker holds the lease
+	ReleaseLease(shardID string) error
+
+	// DeleteLeaseTable deletes the lease table with all leases and checkpoints
+	DeleteLeaseTable() error
+}
+
+var (
+	_ LeaseAdmin = (*DynamoCheckpoint)(nil)
+	_ LeaseAdmin = (*RedisCheckpoint)(nil)
+	_ LeaseAdmin = (*SQLCheckpoint)(nil)
+	_ LeaseAdmin = (*MemoryCheckpoint)(nil)
+)
+
+// fetchLeases reads the leases of the given shards with FetchCheckpoint
+func fetchLeases(checkpointer Checkpointer, shardIDs []string) ([]*par.ShardStatus, error) {
+	leases := make([]*par.ShardStatus, 0, len(shardIDs))
+	for _, shardID := range shardIDs {
+		lease := &par.ShardStatus{ID: shardID, Mux: &sync.RWMutex{}}
+		if err := checkpointer.FetchCheckpoint(lease); err != nil && !errors.Is(err, ErrSequenceIDNotFound) {
+			return nil, err
+		}
+		leases = append(leases, lease)
+	}
+
+	sort.Slice(leases, func(i, j int) bool {
+		return leases[i].ID < leases[j].ID
+	})
+	return leases, nil
+}
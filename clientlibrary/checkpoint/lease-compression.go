@@ -0,0 +1,111 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package checkpoint
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	// MaxItemSize is the max size in bytes of an item of a DynamoDB table
+	MaxItemSize = 400 * 1024
+
+	// compressedValuePrefix marks the string attributes which are stored gzip compressed and base64 encoded
+	compressedValuePrefix = "gzip+base64:"
+)
+
+// compressValue returns the value gzip compressed and base64 encoded, with the compressedValuePrefix
+func compressValue(value []byte) (string, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(value); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return compressedValuePrefix + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// decompressValue returns the value compressed by compressValue
+func decompressValue(value string) ([]byte, error) {
+	if !strings.HasPrefix(value, compressedValuePrefix) {
+		return nil, fmt.Errorf("missing prefix %q", compressedValuePrefix)
+	}
+	compressed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, compressedValuePrefix))
+	if err != nil {
+		return nil, err
+	}
+	r, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// checkItemSize returns an ItemTooLargeError if the item exceeds the MaxItemSize, which would fail the write
+func checkItemSize(item map[string]types.AttributeValue) error {
+	size := 0
+	for name, value := range item {
+		size += len(name) + attributeValueSize(value)
+	}
+	if size <= MaxItemSize {
+		return nil
+	}
+	shardID := ""
+	if id, ok := item[LeaseKeyKey].(*types.AttributeValueMemberS); ok {
+		shardID = id.Value
+	}
+	return &ItemTooLargeError{ShardID: shardID, Size: size}
+}
+
+// attributeValueSize returns the size of an attribute value as counted by DynamoDB, numbers are counted by their
+// digits which slightly overestimates them
+func attributeValueSize(value types.AttributeValue) int {
+	switch v := value.(type) {
+	case *types.AttributeValueMemberS:
+		return len(v.Value)
+	case *types.AttributeValueMemberN:
+		return len(v.Value)
+	case *types.AttributeValueMemberB:
+		return len(v.Value)
+	case *types.AttributeValueMemberM:
+		size := 3
+		for name, value := range v.Value {
+			size += len(name) + attributeValueSize(value) + 1
+		}
+		return size
+	case *types.AttributeValueMemberL:
+		size := 3
+		for _, value := range v.Value {
+			size += attributeValueSize(value) + 1
+		}
+		return size
+	}
+	return 1
+}
@@ -0,0 +1,101 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package checkpoint
+package checkpoint
+
+import (
+	"sync"
+	"time"
+
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+type (
+	// LeaseExpiryChecker is implemented by the checkpointers which tell whether the leases of other workers have
+	// expired from the observed renewals instead of the lease timeouts written by the owners, e.g. DynamoCheckpoint
+	LeaseExpiryChecker interface {
+		// IsLeaseExpired tells whether the lease of the shard has expired, or will have expired by the time unless
+		// it is renewed meanwhile
+		IsLeaseExpired(shard *par.ShardStatus, at time.Time) bool
+	}
+
+	// leaseObserver tells whether the leases of other workers have expired from the changes of their lease counters,
+	// like the KCL for Java. The lease owner increments the counter with each renewal, and a lease has expired once
+	// its counter has not been observed to change for the lease duration. The elapsed time is measured by the
+	// monotonic clock of the local host, so that the leases do not expire spuriously when the clocks of the hosts
+	// are skewed, as they would if the lease timeouts written by the owners were compared with the local time.
+	leaseObserver struct {
+		mux    sync.Mutex
+		leases map[string]observedLease
+	}
+
+	// observedLease is the last observed change of the lease of a shard
+	observedLease struct {
+		owner   string
+		counter int64
+		// changed is the local time the owner or the counter was observed to change at
+		changed time.Time
+	}
+)
+
+var _ LeaseExpiryChecker = (*DynamoCheckpoint)(nil)
+
+func newLeaseObserver() *leaseObserver {
+	return &leaseObserver{leases: make(map[string]observedLease)}
+}
+
+// observe records the owner and the counter of the lease of the shard, and returns the time since they were last
+// observed to change. A lease observed for the first time is regarded as renewed just now.
+func (o *leaseObserver) observe(shardID, owner string, counter int64) time.Duration {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+
+	lease, ok := o.leases[shardID]
+	if !ok || lease.owner != owner || lease.counter != counter {
+		lease = observedLease{owner: owner, counter: counter, changed: time.Now()}
+		o.leases[shardID] = lease
+	}
+	return time.Since(lease.changed)
+}
+
+// isExpired tells whether the lease of the shard has not been renewed by its owner for the lease duration
+func (o *leaseObserver) isExpired(shardID, owner string, counter int64, leaseDuration time.Duration) bool {
+	return o.observe(shardID, owner, counter) >= leaseDuration
+}
+
+// isExpiredAt tells whether the last observed lease of the owner of the shard will have expired by the time unless
+// it is renewed meanwhile. observed is false if the lease of the owner has not been observed.
+func (o *leaseObserver) isExpiredAt(shardID, owner string, at time.Time, leaseDuration time.Duration) (expired, observed bool) {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+
+	lease, ok := o.leases[shardID]
+	if !ok || lease.owner != owner {
+		return false, false
+	}
+	return !lease.changed.Add(leaseDuration).After(at), true
+}
+
+// forget drops the observed lease of the shard, e.g. once the shard no longer exists
+func (o *leaseObserver) forget(shardID string) {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	delete(o.leases, shardID)
+}
@@ -0,0 +1,194 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package checkpoint
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+const (
+	// LeaseSchemaV1 is the schema of the leases written before the schema has been versioned, their items have no
+	// LeaseSchemaVersion attribute
+	LeaseSchemaV1 = 1
+
+	// LeaseSchemaV2 stores the schema version with the lease. The sub-sequence number, the pending checkpoint and
+	// the metadata of the checkpoint are written by the LeaseSerializer, large attributes may be compressed.
+	LeaseSchemaV2 = 2
+
+	// CurrentLeaseSchemaVersion is the schema of the leases written by the default LeaseSerializer
+	CurrentLeaseSchemaVersion = LeaseSchemaV2
+)
+
+// LeaseSerializer stores the checkpoint of a shard in the item of its lease in the DynamoDB lease table. The lease
+// key, the lease owner, the lease timeout, the lease counter, the claim request, the parent shard and the checkpointed
+// sequence number are written by the checkpointer, as they are evaluated by the conditions of the writes.
+//
+// The items of earlier schema versions are read by the serializer and written with its schema version, so that the
+// leases are migrated in place by their first write. A worker does not take the lease of an item written with a
+// later schema version, as it would drop the attributes it does not know.
+type LeaseSerializer interface {
+	// SchemaVersion returns the version of the leases written by the serializer
+	SchemaVersion() int
+
+	// MarshalCheckpoint adds the checkpoint attributes of the shard to the item of its lease
+	MarshalCheckpoint(shard *par.ShardStatus, item map[string]types.AttributeValue) error
+
+	// UnmarshalCheckpoint sets the checkpoint attributes of the shard from the item of its lease, which has been
+	// written with the schema version or an earlier one
+	UnmarshalCheckpoint(item map[string]types.AttributeValue, version int, shard *par.ShardStatus) error
+}
+
+// leaseSerializer is the LeaseSerializer of CurrentLeaseSchemaVersion
+type leaseSerializer struct {
+	compressionThreshold int
+}
+
+// NewLeaseSerializer returns the serializer of CurrentLeaseSchemaVersion, the pending checkpoint and the checkpoint
+// metadata larger than compressionThreshold bytes are stored gzip compressed and base64 encoded, 0 disables the
+// compression
+func NewLeaseSerializer(compressionThreshold int) LeaseSerializer {
+	return &leaseSerializer{compressionThreshold: compressionThreshold}
+}
+
+func (s *leaseSerializer) SchemaVersion() int {
+	return CurrentLeaseSchemaVersion
+}
+
+func (s *leaseSerializer) MarshalCheckpoint(shard *par.ShardStatus, item map[string]types.AttributeValue) error {
+	if subSequenceNumber := shard.GetSubSequenceNumber(); subSequenceNumber != nil {
+		item[SubSequenceNumberKey] = &types.AttributeValueMemberN{Value: strconv.FormatInt(*subSequenceNumber, 10)}
+	}
+
+	if pendingCheckpoint := shard.GetPendingCheckpoint(); pendingCheckpoint != "" {
+		if s.compressionThreshold > 0 && len(pendingCheckpoint) > s.compressionThreshold {
+			compressed, err := compressValue([]byte(pendingCheckpoint))
+			if err != nil {
+				return err
+			}
+			pendingCheckpoint = compressed
+		}
+		item[PendingCheckpointKey] = &types.AttributeValueMemberS{Value: pendingCheckpoint}
+	}
+
+	// uncompressed metadata is stored as binary, compressed metadata as string
+	if metadata := shard.GetCheckpointMetadata(); len(metadata) > 0 {
+		if s.compressionThreshold > 0 && len(metadata) > s.compressionThreshold {
+			compressed, err := compressValue(metadata)
+			if err != nil {
+				return err
+			}
+			item[CheckpointMetadataKey] = &types.AttributeValueMemberS{Value: compressed}
+		} else {
+			item[CheckpointMetadataKey] = &types.AttributeValueMemberB{Value: metadata}
+		}
+	}
+
+	return nil
+}
+
+// UnmarshalCheckpoint reads the leases of both schema versions, the attributes of LeaseSchemaV1 are never compressed
+func (s *leaseSerializer) UnmarshalCheckpoint(item map[string]types.AttributeValue, _ int, shard *par.ShardStatus) error {
+	if subSequenceNumber, ok := item[SubSequenceNumberKey].(*types.AttributeValueMemberN); ok {
+		subSequence, err := strconv.ParseInt(subSequenceNumber.Value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", SubSequenceNumberKey, err)
+		}
+		shard.SetSubSequenceNumber(&subSequence)
+	} else {
+		shard.SetSubSequenceNumber(nil)
+	}
+
+	// a checkpoint may have been prepared before anything was committed
+	pendingCheckpoint := ""
+	if value, ok := item[PendingCheckpointKey].(*types.AttributeValueMemberS); ok {
+		pendingCheckpoint = value.Value
+		if strings.HasPrefix(pendingCheckpoint, compressedValuePrefix) {
+			decompressed, err := decompressValue(pendingCheckpoint)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %w", PendingCheckpointKey, err)
+			}
+			pendingCheckpoint = string(decompressed)
+		}
+	}
+	shard.SetPendingCheckpoint(pendingCheckpoint)
+
+	switch metadata := item[CheckpointMetadataKey].(type) {
+	case *types.AttributeValueMemberB:
+		shard.SetCheckpointMetadata(metadata.Value)
+	case *types.AttributeValueMemberS:
+		decompressed, err := decompressValue(metadata.Value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", CheckpointMetadataKey, err)
+		}
+		shard.SetCheckpointMetadata(decompressed)
+	default:
+		shard.SetCheckpointMetadata(nil)
+	}
+
+	return nil
+}
+
+// leaseSchemaVersion returns the schema version of the item of a lease, LeaseSchemaV1 if it has none
+func leaseSchemaVersion(item map[string]types.AttributeValue) (int, error) {
+	version, ok := item[LeaseSchemaVersionKey].(*types.AttributeValueMemberN)
+	if !ok {
+		return LeaseSchemaV1, nil
+	}
+	v, err := strconv.Atoi(version.Value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", LeaseSchemaVersionKey, err)
+	}
+	return v, nil
+}
+
+// marshalLease adds the schema version and the checkpoint attributes of the shard to the item of its lease
+func (checkpointer *DynamoCheckpoint) marshalLease(item map[string]types.AttributeValue, shard *par.ShardStatus) error {
+	item[LeaseSchemaVersionKey] = &types.AttributeValueMemberN{Value: strconv.Itoa(checkpointer.serializer.SchemaVersion())}
+	return checkpointer.serializer.MarshalCheckpoint(shard, item)
+}
+
+// unmarshalLease sets the checkpoint attributes of the shard from the item of its lease, it fails for the items of
+// later schema versions
+func (checkpointer *DynamoCheckpoint) unmarshalLease(item map[string]types.AttributeValue, shard *par.ShardStatus) error {
+	version, err := checkpointer.checkLeaseSchema(item, shard.ID)
+	if err != nil {
+		return err
+	}
+	return checkpointer.serializer.UnmarshalCheckpoint(item, version, shard)
+}
+
+// checkLeaseSchema returns the schema version of the item of a lease, or a LeaseSchemaError if it is later than the
+// schema of the serializer
+func (checkpointer *DynamoCheckpoint) checkLeaseSchema(item map[string]types.AttributeValue, shardID string) (int, error) {
+	version, err := leaseSchemaVersion(item)
+	if err != nil {
+		return 0, err
+	}
+	if supported := checkpointer.serializer.SchemaVersion(); version > supported {
+		return 0, &LeaseSchemaError{ShardID: shardID, Version: version, SupportedVersion: supported}
+	}
+	return version, nil
+}
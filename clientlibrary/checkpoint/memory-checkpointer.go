@@ -0,0 +1,570 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package checkpoint
+package checkpoint
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+	"github.com/vmware/vmware-go-kcl-v2/logger"
+)
+
+var (
+	memoryLeaseTablesMux sync.Mutex
+	// memoryLeaseTables are the lease tables shared by the workers of the process, by file or table name
+	memoryLeaseTables = map[string]*MemoryLeaseTable{}
+)
+
+// MemoryLeaseTable is a lease table kept in memory. Every shard is stored in a map using the same field names
+// as the DynamoDB lease table. The table can be shared by several workers to test lease failover in one process.
+type MemoryLeaseTable struct {
+	mux    sync.Mutex
+	file   string
+	leases map[string]map[string]string
+}
+
+// NewMemoryLeaseTable creates a lease table which is persisted to the given JSON file unless it is empty.
+// The leases are loaded from the file if it exists.
+func NewMemoryLeaseTable(file string) (*MemoryLeaseTable, error) {
+	table := &MemoryLeaseTable{
+		file:   file,
+		leases: map[string]map[string]string{},
+	}
+
+	if file == "" {
+		return table, nil
+	}
+
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return table, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &table.leases); err != nil {
+		return nil, err
+	}
+
+	return table, nil
+}
+
+// MemoryCheckpoint implements the Checkpoint interface using a MemoryLeaseTable as a backend
+type MemoryCheckpoint struct {
+	log       logger.Logger
+	TableName string
+
+	LeaseDuration int
+	table         *MemoryLeaseTable
+	kclConfig     *config.KinesisClientLibConfiguration
+}
+
+func NewMemoryCheckpoint(kclConfig *config.KinesisClientLibConfiguration) *MemoryCheckpoint {
+	checkpointer := &MemoryCheckpoint{
+		log:           kclConfig.Logger.WithFields(logger.Fields{"workerID": kclConfig.WorkerID}),
+		TableName:     kclConfig.TableName,
+		LeaseDuration: kclConfig.FailoverTimeMillis,
+		kclConfig:     kclConfig,
+	}
+
+	return checkpointer
+}
+
+// WithLeaseTable is used to provide the lease table, e.g. to share it by the workers of a test
+func (checkpointer *MemoryCheckpoint) WithLeaseTable(table *MemoryLeaseTable) *MemoryCheckpoint {
+	checkpointer.table = table
+	return checkpointer
+}
+
+// Open initialises the in-memory Checkpoint like Init, the lease table lives in memory
+func (checkpointer *MemoryCheckpoint) Open() error {
+	return checkpointer.Init()
+}
+
+// Init initialises the in-memory Checkpoint. Unless a lease table has been provided, the workers of the process
+// using the same MemoryCheckpointFile, or the same TableName without a file, share a lease table.
+func (checkpointer *MemoryCheckpoint) Init() error {
+	if checkpointer.table != nil {
+		return nil
+	}
+
+	key := "table:" + checkpointer.TableName
+	if file := checkpointer.kclConfig.MemoryCheckpointFile; file != "" {
+		absFile, err := filepath.Abs(file)
+		if err != nil {
+			return err
+		}
+		key = "file:" + absFile
+	}
+
+	memoryLeaseTablesMux.Lock()
+	defer memoryLeaseTablesMux.Unlock()
+
+	table, ok := memoryLeaseTables[key]
+	if !ok {
+		checkpointer.log.Infof("Creating in-memory lease table %s", key)
+		var err error
+		table, err = NewMemoryLeaseTable(checkpointer.kclConfig.MemoryCheckpointFile)
+		if err != nil {
+			return err
+		}
+		memoryLeaseTables[key] = table
+	}
+
+	checkpointer.table = table
+	return nil
+}
+
+// GetLease attempts to gain a lock on the given shard
+func (checkpointer *MemoryCheckpoint) GetLease(shard *par.ShardStatus, newAssignTo string) error {
+	newLeaseTimeout := time.Now().Add(time.Duration(checkpointer.LeaseDuration) * time.Millisecond).UTC()
+	newLeaseTimeoutString := newLeaseTimeout.Format(time.RFC3339Nano)
+	currentCheckpoint := checkpointer.table.get(shard.ID)
+
+	isClaimRequestExpired := shard.IsClaimRequestExpired(checkpointer.kclConfig)
+
+	var claimRequest string
+	if checkpointer.kclConfig.EnableLeaseStealing {
+		if currentCheckpointClaimRequest := currentCheckpoint[ClaimRequestKey]; currentCheckpointClaimRequest != "" {
+			claimRequest = currentCheckpointClaimRequest
+			if newAssignTo != claimRequest && !isClaimRequestExpired {
+				checkpointer.log.Debugf("another worker: %s has a claim on this shard. Not going to renew the lease", claimRequest)
+				shard.SetClaimRequest(claimRequest)
+				return ErrShardAlreadyClaimed
+			}
+		}
+	}
+
+	assignedTo, assignedToOk := currentCheckpoint[LeaseOwnerKey]
+	leaseTimeout, leaseTimeoutOk := currentCheckpoint[LeaseTimeoutKey]
+
+	var expected []string
+	if !leaseTimeoutOk || !assignedToOk {
+		expected = []string{LeaseOwnerKey, ""}
+	} else {
+		currentLeaseTimeout, err := time.Parse(time.RFC3339Nano, leaseTimeout)
+		if err != nil {
+			return err
+		}
+
+		if checkpointer.kclConfig.EnableLeaseStealing {
+			if time.Now().UTC().Before(currentLeaseTimeout) && assignedTo != newAssignTo && !isClaimRequestExpired {
+				return ErrLeaseNotAcquired{cause: "current lease timeout not yet expired"}
+			}
+		} else {
+			if time.Now().UTC().Before(currentLeaseTimeout) && assignedTo != newAssignTo {
+				return ErrLeaseNotAcquired{cause: "current lease timeout not yet expired"}
+			}
+		}
+
+		checkpointer.log.Debugf("Attempting to get a lock for shard: %s, leaseTimeout: %s, assignedTo: %s, newAssignedTo: %s", shard.ID, currentLeaseTimeout, assignedTo, newAssignTo)
+		expected = []string{LeaseOwnerKey, assignedTo, LeaseTimeoutKey, leaseTimeout}
+	}
+
+	marshalledCheckpoint := map[string]string{
+		LeaseKeyKey:     shard.ID,
+		LeaseOwnerKey:   newAssignTo,
+		LeaseTimeoutKey: newLeaseTimeoutString,
+	}
+
+	if len(shard.ParentShardId) > 0 {
+		marshalledCheckpoint[ParentShardIdKey] = shard.ParentShardId
+	}
+
+	if checkpoint := shard.GetCheckpoint(); checkpoint != "" {
+		marshalledCheckpoint[SequenceNumberKey] = checkpoint
+	}
+
+	if subSequenceNumber := shard.GetSubSequenceNumber(); subSequenceNumber != nil {
+		marshalledCheckpoint[SubSequenceNumberKey] = strconv.FormatInt(*subSequenceNumber, 10)
+	}
+
+	// keep the prepared checkpoint so that the new lease owner can resume from it
+	if pendingCheckpoint := shard.GetPendingCheckpoint(); pendingCheckpoint != "" {
+		marshalledCheckpoint[PendingCheckpointKey] = pendingCheckpoint
+	}
+
+	if metadata := shard.GetCheckpointMetadata(); len(metadata) > 0 {
+		marshalledCheckpoint[CheckpointMetadataKey] = encodeCheckpointMetadata(metadata)
+	}
+
+	if checkpointer.kclConfig.EnableLeaseStealing {
+		if claimRequest != "" && claimRequest == newAssignTo && !isClaimRequestExpired {
+			expected = append(expected, ClaimRequestKey, claimRequest)
+		}
+	}
+
+	err := checkpointer.table.conditionalUpdate(shard.ID, expected, marshalledCheckpoint)
+	if err != nil {
+		if errors.Is(err, ErrConditionalCheckFailed) {
+			return ErrLeaseNotAcquired{cause: err.Error(), err: err}
+		}
+		return err
+	}
+
+	shard.Mux.Lock()
+	shard.AssignedTo = newAssignTo
+	shard.LeaseTimeout = newLeaseTimeout
+	// the lease has been written without the claim request
+	shard.ClaimRequest = ""
+	shard.Mux.Unlock()
+
+	return nil
+}
+
+// CheckpointSequence writes a checkpoint at the designated sequence ID
+func (checkpointer *MemoryCheckpoint) CheckpointSequence(shard *par.ShardStatus) error {
+	leaseTimeout := shard.GetLeaseTimeout().UTC().Format(time.RFC3339Nano)
+	marshalledCheckpoint := map[string]string{
+		LeaseKeyKey:       shard.ID,
+		SequenceNumberKey: shard.GetCheckpoint(),
+		LeaseTimeoutKey:   leaseTimeout,
+	}
+
+	// a checkpoint without lease owner, e.g. written for a shard not consumed yet, leaves the lease free
+	if owner := shard.GetLeaseOwner(); owner != "" {
+		marshalledCheckpoint[LeaseOwnerKey] = owner
+	}
+
+	if len(shard.ParentShardId) > 0 {
+		marshalledCheckpoint[ParentShardIdKey] = shard.ParentShardId
+	}
+
+	if subSequenceNumber := shard.GetSubSequenceNumber(); subSequenceNumber != nil {
+		marshalledCheckpoint[SubSequenceNumberKey] = strconv.FormatInt(*subSequenceNumber, 10)
+	}
+
+	if pendingCheckpoint := shard.GetPendingCheckpoint(); pendingCheckpoint != "" {
+		marshalledCheckpoint[PendingCheckpointKey] = pendingCheckpoint
+	}
+
+	if metadata := shard.GetCheckpointMetadata(); len(metadata) > 0 {
+		marshalledCheckpoint[CheckpointMetadataKey] = encodeCheckpointMetadata(metadata)
+	}
+
+	if !checkpointer.kclConfig.EnableLeaseStealing {
+		return checkpointer.table.conditionalUpdate(shard.ID, nil, marshalledCheckpoint)
+	}
+
+	err := checkpointer.saveClaimedItem(shard.ID, marshalledCheckpoint, shard.GetClaimRequest())
+	if !errors.Is(err, ErrConditionalCheckFailed) {
+		return err
+	}
+
+	claimRequest := checkpointer.table.get(shard.ID)[ClaimRequestKey]
+	shard.SetClaimRequest(claimRequest)
+
+	return checkpointer.saveClaimedItem(shard.ID, marshalledCheckpoint, claimRequest)
+}
+
+// saveClaimedItem writes the lease with the claim request, if the claim request of the stored lease is the same
+func (checkpointer *MemoryCheckpoint) saveClaimedItem(shardID string, item map[string]string, claimRequest string) error {
+	if claimRequest != "" {
+		item[ClaimRequestKey] = claimRequest
+	} else {
+		delete(item, ClaimRequestKey)
+	}
+	return checkpointer.table.conditionalUpdate(shardID, []string{ClaimRequestKey, claimRequest}, item)
+}
+
+// FetchCheckpoint retrieves the checkpoint for the given shard
+func (checkpointer *MemoryCheckpoint) FetchCheckpoint(shard *par.ShardStatus) error {
+	checkpoint := checkpointer.table.get(shard.ID)
+
+	// a checkpoint may have been prepared before anything was committed
+	shard.SetPendingCheckpoint(checkpoint[PendingCheckpointKey])
+
+	// another worker may be attempting to steal the shard
+	shard.SetClaimRequest(checkpoint[ClaimRequestKey])
+
+	metadata, err := decodeCheckpointMetadata(checkpoint[CheckpointMetadataKey])
+	if err != nil {
+		return err
+	}
+	shard.SetCheckpointMetadata(metadata)
+
+	sequenceID, ok := checkpoint[SequenceNumberKey]
+	if !ok {
+		return ErrSequenceIDNotFound
+	}
+
+	checkpointer.log.Debugf("Retrieved Shard Iterator %s", sequenceID)
+	shard.SetCheckpoint(sequenceID)
+
+	if subSequenceNumber, ok := checkpoint[SubSequenceNumberKey]; ok {
+		subSequence, err := strconv.ParseInt(subSequenceNumber, 10, 64)
+		if err != nil {
+			return err
+		}
+		shard.SetSubSequenceNumber(&subSequence)
+	} else {
+		shard.SetSubSequenceNumber(nil)
+	}
+
+	if assignedTo, ok := checkpoint[LeaseOwnerKey]; ok {
+		shard.SetLeaseOwner(assignedTo)
+	}
+
+	// Use up-to-date leaseTimeout to avoid a failed conditional update when claiming
+	if leaseTimeout := checkpoint[LeaseTimeoutKey]; leaseTimeout != "" {
+		currentLeaseTimeout, err := time.Parse(time.RFC3339Nano, leaseTimeout)
+		if err != nil {
+			return err
+		}
+		shard.LeaseTimeout = currentLeaseTimeout
+	}
+
+	return nil
+}
+
+// RemoveLeaseInfo to remove lease info for shard entry because the shard no longer exists in Kinesis
+func (checkpointer *MemoryCheckpoint) RemoveLeaseInfo(shardID string) error {
+	err := checkpointer.table.delete(shardID)
+
+	if err != nil {
+		checkpointer.log.Errorf("Error in removing lease info for shard: %s, Error: %+v", shardID, err)
+	} else {
+		checkpointer.log.Infof("Lease info for shard: %s has been removed.", shardID)
+	}
+
+	return err
+}
+
+// RemoveLeaseOwner to remove lease owner for the shard entry
+func (checkpointer *MemoryCheckpoint) RemoveLeaseOwner(shardID string) error {
+	return checkpointer.table.removeOwner(shardID, checkpointer.kclConfig.WorkerID)
+}
+
+// ReleaseLease removes the lease owner of a shard whichever worker holds the lease
+func (checkpointer *MemoryCheckpoint) ReleaseLease(shardID string) error {
+	return checkpointer.table.removeOwner(shardID, "")
+}
+
+// ListLeases returns the leases of all shards in the lease table ordered by shard ID
+func (checkpointer *MemoryCheckpoint) ListLeases() ([]*par.ShardStatus, error) {
+	return fetchLeases(checkpointer, checkpointer.table.shardIDs())
+}
+
+// DeleteLeaseTable deletes all leases and checkpoints of the lease table
+func (checkpointer *MemoryCheckpoint) DeleteLeaseTable() error {
+	return checkpointer.table.clear()
+}
+
+// GetLeaseOwner returns current lease owner of given shard in checkpoints table
+func (checkpointer *MemoryCheckpoint) GetLeaseOwner(shardID string) (string, error) {
+	assignedTo, ok := checkpointer.table.get(shardID)[LeaseOwnerKey]
+	if !ok {
+		return "", NoLeaseOwnerErr
+	}
+
+	return assignedTo, nil
+}
+
+// ListActiveWorkers returns a map of workers and their shards. The leases are read at every call since the
+// lease table is in memory.
+func (checkpointer *MemoryCheckpoint) ListActiveWorkers(shardStatus map[string]*par.ShardStatus) (map[string][]*par.ShardStatus, error) {
+	for shardID, shard := range shardStatus {
+		lease := checkpointer.table.get(shardID)
+		assignedTo, foundAssignedTo := lease[LeaseOwnerKey]
+		checkpoint, foundCheckpoint := lease[SequenceNumberKey]
+		if foundAssignedTo && foundCheckpoint {
+			shard.SetLeaseOwner(assignedTo)
+			shard.SetCheckpoint(checkpoint)
+			// the lease owner learns about claim requests without renewing the lease
+			shard.SetClaimRequest(lease[ClaimRequestKey])
+		}
+	}
+
+	workers := map[string][]*par.ShardStatus{}
+	for _, shard := range shardStatus {
+		if shard.GetCheckpoint() == ShardEnd {
+			continue
+		}
+
+		leaseOwner := shard.GetLeaseOwner()
+		if leaseOwner == "" {
+			checkpointer.log.Debugf("Shard Not Assigned Error. ShardID: %s, WorkerID: %s", shard.ID, checkpointer.kclConfig.WorkerID)
+			return nil, ErrShardNotAssigned
+		}
+
+		workers[leaseOwner] = append(workers[leaseOwner], shard)
+	}
+	return workers, nil
+}
+
+// ClaimShard places a claim request on a shard to signal a steal attempt
+func (checkpointer *MemoryCheckpoint) ClaimShard(shard *par.ShardStatus, claimID string) error {
+	err := checkpointer.FetchCheckpoint(shard)
+	if err != nil && !errors.Is(err, ErrSequenceIDNotFound) {
+		return err
+	}
+	leaseTimeoutString := shard.GetLeaseTimeout().Format(time.RFC3339Nano)
+
+	expected := []string{
+		LeaseTimeoutKey, leaseTimeoutString,
+		ClaimRequestKey, "",
+		LeaseOwnerKey, shard.GetLeaseOwner(),
+		SequenceNumberKey, shard.GetCheckpoint(),
+		ParentShardIdKey, shard.ParentShardId,
+	}
+
+	marshalledCheckpoint := map[string]string{
+		LeaseKeyKey:       shard.ID,
+		LeaseTimeoutKey:   leaseTimeoutString,
+		SequenceNumberKey: shard.GetCheckpoint(),
+		ClaimRequestKey:   claimID,
+	}
+
+	if leaseOwner := shard.GetLeaseOwner(); leaseOwner != "" {
+		marshalledCheckpoint[LeaseOwnerKey] = leaseOwner
+	}
+
+	// the lease owner keeps checkpointing until the lease is handed over
+	if subSequenceNumber := shard.GetSubSequenceNumber(); subSequenceNumber != nil {
+		marshalledCheckpoint[SubSequenceNumberKey] = strconv.FormatInt(*subSequenceNumber, 10)
+	}
+
+	if pendingCheckpoint := shard.GetPendingCheckpoint(); pendingCheckpoint != "" {
+		marshalledCheckpoint[PendingCheckpointKey] = pendingCheckpoint
+	}
+
+	if metadata := shard.GetCheckpointMetadata(); len(metadata) > 0 {
+		marshalledCheckpoint[CheckpointMetadataKey] = encodeCheckpointMetadata(metadata)
+	}
+
+	if shard.ParentShardId != "" {
+		marshalledCheckpoint[ParentShardIdKey] = shard.ParentShardId
+	}
+
+	return checkpointer.table.conditionalUpdate(shard.ID, expected, marshalledCheckpoint)
+}
+
+// get returns a copy of the lease of a shard, which is empty if the shard has no lease
+func (table *MemoryLeaseTable) get(shardID string) map[string]string {
+	table.mux.Lock()
+	defer table.mux.Unlock()
+
+	lease := make(map[string]string, len(table.leases[shardID]))
+	for k, v := range table.leases[shardID] {
+		lease[k] = v
+	}
+	return lease
+}
+
+// conditionalUpdate replaces the lease of a shard if its current fields match the expected field/value pairs.
+// An empty value requires the field to be absent. The lease is replaced as a whole, the same way DynamoDB PutItem does.
+func (table *MemoryLeaseTable) conditionalUpdate(shardID string, expected []string, item map[string]string) error {
+	table.mux.Lock()
+	defer table.mux.Unlock()
+
+	current := table.leases[shardID]
+	for i := 0; i+1 < len(expected); i += 2 {
+		value, ok := current[expected[i]]
+		if expected[i+1] == "" {
+			if ok {
+				return ErrConditionalCheckFailed
+			}
+		} else if value != expected[i+1] {
+			return ErrConditionalCheckFailed
+		}
+	}
+
+	lease := make(map[string]string, len(item))
+	for k, v := range item {
+		lease[k] = v
+	}
+	table.leases[shardID] = lease
+
+	return table.persist()
+}
+
+// removeOwner removes the lease owner of a shard if it still holds the lease. The lease owner is removed whichever
+// worker holds the lease if owner is empty.
+func (table *MemoryLeaseTable) removeOwner(shardID, owner string) error {
+	table.mux.Lock()
+	defer table.mux.Unlock()
+
+	lease, ok := table.leases[shardID]
+	if !ok && owner == "" {
+		return ErrLeaseNotFound
+	}
+	if !ok || (owner != "" && lease[LeaseOwnerKey] != owner) {
+		return ErrConditionalCheckFailed
+	}
+
+	delete(lease, LeaseOwnerKey)
+	return table.persist()
+}
+
+func (table *MemoryLeaseTable) shardIDs() []string {
+	table.mux.Lock()
+	defer table.mux.Unlock()
+
+	shardIDs := make([]string, 0, len(table.leases))
+	for shardID := range table.leases {
+		shardIDs = append(shardIDs, shardID)
+	}
+	return shardIDs
+}
+
+func (table *MemoryLeaseTable) clear() error {
+	table.mux.Lock()
+	defer table.mux.Unlock()
+
+	table.leases = make(map[string]map[string]string)
+	return table.persist()
+}
+
+func (table *MemoryLeaseTable) delete(shardID string) error {
+	table.mux.Lock()
+	defer table.mux.Unlock()
+
+	delete(table.leases, shardID)
+	return table.persist()
+}
+
+// persist writes the lease table to its file, if any. The file is replaced atomically so that a crash never
+// leaves a partially written lease table behind. The caller has to hold the lock of the table.
+func (table *MemoryLeaseTable) persist() error {
+	if table.file == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(table.leases, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmpFile := table.file + ".tmp"
+	if err := os.WriteFile(tmpFile, data, 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile, table.file)
+}
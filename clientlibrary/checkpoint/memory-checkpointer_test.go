@@ -0,0 +1,213 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package checkpoint
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+
+	cfg "github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+func newTestMemoryCheckpoint(t *testing.T, table *MemoryLeaseTable, kclConfig *cfg.KinesisClientLibConfiguration) *MemoryCheckpoint {
+	checkpoint := NewMemoryCheckpoint(kclConfig).WithLeaseTable(table)
+	assert.Nil(t, checkpoint.Init())
+	return checkpoint
+}
+
+func TestMemoryGetLeaseNotAcquired(t *testing.T) {
+	table, _ := NewMemoryLeaseTable("")
+	kclConfig := cfg.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithFailoverTimeMillis(300000)
+	checkpoint := newTestMemoryCheckpoint(t, table, kclConfig)
+
+	err := checkpoint.GetLease(&par.ShardStatus{
+		ID:  "0001",
+		Mux: &sync.RWMutex{},
+	}, "abcd-efgh")
+	assert.Nil(t, err)
+
+	err = checkpoint.GetLease(&par.ShardStatus{
+		ID:  "0001",
+		Mux: &sync.RWMutex{},
+	}, "ijkl-mnop")
+	if err == nil || !errors.As(err, &ErrLeaseNotAcquired{}) {
+		t.Errorf("Got a lease when it was already held by abcd-efgh: %s", err)
+	}
+}
+
+func TestMemoryLeaseFailover(t *testing.T) {
+	table, _ := NewMemoryLeaseTable("")
+	worker1 := newTestMemoryCheckpoint(t, table, cfg.NewKinesisClientLibConfig("appName", "test", "us-west-2", "worker_1").
+		WithFailoverTimeMillis(10))
+	worker2 := newTestMemoryCheckpoint(t, table, cfg.NewKinesisClientLibConfig("appName", "test", "us-west-2", "worker_2").
+		WithFailoverTimeMillis(300000))
+
+	shard := &par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}}
+	assert.Nil(t, worker1.GetLease(shard, "worker_1"))
+	shard.SetCheckpoint("deadbeef")
+	assert.Nil(t, worker1.CheckpointSequence(shard))
+
+	// worker_1 stops renewing the lease, worker_2 takes over once it expires
+	time.Sleep(20 * time.Millisecond)
+	status := &par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}}
+	assert.Nil(t, worker2.FetchCheckpoint(status))
+	assert.Equal(t, "deadbeef", status.GetCheckpoint())
+	assert.Nil(t, worker2.GetLease(status, "worker_2"))
+
+	owner, err := worker1.GetLeaseOwner("0001")
+	assert.Nil(t, err)
+	assert.Equal(t, "worker_2", owner)
+
+	// worker_1 can neither renew nor release the lease of worker_2
+	err = worker1.GetLease(shard, "worker_1")
+	assert.True(t, errors.As(err, &ErrLeaseNotAcquired{}))
+	assert.Equal(t, ErrConditionalCheckFailed, worker1.RemoveLeaseOwner("0001"))
+
+	assert.Nil(t, worker2.RemoveLeaseOwner("0001"))
+	_, err = worker2.GetLeaseOwner("0001")
+	assert.Equal(t, NoLeaseOwnerErr, err)
+
+	assert.Nil(t, worker2.RemoveLeaseInfo("0001"))
+	assert.Equal(t, ErrSequenceIDNotFound, worker2.FetchCheckpoint(status))
+}
+
+func TestMemoryCheckpointSequence(t *testing.T) {
+	table, _ := NewMemoryLeaseTable("")
+	kclConfig := cfg.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithFailoverTimeMillis(300000)
+	checkpoint := newTestMemoryCheckpoint(t, table, kclConfig)
+
+	status := &par.ShardStatus{
+		ID:  "0001",
+		Mux: &sync.RWMutex{},
+	}
+	assert.Equal(t, ErrSequenceIDNotFound, checkpoint.FetchCheckpoint(status))
+
+	shard := &par.ShardStatus{
+		ID:                 "0001",
+		Checkpoint:         "deadbeef",
+		SubSequenceNumber:  aws.Int64(2),
+		PendingCheckpoint:  "feedbeef",
+		CheckpointMetadata: []byte("meta"),
+		AssignedTo:         "abcd-efgh",
+		LeaseTimeout:       time.Now().Add(time.Minute),
+		Mux:                &sync.RWMutex{},
+	}
+	assert.Nil(t, checkpoint.CheckpointSequence(shard))
+
+	assert.Nil(t, checkpoint.FetchCheckpoint(status))
+	assert.Equal(t, "deadbeef", status.GetCheckpoint())
+	assert.Equal(t, int64(2), *status.GetSubSequenceNumber())
+	assert.Equal(t, "feedbeef", status.GetPendingCheckpoint())
+	assert.Equal(t, []byte("meta"), status.GetCheckpointMetadata())
+	assert.Equal(t, "abcd-efgh", status.GetLeaseOwner())
+
+	// committing the checkpoint clears the optional fields
+	shard.SetSubSequenceNumber(nil)
+	shard.SetPendingCheckpoint("")
+	shard.SetCheckpointMetadata(nil)
+	assert.Nil(t, checkpoint.CheckpointSequence(shard))
+	assert.Nil(t, checkpoint.FetchCheckpoint(status))
+	assert.Nil(t, status.GetSubSequenceNumber())
+	assert.Equal(t, "", status.GetPendingCheckpoint())
+	assert.Nil(t, status.GetCheckpointMetadata())
+}
+
+func TestMemoryListActiveWorkersAndClaimShard(t *testing.T) {
+	table, _ := NewMemoryLeaseTable("")
+	kclConfig := cfg.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithLeaseStealing(true).
+		WithFailoverTimeMillis(300000)
+	checkpoint := newTestMemoryCheckpoint(t, table, kclConfig)
+
+	shardStatus := map[string]*par.ShardStatus{
+		"0000": {ID: "0000", Checkpoint: "1", Mux: &sync.RWMutex{}},
+		"0001": {ID: "0001", Checkpoint: "2", Mux: &sync.RWMutex{}},
+		"0002": {ID: "0002", Checkpoint: "3", Mux: &sync.RWMutex{}},
+	}
+	assert.Nil(t, checkpoint.GetLease(shardStatus["0000"], "worker_1"))
+	assert.Nil(t, checkpoint.GetLease(shardStatus["0001"], "worker_2"))
+	assert.Nil(t, checkpoint.GetLease(shardStatus["0002"], "worker_2"))
+	for _, shard := range shardStatus {
+		assert.Nil(t, checkpoint.CheckpointSequence(shard))
+		shard.SetLeaseOwner("")
+	}
+
+	workers, err := checkpoint.ListActiveWorkers(shardStatus)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(workers["worker_1"]))
+	assert.Equal(t, 2, len(workers["worker_2"]))
+
+	err = checkpoint.ClaimShard(shardStatus["0001"], "worker_1")
+	assert.Nil(t, err)
+
+	// the shard is already claimed
+	err = checkpoint.ClaimShard(shardStatus["0001"], "worker_3")
+	assert.Equal(t, ErrConditionalCheckFailed, err)
+
+	// the checkpoint of the lease owner unaware of the claim keeps the claim request
+	stale := &par.ShardStatus{ID: "0001", Checkpoint: "5", AssignedTo: "worker_2", Mux: &sync.RWMutex{}}
+	assert.Nil(t, checkpoint.CheckpointSequence(stale))
+	assert.Equal(t, "worker_1", stale.GetClaimRequest())
+	current := &par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}}
+	assert.Nil(t, checkpoint.FetchCheckpoint(current))
+	assert.Equal(t, "5", current.GetCheckpoint())
+	assert.Equal(t, "worker_1", current.GetClaimRequest())
+
+	// the lease owner can't renew a claimed lease
+	err = checkpoint.GetLease(shardStatus["0001"], "worker_2")
+	assert.ErrorIs(t, err, ErrShardAlreadyClaimed)
+	assert.Equal(t, ErrShardClaimed, err.Error())
+}
+
+func TestMemoryCheckpointFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "leases.json")
+	kclConfig := cfg.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithMemoryCheckpointer(file)
+
+	// the workers of a process share the lease table of a file
+	checkpoint := NewMemoryCheckpoint(kclConfig)
+	assert.Nil(t, checkpoint.Init())
+	other := NewMemoryCheckpoint(kclConfig)
+	assert.Nil(t, other.Init())
+	assert.Same(t, checkpoint.table, other.table)
+
+	shard := &par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}}
+	assert.Nil(t, checkpoint.GetLease(shard, "abc"))
+	shard.SetCheckpoint("deadbeef")
+	assert.Nil(t, checkpoint.CheckpointSequence(shard))
+
+	// the leases are loaded from the file after a restart
+	table, err := NewMemoryLeaseTable(file)
+	assert.Nil(t, err)
+	restarted := newTestMemoryCheckpoint(t, table, kclConfig)
+	status := &par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}}
+	assert.Nil(t, restarted.FetchCheckpoint(status))
+	assert.Equal(t, "deadbeef", status.GetCheckpoint())
+	assert.Equal(t, "abc", status.GetLeaseOwner())
+}
@@ -30,6 +30,9 @@ package checkpoint
 
 import (
 	"context"
+	"reflect"
+	"strings"
+
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
@@ -41,9 +44,19 @@ type mockDynamoDB struct {
 	item                      map[string]types.AttributeValue
 	conditionalExpression     string
 	expressionAttributeValues map[string]types.AttributeValue
+	createTableInput          *dynamodb.CreateTableInput
+	continuousBackupsInput    *dynamodb.UpdateContinuousBackupsInput
+	continuousBackupsErr      error
+	// scan returns the pages of a Scan, it may be called concurrently
+	scan func(params *dynamodb.ScanInput) (*dynamodb.ScanOutput, error)
+	// getItem returns the output of a GetItem, the item is returned without it
+	getItem func(params *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error)
 }
 
 func (m *mockDynamoDB) Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	if m.scan != nil {
+		return m.scan(params)
+	}
 	return &dynamodb.ScanOutput{}, nil
 }
 
@@ -52,16 +65,52 @@ func (m *mockDynamoDB) DescribeTable(ctx context.Context, params *dynamodb.Descr
 		return &dynamodb.DescribeTableOutput{}, &types.ResourceNotFoundException{Message: aws.String("doesNotExist")}
 	}
 
-	return &dynamodb.DescribeTableOutput{}, nil
+	return &dynamodb.DescribeTableOutput{Table: &types.TableDescription{TableStatus: types.TableStatusActive}}, nil
 }
 
 func (m *mockDynamoDB) CreateTable(ctx context.Context, params *dynamodb.CreateTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.CreateTableOutput, error) {
+	m.createTableInput = params
+	m.tableExist = true
 	return &dynamodb.CreateTableOutput{}, nil
 }
 
+func (m *mockDynamoDB) UpdateContinuousBackups(ctx context.Context, params *dynamodb.UpdateContinuousBackupsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateContinuousBackupsOutput, error) {
+	if m.continuousBackupsErr != nil {
+		return nil, m.continuousBackupsErr
+	}
+	m.continuousBackupsInput = params
+	return &dynamodb.UpdateContinuousBackupsOutput{}, nil
+}
+
 func (m *mockDynamoDB) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
 	item := params.Item
 
+	// the claim request and lease fencing conditions of a checkpoint are evaluated
+	for _, condition := range strings.Split(aws.ToString(params.ConditionExpression), " AND ") {
+		switch condition {
+		case "attribute_not_exists(ClaimRequest)":
+			if _, claimed := m.item[ClaimRequestKey]; claimed {
+				return nil, &types.ConditionalCheckFailedException{Message: aws.String("claimed")}
+			}
+		case "ClaimRequest = :claim_request":
+			if !m.equals(ClaimRequestKey, params.ExpressionAttributeValues[":claim_request"]) {
+				return nil, &types.ConditionalCheckFailedException{Message: aws.String("claim request changed")}
+			}
+		case "attribute_not_exists(AssignedTo)":
+			if _, assigned := m.item[LeaseOwnerKey]; assigned {
+				return nil, &types.ConditionalCheckFailedException{Message: aws.String("assigned")}
+			}
+		case "AssignedTo = :assigned_to":
+			if !m.equals(LeaseOwnerKey, params.ExpressionAttributeValues[":assigned_to"]) {
+				return nil, &types.ConditionalCheckFailedException{Message: aws.String("lease owner changed")}
+			}
+		case "LeaseCounter = :lease_counter":
+			if !m.equals(LeaseCounterKey, params.ExpressionAttributeValues[":lease_counter"]) {
+				return nil, &types.ConditionalCheckFailedException{Message: aws.String("lease counter changed")}
+			}
+		}
+	}
+
 	if shardID, ok := item[LeaseKeyKey]; ok {
 		m.item[LeaseKeyKey] = shardID
 	}
@@ -78,6 +127,36 @@ func (m *mockDynamoDB) PutItem(ctx context.Context, params *dynamodb.PutItemInpu
 		m.item[SequenceNumberKey] = checkpoint
 	}
 
+	if subSequenceNumber, ok := item[SubSequenceNumberKey]; ok {
+		m.item[SubSequenceNumberKey] = subSequenceNumber
+	} else {
+		delete(m.item, SubSequenceNumberKey)
+	}
+
+	if pendingCheckpoint, ok := item[PendingCheckpointKey]; ok {
+		m.item[PendingCheckpointKey] = pendingCheckpoint
+	} else {
+		delete(m.item, PendingCheckpointKey)
+	}
+
+	if metadata, ok := item[CheckpointMetadataKey]; ok {
+		m.item[CheckpointMetadataKey] = metadata
+	} else {
+		delete(m.item, CheckpointMetadataKey)
+	}
+
+	if leaseCounter, ok := item[LeaseCounterKey]; ok {
+		m.item[LeaseCounterKey] = leaseCounter
+	}
+
+	for _, key := range []string{LeaseSchemaVersionKey, heartbeatCounterKey} {
+		if value, ok := item[key]; ok {
+			m.item[key] = value
+		} else {
+			delete(m.item, key)
+		}
+	}
+
 	if parent, ok := item[ParentShardIdKey]; ok {
 		m.item[ParentShardIdKey] = parent
 	}
@@ -95,7 +174,16 @@ func (m *mockDynamoDB) PutItem(ctx context.Context, params *dynamodb.PutItemInpu
 	return nil, nil
 }
 
+// equals reports whether the attribute of the stored item has the value
+func (m *mockDynamoDB) equals(name string, value types.AttributeValue) bool {
+	attribute, ok := m.item[name]
+	return ok && reflect.DeepEqual(attribute, value)
+}
+
 func (m *mockDynamoDB) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	if m.getItem != nil {
+		return m.getItem(params)
+	}
 	return &dynamodb.GetItemOutput{
 		Item: m.item,
 	}, nil
@@ -111,6 +199,12 @@ func (m *mockDynamoDB) UpdateItem(ctx context.Context, params *dynamodb.UpdateIt
 	return nil, nil
 }
 
+func (m *mockDynamoDB) DeleteTable(ctx context.Context, params *dynamodb.DeleteTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteTableOutput, error) {
+	m.tableExist = false
+	m.item = map[string]types.AttributeValue{}
+	return &dynamodb.DeleteTableOutput{}, nil
+}
+
 func (m *mockDynamoDB) DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
 	return &dynamodb.DeleteItemOutput{}, nil
 }
@@ -0,0 +1,563 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package checkpoint
+package checkpoint
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+	"github.com/vmware/vmware-go-kcl-v2/logger"
+)
+
+// casScript replaces the lease hash of a shard if its current fields match the expected ones.
+//
+// KEYS[1] is the lease hash and KEYS[2] is the set indexing all shards of the lease table.
+// ARGV[1] is the shard ID and ARGV[2] is the number n of expected fields, followed by n
+// field/value pairs (an empty value requires the field to be absent) and the fields of the
+// new lease. The hash is replaced as a whole, the same way DynamoDB PutItem does.
+var casScript = redis.NewScript(`
+local n = tonumber(ARGV[2])
+for i = 3, 2 * n + 1, 2 do
+	local current = redis.call('HGET', KEYS[1], ARGV[i])
+	if ARGV[i + 1] == '' then
+		if current then
+			return 0
+		end
+	elseif current ~= ARGV[i + 1] then
+		return 0
+	end
+end
+redis.call('DEL', KEYS[1])
+redis.call('HSET', KEYS[1], unpack(ARGV, 2 * n + 3))
+redis.call('SADD', KEYS[2], ARGV[1])
+return 1
+`)
+
+// removeOwnerScript removes the lease owner ARGV[2] from the lease hash KEYS[1] if it still holds the lease.
+var removeOwnerScript = redis.NewScript(`
+if redis.call('HGET', KEYS[1], ARGV[1]) ~= ARGV[2] then
+	return 0
+end
+redis.call('HDEL', KEYS[1], ARGV[1])
+return 1
+`)
+
+// RedisCheckpoint implements the Checkpoint interface using Redis as a backend.
+// Every shard is stored in a hash using the same field names as the DynamoDB lease table.
+type RedisCheckpoint struct {
+	log       logger.Logger
+	TableName string
+
+	LeaseDuration int
+	svc           redis.UniversalClient
+	kclConfig     *config.KinesisClientLibConfiguration
+	lastLeaseSync time.Time
+}
+
+func NewRedisCheckpoint(kclConfig *config.KinesisClientLibConfiguration) *RedisCheckpoint {
+	checkpointer := &RedisCheckpoint{
+		log:           kclConfig.Logger.WithFields(logger.Fields{"workerID": kclConfig.WorkerID}),
+		TableName:     kclConfig.TableName,
+		LeaseDuration: kclConfig.FailoverTimeMillis,
+		kclConfig:     kclConfig,
+	}
+
+	return checkpointer
+}
+
+// WithRedis is used to provide Redis client
+func (checkpointer *RedisCheckpoint) WithRedis(svc redis.UniversalClient) *RedisCheckpoint {
+	checkpointer.svc = svc
+	return checkpointer
+}
+
+// Open initialises the Redis Checkpoint, Redis doesn't need a lease table to be created
+func (checkpointer *RedisCheckpoint) Open() error {
+	return checkpointer.Init()
+}
+
+// Init initialises the Redis Checkpoint
+func (checkpointer *RedisCheckpoint) Init() error {
+	checkpointer.log.Infof("Creating Redis client")
+
+	if checkpointer.svc == nil {
+		checkpointer.svc = redis.NewClient(&redis.Options{
+			Addr:     checkpointer.kclConfig.RedisAddress,
+			Password: checkpointer.kclConfig.RedisPassword,
+			DB:       checkpointer.kclConfig.RedisDB,
+		})
+	}
+
+	return checkpointer.svc.Ping(context.Background()).Err()
+}
+
+// GetLease attempts to gain a lock on the given shard
+func (checkpointer *RedisCheckpoint) GetLease(shard *par.ShardStatus, newAssignTo string) error {
+	newLeaseTimeout := time.Now().Add(time.Duration(checkpointer.LeaseDuration) * time.Millisecond).UTC()
+	newLeaseTimeoutString := newLeaseTimeout.Format(time.RFC3339Nano)
+	currentCheckpoint, err := checkpointer.getItem(shard.ID)
+	if err != nil {
+		return err
+	}
+
+	isClaimRequestExpired := shard.IsClaimRequestExpired(checkpointer.kclConfig)
+
+	var claimRequest string
+	if checkpointer.kclConfig.EnableLeaseStealing {
+		if currentCheckpointClaimRequest := currentCheckpoint[ClaimRequestKey]; currentCheckpointClaimRequest != "" {
+			claimRequest = currentCheckpointClaimRequest
+			if newAssignTo != claimRequest && !isClaimRequestExpired {
+				checkpointer.log.Debugf("another worker: %s has a claim on this shard. Not going to renew the lease", claimRequest)
+				shard.SetClaimRequest(claimRequest)
+				return ErrShardAlreadyClaimed
+			}
+		}
+	}
+
+	assignedTo, assignedToOk := currentCheckpoint[LeaseOwnerKey]
+	leaseTimeout, leaseTimeoutOk := currentCheckpoint[LeaseTimeoutKey]
+
+	var expected []string
+	if !leaseTimeoutOk || !assignedToOk {
+		expected = []string{LeaseOwnerKey, ""}
+	} else {
+		currentLeaseTimeout, err := time.Parse(time.RFC3339Nano, leaseTimeout)
+		if err != nil {
+			return err
+		}
+
+		if checkpointer.kclConfig.EnableLeaseStealing {
+			if time.Now().UTC().Before(currentLeaseTimeout) && assignedTo != newAssignTo && !isClaimRequestExpired {
+				return ErrLeaseNotAcquired{cause: "current lease timeout not yet expired"}
+			}
+		} else {
+			if time.Now().UTC().Before(currentLeaseTimeout) && assignedTo != newAssignTo {
+				return ErrLeaseNotAcquired{cause: "current lease timeout not yet expired"}
+			}
+		}
+
+		checkpointer.log.Debugf("Attempting to get a lock for shard: %s, leaseTimeout: %s, assignedTo: %s, newAssignedTo: %s", shard.ID, currentLeaseTimeout, assignedTo, newAssignTo)
+		expected = []string{LeaseOwnerKey, assignedTo, LeaseTimeoutKey, leaseTimeout}
+	}
+
+	marshalledCheckpoint := map[string]string{
+		LeaseKeyKey:     shard.ID,
+		LeaseOwnerKey:   newAssignTo,
+		LeaseTimeoutKey: newLeaseTimeoutString,
+	}
+
+	if len(shard.ParentShardId) > 0 {
+		marshalledCheckpoint[ParentShardIdKey] = shard.ParentShardId
+	}
+
+	if checkpoint := shard.GetCheckpoint(); checkpoint != "" {
+		marshalledCheckpoint[SequenceNumberKey] = checkpoint
+	}
+
+	if subSequenceNumber := shard.GetSubSequenceNumber(); subSequenceNumber != nil {
+		marshalledCheckpoint[SubSequenceNumberKey] = strconv.FormatInt(*subSequenceNumber, 10)
+	}
+
+	// keep the prepared checkpoint so that the new lease owner can resume from it
+	if pendingCheckpoint := shard.GetPendingCheckpoint(); pendingCheckpoint != "" {
+		marshalledCheckpoint[PendingCheckpointKey] = pendingCheckpoint
+	}
+
+	if metadata := shard.GetCheckpointMetadata(); len(metadata) > 0 {
+		marshalledCheckpoint[CheckpointMetadataKey] = encodeCheckpointMetadata(metadata)
+	}
+
+	if checkpointer.kclConfig.EnableLeaseStealing {
+		if claimRequest != "" && claimRequest == newAssignTo && !isClaimRequestExpired {
+			expected = append(expected, ClaimRequestKey, claimRequest)
+		}
+	}
+
+	err = checkpointer.conditionalUpdate(shard.ID, expected, marshalledCheckpoint)
+	if err != nil {
+		if errors.Is(err, ErrConditionalCheckFailed) {
+			return ErrLeaseNotAcquired{cause: err.Error(), err: err}
+		}
+		return err
+	}
+
+	shard.Mux.Lock()
+	shard.AssignedTo = newAssignTo
+	shard.LeaseTimeout = newLeaseTimeout
+	// the lease has been written without the claim request
+	shard.ClaimRequest = ""
+	shard.Mux.Unlock()
+
+	return nil
+}
+
+// CheckpointSequence writes a checkpoint at the designated sequence ID
+func (checkpointer *RedisCheckpoint) CheckpointSequence(shard *par.ShardStatus) error {
+	leaseTimeout := shard.GetLeaseTimeout().UTC().Format(time.RFC3339Nano)
+	marshalledCheckpoint := map[string]string{
+		LeaseKeyKey:       shard.ID,
+		SequenceNumberKey: shard.GetCheckpoint(),
+		LeaseOwnerKey:     shard.GetLeaseOwner(),
+		LeaseTimeoutKey:   leaseTimeout,
+	}
+
+	if len(shard.ParentShardId) > 0 {
+		marshalledCheckpoint[ParentShardIdKey] = shard.ParentShardId
+	}
+
+	if subSequenceNumber := shard.GetSubSequenceNumber(); subSequenceNumber != nil {
+		marshalledCheckpoint[SubSequenceNumberKey] = strconv.FormatInt(*subSequenceNumber, 10)
+	}
+
+	// The whole hash is replaced, so the checkpoint and the pending checkpoint are committed
+	// (or cleared) atomically.
+	if pendingCheckpoint := shard.GetPendingCheckpoint(); pendingCheckpoint != "" {
+		marshalledCheckpoint[PendingCheckpointKey] = pendingCheckpoint
+	}
+
+	if metadata := shard.GetCheckpointMetadata(); len(metadata) > 0 {
+		marshalledCheckpoint[CheckpointMetadataKey] = encodeCheckpointMetadata(metadata)
+	}
+
+	if !checkpointer.kclConfig.EnableLeaseStealing {
+		return checkpointer.conditionalUpdate(shard.ID, nil, marshalledCheckpoint)
+	}
+
+	err := checkpointer.saveClaimedItem(shard.ID, marshalledCheckpoint, shard.GetClaimRequest())
+	if !errors.Is(err, ErrConditionalCheckFailed) {
+		return err
+	}
+
+	currentCheckpoint, err := checkpointer.getItem(shard.ID)
+	if err != nil {
+		return err
+	}
+	claimRequest := currentCheckpoint[ClaimRequestKey]
+	shard.SetClaimRequest(claimRequest)
+
+	return checkpointer.saveClaimedItem(shard.ID, marshalledCheckpoint, claimRequest)
+}
+
+// FetchCheckpoint retrieves the checkpoint for the given shard
+func (checkpointer *RedisCheckpoint) FetchCheckpoint(shard *par.ShardStatus) error {
+	checkpoint, err := checkpointer.getItem(shard.ID)
+	if err != nil {
+		return err
+	}
+
+	// a checkpoint may have been prepared before anything was committed
+	shard.SetPendingCheckpoint(checkpoint[PendingCheckpointKey])
+
+	// another worker may be attempting to steal the shard
+	shard.SetClaimRequest(checkpoint[ClaimRequestKey])
+
+	metadata, err := decodeCheckpointMetadata(checkpoint[CheckpointMetadataKey])
+	if err != nil {
+		return err
+	}
+	shard.SetCheckpointMetadata(metadata)
+
+	sequenceID, ok := checkpoint[SequenceNumberKey]
+	if !ok {
+		return ErrSequenceIDNotFound
+	}
+
+	checkpointer.log.Debugf("Retrieved Shard Iterator %s", sequenceID)
+	shard.SetCheckpoint(sequenceID)
+
+	if subSequenceNumber, ok := checkpoint[SubSequenceNumberKey]; ok {
+		subSequence, err := strconv.ParseInt(subSequenceNumber, 10, 64)
+		if err != nil {
+			return err
+		}
+		shard.SetSubSequenceNumber(&subSequence)
+	} else {
+		shard.SetSubSequenceNumber(nil)
+	}
+
+	if assignedTo, ok := checkpoint[LeaseOwnerKey]; ok {
+		shard.SetLeaseOwner(assignedTo)
+	}
+
+	// Use up-to-date leaseTimeout to avoid a failed conditional update when claiming
+	if leaseTimeout := checkpoint[LeaseTimeoutKey]; leaseTimeout != "" {
+		currentLeaseTimeout, err := time.Parse(time.RFC3339Nano, leaseTimeout)
+		if err != nil {
+			return err
+		}
+		shard.LeaseTimeout = currentLeaseTimeout
+	}
+
+	return nil
+}
+
+// RemoveLeaseInfo to remove lease info for shard entry in Redis because the shard no longer exists in Kinesis
+func (checkpointer *RedisCheckpoint) RemoveLeaseInfo(shardID string) error {
+	ctx := context.Background()
+	_, err := checkpointer.svc.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, checkpointer.leaseKey(shardID))
+		pipe.SRem(ctx, checkpointer.shardsKey(), shardID)
+		return nil
+	})
+
+	if err != nil {
+		checkpointer.log.Errorf("Error in removing lease info for shard: %s, Error: %+v", shardID, err)
+	} else {
+		checkpointer.log.Infof("Lease info for shard: %s has been removed.", shardID)
+	}
+
+	return err
+}
+
+// RemoveLeaseOwner to remove lease owner for the shard entry
+func (checkpointer *RedisCheckpoint) RemoveLeaseOwner(shardID string) error {
+	removed, err := removeOwnerScript.Run(context.Background(), checkpointer.svc,
+		[]string{checkpointer.leaseKey(shardID)}, LeaseOwnerKey, checkpointer.kclConfig.WorkerID).Int()
+	if err != nil {
+		return err
+	}
+
+	if removed == 0 {
+		return ErrConditionalCheckFailed
+	}
+
+	return nil
+}
+
+// ReleaseLease removes the lease owner of a shard whichever worker holds the lease
+func (checkpointer *RedisCheckpoint) ReleaseLease(shardID string) error {
+	ctx := context.Background()
+	exists, err := checkpointer.svc.Exists(ctx, checkpointer.leaseKey(shardID)).Result()
+	if err != nil {
+		return err
+	}
+	if exists == 0 {
+		return ErrLeaseNotFound
+	}
+
+	return checkpointer.svc.HDel(ctx, checkpointer.leaseKey(shardID), LeaseOwnerKey).Err()
+}
+
+// ListLeases returns the leases of all shards in the lease table ordered by shard ID
+func (checkpointer *RedisCheckpoint) ListLeases() ([]*par.ShardStatus, error) {
+	shardIDs, err := checkpointer.svc.SMembers(context.Background(), checkpointer.shardsKey()).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	return fetchLeases(checkpointer, shardIDs)
+}
+
+// DeleteLeaseTable deletes the lease hashes of all shards and the set of shards
+func (checkpointer *RedisCheckpoint) DeleteLeaseTable() error {
+	ctx := context.Background()
+	shardIDs, err := checkpointer.svc.SMembers(ctx, checkpointer.shardsKey()).Result()
+	if err != nil {
+		return err
+	}
+
+	keys := []string{checkpointer.shardsKey()}
+	for _, shardID := range shardIDs {
+		keys = append(keys, checkpointer.leaseKey(shardID))
+	}
+	return checkpointer.svc.Del(ctx, keys...).Err()
+}
+
+// GetLeaseOwner returns current lease owner of given shard in checkpoints table
+func (checkpointer *RedisCheckpoint) GetLeaseOwner(shardID string) (string, error) {
+	assignedTo, err := checkpointer.svc.HGet(context.Background(), checkpointer.leaseKey(shardID), LeaseOwnerKey).Result()
+	if err == redis.Nil {
+		return "", NoLeaseOwnerErr
+	}
+
+	return assignedTo, err
+}
+
+// ListActiveWorkers returns a map of workers and their shards
+func (checkpointer *RedisCheckpoint) ListActiveWorkers(shardStatus map[string]*par.ShardStatus) (map[string][]*par.ShardStatus, error) {
+	err := checkpointer.syncLeases(shardStatus)
+	if err != nil {
+		return nil, err
+	}
+
+	workers := map[string][]*par.ShardStatus{}
+	for _, shard := range shardStatus {
+		if shard.GetCheckpoint() == ShardEnd {
+			continue
+		}
+
+		leaseOwner := shard.GetLeaseOwner()
+		if leaseOwner == "" {
+			checkpointer.log.Debugf("Shard Not Assigned Error. ShardID: %s, WorkerID: %s", shard.ID, checkpointer.kclConfig.WorkerID)
+			return nil, ErrShardNotAssigned
+		}
+
+		workers[leaseOwner] = append(workers[leaseOwner], shard)
+	}
+	return workers, nil
+}
+
+// ClaimShard places a claim request on a shard to signal a steal attempt
+func (checkpointer *RedisCheckpoint) ClaimShard(shard *par.ShardStatus, claimID string) error {
+	err := checkpointer.FetchCheckpoint(shard)
+	if err != nil && !errors.Is(err, ErrSequenceIDNotFound) {
+		return err
+	}
+	leaseTimeoutString := shard.GetLeaseTimeout().Format(time.RFC3339Nano)
+
+	expected := []string{
+		LeaseTimeoutKey, leaseTimeoutString,
+		ClaimRequestKey, "",
+		LeaseOwnerKey, shard.GetLeaseOwner(),
+		SequenceNumberKey, shard.GetCheckpoint(),
+		ParentShardIdKey, shard.ParentShardId,
+	}
+
+	marshalledCheckpoint := map[string]string{
+		LeaseKeyKey:       shard.ID,
+		LeaseTimeoutKey:   leaseTimeoutString,
+		SequenceNumberKey: shard.GetCheckpoint(),
+		ClaimRequestKey:   claimID,
+	}
+
+	if leaseOwner := shard.GetLeaseOwner(); leaseOwner != "" {
+		marshalledCheckpoint[LeaseOwnerKey] = leaseOwner
+	}
+
+	// the lease owner keeps checkpointing until the lease is handed over
+	if subSequenceNumber := shard.GetSubSequenceNumber(); subSequenceNumber != nil {
+		marshalledCheckpoint[SubSequenceNumberKey] = strconv.FormatInt(*subSequenceNumber, 10)
+	}
+
+	if pendingCheckpoint := shard.GetPendingCheckpoint(); pendingCheckpoint != "" {
+		marshalledCheckpoint[PendingCheckpointKey] = pendingCheckpoint
+	}
+
+	if metadata := shard.GetCheckpointMetadata(); len(metadata) > 0 {
+		marshalledCheckpoint[CheckpointMetadataKey] = encodeCheckpointMetadata(metadata)
+	}
+
+	if shard.ParentShardId != "" {
+		marshalledCheckpoint[ParentShardIdKey] = shard.ParentShardId
+	}
+
+	return checkpointer.conditionalUpdate(shard.ID, expected, marshalledCheckpoint)
+}
+
+func (checkpointer *RedisCheckpoint) syncLeases(shardStatus map[string]*par.ShardStatus) error {
+	log := checkpointer.log
+
+	if (checkpointer.lastLeaseSync.Add(time.Duration(checkpointer.kclConfig.LeaseSyncingTimeIntervalMillis) * time.Millisecond)).After(time.Now()) {
+		return nil
+	}
+
+	checkpointer.lastLeaseSync = time.Now()
+	ctx := context.Background()
+	shardIDs, err := checkpointer.svc.SMembers(ctx, checkpointer.shardsKey()).Result()
+	if err != nil {
+		log.Debugf("Error listing shards in Redis. Error: %+v ", err)
+		return err
+	}
+
+	cmds := make([]*redis.SliceCmd, len(shardIDs))
+	_, err = checkpointer.svc.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, shardID := range shardIDs {
+			cmds[i] = pipe.HMGet(ctx, checkpointer.leaseKey(shardID), LeaseOwnerKey, SequenceNumberKey)
+		}
+		return nil
+	})
+
+	if err != nil {
+		log.Debugf("Error performing SyncLeases. Error: %+v ", err)
+		return err
+	}
+
+	for i, shardID := range shardIDs {
+		values := cmds[i].Val()
+		assignedTo, foundAssignedTo := values[0].(string)
+		checkpoint, foundCheckpoint := values[1].(string)
+		if !foundAssignedTo || !foundCheckpoint {
+			continue
+		}
+
+		if shard, ok := shardStatus[shardID]; ok {
+			shard.SetLeaseOwner(assignedTo)
+			shard.SetCheckpoint(checkpoint)
+		}
+	}
+
+	log.Debugf("Lease sync completed. Next lease sync will occur in %s", time.Duration(checkpointer.kclConfig.LeaseSyncingTimeIntervalMillis)*time.Millisecond)
+	return nil
+}
+
+// conditionalUpdate replaces the lease of a shard if the expected field/value pairs match, see casScript
+func (checkpointer *RedisCheckpoint) conditionalUpdate(shardID string, expected []string, item map[string]string) error {
+	args := make([]interface{}, 0, 2+len(expected)+2*len(item))
+	args = append(args, shardID, len(expected)/2)
+	for _, v := range expected {
+		args = append(args, v)
+	}
+	for k, v := range item {
+		args = append(args, k, v)
+	}
+
+	updated, err := casScript.Run(context.Background(), checkpointer.svc,
+		[]string{checkpointer.leaseKey(shardID), checkpointer.shardsKey()}, args...).Int()
+	if err != nil {
+		return err
+	}
+
+	if updated == 0 {
+		return ErrConditionalCheckFailed
+	}
+
+	return nil
+}
+
+// saveClaimedItem writes the lease with the claim request, if the claim request of the stored lease is the same
+func (checkpointer *RedisCheckpoint) saveClaimedItem(shardID string, item map[string]string, claimRequest string) error {
+	if claimRequest != "" {
+		item[ClaimRequestKey] = claimRequest
+	} else {
+		delete(item, ClaimRequestKey)
+	}
+	return checkpointer.conditionalUpdate(shardID, []string{ClaimRequestKey, claimRequest}, item)
+}
+
+func (checkpointer *RedisCheckpoint) getItem(shardID string) (map[string]string, error) {
+	return checkpointer.svc.HGetAll(context.Background(), checkpointer.leaseKey(shardID)).Result()
+}
+
+// leaseKey returns the key of the lease hash of a shard. The table name is used as hash tag so that
+// all keys of a lease table are in the same slot of a Redis cluster.
+func (checkpointer *RedisCheckpoint) leaseKey(shardID string) string {
+	return "{" + checkpointer.TableName + "}:" + shardID
+}
+
+func (checkpointer *RedisCheckpoint) shardsKey() string {
+	return "{" + checkpointer.TableName + "}:shards"
+}
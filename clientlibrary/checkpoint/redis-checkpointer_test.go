@@ -0,0 +1,209 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package checkpoint
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+
+	cfg "github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+func newTestRedisCheckpoint(t *testing.T, kclConfig *cfg.KinesisClientLibConfiguration) (*RedisCheckpoint, *miniredis.Miniredis) {
+	s := miniredis.RunT(t)
+	checkpoint := NewRedisCheckpoint(kclConfig).WithRedis(redis.NewClient(&redis.Options{Addr: s.Addr()}))
+	assert.Nil(t, checkpoint.Init())
+	return checkpoint, s
+}
+
+func TestRedisGetLeaseNotAcquired(t *testing.T) {
+	kclConfig := cfg.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithFailoverTimeMillis(300000)
+	checkpoint, _ := newTestRedisCheckpoint(t, kclConfig)
+
+	err := checkpoint.GetLease(&par.ShardStatus{
+		ID:  "0001",
+		Mux: &sync.RWMutex{},
+	}, "abcd-efgh")
+	assert.Nil(t, err)
+
+	err = checkpoint.GetLease(&par.ShardStatus{
+		ID:  "0001",
+		Mux: &sync.RWMutex{},
+	}, "ijkl-mnop")
+	if err == nil || !errors.As(err, &ErrLeaseNotAcquired{}) {
+		t.Errorf("Got a lease when it was already held by abcd-efgh: %s", err)
+	}
+}
+
+func TestRedisGetLeaseAquired(t *testing.T) {
+	kclConfig := cfg.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithFailoverTimeMillis(300000)
+	checkpoint, s := newTestRedisCheckpoint(t, kclConfig)
+
+	s.HSet(checkpoint.leaseKey("0001"),
+		LeaseKeyKey, "0001",
+		LeaseOwnerKey, "abcd-efgh",
+		LeaseTimeoutKey, time.Now().AddDate(0, -1, 0).UTC().Format(time.RFC3339),
+		SequenceNumberKey, "deadbeef")
+
+	shard := &par.ShardStatus{
+		ID:         "0001",
+		Checkpoint: "deadbeef",
+		Mux:        &sync.RWMutex{},
+	}
+	err := checkpoint.GetLease(shard, "ijkl-mnop")
+	assert.Nil(t, err)
+
+	owner, err := checkpoint.GetLeaseOwner("0001")
+	assert.Nil(t, err)
+	assert.Equal(t, "ijkl-mnop", owner)
+	assert.Equal(t, "ijkl-mnop", shard.GetLeaseOwner())
+
+	status := &par.ShardStatus{
+		ID:  shard.ID,
+		Mux: &sync.RWMutex{},
+	}
+	err = checkpoint.FetchCheckpoint(status)
+	assert.Nil(t, err)
+	assert.Equal(t, "deadbeef", status.GetCheckpoint())
+	assert.Equal(t, shard.GetLeaseTimeout().Truncate(time.Microsecond), status.GetLeaseTimeout().Truncate(time.Microsecond))
+}
+
+func TestRedisCheckpointSequence(t *testing.T) {
+	kclConfig := cfg.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithFailoverTimeMillis(300000)
+	checkpoint, s := newTestRedisCheckpoint(t, kclConfig)
+
+	status := &par.ShardStatus{
+		ID:  "0001",
+		Mux: &sync.RWMutex{},
+	}
+	assert.Equal(t, ErrSequenceIDNotFound, checkpoint.FetchCheckpoint(status))
+
+	shard := &par.ShardStatus{
+		ID:                "0001",
+		Checkpoint:        "deadbeef",
+		SubSequenceNumber: aws.Int64(2),
+		PendingCheckpoint: "feedbeef",
+		AssignedTo:        "abcd-efgh",
+		LeaseTimeout:      time.Now().Add(time.Minute),
+		Mux:               &sync.RWMutex{},
+	}
+	assert.Nil(t, checkpoint.CheckpointSequence(shard))
+
+	assert.Nil(t, checkpoint.FetchCheckpoint(status))
+	assert.Equal(t, "deadbeef", status.GetCheckpoint())
+	assert.Equal(t, int64(2), *status.GetSubSequenceNumber())
+	assert.Equal(t, "feedbeef", status.GetPendingCheckpoint())
+	assert.Equal(t, "abcd-efgh", status.GetLeaseOwner())
+
+	// committing the checkpoint clears the optional fields
+	shard.SetSubSequenceNumber(nil)
+	shard.SetPendingCheckpoint("")
+	assert.Nil(t, checkpoint.CheckpointSequence(shard))
+	assert.True(t, s.Exists(checkpoint.leaseKey("0001")))
+	assert.Equal(t, "", s.HGet(checkpoint.leaseKey("0001"), PendingCheckpointKey))
+	assert.Equal(t, "", s.HGet(checkpoint.leaseKey("0001"), SubSequenceNumberKey))
+}
+
+func TestRedisRemoveLease(t *testing.T) {
+	kclConfig := cfg.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithFailoverTimeMillis(300000)
+	checkpoint, s := newTestRedisCheckpoint(t, kclConfig)
+
+	shard := &par.ShardStatus{
+		ID:  "0001",
+		Mux: &sync.RWMutex{},
+	}
+	assert.Nil(t, checkpoint.GetLease(shard, "abc"))
+
+	// only the lease owner can remove itself
+	otherConfig := cfg.NewKinesisClientLibConfig("appName", "test", "us-west-2", "ijkl-mnop")
+	other := NewRedisCheckpoint(otherConfig).WithRedis(checkpoint.svc)
+	assert.Equal(t, ErrConditionalCheckFailed, other.RemoveLeaseOwner("0001"))
+
+	assert.Nil(t, checkpoint.RemoveLeaseOwner("0001"))
+	_, err := checkpoint.GetLeaseOwner("0001")
+	assert.Equal(t, NoLeaseOwnerErr, err)
+
+	assert.Nil(t, checkpoint.RemoveLeaseInfo("0001"))
+	assert.False(t, s.Exists(checkpoint.leaseKey("0001")))
+	members, _ := s.Members(checkpoint.shardsKey())
+	assert.Empty(t, members)
+}
+
+func TestRedisListActiveWorkersAndClaimShard(t *testing.T) {
+	kclConfig := cfg.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithLeaseStealing(true).
+		WithFailoverTimeMillis(300000)
+	checkpoint, _ := newTestRedisCheckpoint(t, kclConfig)
+
+	shardStatus := map[string]*par.ShardStatus{
+		"0000": {ID: "0000", Checkpoint: "1", Mux: &sync.RWMutex{}},
+		"0001": {ID: "0001", Checkpoint: "2", Mux: &sync.RWMutex{}},
+		"0002": {ID: "0002", Checkpoint: "3", Mux: &sync.RWMutex{}},
+	}
+	assert.Nil(t, checkpoint.GetLease(shardStatus["0000"], "worker_1"))
+	assert.Nil(t, checkpoint.GetLease(shardStatus["0001"], "worker_2"))
+	assert.Nil(t, checkpoint.GetLease(shardStatus["0002"], "worker_2"))
+	for _, shard := range shardStatus {
+		assert.Nil(t, checkpoint.CheckpointSequence(shard))
+		shard.SetLeaseOwner("")
+	}
+
+	workers, err := checkpoint.ListActiveWorkers(shardStatus)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(workers["worker_1"]))
+	assert.Equal(t, 2, len(workers["worker_2"]))
+
+	err = checkpoint.ClaimShard(shardStatus["0001"], "worker_1")
+	assert.Nil(t, err)
+
+	// the shard is already claimed
+	err = checkpoint.ClaimShard(shardStatus["0001"], "worker_3")
+	assert.Equal(t, ErrConditionalCheckFailed, err)
+
+	// the checkpoint of the lease owner unaware of the claim keeps the claim request
+	stale := &par.ShardStatus{ID: "0001", Checkpoint: "5", AssignedTo: "worker_2", Mux: &sync.RWMutex{}}
+	assert.Nil(t, checkpoint.CheckpointSequence(stale))
+	assert.Equal(t, "worker_1", stale.GetClaimRequest())
+	current := &par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}}
+	assert.Nil(t, checkpoint.FetchCheckpoint(current))
+	assert.Equal(t, "5", current.GetCheckpoint())
+	assert.Equal(t, "worker_1", current.GetClaimRequest())
+
+	// the lease owner can't renew a claimed lease
+	err = checkpoint.GetLease(shardStatus["0001"], "worker_2")
+	assert.Equal(t, ErrShardClaimed, err.Error())
+
+	claimed := &par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}}
+	assert.Nil(t, checkpoint.FetchCheckpoint(claimed))
+	assert.Equal(t, "worker_2", claimed.GetLeaseOwner())
+	assert.Equal(t, "5", claimed.GetCheckpoint())
+}
@@ -0,0 +1,729 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package checkpoint
+package checkpoint
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+	"github.com/vmware/vmware-go-kcl-v2/logger"
+)
+
+const (
+	// PostgreSQLDialect is used for PostgreSQL compatible databases
+	PostgreSQLDialect SQLDialect = iota + 1
+	// MySQLDialect is used for MySQL compatible databases
+	MySQLDialect
+)
+
+// sqlMigrations upgrade the lease table to the schema expected by SQLCheckpoint. The schema version
+// of a lease table is the number of migrations applied to it, which is kept in <table>_schema_version.
+// %[1]s is replaced by the quoted name of the lease table.
+//
+// Lease timeouts are stored as RFC3339 strings, the same way they are stored in DynamoDB, so that
+// they can be compared as is in conditional updates.
+var sqlMigrations = []string{
+	`CREATE TABLE IF NOT EXISTS %[1]s (
+	shard_id            VARCHAR(255) NOT NULL PRIMARY KEY,
+	assigned_to         VARCHAR(255),
+	lease_timeout       VARCHAR(64),
+	checkpoint          VARCHAR(255),
+	sub_sequence_number BIGINT,
+	pending_checkpoint  VARCHAR(255),
+	parent_shard_id     VARCHAR(255),
+	claim_request       VARCHAR(255)
+)`,
+	// the metadata of the record processor is stored base64 encoded, so that the column type is the same in all
+	// dialects
+	`ALTER TABLE %[1]s ADD COLUMN checkpoint_metadata TEXT`,
+}
+
+// sqlLeaseColumns are the columns of the lease table, shard_id has to be the first one
+var sqlLeaseColumns = []string{
+	"shard_id",
+	"assigned_to",
+	"lease_timeout",
+	"checkpoint",
+	"sub_sequence_number",
+	"pending_checkpoint",
+	"parent_shard_id",
+	"checkpoint_metadata",
+	"claim_request",
+}
+
+type (
+	// SQLDialect specifies the SQL syntax of the database used by SQLCheckpoint
+	SQLDialect int
+
+	// SQLCheckpoint implements the Checkpoint interface using a PostgreSQL or MySQL table as a backend.
+	// Leases are acquired with conditional updates on the current lease owner and lease timeout.
+	SQLCheckpoint struct {
+		log       logger.Logger
+		TableName string
+
+		LeaseDuration int
+		db            *sql.DB
+		dialect       SQLDialect
+		kclConfig     *config.KinesisClientLibConfiguration
+		lastLeaseSync time.Time
+	}
+
+	// sqlLease is a row of the lease table
+	sqlLease struct {
+		assignedTo         sql.NullString
+		leaseTimeout       sql.NullString
+		checkpoint         sql.NullString
+		subSequenceNumber  sql.NullInt64
+		pendingCheckpoint  sql.NullString
+		parentShardId      sql.NullString
+		checkpointMetadata sql.NullString
+		claimRequest       sql.NullString
+	}
+)
+
+func NewSQLCheckpoint(kclConfig *config.KinesisClientLibConfiguration) *SQLCheckpoint {
+	dialect := PostgreSQLDialect
+	if strings.HasPrefix(kclConfig.SQLDriverName, "mysql") {
+		dialect = MySQLDialect
+	}
+
+	checkpointer := &SQLCheckpoint{
+		log:           kclConfig.Logger.WithFields(logger.Fields{"workerID": kclConfig.WorkerID}),
+		TableName:     kclConfig.TableName,
+		LeaseDuration: kclConfig.FailoverTimeMillis,
+		dialect:       dialect,
+		kclConfig:     kclConfig,
+	}
+
+	return checkpointer
+}
+
+// WithDB is used to provide an already opened database of the given dialect
+func (checkpointer *SQLCheckpoint) WithDB(db *sql.DB, dialect SQLDialect) *SQLCheckpoint {
+	checkpointer.db = db
+	checkpointer.dialect = dialect
+	return checkpointer
+}
+
+// Init initialises the SQL Checkpoint
+func (checkpointer *SQLCheckpoint) Init() error {
+	if err := checkpointer.Open(); err != nil {
+		return err
+	}
+
+	return checkpointer.Migrate()
+}
+
+// Open opens the database of the SQL Checkpoint without creating or migrating the lease table
+func (checkpointer *SQLCheckpoint) Open() error {
+	checkpointer.log.Infof("Opening %s database", checkpointer.kclConfig.SQLDriverName)
+
+	if checkpointer.db == nil {
+		db, err := sql.Open(checkpointer.kclConfig.SQLDriverName, checkpointer.kclConfig.SQLDataSourceName)
+		if err != nil {
+			return err
+		}
+		checkpointer.db = db
+	}
+
+	return checkpointer.db.PingContext(context.Background())
+}
+
+// Migrate creates the lease table or upgrades it to the schema expected by this version of the library.
+// It is called by Init, applications which manage the database schema separately can call it from their
+// own migration tooling instead.
+func (checkpointer *SQLCheckpoint) Migrate() error {
+	ctx := context.Background()
+	versionTable := checkpointer.dialect.quote(checkpointer.TableName + "_schema_version")
+
+	if _, err := checkpointer.db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+versionTable+" (version INT NOT NULL)"); err != nil {
+		return err
+	}
+
+	var version sql.NullInt64
+	if err := checkpointer.db.QueryRowContext(ctx, "SELECT MAX(version) FROM "+versionTable).Scan(&version); err != nil {
+		return err
+	}
+
+	for i := int(version.Int64); i < len(sqlMigrations); i++ {
+		checkpointer.log.Infof("Migrating lease table %s to schema version %d", checkpointer.TableName, i+1)
+		if _, err := checkpointer.db.ExecContext(ctx, fmt.Sprintf(sqlMigrations[i], checkpointer.table())); err != nil {
+			return err
+		}
+
+		if _, err := checkpointer.exec("INSERT INTO "+versionTable+" (version) VALUES (?)", i+1); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetLease attempts to gain a lock on the given shard
+func (checkpointer *SQLCheckpoint) GetLease(shard *par.ShardStatus, newAssignTo string) error {
+	newLeaseTimeout := time.Now().Add(time.Duration(checkpointer.LeaseDuration) * time.Millisecond).UTC()
+	newLeaseTimeoutString := newLeaseTimeout.Format(time.RFC3339Nano)
+	currentCheckpoint, err := checkpointer.getItem(shard.ID)
+	if err != nil {
+		return err
+	}
+
+	isClaimRequestExpired := shard.IsClaimRequestExpired(checkpointer.kclConfig)
+
+	var claimRequest string
+	if checkpointer.kclConfig.EnableLeaseStealing && currentCheckpoint != nil {
+		if currentCheckpoint.claimRequest.String != "" {
+			claimRequest = currentCheckpoint.claimRequest.String
+			if newAssignTo != claimRequest && !isClaimRequestExpired {
+				checkpointer.log.Debugf("another worker: %s has a claim on this shard. Not going to renew the lease", claimRequest)
+				shard.SetClaimRequest(claimRequest)
+				return ErrShardAlreadyClaimed
+			}
+		}
+	}
+
+	// writing a lease clears the claim request, the same way DynamoDB PutItem does
+	values := checkpointer.leaseValues(shard, newAssignTo, newLeaseTimeoutString, "")
+
+	// the shard has never been leased before
+	if currentCheckpoint == nil {
+		inserted, err := checkpointer.exec(checkpointer.dialect.insertIgnore(checkpointer.table(), sqlLeaseColumns), values...)
+		if err != nil {
+			return err
+		}
+		if !inserted {
+			return ErrLeaseNotAcquired{cause: "lease has been acquired by another worker"}
+		}
+	} else {
+		var conditions []string
+		var conditionValues []interface{}
+
+		if !currentCheckpoint.leaseTimeout.Valid || !currentCheckpoint.assignedTo.Valid {
+			conditions = append(conditions, "assigned_to IS NULL")
+		} else {
+			assignedTo := currentCheckpoint.assignedTo.String
+			leaseTimeout := currentCheckpoint.leaseTimeout.String
+
+			currentLeaseTimeout, err := time.Parse(time.RFC3339Nano, leaseTimeout)
+			if err != nil {
+				return err
+			}
+
+			if checkpointer.kclConfig.EnableLeaseStealing {
+				if time.Now().UTC().Before(currentLeaseTimeout) && assignedTo != newAssignTo && !isClaimRequestExpired {
+					return ErrLeaseNotAcquired{cause: "current lease timeout not yet expired"}
+				}
+			} else {
+				if time.Now().UTC().Before(currentLeaseTimeout) && assignedTo != newAssignTo {
+					return ErrLeaseNotAcquired{cause: "current lease timeout not yet expired"}
+				}
+			}
+
+			checkpointer.log.Debugf("Attempting to get a lock for shard: %s, leaseTimeout: %s, assignedTo: %s, newAssignedTo: %s", shard.ID, currentLeaseTimeout, assignedTo, newAssignTo)
+			conditions = append(conditions, "assigned_to = ?", "lease_timeout = ?")
+			conditionValues = append(conditionValues, assignedTo, leaseTimeout)
+		}
+
+		if checkpointer.kclConfig.EnableLeaseStealing {
+			if claimRequest != "" && claimRequest == newAssignTo && !isClaimRequestExpired {
+				conditions = append(conditions, "claim_request = ?")
+				conditionValues = append(conditionValues, claimRequest)
+			}
+		}
+
+		err = checkpointer.conditionalUpdate(shard.ID, conditions, conditionValues, values)
+		if err != nil {
+			if errors.Is(err, ErrConditionalCheckFailed) {
+				return ErrLeaseNotAcquired{cause: err.Error(), err: err}
+			}
+			return err
+		}
+	}
+
+	shard.Mux.Lock()
+	shard.AssignedTo = newAssignTo
+	shard.LeaseTimeout = newLeaseTimeout
+	// the lease has been written without the claim request
+	shard.ClaimRequest = ""
+	shard.Mux.Unlock()
+
+	return nil
+}
+
+// CheckpointSequence writes a checkpoint at the designated sequence ID
+func (checkpointer *SQLCheckpoint) CheckpointSequence(shard *par.ShardStatus) error {
+	leaseTimeout := shard.GetLeaseTimeout().UTC().Format(time.RFC3339Nano)
+
+	// The whole row is replaced, so the checkpoint and the pending checkpoint are committed
+	// (or cleared) atomically.
+	if !checkpointer.kclConfig.EnableLeaseStealing {
+		values := checkpointer.leaseValues(shard, shard.GetLeaseOwner(), leaseTimeout, "")
+		_, err := checkpointer.exec(checkpointer.dialect.upsert(checkpointer.table(), sqlLeaseColumns), values...)
+		return err
+	}
+
+	err := checkpointer.saveClaimedItem(shard, leaseTimeout, shard.GetClaimRequest())
+	if !errors.Is(err, ErrConditionalCheckFailed) {
+		return err
+	}
+
+	currentCheckpoint, err := checkpointer.getItem(shard.ID)
+	if err != nil {
+		return err
+	}
+
+	// the shard has never been leased before, so it cannot have been claimed
+	if currentCheckpoint == nil {
+		values := checkpointer.leaseValues(shard, shard.GetLeaseOwner(), leaseTimeout, "")
+		inserted, err := checkpointer.exec(checkpointer.dialect.insertIgnore(checkpointer.table(), sqlLeaseColumns), values...)
+		if err != nil {
+			return err
+		}
+		if !inserted {
+			return ErrConditionalCheckFailed
+		}
+		return nil
+	}
+
+	claimRequest := currentCheckpoint.claimRequest.String
+	shard.SetClaimRequest(claimRequest)
+
+	return checkpointer.saveClaimedItem(shard, leaseTimeout, claimRequest)
+}
+
+// saveClaimedItem replaces the lease with the checkpoint of the shard and the claim request, if the claim request of
+// the stored lease is the same
+func (checkpointer *SQLCheckpoint) saveClaimedItem(shard *par.ShardStatus, leaseTimeout, claimRequest string) error {
+	conditions := []string{"claim_request IS NULL"}
+	var conditionValues []interface{}
+	if claimRequest != "" {
+		conditions = []string{"claim_request = ?"}
+		conditionValues = []interface{}{claimRequest}
+	}
+
+	values := checkpointer.leaseValues(shard, shard.GetLeaseOwner(), leaseTimeout, claimRequest)
+	return checkpointer.conditionalUpdate(shard.ID, conditions, conditionValues, values)
+}
+
+// FetchCheckpoint retrieves the checkpoint for the given shard
+func (checkpointer *SQLCheckpoint) FetchCheckpoint(shard *par.ShardStatus) error {
+	checkpoint, err := checkpointer.getItem(shard.ID)
+	if err != nil {
+		return err
+	}
+
+	if checkpoint == nil {
+		shard.SetPendingCheckpoint("")
+		shard.SetClaimRequest("")
+		shard.SetCheckpointMetadata(nil)
+		return ErrSequenceIDNotFound
+	}
+
+	// a checkpoint may have been prepared before anything was committed
+	shard.SetPendingCheckpoint(checkpoint.pendingCheckpoint.String)
+
+	// another worker may be attempting to steal the shard
+	shard.SetClaimRequest(checkpoint.claimRequest.String)
+
+	metadata, err := decodeCheckpointMetadata(checkpoint.checkpointMetadata.String)
+	if err != nil {
+		return err
+	}
+	shard.SetCheckpointMetadata(metadata)
+
+	if !checkpoint.checkpoint.Valid {
+		return ErrSequenceIDNotFound
+	}
+
+	checkpointer.log.Debugf("Retrieved Shard Iterator %s", checkpoint.checkpoint.String)
+	shard.SetCheckpoint(checkpoint.checkpoint.String)
+
+	if checkpoint.subSequenceNumber.Valid {
+		subSequence := checkpoint.subSequenceNumber.Int64
+		shard.SetSubSequenceNumber(&subSequence)
+	} else {
+		shard.SetSubSequenceNumber(nil)
+	}
+
+	if checkpoint.assignedTo.Valid {
+		shard.SetLeaseOwner(checkpoint.assignedTo.String)
+	}
+
+	// Use up-to-date leaseTimeout to avoid a failed conditional update when claiming
+	if checkpoint.leaseTimeout.String != "" {
+		currentLeaseTimeout, err := time.Parse(time.RFC3339Nano, checkpoint.leaseTimeout.String)
+		if err != nil {
+			return err
+		}
+		shard.LeaseTimeout = currentLeaseTimeout
+	}
+
+	return nil
+}
+
+// RemoveLeaseInfo to remove lease info for shard entry in the lease table because the shard no longer exists in Kinesis
+func (checkpointer *SQLCheckpoint) RemoveLeaseInfo(shardID string) error {
+	_, err := checkpointer.exec("DELETE FROM "+checkpointer.table()+" WHERE shard_id = ?", shardID)
+
+	if err != nil {
+		checkpointer.log.Errorf("Error in removing lease info for shard: %s, Error: %+v", shardID, err)
+	} else {
+		checkpointer.log.Infof("Lease info for shard: %s has been removed.", shardID)
+	}
+
+	return err
+}
+
+// RemoveLeaseOwner to remove lease owner for the shard entry
+func (checkpointer *SQLCheckpoint) RemoveLeaseOwner(shardID string) error {
+	updated, err := checkpointer.exec("UPDATE "+checkpointer.table()+" SET assigned_to = NULL WHERE shard_id = ? AND assigned_to = ?",
+		shardID, checkpointer.kclConfig.WorkerID)
+	if err != nil {
+		return err
+	}
+
+	if !updated {
+		return ErrConditionalCheckFailed
+	}
+
+	return nil
+}
+
+// ReleaseLease removes the lease owner of a shard whichever worker holds the lease
+func (checkpointer *SQLCheckpoint) ReleaseLease(shardID string) error {
+	currentCheckpoint, err := checkpointer.getItem(shardID)
+	if err != nil {
+		return err
+	}
+	if currentCheckpoint == nil {
+		return ErrLeaseNotFound
+	}
+
+	_, err = checkpointer.exec("UPDATE "+checkpointer.table()+" SET assigned_to = NULL WHERE shard_id = ?", shardID)
+	return err
+}
+
+// ListLeases returns the leases of all shards in the lease table ordered by shard ID
+func (checkpointer *SQLCheckpoint) ListLeases() ([]*par.ShardStatus, error) {
+	rows, err := checkpointer.db.QueryContext(context.Background(), "SELECT shard_id FROM "+checkpointer.table())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var shardIDs []string
+	for rows.Next() {
+		var shardID string
+		if err := rows.Scan(&shardID); err != nil {
+			return nil, err
+		}
+		shardIDs = append(shardIDs, shardID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return fetchLeases(checkpointer, shardIDs)
+}
+
+// DeleteLeaseTable drops the lease table
+func (checkpointer *SQLCheckpoint) DeleteLeaseTable() error {
+	_, err := checkpointer.db.ExecContext(context.Background(), "DROP TABLE IF EXISTS "+checkpointer.table())
+	return err
+}
+
+// GetLeaseOwner returns current lease owner of given shard in checkpoints table
+func (checkpointer *SQLCheckpoint) GetLeaseOwner(shardID string) (string, error) {
+	currentCheckpoint, err := checkpointer.getItem(shardID)
+	if err != nil {
+		return "", err
+	}
+
+	if currentCheckpoint == nil || !currentCheckpoint.assignedTo.Valid {
+		return "", NoLeaseOwnerErr
+	}
+
+	return currentCheckpoint.assignedTo.String, nil
+}
+
+// ListActiveWorkers returns a map of workers and their shards
+func (checkpointer *SQLCheckpoint) ListActiveWorkers(shardStatus map[string]*par.ShardStatus) (map[string][]*par.ShardStatus, error) {
+	err := checkpointer.syncLeases(shardStatus)
+	if err != nil {
+		return nil, err
+	}
+
+	workers := map[string][]*par.ShardStatus{}
+	for _, shard := range shardStatus {
+		if shard.GetCheckpoint() == ShardEnd {
+			continue
+		}
+
+		leaseOwner := shard.GetLeaseOwner()
+		if leaseOwner == "" {
+			checkpointer.log.Debugf("Shard Not Assigned Error. ShardID: %s, WorkerID: %s", shard.ID, checkpointer.kclConfig.WorkerID)
+			return nil, ErrShardNotAssigned
+		}
+
+		workers[leaseOwner] = append(workers[leaseOwner], shard)
+	}
+	return workers, nil
+}
+
+// ClaimShard places a claim request on a shard to signal a steal attempt
+func (checkpointer *SQLCheckpoint) ClaimShard(shard *par.ShardStatus, claimID string) error {
+	err := checkpointer.FetchCheckpoint(shard)
+	if err != nil && !errors.Is(err, ErrSequenceIDNotFound) {
+		return err
+	}
+	leaseTimeoutString := shard.GetLeaseTimeout().Format(time.RFC3339Nano)
+
+	query := "UPDATE " + checkpointer.table() + " SET claim_request = ? WHERE shard_id = ? AND lease_timeout = ? AND claim_request IS NULL"
+	args := []interface{}{claimID, shard.ID, leaseTimeoutString}
+
+	if leaseOwner := shard.GetLeaseOwner(); leaseOwner == "" {
+		query += " AND assigned_to IS NULL"
+	} else {
+		query += " AND assigned_to = ?"
+		args = append(args, leaseOwner)
+	}
+
+	if checkpoint := shard.GetCheckpoint(); checkpoint == "" {
+		query += " AND checkpoint IS NULL"
+	} else if checkpoint == ShardEnd {
+		query += " AND checkpoint <> ?"
+		args = append(args, ShardEnd)
+	} else {
+		query += " AND checkpoint = ?"
+		args = append(args, checkpoint)
+	}
+
+	if shard.ParentShardId == "" {
+		query += " AND parent_shard_id IS NULL"
+	} else {
+		query += " AND parent_shard_id = ?"
+		args = append(args, shard.ParentShardId)
+	}
+
+	updated, err := checkpointer.exec(query, args...)
+	if err != nil {
+		return err
+	}
+
+	if !updated {
+		return ErrConditionalCheckFailed
+	}
+
+	return nil
+}
+
+func (checkpointer *SQLCheckpoint) syncLeases(shardStatus map[string]*par.ShardStatus) error {
+	log := checkpointer.log
+
+	if (checkpointer.lastLeaseSync.Add(time.Duration(checkpointer.kclConfig.LeaseSyncingTimeIntervalMillis) * time.Millisecond)).After(time.Now()) {
+		return nil
+	}
+
+	checkpointer.lastLeaseSync = time.Now()
+	rows, err := checkpointer.db.QueryContext(context.Background(),
+		"SELECT shard_id, assigned_to, checkpoint FROM "+checkpointer.table()+" WHERE assigned_to IS NOT NULL AND checkpoint IS NOT NULL")
+	if err != nil {
+		log.Debugf("Error performing SQL query. Error: %+v ", err)
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var shardID, assignedTo, checkpoint string
+		if err := rows.Scan(&shardID, &assignedTo, &checkpoint); err != nil {
+			return err
+		}
+
+		if shard, ok := shardStatus[shardID]; ok {
+			shard.SetLeaseOwner(assignedTo)
+			shard.SetCheckpoint(checkpoint)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Debugf("Error performing SyncLeases. Error: %+v ", err)
+		return err
+	}
+	log.Debugf("Lease sync completed. Next lease sync will occur in %s", time.Duration(checkpointer.kclConfig.LeaseSyncingTimeIntervalMillis)*time.Millisecond)
+	return nil
+}
+
+// leaseValues returns the values of sqlLeaseColumns for the given shard
+func (checkpointer *SQLCheckpoint) leaseValues(shard *par.ShardStatus, assignedTo, leaseTimeout, claimRequest string) []interface{} {
+	var subSequenceNumber interface{}
+	if s := shard.GetSubSequenceNumber(); s != nil {
+		subSequenceNumber = *s
+	}
+
+	return []interface{}{
+		shard.ID,
+		nullString(assignedTo),
+		leaseTimeout,
+		nullString(shard.GetCheckpoint()),
+		subSequenceNumber,
+		nullString(shard.GetPendingCheckpoint()),
+		nullString(shard.ParentShardId),
+		nullString(encodeCheckpointMetadata(shard.GetCheckpointMetadata())),
+		nullString(claimRequest),
+	}
+}
+
+// conditionalUpdate replaces the lease of a shard if the conditions are met
+func (checkpointer *SQLCheckpoint) conditionalUpdate(shardID string, conditions []string, conditionValues []interface{}, values []interface{}) error {
+	assignments := make([]string, 0, len(sqlLeaseColumns)-1)
+	for _, column := range sqlLeaseColumns[1:] {
+		assignments = append(assignments, column+" = ?")
+	}
+
+	query := "UPDATE " + checkpointer.table() + " SET " + strings.Join(assignments, ", ") + " WHERE " +
+		strings.Join(append([]string{"shard_id = ?"}, conditions...), " AND ")
+
+	args := append(append(values[1:len(values):len(values)], shardID), conditionValues...)
+	updated, err := checkpointer.exec(query, args...)
+	if err != nil {
+		return err
+	}
+
+	if !updated {
+		return ErrConditionalCheckFailed
+	}
+
+	return nil
+}
+
+// exec runs a statement written with ? placeholders and reports whether it affected any row
+func (checkpointer *SQLCheckpoint) exec(query string, args ...interface{}) (bool, error) {
+	result, err := checkpointer.db.ExecContext(context.Background(), checkpointer.dialect.rebind(query), args...)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
+
+func (checkpointer *SQLCheckpoint) getItem(shardID string) (*sqlLease, error) {
+	lease := &sqlLease{}
+	err := checkpointer.db.QueryRowContext(context.Background(),
+		checkpointer.dialect.rebind("SELECT "+strings.Join(sqlLeaseColumns[1:], ", ")+" FROM "+checkpointer.table()+" WHERE shard_id = ?"),
+		shardID).Scan(
+		&lease.assignedTo,
+		&lease.leaseTimeout,
+		&lease.checkpoint,
+		&lease.subSequenceNumber,
+		&lease.pendingCheckpoint,
+		&lease.parentShardId,
+		&lease.checkpointMetadata,
+		&lease.claimRequest,
+	)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return lease, nil
+}
+
+func (checkpointer *SQLCheckpoint) table() string {
+	return checkpointer.dialect.quote(checkpointer.TableName)
+}
+
+func (d SQLDialect) quote(identifier string) string {
+	if d == MySQLDialect {
+		return "`" + strings.ReplaceAll(identifier, "`", "``") + "`"
+	}
+	return `"` + strings.ReplaceAll(identifier, `"`, `""`) + `"`
+}
+
+// rebind replaces the ? placeholders of a query with the positional placeholders of PostgreSQL
+func (d SQLDialect) rebind(query string) string {
+	if d == MySQLDialect {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// insertIgnore returns a statement which inserts a row unless a row with the same key already exists
+func (d SQLDialect) insertIgnore(table string, columns []string) string {
+	if d == MySQLDialect {
+		return "INSERT IGNORE INTO " + table + insertColumns(columns)
+	}
+	return "INSERT INTO " + table + insertColumns(columns) + " ON CONFLICT (" + columns[0] + ") DO NOTHING"
+}
+
+// upsert returns a statement which inserts a row or replaces the row with the same key
+func (d SQLDialect) upsert(table string, columns []string) string {
+	assignments := make([]string, 0, len(columns)-1)
+	for _, column := range columns[1:] {
+		if d == MySQLDialect {
+			assignments = append(assignments, column+" = VALUES("+column+")")
+		} else {
+			assignments = append(assignments, column+" = EXCLUDED."+column)
+		}
+	}
+
+	if d == MySQLDialect {
+		return "INSERT INTO " + table + insertColumns(columns) + " ON DUPLICATE KEY UPDATE " + strings.Join(assignments, ", ")
+	}
+	return "INSERT INTO " + table + insertColumns(columns) + " ON CONFLICT (" + columns[0] + ") DO UPDATE SET " + strings.Join(assignments, ", ")
+}
+
+func insertColumns(columns []string) string {
+	return " (" + strings.Join(columns, ", ") + ") VALUES (?" + strings.Repeat(", ?", len(columns)-1) + ")"
+}
+
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,224 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package checkpoint
+
+import (
+	"errors"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+
+	cfg "github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+var sqlLeaseRowColumns = sqlLeaseColumns[1:]
+
+func newTestSQLCheckpoint(t *testing.T, dialect SQLDialect) (*SQLCheckpoint, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	assert.Nil(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	kclConfig := cfg.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithFailoverTimeMillis(300000)
+	return NewSQLCheckpoint(kclConfig).WithDB(db, dialect), mock
+}
+
+func TestSQLMigrate(t *testing.T) {
+	checkpoint, mock := newTestSQLCheckpoint(t, PostgreSQLDialect)
+
+	mock.ExpectExec(regexp.QuoteMeta(`CREATE TABLE IF NOT EXISTS "appName_schema_version"`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT MAX(version) FROM "appName_schema_version"`)).
+		WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(nil))
+	mock.ExpectExec(regexp.QuoteMeta(`CREATE TABLE IF NOT EXISTS "appName"`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "appName_schema_version" (version) VALUES ($1)`)).
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`ALTER TABLE "appName" ADD COLUMN checkpoint_metadata TEXT`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "appName_schema_version" (version) VALUES ($1)`)).
+		WithArgs(2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.Nil(t, checkpoint.Migrate())
+
+	// nothing to do once the schema is up-to-date
+	mock.ExpectExec(regexp.QuoteMeta(`CREATE TABLE IF NOT EXISTS "appName_schema_version"`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT MAX(version) FROM "appName_schema_version"`)).
+		WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(len(sqlMigrations)))
+	assert.Nil(t, checkpoint.Migrate())
+
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestSQLGetLeaseNewShard(t *testing.T) {
+	checkpoint, mock := newTestSQLCheckpoint(t, MySQLDialect)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT assigned_to, lease_timeout, checkpoint, sub_sequence_number, pending_checkpoint, parent_shard_id, checkpoint_metadata, claim_request FROM `appName` WHERE shard_id = ?")).
+		WithArgs("0001").
+		WillReturnRows(sqlmock.NewRows(sqlLeaseRowColumns))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT IGNORE INTO `appName` (shard_id, assigned_to")).
+		WithArgs("0001", "abcd-efgh", sqlmock.AnyArg(), nil, nil, nil, nil, nil, nil).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	shard := &par.ShardStatus{
+		ID:  "0001",
+		Mux: &sync.RWMutex{},
+	}
+	err := checkpoint.GetLease(shard, "abcd-efgh")
+	assert.Nil(t, err)
+	assert.Equal(t, "abcd-efgh", shard.GetLeaseOwner())
+
+	// another worker inserted the lease in the meantime
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT assigned_to")).
+		WithArgs("0001").
+		WillReturnRows(sqlmock.NewRows(sqlLeaseRowColumns))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT IGNORE INTO `appName`")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	err = checkpoint.GetLease(shard, "ijkl-mnop")
+	if err == nil || !errors.As(err, &ErrLeaseNotAcquired{}) {
+		t.Errorf("Got a lease when it was already held by abcd-efgh: %s", err)
+	}
+
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestSQLGetLeaseConditional(t *testing.T) {
+	checkpoint, mock := newTestSQLCheckpoint(t, PostgreSQLDialect)
+	leaseTimeout := time.Now().AddDate(0, -1, 0).UTC().Format(time.RFC3339Nano)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT assigned_to, lease_timeout, checkpoint, sub_sequence_number, pending_checkpoint, parent_shard_id, checkpoint_metadata, claim_request FROM "appName" WHERE shard_id = $1`)).
+		WithArgs("0001").
+		WillReturnRows(sqlmock.NewRows(sqlLeaseRowColumns).AddRow("abcd-efgh", leaseTimeout, "deadbeef", nil, nil, nil, nil, nil))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "appName" SET assigned_to = $1, lease_timeout = $2, checkpoint = $3, sub_sequence_number = $4, pending_checkpoint = $5, parent_shard_id = $6, checkpoint_metadata = $7, claim_request = $8 WHERE shard_id = $9 AND assigned_to = $10 AND lease_timeout = $11`)).
+		WithArgs("ijkl-mnop", sqlmock.AnyArg(), "deadbeef", nil, nil, nil, nil, nil, "0001", "abcd-efgh", leaseTimeout).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	shard := &par.ShardStatus{
+		ID:         "0001",
+		Checkpoint: "deadbeef",
+		Mux:        &sync.RWMutex{},
+	}
+	err := checkpoint.GetLease(shard, "ijkl-mnop")
+	if err == nil || !errors.As(err, &ErrLeaseNotAcquired{}) {
+		t.Errorf("Got a lease when the conditional update failed: %s", err)
+	}
+	assert.Equal(t, "", shard.GetLeaseOwner())
+
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestSQLCheckpointSequence(t *testing.T) {
+	checkpoint, mock := newTestSQLCheckpoint(t, PostgreSQLDialect)
+	leaseTimeout := time.Now().Add(time.Minute).UTC()
+
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "appName" (shard_id, assigned_to, lease_timeout, checkpoint, sub_sequence_number, pending_checkpoint, parent_shard_id, checkpoint_metadata, claim_request) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) ON CONFLICT (shard_id) DO UPDATE SET assigned_to = EXCLUDED.assigned_to`)).
+		WithArgs("0001", "abcd-efgh", leaseTimeout.Format(time.RFC3339Nano), "deadbeef", int64(2), nil, "0000", "bWV0YQ==", nil).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	err := checkpoint.CheckpointSequence(&par.ShardStatus{
+		ID:                 "0001",
+		ParentShardId:      "0000",
+		Checkpoint:         "deadbeef",
+		SubSequenceNumber:  aws.Int64(2),
+		CheckpointMetadata: []byte("meta"),
+		AssignedTo:         "abcd-efgh",
+		LeaseTimeout:       leaseTimeout,
+		Mux:                &sync.RWMutex{},
+	})
+	assert.Nil(t, err)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT assigned_to`)).
+		WithArgs("0001").
+		WillReturnRows(sqlmock.NewRows(sqlLeaseRowColumns).AddRow("abcd-efgh", leaseTimeout.Format(time.RFC3339Nano), "deadbeef", 2, nil, "0000", "bWV0YQ==", nil))
+
+	status := &par.ShardStatus{
+		ID:  "0001",
+		Mux: &sync.RWMutex{},
+	}
+	err = checkpoint.FetchCheckpoint(status)
+	assert.Nil(t, err)
+	assert.Equal(t, "deadbeef", status.GetCheckpoint())
+	assert.Equal(t, int64(2), *status.GetSubSequenceNumber())
+	assert.Equal(t, []byte("meta"), status.GetCheckpointMetadata())
+	assert.Equal(t, "abcd-efgh", status.GetLeaseOwner())
+	assert.True(t, leaseTimeout.Equal(status.GetLeaseTimeout()))
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT assigned_to`)).
+		WithArgs("0002").
+		WillReturnRows(sqlmock.NewRows(sqlLeaseRowColumns))
+	assert.Equal(t, ErrSequenceIDNotFound, checkpoint.FetchCheckpoint(&par.ShardStatus{ID: "0002", Mux: &sync.RWMutex{}}))
+
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestSQLCheckpointSequenceClaimed(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.Nil(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+	kclConfig := cfg.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithLeaseStealing(true).
+		WithFailoverTimeMillis(300000)
+	checkpoint := NewSQLCheckpoint(kclConfig).WithDB(db, PostgreSQLDialect)
+	leaseTimeout := time.Now().Add(time.Minute).UTC().Format(time.RFC3339Nano)
+	update := regexp.QuoteMeta(`UPDATE "appName" SET assigned_to = $1, lease_timeout = $2, checkpoint = $3, sub_sequence_number = $4, pending_checkpoint = $5, parent_shard_id = $6, checkpoint_metadata = $7, claim_request = $8 WHERE shard_id = $9 AND `)
+
+	// the claim request placed after the last sync is read and kept by the checkpoint
+	mock.ExpectExec(update+regexp.QuoteMeta(`claim_request IS NULL`)).
+		WithArgs("abcd-efgh", leaseTimeout, "deadbeef", nil, nil, nil, nil, nil, "0001").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT assigned_to`)).
+		WithArgs("0001").
+		WillReturnRows(sqlmock.NewRows(sqlLeaseRowColumns).AddRow("abcd-efgh", leaseTimeout, "cafebabe", nil, nil, nil, nil, "ijkl-mnop"))
+	mock.ExpectExec(update+regexp.QuoteMeta(`claim_request = $10`)).
+		WithArgs("abcd-efgh", leaseTimeout, "deadbeef", nil, nil, nil, nil, "ijkl-mnop", "0001", "ijkl-mnop").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	shard := &par.ShardStatus{ID: "0001", Checkpoint: "deadbeef", AssignedTo: "abcd-efgh", Mux: &sync.RWMutex{}}
+	shard.LeaseTimeout, _ = time.Parse(time.RFC3339Nano, leaseTimeout)
+	assert.Nil(t, checkpoint.CheckpointSequence(shard))
+	assert.Equal(t, "ijkl-mnop", shard.GetClaimRequest())
+
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestSQLRemoveLeaseOwner(t *testing.T) {
+	checkpoint, mock := newTestSQLCheckpoint(t, MySQLDialect)
+
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `appName` SET assigned_to = NULL WHERE shard_id = ? AND assigned_to = ?")).
+		WithArgs("0001", "abc").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.Nil(t, checkpoint.RemoveLeaseOwner("0001"))
+
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `appName` SET assigned_to = NULL")).
+		WithArgs("0001", "abc").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	assert.Equal(t, ErrConditionalCheckFailed, checkpoint.RemoveLeaseOwner("0001"))
+
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
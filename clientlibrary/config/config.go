@@ -37,14 +37,25 @@
 package config
 
 import (
+	"context"
 	"log"
 	"math"
+	"net/http"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	ktypes "github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/deadletter"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/decoder"
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/leaseaudit"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/leasecoordinator"
 	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/metrics"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/transformer"
 	"github.com/vmware/vmware-go-kcl-v2/logger"
 )
 
@@ -55,6 +66,9 @@ const (
 	TRIM_HORIZON
 	// AT_TIMESTAMP start from the record at or after the specified server-side Timestamp.
 	AT_TIMESTAMP
+	// AT_SEQUENCE_NUMBER start from the record with the specified sequence number of a shard. The shards without
+	// sequence number start from the oldest available data record.
+	AT_SEQUENCE_NUMBER
 
 	// DefaultInitialPositionInStream The location in the shard from which the KinesisClientLibrary will start fetching records from
 	// when the application starts for the first time and there is no checkpoint for the shard.
@@ -76,6 +90,10 @@ const (
 	// should sleep if no records are returned from the call to
 	DefaultIdleTimeBetweenReadsMillis = 1000
 
+	// DefaultPollingLagThresholdMillis The polling shard consumer polls without delay while it is at least
+	// 10 seconds behind the tip of the shard.
+	DefaultPollingLagThresholdMillis = 10000
+
 	// DefaultDontCallProcessRecordsForEmptyRecordList Don't call processRecords() on the record processor for empty record lists.
 	DefaultDontCallProcessRecordsForEmptyRecordList = false
 
@@ -111,6 +129,10 @@ const (
 	// but can cause higher churn in the system.
 	DefaultMaxLeasesToStealAtOneTime = 1
 
+	// DefaultMaxLeasesToAcquireAtOneTime Max available leases to acquire at every shard sync. A low number lets the
+	// leases spread over the workers starting at the same time instead of the first worker grabbing every shard.
+	DefaultMaxLeasesToAcquireAtOneTime = 1
+
 	// DefaultInitialLeaseTableReadCapacity The Amazon DynamoDB table used for tracking leases will be provisioned with this read capacity.
 	DefaultInitialLeaseTableReadCapacity = 10
 
@@ -125,20 +147,145 @@ const (
 	// DefaultShutdownGraceMillis The amount of milliseconds to wait before graceful shutdown forcefully terminates.
 	DefaultShutdownGraceMillis = 5000
 
+	// DefaultReclaimLeasesOnStartup The leases held by the worker ID before a restart are acquired like any other lease.
+	DefaultReclaimLeasesOnStartup = false
+
 	// DefaultEnableLeaseStealing Lease stealing defaults to false for backwards compatibility.
 	DefaultEnableLeaseStealing = false
 
 	// DefaultLeaseStealingIntervalMillis Interval between rebalance tasks defaults to 5 seconds.
 	DefaultLeaseStealingIntervalMillis = 5000
 
+	// DefaultCleanupLeasesUponShardCompletion The leases of completed shards are deleted once their child shards are processed.
+	DefaultCleanupLeasesUponShardCompletion = true
+
+	// DefaultLeaseCleanupIntervalMillis Interval between lease cleanup tasks defaults to 5 minutes.
+	DefaultLeaseCleanupIntervalMillis = 300000
+
 	// DefaultLeaseStealingClaimTimeoutMillis Number of milliseconds to wait before another worker can aquire a claimed shard
 	DefaultLeaseStealingClaimTimeoutMillis = 120000
 
+	// DefaultLeaseStealingHandoffTimeoutMillis The lease owner has 10 seconds to checkpoint and release a claimed shard.
+	DefaultLeaseStealingHandoffTimeoutMillis = 10000
+
 	// DefaultLeaseSyncingIntervalMillis Number of milliseconds to wait before syncing with lease table (dynamodDB)
 	DefaultLeaseSyncingIntervalMillis = 60000
 
+	// DefaultLeaseTableScanSegments The lease table is scanned sequentially by default
+	DefaultLeaseTableScanSegments = 1
+
+	// DefaultLeaseScanConsistency The leases are synced by eventually consistent scans, which consume half the read
+	// capacity of strongly consistent ones.
+	DefaultLeaseScanConsistency = EventuallyConsistent
+
+	// DefaultLeaseGetConsistency The lease of a shard is read strongly consistent before it is taken or checkpointed.
+	DefaultLeaseGetConsistency = StronglyConsistent
+
 	// DefaultMaxRetryCount The default maximum number of retries in case of error
 	DefaultMaxRetryCount = 5
+
+	// DefaultAsyncCheckpointIntervalMillis The pending asynchronous checkpoint of a shard is flushed every second.
+	DefaultAsyncCheckpointIntervalMillis = 1000
+
+	// DefaultEnableKPLDeaggregation The records published by the KPL are de-aggregated into user records.
+	DefaultEnableKPLDeaggregation = true
+
+	// DefaultLeaseTableBillingMode The Amazon DynamoDB table used for tracking leases is created with provisioned throughput.
+	DefaultLeaseTableBillingMode = types.BillingModeProvisioned
+
+	// DefaultProcessorPanicPolicy The lease of a shard is released if its record processor panics.
+	DefaultProcessorPanicPolicy = ReleaseLeaseOnPanic
+
+	// DefaultProcessorRestartBackoffMillis The initial backoff before restarting a panicked record processor is 1 second.
+	DefaultProcessorRestartBackoffMillis = 1000
+
+	// DefaultMaxProcessRecordsRetries A batch failed by the record processor is not retried by the shard consumer.
+	DefaultMaxProcessRecordsRetries = 0
+
+	// DefaultProcessRecordsErrorPolicy The shard consumer stops if a batch fails after the retries.
+	DefaultProcessRecordsErrorPolicy = HaltOnError
+
+	// DefaultRetryMaxAttempts The calls to Kinesis and DynamoDB are attempted at most 3 times.
+	DefaultRetryMaxAttempts = 3
+
+	// DefaultRetryBaseDelayMillis The delay before the first retry of a call to Kinesis or DynamoDB is 100 milliseconds.
+	DefaultRetryBaseDelayMillis = 100
+
+	// DefaultRetryMaxDelayMillis The delay before a retry of a call to Kinesis or DynamoDB is at most 20 seconds.
+	DefaultRetryMaxDelayMillis = 20000
+
+	// DefaultRetryJitter The delays before retries are randomized.
+	DefaultRetryJitter = true
+
+	// DefaultCheckpointBackend Leases and checkpoints are kept in DynamoDB unless configured otherwise.
+	DefaultCheckpointBackend = DynamoDBBackend
+
+	// DefaultAlarmEvaluationIntervalMillis The alarms of the shards are evaluated every 10 seconds.
+	DefaultAlarmEvaluationIntervalMillis = 10000
+
+	// DefaultShardMetricsSampleRatio The per-shard metrics are reported for all shards.
+	DefaultShardMetricsSampleRatio = 1.0
+
+	// DefaultHotShardsTopN The 5 shards with the highest throughput are reported as hot shards.
+	DefaultHotShardsTopN = 5
+)
+
+const (
+	// DynamoDBBackend stores leases and checkpoints in a DynamoDB table
+	DynamoDBBackend CheckpointBackend = iota + 1
+	// RedisBackend stores leases and checkpoints in Redis
+	RedisBackend
+	// SQLBackend stores leases and checkpoints in a PostgreSQL or MySQL table
+	SQLBackend
+	// MemoryBackend keeps leases and checkpoints in memory, optionally persisted to a JSON file. It is meant for
+	// tests and local development, the lease table is only shared by the workers of a process.
+	MemoryBackend
+)
+
+const (
+	// ReleaseLeaseOnPanic shuts down the shard consumer and releases the lease, so that the shard is leased again
+	// by any worker
+	ReleaseLeaseOnPanic ProcessorPanicPolicy = iota + 1
+	// RestartOnPanic restarts the shard consumer with a new record processor after an exponential backoff
+	RestartOnPanic
+	// CrashOnPanic panics again to crash the worker
+	CrashOnPanic
+)
+
+const (
+	// HaltOnError stops the shard consumer and releases the lease, so that the batch is delivered again from the
+	// last checkpoint by the next lease owner
+	HaltOnError ProcessRecordsErrorPolicy = iota + 1
+	// SkipOnError continues with the next batch, the failed batch is only processed again if the record
+	// processor has not checkpointed it
+	SkipOnError
+	// RetryOnError delivers the same batch again with an exponential backoff until it succeeds or the shard
+	// consumer is shut down
+	RetryOnError
+)
+
+const (
+	// EventuallyConsistent reads may return leases which have been modified shortly before
+	EventuallyConsistent ReadConsistency = iota + 1
+	// StronglyConsistent reads return the leases as last written, they consume twice the read capacity
+	StronglyConsistent
+)
+
+const (
+	// CheckpointAgeAlarm is raised when the checkpoint of a shard which is behind the tip of the shard has not
+	// advanced for CheckpointAgeAlarmMillis, e.g. because its record processor is stalled
+	CheckpointAgeAlarm AlarmType = "CheckpointAge"
+	// MillisBehindLatestAlarm is raised when a shard is more than MillisBehindLatestAlarmMillis behind its tip
+	MillisBehindLatestAlarm AlarmType = "MillisBehindLatest"
+)
+
+const (
+	// ApplyErrorPolicy continues with the retries, the dead-letter queue and the ProcessRecordsErrorPolicy as if
+	// there was no ProcessingFailedHandler
+	ApplyErrorPolicy ProcessingFailedAction = iota
+	// SkipFailedRecords checkpoints the failed records and continues with the next batch, e.g. once the handler has
+	// published the records to a dead-letter queue of the application
+	SkipFailedRecords
 )
 
 type (
@@ -146,6 +293,139 @@ type (
 	// This is used during initial application bootstrap (when a checkpoint doesn't exist for a shard or its parents)
 	InitialPositionInStream int
 
+	// CheckpointBackend Used to specify the storage of the lease table
+	CheckpointBackend int
+
+	// ProcessorPanicPolicy Used to specify how a shard consumer recovers from a panic of its record processor
+	ProcessorPanicPolicy int
+
+	// ProcessRecordsErrorPolicy Used to specify how a shard consumer continues after its record processor failed
+	// a batch of records
+	ProcessRecordsErrorPolicy int
+
+	// ReadConsistency Used to specify the consistency of the reads of the DynamoDB lease table
+	ReadConsistency int
+
+	// LeaseTableCapacity is the capacity of the DynamoDB lease table consumed by a call of the worker
+	LeaseTableCapacity struct {
+		// Operation is the API call, e.g. Scan or PutItem
+		Operation string
+
+		// ReadCapacityUnits are consumed by Scan and GetItem
+		ReadCapacityUnits float64
+
+		// WriteCapacityUnits are consumed by PutItem, UpdateItem and DeleteItem
+		WriteCapacityUnits float64
+	}
+
+	// LeaseTableCapacityHandler is called with the capacity consumed by each call of the worker to the DynamoDB lease
+	// table, e.g. to scale the provisioned capacity or to alarm when the lease table becomes hot. It must not block.
+	LeaseTableCapacityHandler func(capacity *LeaseTableCapacity)
+
+	// ProcessorPanicHandler is called with the recovered value and the stack trace when the record processor of
+	// a shard panics
+	ProcessorPanicHandler func(shardID string, recovered interface{}, stack []byte)
+
+	// DebugAuthorizer authorizes the requests of the debug server, the request is rejected with 403 Forbidden if an
+	// error is returned
+	DebugAuthorizer func(r *http.Request) error
+
+	// ProcessingFailedAction Used to specify how a shard consumer continues with a batch the record processor has
+	// failed MaxDeliveryAttempts times
+	ProcessingFailedAction int
+
+	// ProcessingFailedInput contains a batch failed by the record processor at least MaxDeliveryAttempts times
+	ProcessingFailedInput struct {
+		// ShardID is the shard of the records
+		ShardID string
+
+		// Records are the user records of the failed batch
+		Records []kcl.UserRecord
+
+		// AttemptCount is the number of times the batch has been delivered, at least MaxDeliveryAttempts
+		AttemptCount int
+
+		// Err is the error returned by the record processor
+		Err error
+	}
+
+	// ProcessingFailedHandler is called whenever the record processor of a shard failed a batch which has been
+	// delivered MaxDeliveryAttempts times or more, e.g. to skip poison records. The batch is delivered again unless
+	// SkipFailedRecords is returned.
+	ProcessingFailedHandler func(ctx context.Context, input *ProcessingFailedInput) ProcessingFailedAction
+
+	// CheckpointValidator is called before a checkpoint requested by a record processor is stored, with the current
+	// checkpoint of the shard and the requested one. A nil requested sequence number checkpoints the end of the
+	// shard. The checkpoint is rejected with the returned error, if any.
+	CheckpointValidator func(shardID string, current, requested *kcl.ExtendedSequenceNumber) error
+
+	// LagHandler is called when the MillisBehindLatest of a shard exceeds the lag threshold, e.g. to scale out the
+	// consumers or to raise an alert. It is called again once the lag has dropped to the threshold and exceeds it
+	// again. It is called by the shard consumers and should return quickly.
+	LagHandler func(shardID string, millisBehindLatest int64)
+
+	// AlarmType is the condition an Alarm is raised for
+	AlarmType string
+
+	// Alarm is raised when a shard consumed by the worker exceeds an alarm threshold, and cleared once the shard is
+	// back within the threshold or no longer consumed by the worker
+	Alarm struct {
+		Type AlarmType
+
+		// ShardID is the lease key of the shard
+		ShardID string
+
+		// Millis is the checkpoint age or the MillisBehindLatest of the shard when the alarm is raised or cleared
+		Millis int64
+
+		// ThresholdMillis is the threshold of the alarm
+		ThresholdMillis int64
+
+		// Raised is true when the alarm is raised and false when it is cleared
+		Raised bool
+	}
+
+	// AlarmHandler is called when an alarm of a shard is raised or cleared, e.g. to page an operator. It is called
+	// by the alarm evaluator of the worker every AlarmEvaluationIntervalMillis and should return quickly.
+	AlarmHandler func(alarm Alarm)
+
+	// HotShard is the throughput of a shard consumed by the worker over the last report interval
+	HotShard struct {
+		// ShardID is the lease key of the shard
+		ShardID string `json:"shardId"`
+
+		// StreamName is the stream of the shard in multi-stream mode
+		StreamName string `json:"streamName,omitempty"`
+
+		// StartingHashKey and EndingHashKey are the hash key range of the shard, a shard whose range is hot is split
+		// at a hash key within the range, a single hot hash key hints at a skewed partition key
+		StartingHashKey string `json:"startingHashKey"`
+		EndingHashKey   string `json:"endingHashKey"`
+
+		RecordsPerSecond float64 `json:"recordsPerSecond"`
+		BytesPerSecond   float64 `json:"bytesPerSecond"`
+	}
+
+	// HotShardsHandler is called with the HotShardsTopN shards with the highest throughput in bytes, ordered by
+	// descending throughput, every HotShardsReportIntervalMillis. It is called by the goroutine reporting the hot
+	// shards and should return quickly.
+	HotShardsHandler func(shards []HotShard)
+
+	// StreamProvider returns the names or ARNs of the streams consumed by a worker in multi-stream mode. It is called
+	// on every shard sync, so streams can be added to or removed from a running worker. The shards of a removed stream
+	// are no longer leased by the worker but their leases and checkpoints are kept.
+	StreamProvider func() ([]string, error)
+
+	// SequenceNumberTranslator translates the checkpoint of a shard of the named stream into the shard of the other
+	// stream of the failover holding the same records and the sequence number to continue from, e.g. by looking up
+	// a replication log. It is called with the primary stream at failover and with the secondary stream at failback.
+	// The checkpoint is not carried over if the returned shard ID is empty.
+	SequenceNumberTranslator func(streamName, shardID, sequenceNumber string) (translatedShardID, translatedSequenceNumber string, err error)
+
+	// WorkerIDProvider returns the ID of a worker, e.g. its hostname or the name of its pod. A stable ID keeps the
+	// leases of a worker across restarts instead of churning them with a random ID on every start.
+	WorkerIDProvider func() (string, error)
+
 	// InitialPositionInStreamExtended Class that houses the entities needed to specify the Position in the stream from where a new application should
 	// start.
 	InitialPositionInStreamExtended struct {
@@ -159,6 +439,10 @@ type (
 		// than the current trim horizon, the iterator returned is for the oldest untrimmed
 		// data record (TRIM_HORIZON).
 		Timestamp *time.Time `type:"Timestamp" timestampFormat:"unix"`
+
+		// The sequence numbers of the data records from which to start reading per shard id. Used with shard
+		// iterator type AT_SEQUENCE_NUMBER.
+		SequenceNumbers map[string]string
 	}
 
 	// KinesisClientLibConfiguration Configuration for the Kinesis Client Library.
@@ -175,18 +459,103 @@ type (
 		// If this is empty, the default generated endpoint will be used.
 		KinesisEndpoint string
 
+		// SkipTLSVerify disables the verification of the TLS certificates of the Kinesis and DynamoDB endpoints,
+		// e.g. for LocalStack with a self-signed certificate. It must not be used in production nor with an injected
+		// AWSConfig or HTTPClient.
+		SkipTLSVerify bool
+
+		// AWSConfig is an optional fully constructed AWS config used by the Kinesis and DynamoDB clients instead of
+		// RegionName, KinesisCredentials and DynamoDBCredentials, e.g. for SSO profiles, assume-role chains, custom
+		// HTTP clients or request middlewares.
+		AWSConfig *aws.Config
+
+		// HTTPClient is an optional HTTP client of the Kinesis and DynamoDB clients, e.g. with the client certificates
+		// of mTLS or the transport of a corporate proxy. It replaces the HTTP client of an injected AWSConfig.
+		HTTPClient aws.HTTPClient
+
+		// ProxyURL is an optional URL of the HTTP proxy the calls to Kinesis and DynamoDB are sent through, e.g.
+		// "http://proxy.example.com:3128", instead of the proxy of the environment, e.g. HTTPS_PROXY. It is not
+		// applied to an injected AWSConfig or HTTPClient.
+		ProxyURL string
+
+		// Retryer is an optional constructor of the retryer of the Kinesis and DynamoDB clients used instead of
+		// RetryPolicy, also by an injected AWSConfig. The throttled attempts are counted unless the retryer does not
+		// implement aws.RetryerV2.
+		Retryer func() aws.Retryer
+
+		// APICallTimeouts are the timeouts of the calls to Kinesis and DynamoDB by operation, e.g. "GetRecords" or
+		// "PutItem", including their retries. The calls of other operations are bounded by their context only.
+		// SubscribeToShard cannot time out, its events are read after the call has returned.
+		APICallTimeouts map[string]time.Duration
+
 		// KinesisCredentials is used to access Kinesis
 		KinesisCredentials aws.CredentialsProvider
 
 		// DynamoDBCredentials is used to access DynamoDB
 		DynamoDBCredentials aws.CredentialsProvider
 
+		// KinesisRoleARN is an optional IAM role assumed to access Kinesis, e.g. a role of the account of the stream
+		KinesisRoleARN string
+
+		// DynamoDBRoleARN is an optional IAM role assumed to access the lease table in DynamoDB
+		DynamoDBRoleARN string
+
+		// AssumeRoleSessionName is the session name of the assumed roles, a name is generated if it is empty
+		AssumeRoleSessionName string
+
+		// AssumeRoleExternalID is the optional external ID required by the trust policies of the assumed roles
+		AssumeRoleExternalID string
+
 		// TableName is name of the dynamo db table for managing kinesis stream default to ApplicationName
 		TableName string
 
-		// StreamName is the name of Kinesis stream
+		// TableNameTemplate is the template of the TableName, e.g. "{appName}-{env}-leases". The placeholders
+		// {appName}, {streamName} and {region} are replaced by the ApplicationName, StreamName and RegionName, the
+		// others by the TableNameVariables.
+		TableNameTemplate string
+
+		// TableNameVariables are the values of the placeholders of the TableNameTemplate
+		TableNameVariables map[string]string
+
+		// LeaseKeyPrefix prefixes the shard IDs in the keys of the DynamoDB lease table, so that multiple applications
+		// share one lease table while each application only sees its own leases
+		LeaseKeyPrefix string
+
+		// StreamName is the name of Kinesis stream. In multi-stream mode it only identifies the worker in metrics.
 		StreamName string
 
+		// StreamARN is the optional ARN of the Kinesis stream. It is passed to the Kinesis API instead of the stream
+		// name to consume a stream of another AWS account which grants access with a resource-based policy.
+		StreamARN string
+
+		// Streams are the names or ARNs of the Kinesis streams consumed by the worker in multi-stream mode. The lease
+		// keys are then namespaced by the stream ARN, or by the stream name for the streams given by name, so that the
+		// streams of the same name in different accounts or regions are kept apart. The shards of all streams are
+		// balanced across the workers. Streams of other AWS accounts have to be identified by their ARN.
+		Streams []string
+
+		// StreamProvider provides the streams consumed by the worker in multi-stream mode instead of a fixed list
+		StreamProvider StreamProvider
+
+		// SecondaryRegionName is the region of the replica of the stream the worker fails over to once the stream has
+		// been unavailable for RegionFailoverAfterMillis, e.g. for globally replicated streams
+		SecondaryRegionName string
+
+		// SecondaryStreamName is the name of the replica of the stream in SecondaryRegionName. Its leases are
+		// namespaced by its name, so that they are kept apart from the leases of the primary stream.
+		SecondaryStreamName string
+
+		// RegionFailoverAfterMillis is the time the shards of the primary stream cannot be listed before the
+		// application fails over to the secondary stream, and the time they can be listed again before it fails back.
+		// Throttled listings do not count as unavailability. The stream consumed by the application is recorded in the
+		// lease table, so that all workers follow the worker failing over or back.
+		RegionFailoverAfterMillis int
+
+		// SequenceNumberTranslator translates the checkpoints of the consumed stream into checkpoints of the other
+		// stream at failover and failback. Without a translator the secondary stream is consumed from
+		// InitialPositionInStream and the primary stream resumes from its checkpoints.
+		SequenceNumberTranslator SequenceNumberTranslator
+
 		// EnableEnhancedFanOutConsumer enables enhanced fan-out consumer
 		// See: https://docs.aws.amazon.com/streams/latest/dev/enhanced-consumers.html
 		// Either consumer name or consumer ARN must be specified when Enhanced Fan-Out is enabled.
@@ -196,11 +565,20 @@ type (
 		EnhancedFanOutConsumerName string
 
 		// EnhancedFanOutConsumerARN is the ARN of an already created enhanced fan-out consumer, if this is set no automatic consumer creation will be attempted
+		// It is ignored in multi-stream mode as a consumer is registered per stream.
 		EnhancedFanOutConsumerARN string
 
 		// WorkerID used to distinguish different workers/processes of a Kinesis application
 		WorkerID string
 
+		// WorkerIDProvider provides the WorkerID when the configuration is created instead of a fixed or random ID
+		WorkerIDProvider WorkerIDProvider
+
+		// ReclaimLeasesOnStartup acquires all leases still held by the WorkerID when the worker starts, e.g. after
+		// a restart with a stable WorkerID, instead of at most MaxLeasesToAcquireAtOneTime leases per shard sync,
+		// so that the leases are not taken over by other workers once they expire.
+		ReclaimLeasesOnStartup bool
+
 		// InitialPositionInStream specifies the Position in the stream where a new application should start from
 		InitialPositionInStream InitialPositionInStream
 
@@ -210,7 +588,9 @@ type (
 		// credentials to access Kinesis/Dynamo: https://docs.aws.amazon.com/sdk-for-go/api/aws/credentials/
 		// Note: No need to configure here. Use NewEnvCredentials for testing and EC2RoleProvider for production
 
-		// FailoverTimeMillis Lease duration (leases not renewed within this period will be claimed by others)
+		// FailoverTimeMillis Lease duration (leases not renewed within this period will be claimed by others). The
+		// DynamoDB checkpointer measures it from the last change of the lease counter observed by the local worker,
+		// so that skewed clocks do not expire leases.
 		FailoverTimeMillis int
 
 		// LeaseRefreshPeriodMillis is the period before the end of lease during which a lease is refreshed by the owner.
@@ -222,6 +602,10 @@ type (
 		// IdleTimeBetweenReadsInMillis Idle time between calls to fetch data from Kinesis
 		IdleTimeBetweenReadsInMillis int
 
+		// PollingStrategy decides the delay between GetRecords calls of the polling shard consumers. By default
+		// the delay adapts to MillisBehindLatest and backs off toward IdleTimeBetweenReadsInMillis.
+		PollingStrategy PollingStrategy
+
 		// CallProcessRecordsEvenForEmptyRecordList Call the IRecordProcessor::processRecords() API even if
 		// GetRecords returned an empty record list.
 		CallProcessRecordsEvenForEmptyRecordList bool
@@ -232,6 +616,15 @@ type (
 		// ShardSyncIntervalMillis Time between tasks to sync leases and Kinesis shards
 		ShardSyncIntervalMillis int
 
+		// ShardEndSyncIntervalMillis Time between the shard syncs following the end of a shard, so that the shards of
+		// streams in ON_DEMAND capacity mode, which are split as the traffic grows, are discovered quickly. The
+		// interval doubles while ListShards is throttled. 0 waits for ShardSyncIntervalMillis.
+		ShardEndSyncIntervalMillis int
+
+		// ShardEndSyncDurationMillis The number of milliseconds after the end of a shard during which the shards are
+		// synced every ShardEndSyncIntervalMillis
+		ShardEndSyncDurationMillis int
+
 		// CleanupTerminatedShardsBeforeExpiry Clean up shards we've finished processing (don't wait for expiration)
 		CleanupTerminatedShardsBeforeExpiry bool
 
@@ -259,12 +652,172 @@ type (
 		// Max leases to steal at one time (for load balancing)
 		MaxLeasesToStealAtOneTime int
 
+		// Max available leases to acquire at every shard sync
+		MaxLeasesToAcquireAtOneTime int
+
 		// Read capacity to provision when creating the lease table (dynamoDB).
 		InitialLeaseTableReadCapacity int
 
 		// Write capacity to provision when creating the lease table.
 		InitialLeaseTableWriteCapacity int
 
+		// ProcessorPanicPolicy specifies how a shard consumer recovers from a panic of its record processor
+		ProcessorPanicPolicy ProcessorPanicPolicy
+
+		// ProcessorRestartBackoffMillis is the initial backoff before restarting a panicked record processor with
+		// RestartOnPanic. It is doubled on each consecutive panic up to 30 seconds.
+		ProcessorRestartBackoffMillis int
+
+		// ProcessorPanicHandler is called when a record processor panics, e.g. to report the panic
+		ProcessorPanicHandler ProcessorPanicHandler
+
+		// MaxProcessRecordsRetries is the number of retries with exponential backoff of a batch failed by the record
+		// processor before the records are published to the DeadLetterPublisher
+		MaxProcessRecordsRetries int
+
+		// ProcessRecordsErrorPolicy specifies how a shard consumer continues once a batch failed by the record
+		// processor has been retried MaxProcessRecordsRetries times and could not be published to the
+		// DeadLetterPublisher
+		ProcessRecordsErrorPolicy ProcessRecordsErrorPolicy
+
+		// MaxDeliveryAttempts is the number of deliveries of a batch after which the ProcessingFailedHandler is called
+		// for each failed delivery. The attempts are counted by the worker across retries and restarts of the shard
+		// consumer, until a batch starting at another record is delivered. It is disabled with 0.
+		MaxDeliveryAttempts int
+
+		// ProcessingFailedHandler decides how a batch failed MaxDeliveryAttempts times is continued with
+		ProcessingFailedHandler ProcessingFailedHandler
+
+		// UnorderedProcessingConcurrency is the number of goroutines the records of a batch are fanned out to, for
+		// record processors which don't need the records of a shard in order. The records with the same partition key
+		// are delivered to the same goroutine in order, and the checkpoints only advance to the last record before
+		// which all the records of the batch have been checkpointed. The record processor must be safe for concurrent
+		// use. The records are processed in order with 0 or 1.
+		UnorderedProcessingConcurrency int
+
+		// AllowCheckpointRewind allows record processors to checkpoint a sequence number before the current checkpoint
+		// of the shard, e.g. to intentionally reprocess records. By default such checkpoints are rejected with
+		// a SkippedSequenceError.
+		AllowCheckpointRewind bool
+
+		// CheckpointValidator is an optional hook validating the checkpoints requested by record processors
+		CheckpointValidator CheckpointValidator
+
+		// ShardFilter scopes the worker to the shards it matches, all shards are consumed by default
+		ShardFilter ShardFilter
+
+		// PinnedShardIDs pins the worker to the shards with these IDs, e.g. to debug a single hot shard or to replay
+		// shards. The other shards of the streams and the child shards of the pinned shards are not consumed, the
+		// consumption of a pinned shard ends with the shard. Unless PinnedShardLeaseCoordination is enabled, the
+		// default checkpointer keeps the leases and checkpoints of the pinned shards in memory (see
+		// MemoryCheckpointFile), so that the worker does not coordinate with the workers of the lease table; a pinned
+		// child shard is then consumed without waiting for its parents which are not pinned.
+		PinnedShardIDs []string
+
+		// PinnedShardLeaseCoordination leases the PinnedShardIDs in the lease table of the application, the worker
+		// then only takes, renews and steals the leases of the pinned shards
+		PinnedShardLeaseCoordination bool
+
+		// ShardAssignment pre-assigns the shards to worker IDs, the worker leaves the shards assigned to other workers
+		// to them. By default the shards are leased by any worker.
+		ShardAssignment ShardAssignment
+
+		// ShardAssignmentFallbackMillis is the time after which the worker leases a shard assigned to another worker
+		// whose lease has not been held, e.g. because the assigned worker is down. With lease stealing the assigned
+		// worker claims the shard back once it is up. The shards are only leased by the assigned workers with 0.
+		ShardAssignmentFallbackMillis int
+
+		// ListShardsFilter is passed to ListShards to select the new shards to consume, e.g. only the open shards
+		// with AT_LATEST. The shards which are not listed are not leased, but their leases are kept and their child
+		// shards wait for them to be completed.
+		ListShardsFilter *ktypes.ShardFilter
+
+		// LagThresholdMillis is the MillisBehindLatest of a shard above which LagHandler is called
+		LagThresholdMillis int64
+
+		// LagHandler is an optional hook called when the lag of a shard exceeds LagThresholdMillis
+		LagHandler LagHandler
+
+		// CheckpointAgeAlarmMillis is the time the checkpoint of a shard behind its tip may not advance before
+		// a CheckpointAgeAlarm is raised, disabled with 0
+		CheckpointAgeAlarmMillis int
+
+		// MillisBehindLatestAlarmMillis is the MillisBehindLatest of a shard above which a MillisBehindLatestAlarm is
+		// raised, disabled with 0
+		MillisBehindLatestAlarmMillis int
+
+		// AlarmEvaluationIntervalMillis is the interval the alarms of the shards consumed by the worker are evaluated
+		// at, the checkpoint ages are reported to the monitoring service at the same interval
+		AlarmEvaluationIntervalMillis int
+
+		// AlarmHandler is an optional hook called when an alarm is raised or cleared
+		AlarmHandler AlarmHandler
+
+		// HotShardsReportIntervalMillis is the interval the throughput of the shards consumed by the worker is
+		// aggregated over, the hottest shards are logged and passed to HotShardsHandler at the end of each interval.
+		// The report is disabled with 0.
+		HotShardsReportIntervalMillis int
+
+		// HotShardsTopN is the number of the hottest shards which are reported
+		HotShardsTopN int
+
+		// HotShardsHandler is an optional hook receiving the hottest shards
+		HotShardsHandler HotShardsHandler
+
+		// DeadLetterPublisher receives the records which cannot be processed, the checkpoint then advances past them.
+		// Without a publisher the shard consumer fails as soon as the retries are exhausted.
+		DeadLetterPublisher deadletter.Publisher
+
+		// LeaseAuditSink records the lease transitions of the worker with the checkpoints of the shards, e.g. to an
+		// audit table, for the analysis of duplicate or missed processing. No events are recorded by default.
+		LeaseAuditSink leaseaudit.Sink
+
+		// LeaseCoordinator assigns the shards to the workers instead of the leases of the lease table, e.g. by the
+		// leader election of Kubernetes or by etcd. The lease table of the CheckpointBackend is still required, a
+		// worker takes the lease of a shard in it, which stores the checkpoints, only once the shard has been assigned
+		// to it. Lease stealing is not supported.
+		LeaseCoordinator leasecoordinator.LeaseCoordinator
+
+		// RecordTransformer transforms the data of each user record before it is delivered to the record processor,
+		// e.g. to decrypt or decompress the payloads. The records which cannot be transformed are published to the
+		// DeadLetterPublisher, without a publisher the shard consumer fails.
+		RecordTransformer transformer.RecordTransformer
+
+		// RecordDecoder deserializes the data of each user record after it has been transformed, the value is
+		// delivered to the record processor as UserRecord.Decoded. The records which cannot be decoded are handled
+		// like the records which cannot be transformed.
+		RecordDecoder decoder.Decoder
+
+		// RetryPolicy specifies how the calls to Kinesis and DynamoDB are retried. It is not applied to the Kinesis
+		// and DynamoDB clients provided by the application.
+		RetryPolicy RetryPolicy
+
+		// LeaseTableBillingMode is the billing mode of the lease table when it is created. The initial read and write
+		// capacity are only used with PROVISIONED.
+		LeaseTableBillingMode types.BillingMode
+
+		// LeaseTableTags are the tags of the lease table when it is created
+		LeaseTableTags map[string]string
+
+		// LeaseTablePointInTimeRecovery enables point-in-time recovery of the lease table whenever the worker starts,
+		// the DynamoDB client has to implement checkpoint.ContinuousBackupsAPI
+		LeaseTablePointInTimeRecovery bool
+
+		// LeaseTableSSEEnabled encrypts the lease table with a KMS key instead of an AWS owned key when it is created
+		LeaseTableSSEEnabled bool
+
+		// LeaseTableSSEKMSKeyID is the KMS key used to encrypt the lease table, the AWS managed key is used if it is empty
+		LeaseTableSSEKMSKeyID string
+
+		// DebugServerAddress is the address of the debug server of the worker serving pprof profiles, expvar
+		// variables, the leases and the state of the shard consumers, e.g. "localhost:6060". It is disabled if empty.
+		// An address without host, e.g. ":6060", is bound to localhost, the server is only reachable from other hosts
+		// if their address is given explicitly, e.g. "0.0.0.0:6060", which should come with a DebugAuthorizer.
+		DebugServerAddress string
+
+		// DebugAuthorizer is an optional hook authorizing the requests of the debug server
+		DebugAuthorizer DebugAuthorizer
+
 		// Worker should skip syncing shards and leases at startup if leases are present
 		// This is useful for optimizing deployments to large fleets working on a stable stream.
 		SkipShardSyncAtWorkerInitializationIfLeasesExist bool
@@ -275,27 +828,151 @@ type (
 		// MonitoringService publishes per worker-scoped metrics.
 		MonitoringService metrics.MonitoringService
 
+		// MetricsNamespace is the namespace of the metrics passed to the MonitoringService, e.g. the CloudWatch
+		// namespace or the prefix of the Prometheus metrics. It is the ApplicationName if it is empty.
+		MetricsNamespace string
+
+		// DisabledMetrics are not reported to the MonitoringService, e.g. to reduce the cost of CloudWatch.
+		DisabledMetrics []metrics.Metric
+
+		// ShardMetricsSampleRatio is the fraction of the shards, between 0 and 1, whose per-shard metrics of the
+		// record processing are reported to the MonitoringService, see metrics.NewFilteredMonitoringService.
+		ShardMetricsSampleRatio float64
+
+		// TracerProvider creates the tracer of the spans around GetRecords, ProcessRecords and Checkpoint calls.
+		TracerProvider trace.TracerProvider
+
 		// EnableLeaseStealing turns on lease stealing
 		EnableLeaseStealing bool
 
 		// LeaseStealingIntervalMillis The number of milliseconds between rebalance tasks
 		LeaseStealingIntervalMillis int
 
+		// CleanupLeasesUponShardCompletion deletes the leases of shards checkpointed at SHARD_END once all their
+		// child shards have been checkpointed, so that the lease table does not grow with every resharding
+		CleanupLeasesUponShardCompletion bool
+
+		// LeaseCleanupIntervalMillis The number of milliseconds between lease cleanup tasks
+		LeaseCleanupIntervalMillis int
+
 		// LeaseStealingClaimTimeoutMillis The number of milliseconds to wait before another worker can aquire a claimed shard
 		LeaseStealingClaimTimeoutMillis int
 
+		// LeaseStealingHandoffTimeoutMillis The number of milliseconds the owner of a claimed shard has to checkpoint
+		// and release the lease. The claiming worker takes the lease as soon as it has been released, and only once
+		// the lease has expired otherwise. 0 completes the steal as soon as the lease has expired.
+		LeaseStealingHandoffTimeoutMillis int
+
 		// LeaseSyncingTimeInterval The number of milliseconds to wait before syncing with lease table (dynamoDB)
 		LeaseSyncingTimeIntervalMillis int
 
+		// LeaseTableScanSegments is the number of segments of the lease table which are scanned in parallel when the
+		// leases are synced. Each segment is scanned page by page, so that at most one page of 1 MB per segment
+		// is held in memory.
+		LeaseTableScanSegments int
+
+		// LeaseScanConsistency is the consistency of the scans of the DynamoDB lease table syncing the leases
+		LeaseScanConsistency ReadConsistency
+
+		// LeaseGetConsistency is the consistency of the reads of the lease of a shard from the DynamoDB lease table,
+		// e.g. before the lease is taken. The conditional writes of the leases are not affected.
+		LeaseGetConsistency ReadConsistency
+
+		// LeaseTableCapacityHandler is called with the capacity consumed by each call to the DynamoDB lease table,
+		// which is also reported to the MonitoringService
+		LeaseTableCapacityHandler LeaseTableCapacityHandler
+
+		// LeaseAttributeCompressionThreshold is the size in bytes above which the pending checkpoint and the
+		// checkpoint metadata are stored gzip compressed and base64 encoded in the DynamoDB lease table, 0 disables
+		// the compression. Compressed attributes are read regardless of the threshold.
+		LeaseAttributeCompressionThreshold int
+
 		// MaxRetryCount The maximum number of retries in case of error
 		MaxRetryCount int
+
+		// AsyncCheckpointIntervalMillis The number of milliseconds between flushes of the checkpoints requested by CheckpointAsync
+		AsyncCheckpointIntervalMillis int
+
+		// AutoCheckpoint checkpoints the last record of a batch after ProcessRecords has returned without an error,
+		// once AutoCheckpointIntervalMillis have elapsed or AutoCheckpointRecordCount records have been processed
+		// since the last checkpoint, every batch is checkpointed if both are 0. The processed records which have not
+		// been checkpointed yet are checkpointed before the record processor is shut down while the lease is held.
+		AutoCheckpoint bool
+
+		// AutoCheckpointIntervalMillis The minimum number of milliseconds between the checkpoints of AutoCheckpoint
+		AutoCheckpointIntervalMillis int
+
+		// AutoCheckpointRecordCount The number of processed records after which AutoCheckpoint checkpoints regardless
+		// of AutoCheckpointIntervalMillis
+		AutoCheckpointRecordCount int
+
+		// MaxRecordsPerSecond The maximum number of records processed per second by all shard consumers of the worker,
+		// 0 is unlimited
+		MaxRecordsPerSecond int
+
+		// MaxInFlightBytes The maximum number of bytes being processed by all shard consumers of the worker, 0 is unlimited
+		MaxInFlightBytes int64
+
+		// MaxConcurrentShardConsumers The maximum number of shard consumers of the worker fetching and processing
+		// records at the same time, the other shard consumers wait for their turn in the order they requested it.
+		// 0 is unlimited.
+		MaxConcurrentShardConsumers int
+
+		// MaxGetRecordsCallsPerSecond The maximum number of GetRecords calls per second of all polling shard consumers
+		// of the worker, e.g. to stay below the read limits while many shards catch up. The calls are granted in the
+		// order they are requested, a shard throttled by Kinesis backs off without lowering the rate of the other
+		// shards. 0 is unlimited.
+		MaxGetRecordsCallsPerSecond int
+
+		// PrefetchMaxRecords The maximum number of records read ahead per shard by the polling shard consumers while
+		// the record processor works on the current batch, 0 disables prefetching
+		PrefetchMaxRecords int
+
+		// PrefetchMaxBytes The maximum number of bytes read ahead per shard by the polling shard consumers, 0 is unlimited
+		PrefetchMaxBytes int
+
+		// MinBatchSize The minimum number of records delivered to ProcessRecords at once, the records read from a
+		// shard are collected until there are as many of them or MaxBatchWaitTimeMillis has passed. The last records
+		// of a closed shard are delivered at once. 0 delivers every read as it is.
+		MinBatchSize int
+
+		// MaxBatchWaitTimeMillis The maximum number of milliseconds the records read from a shard are collected
+		// before they are delivered, it bounds the latency added by MinBatchSize
+		MaxBatchWaitTimeMillis int
+
+		// EnableKPLDeaggregation de-aggregates the records published by the KPL into user records before they are
+		// delivered to the RecordProcessor, otherwise the aggregated records are delivered as they are
+		EnableKPLDeaggregation bool
+
+		// CheckpointBackend selects the storage of leases and checkpoints used by the default checkpointer
+		CheckpointBackend CheckpointBackend
+
+		// RedisAddress is the host:port of the Redis server used by RedisBackend
+		RedisAddress string
+
+		// RedisPassword is an optional password to access the Redis server
+		RedisPassword string
+
+		// RedisDB is the Redis database to select after connecting to the server
+		RedisDB int
+
+		// SQLDriverName is the database/sql driver used by SQLBackend, e.g. "postgres", "pgx" or "mysql".
+		// The driver has to be registered by the application.
+		SQLDriverName string
+
+		// SQLDataSourceName is the driver specific data source name used to open the database
+		SQLDataSourceName string
+
+		// MemoryCheckpointFile is the optional JSON file the lease table of MemoryBackend is persisted to
+		MemoryCheckpointFile string
 	}
 )
 
 var positionMap = map[InitialPositionInStream]*string{
-	LATEST:       aws.String("LATEST"),
-	TRIM_HORIZON: aws.String("TRIM_HORIZON"),
-	AT_TIMESTAMP: aws.String("AT_TIMESTAMP"),
+	LATEST:             aws.String("LATEST"),
+	TRIM_HORIZON:       aws.String("TRIM_HORIZON"),
+	AT_TIMESTAMP:       aws.String("AT_TIMESTAMP"),
+	AT_SEQUENCE_NUMBER: aws.String("AT_SEQUENCE_NUMBER"),
 }
 
 func InitalPositionInStreamToShardIteratorType(pos InitialPositionInStream) *string {
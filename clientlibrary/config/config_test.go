@@ -19,10 +19,30 @@
 package config
 
 import (
+	"context"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	awsConfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	ktypes "github.com/aws/aws-sdk-go-v2/service/kinesis/types"
 	"github.com/stretchr/testify/assert"
 
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/deadletter"
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/leaseaudit"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/leasecoordinator"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/metrics"
 	"github.com/vmware/vmware-go-kcl-v2/logger"
 )
 
@@ -46,6 +66,8 @@ func TestConfig(t *testing.T) {
 	assert.Equal(t, false, kclConfig.EnableLeaseStealing)
 	assert.Equal(t, 5000, kclConfig.LeaseStealingIntervalMillis)
 
+	assert.Equal(t, true, kclConfig.EnableKPLDeaggregation)
+
 	contextLogger := kclConfig.Logger.WithFields(logger.Fields{"key1": "value1"})
 	contextLogger.Debugf("Starting with default logger")
 	contextLogger.Infof("Default logger is awesome")
@@ -67,12 +89,26 @@ func TestConfigLeaseStealing(t *testing.T) {
 	assert.Equal(t, 10, kclConfig.TaskBackoffTimeMillis)
 	assert.Equal(t, true, kclConfig.EnableLeaseStealing)
 	assert.Equal(t, 10000, kclConfig.LeaseStealingIntervalMillis)
+	assert.Equal(t, DefaultLeaseStealingHandoffTimeoutMillis, kclConfig.LeaseStealingHandoffTimeoutMillis)
 
 	contextLogger := kclConfig.Logger.WithFields(logger.Fields{"key1": "value1"})
 	contextLogger.Debugf("Starting with default logger")
 	contextLogger.Infof("Default logger is awesome")
 }
 
+func TestConfigLeaseStealingHandoffTimeout(t *testing.T) {
+	kclConfig := NewKinesisClientLibConfig("appName", "StreamName", "us-west-2", "workerId").
+		WithLeaseStealing(true).
+		WithLeaseStealingHandoffTimeoutMillis(30000)
+	assert.Equal(t, 30000, kclConfig.LeaseStealingHandoffTimeoutMillis)
+	assert.NoError(t, kclConfig.Validate())
+
+	kclConfig.WithLeaseStealingHandoffTimeoutMillis(kclConfig.LeaseStealingClaimTimeoutMillis)
+	assert.Error(t, kclConfig.Validate())
+
+	assert.Panics(t, func() { kclConfig.WithLeaseStealingHandoffTimeoutMillis(-1) })
+}
+
 func TestConfigDefaultEnhancedFanOutConsumerName(t *testing.T) {
 	kclConfig := NewKinesisClientLibConfig("appName", "StreamName", "us-west-2", "workerId")
 
@@ -100,3 +136,1028 @@ func TestEmptyEnhancedFanOutConsumerARN(t *testing.T) {
 		NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").WithEnhancedFanOutConsumerARN("")
 	})
 }
+
+func TestConfigWithRedisCheckpointer(t *testing.T) {
+	kclConfig := NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker")
+	assert.Equal(t, DynamoDBBackend, kclConfig.CheckpointBackend)
+
+	kclConfig.WithRedisCheckpointer("localhost:6379", "secret", 1)
+	assert.Equal(t, RedisBackend, kclConfig.CheckpointBackend)
+	assert.Equal(t, "localhost:6379", kclConfig.RedisAddress)
+	assert.Equal(t, "secret", kclConfig.RedisPassword)
+	assert.Equal(t, 1, kclConfig.RedisDB)
+}
+
+func TestConfigWithSQLCheckpointer(t *testing.T) {
+	kclConfig := NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+		WithSQLCheckpointer("postgres", "postgres://localhost/kcl")
+	assert.Equal(t, SQLBackend, kclConfig.CheckpointBackend)
+	assert.Equal(t, "postgres", kclConfig.SQLDriverName)
+	assert.Equal(t, "postgres://localhost/kcl", kclConfig.SQLDataSourceName)
+
+	assert.PanicsWithValue(t, "Non-empty value expected for SQLDataSourceName, actual: ", func() {
+		NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").WithSQLCheckpointer("mysql", "")
+	})
+}
+
+func TestConfigWithMemoryCheckpointer(t *testing.T) {
+	kclConfig := NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+		WithMemoryCheckpointer("leases.json")
+	assert.Equal(t, MemoryBackend, kclConfig.CheckpointBackend)
+	assert.Equal(t, "leases.json", kclConfig.MemoryCheckpointFile)
+}
+
+func TestConfigWithInitialPositionAtSequenceNumber(t *testing.T) {
+	kclConfig := NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+		WithSequenceNumberAtInitialPositionInStream(map[string]string{"shardId-000000000001": "49590338271490256608559692538361571095921575989136588898"})
+	assert.Equal(t, AT_SEQUENCE_NUMBER, kclConfig.InitialPositionInStream)
+	assert.Equal(t, AT_SEQUENCE_NUMBER, kclConfig.InitialPositionInStreamExtended.Position)
+	assert.Equal(t, "49590338271490256608559692538361571095921575989136588898",
+		kclConfig.InitialPositionInStreamExtended.SequenceNumbers["shardId-000000000001"])
+
+	assert.PanicsWithValue(t, "Non-empty value expected for sequenceNumber, actual: ", func() {
+		NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+			WithSequenceNumberAtInitialPositionInStream(map[string]string{"shardId-000000000001": ""})
+	})
+}
+
+func TestConfigInitialPositionRequiresValue(t *testing.T) {
+	now := time.Now()
+	kclConfig := NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+		WithTimestampAtInitialPositionInStream(&now)
+	assert.Equal(t, AT_TIMESTAMP, kclConfig.InitialPositionInStream)
+	assert.Equal(t, &now, kclConfig.InitialPositionInStreamExtended.Timestamp)
+
+	assert.Panics(t, func() {
+		NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").WithInitialPositionInStream(AT_TIMESTAMP)
+	})
+	assert.Panics(t, func() {
+		NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").WithTimestampAtInitialPositionInStream(nil)
+	})
+}
+
+func TestConfigProcessingLimits(t *testing.T) {
+	kclConfig := NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker")
+	assert.Equal(t, 0, kclConfig.MaxRecordsPerSecond)
+	assert.Equal(t, int64(0), kclConfig.MaxInFlightBytes)
+	assert.Equal(t, 0, kclConfig.MaxConcurrentShardConsumers)
+
+	kclConfig.WithMaxRecordsPerSecond(500).WithMaxInFlightBytes(1 << 20).WithMaxConcurrentShardConsumers(8).
+		WithMaxGetRecordsCallsPerSecond(50)
+	assert.Equal(t, 500, kclConfig.MaxRecordsPerSecond)
+	assert.Equal(t, int64(1<<20), kclConfig.MaxInFlightBytes)
+	assert.Equal(t, 8, kclConfig.MaxConcurrentShardConsumers)
+	assert.Equal(t, 50, kclConfig.MaxGetRecordsCallsPerSecond)
+	assert.Nil(t, kclConfig.Validate())
+
+	assert.Panics(t, func() {
+		NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").WithMaxRecordsPerSecond(0)
+	})
+	assert.Panics(t, func() {
+		NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").WithMaxGetRecordsCallsPerSecond(0)
+	})
+}
+
+func TestConfigBatching(t *testing.T) {
+	kclConfig := NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker")
+	assert.Equal(t, 0, kclConfig.MinBatchSize)
+	assert.Equal(t, 0, kclConfig.MaxBatchWaitTimeMillis)
+
+	kclConfig.WithBatching(100, 500)
+	assert.Equal(t, 100, kclConfig.MinBatchSize)
+	assert.Equal(t, 500, kclConfig.MaxBatchWaitTimeMillis)
+	assert.Nil(t, kclConfig.Validate())
+
+	assert.Panics(t, func() {
+		NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").WithBatching(100, 0)
+	})
+
+	// the wait time bounds the latency of the batches
+	_, err := New("app", "stream", WithRegion("us-west-2"), WithBatching(100, 0))
+	var errs ValidationErrors
+	assert.True(t, errors.As(err, &errs))
+	assert.Equal(t, 1, len(errs))
+	assert.Equal(t, "MaxBatchWaitTimeMillis", errs[0].Field)
+}
+
+func TestConfigLeaseTableOptions(t *testing.T) {
+	kclConfig := NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker")
+	assert.Equal(t, types.BillingModeProvisioned, kclConfig.LeaseTableBillingMode)
+	assert.False(t, kclConfig.LeaseTablePointInTimeRecovery)
+	assert.False(t, kclConfig.LeaseTableSSEEnabled)
+
+	kclConfig.WithLeaseTableBillingMode(types.BillingModePayPerRequest).
+		WithLeaseTableTags(map[string]string{"team": "kcl"}).
+		WithLeaseTablePointInTimeRecovery(true).
+		WithLeaseTableServerSideEncryption("alias/kcl")
+	assert.Equal(t, types.BillingModePayPerRequest, kclConfig.LeaseTableBillingMode)
+	assert.Equal(t, map[string]string{"team": "kcl"}, kclConfig.LeaseTableTags)
+	assert.True(t, kclConfig.LeaseTablePointInTimeRecovery)
+	assert.True(t, kclConfig.LeaseTableSSEEnabled)
+	assert.Equal(t, "alias/kcl", kclConfig.LeaseTableSSEKMSKeyID)
+}
+
+func TestConfigMultiStream(t *testing.T) {
+	kclConfig := NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker")
+	assert.False(t, kclConfig.IsMultiStreamMode())
+
+	kclConfig.WithStreams("stream_1", "stream_2")
+	assert.True(t, kclConfig.IsMultiStreamMode())
+	assert.Equal(t, []string{"stream_1", "stream_2"}, kclConfig.Streams)
+
+	assert.Panics(t, func() {
+		NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").WithStreams()
+	})
+	assert.Panics(t, func() {
+		NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").WithStreamProvider(nil)
+	})
+}
+
+func TestConfigWithStreamARN(t *testing.T) {
+	streamARN := "arn:aws:kinesis:us-west-2:123456789012:stream/stream"
+	kclConfig := NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").WithStreamARN(streamARN)
+	assert.Equal(t, streamARN, kclConfig.StreamARN)
+
+	assert.Panics(t, func() {
+		NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").WithStreamARN("")
+	})
+}
+
+func TestConfigProcessorPanicPolicy(t *testing.T) {
+	kclConfig := NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker")
+	assert.Equal(t, ReleaseLeaseOnPanic, kclConfig.ProcessorPanicPolicy)
+	assert.Equal(t, DefaultProcessorRestartBackoffMillis, kclConfig.ProcessorRestartBackoffMillis)
+
+	kclConfig.WithProcessorPanicPolicy(RestartOnPanic).WithProcessorRestartBackoffMillis(100)
+	assert.Equal(t, RestartOnPanic, kclConfig.ProcessorPanicPolicy)
+	assert.Equal(t, 100, kclConfig.ProcessorRestartBackoffMillis)
+
+	assert.Panics(t, func() {
+		NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").WithProcessorPanicPolicy(0)
+	})
+}
+
+func TestConfigDeadLetter(t *testing.T) {
+	kclConfig := NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker")
+	assert.Equal(t, 0, kclConfig.MaxProcessRecordsRetries)
+	assert.Nil(t, kclConfig.DeadLetterPublisher)
+
+	publisher := deadletter.PublisherFunc(func(context.Context, *deadletter.Input) error { return nil })
+	kclConfig.WithMaxProcessRecordsRetries(3).WithDeadLetterPublisher(publisher)
+	assert.Equal(t, 3, kclConfig.MaxProcessRecordsRetries)
+	assert.NotNil(t, kclConfig.DeadLetterPublisher)
+
+	assert.Panics(t, func() { kclConfig.WithMaxProcessRecordsRetries(-1) })
+	assert.Panics(t, func() { kclConfig.WithDeadLetterPublisher(nil) })
+}
+
+func TestConfigMaxDeliveryAttempts(t *testing.T) {
+	kclConfig := NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker")
+	assert.Equal(t, 0, kclConfig.MaxDeliveryAttempts)
+	assert.Nil(t, kclConfig.ProcessingFailedHandler)
+
+	handler := func(context.Context, *ProcessingFailedInput) ProcessingFailedAction { return SkipFailedRecords }
+	kclConfig.WithMaxDeliveryAttempts(5, handler)
+	assert.Equal(t, 5, kclConfig.MaxDeliveryAttempts)
+	assert.NotNil(t, kclConfig.ProcessingFailedHandler)
+	assert.Nil(t, kclConfig.Validate())
+
+	assert.Panics(t, func() { kclConfig.WithMaxDeliveryAttempts(0, handler) })
+	assert.Panics(t, func() { kclConfig.WithMaxDeliveryAttempts(5, nil) })
+
+	// a handler is required to limit the delivery attempts
+	_, err := New("stream", "app", WithMaxDeliveryAttempts(5, nil))
+	assert.NotNil(t, err)
+}
+
+func TestConfigUnorderedProcessing(t *testing.T) {
+	kclConfig := NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker")
+	assert.Equal(t, 0, kclConfig.UnorderedProcessingConcurrency)
+
+	kclConfig.WithUnorderedProcessing(4)
+	assert.Equal(t, 4, kclConfig.UnorderedProcessingConcurrency)
+	assert.Nil(t, kclConfig.Validate())
+
+	assert.Panics(t, func() { kclConfig.WithUnorderedProcessing(0) })
+
+	_, err := New("stream", "app", WithUnorderedProcessing(-1))
+	assert.NotNil(t, err)
+}
+
+func TestConfigProcessRecordsErrorPolicy(t *testing.T) {
+	kclConfig := NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker")
+	assert.Equal(t, HaltOnError, kclConfig.ProcessRecordsErrorPolicy)
+
+	kclConfig.WithProcessRecordsErrorPolicy(RetryOnError)
+	assert.Equal(t, RetryOnError, kclConfig.ProcessRecordsErrorPolicy)
+	assert.NoError(t, kclConfig.Validate())
+
+	// a failed batch is either retried or published to the dead-letter queue
+	kclConfig.WithDeadLetterPublisher(deadletter.PublisherFunc(func(context.Context, *deadletter.Input) error { return nil }))
+	assert.Error(t, kclConfig.Validate())
+
+	assert.Panics(t, func() { kclConfig.WithProcessRecordsErrorPolicy(0) })
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelayMillis: 100, MaxDelayMillis: 1000}
+	assert.Equal(t, 100*time.Millisecond, policy.Delay(1))
+	assert.Equal(t, 200*time.Millisecond, policy.Delay(2))
+	assert.Equal(t, 800*time.Millisecond, policy.Delay(4))
+	assert.Equal(t, time.Second, policy.Delay(5))
+	assert.Equal(t, time.Second, policy.Delay(100))
+
+	policy.Jitter = true
+	for i := 0; i < 100; i++ {
+		delay := policy.Delay(3)
+		assert.True(t, delay >= 0 && delay <= 400*time.Millisecond)
+	}
+
+	kclConfig := NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker")
+	assert.Equal(t, DefaultRetryMaxAttempts, kclConfig.RetryPolicy.MaxAttempts)
+	assert.True(t, kclConfig.RetryPolicy.Jitter)
+
+	kclConfig.WithRetryPolicy(policy)
+	assert.Equal(t, policy, kclConfig.RetryPolicy)
+	assert.Panics(t, func() {
+		kclConfig.WithRetryPolicy(RetryPolicy{MaxAttempts: 1, BaseDelayMillis: 100, MaxDelayMillis: 10})
+	})
+	assert.Panics(t, func() {
+		kclConfig.WithRetryPolicy(RetryPolicy{MaxAttempts: 0, BaseDelayMillis: 100, MaxDelayMillis: 100})
+	})
+}
+
+// throttleCounter counts the throttled requests per API
+type throttleCounter struct {
+	metrics.NoopMonitoringService
+	throttles map[string]int
+}
+
+func (c *throttleCounter) IncrThrottledRequests(api string) {
+	c.throttles[api]++
+}
+
+func TestRetryerCountsThrottledRequests(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		if calls < 3 {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"__type":"ProvisionedThroughputExceededException","message":"Rate exceeded"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"Shards":[]}`))
+	}))
+	defer server.Close()
+
+	counter := &throttleCounter{throttles: map[string]int{}}
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelayMillis: 1, MaxDelayMillis: 10, Jitter: true}
+	kc := kinesis.New(kinesis.Options{
+		Region:           "us-west-2",
+		Credentials:      credentials.NewStaticCredentialsProvider("AKID", "SECRET", ""),
+		EndpointResolver: kinesis.EndpointResolverFromURL(server.URL),
+		Retryer:          policy.NewRetryer(counter),
+	})
+
+	_, err := kc.ListShards(context.TODO(), &kinesis.ListShardsInput{StreamName: aws.String("stream")})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, map[string]int{"Kinesis.ListShards": 2}, counter.throttles)
+}
+
+func TestConfigLeaseCleanup(t *testing.T) {
+	kclConfig := NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker")
+	assert.True(t, kclConfig.CleanupLeasesUponShardCompletion)
+	assert.Equal(t, DefaultLeaseCleanupIntervalMillis, kclConfig.LeaseCleanupIntervalMillis)
+
+	kclConfig.WithCleanupLeasesUponShardCompletion(false).WithLeaseCleanupIntervalMillis(1000)
+	assert.False(t, kclConfig.CleanupLeasesUponShardCompletion)
+	assert.Equal(t, 1000, kclConfig.LeaseCleanupIntervalMillis)
+}
+
+func TestPollingStrategy(t *testing.T) {
+	kclConfig := NewKinesisClientLibConfig("appName", "StreamName", "us-west-2", "workerId").
+		WithIdleTimeBetweenReadsInMillis(2000)
+
+	adaptive, ok := kclConfig.GetPollingStrategy().(AdaptivePollingStrategy)
+	assert.True(t, ok)
+	assert.Equal(t, 2000, adaptive.MaxDelayMillis)
+	assert.Equal(t, DefaultPollingLagThresholdMillis, adaptive.LagThresholdMillis)
+
+	// full batch or far behind: poll immediately
+	assert.Equal(t, time.Duration(0), adaptive.NextPollDelay(PollResult{RecordCount: 100, MaxRecords: 100}))
+	assert.Equal(t, time.Duration(0), adaptive.NextPollDelay(PollResult{RecordCount: 1, MaxRecords: 100, MillisBehindLatest: 10000}))
+	// the delay backs off with decreasing lag toward the idle time
+	assert.Equal(t, 500*time.Millisecond, adaptive.NextPollDelay(PollResult{RecordCount: 1, MaxRecords: 100, MillisBehindLatest: 7500}))
+	assert.Equal(t, 2*time.Second, adaptive.NextPollDelay(PollResult{MaxRecords: 100}))
+
+	fixed := FixedPollingStrategy{IdleTimeMillis: 1000}
+	assert.Equal(t, time.Second, fixed.NextPollDelay(PollResult{MillisBehindLatest: 999}))
+	assert.Equal(t, time.Duration(0), fixed.NextPollDelay(PollResult{MillisBehindLatest: 1000}))
+	assert.Equal(t, time.Duration(0), fixed.NextPollDelay(PollResult{RecordCount: 1}))
+
+	kclConfig.WithPollingStrategy(fixed)
+	assert.Equal(t, fixed, kclConfig.GetPollingStrategy())
+	assert.Panics(t, func() {
+		kclConfig.WithPollingStrategy(nil)
+	})
+}
+
+func TestEndpointOptions(t *testing.T) {
+	kclConfig := NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+		WithLocalStackEndpoint("http://localhost:4566")
+	assert.Equal(t, "http://localhost:4566", kclConfig.KinesisEndpoint)
+	assert.Equal(t, "http://localhost:4566", kclConfig.DynamoDBEndpoint)
+
+	cfg, err := awsConfig.LoadDefaultConfig(context.TODO(), kclConfig.EndpointOptions(kinesis.ServiceID, kclConfig.KinesisEndpoint)...)
+	assert.Nil(t, err)
+
+	endpoint, err := cfg.EndpointResolverWithOptions.ResolveEndpoint(kinesis.ServiceID, "us-west-2")
+	assert.Nil(t, err)
+	assert.Equal(t, "http://localhost:4566", endpoint.URL)
+	assert.Equal(t, "us-west-2", endpoint.SigningRegion)
+
+	// other services fall back to the default endpoint resolution
+	_, err = cfg.EndpointResolverWithOptions.ResolveEndpoint(dynamodb.ServiceID, "us-west-2")
+	assert.ErrorAs(t, err, new(*aws.EndpointNotFoundError))
+}
+
+func TestEndpointOptionsSkipTLSVerify(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	kclConfig := NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+		WithDynamoDBEndpoint(server.URL)
+	cfg, err := awsConfig.LoadDefaultConfig(context.TODO(), kclConfig.EndpointOptions(dynamodb.ServiceID, kclConfig.DynamoDBEndpoint)...)
+	assert.Nil(t, err)
+
+	// the certificate of the test server is self-signed
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	_, err = cfg.HTTPClient.Do(req)
+	assert.NotNil(t, err)
+
+	kclConfig.WithSkipTLSVerify(true)
+	cfg, err = awsConfig.LoadDefaultConfig(context.TODO(), kclConfig.EndpointOptions(dynamodb.ServiceID, kclConfig.DynamoDBEndpoint)...)
+	assert.Nil(t, err)
+
+	resp, err := cfg.HTTPClient.Do(req)
+	assert.Nil(t, err)
+	resp.Body.Close()
+}
+
+func TestEndpointOptionsProxyURL(t *testing.T) {
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.Host)
+	}))
+	defer proxy.Close()
+
+	kclConfig := NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+		WithProxyURL(proxy.URL)
+	assert.Nil(t, kclConfig.Validate())
+	cfg, err := awsConfig.LoadDefaultConfig(context.TODO(), kclConfig.EndpointOptions(kinesis.ServiceID, "")...)
+	assert.Nil(t, err)
+
+	req, _ := http.NewRequest(http.MethodPost, "http://kinesis.us-west-2.amazonaws.com/", nil)
+	resp, err := cfg.HTTPClient.Do(req)
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, []string{"kinesis.us-west-2.amazonaws.com"}, proxied)
+
+	// the HTTP client of the application is used as it is, the SDK cannot add the CA bundle of the environment to it
+	t.Setenv("AWS_CA_BUNDLE", "")
+	client := &http.Client{}
+	kclConfig.WithHTTPClient(client)
+	cfg, err = awsConfig.LoadDefaultConfig(context.TODO(), kclConfig.EndpointOptions(kinesis.ServiceID, "")...)
+	assert.Nil(t, err)
+	assert.Equal(t, client, cfg.HTTPClient)
+}
+
+func TestConfigHTTPClientValidation(t *testing.T) {
+	kclConfig := NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker")
+	assert.Panics(t, func() { kclConfig.WithHTTPClient(nil) })
+	assert.Panics(t, func() { kclConfig.WithProxyURL("") })
+	assert.Panics(t, func() { kclConfig.WithRetryer(nil) })
+	assert.Panics(t, func() { kclConfig.WithAPICallTimeout("GetRecords", 0) })
+
+	_, err := New("stream", "app", WithRegion("us-west-2"), WithWorkerID("worker"), WithProxyURL("proxy:3128"))
+	assert.NotNil(t, err)
+	_, err = New("stream", "app", WithRegion("us-west-2"), WithWorkerID("worker"), WithProxyURL("http://proxy:3128"),
+		WithHTTPClient(&http.Client{}))
+	assert.NotNil(t, err)
+	assert.NotNil(t, NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+		WithSkipTLSVerify(true).WithHTTPClient(&http.Client{}).Validate())
+	_, err = New("stream", "app", WithRegion("us-west-2"), WithWorkerID("worker"), WithAPICallTimeout("GetRecords", -time.Second))
+	assert.NotNil(t, err)
+	// the events of a subscription are read after the call has returned
+	_, err = New("stream", "app", WithRegion("us-west-2"), WithWorkerID("worker"), WithAPICallTimeout("SubscribeToShard", time.Second))
+	assert.NotNil(t, err)
+
+	kclConfig, err = New("stream", "app", WithRegion("us-west-2"), WithWorkerID("worker"), WithHTTPClient(&http.Client{}),
+		WithAPICallTimeout("GetRecords", time.Second), WithAPICallTimeout("PutItem", 2*time.Second))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]time.Duration{"GetRecords": time.Second, "PutItem": 2 * time.Second}, kclConfig.APICallTimeouts)
+}
+
+func TestConfigLeaseLimits(t *testing.T) {
+	kclConfig := NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker")
+	assert.Equal(t, DefaultMaxLeasesToStealAtOneTime, kclConfig.MaxLeasesToStealAtOneTime)
+	assert.Equal(t, DefaultMaxLeasesToAcquireAtOneTime, kclConfig.MaxLeasesToAcquireAtOneTime)
+
+	kclConfig.WithMaxLeasesToStealAtOneTime(2).WithMaxLeasesToAcquireAtOneTime(3)
+	assert.Equal(t, 2, kclConfig.MaxLeasesToStealAtOneTime)
+	assert.Equal(t, 3, kclConfig.MaxLeasesToAcquireAtOneTime)
+
+	assert.Panics(t, func() {
+		kclConfig.WithMaxLeasesToAcquireAtOneTime(0)
+	})
+}
+
+func TestConfigCheckpointValidation(t *testing.T) {
+	kclConfig := NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker")
+	assert.False(t, kclConfig.AllowCheckpointRewind)
+	assert.Nil(t, kclConfig.CheckpointValidator)
+
+	kclConfig.WithAllowCheckpointRewind(true).
+		WithCheckpointValidator(func(shardID string, current, requested *kcl.ExtendedSequenceNumber) error {
+			return nil
+		})
+	assert.True(t, kclConfig.AllowCheckpointRewind)
+	assert.NotNil(t, kclConfig.CheckpointValidator)
+
+	assert.Panics(t, func() {
+		kclConfig.WithCheckpointValidator(nil)
+	})
+}
+
+func TestNewWithOptions(t *testing.T) {
+	kclConfig, err := New("stream", "app",
+		WithRegion("us-west-2"),
+		WithWorkerID("worker"),
+		WithMaxRecords(100),
+		WithIdleTimeBetweenReadsInMillis(50),
+		WithSettings(func(c *KinesisClientLibConfiguration) {
+			c.WithKPLDeaggregation(false)
+		}))
+	assert.Nil(t, err)
+	assert.Equal(t, "stream", kclConfig.StreamName)
+	assert.Equal(t, "app", kclConfig.ApplicationName)
+	assert.Equal(t, "app", kclConfig.TableName)
+	assert.Equal(t, "us-west-2", kclConfig.RegionName)
+	assert.Equal(t, "worker", kclConfig.WorkerID)
+	assert.Equal(t, 100, kclConfig.MaxRecords)
+	assert.Equal(t, 50, kclConfig.IdleTimeBetweenReadsInMillis)
+	assert.False(t, kclConfig.EnableKPLDeaggregation)
+	assert.Equal(t, DefaultFailoverTimeMillis, kclConfig.FailoverTimeMillis)
+
+	// the region defaults to the environment and the worker ID to a random UUID
+	t.Setenv("AWS_REGION", "eu-central-1")
+	kclConfig, err = New("stream", "app")
+	assert.Nil(t, err)
+	assert.Equal(t, "eu-central-1", kclConfig.RegionName)
+	assert.NotEmpty(t, kclConfig.WorkerID)
+
+	// the streams are set by Streams in multi-stream mode
+	_, err = New("", "app", WithStreams("a", "b"))
+	assert.Nil(t, err)
+
+	// the WithXxx methods apply the options of the same name
+	kclConfig, err = New("stream", "app", WithRegion("us-west-2"), WithWorkerID("worker"),
+		WithSharedLeaseTable("leases"), WithAutoCheckpoint(1000, 10), WithPrefetch(100, 1024))
+	assert.Nil(t, err)
+	assert.Equal(t, kclConfig, NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+		WithSharedLeaseTable("leases").WithAutoCheckpoint(1000, 10).WithPrefetch(100, 1024))
+}
+
+func TestNewValidationErrors(t *testing.T) {
+	t.Setenv("AWS_REGION", "")
+	_, err := New("stream", "",
+		WithRegion("mars-1"),
+		WithIdleTimeBetweenReadsInMillis(-1),
+		WithFailoverTimeMillis(1000),
+		WithLeaseRefreshPeriodMillis(2000),
+		WithStreams("a"),
+		WithStreamProvider(func() ([]string, error) { return nil, nil }),
+		WithRedisCheckpointer("", "", 0))
+
+	var errs ValidationErrors
+	assert.True(t, errors.As(err, &errs))
+	fields := make([]string, 0, len(errs))
+	for _, e := range errs {
+		fields = append(fields, e.Field)
+	}
+	assert.Equal(t, []string{
+		"ApplicationName",
+		"IdleTimeBetweenReadsInMillis",
+		"LeaseRefreshPeriodMillis",
+		"RedisAddress",
+		"RegionName",
+		"Streams",
+		"TableName",
+	}, fields)
+	assert.Equal(t, &ValidationError{Field: "RegionName", Value: "mars-1", Reason: "unknown region"}, errs[4])
+	assert.Contains(t, err.Error(), "invalid RegionName mars-1: unknown region")
+
+	// the configuration of the constructors is valid
+	assert.Nil(t, NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").Validate())
+}
+
+func TestShardFilters(t *testing.T) {
+	shard := func(id, start, end string) ktypes.Shard {
+		return ktypes.Shard{
+			ShardId:      aws.String(id),
+			HashKeyRange: &ktypes.HashKeyRange{StartingHashKey: aws.String(start), EndingHashKey: aws.String(end)},
+		}
+	}
+
+	byID := ShardIDPrefixFilter("shardId-0000", "shardId-0001")
+	assert.True(t, byID("stream", shard("shardId-000012", "0", "1")))
+	assert.True(t, byID("stream", shard("shardId-000100", "0", "1")))
+	assert.False(t, byID("stream", shard("shardId-002000", "0", "1")))
+
+	// the hash key ranges are inclusive
+	byRange := HashKeyRangeFilter(big.NewInt(100), big.NewInt(199))
+	assert.True(t, byRange("stream", shard("shardId-0", "0", "100")))
+	assert.True(t, byRange("stream", shard("shardId-1", "199", "340282366920938463463374607431768211455")))
+	assert.False(t, byRange("stream", shard("shardId-2", "0", "99")))
+	assert.False(t, byRange("stream", shard("shardId-3", "200", "299")))
+	assert.False(t, byRange("stream", ktypes.Shard{ShardId: aws.String("shardId-4")}))
+
+	exact := ShardIDFilter("shardId-0001")
+	assert.True(t, exact("stream", shard("shardId-0001", "0", "1")))
+	assert.False(t, exact("stream", shard("shardId-00012", "0", "1")))
+}
+
+func TestConfigAutoCheckpoint(t *testing.T) {
+	kclConfig := NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker")
+	assert.False(t, kclConfig.AutoCheckpoint)
+
+	kclConfig.WithAutoCheckpoint(1000, 0)
+	assert.True(t, kclConfig.AutoCheckpoint)
+	assert.Equal(t, 1000, kclConfig.AutoCheckpointIntervalMillis)
+	assert.Equal(t, 0, kclConfig.AutoCheckpointRecordCount)
+	assert.Panics(t, func() { kclConfig.WithAutoCheckpoint(-1, 0) })
+
+	_, err := New("stream", "app", WithRegion("us-west-2"), WithWorkerID("worker"), WithAutoCheckpoint(0, -1))
+	var errs ValidationErrors
+	assert.True(t, errors.As(err, &errs))
+	assert.Equal(t, "AutoCheckpointRecordCount", errs[0].Field)
+}
+
+func TestConfigHotShardsReport(t *testing.T) {
+	kclConfig := NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker")
+	assert.Equal(t, 0, kclConfig.HotShardsReportIntervalMillis)
+	assert.Equal(t, DefaultHotShardsTopN, kclConfig.HotShardsTopN)
+
+	kclConfig.WithHotShardsReport(60000, 3)
+	assert.Equal(t, 60000, kclConfig.HotShardsReportIntervalMillis)
+	assert.Equal(t, 3, kclConfig.HotShardsTopN)
+	assert.Panics(t, func() { kclConfig.WithHotShardsReport(60000, 0) })
+	assert.Panics(t, func() { kclConfig.WithHotShardsHandler(nil) })
+
+	// the handler is only called when the hot shards are reported
+	_, err := New("stream", "app", WithRegion("us-west-2"), WithWorkerID("worker"),
+		WithHotShardsHandler(func([]HotShard) {}))
+	var errs ValidationErrors
+	assert.True(t, errors.As(err, &errs))
+	assert.Equal(t, "HotShardsReportIntervalMillis", errs[0].Field)
+}
+
+func TestConfigPinnedShards(t *testing.T) {
+	kclConfig := NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+		WithPinnedShards(false, "shardId-0001")
+	assert.Equal(t, []string{"shardId-0001"}, kclConfig.PinnedShardIDs)
+	assert.False(t, kclConfig.PinnedShardLeaseCoordination)
+	assert.Nil(t, kclConfig.Validate())
+	assert.Panics(t, func() { kclConfig.WithPinnedShards(true) })
+	assert.Panics(t, func() { kclConfig.WithPinnedShards(true, "") })
+
+	// the pinned shards are not assigned by a lease coordinator without lease coordination
+	coordinator := leasecoordinator.Funcs{AcquireFunc: func(context.Context, string, string) (bool, error) {
+		return true, nil
+	}}
+	_, err := New("stream", "app", WithRegion("us-west-2"), WithWorkerID("worker"), WithLeaseCoordinator(coordinator),
+		WithPinnedShards(false, "shardId-0001"))
+	var errs ValidationErrors
+	assert.True(t, errors.As(err, &errs))
+	assert.Equal(t, "LeaseCoordinator", errs[0].Field)
+	_, err = New("stream", "app", WithRegion("us-west-2"), WithWorkerID("worker"), WithLeaseCoordinator(coordinator),
+		WithPinnedShards(true, "shardId-0001"))
+	assert.Nil(t, err)
+
+	_, err = New("stream", "app", WithRegion("us-west-2"), WithWorkerID("worker"), WithPinnedShards(true))
+	assert.True(t, errors.As(err, &errs))
+	assert.Equal(t, "PinnedShardLeaseCoordination", errs[0].Field)
+}
+
+func TestShardAssignments(t *testing.T) {
+	shard := func(id, start string) ktypes.Shard {
+		return ktypes.Shard{
+			ShardId:      aws.String(id),
+			HashKeyRange: &ktypes.HashKeyRange{StartingHashKey: aws.String(start), EndingHashKey: aws.String(start)},
+		}
+	}
+
+	static := StaticShardAssignment(map[string]string{"shardId-0": "worker-a"})
+	assert.Equal(t, "worker-a", static("stream", shard("shardId-0", "0")))
+	assert.Equal(t, "", static("stream", shard("shardId-1", "0")))
+
+	// the hash key space is split into equal ranges by ordinal
+	byOrdinal := StatefulSetAssignment("consumer", 3)
+	assert.Equal(t, "consumer-0", byOrdinal("stream", shard("shardId-0", "0")))
+	assert.Equal(t, "consumer-0", byOrdinal("stream", shard("shardId-1", "113427455640312821154458202477256070484")))
+	assert.Equal(t, "consumer-1", byOrdinal("stream", shard("shardId-2", "113427455640312821154458202477256070486")))
+	assert.Equal(t, "consumer-2", byOrdinal("stream", shard("shardId-3", "340282366920938463463374607431768211455")))
+	assert.Equal(t, "", byOrdinal("stream", ktypes.Shard{ShardId: aws.String("shardId-4")}))
+	assert.Equal(t, "", HashKeyRangeAssignment()("stream", shard("shardId-0", "0")))
+
+	kclConfig := NewKinesisClientLibConfig("app", "stream", "us-west-2", "consumer-0").
+		WithShardAssignment(byOrdinal).
+		WithShardAssignmentFallbackMillis(60000)
+	assert.Nil(t, kclConfig.Validate())
+	assert.Panics(t, func() { kclConfig.WithShardAssignment(nil) })
+	assert.Panics(t, func() { kclConfig.WithShardAssignmentFallbackMillis(0) })
+
+	// the fallback requires an assignment
+	_, err := New("stream", "app", WithRegion("us-west-2"), WithWorkerID("consumer-0"), WithShardAssignmentFallbackMillis(60000))
+	assert.NotNil(t, err)
+	kclConfig, err = New("stream", "app", WithRegion("us-west-2"), WithWorkerID("consumer-0"), WithShardAssignment(byOrdinal),
+		WithShardAssignmentFallbackMillis(60000))
+	assert.Nil(t, err)
+	assert.Equal(t, 60000, kclConfig.ShardAssignmentFallbackMillis)
+}
+
+func TestConfigAlarms(t *testing.T) {
+	kclConfig := NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker")
+	assert.Equal(t, DefaultAlarmEvaluationIntervalMillis, kclConfig.AlarmEvaluationIntervalMillis)
+	assert.Equal(t, 0, kclConfig.CheckpointAgeAlarmMillis)
+
+	handler := func(Alarm) {}
+	kclConfig.WithCheckpointAgeAlarm(60000).WithMillisBehindLatestAlarm(30000).WithAlarmEvaluationIntervalMillis(5000).
+		WithAlarmHandler(handler)
+	assert.Nil(t, kclConfig.Validate())
+	assert.Equal(t, 60000, kclConfig.CheckpointAgeAlarmMillis)
+	assert.Equal(t, 30000, kclConfig.MillisBehindLatestAlarmMillis)
+	assert.Equal(t, 5000, kclConfig.AlarmEvaluationIntervalMillis)
+	assert.Panics(t, func() { kclConfig.WithAlarmHandler(nil) })
+	assert.Panics(t, func() { kclConfig.WithCheckpointAgeAlarm(0) })
+
+	// the handler is only called with a threshold
+	_, err := New("stream", "app", WithRegion("us-west-2"), WithWorkerID("worker"), WithAlarmHandler(handler))
+	assert.NotNil(t, err)
+	kclConfig, err = New("stream", "app", WithRegion("us-west-2"), WithWorkerID("worker"), WithAlarmHandler(handler),
+		WithMillisBehindLatestAlarm(30000), WithAlarmEvaluationIntervalMillis(1000))
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, kclConfig.AlarmEvaluationIntervalMillis)
+	_, err = New("stream", "app", WithRegion("us-west-2"), WithWorkerID("worker"), WithCheckpointAgeAlarm(-1))
+	assert.NotNil(t, err)
+}
+
+func TestConfigMetrics(t *testing.T) {
+	kclConfig := NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker")
+	assert.Equal(t, DefaultShardMetricsSampleRatio, kclConfig.ShardMetricsSampleRatio)
+
+	kclConfig.WithMetricsNamespace("namespace").WithDisabledMetrics(metrics.CheckpointTimeMetric).
+		WithShardMetricsSampleRatio(0.1)
+	assert.Nil(t, kclConfig.Validate())
+	assert.Equal(t, "namespace", kclConfig.MetricsNamespace)
+	assert.Equal(t, []metrics.Metric{metrics.CheckpointTimeMetric}, kclConfig.DisabledMetrics)
+	assert.Equal(t, 0.1, kclConfig.ShardMetricsSampleRatio)
+	assert.Panics(t, func() { kclConfig.WithShardMetricsSampleRatio(0) })
+	assert.Panics(t, func() { kclConfig.WithShardMetricsSampleRatio(1.5) })
+	assert.Panics(t, func() { kclConfig.WithMetricsNamespace("") })
+
+	_, err := New("stream", "app", WithRegion("us-west-2"), WithWorkerID("worker"), WithShardMetricsSampleRatio(2))
+	assert.NotNil(t, err)
+	kclConfig, err = New("stream", "app", WithRegion("us-west-2"), WithWorkerID("worker"),
+		WithDisabledMetrics(metrics.RecordsProcessedMetric), WithShardMetricsSampleRatio(0.5))
+	assert.Nil(t, err)
+	assert.Equal(t, 0.5, kclConfig.ShardMetricsSampleRatio)
+}
+
+func TestWorkerIDProvider(t *testing.T) {
+	hostname, err := os.Hostname()
+	assert.Nil(t, err)
+	kclConfig := NewKinesisClientLibConfig("app", "stream", "us-west-2", "").WithWorkerIDProvider(HostnameWorkerID())
+	assert.Equal(t, hostname, kclConfig.WorkerID)
+
+	t.Setenv("KCL_TEST_POD_NAME", "consumer-0")
+	kclConfig, err = New("stream", "app", WithRegion("us-west-2"), WithWorkerIDProvider(EnvWorkerID("KCL_TEST_POD_NAME")),
+		WithReclaimLeasesOnStartup(true))
+	assert.Nil(t, err)
+	assert.Equal(t, "consumer-0", kclConfig.WorkerID)
+	assert.True(t, kclConfig.ReclaimLeasesOnStartup)
+
+	_, err = New("stream", "app", WithRegion("us-west-2"), WithWorkerIDProvider(EnvWorkerID("KCL_TEST_UNSET")))
+	assert.EqualError(t, err, "failed to get the worker ID: environment variable KCL_TEST_UNSET is not set")
+	assert.Panics(t, func() {
+		NewKinesisClientLibConfig("app", "stream", "us-west-2", "").WithWorkerIDProvider(func() (string, error) {
+			return "", nil
+		})
+	})
+}
+
+func TestLoadAWSConfig(t *testing.T) {
+	creds := credentials.NewStaticCredentialsProvider("id", "secret", "")
+	kclConfig := NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+		WithAWSConfig(aws.Config{Region: "eu-west-1", Credentials: creds})
+
+	// the injected config is used as it is
+	cfg, err := kclConfig.LoadAWSConfig(kinesis.ServiceID, "", nil, "", metrics.NoopMonitoringService{})
+	assert.Nil(t, err)
+	assert.Equal(t, "eu-west-1", cfg.Region)
+	assert.Equal(t, creds, cfg.Credentials)
+	assert.Nil(t, cfg.EndpointResolverWithOptions)
+	assert.NotNil(t, cfg.Retryer)
+
+	// the endpoint is overridden and signed for the region of the injected config
+	cfg, err = kclConfig.LoadAWSConfig(kinesis.ServiceID, "http://localhost:4566", nil, "", metrics.NoopMonitoringService{})
+	assert.Nil(t, err)
+	endpoint, err := cfg.EndpointResolverWithOptions.ResolveEndpoint(kinesis.ServiceID, cfg.Region)
+	assert.Nil(t, err)
+	assert.Equal(t, "http://localhost:4566", endpoint.URL)
+	assert.Equal(t, "eu-west-1", endpoint.SigningRegion)
+	// the injected config is not modified
+	assert.Nil(t, kclConfig.AWSConfig.EndpointResolverWithOptions)
+	assert.Nil(t, kclConfig.AWSConfig.Retryer)
+
+	// the TLS verification cannot be skipped by the HTTP client of the injected config
+	assert.Nil(t, kclConfig.Validate())
+	assert.NotNil(t, kclConfig.WithSkipTLSVerify(true).Validate())
+}
+
+func TestLoadAWSConfigHTTPClientAndRetryer(t *testing.T) {
+	injected := retry.NewStandard()
+	kclConfig := NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+		WithAWSConfig(aws.Config{Region: "eu-west-1", Retryer: func() aws.Retryer { return injected }})
+
+	cfg, err := kclConfig.LoadAWSConfig(kinesis.ServiceID, "", nil, "", nil)
+	assert.Nil(t, err)
+	assert.Nil(t, cfg.HTTPClient)
+	assert.Equal(t, injected, cfg.Retryer())
+
+	// the HTTP client and the retryer of the configuration replace the ones of the injected config
+	client := &http.Client{}
+	custom := retry.AddWithMaxAttempts(retry.NewStandard(), 5)
+	kclConfig.WithHTTPClient(client).WithRetryer(func() aws.Retryer { return custom })
+	cfg, err = kclConfig.LoadAWSConfig(kinesis.ServiceID, "", nil, "", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, client, cfg.HTTPClient)
+	retryer := cfg.Retryer()
+	assert.Equal(t, 5, retryer.MaxAttempts())
+	// the throttled attempts of the custom retryer are counted
+	assert.IsType(t, &throttleRecorder{}, retryer)
+}
+
+func TestLoadAWSConfigAPICallTimeouts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Amz-Target") == "Kinesis_20131202.ListShards" {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		_, _ = w.Write([]byte(`{"StreamDescriptionSummary": {"StreamName": "stream"}}`))
+	}))
+	defer server.Close()
+
+	kclConfig := NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+		WithAWSConfig(aws.Config{Region: "us-west-2", Credentials: credentials.NewStaticCredentialsProvider("id", "secret", "")}).
+		WithAPICallTimeout("ListShards", 50*time.Millisecond)
+	cfg, err := kclConfig.LoadAWSConfig(kinesis.ServiceID, server.URL, nil, "", nil)
+	assert.Nil(t, err)
+	// the options of the injected config are not modified
+	assert.Empty(t, kclConfig.AWSConfig.APIOptions)
+	client := kinesis.NewFromConfig(cfg)
+
+	start := time.Now()
+	_, err = client.ListShards(context.TODO(), &kinesis.ListShardsInput{StreamName: aws.String("stream")})
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "%v", err)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+
+	// the calls of other operations are not bounded
+	output, err := client.DescribeStreamSummary(context.TODO(), &kinesis.DescribeStreamSummaryInput{StreamName: aws.String("stream")})
+	assert.Nil(t, err)
+	assert.Equal(t, "stream", aws.ToString(output.StreamDescriptionSummary.StreamName))
+}
+
+func TestLoadAWSConfigAssumeRole(t *testing.T) {
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		assert.Nil(t, r.ParseForm())
+		assert.Equal(t, "AssumeRole", r.Form.Get("Action"))
+		assert.Equal(t, "arn:aws:iam::123456789012:role/stream", r.Form.Get("RoleArn"))
+		assert.Equal(t, "worker", r.Form.Get("RoleSessionName"))
+		assert.Equal(t, "external", r.Form.Get("ExternalId"))
+
+		w.Header().Set("Content-Type", "text/xml")
+		_, _ = w.Write([]byte(`<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult>
+    <Credentials>
+      <AccessKeyId>role-id</AccessKeyId>
+      <SecretAccessKey>role-secret</SecretAccessKey>
+      <SessionToken>token</SessionToken>
+      <Expiration>` + time.Now().Add(time.Hour).UTC().Format(time.RFC3339) + `</Expiration>
+    </Credentials>
+  </AssumeRoleResult>
+</AssumeRoleResponse>`))
+	}))
+	defer server.Close()
+
+	kclConfig := NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+		WithAWSConfig(aws.Config{
+			Region:                      "us-west-2",
+			Credentials:                 credentials.NewStaticCredentialsProvider("id", "secret", ""),
+			EndpointResolverWithOptions: endpointResolver("STS", server.URL),
+		}).
+		WithKinesisRoleARN("arn:aws:iam::123456789012:role/stream").
+		WithAssumeRoleSessionName("worker").
+		WithAssumeRoleExternalID("external")
+
+	cfg, err := kclConfig.LoadAWSConfig(kinesis.ServiceID, "", nil, kclConfig.KinesisRoleARN, metrics.NoopMonitoringService{})
+	assert.Nil(t, err)
+
+	// the temporary credentials are cached until they expire
+	for i := 0; i < 2; i++ {
+		creds, err := cfg.Credentials.Retrieve(context.TODO())
+		assert.Nil(t, err)
+		assert.Equal(t, "role-id", creds.AccessKeyID)
+		assert.Equal(t, "token", creds.SessionToken)
+	}
+	assert.Equal(t, 1, calls)
+}
+
+func TestConfigDebugServer(t *testing.T) {
+	kclConfig := NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker")
+	assert.Equal(t, "", kclConfig.DebugServerAddress)
+
+	authorizer := func(*http.Request) error { return nil }
+	kclConfig.WithDebugServer("localhost:6060", authorizer)
+	assert.Equal(t, "localhost:6060", kclConfig.DebugServerAddress)
+	assert.NotNil(t, kclConfig.DebugAuthorizer)
+
+	assert.Panics(t, func() { kclConfig.WithDebugServer("", authorizer) })
+
+	kclConfig, err := New("stream", "app", WithRegion("us-west-2"), WithWorkerID("worker"),
+		WithDebugServer("localhost:6060", nil))
+	assert.Nil(t, err)
+	assert.Equal(t, "localhost:6060", kclConfig.DebugServerAddress)
+	assert.Nil(t, kclConfig.DebugAuthorizer)
+}
+
+func TestConfigLeaseTableReadConsistency(t *testing.T) {
+	kclConfig := NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker")
+	assert.Equal(t, EventuallyConsistent, kclConfig.LeaseScanConsistency)
+	assert.Equal(t, StronglyConsistent, kclConfig.LeaseGetConsistency)
+	assert.Nil(t, kclConfig.LeaseTableCapacityHandler)
+
+	kclConfig.WithLeaseTableReadConsistency(StronglyConsistent, EventuallyConsistent).
+		WithLeaseTableCapacityHandler(func(*LeaseTableCapacity) {})
+	assert.Equal(t, StronglyConsistent, kclConfig.LeaseScanConsistency)
+	assert.Equal(t, EventuallyConsistent, kclConfig.LeaseGetConsistency)
+	assert.NotNil(t, kclConfig.LeaseTableCapacityHandler)
+
+	assert.Panics(t, func() { kclConfig.WithLeaseTableReadConsistency(ReadConsistency(3), StronglyConsistent) })
+	assert.Panics(t, func() { kclConfig.WithLeaseTableCapacityHandler(nil) })
+
+	_, err := New("stream", "app", WithRegion("us-west-2"), WithWorkerID("worker"),
+		WithLeaseTableReadConsistency(EventuallyConsistent, ReadConsistency(0)))
+	assert.NotNil(t, err)
+}
+
+func TestConfigLeaseAttributeCompression(t *testing.T) {
+	kclConfig := NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker")
+	assert.Equal(t, 0, kclConfig.LeaseAttributeCompressionThreshold)
+
+	kclConfig.WithLeaseAttributeCompressionThreshold(4096)
+	assert.Equal(t, 4096, kclConfig.LeaseAttributeCompressionThreshold)
+	assert.Panics(t, func() { kclConfig.WithLeaseAttributeCompressionThreshold(0) })
+
+	_, err := New("stream", "app", WithRegion("us-west-2"), WithWorkerID("worker"),
+		WithLeaseAttributeCompressionThreshold(-1))
+	assert.NotNil(t, err)
+}
+
+func TestConfigShardEndSync(t *testing.T) {
+	kclConfig := NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker")
+	assert.Equal(t, 0, kclConfig.ShardEndSyncIntervalMillis)
+
+	kclConfig.WithShardEndSync(1000, 60000)
+	assert.Equal(t, 1000, kclConfig.ShardEndSyncIntervalMillis)
+	assert.Equal(t, 60000, kclConfig.ShardEndSyncDurationMillis)
+	assert.Nil(t, kclConfig.Validate())
+
+	assert.Panics(t, func() { kclConfig.WithShardEndSync(0, 60000) })
+	assert.Panics(t, func() { kclConfig.WithShardEndSync(1000, 0) })
+
+	// the rapid syncs are not slower than the regular ones
+	_, err := New("stream", "app", WithRegion("us-west-2"), WithWorkerID("worker"),
+		WithShardSyncIntervalMillis(1000), WithShardEndSync(2000, 60000))
+	assert.NotNil(t, err)
+	_, err = New("stream", "app", WithRegion("us-west-2"), WithWorkerID("worker"), WithShardEndSync(1000, 0))
+	assert.NotNil(t, err)
+}
+
+func TestConfigFailover(t *testing.T) {
+	kclConfig := NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+		WithFailover("us-east-1", "stream", 60000).
+		WithSequenceNumberTranslator(func(streamName, shardID, sequenceNumber string) (string, string, error) {
+			return shardID, sequenceNumber, nil
+		})
+	assert.Equal(t, "us-east-1", kclConfig.SecondaryRegionName)
+	assert.Equal(t, "stream", kclConfig.SecondaryStreamName)
+	assert.Equal(t, 60000, kclConfig.RegionFailoverAfterMillis)
+	assert.NotNil(t, kclConfig.SequenceNumberTranslator)
+	assert.Nil(t, kclConfig.Validate())
+
+	assert.Panics(t, func() { kclConfig.WithFailover("", "stream", 60000) })
+	assert.Panics(t, func() { kclConfig.WithFailover("us-east-1", "stream", 0) })
+	assert.Panics(t, func() { kclConfig.WithSequenceNumberTranslator(nil) })
+
+	// the secondary stream is another stream in a known region
+	_, err := New("stream", "app", WithRegion("us-west-2"), WithWorkerID("worker"), WithFailover("us-west-2", "stream", 60000))
+	assert.NotNil(t, err)
+	_, err = New("stream", "app", WithRegion("us-west-2"), WithWorkerID("worker"), WithFailover("mars", "stream", 60000))
+	assert.NotNil(t, err)
+	_, err = New("", "app", WithRegion("us-west-2"), WithWorkerID("worker"), WithStreams("a", "b"),
+		WithFailover("us-east-1", "stream", 60000))
+	assert.NotNil(t, err)
+}
+
+func TestConfigLeaseAuditSink(t *testing.T) {
+	kclConfig := NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker")
+	assert.Nil(t, kclConfig.LeaseAuditSink)
+
+	sink := leaseaudit.NewLogSink(kclConfig.Logger)
+	kclConfig.WithLeaseAuditSink(sink)
+	assert.Equal(t, sink, kclConfig.LeaseAuditSink)
+	assert.Panics(t, func() { kclConfig.WithLeaseAuditSink(nil) })
+
+	kclConfig, err := New("stream", "app", WithRegion("us-west-2"), WithWorkerID("worker"), WithLeaseAuditSink(sink))
+	assert.Nil(t, err)
+	assert.Equal(t, sink, kclConfig.LeaseAuditSink)
+}
+
+func TestConfigLeaseCoordinator(t *testing.T) {
+	kclConfig := NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker")
+	assert.Nil(t, kclConfig.LeaseCoordinator)
+
+	coordinator := leasecoordinator.Funcs{AcquireFunc: func(context.Context, string, string) (bool, error) {
+		return true, nil
+	}}
+	kclConfig.WithLeaseCoordinator(coordinator)
+	assert.NotNil(t, kclConfig.LeaseCoordinator)
+	assert.Panics(t, func() { kclConfig.WithLeaseCoordinator(nil) })
+
+	kclConfig, err := New("stream", "app", WithRegion("us-west-2"), WithWorkerID("worker"), WithLeaseCoordinator(coordinator))
+	assert.Nil(t, err)
+	assert.NotNil(t, kclConfig.LeaseCoordinator)
+
+	// the shards are not stolen from the workers they are assigned to by the coordinator
+	_, err = New("stream", "app", WithRegion("us-west-2"), WithWorkerID("worker"), WithLeaseCoordinator(coordinator),
+		WithLeaseStealing(true))
+	assert.NotNil(t, err)
+}
+
+func TestConfigTableNameTemplate(t *testing.T) {
+	kclConfig := NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+		WithTableNameTemplate("{appName}-{env}-leases", map[string]string{"env": "prod"})
+	assert.Equal(t, "app-prod-leases", kclConfig.TableName)
+	assert.Panics(t, func() { kclConfig.WithTableNameTemplate("{appName}-{env}-leases", nil) })
+
+	// the template is expanded once all options are applied
+	kclConfig, err := New("stream", "app", WithTableNameTemplate("{appName}-{streamName}-{region}", nil),
+		WithRegion("us-west-2"), WithWorkerID("worker"))
+	assert.Nil(t, err)
+	assert.Equal(t, "app-stream-us-west-2", kclConfig.TableName)
+
+	_, err = New("stream", "app", WithRegion("us-west-2"), WithWorkerID("worker"),
+		WithTableNameTemplate("{appName}-{env}-{team}", nil))
+	assert.EqualError(t, err, `no value for {env}, {team} in lease table name template "{appName}-{env}-{team}"`)
+}
+
+func TestConfigSharedLeaseTable(t *testing.T) {
+	kclConfig, err := New("stream", "app", WithRegion("us-west-2"), WithWorkerID("worker"), WithSharedLeaseTable("leases"))
+	assert.Nil(t, err)
+	assert.Equal(t, "leases", kclConfig.TableName)
+	assert.Equal(t, "app#", kclConfig.LeaseKeyPrefix)
+
+	kclConfig, err = New("stream", "app", WithRegion("us-west-2"), WithWorkerID("worker"), WithLeaseKeyPrefix("team/app/"))
+	assert.Nil(t, err)
+	assert.Equal(t, "app", kclConfig.TableName)
+	assert.Equal(t, "team/app/", kclConfig.LeaseKeyPrefix)
+	assert.Panics(t, func() { kclConfig.WithSharedLeaseTable("") })
+
+	// the prefix of app "a" would match the leases of app "a#b"
+	assert.Panics(t, func() {
+		NewKinesisClientLibConfig("a#b", "stream", "us-west-2", "worker").WithSharedLeaseTable("leases")
+	})
+	_, err = New("stream", "a#b", WithRegion("us-west-2"), WithWorkerID("worker"), WithSharedLeaseTable("leases"))
+	var errs ValidationErrors
+	assert.True(t, errors.As(err, &errs))
+	assert.Equal(t, "LeaseKeyPrefix", errs[0].Field)
+}
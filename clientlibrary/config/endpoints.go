@@ -0,0 +1,174 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package config
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	awsConfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go/middleware"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/metrics"
+)
+
+// LoadAWSConfig returns the AWS config of the client of the given service. An injected AWSConfig is used as it is,
+// only the endpoint and the HTTP client are overridden if they are configured and the retry policy applies unless it
+// has a retryer. Otherwise the config is loaded from the environment with the given credentials. The given IAM role
+// is assumed with these credentials unless roleARN is empty.
+func (c *KinesisClientLibConfiguration) LoadAWSConfig(serviceID, endpoint string, credentials aws.CredentialsProvider, roleARN string, mService metrics.MonitoringService) (aws.Config, error) {
+	if mService == nil {
+		mService = metrics.NoopMonitoringService{}
+	}
+	newRetryer := func() aws.Retryer {
+		if c.Retryer != nil {
+			return recordThrottles(c.Retryer(), mService)
+		}
+		return c.RetryPolicy.NewRetryer(mService)
+	}
+
+	if c.AWSConfig != nil {
+		cfg := c.AWSConfig.Copy()
+		if len(endpoint) > 0 {
+			cfg.EndpointResolverWithOptions = endpointResolver(serviceID, endpoint)
+		}
+		if c.HTTPClient != nil {
+			cfg.HTTPClient = c.HTTPClient
+		}
+		if cfg.Retryer == nil || c.Retryer != nil {
+			cfg.Retryer = newRetryer
+		}
+		c.addAPICallTimeouts(&cfg)
+		c.assumeRole(&cfg, roleARN)
+		return cfg, nil
+	}
+
+	options := append(c.EndpointOptions(serviceID, endpoint),
+		awsConfig.WithRegion(c.RegionName),
+		awsConfig.WithCredentialsProvider(credentials),
+		awsConfig.WithRetryer(newRetryer),
+	)
+
+	cfg, err := awsConfig.LoadDefaultConfig(context.TODO(), options...)
+	if err != nil {
+		return cfg, err
+	}
+
+	c.addAPICallTimeouts(&cfg)
+	c.assumeRole(&cfg, roleARN)
+	return cfg, nil
+}
+
+// addAPICallTimeouts bounds the calls of the operations of APICallTimeouts, including their retries, by the timeouts
+func (c *KinesisClientLibConfiguration) addAPICallTimeouts(cfg *aws.Config) {
+	if len(c.APICallTimeouts) == 0 {
+		return
+	}
+
+	timeouts := make(map[string]time.Duration, len(c.APICallTimeouts))
+	for operation, timeout := range c.APICallTimeouts {
+		timeouts[operation] = timeout
+	}
+	addTimeout := func(stack *middleware.Stack) error {
+		// the ID of the stack is the name of the operation
+		timeout, ok := timeouts[stack.ID()]
+		if !ok {
+			return nil
+		}
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("KCLAPICallTimeout", func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			return next.HandleInitialize(ctx, in)
+		}), middleware.Before)
+	}
+	// the options of an injected config are not modified
+	cfg.APIOptions = append(cfg.APIOptions[:len(cfg.APIOptions):len(cfg.APIOptions)], addTimeout)
+}
+
+// assumeRole replaces the credentials of the config by the temporary credentials of the given IAM role, which are
+// obtained from STS with the original credentials and refreshed before they expire.
+func (c *KinesisClientLibConfiguration) assumeRole(cfg *aws.Config, roleARN string) {
+	if len(roleARN) == 0 {
+		return
+	}
+
+	provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(*cfg), roleARN, func(o *stscreds.AssumeRoleOptions) {
+		if len(c.AssumeRoleSessionName) > 0 {
+			o.RoleSessionName = c.AssumeRoleSessionName
+		}
+		if len(c.AssumeRoleExternalID) > 0 {
+			o.ExternalID = aws.String(c.AssumeRoleExternalID)
+		}
+	})
+	cfg.Credentials = aws.NewCredentialsCache(provider)
+}
+
+// EndpointOptions returns the options loading the AWS config of the client of the given service. The client is
+// pointed at the given endpoint unless it is empty, the default endpoint resolution applies to other services.
+func (c *KinesisClientLibConfiguration) EndpointOptions(serviceID, endpoint string) []func(*awsConfig.LoadOptions) error {
+	options := []func(*awsConfig.LoadOptions) error{
+		awsConfig.WithEndpointResolverWithOptions(endpointResolver(serviceID, endpoint)),
+	}
+
+	if c.HTTPClient != nil {
+		return append(options, awsConfig.WithHTTPClient(c.HTTPClient))
+	}
+
+	if c.SkipTLSVerify || len(c.ProxyURL) > 0 {
+		options = append(options, awsConfig.WithHTTPClient(awshttp.NewBuildableClient().WithTransportOptions(func(tr *http.Transport) {
+			if c.SkipTLSVerify {
+				if tr.TLSClientConfig == nil {
+					tr.TLSClientConfig = &tls.Config{}
+				}
+				tr.TLSClientConfig.InsecureSkipVerify = true
+			}
+			// the URL has been validated with the configuration
+			if proxyURL, err := url.Parse(c.ProxyURL); err == nil && len(c.ProxyURL) > 0 {
+				tr.Proxy = http.ProxyURL(proxyURL)
+			}
+		})))
+	}
+
+	return options
+}
+
+// endpointResolver resolves the given endpoint for the service unless it is empty
+func endpointResolver(serviceID, endpoint string) aws.EndpointResolverWithOptions {
+	return aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
+		if service == serviceID && len(endpoint) > 0 {
+			return aws.Endpoint{
+				PartitionID:   "aws",
+				URL:           endpoint,
+				SigningRegion: region,
+				// the host of the endpoint is never prefixed by the SDK
+				HostnameImmutable: true,
+			}, nil
+		}
+		// returning EndpointNotFoundError will allow the service to fallback to it's default resolution
+		return aws.Endpoint{}, &aws.EndpointNotFoundError{}
+	})
+}
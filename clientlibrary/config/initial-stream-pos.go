@@ -44,6 +44,10 @@ func newInitialPositionAtTimestamp(timestamp *time.Time) *InitialPositionInStrea
 	return &InitialPositionInStreamExtended{Position: AT_TIMESTAMP, Timestamp: timestamp}
 }
 
+func newInitialPositionAtSequenceNumber(sequenceNumbers map[string]string) *InitialPositionInStreamExtended {
+	return &InitialPositionInStreamExtended{Position: AT_SEQUENCE_NUMBER, SequenceNumbers: sequenceNumbers}
+}
+
 func newInitialPosition(position InitialPositionInStream) *InitialPositionInStreamExtended {
 	return &InitialPositionInStreamExtended{Position: position, Timestamp: nil}
 }
@@ -38,11 +38,20 @@ package config
 
 import (
 	"log"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	ktypes "github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/deadletter"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/decoder"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/leaseaudit"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/leasecoordinator"
 	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/metrics"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/transformer"
 	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/utils"
 	"github.com/vmware/vmware-go-kcl-v2/logger"
 )
@@ -66,6 +75,13 @@ func NewKinesisClientLibConfigWithCredentials(applicationName, streamName, regio
 	checkIsValueNotEmpty("StreamName", streamName)
 	checkIsValueNotEmpty("RegionName", regionName)
 
+	return newDefaultConfiguration(applicationName, streamName, regionName, workerID, kinesisCreds, dynamodbCreds)
+}
+
+// newDefaultConfiguration creates a KinesisClientLibConfiguration with default values without validating the
+// required fields
+func newDefaultConfiguration(applicationName, streamName, regionName, workerID string,
+	kinesisCreds, dynamodbCreds aws.CredentialsProvider) *KinesisClientLibConfiguration {
 	if empty(workerID) {
 		workerID = utils.MustNewUUID()
 	}
@@ -95,69 +111,283 @@ func NewKinesisClientLibConfigWithCredentials(applicationName, streamName, regio
 		ShutdownGraceMillis:                              DefaultShutdownGraceMillis,
 		MaxLeasesForWorker:                               DefaultMaxLeasesForWorker,
 		MaxLeasesToStealAtOneTime:                        DefaultMaxLeasesToStealAtOneTime,
+		MaxLeasesToAcquireAtOneTime:                      DefaultMaxLeasesToAcquireAtOneTime,
 		InitialLeaseTableReadCapacity:                    DefaultInitialLeaseTableReadCapacity,
 		InitialLeaseTableWriteCapacity:                   DefaultInitialLeaseTableWriteCapacity,
 		SkipShardSyncAtWorkerInitializationIfLeasesExist: DefaultSkipShardSyncAtStartupIfLeasesExist,
+		ReclaimLeasesOnStartup:                           DefaultReclaimLeasesOnStartup,
 		EnableLeaseStealing:                              DefaultEnableLeaseStealing,
 		LeaseStealingIntervalMillis:                      DefaultLeaseStealingIntervalMillis,
+		CleanupLeasesUponShardCompletion:                 DefaultCleanupLeasesUponShardCompletion,
+		LeaseCleanupIntervalMillis:                       DefaultLeaseCleanupIntervalMillis,
 		LeaseStealingClaimTimeoutMillis:                  DefaultLeaseStealingClaimTimeoutMillis,
+		LeaseStealingHandoffTimeoutMillis:                DefaultLeaseStealingHandoffTimeoutMillis,
 		LeaseSyncingTimeIntervalMillis:                   DefaultLeaseSyncingIntervalMillis,
+		LeaseTableScanSegments:                           DefaultLeaseTableScanSegments,
+		LeaseScanConsistency:                             DefaultLeaseScanConsistency,
+		LeaseGetConsistency:                              DefaultLeaseGetConsistency,
 		MaxRetryCount:                                    DefaultMaxRetryCount,
+		EnableKPLDeaggregation:                           DefaultEnableKPLDeaggregation,
+		LeaseTableBillingMode:                            DefaultLeaseTableBillingMode,
+		ProcessorPanicPolicy:                             DefaultProcessorPanicPolicy,
+		ProcessorRestartBackoffMillis:                    DefaultProcessorRestartBackoffMillis,
+		MaxProcessRecordsRetries:                         DefaultMaxProcessRecordsRetries,
+		ProcessRecordsErrorPolicy:                        DefaultProcessRecordsErrorPolicy,
+		AsyncCheckpointIntervalMillis:                    DefaultAsyncCheckpointIntervalMillis,
+		CheckpointBackend:                                DefaultCheckpointBackend,
+		AlarmEvaluationIntervalMillis:                    DefaultAlarmEvaluationIntervalMillis,
+		HotShardsTopN:                                    DefaultHotShardsTopN,
+		ShardMetricsSampleRatio:                          DefaultShardMetricsSampleRatio,
 		Logger:                                           logger.GetDefaultLogger(),
+		RetryPolicy: RetryPolicy{
+			MaxAttempts:     DefaultRetryMaxAttempts,
+			BaseDelayMillis: DefaultRetryBaseDelayMillis,
+			MaxDelayMillis:  DefaultRetryMaxDelayMillis,
+			Jitter:          DefaultRetryJitter,
+		},
 	}
 }
 
 // WithKinesisEndpoint is used to provide an alternative Kinesis endpoint
 func (c *KinesisClientLibConfiguration) WithKinesisEndpoint(kinesisEndpoint string) *KinesisClientLibConfiguration {
-	c.KinesisEndpoint = kinesisEndpoint
+	WithKinesisEndpoint(kinesisEndpoint)(c)
 	return c
 }
 
 // WithDynamoDBEndpoint is used to provide an alternative DynamoDB endpoint
 func (c *KinesisClientLibConfiguration) WithDynamoDBEndpoint(dynamoDBEndpoint string) *KinesisClientLibConfiguration {
-	c.DynamoDBEndpoint = dynamoDBEndpoint
+	WithDynamoDBEndpoint(dynamoDBEndpoint)(c)
+	return c
+}
+
+// WithAWSConfig is used to provide the AWS config of the Kinesis and DynamoDB clients instead of deriving it from
+// the region and the credentials of this configuration
+func (c *KinesisClientLibConfiguration) WithAWSConfig(cfg aws.Config) *KinesisClientLibConfiguration {
+	WithAWSConfig(cfg)(c)
+	return c
+}
+
+// WithHTTPClient sets the HTTP client of the Kinesis and DynamoDB clients, e.g. with the client certificates of mTLS
+func (c *KinesisClientLibConfiguration) WithHTTPClient(client aws.HTTPClient) *KinesisClientLibConfiguration {
+	if client == nil {
+		log.Panic("HTTPClient should not be nil")
+	}
+	WithHTTPClient(client)(c)
+	return c
+}
+
+// WithProxyURL sends the calls to Kinesis and DynamoDB through the HTTP proxy, e.g. "http://proxy.example.com:3128"
+func (c *KinesisClientLibConfiguration) WithProxyURL(proxyURL string) *KinesisClientLibConfiguration {
+	checkIsValueNotEmpty("ProxyURL", proxyURL)
+	WithProxyURL(proxyURL)(c)
+	return c
+}
+
+// WithRetryer sets the constructor of the retryer of the Kinesis and DynamoDB clients, e.g.
+// func() aws.Retryer { return retry.AddWithMaxAttempts(retry.NewStandard(), 5) }.
+func (c *KinesisClientLibConfiguration) WithRetryer(newRetryer func() aws.Retryer) *KinesisClientLibConfiguration {
+	if newRetryer == nil {
+		log.Panic("Retryer should not be nil")
+	}
+	WithRetryer(newRetryer)(c)
+	return c
+}
+
+// WithAPICallTimeout sets the timeout of the calls of an operation of Kinesis or DynamoDB, e.g. "GetRecords"
+func (c *KinesisClientLibConfiguration) WithAPICallTimeout(operation string, timeout time.Duration) *KinesisClientLibConfiguration {
+	checkIsValueNotEmpty("APICallTimeouts", operation)
+	checkIsValuePositive("APICallTimeouts."+operation, int(timeout.Milliseconds()))
+	WithAPICallTimeout(operation, timeout)(c)
+	return c
+}
+
+// WithKinesisRoleARN is used to assume the given IAM role to access Kinesis. The temporary credentials are
+// obtained with KinesisCredentials and refreshed automatically.
+func (c *KinesisClientLibConfiguration) WithKinesisRoleARN(roleARN string) *KinesisClientLibConfiguration {
+	checkIsValueNotEmpty("KinesisRoleARN", roleARN)
+	c.KinesisRoleARN = roleARN
+	return c
+}
+
+// WithDynamoDBRoleARN is used to assume the given IAM role to access the lease table. The temporary credentials
+// are obtained with DynamoDBCredentials and refreshed automatically.
+func (c *KinesisClientLibConfiguration) WithDynamoDBRoleARN(roleARN string) *KinesisClientLibConfiguration {
+	checkIsValueNotEmpty("DynamoDBRoleARN", roleARN)
+	c.DynamoDBRoleARN = roleARN
+	return c
+}
+
+// WithAssumeRoleSessionName sets the session name of the assumed IAM roles
+func (c *KinesisClientLibConfiguration) WithAssumeRoleSessionName(sessionName string) *KinesisClientLibConfiguration {
+	checkIsValueNotEmpty("AssumeRoleSessionName", sessionName)
+	c.AssumeRoleSessionName = sessionName
+	return c
+}
+
+// WithAssumeRoleExternalID sets the external ID required to assume the IAM roles
+func (c *KinesisClientLibConfiguration) WithAssumeRoleExternalID(externalID string) *KinesisClientLibConfiguration {
+	checkIsValueNotEmpty("AssumeRoleExternalID", externalID)
+	c.AssumeRoleExternalID = externalID
+	return c
+}
+
+// WithLocalStackEndpoint points both the Kinesis and the DynamoDB clients at a single endpoint, e.g. the edge
+// endpoint "http://localhost:4566" of LocalStack
+func (c *KinesisClientLibConfiguration) WithLocalStackEndpoint(endpoint string) *KinesisClientLibConfiguration {
+	checkIsValueNotEmpty("LocalStackEndpoint", endpoint)
+	c.KinesisEndpoint = endpoint
+	c.DynamoDBEndpoint = endpoint
+	return c
+}
+
+// WithSkipTLSVerify disables the verification of the TLS certificates of the Kinesis and DynamoDB endpoints
+func (c *KinesisClientLibConfiguration) WithSkipTLSVerify(skipTLSVerify bool) *KinesisClientLibConfiguration {
+	c.SkipTLSVerify = skipTLSVerify
 	return c
 }
 
 // WithTableName to provide alternative lease table in DynamoDB
 func (c *KinesisClientLibConfiguration) WithTableName(tableName string) *KinesisClientLibConfiguration {
-	c.TableName = tableName
+	WithTableName(tableName)(c)
+	return c
+}
+
+// WithTableNameTemplate sets the TableName to a template, e.g. "{appName}-{env}-leases", expanded with the
+// ApplicationName, StreamName and RegionName and the variables. It panics if a placeholder has no value.
+func (c *KinesisClientLibConfiguration) WithTableNameTemplate(template string, variables map[string]string) *KinesisClientLibConfiguration {
+	checkIsValueNotEmpty("TableNameTemplate", template)
+	WithTableNameTemplate(template, variables)(c)
+	if err := c.resolveTableName(); err != nil {
+		log.Panic(err)
+	}
+	return c
+}
+
+// WithLeaseKeyPrefix prefixes the lease keys in the DynamoDB lease table, so that applications with different
+// prefixes share one lease table
+func (c *KinesisClientLibConfiguration) WithLeaseKeyPrefix(prefix string) *KinesisClientLibConfiguration {
+	WithLeaseKeyPrefix(prefix)(c)
+	return c
+}
+
+// WithSharedLeaseTable stores the leases in a DynamoDB lease table shared with other applications, the lease keys
+// are prefixed by the ApplicationName. It panics if the ApplicationName contains the # separator of the prefix.
+func (c *KinesisClientLibConfiguration) WithSharedLeaseTable(tableName string) *KinesisClientLibConfiguration {
+	checkIsValueNotEmpty("TableName", tableName)
+	if strings.Contains(c.ApplicationName, sharedLeaseKeySeparator) {
+		log.Panicf("ApplicationName %q must not contain %q in a shared lease table", c.ApplicationName, sharedLeaseKeySeparator)
+	}
+	WithSharedLeaseTable(tableName)(c)
+	return c
+}
+
+// WithLeaseTableBillingMode sets the billing mode of the lease table created in DynamoDB, PROVISIONED or PAY_PER_REQUEST.
+func (c *KinesisClientLibConfiguration) WithLeaseTableBillingMode(billingMode types.BillingMode) *KinesisClientLibConfiguration {
+	checkIsValueNotEmpty("LeaseTableBillingMode", string(billingMode))
+	c.LeaseTableBillingMode = billingMode
+	return c
+}
+
+// WithLeaseTableTags sets the tags of the lease table created in DynamoDB.
+func (c *KinesisClientLibConfiguration) WithLeaseTableTags(tags map[string]string) *KinesisClientLibConfiguration {
+	c.LeaseTableTags = tags
+	return c
+}
+
+// WithLeaseTablePointInTimeRecovery enables point-in-time recovery of the lease table in DynamoDB.
+func (c *KinesisClientLibConfiguration) WithLeaseTablePointInTimeRecovery(enable bool) *KinesisClientLibConfiguration {
+	c.LeaseTablePointInTimeRecovery = enable
+	return c
+}
+
+// WithLeaseTableServerSideEncryption encrypts the lease table created in DynamoDB with the given KMS key. The AWS
+// managed key for DynamoDB is used if kmsKeyID is empty.
+func (c *KinesisClientLibConfiguration) WithLeaseTableServerSideEncryption(kmsKeyID string) *KinesisClientLibConfiguration {
+	c.LeaseTableSSEEnabled = true
+	c.LeaseTableSSEKMSKeyID = kmsKeyID
 	return c
 }
 
 func (c *KinesisClientLibConfiguration) WithInitialPositionInStream(initialPositionInStream InitialPositionInStream) *KinesisClientLibConfiguration {
-	c.InitialPositionInStream = initialPositionInStream
-	c.InitialPositionInStreamExtended = *newInitialPosition(initialPositionInStream)
+	if initialPositionInStream == AT_TIMESTAMP || initialPositionInStream == AT_SEQUENCE_NUMBER {
+		// There is no point to continue for incorrect configuration. Fail fast!
+		log.Panicf("Initial position %v requires a value, use WithTimestampAtInitialPositionInStream or WithSequenceNumberAtInitialPositionInStream",
+			aws.ToString(InitalPositionInStreamToShardIteratorType(initialPositionInStream)))
+	}
+	WithInitialPositionInStream(initialPositionInStream)(c)
 	return c
 }
 
 func (c *KinesisClientLibConfiguration) WithTimestampAtInitialPositionInStream(timestamp *time.Time) *KinesisClientLibConfiguration {
-	c.InitialPositionInStream = AT_TIMESTAMP
-	c.InitialPositionInStreamExtended = *newInitialPositionAtTimestamp(timestamp)
+	if timestamp == nil {
+		log.Panicf("Non-nil value expected for timestamp")
+	}
+	WithTimestampAtInitialPositionInStream(*timestamp)(c)
+	return c
+}
+
+// WithSequenceNumberAtInitialPositionInStream starts the shards without checkpoint at the record with the given
+// sequence number (shard id to sequence number) to replay a specific position. The other shards start from the
+// oldest available data record.
+func (c *KinesisClientLibConfiguration) WithSequenceNumberAtInitialPositionInStream(sequenceNumbers map[string]string) *KinesisClientLibConfiguration {
+	for shardID, sequenceNumber := range sequenceNumbers {
+		checkIsValueNotEmpty("shardID", shardID)
+		checkIsValueNotEmpty("sequenceNumber", sequenceNumber)
+	}
+	WithSequenceNumberAtInitialPositionInStream(sequenceNumbers)(c)
 	return c
 }
 
 func (c *KinesisClientLibConfiguration) WithFailoverTimeMillis(failoverTimeMillis int) *KinesisClientLibConfiguration {
 	checkIsValuePositive("FailoverTimeMillis", failoverTimeMillis)
-	c.FailoverTimeMillis = failoverTimeMillis
+	WithFailoverTimeMillis(failoverTimeMillis)(c)
 	return c
 }
 
 func (c *KinesisClientLibConfiguration) WithLeaseRefreshPeriodMillis(leaseRefreshPeriodMillis int) *KinesisClientLibConfiguration {
 	checkIsValuePositive("LeaseRefreshPeriodMillis", leaseRefreshPeriodMillis)
-	c.LeaseRefreshPeriodMillis = leaseRefreshPeriodMillis
+	WithLeaseRefreshPeriodMillis(leaseRefreshPeriodMillis)(c)
 	return c
 }
 
 func (c *KinesisClientLibConfiguration) WithShardSyncIntervalMillis(shardSyncIntervalMillis int) *KinesisClientLibConfiguration {
 	checkIsValuePositive("ShardSyncIntervalMillis", shardSyncIntervalMillis)
-	c.ShardSyncIntervalMillis = shardSyncIntervalMillis
+	WithShardSyncIntervalMillis(shardSyncIntervalMillis)(c)
+	return c
+}
+
+// WithShardEndSync syncs the shards every intervalMillis for durationMillis after the end of a shard, e.g. for streams
+// in ON_DEMAND capacity mode whose shard count changes rapidly.
+func (c *KinesisClientLibConfiguration) WithShardEndSync(intervalMillis, durationMillis int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("ShardEndSyncIntervalMillis", intervalMillis)
+	checkIsValuePositive("ShardEndSyncDurationMillis", durationMillis)
+	WithShardEndSync(intervalMillis, durationMillis)(c)
+	return c
+}
+
+// WithFailover sets the replica of the stream in another region the application fails over to once the shards of
+// the primary stream cannot be listed for failoverAfterMillis, it fails back once they can be listed as long again
+func (c *KinesisClientLibConfiguration) WithFailover(secondaryRegionName, secondaryStreamName string, failoverAfterMillis int) *KinesisClientLibConfiguration {
+	checkIsValueNotEmpty("SecondaryRegionName", secondaryRegionName)
+	checkIsValueNotEmpty("SecondaryStreamName", secondaryStreamName)
+	checkIsValuePositive("RegionFailoverAfterMillis", failoverAfterMillis)
+	WithFailover(secondaryRegionName, secondaryStreamName, failoverAfterMillis)(c)
+	return c
+}
+
+// WithSequenceNumberTranslator sets the translation of the checkpoints of the consumed stream into checkpoints of the
+// other stream at failover and failback
+func (c *KinesisClientLibConfiguration) WithSequenceNumberTranslator(translator SequenceNumberTranslator) *KinesisClientLibConfiguration {
+	if translator == nil {
+		log.Panic("SequenceNumberTranslator should not be nil")
+	}
+	WithSequenceNumberTranslator(translator)(c)
 	return c
 }
 
 func (c *KinesisClientLibConfiguration) WithMaxRecords(maxRecords int) *KinesisClientLibConfiguration {
 	checkIsValuePositive("MaxRecords", maxRecords)
-	c.MaxRecords = maxRecords
+	WithMaxRecords(maxRecords)(c)
 	return c
 }
 
@@ -165,7 +395,23 @@ func (c *KinesisClientLibConfiguration) WithMaxRecords(maxRecords int) *KinesisC
 // this worker can handle.
 func (c *KinesisClientLibConfiguration) WithMaxLeasesForWorker(n int) *KinesisClientLibConfiguration {
 	checkIsValuePositive("MaxLeasesForWorker", n)
-	c.MaxLeasesForWorker = n
+	WithMaxLeasesForWorker(n)(c)
+	return c
+}
+
+// WithMaxLeasesToStealAtOneTime configures the maximum number of leases stolen from another worker at once when
+// lease stealing is enabled. A higher number converges faster but causes more churn.
+func (c *KinesisClientLibConfiguration) WithMaxLeasesToStealAtOneTime(n int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("MaxLeasesToStealAtOneTime", n)
+	WithMaxLeasesToStealAtOneTime(n)(c)
+	return c
+}
+
+// WithMaxLeasesToAcquireAtOneTime configures the maximum number of available leases this worker acquires at every
+// shard sync.
+func (c *KinesisClientLibConfiguration) WithMaxLeasesToAcquireAtOneTime(n int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("MaxLeasesToAcquireAtOneTime", n)
+	WithMaxLeasesToAcquireAtOneTime(n)(c)
 	return c
 }
 
@@ -189,7 +435,17 @@ func (c *KinesisClientLibConfiguration) WithMaxLeasesForWorker(n int) *KinesisCl
 // @return KinesisClientLibConfiguration
 func (c *KinesisClientLibConfiguration) WithIdleTimeBetweenReadsInMillis(idleTimeBetweenReadsInMillis int) *KinesisClientLibConfiguration {
 	checkIsValuePositive("IdleTimeBetweenReadsInMillis", idleTimeBetweenReadsInMillis)
-	c.IdleTimeBetweenReadsInMillis = idleTimeBetweenReadsInMillis
+	WithIdleTimeBetweenReadsInMillis(idleTimeBetweenReadsInMillis)(c)
+	return c
+}
+
+// WithPollingStrategy sets the strategy deciding the delay between GetRecords calls, e.g. FixedPollingStrategy
+// to poll only after IdleTimeBetweenReadsInMillis once caught up.
+func (c *KinesisClientLibConfiguration) WithPollingStrategy(strategy PollingStrategy) *KinesisClientLibConfiguration {
+	if strategy == nil {
+		log.Panic("PollingStrategy should not be nil")
+	}
+	c.PollingStrategy = strategy
 	return c
 }
 
@@ -208,7 +464,7 @@ func (c *KinesisClientLibConfiguration) WithLogger(logger logger.Logger) *Kinesi
 	if logger == nil {
 		log.Panic("Logger cannot be null")
 	}
-	c.Logger = logger
+	WithLogger(logger)(c)
 	return c
 }
 
@@ -223,7 +479,38 @@ func (c *KinesisClientLibConfiguration) WithMaxRetryCount(maxRetryCount int) *Ki
 func (c *KinesisClientLibConfiguration) WithMonitoringService(mService metrics.MonitoringService) *KinesisClientLibConfiguration {
 	// Nil case is handled downward (at worker creation) so no need to do it here.
 	// Plus the user might want to be explicit about passing a nil monitoring service here.
-	c.MonitoringService = mService
+	WithMonitoringService(mService)(c)
+	return c
+}
+
+// WithMetricsNamespace sets the namespace of the metrics instead of the application name.
+func (c *KinesisClientLibConfiguration) WithMetricsNamespace(namespace string) *KinesisClientLibConfiguration {
+	checkIsValueNotEmpty("MetricsNamespace", namespace)
+	WithMetricsNamespace(namespace)(c)
+	return c
+}
+
+// WithDisabledMetrics disables the metrics, e.g. metrics.CheckpointTimeMetric.
+func (c *KinesisClientLibConfiguration) WithDisabledMetrics(disabled ...metrics.Metric) *KinesisClientLibConfiguration {
+	WithDisabledMetrics(disabled...)(c)
+	return c
+}
+
+// WithShardMetricsSampleRatio sets the fraction of the shards whose per-shard metrics of the record processing
+// are reported, e.g. 0.1 for streams with thousands of shards.
+func (c *KinesisClientLibConfiguration) WithShardMetricsSampleRatio(ratio float64) *KinesisClientLibConfiguration {
+	if ratio <= 0 || ratio > 1 {
+		log.Panicf("Value between 0 and 1 expected for ShardMetricsSampleRatio, actual: %v", ratio)
+	}
+	WithShardMetricsSampleRatio(ratio)(c)
+	return c
+}
+
+// WithTracerProvider sets the OpenTelemetry tracer provider to use to trace GetRecords, ProcessRecords and Checkpoint
+// calls. The spans of ProcessRecords are propagated to the record processor through ProcessRecordsInput.Context.
+func (c *KinesisClientLibConfiguration) WithTracerProvider(tracerProvider trace.TracerProvider) *KinesisClientLibConfiguration {
+	// Nil case is handled downward (at worker creation) so no need to do it here.
+	WithTracerProvider(tracerProvider)(c)
 	return c
 }
 
@@ -240,8 +527,7 @@ func (c *KinesisClientLibConfiguration) WithEnhancedFanOutConsumer(enable bool)
 // Note: You can register up to twenty consumers per stream to use enhanced fan-out.
 func (c *KinesisClientLibConfiguration) WithEnhancedFanOutConsumerName(consumerName string) *KinesisClientLibConfiguration {
 	checkIsValueNotEmpty("EnhancedFanOutConsumerName", consumerName)
-	c.EnhancedFanOutConsumerName = consumerName
-	c.EnableEnhancedFanOutConsumer = true
+	WithEnhancedFanOutConsumerName(consumerName)(c)
 	return c
 }
 
@@ -250,13 +536,371 @@ func (c *KinesisClientLibConfiguration) WithEnhancedFanOutConsumerName(consumerN
 // Note: You can register up to twenty consumers per stream to use enhanced fan-out.
 func (c *KinesisClientLibConfiguration) WithEnhancedFanOutConsumerARN(consumerARN string) *KinesisClientLibConfiguration {
 	checkIsValueNotEmpty("EnhancedFanOutConsumerARN", consumerARN)
-	c.EnhancedFanOutConsumerARN = consumerARN
-	c.EnableEnhancedFanOutConsumer = true
+	WithEnhancedFanOutConsumerARN(consumerARN)(c)
+	return c
+}
+
+// WithStreamARN sets the ARN of the stream to consume, e.g. the ARN of a stream in another AWS account.
+func (c *KinesisClientLibConfiguration) WithStreamARN(streamARN string) *KinesisClientLibConfiguration {
+	checkIsValueNotEmpty("StreamARN", streamARN)
+	WithStreamARN(streamARN)(c)
+	return c
+}
+
+// WithProcessorPanicPolicy sets how a shard consumer recovers from a panic of its record processor.
+func (c *KinesisClientLibConfiguration) WithProcessorPanicPolicy(policy ProcessorPanicPolicy) *KinesisClientLibConfiguration {
+	if policy < ReleaseLeaseOnPanic || policy > CrashOnPanic {
+		log.Panicf("Unsupported processor panic policy %d", policy)
+	}
+	c.ProcessorPanicPolicy = policy
+	return c
+}
+
+// WithProcessorRestartBackoffMillis sets the initial backoff before restarting a panicked record processor.
+func (c *KinesisClientLibConfiguration) WithProcessorRestartBackoffMillis(backoffMillis int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("ProcessorRestartBackoffMillis", backoffMillis)
+	c.ProcessorRestartBackoffMillis = backoffMillis
+	return c
+}
+
+// WithProcessorPanicHandler sets the callback which is called when a record processor panics.
+func (c *KinesisClientLibConfiguration) WithProcessorPanicHandler(handler ProcessorPanicHandler) *KinesisClientLibConfiguration {
+	c.ProcessorPanicHandler = handler
+	return c
+}
+
+// WithAllowCheckpointRewind allows record processors to checkpoint before the current checkpoint of the shard.
+func (c *KinesisClientLibConfiguration) WithAllowCheckpointRewind(allowRewind bool) *KinesisClientLibConfiguration {
+	c.AllowCheckpointRewind = allowRewind
+	return c
+}
+
+// WithCheckpointValidator sets the hook which validates the checkpoints requested by record processors.
+func (c *KinesisClientLibConfiguration) WithCheckpointValidator(validator CheckpointValidator) *KinesisClientLibConfiguration {
+	if validator == nil {
+		log.Panic("CheckpointValidator should not be nil")
+	}
+	c.CheckpointValidator = validator
+	return c
+}
+
+// WithShardFilter scopes the worker to the shards matched by the filter.
+func (c *KinesisClientLibConfiguration) WithShardFilter(filter ShardFilter) *KinesisClientLibConfiguration {
+	if filter == nil {
+		log.Panic("ShardFilter should not be nil")
+	}
+	WithShardFilter(filter)(c)
+	return c
+}
+
+// WithPinnedShards pins the worker to the shards with the IDs. With leaseCoordination the leases of the shards are
+// taken in the lease table, otherwise the leases and checkpoints are kept in memory by the default checkpointer.
+func (c *KinesisClientLibConfiguration) WithPinnedShards(leaseCoordination bool, shardIDs ...string) *KinesisClientLibConfiguration {
+	if len(shardIDs) == 0 {
+		log.Panic("PinnedShardIDs should not be empty")
+	}
+	for _, id := range shardIDs {
+		checkIsValueNotEmpty("PinnedShardIDs", id)
+	}
+	WithPinnedShards(leaseCoordination, shardIDs...)(c)
+	return c
+}
+
+// WithShardAssignment pre-assigns the shards to worker IDs, e.g. with StatefulSetAssignment.
+func (c *KinesisClientLibConfiguration) WithShardAssignment(assignment ShardAssignment) *KinesisClientLibConfiguration {
+	if assignment == nil {
+		log.Panic("ShardAssignment should not be nil")
+	}
+	WithShardAssignment(assignment)(c)
+	return c
+}
+
+// WithShardAssignmentFallbackMillis sets the time after which the shards assigned to other workers are leased by the
+// worker when their leases are not held.
+func (c *KinesisClientLibConfiguration) WithShardAssignmentFallbackMillis(millis int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("ShardAssignmentFallbackMillis", millis)
+	WithShardAssignmentFallbackMillis(millis)(c)
+	return c
+}
+
+// WithListShardsFilter sets the filter passed to ListShards to select the new shards to consume.
+func (c *KinesisClientLibConfiguration) WithListShardsFilter(filter ktypes.ShardFilter) *KinesisClientLibConfiguration {
+	checkIsValueNotEmpty("ListShardsFilter.Type", string(filter.Type))
+	WithListShardsFilter(filter)(c)
+	return c
+}
+
+// WithLagThreshold sets the hook which is called when the MillisBehindLatest of a shard exceeds the threshold.
+func (c *KinesisClientLibConfiguration) WithLagThreshold(thresholdMillis int64, handler LagHandler) *KinesisClientLibConfiguration {
+	checkIsValuePositive("LagThresholdMillis", int(thresholdMillis))
+	if handler == nil {
+		log.Panic("LagHandler should not be nil")
+	}
+	WithLagThreshold(thresholdMillis, handler)(c)
+	return c
+}
+
+// WithCheckpointAgeAlarm raises a CheckpointAgeAlarm when the checkpoint of a shard behind its tip has not advanced
+// for the threshold.
+func (c *KinesisClientLibConfiguration) WithCheckpointAgeAlarm(thresholdMillis int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("CheckpointAgeAlarmMillis", thresholdMillis)
+	WithCheckpointAgeAlarm(thresholdMillis)(c)
+	return c
+}
+
+// WithMillisBehindLatestAlarm raises a MillisBehindLatestAlarm when the MillisBehindLatest of a shard exceeds the
+// threshold.
+func (c *KinesisClientLibConfiguration) WithMillisBehindLatestAlarm(thresholdMillis int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("MillisBehindLatestAlarmMillis", thresholdMillis)
+	WithMillisBehindLatestAlarm(thresholdMillis)(c)
+	return c
+}
+
+// WithAlarmEvaluationIntervalMillis sets the interval the alarms of the shards are evaluated at.
+func (c *KinesisClientLibConfiguration) WithAlarmEvaluationIntervalMillis(millis int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("AlarmEvaluationIntervalMillis", millis)
+	WithAlarmEvaluationIntervalMillis(millis)(c)
+	return c
+}
+
+// WithAlarmHandler sets the hook which is called when an alarm of a shard is raised or cleared.
+func (c *KinesisClientLibConfiguration) WithAlarmHandler(handler AlarmHandler) *KinesisClientLibConfiguration {
+	if handler == nil {
+		log.Panic("AlarmHandler should not be nil")
+	}
+	WithAlarmHandler(handler)(c)
+	return c
+}
+
+// WithHotShardsReport logs the topN shards with the highest throughput over every interval.
+func (c *KinesisClientLibConfiguration) WithHotShardsReport(intervalMillis, topN int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("HotShardsReportIntervalMillis", intervalMillis)
+	checkIsValuePositive("HotShardsTopN", topN)
+	WithHotShardsReport(intervalMillis, topN)(c)
+	return c
+}
+
+// WithHotShardsHandler sets the hook which receives the hottest shards whenever they are reported.
+func (c *KinesisClientLibConfiguration) WithHotShardsHandler(handler HotShardsHandler) *KinesisClientLibConfiguration {
+	if handler == nil {
+		log.Panic("HotShardsHandler should not be nil")
+	}
+	WithHotShardsHandler(handler)(c)
+	return c
+}
+
+// WithProcessRecordsErrorPolicy sets how a shard consumer continues after its record processor failed a batch.
+func (c *KinesisClientLibConfiguration) WithProcessRecordsErrorPolicy(policy ProcessRecordsErrorPolicy) *KinesisClientLibConfiguration {
+	if policy < HaltOnError || policy > RetryOnError {
+		log.Panicf("Unsupported process records error policy %d", policy)
+	}
+	WithProcessRecordsErrorPolicy(policy)(c)
+	return c
+}
+
+// WithMaxProcessRecordsRetries sets how often a batch failed by the record processor is retried.
+func (c *KinesisClientLibConfiguration) WithMaxProcessRecordsRetries(retries int) *KinesisClientLibConfiguration {
+	if retries < 0 {
+		log.Panicf("MaxProcessRecordsRetries should not be negative, got %d", retries)
+	}
+	c.MaxProcessRecordsRetries = retries
+	return c
+}
+
+// WithMaxDeliveryAttempts sets the number of deliveries of a batch after which the handler is called for each failed
+// delivery, e.g. to skip poison records.
+func (c *KinesisClientLibConfiguration) WithMaxDeliveryAttempts(attempts int, handler ProcessingFailedHandler) *KinesisClientLibConfiguration {
+	checkIsValuePositive("MaxDeliveryAttempts", attempts)
+	if handler == nil {
+		log.Panic("ProcessingFailedHandler should not be nil")
+	}
+	WithMaxDeliveryAttempts(attempts, handler)(c)
+	return c
+}
+
+// WithUnorderedProcessing fans the records of each batch out to the number of goroutines, the records of a shard are
+// then no longer processed in order.
+func (c *KinesisClientLibConfiguration) WithUnorderedProcessing(concurrency int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("UnorderedProcessingConcurrency", concurrency)
+	WithUnorderedProcessing(concurrency)(c)
+	return c
+}
+
+// WithDebugServer enables the debug server of the worker at the address, the requests are authorized by the optional
+// authorizer.
+func (c *KinesisClientLibConfiguration) WithDebugServer(address string, authorizer DebugAuthorizer) *KinesisClientLibConfiguration {
+	if address == "" {
+		log.Panic("DebugServerAddress should not be empty")
+	}
+	WithDebugServer(address, authorizer)(c)
+	return c
+}
+
+// WithRetryPolicy sets how the calls to Kinesis and DynamoDB are retried.
+func (c *KinesisClientLibConfiguration) WithRetryPolicy(policy RetryPolicy) *KinesisClientLibConfiguration {
+	checkIsValuePositive("RetryPolicy.MaxAttempts", policy.MaxAttempts)
+	checkIsValuePositive("RetryPolicy.BaseDelayMillis", policy.BaseDelayMillis)
+	if policy.MaxDelayMillis < policy.BaseDelayMillis {
+		log.Panicf("RetryPolicy.MaxDelayMillis %d should not be less than BaseDelayMillis %d", policy.MaxDelayMillis, policy.BaseDelayMillis)
+	}
+	c.RetryPolicy = policy
+	return c
+}
+
+// WithDeadLetterPublisher sets the dead-letter queue of the records which cannot be processed.
+func (c *KinesisClientLibConfiguration) WithDeadLetterPublisher(publisher deadletter.Publisher) *KinesisClientLibConfiguration {
+	if publisher == nil {
+		log.Panic("DeadLetterPublisher should not be nil")
+	}
+	c.DeadLetterPublisher = publisher
+	return c
+}
+
+// WithLeaseAuditSink sets the sink recording the lease transitions of the worker, e.g.
+// leaseaudit.NewLogSink(logger) or dynamodb.NewSink(client, "leases-audit").
+func (c *KinesisClientLibConfiguration) WithLeaseAuditSink(sink leaseaudit.Sink) *KinesisClientLibConfiguration {
+	if sink == nil {
+		log.Panic("LeaseAuditSink should not be nil")
+	}
+	WithLeaseAuditSink(sink)(c)
+	return c
+}
+
+// WithLeaseCoordinator sets the coordinator assigning the shards to the workers, e.g.
+// etcd.NewCoordinator("http://localhost:2379", "/kcl/orders/", 30*time.Second).
+func (c *KinesisClientLibConfiguration) WithLeaseCoordinator(coordinator leasecoordinator.LeaseCoordinator) *KinesisClientLibConfiguration {
+	if coordinator == nil {
+		log.Panic("LeaseCoordinator should not be nil")
+	}
+	WithLeaseCoordinator(coordinator)(c)
+	return c
+}
+
+// WithRecordTransformer sets the transformer of the user records delivered to the record processors, e.g.
+// transformer.Chain(kms.NewDecrypter(client), transformer.Gzip()).
+func (c *KinesisClientLibConfiguration) WithRecordTransformer(t transformer.RecordTransformer) *KinesisClientLibConfiguration {
+	if t == nil {
+		log.Panic("RecordTransformer should not be nil")
+	}
+	WithRecordTransformer(t)(c)
+	return c
+}
+
+// WithRecordDecoder sets the decoder of the user records delivered to the record processors, e.g.
+// glue.NewDecoder(registry) for the records serialized with the Glue Schema Registry.
+func (c *KinesisClientLibConfiguration) WithRecordDecoder(d decoder.Decoder) *KinesisClientLibConfiguration {
+	if d == nil {
+		log.Panic("RecordDecoder should not be nil")
+	}
+	WithRecordDecoder(d)(c)
+	return c
+}
+
+// WithStreams enables the multi-stream mode consuming the given stream names or ARNs.
+func (c *KinesisClientLibConfiguration) WithStreams(streams ...string) *KinesisClientLibConfiguration {
+	if len(streams) == 0 {
+		log.Panic("Streams should not be empty")
+	}
+	for _, stream := range streams {
+		checkIsValueNotEmpty("Streams", stream)
+	}
+	WithStreams(streams...)(c)
+	return c
+}
+
+// WithStreamProvider enables the multi-stream mode consuming the streams returned by the provider on every shard sync.
+func (c *KinesisClientLibConfiguration) WithStreamProvider(provider StreamProvider) *KinesisClientLibConfiguration {
+	if provider == nil {
+		log.Panic("StreamProvider should not be nil")
+	}
+	WithStreamProvider(provider)(c)
+	return c
+}
+
+// IsMultiStreamMode returns true if the worker consumes multiple streams.
+func (c *KinesisClientLibConfiguration) IsMultiStreamMode() bool {
+	return len(c.Streams) > 0 || c.StreamProvider != nil
+}
+
+// WithWorkerIDProvider sets the WorkerID to the one returned by the provider, e.g. HostnameWorkerID or EnvWorkerID.
+// It panics if the provider fails.
+func (c *KinesisClientLibConfiguration) WithWorkerIDProvider(provider WorkerIDProvider) *KinesisClientLibConfiguration {
+	if provider == nil {
+		log.Panic("WorkerIDProvider should not be nil")
+	}
+	WithWorkerIDProvider(provider)(c)
+	if err := c.resolveWorkerID(); err != nil {
+		log.Panic(err)
+	}
+	checkIsValueNotEmpty("WorkerID", c.WorkerID)
+	return c
+}
+
+// WithReclaimLeasesOnStartup acquires all leases still held by the worker ID when the worker starts.
+func (c *KinesisClientLibConfiguration) WithReclaimLeasesOnStartup(reclaim bool) *KinesisClientLibConfiguration {
+	WithReclaimLeasesOnStartup(reclaim)(c)
 	return c
 }
 
 func (c *KinesisClientLibConfiguration) WithLeaseStealing(enableLeaseStealing bool) *KinesisClientLibConfiguration {
-	c.EnableLeaseStealing = enableLeaseStealing
+	WithLeaseStealing(enableLeaseStealing)(c)
+	return c
+}
+
+// WithAsyncCheckpointIntervalMillis sets how often the checkpoints requested by CheckpointAsync are stored.
+func (c *KinesisClientLibConfiguration) WithAsyncCheckpointIntervalMillis(asyncCheckpointIntervalMillis int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("AsyncCheckpointIntervalMillis", asyncCheckpointIntervalMillis)
+	c.AsyncCheckpointIntervalMillis = asyncCheckpointIntervalMillis
+	return c
+}
+
+// WithAutoCheckpoint checkpoints the last record of the batches processed successfully by the record processors, at
+// most every intervalMillis unless recordCount records have been processed since the last checkpoint. Every batch
+// is checkpointed if both are 0.
+func (c *KinesisClientLibConfiguration) WithAutoCheckpoint(intervalMillis, recordCount int) *KinesisClientLibConfiguration {
+	if intervalMillis < 0 || recordCount < 0 {
+		log.Panicf("AutoCheckpoint thresholds must not be negative: %d ms, %d records", intervalMillis, recordCount)
+	}
+	WithAutoCheckpoint(intervalMillis, recordCount)(c)
+	return c
+}
+
+// WithMaxRecordsPerSecond limits the records processed per second by the worker. The shard consumers back off from
+// fetching records while the limit is exceeded.
+func (c *KinesisClientLibConfiguration) WithMaxRecordsPerSecond(maxRecordsPerSecond int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("MaxRecordsPerSecond", maxRecordsPerSecond)
+	c.MaxRecordsPerSecond = maxRecordsPerSecond
+	return c
+}
+
+// WithMaxInFlightBytes limits the bytes being processed by the worker. The shard consumers back off from fetching
+// records while the limit is exceeded.
+func (c *KinesisClientLibConfiguration) WithMaxInFlightBytes(maxInFlightBytes int64) *KinesisClientLibConfiguration {
+	checkIsValuePositive("MaxInFlightBytes", int(maxInFlightBytes))
+	c.MaxInFlightBytes = maxInFlightBytes
+	return c
+}
+
+// WithMaxConcurrentShardConsumers bounds the shard consumers fetching and processing records at the same time, e.g.
+// for workers in small containers leasing hundreds of shards.
+func (c *KinesisClientLibConfiguration) WithMaxConcurrentShardConsumers(maxConcurrentShardConsumers int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("MaxConcurrentShardConsumers", maxConcurrentShardConsumers)
+	WithMaxConcurrentShardConsumers(maxConcurrentShardConsumers)(c)
+	return c
+}
+
+// WithMaxGetRecordsCallsPerSecond limits the GetRecords calls per second of the worker, which are shared fairly by
+// the shards it consumes.
+func (c *KinesisClientLibConfiguration) WithMaxGetRecordsCallsPerSecond(maxGetRecordsCallsPerSecond int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("MaxGetRecordsCallsPerSecond", maxGetRecordsCallsPerSecond)
+	WithMaxGetRecordsCallsPerSecond(maxGetRecordsCallsPerSecond)(c)
+	return c
+}
+
+// WithKPLDeaggregation sets EnableKPLDeaggregation. The user records of a KPL aggregated record share the sequence
+// number of the aggregated record and are told apart by their sub-sequence number.
+func (c *KinesisClientLibConfiguration) WithKPLDeaggregation(enable bool) *KinesisClientLibConfiguration {
+	c.EnableKPLDeaggregation = enable
 	return c
 }
 
@@ -265,7 +909,109 @@ func (c *KinesisClientLibConfiguration) WithLeaseStealingIntervalMillis(leaseSte
 	return c
 }
 
+// WithLeaseStealingHandoffTimeoutMillis sets the time the owner of a claimed shard has to checkpoint and release
+// the lease before the claiming worker takes it over.
+func (c *KinesisClientLibConfiguration) WithLeaseStealingHandoffTimeoutMillis(leaseStealingHandoffTimeoutMillis int) *KinesisClientLibConfiguration {
+	if leaseStealingHandoffTimeoutMillis < 0 {
+		log.Panicf("LeaseStealingHandoffTimeoutMillis must not be negative: %d", leaseStealingHandoffTimeoutMillis)
+	}
+	WithLeaseStealingHandoffTimeout(leaseStealingHandoffTimeoutMillis)(c)
+	return c
+}
+
+// WithCleanupLeasesUponShardCompletion enables the deletion of the leases of completed shards.
+func (c *KinesisClientLibConfiguration) WithCleanupLeasesUponShardCompletion(cleanup bool) *KinesisClientLibConfiguration {
+	c.CleanupLeasesUponShardCompletion = cleanup
+	return c
+}
+
+// WithLeaseCleanupIntervalMillis sets the interval between lease cleanup tasks.
+func (c *KinesisClientLibConfiguration) WithLeaseCleanupIntervalMillis(leaseCleanupIntervalMillis int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("LeaseCleanupIntervalMillis", leaseCleanupIntervalMillis)
+	c.LeaseCleanupIntervalMillis = leaseCleanupIntervalMillis
+	return c
+}
+
 func (c *KinesisClientLibConfiguration) WithLeaseSyncingIntervalMillis(leaseSyncingIntervalMillis int) *KinesisClientLibConfiguration {
 	c.LeaseSyncingTimeIntervalMillis = leaseSyncingIntervalMillis
 	return c
 }
+
+// WithPrefetch makes the polling shard consumers read ahead up to the given number of records and bytes per shard
+// while the record processor works on the current batch. The records are still delivered in order; a maxBytes of 0
+// does not limit the bytes read ahead.
+func (c *KinesisClientLibConfiguration) WithPrefetch(maxRecords, maxBytes int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("PrefetchMaxRecords", maxRecords)
+	if maxBytes < 0 {
+		log.Panicf("PrefetchMaxBytes must not be negative: %d", maxBytes)
+	}
+	WithPrefetch(maxRecords, maxBytes)(c)
+	return c
+}
+
+// WithBatching makes the shard consumers collect the records read from a shard until there are at least
+// minBatchSize of them or the first of them has waited for maxBatchWaitTimeMillis, so that sparse streams deliver
+// fewer, larger batches to ProcessRecords.
+func (c *KinesisClientLibConfiguration) WithBatching(minBatchSize, maxBatchWaitTimeMillis int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("MinBatchSize", minBatchSize)
+	checkIsValuePositive("MaxBatchWaitTimeMillis", maxBatchWaitTimeMillis)
+	WithBatching(minBatchSize, maxBatchWaitTimeMillis)(c)
+	return c
+}
+
+// WithLeaseTableScanSegments sets the number of segments of the lease table which are scanned in parallel.
+func (c *KinesisClientLibConfiguration) WithLeaseTableScanSegments(segments int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("LeaseTableScanSegments", segments)
+	c.LeaseTableScanSegments = segments
+	return c
+}
+
+// WithLeaseTableReadConsistency sets the consistency of the scans syncing the leases and of the reads of the lease of
+// a shard from the DynamoDB lease table.
+func (c *KinesisClientLibConfiguration) WithLeaseTableReadConsistency(scans, gets ReadConsistency) *KinesisClientLibConfiguration {
+	if scans < EventuallyConsistent || scans > StronglyConsistent || gets < EventuallyConsistent || gets > StronglyConsistent {
+		log.Panicf("Unsupported read consistency %d, %d", scans, gets)
+	}
+	WithLeaseTableReadConsistency(scans, gets)(c)
+	return c
+}
+
+// WithLeaseTableCapacityHandler calls the handler with the capacity consumed by each call to the DynamoDB lease table.
+func (c *KinesisClientLibConfiguration) WithLeaseTableCapacityHandler(handler LeaseTableCapacityHandler) *KinesisClientLibConfiguration {
+	if handler == nil {
+		log.Panic("LeaseTableCapacityHandler should not be nil")
+	}
+	WithLeaseTableCapacityHandler(handler)(c)
+	return c
+}
+
+// WithLeaseAttributeCompressionThreshold compresses the pending checkpoints and the checkpoint metadata larger than
+// threshold bytes in the DynamoDB lease table.
+func (c *KinesisClientLibConfiguration) WithLeaseAttributeCompressionThreshold(threshold int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("LeaseAttributeCompressionThreshold", threshold)
+	WithLeaseAttributeCompressionThreshold(threshold)(c)
+	return c
+}
+
+// WithRedisCheckpointer keeps leases and checkpoints in the given Redis server instead of DynamoDB
+func (c *KinesisClientLibConfiguration) WithRedisCheckpointer(address, password string, db int) *KinesisClientLibConfiguration {
+	checkIsValueNotEmpty("RedisAddress", address)
+	WithRedisCheckpointer(address, password, db)(c)
+	return c
+}
+
+// WithSQLCheckpointer keeps leases and checkpoints in a PostgreSQL or MySQL database instead of DynamoDB.
+// The database/sql driver has to be registered by the application.
+func (c *KinesisClientLibConfiguration) WithSQLCheckpointer(driverName, dataSourceName string) *KinesisClientLibConfiguration {
+	checkIsValueNotEmpty("SQLDriverName", driverName)
+	checkIsValueNotEmpty("SQLDataSourceName", dataSourceName)
+	WithSQLCheckpointer(driverName, dataSourceName)(c)
+	return c
+}
+
+// WithMemoryCheckpointer keeps leases and checkpoints in memory instead of DynamoDB, e.g. for tests and local
+// runs against Kinesalite or LocalStack. The lease table is persisted to the given JSON file unless it is empty.
+func (c *KinesisClientLibConfiguration) WithMemoryCheckpointer(file string) *KinesisClientLibConfiguration {
+	WithMemoryCheckpointer(file)(c)
+	return c
+}
@@ -0,0 +1,638 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package config
+
+import (
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/decoder"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/leaseaudit"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/leasecoordinator"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/metrics"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/transformer"
+	"github.com/vmware/vmware-go-kcl-v2/logger"
+)
+
+// Option changes a setting of the configuration built by New. The WithXxx methods of the configuration apply the
+// option of the same name once they checked its values. Unlike the WithXxx methods, options do not panic on invalid
+// values, the configuration is validated once all options have been applied.
+type Option func(*KinesisClientLibConfiguration)
+
+// New creates a KinesisClientLibConfiguration with default values changed by the options. The region defaults to
+// the AWS_REGION environment variable and the worker ID to a random UUID unless a WorkerIDProvider is set. All
+// invalid settings are returned as ValidationErrors.
+func New(streamName, applicationName string, opts ...Option) (*KinesisClientLibConfiguration, error) {
+	c := newDefaultConfiguration(applicationName, streamName, os.Getenv("AWS_REGION"), "", nil, nil)
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if err := c.resolveWorkerID(); err != nil {
+		return nil, err
+	}
+	if err := c.resolveTableName(); err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// WithSettings changes any setting of the configuration, e.g. by calling its WithXxx methods
+func WithSettings(configure func(*KinesisClientLibConfiguration)) Option {
+	return configure
+}
+
+// WithRegion sets the AWS region of the stream and of the lease table
+func WithRegion(regionName string) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.RegionName = regionName
+	}
+}
+
+// WithWorkerID sets the ID identifying the worker as lease owner
+func WithWorkerID(workerID string) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.WorkerID = workerID
+	}
+}
+
+// WithWorkerIDProvider sets the ID identifying the worker as lease owner to the one returned by the provider,
+// e.g. HostnameWorkerID or EnvWorkerID
+func WithWorkerIDProvider(provider WorkerIDProvider) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.WorkerIDProvider = provider
+	}
+}
+
+// WithReclaimLeasesOnStartup acquires all leases still held by the worker ID when the worker starts
+func WithReclaimLeasesOnStartup(reclaim bool) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.ReclaimLeasesOnStartup = reclaim
+	}
+}
+
+// WithCredentials sets the credentials of the Kinesis and of the DynamoDB clients
+func WithCredentials(kinesisCreds, dynamodbCreds aws.CredentialsProvider) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.KinesisCredentials = kinesisCreds
+		c.DynamoDBCredentials = dynamodbCreds
+	}
+}
+
+// WithAWSConfig sets the aws.Config the Kinesis and DynamoDB clients are created from
+func WithAWSConfig(cfg aws.Config) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.AWSConfig = &cfg
+	}
+}
+
+// WithHTTPClient sets the HTTP client of the Kinesis and DynamoDB clients
+func WithHTTPClient(client aws.HTTPClient) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.HTTPClient = client
+	}
+}
+
+// WithProxyURL sends the calls to Kinesis and DynamoDB through the HTTP proxy
+func WithProxyURL(proxyURL string) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.ProxyURL = proxyURL
+	}
+}
+
+// WithRetryer sets the constructor of the retryer of the Kinesis and DynamoDB clients
+func WithRetryer(newRetryer func() aws.Retryer) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.Retryer = newRetryer
+	}
+}
+
+// WithAPICallTimeout sets the timeout of the calls of an operation of Kinesis or DynamoDB
+func WithAPICallTimeout(operation string, timeout time.Duration) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		if c.APICallTimeouts == nil {
+			c.APICallTimeouts = make(map[string]time.Duration)
+		}
+		c.APICallTimeouts[operation] = timeout
+	}
+}
+
+// WithKinesisEndpoint sets the endpoint of the Kinesis service
+func WithKinesisEndpoint(kinesisEndpoint string) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.KinesisEndpoint = kinesisEndpoint
+	}
+}
+
+// WithDynamoDBEndpoint sets the endpoint of the DynamoDB service
+func WithDynamoDBEndpoint(dynamoDBEndpoint string) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.DynamoDBEndpoint = dynamoDBEndpoint
+	}
+}
+
+// WithStreamARN sets the ARN of the stream to consume, e.g. the ARN of a stream in another AWS account
+func WithStreamARN(streamARN string) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.StreamARN = streamARN
+	}
+}
+
+// WithStreams enables the multi-stream mode consuming the given stream names or ARNs
+func WithStreams(streams ...string) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.Streams = streams
+	}
+}
+
+// WithStreamProvider enables the multi-stream mode consuming the streams returned by the provider
+func WithStreamProvider(provider StreamProvider) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.StreamProvider = provider
+	}
+}
+
+// WithTableName sets the name of the lease table
+func WithTableName(tableName string) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.TableName = tableName
+	}
+}
+
+// WithTableNameTemplate sets the name of the lease table to a template, e.g. "{appName}-{env}-leases". The
+// placeholders {appName}, {streamName} and {region} are replaced by the application, the stream and the region
+// once all options are applied, the others by the variables.
+func WithTableNameTemplate(template string, variables map[string]string) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.TableNameTemplate = template
+		c.TableNameVariables = variables
+	}
+}
+
+// WithLeaseKeyPrefix prefixes the lease keys in the DynamoDB lease table, so that applications with different
+// prefixes share one lease table
+func WithLeaseKeyPrefix(prefix string) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.LeaseKeyPrefix = prefix
+	}
+}
+
+// WithSharedLeaseTable stores the leases in a DynamoDB lease table shared with other applications, the lease keys
+// are prefixed by the application name
+func WithSharedLeaseTable(tableName string) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.TableName = tableName
+		c.LeaseKeyPrefix = c.ApplicationName + sharedLeaseKeySeparator
+	}
+}
+
+// WithInitialPositionInStream sets where shards without checkpoint start, AT_TIMESTAMP and AT_SEQUENCE_NUMBER are
+// set by WithTimestampAtInitialPositionInStream and WithSequenceNumberAtInitialPositionInStream
+func WithInitialPositionInStream(initialPositionInStream InitialPositionInStream) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.InitialPositionInStream = initialPositionInStream
+		c.InitialPositionInStreamExtended = *newInitialPosition(initialPositionInStream)
+	}
+}
+
+// WithTimestampAtInitialPositionInStream starts the shards without checkpoint at the records arrived at the timestamp
+func WithTimestampAtInitialPositionInStream(timestamp time.Time) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.InitialPositionInStream = AT_TIMESTAMP
+		c.InitialPositionInStreamExtended = *newInitialPositionAtTimestamp(&timestamp)
+	}
+}
+
+// WithSequenceNumberAtInitialPositionInStream starts the shards without checkpoint at the given sequence numbers
+// (shard id to sequence number)
+func WithSequenceNumberAtInitialPositionInStream(sequenceNumbers map[string]string) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.InitialPositionInStream = AT_SEQUENCE_NUMBER
+		c.InitialPositionInStreamExtended = *newInitialPositionAtSequenceNumber(sequenceNumbers)
+	}
+}
+
+// WithFailoverTimeMillis sets the lease duration
+func WithFailoverTimeMillis(failoverTimeMillis int) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.FailoverTimeMillis = failoverTimeMillis
+	}
+}
+
+// WithLeaseRefreshPeriodMillis sets the period before the end of the lease at which it is renewed
+func WithLeaseRefreshPeriodMillis(leaseRefreshPeriodMillis int) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.LeaseRefreshPeriodMillis = leaseRefreshPeriodMillis
+	}
+}
+
+// WithShardSyncIntervalMillis sets the interval between shard syncs
+func WithShardSyncIntervalMillis(shardSyncIntervalMillis int) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.ShardSyncIntervalMillis = shardSyncIntervalMillis
+	}
+}
+
+// WithShardEndSync syncs the shards every intervalMillis for durationMillis after the end of a shard
+func WithShardEndSync(intervalMillis, durationMillis int) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.ShardEndSyncIntervalMillis = intervalMillis
+		c.ShardEndSyncDurationMillis = durationMillis
+	}
+}
+
+// WithFailover sets the replica of the stream the worker fails over to once the primary stream is unavailable
+func WithFailover(secondaryRegionName, secondaryStreamName string, failoverAfterMillis int) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.SecondaryRegionName = secondaryRegionName
+		c.SecondaryStreamName = secondaryStreamName
+		c.RegionFailoverAfterMillis = failoverAfterMillis
+	}
+}
+
+// WithSequenceNumberTranslator sets the translation of the checkpoints at failover and failback
+func WithSequenceNumberTranslator(translator SequenceNumberTranslator) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.SequenceNumberTranslator = translator
+	}
+}
+
+// WithMaxRecords sets the max number of records returned by a GetRecords call
+func WithMaxRecords(maxRecords int) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.MaxRecords = maxRecords
+	}
+}
+
+// WithIdleTimeBetweenReadsInMillis sets the max delay between GetRecords calls
+func WithIdleTimeBetweenReadsInMillis(idleTimeBetweenReadsInMillis int) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.IdleTimeBetweenReadsInMillis = idleTimeBetweenReadsInMillis
+	}
+}
+
+// WithMaxLeasesForWorker sets the max number of leases held by the worker
+func WithMaxLeasesForWorker(n int) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.MaxLeasesForWorker = n
+	}
+}
+
+// WithMaxLeasesToAcquireAtOneTime sets the max number of leases acquired at every shard sync
+func WithMaxLeasesToAcquireAtOneTime(n int) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.MaxLeasesToAcquireAtOneTime = n
+	}
+}
+
+// WithLeaseStealing enables stealing leases from other workers to balance the load
+func WithLeaseStealing(enableLeaseStealing bool) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.EnableLeaseStealing = enableLeaseStealing
+	}
+}
+
+// WithLeaseStealingHandoffTimeout sets the time the owner of a claimed shard has to checkpoint and release the lease
+func WithLeaseStealingHandoffTimeout(millis int) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.LeaseStealingHandoffTimeoutMillis = millis
+	}
+}
+
+// WithMaxLeasesToStealAtOneTime sets the max number of leases stolen at once
+func WithMaxLeasesToStealAtOneTime(n int) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.MaxLeasesToStealAtOneTime = n
+	}
+}
+
+// WithEnhancedFanOutConsumerName enables the enhanced fan-out consumer with the specified name
+func WithEnhancedFanOutConsumerName(consumerName string) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.EnhancedFanOutConsumerName = consumerName
+		c.EnableEnhancedFanOutConsumer = true
+	}
+}
+
+// WithEnhancedFanOutConsumerARN enables the enhanced fan-out consumer with the specified consumer ARN
+func WithEnhancedFanOutConsumerARN(consumerARN string) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.EnhancedFanOutConsumerARN = consumerARN
+		c.EnableEnhancedFanOutConsumer = true
+	}
+}
+
+// WithPrefetch makes the polling shard consumers read ahead up to the given number of records and bytes per shard
+func WithPrefetch(maxRecords, maxBytes int) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.PrefetchMaxRecords = maxRecords
+		c.PrefetchMaxBytes = maxBytes
+	}
+}
+
+// WithAutoCheckpoint checkpoints the last record of the batches processed successfully by the record processors, at
+// most every intervalMillis unless recordCount records have been processed since the last checkpoint
+func WithAutoCheckpoint(intervalMillis, recordCount int) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.AutoCheckpoint = true
+		c.AutoCheckpointIntervalMillis = intervalMillis
+		c.AutoCheckpointRecordCount = recordCount
+	}
+}
+
+// WithBatching collects the records read from a shard until there are at least minBatchSize of them or the first
+// of them has waited for maxBatchWaitTimeMillis
+func WithBatching(minBatchSize, maxBatchWaitTimeMillis int) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.MinBatchSize = minBatchSize
+		c.MaxBatchWaitTimeMillis = maxBatchWaitTimeMillis
+	}
+}
+
+// WithMaxConcurrentShardConsumers bounds the shard consumers fetching and processing records at the same time
+func WithMaxConcurrentShardConsumers(maxConcurrentShardConsumers int) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.MaxConcurrentShardConsumers = maxConcurrentShardConsumers
+	}
+}
+
+// WithMaxGetRecordsCallsPerSecond limits the GetRecords calls per second of the worker
+func WithMaxGetRecordsCallsPerSecond(maxGetRecordsCallsPerSecond int) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.MaxGetRecordsCallsPerSecond = maxGetRecordsCallsPerSecond
+	}
+}
+
+// WithLeaseTableReadConsistency sets the consistency of the scans syncing the leases and of the reads of the lease of
+// a shard from the DynamoDB lease table
+func WithLeaseTableReadConsistency(scans, gets ReadConsistency) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.LeaseScanConsistency = scans
+		c.LeaseGetConsistency = gets
+	}
+}
+
+// WithLeaseTableCapacityHandler calls the handler with the capacity consumed by each call to the DynamoDB lease table
+func WithLeaseTableCapacityHandler(handler LeaseTableCapacityHandler) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.LeaseTableCapacityHandler = handler
+	}
+}
+
+// WithLeaseAttributeCompressionThreshold compresses the pending checkpoints and the checkpoint metadata larger than
+// threshold bytes in the DynamoDB lease table
+func WithLeaseAttributeCompressionThreshold(threshold int) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.LeaseAttributeCompressionThreshold = threshold
+	}
+}
+
+// WithProcessRecordsErrorPolicy sets how a shard consumer continues after its record processor failed a batch
+func WithProcessRecordsErrorPolicy(policy ProcessRecordsErrorPolicy) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.ProcessRecordsErrorPolicy = policy
+	}
+}
+
+// WithMaxDeliveryAttempts sets the number of deliveries of a batch after which the handler is called for each failed
+// delivery
+func WithMaxDeliveryAttempts(attempts int, handler ProcessingFailedHandler) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.MaxDeliveryAttempts = attempts
+		c.ProcessingFailedHandler = handler
+	}
+}
+
+// WithUnorderedProcessing fans the records of each batch out to the number of goroutines
+func WithUnorderedProcessing(concurrency int) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.UnorderedProcessingConcurrency = concurrency
+	}
+}
+
+// WithDebugServer enables the debug server of the worker at the address, the requests are authorized by the optional
+// authorizer
+func WithDebugServer(address string, authorizer DebugAuthorizer) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.DebugServerAddress = address
+		c.DebugAuthorizer = authorizer
+	}
+}
+
+// WithShutdownGraceMillis sets how long the record processors may checkpoint after the worker shut down
+func WithShutdownGraceMillis(shutdownGraceMillis int) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.ShutdownGraceMillis = shutdownGraceMillis
+	}
+}
+
+// WithRedisCheckpointer keeps leases and checkpoints in the given Redis server instead of DynamoDB
+func WithRedisCheckpointer(address, password string, db int) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.CheckpointBackend = RedisBackend
+		c.RedisAddress = address
+		c.RedisPassword = password
+		c.RedisDB = db
+	}
+}
+
+// WithSQLCheckpointer keeps leases and checkpoints in a PostgreSQL or MySQL database instead of DynamoDB
+func WithSQLCheckpointer(driverName, dataSourceName string) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.CheckpointBackend = SQLBackend
+		c.SQLDriverName = driverName
+		c.SQLDataSourceName = dataSourceName
+	}
+}
+
+// WithMemoryCheckpointer keeps leases and checkpoints in memory, persisted to the file unless it is empty
+func WithMemoryCheckpointer(file string) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.CheckpointBackend = MemoryBackend
+		c.MemoryCheckpointFile = file
+	}
+}
+
+// WithShardFilter scopes the worker to the shards matched by the filter
+func WithShardFilter(filter ShardFilter) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.ShardFilter = filter
+	}
+}
+
+// WithPinnedShards pins the worker to the shards with the IDs, with or without lease coordination
+func WithPinnedShards(leaseCoordination bool, shardIDs ...string) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.PinnedShardIDs = shardIDs
+		c.PinnedShardLeaseCoordination = leaseCoordination
+	}
+}
+
+// WithShardAssignment pre-assigns the shards to worker IDs
+func WithShardAssignment(assignment ShardAssignment) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.ShardAssignment = assignment
+	}
+}
+
+// WithShardAssignmentFallbackMillis sets the time after which the shards assigned to other workers are leased by the
+// worker when their leases are not held
+func WithShardAssignmentFallbackMillis(millis int) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.ShardAssignmentFallbackMillis = millis
+	}
+}
+
+// WithListShardsFilter sets the filter passed to ListShards to select the new shards to consume
+func WithListShardsFilter(filter types.ShardFilter) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.ListShardsFilter = &filter
+	}
+}
+
+// WithRecordTransformer sets the transformer of the user records delivered to the record processors
+func WithRecordTransformer(t transformer.RecordTransformer) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.RecordTransformer = t
+	}
+}
+
+// WithRecordDecoder sets the decoder of the user records delivered to the record processors
+func WithRecordDecoder(d decoder.Decoder) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.RecordDecoder = d
+	}
+}
+
+// WithLeaseAuditSink sets the sink recording the lease transitions of the worker
+func WithLeaseAuditSink(sink leaseaudit.Sink) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.LeaseAuditSink = sink
+	}
+}
+
+// WithLeaseCoordinator sets the coordinator assigning the shards to the workers
+func WithLeaseCoordinator(coordinator leasecoordinator.LeaseCoordinator) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.LeaseCoordinator = coordinator
+	}
+}
+
+// WithLagThreshold sets the hook which is called when the MillisBehindLatest of a shard exceeds the threshold
+func WithLagThreshold(thresholdMillis int64, handler LagHandler) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.LagThresholdMillis = thresholdMillis
+		c.LagHandler = handler
+	}
+}
+
+// WithCheckpointAgeAlarm raises a CheckpointAgeAlarm when the checkpoint of a shard behind its tip has not advanced
+// for the threshold
+func WithCheckpointAgeAlarm(thresholdMillis int) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.CheckpointAgeAlarmMillis = thresholdMillis
+	}
+}
+
+// WithMillisBehindLatestAlarm raises a MillisBehindLatestAlarm when the MillisBehindLatest of a shard exceeds the
+// threshold
+func WithMillisBehindLatestAlarm(thresholdMillis int) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.MillisBehindLatestAlarmMillis = thresholdMillis
+	}
+}
+
+// WithAlarmEvaluationIntervalMillis sets the interval the alarms of the shards are evaluated at
+func WithAlarmEvaluationIntervalMillis(millis int) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.AlarmEvaluationIntervalMillis = millis
+	}
+}
+
+// WithAlarmHandler sets the hook which is called when an alarm of a shard is raised or cleared
+func WithAlarmHandler(handler AlarmHandler) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.AlarmHandler = handler
+	}
+}
+
+// WithHotShardsReport logs the topN shards with the highest throughput over every interval
+func WithHotShardsReport(intervalMillis, topN int) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.HotShardsReportIntervalMillis = intervalMillis
+		c.HotShardsTopN = topN
+	}
+}
+
+// WithHotShardsHandler sets the hook which receives the hottest shards whenever they are reported
+func WithHotShardsHandler(handler HotShardsHandler) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.HotShardsHandler = handler
+	}
+}
+
+// WithLogger sets the logger of the worker
+func WithLogger(logger logger.Logger) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.Logger = logger
+	}
+}
+
+// WithMonitoringService sets the monitoring service the metrics are reported to
+func WithMonitoringService(mService metrics.MonitoringService) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.MonitoringService = mService
+	}
+}
+
+// WithMetricsNamespace sets the namespace of the metrics instead of the application name
+func WithMetricsNamespace(namespace string) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.MetricsNamespace = namespace
+	}
+}
+
+// WithDisabledMetrics disables the metrics
+func WithDisabledMetrics(disabled ...metrics.Metric) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.DisabledMetrics = append(c.DisabledMetrics, disabled...)
+	}
+}
+
+// WithShardMetricsSampleRatio sets the fraction of the shards whose per-shard metrics are reported
+func WithShardMetricsSampleRatio(ratio float64) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.ShardMetricsSampleRatio = ratio
+	}
+}
+
+// WithTracerProvider sets the OpenTelemetry tracer provider of the spans of the worker
+func WithTracerProvider(tracerProvider trace.TracerProvider) Option {
+	return func(c *KinesisClientLibConfiguration) {
+		c.TracerProvider = tracerProvider
+	}
+}
@@ -0,0 +1,93 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package config
+
+import "time"
+
+type (
+	// PollResult describes the result of a GetRecords call of a polling shard consumer
+	PollResult struct {
+		// ShardID is the lease key of the shard
+		ShardID string
+
+		// RecordCount is the number of records returned by GetRecords
+		RecordCount int
+
+		// MaxRecords is the max number of records requested by GetRecords
+		MaxRecords int
+
+		// MillisBehindLatest is the lag of the shard consumer reported by GetRecords
+		MillisBehindLatest int64
+	}
+
+	// PollingStrategy decides how long a polling shard consumer waits before the next GetRecords call. It is
+	// shared by the shard consumers of a worker and must be safe for concurrent use. The read limits of a shard
+	// are enforced by the shard consumer regardless of the strategy.
+	PollingStrategy interface {
+		NextPollDelay(result PollResult) time.Duration
+	}
+
+	// FixedPollingStrategy waits IdleTimeMillis only if no records were returned and the consumer has caught up
+	FixedPollingStrategy struct {
+		IdleTimeMillis int
+	}
+
+	// AdaptivePollingStrategy polls immediately while the consumer is at least LagThresholdMillis behind or a full
+	// batch has been returned. Below the threshold the delay grows linearly with decreasing lag up to
+	// MaxDelayMillis once the consumer has caught up.
+	AdaptivePollingStrategy struct {
+		MaxDelayMillis     int
+		LagThresholdMillis int
+	}
+)
+
+func (s FixedPollingStrategy) NextPollDelay(result PollResult) time.Duration {
+	if result.RecordCount == 0 && result.MillisBehindLatest < int64(s.IdleTimeMillis) {
+		return time.Duration(s.IdleTimeMillis) * time.Millisecond
+	}
+	return 0
+}
+
+func (s AdaptivePollingStrategy) NextPollDelay(result PollResult) time.Duration {
+	if result.MaxRecords > 0 && result.RecordCount >= result.MaxRecords {
+		return 0
+	}
+	if result.MillisBehindLatest >= int64(s.LagThresholdMillis) {
+		return 0
+	}
+
+	maxDelay := time.Duration(s.MaxDelayMillis) * time.Millisecond
+	if s.LagThresholdMillis <= 0 {
+		return maxDelay
+	}
+	return maxDelay * time.Duration(int64(s.LagThresholdMillis)-result.MillisBehindLatest) / time.Duration(s.LagThresholdMillis)
+}
+
+// GetPollingStrategy returns the configured polling strategy, by default an AdaptivePollingStrategy backing off
+// toward IdleTimeBetweenReadsInMillis.
+func (c *KinesisClientLibConfiguration) GetPollingStrategy() PollingStrategy {
+	if c.PollingStrategy != nil {
+		return c.PollingStrategy
+	}
+	return AdaptivePollingStrategy{
+		MaxDelayMillis:     c.IdleTimeBetweenReadsInMillis,
+		LagThresholdMillis: DefaultPollingLagThresholdMillis,
+	}
+}
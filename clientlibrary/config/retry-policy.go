@@ -0,0 +1,136 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package config
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddle "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/smithy-go"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/metrics"
+)
+
+// kmsThrottlingErrorCode is returned by Kinesis if KMS throttles the decryption of the records, it is not
+// part of the throttling errors of the SDK.
+const kmsThrottlingErrorCode = "KMSThrottlingException"
+
+// RetryPolicy specifies how the calls of the KCL to Kinesis and DynamoDB are retried. The delay before a retry
+// grows exponentially from BaseDelayMillis up to MaxDelayMillis.
+type RetryPolicy struct {
+	// MaxAttempts is the max number of attempts of a call to the AWS SDK, including the first one
+	MaxAttempts int
+
+	// BaseDelayMillis is the delay before the first retry, it is doubled with every retry
+	BaseDelayMillis int
+
+	// MaxDelayMillis caps the delay before a retry
+	MaxDelayMillis int
+
+	// Jitter picks a random delay between 0 and the exponential delay ("full jitter"), so that workers which
+	// are throttled at the same time do not retry at the same time
+	Jitter bool
+}
+
+// Delay returns the delay before the given retry, starting at 1 for the first retry.
+func (p RetryPolicy) Delay(retry int) time.Duration {
+	if retry < 1 {
+		retry = 1
+	}
+
+	delay := time.Duration(p.MaxDelayMillis) * time.Millisecond
+	// avoid overflowing the duration for large retry numbers
+	if exp := math.Exp2(float64(retry-1)) * float64(p.BaseDelayMillis); exp < float64(p.MaxDelayMillis) {
+		delay = time.Duration(exp) * time.Millisecond
+	}
+
+	if p.Jitter && delay > 0 {
+		delay = time.Duration(rand.Int63n(int64(delay) + 1))
+	}
+	return delay
+}
+
+// BackoffDelay implements retry.BackoffDelayer of the AWS SDK.
+func (p RetryPolicy) BackoffDelay(attempt int, _ error) (time.Duration, error) {
+	return p.Delay(attempt), nil
+}
+
+// NewRetryer creates a retryer of the AWS SDK following the retry policy. Every throttled attempt is counted
+// by the throttled requests metric of the monitoring service.
+func (p RetryPolicy) NewRetryer(mService metrics.MonitoringService) aws.Retryer {
+	if mService == nil {
+		mService = metrics.NoopMonitoringService{}
+	}
+
+	return recordThrottles(retry.NewStandard(func(o *retry.StandardOptions) {
+		o.MaxAttempts = p.MaxAttempts
+		o.MaxBackoff = time.Duration(p.MaxDelayMillis) * time.Millisecond
+		o.Backoff = p
+	}), mService)
+}
+
+// recordThrottles counts the throttled attempts of the retryer by the throttled requests metric of the monitoring
+// service, the attempts of a retryer not implementing aws.RetryerV2 are not counted.
+func recordThrottles(retryer aws.Retryer, mService metrics.MonitoringService) aws.Retryer {
+	retryerV2, ok := retryer.(aws.RetryerV2)
+	if !ok {
+		return retryer
+	}
+	return &throttleRecorder{RetryerV2: retryerV2, mService: mService}
+}
+
+// IsThrottlingError reports whether err is caused by the throttling of an AWS API call.
+func IsThrottlingError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == kmsThrottlingErrorCode {
+		return true
+	}
+	return retry.IsErrorThrottles(retry.DefaultThrottles).IsErrorThrottle(err) == aws.TrueTernary
+}
+
+// throttleRecorder counts the throttled attempts of the calls to the AWS SDK per API
+type throttleRecorder struct {
+	aws.RetryerV2
+	mService metrics.MonitoringService
+}
+
+func (r *throttleRecorder) GetAttemptToken(ctx context.Context) (func(error) error, error) {
+	release, err := r.RetryerV2.GetAttemptToken(ctx)
+	if err != nil {
+		return release, err
+	}
+
+	return func(attemptErr error) error {
+		if m, ok := r.mService.(metrics.ThrottlingMonitoringService); ok && IsThrottlingError(attemptErr) {
+			m.IncrThrottledRequests(awsmiddle.GetServiceID(ctx) + "." + awsmiddle.GetOperationName(ctx))
+		}
+		return release(attemptErr)
+	}, nil
+}
@@ -0,0 +1,73 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package config
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+)
+
+// ShardAssignment pre-assigns the shards to workers, for deployments which need a stable mapping of the shards to the
+// hosts, e.g. for local caches. It returns the ID of the worker a shard is assigned to, or "" if the shard is leased
+// by any worker. It is called with the name of the stream of the shard. The workers don't take the leases of the
+// shards assigned to other workers unless ShardAssignmentFallbackMillis is set.
+type ShardAssignment func(streamName string, shard types.Shard) string
+
+// maxHashKey is the upper bound of the hash key space of a stream, the hash keys are 128 bits
+var maxHashKey = new(big.Int).Lsh(big.NewInt(1), 128)
+
+// StaticShardAssignment assigns the shards by their IDs to the worker IDs of the map, the other shards are leased by
+// any worker
+func StaticShardAssignment(workerIDsByShardID map[string]string) ShardAssignment {
+	return func(_ string, shard types.Shard) string {
+		return workerIDsByShardID[aws.ToString(shard.ShardId)]
+	}
+}
+
+// HashKeyRangeAssignment splits the hash key space into as many equal ranges as worker IDs, in order, and assigns
+// each shard to the worker whose range contains the starting hash key of the shard. The child shards of a resharding
+// start within the hash key ranges of their parents, so that they mostly remain with the workers of their parents.
+func HashKeyRangeAssignment(workerIDs ...string) ShardAssignment {
+	return func(_ string, shard types.Shard) string {
+		if len(workerIDs) == 0 || shard.HashKeyRange == nil {
+			return ""
+		}
+
+		start, ok := new(big.Int).SetString(aws.ToString(shard.HashKeyRange.StartingHashKey), 10)
+		if !ok || start.Sign() < 0 || start.Cmp(maxHashKey) >= 0 {
+			return ""
+		}
+		index := new(big.Int).Mul(start, big.NewInt(int64(len(workerIDs))))
+		return workerIDs[index.Div(index, maxHashKey).Int64()]
+	}
+}
+
+// StatefulSetAssignment is a HashKeyRangeAssignment to the pods of a StatefulSet by ordinal, the worker IDs are the
+// pod names "<name>-0" to "<name>-<replicas-1>", e.g. with EnvWorkerID of the pod name.
+func StatefulSetAssignment(name string, replicas int) ShardAssignment {
+	workerIDs := make([]string, replicas)
+	for i := range workerIDs {
+		workerIDs[i] = fmt.Sprintf("%s-%d", name, i)
+	}
+	return HashKeyRangeAssignment(workerIDs...)
+}
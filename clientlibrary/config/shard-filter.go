@@ -0,0 +1,78 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package config
+
+import (
+	"math/big"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+)
+
+// ShardFilter decides whether a shard is consumed by a worker, so that a worker can be scoped to a subset of the
+// shards of a stream. It is called with the name of the stream of the shard, both for the shards listed from Kinesis
+// and for the child shards of closed shards. The worker neither takes nor steals the leases of the shards outside the
+// filter, and it leaves their leases to the workers consuming them.
+type ShardFilter func(streamName string, shard types.Shard) bool
+
+// ShardIDPrefixFilter consumes the shards whose IDs start with one of the prefixes
+func ShardIDPrefixFilter(prefixes ...string) ShardFilter {
+	return func(_ string, shard types.Shard) bool {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(aws.ToString(shard.ShardId), prefix) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// ShardIDFilter consumes the shards with one of the IDs
+func ShardIDFilter(shardIDs ...string) ShardFilter {
+	ids := make(map[string]bool, len(shardIDs))
+	for _, id := range shardIDs {
+		ids[id] = true
+	}
+	return func(_ string, shard types.Shard) bool {
+		return ids[aws.ToString(shard.ShardId)]
+	}
+}
+
+// HashKeyRangeFilter consumes the shards whose hash key ranges overlap the inclusive range of hash keys. The child
+// shards of a resharding cover the hash key ranges of their parents, so the shards of a range remain consumed by the
+// same workers.
+func HashKeyRangeFilter(startingHashKey, endingHashKey *big.Int) ShardFilter {
+	return func(_ string, shard types.Shard) bool {
+		if shard.HashKeyRange == nil {
+			return false
+		}
+
+		start, ok := new(big.Int).SetString(aws.ToString(shard.HashKeyRange.StartingHashKey), 10)
+		if !ok {
+			return false
+		}
+		end, ok := new(big.Int).SetString(aws.ToString(shard.HashKeyRange.EndingHashKey), 10)
+		if !ok {
+			return false
+		}
+		return start.Cmp(endingHashKey) <= 0 && end.Cmp(startingHashKey) >= 0
+	}
+}
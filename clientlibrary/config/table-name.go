@@ -0,0 +1,73 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// sharedLeaseKeySeparator separates the application from the shard ID in the lease keys of a shared lease table
+const sharedLeaseKeySeparator = "#"
+
+var tableNamePlaceholder = regexp.MustCompile(`\{([A-Za-z0-9_.-]+)\}`)
+
+// ExpandTableName replaces the placeholders of a lease table name template, e.g. "{appName}-{env}-leases", with
+// the values of the variables. It fails if a placeholder has no value.
+func ExpandTableName(template string, variables map[string]string) (string, error) {
+	var missing []string
+	tableName := tableNamePlaceholder.ReplaceAllStringFunc(template, func(placeholder string) string {
+		name := placeholder[1 : len(placeholder)-1]
+		value, ok := variables[name]
+		if !ok {
+			missing = append(missing, placeholder)
+		}
+		return value
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("no value for %s in lease table name template %q", strings.Join(missing, ", "), template)
+	}
+	return tableName, nil
+}
+
+// resolveTableName sets the TableName to the TableNameTemplate expanded with the application, the stream, the
+// region and the TableNameVariables, if there is a template
+func (c *KinesisClientLibConfiguration) resolveTableName() error {
+	if c.TableNameTemplate == "" {
+		return nil
+	}
+
+	variables := map[string]string{
+		"appName":    c.ApplicationName,
+		"streamName": c.StreamName,
+		"region":     c.RegionName,
+	}
+	for name, value := range c.TableNameVariables {
+		variables[name] = value
+	}
+
+	tableName, err := ExpandTableName(c.TableNameTemplate, variables)
+	if err != nil {
+		return err
+	}
+	c.TableName = tableName
+	return nil
+}
@@ -0,0 +1,313 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// maxGetRecordsLimit is the max number of records returned by a GetRecords call
+const maxGetRecordsLimit = 10000
+
+// regionPattern matches the names of the AWS regions, e.g. us-west-2, us-gov-east-1 or cn-north-1
+var regionPattern = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-[0-9]+$`)
+
+type (
+	// ValidationError is an invalid setting of a KinesisClientLibConfiguration
+	ValidationError struct {
+		// Field is the name of the invalid setting
+		Field string
+
+		// Value is the invalid value
+		Value interface{}
+
+		// Reason explains why the value is invalid
+		Reason string
+	}
+
+	// ValidationErrors are all invalid settings of a KinesisClientLibConfiguration
+	ValidationErrors []*ValidationError
+)
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid %s %v: %s", e.Field, e.Value, e.Reason)
+}
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Validate returns ValidationErrors listing all invalid settings, e.g. an unknown region, negative intervals or
+// conflicting modes, and nil if the configuration is valid.
+func (c *KinesisClientLibConfiguration) Validate() error {
+	var errs ValidationErrors
+	invalid := func(field string, value interface{}, reason string) {
+		errs = append(errs, &ValidationError{Field: field, Value: value, Reason: reason})
+	}
+
+	for field, value := range map[string]string{
+		"ApplicationName": c.ApplicationName,
+		"TableName":       c.TableName,
+		"WorkerID":        c.WorkerID,
+	} {
+		if empty(value) {
+			invalid(field, value, "non-empty value expected")
+		}
+	}
+	if empty(c.StreamName) && !c.IsMultiStreamMode() {
+		invalid("StreamName", c.StreamName, "non-empty value expected unless multiple streams are consumed")
+	}
+	// the prefix of app "a" would match the lease keys of app "a#b"
+	if strings.Contains(strings.TrimSuffix(c.LeaseKeyPrefix, sharedLeaseKeySeparator), sharedLeaseKeySeparator) {
+		invalid("LeaseKeyPrefix", c.LeaseKeyPrefix, "the # separator is only expected at the end of the prefix")
+	}
+	if !regionPattern.MatchString(c.RegionName) {
+		invalid("RegionName", c.RegionName, "unknown region")
+	}
+
+	for field, value := range map[string]int{
+		"FailoverTimeMillis":            c.FailoverTimeMillis,
+		"LeaseRefreshPeriodMillis":      c.LeaseRefreshPeriodMillis,
+		"MaxRecords":                    c.MaxRecords,
+		"IdleTimeBetweenReadsInMillis":  c.IdleTimeBetweenReadsInMillis,
+		"ParentShardPollIntervalMillis": c.ParentShardPollIntervalMillis,
+		"ShardSyncIntervalMillis":       c.ShardSyncIntervalMillis,
+		"TaskBackoffTimeMillis":         c.TaskBackoffTimeMillis,
+		"MaxLeasesForWorker":            c.MaxLeasesForWorker,
+		"MaxLeasesToStealAtOneTime":     c.MaxLeasesToStealAtOneTime,
+		"MaxLeasesToAcquireAtOneTime":   c.MaxLeasesToAcquireAtOneTime,
+		"LeaseStealingIntervalMillis":   c.LeaseStealingIntervalMillis,
+		"LeaseCleanupIntervalMillis":    c.LeaseCleanupIntervalMillis,
+		"AsyncCheckpointIntervalMillis": c.AsyncCheckpointIntervalMillis,
+		"ProcessorRestartBackoffMillis": c.ProcessorRestartBackoffMillis,
+		"LeaseTableScanSegments":        c.LeaseTableScanSegments,
+		"AlarmEvaluationIntervalMillis": c.AlarmEvaluationIntervalMillis,
+		"HotShardsTopN":                 c.HotShardsTopN,
+	} {
+		if value <= 0 {
+			invalid(field, value, "positive value expected")
+		}
+	}
+	for field, value := range map[string]int{
+		"ShutdownGraceMillis":                c.ShutdownGraceMillis,
+		"MaxRetryCount":                      c.MaxRetryCount,
+		"MaxProcessRecordsRetries":           c.MaxProcessRecordsRetries,
+		"MaxDeliveryAttempts":                c.MaxDeliveryAttempts,
+		"UnorderedProcessingConcurrency":     c.UnorderedProcessingConcurrency,
+		"MaxRecordsPerSecond":                c.MaxRecordsPerSecond,
+		"MaxConcurrentShardConsumers":        c.MaxConcurrentShardConsumers,
+		"MaxGetRecordsCallsPerSecond":        c.MaxGetRecordsCallsPerSecond,
+		"PrefetchMaxRecords":                 c.PrefetchMaxRecords,
+		"PrefetchMaxBytes":                   c.PrefetchMaxBytes,
+		"MinBatchSize":                       c.MinBatchSize,
+		"MaxBatchWaitTimeMillis":             c.MaxBatchWaitTimeMillis,
+		"LeaseStealingHandoffTimeoutMillis":  c.LeaseStealingHandoffTimeoutMillis,
+		"ShardEndSyncIntervalMillis":         c.ShardEndSyncIntervalMillis,
+		"ShardEndSyncDurationMillis":         c.ShardEndSyncDurationMillis,
+		"ShardAssignmentFallbackMillis":      c.ShardAssignmentFallbackMillis,
+		"CheckpointAgeAlarmMillis":           c.CheckpointAgeAlarmMillis,
+		"MillisBehindLatestAlarmMillis":      c.MillisBehindLatestAlarmMillis,
+		"LeaseAttributeCompressionThreshold": c.LeaseAttributeCompressionThreshold,
+		"AutoCheckpointIntervalMillis":       c.AutoCheckpointIntervalMillis,
+		"AutoCheckpointRecordCount":          c.AutoCheckpointRecordCount,
+		"HotShardsReportIntervalMillis":      c.HotShardsReportIntervalMillis,
+	} {
+		if value < 0 {
+			invalid(field, value, "non-negative value expected")
+		}
+	}
+	if c.ShardMetricsSampleRatio <= 0 || c.ShardMetricsSampleRatio > 1 {
+		invalid("ShardMetricsSampleRatio", c.ShardMetricsSampleRatio, "value between 0 and 1 expected")
+	}
+	if c.ShardEndSyncIntervalMillis > 0 && c.ShardEndSyncDurationMillis == 0 {
+		invalid("ShardEndSyncDurationMillis", c.ShardEndSyncDurationMillis, "a duration is required with ShardEndSyncIntervalMillis")
+	}
+	if c.ShardEndSyncIntervalMillis > c.ShardSyncIntervalMillis {
+		invalid("ShardEndSyncIntervalMillis", c.ShardEndSyncIntervalMillis, "exceeds ShardSyncIntervalMillis")
+	}
+	if c.MaxInFlightBytes < 0 {
+		invalid("MaxInFlightBytes", c.MaxInFlightBytes, "non-negative value expected")
+	}
+	if c.ListShardsFilter != nil && c.ListShardsFilter.Type == "" {
+		invalid("ListShardsFilter", c.ListShardsFilter, "the type of the filter is required")
+	}
+	if c.MinBatchSize > 1 && c.MaxBatchWaitTimeMillis <= 0 {
+		invalid("MaxBatchWaitTimeMillis", c.MaxBatchWaitTimeMillis, "positive value expected with MinBatchSize")
+	}
+	if c.LagHandler != nil && c.LagThresholdMillis <= 0 {
+		invalid("LagThresholdMillis", c.LagThresholdMillis, "positive value expected")
+	}
+	if c.AlarmHandler != nil && c.CheckpointAgeAlarmMillis == 0 && c.MillisBehindLatestAlarmMillis == 0 {
+		invalid("AlarmHandler", "AlarmHandler", "a CheckpointAgeAlarmMillis or MillisBehindLatestAlarmMillis threshold is required")
+	}
+	if c.HotShardsHandler != nil && c.HotShardsReportIntervalMillis == 0 {
+		invalid("HotShardsReportIntervalMillis", c.HotShardsReportIntervalMillis, "positive value expected with HotShardsHandler")
+	}
+	if c.EnableLeaseStealing && c.LeaseStealingHandoffTimeoutMillis >= c.LeaseStealingClaimTimeoutMillis {
+		invalid("LeaseStealingHandoffTimeoutMillis", c.LeaseStealingHandoffTimeoutMillis, "the lease has to be handed off before the claim expires after LeaseStealingClaimTimeoutMillis")
+	}
+	if c.EnableLeaseStealing && c.LeaseCoordinator != nil {
+		invalid("EnableLeaseStealing", c.EnableLeaseStealing, "the shards are assigned by the LeaseCoordinator")
+	}
+	if c.ShardAssignment != nil && c.LeaseCoordinator != nil {
+		invalid("ShardAssignment", "ShardAssignment", "the shards are assigned by the LeaseCoordinator")
+	}
+	if c.ShardAssignmentFallbackMillis > 0 && c.ShardAssignment == nil {
+		invalid("ShardAssignmentFallbackMillis", c.ShardAssignmentFallbackMillis, "a ShardAssignment is required")
+	}
+	for _, id := range c.PinnedShardIDs {
+		if empty(id) {
+			invalid("PinnedShardIDs", c.PinnedShardIDs, "non-empty shard IDs expected")
+			break
+		}
+	}
+	if c.PinnedShardLeaseCoordination && len(c.PinnedShardIDs) == 0 {
+		invalid("PinnedShardLeaseCoordination", c.PinnedShardLeaseCoordination, "PinnedShardIDs are required")
+	}
+	if len(c.PinnedShardIDs) > 0 && !c.PinnedShardLeaseCoordination && c.LeaseCoordinator != nil {
+		invalid("LeaseCoordinator", "LeaseCoordinator", "the pinned shards are consumed without lease coordination")
+	}
+	if c.MaxRecords > maxGetRecordsLimit {
+		invalid("MaxRecords", c.MaxRecords, fmt.Sprintf("at most %d records are returned by GetRecords", maxGetRecordsLimit))
+	}
+	if c.LeaseRefreshPeriodMillis >= c.FailoverTimeMillis {
+		invalid("LeaseRefreshPeriodMillis", c.LeaseRefreshPeriodMillis, "the lease has to be renewed before it expires after FailoverTimeMillis")
+	}
+
+	if c.InitialPositionInStream == AT_TIMESTAMP && c.InitialPositionInStreamExtended.Timestamp == nil {
+		invalid("InitialPositionInStream", "AT_TIMESTAMP", "a timestamp is required")
+	}
+	for shardID, sequenceNumber := range c.InitialPositionInStreamExtended.SequenceNumbers {
+		if empty(shardID) || empty(sequenceNumber) {
+			invalid("InitialPositionInStreamExtended.SequenceNumbers", shardID+"="+sequenceNumber, "non-empty shard ID and sequence number expected")
+		}
+	}
+
+	// conflicting modes
+	if len(c.Streams) > 0 && c.StreamProvider != nil {
+		invalid("Streams", c.Streams, "the streams are returned by the StreamProvider")
+	}
+	for _, stream := range c.Streams {
+		if empty(stream) {
+			invalid("Streams", c.Streams, "non-empty stream names expected")
+			break
+		}
+	}
+	if c.IsMultiStreamMode() && c.StreamARN != "" {
+		invalid("StreamARN", c.StreamARN, "the ARNs of multiple streams are set by Streams")
+	}
+	if c.IsMultiStreamMode() && c.EnhancedFanOutConsumerARN != "" {
+		invalid("EnhancedFanOutConsumerARN", c.EnhancedFanOutConsumerARN, "a consumer is registered per stream when multiple streams are consumed")
+	}
+	if c.SecondaryStreamName != "" || c.SecondaryRegionName != "" {
+		if !regionPattern.MatchString(c.SecondaryRegionName) {
+			invalid("SecondaryRegionName", c.SecondaryRegionName, "unknown region")
+		}
+		if c.SecondaryRegionName == c.RegionName && c.SecondaryStreamName == c.StreamName {
+			invalid("SecondaryStreamName", c.SecondaryStreamName, "the secondary stream is the primary stream")
+		}
+		if empty(c.SecondaryStreamName) {
+			invalid("SecondaryStreamName", c.SecondaryStreamName, "the replica of the stream is required with SecondaryRegionName")
+		}
+		if c.RegionFailoverAfterMillis <= 0 {
+			invalid("RegionFailoverAfterMillis", c.RegionFailoverAfterMillis, "positive value expected")
+		}
+		if c.IsMultiStreamMode() {
+			invalid("SecondaryStreamName", c.SecondaryStreamName, "multiple streams cannot fail over")
+		}
+		if c.EnhancedFanOutConsumerARN != "" {
+			invalid("EnhancedFanOutConsumerARN", c.EnhancedFanOutConsumerARN, "the consumer of the secondary stream is found by EnhancedFanOutConsumerName")
+		}
+	}
+	if c.EnableEnhancedFanOutConsumer && c.EnhancedFanOutConsumerARN == "" && empty(c.EnhancedFanOutConsumerName) {
+		invalid("EnhancedFanOutConsumerName", c.EnhancedFanOutConsumerName, "a consumer name or ARN is required by the enhanced fan-out consumer")
+	}
+
+	if c.LeaseScanConsistency < EventuallyConsistent || c.LeaseScanConsistency > StronglyConsistent {
+		invalid("LeaseScanConsistency", c.LeaseScanConsistency, "unsupported read consistency")
+	}
+	if c.LeaseGetConsistency < EventuallyConsistent || c.LeaseGetConsistency > StronglyConsistent {
+		invalid("LeaseGetConsistency", c.LeaseGetConsistency, "unsupported read consistency")
+	}
+
+	if c.ProcessRecordsErrorPolicy < HaltOnError || c.ProcessRecordsErrorPolicy > RetryOnError {
+		invalid("ProcessRecordsErrorPolicy", c.ProcessRecordsErrorPolicy, "unsupported policy")
+	}
+	if c.MaxDeliveryAttempts > 0 && c.ProcessingFailedHandler == nil {
+		invalid("ProcessingFailedHandler", nil, "a handler is required with MaxDeliveryAttempts")
+	}
+	if c.ProcessRecordsErrorPolicy == RetryOnError && c.DeadLetterPublisher != nil {
+		invalid("ProcessRecordsErrorPolicy", "RetryOnError", "failed batches are retried instead of being published to the DeadLetterPublisher")
+	}
+
+	if len(c.ProxyURL) > 0 {
+		if proxyURL, err := url.Parse(c.ProxyURL); err != nil || proxyURL.Scheme == "" || proxyURL.Host == "" {
+			invalid("ProxyURL", c.ProxyURL, "absolute URL expected")
+		}
+		if c.HTTPClient != nil {
+			invalid("ProxyURL", c.ProxyURL, "the proxy is set by the transport of the HTTPClient")
+		}
+	}
+	if c.SkipTLSVerify && c.HTTPClient != nil {
+		invalid("SkipTLSVerify", c.SkipTLSVerify, "the TLS config is set by the transport of the HTTPClient")
+	} else if c.SkipTLSVerify && c.AWSConfig != nil {
+		invalid("SkipTLSVerify", c.SkipTLSVerify, "the TLS config is set by the HTTP client of the AWSConfig")
+	}
+	for operation, timeout := range c.APICallTimeouts {
+		if timeout <= 0 {
+			invalid("APICallTimeouts."+operation, timeout, "positive value expected")
+		}
+		if operation == "SubscribeToShard" {
+			invalid("APICallTimeouts."+operation, timeout, "the events are read after the call has returned")
+		}
+	}
+
+	switch c.CheckpointBackend {
+	case RedisBackend:
+		if empty(c.RedisAddress) {
+			invalid("RedisAddress", c.RedisAddress, "non-empty value expected by the Redis checkpointer")
+		}
+	case SQLBackend:
+		if empty(c.SQLDriverName) || empty(c.SQLDataSourceName) {
+			invalid("SQLDriverName", c.SQLDriverName, "a driver and a data source name are required by the SQL checkpointer")
+		}
+	}
+
+	if c.Logger == nil {
+		invalid("Logger", c.Logger, "non-nil value expected")
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	// the errors are reported in a stable order
+	sort.SliceStable(errs, func(i, j int) bool {
+		return errs[i].Field < errs[j].Field
+	})
+	return errs
+}
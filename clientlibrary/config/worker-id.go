@@ -0,0 +1,58 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package config
+
+import (
+	"fmt"
+	"os"
+)
+
+// HostnameWorkerID identifies a worker by the hostname of its machine, which is stable across restarts of the
+// worker on the same machine.
+func HostnameWorkerID() WorkerIDProvider {
+	return os.Hostname
+}
+
+// EnvWorkerID identifies a worker by the value of an environment variable, e.g. the name of the pod of the worker
+// exposed as POD_NAME with the downward API of Kubernetes. The name of the pod of a StatefulSet is stable across
+// restarts and deployments.
+func EnvWorkerID(name string) WorkerIDProvider {
+	return func() (string, error) {
+		workerID, ok := os.LookupEnv(name)
+		if !ok || empty(workerID) {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return workerID, nil
+	}
+}
+
+// resolveWorkerID sets the WorkerID to the one returned by the WorkerIDProvider, if any
+func (c *KinesisClientLibConfiguration) resolveWorkerID() error {
+	if c.WorkerIDProvider == nil {
+		return nil
+	}
+
+	workerID, err := c.WorkerIDProvider()
+	if err != nil {
+		return fmt.Errorf("failed to get the worker ID: %w", err)
+	}
+	c.WorkerID = workerID
+	return nil
+}
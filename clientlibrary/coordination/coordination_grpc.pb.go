@@ -0,0 +1,147 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             (unknown)
+// source: coordination.proto
+
+package coordination
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// CoordinationClient is the client API for Coordination service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type CoordinationClient interface {
+	// GetAssignment returns the shards consumed by the worker.
+	GetAssignment(ctx context.Context, in *GetAssignmentRequest, opts ...grpc.CallOption) (*GetAssignmentResponse, error)
+	// Drain releases the leases of the shards consumed by the worker after their record processors have been
+	// shut down, all shards are released unless shard IDs are given.
+	Drain(ctx context.Context, in *DrainRequest, opts ...grpc.CallOption) (*DrainResponse, error)
+}
+
+type coordinationClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewCoordinationClient(cc grpc.ClientConnInterface) CoordinationClient {
+	return &coordinationClient{cc}
+}
+
+func (c *coordinationClient) GetAssignment(ctx context.Context, in *GetAssignmentRequest, opts ...grpc.CallOption) (*GetAssignmentResponse, error) {
+	out := new(GetAssignmentResponse)
+	err := c.cc.Invoke(ctx, "/kcl.coordination.v1.Coordination/GetAssignment", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *coordinationClient) Drain(ctx context.Context, in *DrainRequest, opts ...grpc.CallOption) (*DrainResponse, error) {
+	out := new(DrainResponse)
+	err := c.cc.Invoke(ctx, "/kcl.coordination.v1.Coordination/Drain", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CoordinationServer is the server API for Coordination service.
+// All implementations must embed UnimplementedCoordinationServer
+// for forward compatibility
+type CoordinationServer interface {
+	// GetAssignment returns the shards consumed by the worker.
+	GetAssignment(context.Context, *GetAssignmentRequest) (*GetAssignmentResponse, error)
+	// Drain releases the leases of the shards consumed by the worker after their record processors have been
+	// shut down, all shards are released unless shard IDs are given.
+	Drain(context.Context, *DrainRequest) (*DrainResponse, error)
+	mustEmbedUnimplementedCoordinationServer()
+}
+
+// UnimplementedCoordinationServer must be embedded to have forward compatible implementations.
+type UnimplementedCoordinationServer struct {
+}
+
+func (UnimplementedCoordinationServer) GetAssignment(context.Context, *GetAssignmentRequest) (*GetAssignmentResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetAssignment not implemented")
+}
+func (UnimplementedCoordinationServer) Drain(context.Context, *DrainRequest) (*DrainResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Drain not implemented")
+}
+func (UnimplementedCoordinationServer) mustEmbedUnimplementedCoordinationServer() {}
+
+// UnsafeCoordinationServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to CoordinationServer will
+// result in compilation errors.
+type UnsafeCoordinationServer interface {
+	mustEmbedUnimplementedCoordinationServer()
+}
+
+func RegisterCoordinationServer(s grpc.ServiceRegistrar, srv CoordinationServer) {
+	s.RegisterService(&Coordination_ServiceDesc, srv)
+}
+
+func _Coordination_GetAssignment_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetAssignmentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CoordinationServer).GetAssignment(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kcl.coordination.v1.Coordination/GetAssignment",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CoordinationServer).GetAssignment(ctx, req.(*GetAssignmentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Coordination_Drain_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DrainRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CoordinationServer).Drain(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kcl.coordination.v1.Coordination/Drain",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CoordinationServer).Drain(ctx, req.(*DrainRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Coordination_ServiceDesc is the grpc.ServiceDesc for Coordination service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Coordination_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "kcl.coordination.v1.Coordination",
+	HandlerType: (*CoordinationServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetAssignment",
+			Handler:    _Coordination_GetAssignment_Handler,
+		},
+		{
+			MethodName: "Drain",
+			Handler:    _Coordination_Drain_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "coordination.proto",
+}
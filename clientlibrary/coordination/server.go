@@ -0,0 +1,270 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package coordination serves the shard assignment and the health of a worker over gRPC, so that sidecars and
+// supervisors in other languages can query the shards held by the process and request a drain before stopping
+// it. Unlike the MultiLangDaemon of the Java KCL the records are not passed to the other process.
+//
+// The service is defined by coordination.proto, the stubs are generated by protoc-gen-go and protoc-gen-go-grpc.
+// The health of the worker is served by the standard grpc.health.v1.Health service.
+package coordination
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative coordination.proto
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/worker"
+)
+
+const (
+	// ServiceName is the name of the coordination service, it is also the service of the health checks
+	ServiceName = "kcl.coordination.v1.Coordination"
+
+	// healthUpdateInterval is the interval the serving status of the health service is updated from the status of
+	// the worker, so that watchers of the health are notified once the worker has started or stopped
+	healthUpdateInterval = time.Second
+)
+
+type (
+	// Authorizer authorizes a call to the coordination service, e.g. by a bearer token of the metadata of the call.
+	// It returns an error if the call is not authorized.
+	Authorizer func(ctx context.Context) error
+
+	// Server serves the coordination service of a worker
+	Server struct {
+		UnimplementedCoordinationServer
+
+		worker     *worker.Worker
+		authorizer Authorizer
+		health     *health.Server
+
+		watchOnce sync.Once
+		done      chan struct{}
+
+		mux    sync.Mutex
+		server *grpc.Server
+		closed bool
+	}
+
+	// healthServer updates the serving status from the status of the worker before it is checked
+	healthServer struct {
+		*health.Server
+		s *Server
+	}
+)
+
+// NewServer returns a server of the coordination service of the worker
+func NewServer(w *worker.Worker) *Server {
+	return &Server{
+		worker: w,
+		health: health.NewServer(),
+		done:   make(chan struct{}),
+	}
+}
+
+// WithAuthorizer authorizes the calls of the coordination service by the authorizer, the calls which are not
+// authorized fail with PermissionDenied. The health checks are not authorized, e.g. for the gRPC probes of
+// Kubernetes.
+func (s *Server) WithAuthorizer(authorizer Authorizer) *Server {
+	s.authorizer = authorizer
+	return s
+}
+
+// Register registers the coordination service and the health service on the gRPC server, e.g. on the server of
+// the application. The serving status of the health service is updated until Close is called.
+func (s *Server) Register(server grpc.ServiceRegistrar) {
+	RegisterCoordinationServer(server, s)
+	healthpb.RegisterHealthServer(server, &healthServer{Server: s.health, s: s})
+	s.watchOnce.Do(func() {
+		s.updateHealth()
+		go s.watchHealth()
+	})
+}
+
+// Serve serves the services on the listener by a gRPC server of its own until Close is called, it returns nil
+// then. The options are passed to the gRPC server, e.g. its TLS credentials.
+func (s *Server) Serve(listener net.Listener, opts ...grpc.ServerOption) error {
+	s.mux.Lock()
+	if s.closed {
+		s.mux.Unlock()
+		return nil
+	}
+	if s.server == nil {
+		s.server = grpc.NewServer(opts...)
+		s.Register(s.server)
+	}
+	server := s.server
+	s.mux.Unlock()
+
+	if err := server.Serve(listener); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+		return err
+	}
+	return nil
+}
+
+// Close stops the gRPC server of Serve, closing its listeners and connections, and marks the health of the
+// service as not serving
+func (s *Server) Close() error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+	s.health.Shutdown()
+	if s.server != nil {
+		s.server.Stop()
+	}
+	return nil
+}
+
+// GetAssignment returns the shards consumed by the worker
+func (s *Server) GetAssignment(ctx context.Context, _ *GetAssignmentRequest) (*GetAssignmentResponse, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+
+	workerStatus := s.worker.Status()
+	response := &GetAssignmentResponse{WorkerId: workerStatus.WorkerID, Running: workerStatus.Running}
+	for _, shard := range workerStatus.Shards {
+		assignment := &ShardAssignment{
+			ShardId:            shard.ShardID,
+			StreamName:         shard.StreamName,
+			State:              shard.State.String(),
+			Checkpoint:         shard.Checkpoint,
+			MillisBehindLatest: shard.MillisBehindLatest,
+		}
+		if !shard.LeaseTimeout.IsZero() {
+			assignment.LeaseTimeoutUnixMillis = shard.LeaseTimeout.UnixMilli()
+		}
+		response.Shards = append(response.Shards, assignment)
+	}
+	return response, nil
+}
+
+// Drain releases the leases of the requested shards concurrently, all shards consumed by the worker are released
+// without shard IDs in the request. The call fails with DeadlineExceeded or Canceled once its context is done, the
+// shards are still released then.
+func (s *Server) Drain(ctx context.Context, request *DrainRequest) (*DrainResponse, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+
+	workerStatus := s.worker.Status()
+	if !workerStatus.Running {
+		return nil, status.Error(codes.Unavailable, worker.ErrWorkerNotRunning.Error())
+	}
+	shardIDs := request.GetShardIds()
+	all := len(shardIDs) == 0
+	if all {
+		for _, shard := range workerStatus.Shards {
+			shardIDs = append(shardIDs, shard.ShardID)
+		}
+	}
+
+	errs := make([]error, len(shardIDs))
+	var wg sync.WaitGroup
+	for i := range shardIDs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = s.worker.ReleaseShard(shardIDs[i])
+		}(i)
+	}
+	released := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(released)
+	}()
+	select {
+	case <-released:
+	case <-ctx.Done():
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+
+	response := &DrainResponse{}
+	for i, err := range errs {
+		switch {
+		case err == nil:
+			response.ReleasedShardIds = append(response.ReleasedShardIds, shardIDs[i])
+		case errors.Is(err, worker.ErrShardNotConsumed) && all:
+			// the consumer has been shut down since the status has been taken
+		case errors.Is(err, worker.ErrShardNotConsumed):
+			return nil, status.Errorf(codes.NotFound, "shard %s: %v", shardIDs[i], err)
+		case errors.Is(err, worker.ErrWorkerNotRunning):
+			return nil, status.Error(codes.Unavailable, err.Error())
+		default:
+			return nil, status.Errorf(codes.Internal, "failed to release shard %s: %v", shardIDs[i], err)
+		}
+	}
+	return response, nil
+}
+
+func (s *Server) authorize(ctx context.Context) error {
+	if s.authorizer == nil {
+		return nil
+	}
+	if err := s.authorizer(ctx); err != nil {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	return nil
+}
+
+// updateHealth sets the serving status of the server and of the coordination service, the worker is serving
+// between Start and Shutdown
+func (s *Server) updateHealth() {
+	servingStatus := healthpb.HealthCheckResponse_NOT_SERVING
+	if s.worker.Status().Running {
+		servingStatus = healthpb.HealthCheckResponse_SERVING
+	}
+	s.health.SetServingStatus("", servingStatus)
+	s.health.SetServingStatus(ServiceName, servingStatus)
+}
+
+func (s *Server) watchHealth() {
+	ticker := time.NewTicker(healthUpdateInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.updateHealth()
+		}
+	}
+}
+
+// Check returns the serving status of the worker at the time of the call
+func (h *healthServer) Check(ctx context.Context, request *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	h.s.updateHealth()
+	return h.Server.Check(ctx, request)
+}
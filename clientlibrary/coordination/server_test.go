@@ -0,0 +1,257 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package coordination
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/worker"
+)
+
+// newKinesisServer serves two shards without records
+func newKinesisServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var output interface{}
+		switch r.Header.Get("X-Amz-Target") {
+		case "Kinesis_20131202.ListShards":
+			output = map[string]interface{}{"Shards": []map[string]interface{}{
+				{"ShardId": "shardId-0", "SequenceNumberRange": map[string]string{"StartingSequenceNumber": "0"}},
+				{"ShardId": "shardId-1", "SequenceNumberRange": map[string]string{"StartingSequenceNumber": "0"}},
+			}}
+		case "Kinesis_20131202.GetShardIterator":
+			output = map[string]interface{}{"ShardIterator": "iterator"}
+		case "Kinesis_20131202.GetRecords":
+			output = map[string]interface{}{"Records": []interface{}{}, "NextShardIterator": "iterator", "MillisBehindLatest": 0}
+		default:
+			t.Errorf("unexpected request %s", r.Header.Get("X-Amz-Target"))
+		}
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		assert.Nil(t, json.NewEncoder(w).Encode(output))
+	}))
+}
+
+type nopProcessor struct{}
+
+func (nopProcessor) Initialize(*kcl.InitializationInput) {}
+
+func (nopProcessor) ProcessRecords(*kcl.ProcessRecordsInput) error { return nil }
+
+func (nopProcessor) Shutdown(*kcl.ShutdownInput) {}
+
+type nopProcessorFactory struct{}
+
+func (nopProcessorFactory) CreateProcessor() kcl.IRecordProcessor { return nopProcessor{} }
+
+// newTestServer serves the worker and returns a connection to the server, the calls are authorized by the
+// bearer token of the context returned
+func newTestServer(t *testing.T, w *worker.Worker) (*grpc.ClientConn, context.Context) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	server := NewServer(w).WithAuthorizer(func(ctx context.Context) error {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if values := md.Get("authorization"); len(values) != 1 || values[0] != "Bearer token" {
+			return errors.New("invalid token")
+		}
+		return nil
+	})
+	go func() {
+		assert.Nil(t, server.Serve(listener))
+	}()
+	t.Cleanup(func() { assert.Nil(t, server.Close()) })
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.Nil(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn, metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer token")
+}
+
+func TestServer(t *testing.T) {
+	kinesisServer := newKinesisServer(t)
+	defer kinesisServer.Close()
+
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+		WithIdleTimeBetweenReadsInMillis(10).
+		WithShardSyncIntervalMillis(10)
+	w := worker.NewWorker(nopProcessorFactory{}, kclConfig).
+		WithKinesis(kinesis.New(kinesis.Options{
+			Region:           "us-west-2",
+			Credentials:      credentials.NewStaticCredentialsProvider("id", "secret", ""),
+			EndpointResolver: kinesis.EndpointResolverFromURL(kinesisServer.URL),
+		})).
+		WithCheckpointer(chk.NewMemoryCheckpoint(kclConfig))
+	conn, ctx := newTestServer(t, w)
+	client := NewCoordinationClient(conn)
+	healthClient := healthpb.NewHealthClient(conn)
+
+	health, err := healthClient.Check(ctx, &healthpb.HealthCheckRequest{Service: ServiceName})
+	assert.Nil(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, health.Status)
+	_, err = client.Drain(ctx, &DrainRequest{})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Equal(t, worker.ErrWorkerNotRunning.Error(), status.Convert(err).Message())
+
+	assert.Nil(t, w.Start())
+	defer w.Shutdown()
+
+	health, err = healthClient.Check(ctx, &healthpb.HealthCheckRequest{Service: ServiceName})
+	assert.Nil(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, health.Status)
+
+	var assignment *GetAssignmentResponse
+	assert.Eventually(t, func() bool {
+		assignment, err = client.GetAssignment(ctx, &GetAssignmentRequest{})
+		return err == nil && len(assignment.Shards) == 2 && assignment.Shards[1].State == "PROCESSING"
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "worker", assignment.WorkerId)
+	assert.True(t, assignment.Running)
+	assert.Equal(t, "shardId-0", assignment.Shards[0].ShardId)
+	assert.NotZero(t, assignment.Shards[0].LeaseTimeoutUnixMillis)
+
+	_, err = client.Drain(ctx, &DrainRequest{ShardIds: []string{"shardId-2"}})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	released, err := client.Drain(ctx, &DrainRequest{ShardIds: []string{"shardId-1"}})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"shardId-1"}, released.ReleasedShardIds)
+
+	released, err = client.Drain(ctx, &DrainRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"shardId-0"}, released.ReleasedShardIds)
+
+	assignment, err = client.GetAssignment(ctx, &GetAssignmentRequest{})
+	assert.Nil(t, err)
+	assert.Empty(t, assignment.Shards)
+}
+
+func TestServerErrors(t *testing.T) {
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker")
+	w := worker.NewWorker(nopProcessorFactory{}, kclConfig)
+	conn, ctx := newTestServer(t, w)
+	client := NewCoordinationClient(conn)
+	healthClient := healthpb.NewHealthClient(conn)
+
+	// the calls are authorized by the token of the request
+	_, err := client.GetAssignment(context.Background(), &GetAssignmentRequest{})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	assert.Equal(t, "invalid token", status.Convert(err).Message())
+
+	err = conn.Invoke(ctx, "/"+ServiceName+"/Unknown", &GetAssignmentRequest{}, &GetAssignmentResponse{})
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+
+	_, err = healthClient.Check(ctx, &healthpb.HealthCheckRequest{Service: "other.Service"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	// the health of the server is checked without service name and without token too
+	health, err := healthClient.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, health.Status)
+}
+
+func TestServerClose(t *testing.T) {
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker")
+	server := NewServer(worker.NewWorker(nopProcessorFactory{}, kclConfig))
+	assert.Nil(t, server.Close())
+
+	// Serve returns immediately once the server has been closed
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+	assert.Nil(t, server.Serve(listener))
+	assert.Nil(t, server.Close())
+}
+
+// blockingProcessor blocks the shutdown of its record processor until unblock is closed
+type blockingProcessor struct {
+	nopProcessor
+	unblock chan struct{}
+}
+
+func (p blockingProcessor) Shutdown(*kcl.ShutdownInput) { <-p.unblock }
+
+type blockingProcessorFactory struct {
+	unblock chan struct{}
+}
+
+func (f blockingProcessorFactory) CreateProcessor() kcl.IRecordProcessor {
+	return blockingProcessor{unblock: f.unblock}
+}
+
+func TestServerDrainDeadline(t *testing.T) {
+	kinesisServer := newKinesisServer(t)
+	defer kinesisServer.Close()
+
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+		WithIdleTimeBetweenReadsInMillis(10).
+		WithShardSyncIntervalMillis(10)
+	unblock := make(chan struct{})
+	w := worker.NewWorker(blockingProcessorFactory{unblock}, kclConfig).
+		WithKinesis(kinesis.New(kinesis.Options{
+			Region:           "us-west-2",
+			Credentials:      credentials.NewStaticCredentialsProvider("id", "secret", ""),
+			EndpointResolver: kinesis.EndpointResolverFromURL(kinesisServer.URL),
+		})).
+		WithCheckpointer(chk.NewMemoryCheckpoint(kclConfig))
+	server := NewServer(w)
+	defer server.Close()
+
+	assert.Nil(t, w.Start())
+	defer w.Shutdown()
+	defer close(unblock)
+	assert.Eventually(t, func() bool {
+		assignment, err := server.GetAssignment(context.Background(), &GetAssignmentRequest{})
+		return err == nil && len(assignment.Shards) == 2 && assignment.Shards[0].State == "PROCESSING"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the record processor does not return from its shutdown, the drain returns at the deadline of the call
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	errs := make(chan error, 1)
+	go func() {
+		_, err := server.Drain(ctx, &DrainRequest{ShardIds: []string{"shardId-0"}})
+		errs <- err
+	}()
+	select {
+	case err := <-errs:
+		assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	case <-time.After(5 * time.Second):
+		t.Fatal("the drain did not return at the deadline")
+	}
+}
@@ -0,0 +1,57 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package deadletter
+// Records which cannot be processed by the record processor are published to a dead-letter queue, so that the
+// checkpoint can advance instead of retrying the records forever.
+package deadletter
+
+import (
+	"context"
+
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+)
+
+type (
+	// Input contains the records which could not be processed
+	Input struct {
+		// ShardID is the shard of the records
+		ShardID string
+
+		// Records are the user records which could not be processed
+		Records []kcl.UserRecord
+
+		// Err is the last error returned by the record processor
+		Err error
+	}
+
+	// Publisher publishes the records which could not be processed to a dead-letter queue. If the records cannot be
+	// published, the shard consumer fails as if there was no dead-letter queue.
+	Publisher interface {
+		Publish(ctx context.Context, input *Input) error
+	}
+
+	// PublisherFunc is an adapter to use a function as Publisher, e.g. a callback of the application
+	PublisherFunc func(ctx context.Context, input *Input) error
+)
+
+// Publish calls f(ctx, input).
+func (f PublisherFunc) Publish(ctx context.Context, input *Input) error {
+	return f(ctx, input)
+}
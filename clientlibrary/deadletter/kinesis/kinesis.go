@@ -0,0 +1,122 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package kinesis implements a dead-letter queue publishing the records to a Kinesis stream
+package kinesis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awskinesis "github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/deadletter"
+)
+
+const (
+	// maxRecordsPerRequest is the max number of records of a PutRecords request
+	maxRecordsPerRequest = 500
+
+	// maxPutAttempts is the max number of PutRecords requests of a chunk, the records which failed, e.g. because
+	// the dead-letter stream is throttled, are put again
+	maxPutAttempts = 3
+
+	// putRetryDelay is the delay before the failed records are put again, it is doubled with every retry
+	putRetryDelay = 100 * time.Millisecond
+)
+
+// PutRecordsAPI is the part of the Kinesis client used by the publisher
+type PutRecordsAPI interface {
+	PutRecords(ctx context.Context, params *awskinesis.PutRecordsInput, optFns ...func(*awskinesis.Options)) (*awskinesis.PutRecordsOutput, error)
+}
+
+// Publisher publishes the records with their partition key to the dead-letter stream
+type Publisher struct {
+	client     PutRecordsAPI
+	streamName string
+}
+
+// NewPublisher creates a publisher for the dead-letter stream with the given name.
+func NewPublisher(client PutRecordsAPI, streamName string) *Publisher {
+	return &Publisher{client: client, streamName: streamName}
+}
+
+func (p *Publisher) Publish(ctx context.Context, input *deadletter.Input) error {
+	for start := 0; start < len(input.Records); start += maxRecordsPerRequest {
+		end := start + maxRecordsPerRequest
+		if end > len(input.Records) {
+			end = len(input.Records)
+		}
+
+		entries := make([]types.PutRecordsRequestEntry, 0, end-start)
+		for _, r := range input.Records[start:end] {
+			entries = append(entries, types.PutRecordsRequestEntry{
+				Data:         r.Data,
+				PartitionKey: r.PartitionKey,
+			})
+		}
+
+		if err := p.put(ctx, entries); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// put puts the entries into the dead-letter stream, retrying the entries which failed
+func (p *Publisher) put(ctx context.Context, entries []types.PutRecordsRequestEntry) error {
+	delay := putRetryDelay
+	for attempt := 1; ; attempt++ {
+		out, err := p.client.PutRecords(ctx, &awskinesis.PutRecordsInput{
+			Records:    entries,
+			StreamName: aws.String(p.streamName),
+		})
+		if err != nil {
+			return err
+		}
+		if aws.ToInt32(out.FailedRecordCount) == 0 {
+			return nil
+		}
+
+		// the results are in the order of the entries, only the failed entries are put again
+		var failed []types.PutRecordsRequestEntry
+		var errorCode string
+		for i, r := range out.Records {
+			if r.ErrorCode != nil && i < len(entries) {
+				failed = append(failed, entries[i])
+				errorCode = aws.ToString(r.ErrorCode)
+			}
+		}
+		if len(failed) == 0 || attempt >= maxPutAttempts {
+			return fmt.Errorf("failed to publish %d records to dead-letter stream %s after %d attempts, error code: %q",
+				aws.ToInt32(out.FailedRecordCount), p.streamName, attempt, errorCode)
+		}
+		entries = failed
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
@@ -0,0 +1,127 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package kinesis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awskinesis "github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/deadletter"
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+)
+
+// mockPutRecordsClient fails the first failedRecordCount entries of each request
+type mockPutRecordsClient struct {
+	inputs            []*awskinesis.PutRecordsInput
+	failedRecordCount int32
+}
+
+func (m *mockPutRecordsClient) PutRecords(_ context.Context, params *awskinesis.PutRecordsInput, _ ...func(*awskinesis.Options)) (*awskinesis.PutRecordsOutput, error) {
+	m.inputs = append(m.inputs, params)
+	out := &awskinesis.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}
+	for i := range params.Records {
+		if int32(i) < m.failedRecordCount {
+			out.Records = append(out.Records, types.PutRecordsResultEntry{ErrorCode: aws.String("ProvisionedThroughputExceededException")})
+			*out.FailedRecordCount++
+		} else {
+			out.Records = append(out.Records, types.PutRecordsResultEntry{SequenceNumber: aws.String(fmt.Sprint(i))})
+		}
+	}
+	return out, nil
+}
+
+func newInput(numRecords int) *deadletter.Input {
+	input := &deadletter.Input{ShardID: "shardId-0001", Err: errors.New("failed")}
+	for i := 0; i < numRecords; i++ {
+		input.Records = append(input.Records, kcl.UserRecord{Record: types.Record{
+			SequenceNumber: aws.String(fmt.Sprintf("%d", i)),
+			PartitionKey:   aws.String("pk"),
+			Data:           []byte("data"),
+		}})
+	}
+	return input
+}
+
+func TestPublish(t *testing.T) {
+	client := &mockPutRecordsClient{}
+	publisher := NewPublisher(client, "dlq")
+
+	assert.Nil(t, publisher.Publish(context.TODO(), newInput(501)))
+	assert.Equal(t, 2, len(client.inputs))
+	assert.Equal(t, 500, len(client.inputs[0].Records))
+	assert.Equal(t, 1, len(client.inputs[1].Records))
+	assert.Equal(t, "dlq", aws.ToString(client.inputs[0].StreamName))
+	assert.Equal(t, "pk", aws.ToString(client.inputs[1].Records[0].PartitionKey))
+	assert.Equal(t, []byte("data"), client.inputs[1].Records[0].Data)
+
+	// only the failed records are put again
+	client = &mockPutRecordsClient{failedRecordCount: 2}
+	publisher = NewPublisher(client, "dlq")
+	input := newInput(3)
+	input.Records[1].Data = []byte("second")
+	err := publisher.Publish(context.TODO(), input)
+	assert.ErrorContains(t, err, `failed to publish 2 records to dead-letter stream dlq after 3 attempts, error code: "ProvisionedThroughputExceededException"`)
+	assert.Equal(t, 3, len(client.inputs))
+	assert.Equal(t, 3, len(client.inputs[0].Records))
+	assert.Equal(t, 2, len(client.inputs[1].Records))
+	assert.Equal(t, []byte("second"), client.inputs[1].Records[1].Data)
+
+	client.failedRecordCount = 0
+	client.inputs = nil
+	assert.Nil(t, publisher.Publish(context.TODO(), newInput(1)))
+	assert.Equal(t, 1, len(client.inputs))
+}
+
+func TestPublishRetriesFailedRecords(t *testing.T) {
+	// the throttled records succeed on the retry
+	client := &flakyPutRecordsClient{}
+	input := newInput(3)
+	input.Records[2].Data = []byte("third")
+	assert.Nil(t, NewPublisher(client, "dlq").Publish(context.TODO(), input))
+	assert.Equal(t, 2, len(client.inputs))
+	assert.Equal(t, 1, len(client.inputs[1].Records))
+	assert.Equal(t, []byte("third"), client.inputs[1].Records[0].Data)
+}
+
+// flakyPutRecordsClient fails the last entry of the first request
+type flakyPutRecordsClient struct {
+	inputs []*awskinesis.PutRecordsInput
+}
+
+func (m *flakyPutRecordsClient) PutRecords(_ context.Context, params *awskinesis.PutRecordsInput, _ ...func(*awskinesis.Options)) (*awskinesis.PutRecordsOutput, error) {
+	m.inputs = append(m.inputs, params)
+	out := &awskinesis.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}
+	for i := range params.Records {
+		if len(m.inputs) == 1 && i == len(params.Records)-1 {
+			out.Records = append(out.Records, types.PutRecordsResultEntry{ErrorCode: aws.String("InternalFailure")})
+			*out.FailedRecordCount++
+		} else {
+			out.Records = append(out.Records, types.PutRecordsResultEntry{SequenceNumber: aws.String(fmt.Sprint(i))})
+		}
+	}
+	return out, nil
+}
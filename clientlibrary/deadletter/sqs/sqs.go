@@ -0,0 +1,77 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package sqs implements a dead-letter queue sending the records to an SQS queue
+package sqs
+
+import (
+	"context"
+	"encoding/base64"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awssqs "github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/deadletter"
+)
+
+// SendMessageAPI is the part of the SQS client used by the publisher
+type SendMessageAPI interface {
+	SendMessage(ctx context.Context, params *awssqs.SendMessageInput, optFns ...func(*awssqs.Options)) (*awssqs.SendMessageOutput, error)
+}
+
+// Publisher sends a message per record to the dead-letter queue. The body of the message is the base64 encoded
+// data of the record, the origin of the record and the error are added as message attributes.
+type Publisher struct {
+	client   SendMessageAPI
+	queueURL string
+}
+
+// NewPublisher creates a publisher for the dead-letter queue with the given URL.
+func NewPublisher(client SendMessageAPI, queueURL string) *Publisher {
+	return &Publisher{client: client, queueURL: queueURL}
+}
+
+func (p *Publisher) Publish(ctx context.Context, input *deadletter.Input) error {
+	for _, r := range input.Records {
+		attributes := map[string]types.MessageAttributeValue{
+			"ShardId":           stringAttribute(input.ShardID),
+			"SequenceNumber":    stringAttribute(aws.ToString(r.SequenceNumber)),
+			"SubSequenceNumber": {DataType: aws.String("Number"), StringValue: aws.String(strconv.FormatInt(r.SubSequenceNumber, 10))},
+			"PartitionKey":      stringAttribute(aws.ToString(r.PartitionKey)),
+		}
+		if input.Err != nil {
+			attributes["Error"] = stringAttribute(input.Err.Error())
+		}
+
+		if _, err := p.client.SendMessage(ctx, &awssqs.SendMessageInput{
+			MessageBody:       aws.String(base64.StdEncoding.EncodeToString(r.Data)),
+			QueueUrl:          aws.String(p.queueURL),
+			MessageAttributes: attributes,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func stringAttribute(value string) types.MessageAttributeValue {
+	return types.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(value)}
+}
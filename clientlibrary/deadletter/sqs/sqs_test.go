@@ -0,0 +1,68 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package sqs
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	awssqs "github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/deadletter"
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+)
+
+type mockSendMessageClient struct {
+	inputs []*awssqs.SendMessageInput
+}
+
+func (m *mockSendMessageClient) SendMessage(_ context.Context, params *awssqs.SendMessageInput, _ ...func(*awssqs.Options)) (*awssqs.SendMessageOutput, error) {
+	m.inputs = append(m.inputs, params)
+	return &awssqs.SendMessageOutput{}, nil
+}
+
+func TestPublish(t *testing.T) {
+	client := &mockSendMessageClient{}
+	publisher := NewPublisher(client, "https://sqs.us-west-2.amazonaws.com/123456789012/dlq")
+
+	input := &deadletter.Input{
+		ShardID: "shardId-0001",
+		Records: []kcl.UserRecord{
+			{Record: types.Record{SequenceNumber: aws.String("100"), PartitionKey: aws.String("pk"), Data: []byte("a")}, SubSequenceNumber: 2},
+			{Record: types.Record{SequenceNumber: aws.String("101"), PartitionKey: aws.String("pk"), Data: []byte("b")}},
+		},
+		Err: errors.New("failed"),
+	}
+	assert.Nil(t, publisher.Publish(context.TODO(), input))
+	assert.Equal(t, 2, len(client.inputs))
+
+	message := client.inputs[0]
+	assert.Equal(t, "https://sqs.us-west-2.amazonaws.com/123456789012/dlq", aws.ToString(message.QueueUrl))
+	assert.Equal(t, "YQ==", aws.ToString(message.MessageBody))
+	assert.Equal(t, "shardId-0001", aws.ToString(message.MessageAttributes["ShardId"].StringValue))
+	assert.Equal(t, "100", aws.ToString(message.MessageAttributes["SequenceNumber"].StringValue))
+	assert.Equal(t, "2", aws.ToString(message.MessageAttributes["SubSequenceNumber"].StringValue))
+	assert.Equal(t, "pk", aws.ToString(message.MessageAttributes["PartitionKey"].StringValue))
+	assert.Equal(t, "failed", aws.ToString(message.MessageAttributes["Error"].StringValue))
+}
@@ -0,0 +1,93 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package decoder
+// Record decoders deserialize the data of each record before it is delivered to the record processor, so the
+// record processors receive the values written by the producers instead of their serialized form.
+package decoder
+
+import (
+	"context"
+	"encoding/json"
+
+	"google.golang.org/protobuf/proto"
+
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+)
+
+type (
+	// Decoder deserializes the data of a user record, the value is delivered to the record processor as
+	// UserRecord.Decoded. The records are decoded after they have been transformed by the RecordTransformer. If a
+	// record cannot be decoded, it is published to the dead-letter queue if there is one, otherwise the shard
+	// consumer fails. A RetryableError is retried instead.
+	Decoder interface {
+		Decode(ctx context.Context, record *kcl.UserRecord) (interface{}, error)
+	}
+
+	// Func is an adapter to use a function deserializing the data of a record as Decoder
+	Func func(ctx context.Context, data []byte) (interface{}, error)
+
+	// RetryableError is returned by a Decoder for a record which can be decoded once the cause of the error is
+	// gone, e.g. a throttled call of a schema registry. The record is decoded again after a back-off instead of
+	// being published to the dead-letter queue.
+	RetryableError struct {
+		Err error
+	}
+)
+
+// Retryable wraps the error of a record which can be decoded again in a RetryableError.
+func Retryable(err error) error {
+	return &RetryableError{Err: err}
+}
+
+func (e *RetryableError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RetryableError) Unwrap() error {
+	return e.Err
+}
+
+// Decode returns f(ctx, record.Data).
+func (f Func) Decode(ctx context.Context, record *kcl.UserRecord) (interface{}, error) {
+	return f(ctx, record.Data)
+}
+
+// JSON returns a decoder unmarshalling the JSON of each record into the value returned by newValue, which must be
+// a pointer, e.g. func() interface{} { return &Order{} }.
+func JSON(newValue func() interface{}) Decoder {
+	return Func(func(_ context.Context, data []byte) (interface{}, error) {
+		v := newValue()
+		if err := json.Unmarshal(data, v); err != nil {
+			return nil, err
+		}
+		return v, nil
+	})
+}
+
+// Protobuf returns a decoder unmarshalling each record into the protocol buffer message returned by newMessage.
+func Protobuf(newMessage func() proto.Message) Decoder {
+	return Func(func(_ context.Context, data []byte) (interface{}, error) {
+		m := newMessage()
+		if err := proto.Unmarshal(data, m); err != nil {
+			return nil, err
+		}
+		return m, nil
+	})
+}
@@ -0,0 +1,61 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package decoder
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+)
+
+type order struct {
+	ID    string `json:"id"`
+	Total int    `json:"total"`
+}
+
+func TestJSON(t *testing.T) {
+	d := JSON(func() interface{} { return &order{} })
+
+	v, err := d.Decode(context.TODO(), &kcl.UserRecord{Record: types.Record{Data: []byte(`{"id":"a","total":3}`)}})
+	assert.Nil(t, err)
+	assert.Equal(t, &order{ID: "a", Total: 3}, v)
+
+	_, err = d.Decode(context.TODO(), &kcl.UserRecord{Record: types.Record{Data: []byte("not json")}})
+	assert.NotNil(t, err)
+}
+
+func TestProtobuf(t *testing.T) {
+	data, err := proto.Marshal(wrapperspb.String("payload"))
+	assert.Nil(t, err)
+
+	d := Protobuf(func() proto.Message { return &wrapperspb.StringValue{} })
+	v, err := d.Decode(context.TODO(), &kcl.UserRecord{Record: types.Record{Data: data}})
+	assert.Nil(t, err)
+	assert.Equal(t, "payload", v.(*wrapperspb.StringValue).GetValue())
+
+	_, err = d.Decode(context.TODO(), &kcl.UserRecord{Record: types.Record{Data: []byte{0xff}}})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,52 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+package glue
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/linkedin/goavro/v2"
+)
+
+// AvroDeserializer returns a deserializer decoding the Avro binary payloads into the native Go values of goavro:
+// records are decoded into maps by field name, and the values of unions other than null into maps by the name of
+// their type. The codecs of the schema versions are compiled once.
+func AvroDeserializer() Deserializer {
+	var codecs sync.Map
+	return func(schema *Schema, payload []byte) (interface{}, error) {
+		codec, ok := codecs.Load(schema.VersionID)
+		if !ok {
+			c, err := goavro.NewCodec(schema.Definition)
+			if err != nil {
+				return nil, fmt.Errorf("invalid Avro schema version %s: %w", schema.VersionID, err)
+			}
+			codec, _ = codecs.LoadOrStore(schema.VersionID, c)
+		}
+
+		v, rest, err := codec.(*goavro.Codec).NativeFromBinary(payload)
+		if err != nil {
+			return nil, err
+		}
+		if len(rest) > 0 {
+			return nil, fmt.Errorf("%d bytes left after the Avro record of schema version %s", len(rest), schema.VersionID)
+		}
+		return v, nil
+	}
+}
@@ -0,0 +1,258 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package glue implements a record decoder for the records serialized with the AWS Glue Schema Registry.
+//
+// A record is laid out as
+//
+//	header version (1 byte, 3) | compression (1 byte, 0 or 5 for zlib) | schema version id (16 bytes, UUID) |
+//	payload
+//
+// The schema versions are fetched from the registry once and cached, since they cannot be changed, e.g. from the AWS
+// Glue Schema Registry by NewRegistry. The payload is deserialized by the Deserializer of the data format of the
+// schema.
+package glue
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/decoder"
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+)
+
+const (
+	// HeaderVersion is the version of the header of the records written by the Glue Schema Registry serializers
+	HeaderVersion = 3
+
+	// CompressionNone and CompressionZlib are the compressions of the payload
+	CompressionNone = 0
+	CompressionZlib = 5
+
+	// DataFormatAvro, DataFormatJSON and DataFormatProtobuf are the data formats of the schemas
+	DataFormatAvro     = "AVRO"
+	DataFormatJSON     = "JSON"
+	DataFormatProtobuf = "PROTOBUF"
+
+	// MaxDecompressedSize is the max size of a decompressed payload, larger payloads are rejected, so that a
+	// record compressed with a high ratio cannot exhaust the memory of the worker
+	MaxDecompressedSize = 10 << 20
+
+	headerSize = 18
+)
+
+var (
+	// ErrInvalidHeader is returned for records which have not been serialized with the Glue Schema Registry
+	ErrInvalidHeader = errors.New("invalid Glue Schema Registry header")
+
+	// ErrSchemaVersionNotFound is returned by a SchemaRegistry for an unknown schema version. The other errors of
+	// the registry are retried, since the registry may be throttled or unavailable for a while.
+	ErrSchemaVersionNotFound = errors.New("schema version not found")
+
+	// ErrPayloadTooLarge is returned for a compressed payload larger than MaxDecompressedSize
+	ErrPayloadTooLarge = errors.New("decompressed payload too large")
+)
+
+type (
+	// Schema is a version of a schema of the registry
+	Schema struct {
+		VersionID  string
+		DataFormat string
+		Definition string
+	}
+
+	// SchemaRegistry returns the schema versions by id, e.g. by GetSchemaVersion of the Glue client
+	SchemaRegistry interface {
+		GetSchemaVersion(ctx context.Context, versionID string) (*Schema, error)
+	}
+
+	// SchemaRegistryFunc is an adapter to use a function as SchemaRegistry
+	SchemaRegistryFunc func(ctx context.Context, versionID string) (*Schema, error)
+
+	// Deserializer deserializes the payload of a record written with the schema
+	Deserializer func(schema *Schema, payload []byte) (interface{}, error)
+
+	// Decoder is a record decoder deserializing the records written by the Glue Schema Registry serializers. JSON
+	// and Avro records are decoded by default, the deserializers of Protobuf types are set by WithDeserializer.
+	Decoder struct {
+		registry      SchemaRegistry
+		deserializers map[string]Deserializer
+
+		mux     sync.Mutex
+		schemas map[string]*Schema
+	}
+)
+
+func (f SchemaRegistryFunc) GetSchemaVersion(ctx context.Context, versionID string) (*Schema, error) {
+	return f(ctx, versionID)
+}
+
+// NewDecoder creates a decoder fetching the schemas from the registry.
+func NewDecoder(registry SchemaRegistry) *Decoder {
+	return &Decoder{
+		registry: registry,
+		deserializers: map[string]Deserializer{
+			DataFormatAvro: AvroDeserializer(),
+			DataFormatJSON: JSONDeserializer,
+		},
+		schemas: make(map[string]*Schema),
+	}
+}
+
+// WithDeserializer sets the deserializer of the records of the data format, e.g. of the generated Protobuf types.
+func (d *Decoder) WithDeserializer(dataFormat string, deserializer Deserializer) *Decoder {
+	d.deserializers[dataFormat] = deserializer
+	return d
+}
+
+func (d *Decoder) Decode(ctx context.Context, record *kcl.UserRecord) (interface{}, error) {
+	versionID, payload, err := parseRecord(record.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	schema, err := d.schema(ctx, versionID)
+	if err != nil {
+		return nil, err
+	}
+
+	deserializer, ok := d.deserializers[schema.DataFormat]
+	if !ok {
+		return nil, fmt.Errorf("no deserializer for the data format %s of schema version %s", schema.DataFormat, versionID)
+	}
+	return deserializer(schema, payload)
+}
+
+// schema returns the schema version, fetching it from the registry unless it is cached
+func (d *Decoder) schema(ctx context.Context, versionID string) (*Schema, error) {
+	d.mux.Lock()
+	schema, ok := d.schemas[versionID]
+	d.mux.Unlock()
+	if ok {
+		return schema, nil
+	}
+
+	schema, err := d.registry.GetSchemaVersion(ctx, versionID)
+	if err != nil {
+		err = fmt.Errorf("failed to get schema version %s: %w", versionID, err)
+		if errors.Is(err, ErrSchemaVersionNotFound) {
+			return nil, err
+		}
+		// the record is decoded again once the registry is available, instead of being dropped
+		return nil, decoder.Retryable(err)
+	}
+
+	d.mux.Lock()
+	d.schemas[versionID] = schema
+	d.mux.Unlock()
+	return schema, nil
+}
+
+// parseRecord returns the schema version id and the decompressed payload of the record
+func parseRecord(data []byte) (string, []byte, error) {
+	if len(data) < headerSize || data[0] != HeaderVersion {
+		return "", nil, ErrInvalidHeader
+	}
+
+	versionID, err := uuid.FromBytes(data[2:headerSize])
+	if err != nil {
+		return "", nil, ErrInvalidHeader
+	}
+
+	payload := data[headerSize:]
+	switch data[1] {
+	case CompressionNone:
+		return versionID.String(), payload, nil
+	case CompressionZlib:
+		r, err := zlib.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return "", nil, err
+		}
+		defer r.Close()
+		payload, err = io.ReadAll(io.LimitReader(r, MaxDecompressedSize+1))
+		if err == nil && len(payload) > MaxDecompressedSize {
+			err = ErrPayloadTooLarge
+		}
+		return versionID.String(), payload, err
+	default:
+		return "", nil, fmt.Errorf("%w: unknown compression %d", ErrInvalidHeader, data[1])
+	}
+}
+
+// JSONDeserializer unmarshals the JSON payloads into maps, slices and the other values of encoding/json.
+func JSONDeserializer(_ *Schema, payload []byte) (interface{}, error) {
+	var v interface{}
+	if err := json.Unmarshal(payload, &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// ProtobufDeserializer returns a deserializer unmarshalling the payloads into the message returned by newMessage
+// for the schema, e.g. by the name of the message type in the schema definition.
+func ProtobufDeserializer(newMessage func(schema *Schema) (proto.Message, error)) Deserializer {
+	return func(schema *Schema, payload []byte) (interface{}, error) {
+		m, err := newMessage(schema)
+		if err != nil {
+			return nil, err
+		}
+		if err := proto.Unmarshal(payload, m); err != nil {
+			return nil, err
+		}
+		return m, nil
+	}
+}
+
+// Encode writes a record of the payload serialized with the schema version, e.g. for the producers of a stream
+// consumed with a Decoder.
+func Encode(versionID string, payload []byte, compression byte) ([]byte, error) {
+	id, err := uuid.Parse(versionID)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(HeaderVersion)
+	buf.WriteByte(compression)
+	buf.Write(id[:])
+	switch compression {
+	case CompressionNone:
+		buf.Write(payload)
+	case CompressionZlib:
+		w := zlib.NewWriter(&buf)
+		if _, err := w.Write(payload); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown compression %d", compression)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,197 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package glue
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsglue "github.com/aws/aws-sdk-go-v2/service/glue"
+	gluetypes "github.com/aws/aws-sdk-go-v2/service/glue/types"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/linkedin/goavro/v2"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/decoder"
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+)
+
+const (
+	jsonVersionID     = "5a1b4bd6-3a8e-4d55-8c1f-0a93f2c1d9e1"
+	protobufVersionID = "0c3e1f0a-7d2b-4b8e-9e4a-6f1d2c3b4a59"
+	avroVersionID     = "9f8e7d6c-5b4a-4392-8170-6f5e4d3c2b1a"
+	throttledID       = "1d2c3b4a-5f6e-4d7c-8b9a-0e1f2d3c4b5a"
+)
+
+// registry is a schema registry counting the calls per schema version
+type registry struct {
+	calls map[string]int
+}
+
+func (r *registry) GetSchemaVersion(_ context.Context, versionID string) (*Schema, error) {
+	r.calls[versionID]++
+	switch versionID {
+	case jsonVersionID:
+		return &Schema{VersionID: versionID, DataFormat: DataFormatJSON, Definition: `{"type":"object"}`}, nil
+	case protobufVersionID:
+		return &Schema{VersionID: versionID, DataFormat: DataFormatProtobuf, Definition: "message StringValue {}"}, nil
+	case avroVersionID:
+		return &Schema{VersionID: versionID, DataFormat: DataFormatAvro, Definition: `{"type":"string"}`}, nil
+	case throttledID:
+		return nil, errors.New("throttled")
+	}
+	return nil, ErrSchemaVersionNotFound
+}
+
+func record(t *testing.T, versionID string, payload []byte, compression byte) *kcl.UserRecord {
+	data, err := Encode(versionID, payload, compression)
+	assert.Nil(t, err)
+	return &kcl.UserRecord{Record: types.Record{Data: data}}
+}
+
+func TestDecoder(t *testing.T) {
+	r := &registry{calls: make(map[string]int)}
+	d := NewDecoder(r)
+
+	// the schema versions are cached
+	for _, compression := range []byte{CompressionNone, CompressionZlib} {
+		v, err := d.Decode(context.TODO(), record(t, jsonVersionID, []byte(`{"id":"a"}`), compression))
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]interface{}{"id": "a"}, v)
+	}
+	assert.Equal(t, 1, r.calls[jsonVersionID])
+
+	// without a deserializer for the data format
+	_, err := d.Decode(context.TODO(), record(t, protobufVersionID, []byte("a"), CompressionNone))
+	assert.ErrorContains(t, err, "no deserializer for the data format PROTOBUF")
+
+	d.WithDeserializer(DataFormatAvro, func(schema *Schema, payload []byte) (interface{}, error) {
+		return string(payload), nil
+	})
+	v, err := d.Decode(context.TODO(), record(t, avroVersionID, []byte("a"), CompressionNone))
+	assert.Nil(t, err)
+	assert.Equal(t, "a", v)
+
+	// an unknown schema version fails the record, the other errors of the registry are retried
+	var retryable *decoder.RetryableError
+	_, err = d.Decode(context.TODO(), record(t, "00000000-0000-0000-0000-000000000000", []byte("a"), CompressionNone))
+	assert.ErrorIs(t, err, ErrSchemaVersionNotFound)
+	assert.False(t, errors.As(err, &retryable))
+	_, err = d.Decode(context.TODO(), record(t, throttledID, []byte("a"), CompressionNone))
+	assert.ErrorContains(t, err, "throttled")
+	assert.True(t, errors.As(err, &retryable))
+	_, err = d.Decode(context.TODO(), record(t, throttledID, []byte("a"), CompressionNone))
+	assert.True(t, errors.As(err, &retryable))
+	assert.Equal(t, 2, r.calls[throttledID])
+
+	_, err = d.Decode(context.TODO(), &kcl.UserRecord{Record: types.Record{Data: []byte("not serialized with the registry")}})
+	assert.ErrorIs(t, err, ErrInvalidHeader)
+
+	data := record(t, jsonVersionID, []byte("{}"), CompressionNone).Data
+	data[1] = 1
+	_, err = d.Decode(context.TODO(), &kcl.UserRecord{Record: types.Record{Data: data}})
+	assert.ErrorIs(t, err, ErrInvalidHeader)
+
+	// the decompressed payload is limited
+	_, err = d.Decode(context.TODO(), record(t, jsonVersionID, make([]byte, MaxDecompressedSize+1), CompressionZlib))
+	assert.ErrorIs(t, err, ErrPayloadTooLarge)
+	_, err = d.Decode(context.TODO(), record(t, jsonVersionID, append(bytes.Repeat([]byte(" "), MaxDecompressedSize-2), '{', '}'), CompressionZlib))
+	assert.Nil(t, err)
+}
+
+func TestAvroDeserializer(t *testing.T) {
+	definition := `{"type":"record","name":"Order","fields":[{"name":"id","type":"string"},{"name":"amount","type":"long"}]}`
+	codec, err := goavro.NewCodec(definition)
+	assert.Nil(t, err)
+	payload, err := codec.BinaryFromNative(nil, map[string]interface{}{"id": "a", "amount": int64(3)})
+	assert.Nil(t, err)
+
+	registry := SchemaRegistryFunc(func(_ context.Context, versionID string) (*Schema, error) {
+		return &Schema{VersionID: versionID, DataFormat: DataFormatAvro, Definition: definition}, nil
+	})
+	d := NewDecoder(registry)
+	for _, compression := range []byte{CompressionNone, CompressionZlib} {
+		v, err := d.Decode(context.TODO(), record(t, avroVersionID, payload, compression))
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]interface{}{"id": "a", "amount": int64(3)}, v)
+	}
+
+	_, err = d.Decode(context.TODO(), record(t, avroVersionID, append(payload, 0), CompressionNone))
+	assert.ErrorContains(t, err, "1 bytes left")
+	_, err = AvroDeserializer()(&Schema{VersionID: avroVersionID, Definition: "{"}, payload)
+	assert.ErrorContains(t, err, "invalid Avro schema version")
+}
+
+// glueClient returns the schema versions of the Glue Schema Registry
+type glueClient struct {
+	outputs map[string]*awsglue.GetSchemaVersionOutput
+	err     error
+}
+
+func (c *glueClient) GetSchemaVersion(_ context.Context, params *awsglue.GetSchemaVersionInput, _ ...func(*awsglue.Options)) (*awsglue.GetSchemaVersionOutput, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	out, ok := c.outputs[aws.ToString(params.SchemaVersionId)]
+	if !ok {
+		return nil, &gluetypes.EntityNotFoundException{Message: aws.String("not found")}
+	}
+	return out, nil
+}
+
+func TestRegistry(t *testing.T) {
+	client := &glueClient{outputs: map[string]*awsglue.GetSchemaVersionOutput{
+		jsonVersionID: {
+			SchemaVersionId:  aws.String(jsonVersionID),
+			DataFormat:       gluetypes.DataFormatJson,
+			SchemaDefinition: aws.String(`{"type":"object"}`),
+		},
+	}}
+	r := NewRegistry(client)
+
+	schema, err := r.GetSchemaVersion(context.TODO(), jsonVersionID)
+	assert.Nil(t, err)
+	assert.Equal(t, &Schema{VersionID: jsonVersionID, DataFormat: DataFormatJSON, Definition: `{"type":"object"}`}, schema)
+
+	_, err = r.GetSchemaVersion(context.TODO(), avroVersionID)
+	assert.ErrorIs(t, err, ErrSchemaVersionNotFound)
+
+	client.err = errors.New("throttled")
+	_, err = r.GetSchemaVersion(context.TODO(), jsonVersionID)
+	assert.False(t, errors.Is(err, ErrSchemaVersionNotFound))
+}
+
+func TestProtobufDeserializer(t *testing.T) {
+	payload, err := proto.Marshal(wrapperspb.String("payload"))
+	assert.Nil(t, err)
+
+	d := NewDecoder(&registry{calls: make(map[string]int)}).
+		WithDeserializer(DataFormatProtobuf, ProtobufDeserializer(func(schema *Schema) (proto.Message, error) {
+			return &wrapperspb.StringValue{}, nil
+		}))
+	v, err := d.Decode(context.TODO(), record(t, protobufVersionID, payload, CompressionZlib))
+	assert.Nil(t, err)
+	assert.Equal(t, "payload", v.(*wrapperspb.StringValue).GetValue())
+}
@@ -0,0 +1,61 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+package glue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsglue "github.com/aws/aws-sdk-go-v2/service/glue"
+	"github.com/aws/aws-sdk-go-v2/service/glue/types"
+)
+
+// GetSchemaVersionAPI is the part of the Glue client used by the registry
+type GetSchemaVersionAPI interface {
+	GetSchemaVersion(ctx context.Context, params *awsglue.GetSchemaVersionInput, optFns ...func(*awsglue.Options)) (*awsglue.GetSchemaVersionOutput, error)
+}
+
+// Registry is the SchemaRegistry of the AWS Glue Schema Registry
+type Registry struct {
+	client GetSchemaVersionAPI
+}
+
+// NewRegistry creates a schema registry getting the schema versions by the Glue client.
+func NewRegistry(client GetSchemaVersionAPI) *Registry {
+	return &Registry{client: client}
+}
+
+func (r *Registry) GetSchemaVersion(ctx context.Context, versionID string) (*Schema, error) {
+	out, err := r.client.GetSchemaVersion(ctx, &awsglue.GetSchemaVersionInput{SchemaVersionId: aws.String(versionID)})
+	if err != nil {
+		var notFound *types.EntityNotFoundException
+		if errors.As(err, &notFound) {
+			return nil, fmt.Errorf("%w: %v", ErrSchemaVersionNotFound, err)
+		}
+		return nil, err
+	}
+
+	return &Schema{
+		VersionID:  versionID,
+		DataFormat: string(out.DataFormat),
+		Definition: aws.ToString(out.SchemaDefinition),
+	}, nil
+}
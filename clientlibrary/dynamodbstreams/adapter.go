@@ -0,0 +1,351 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package dynamodbstreams adapts DynamoDB Streams to the Kinesis API used by the worker, so that the changes of
+// DynamoDB tables are consumed like the records of a Kinesis stream. The stream of a table is identified by its ARN,
+// which is configured as the stream name or ARN of the worker.
+//
+// The change records are delivered as Kinesis records whose data is the JSON of the change record, their partition
+// key is the event ID. DynamoDB Streams closes the shards of a table every few hours, the child shard of a closed
+// shard is returned with the last records of the shard, so that the worker leases it right away without listing
+// the shards of the stream again. Enhanced fan-out and AT_TIMESTAMP iterators are not supported by DynamoDB Streams.
+package dynamodbstreams
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams"
+	streamtypes "github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/aws/smithy-go"
+)
+
+// maxRecordsPerRequest is the max number of records of a GetRecords call of DynamoDB Streams
+const maxRecordsPerRequest = 1000
+
+var (
+	// ErrEnhancedFanOutNotSupported is returned by the enhanced fan-out operations, DynamoDB Streams is polled
+	ErrEnhancedFanOutNotSupported = errors.New("enhanced fan-out is not supported by DynamoDB Streams")
+
+	// errStreamRequired is returned when neither the stream name nor the ARN of a request is set
+	errStreamRequired = errors.New("the ARN of the DynamoDB stream is required")
+)
+
+type (
+	// DynamoDBStreamsAPI is the subset of the DynamoDB Streams client used by the KinesisAdapter
+	DynamoDBStreamsAPI interface {
+		DescribeStream(ctx context.Context, params *dynamodbstreams.DescribeStreamInput, optFns ...func(*dynamodbstreams.Options)) (*dynamodbstreams.DescribeStreamOutput, error)
+		GetShardIterator(ctx context.Context, params *dynamodbstreams.GetShardIteratorInput, optFns ...func(*dynamodbstreams.Options)) (*dynamodbstreams.GetShardIteratorOutput, error)
+		GetRecords(ctx context.Context, params *dynamodbstreams.GetRecordsInput, optFns ...func(*dynamodbstreams.Options)) (*dynamodbstreams.GetRecordsOutput, error)
+	}
+
+	// KinesisAdapter implements the Kinesis API of the worker by the DynamoDB Streams API
+	KinesisAdapter struct {
+		cfg aws.Config
+		svc DynamoDBStreamsAPI
+
+		// now returns the current time, it is replaced by tests
+		now func() time.Time
+	}
+
+	// shardIterator is the iterator handed to the worker, it keeps the shard of the iterator of DynamoDB Streams
+	// to find its child shard once the shard has been read completely
+	shardIterator struct {
+		StreamArn string `json:"s"`
+		ShardId   string `json:"h"`
+		Iterator  string `json:"i"`
+	}
+
+	// listShardsToken is the next token of ListShards, it keeps the stream as the followup requests have none
+	listShardsToken struct {
+		StreamArn string `json:"s"`
+		ShardId   string `json:"h"`
+	}
+)
+
+// NewKinesisAdapter creates an adapter calling DynamoDB Streams in the region with the credentials of the config
+func NewKinesisAdapter(cfg aws.Config) *KinesisAdapter {
+	return &KinesisAdapter{cfg: cfg, svc: dynamodbstreams.NewFromConfig(cfg), now: time.Now}
+}
+
+// WithEndpoint overrides the endpoint of DynamoDB Streams, e.g. to use LocalStack
+func (a *KinesisAdapter) WithEndpoint(endpoint string) *KinesisAdapter {
+	a.svc = dynamodbstreams.NewFromConfig(a.cfg, func(o *dynamodbstreams.Options) {
+		o.EndpointResolver = dynamodbstreams.EndpointResolverFromURL(endpoint)
+	})
+	return a
+}
+
+// WithDynamoDBStreams sets the DynamoDB Streams client of the adapter, e.g. a mock for testing
+func (a *KinesisAdapter) WithDynamoDBStreams(svc DynamoDBStreamsAPI) *KinesisAdapter {
+	a.svc = svc
+	return a
+}
+
+// ListShards lists the shards of the stream, a page at a time. Shard filters are not supported by DynamoDB Streams,
+// all shards are listed.
+func (a *KinesisAdapter) ListShards(ctx context.Context, params *kinesis.ListShardsInput, _ ...func(*kinesis.Options)) (*kinesis.ListShardsOutput, error) {
+	input := &dynamodbstreams.DescribeStreamInput{StreamArn: streamArn(params.StreamARN, params.StreamName)}
+	if params.NextToken != nil {
+		token := &listShardsToken{}
+		if err := decodeToken(aws.ToString(params.NextToken), token); err != nil {
+			return nil, &types.InvalidArgumentException{Message: aws.String("invalid next token")}
+		}
+		input.StreamArn = aws.String(token.StreamArn)
+		input.ExclusiveStartShardId = aws.String(token.ShardId)
+	}
+	if input.StreamArn == nil {
+		return nil, errStreamRequired
+	}
+	if params.MaxResults != nil {
+		input.Limit = params.MaxResults
+	}
+
+	output, err := a.svc.DescribeStream(ctx, input)
+	if err != nil {
+		return nil, toKinesisError(err)
+	}
+
+	description := output.StreamDescription
+	result := &kinesis.ListShardsOutput{Shards: make([]types.Shard, 0, len(description.Shards))}
+	for _, s := range description.Shards {
+		result.Shards = append(result.Shards, toKinesisShard(s))
+	}
+	if description.LastEvaluatedShardId != nil {
+		result.NextToken = aws.String(encodeToken(&listShardsToken{
+			StreamArn: aws.ToString(input.StreamArn),
+			ShardId:   aws.ToString(description.LastEvaluatedShardId),
+		}))
+	}
+	return result, nil
+}
+
+// GetShardIterator gets the iterator at the starting position of a shard
+func (a *KinesisAdapter) GetShardIterator(ctx context.Context, params *kinesis.GetShardIteratorInput, _ ...func(*kinesis.Options)) (*kinesis.GetShardIteratorOutput, error) {
+	arn := streamArn(params.StreamARN, params.StreamName)
+	if arn == nil {
+		return nil, errStreamRequired
+	}
+	if params.ShardIteratorType == types.ShardIteratorTypeAtTimestamp {
+		return nil, &types.InvalidArgumentException{Message: aws.String("AT_TIMESTAMP iterators are not supported by DynamoDB Streams")}
+	}
+
+	output, err := a.svc.GetShardIterator(ctx, &dynamodbstreams.GetShardIteratorInput{
+		StreamArn:         arn,
+		ShardId:           params.ShardId,
+		ShardIteratorType: streamtypes.ShardIteratorType(params.ShardIteratorType),
+		SequenceNumber:    params.StartingSequenceNumber,
+	})
+	if err != nil {
+		return nil, toKinesisError(err)
+	}
+
+	return &kinesis.GetShardIteratorOutput{ShardIterator: aws.String(encodeToken(&shardIterator{
+		StreamArn: aws.ToString(arn),
+		ShardId:   aws.ToString(params.ShardId),
+		Iterator:  aws.ToString(output.ShardIterator),
+	}))}, nil
+}
+
+// GetRecords gets the change records of a shard from the position of a shard iterator. The lag is the time since
+// the creation of the last record, there is no lag without records. The child shards are returned with the last
+// records of a closed shard.
+func (a *KinesisAdapter) GetRecords(ctx context.Context, params *kinesis.GetRecordsInput, _ ...func(*kinesis.Options)) (*kinesis.GetRecordsOutput, error) {
+	iterator := &shardIterator{}
+	if err := decodeToken(aws.ToString(params.ShardIterator), iterator); err != nil {
+		return nil, &types.InvalidArgumentException{Message: aws.String("invalid shard iterator")}
+	}
+
+	limit := params.Limit
+	if limit != nil && *limit > maxRecordsPerRequest {
+		limit = aws.Int32(maxRecordsPerRequest)
+	}
+	output, err := a.svc.GetRecords(ctx, &dynamodbstreams.GetRecordsInput{ShardIterator: aws.String(iterator.Iterator), Limit: limit})
+	if err != nil {
+		return nil, toKinesisError(err)
+	}
+
+	result := &kinesis.GetRecordsOutput{
+		Records:            make([]types.Record, 0, len(output.Records)),
+		MillisBehindLatest: aws.Int64(0),
+	}
+	for _, r := range output.Records {
+		if r.Dynamodb == nil {
+			return nil, fmt.Errorf("invalid record %s of shard %s: the stream record is missing", aws.ToString(r.EventID), iterator.ShardId)
+		}
+		data, err := marshalChangeRecord(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid record %s of shard %s: %w", aws.ToString(r.EventID), iterator.ShardId, err)
+		}
+		created := aws.ToTime(r.Dynamodb.ApproximateCreationDateTime)
+		result.Records = append(result.Records, types.Record{
+			Data:                        data,
+			PartitionKey:                r.EventID,
+			SequenceNumber:              r.Dynamodb.SequenceNumber,
+			ApproximateArrivalTimestamp: aws.Time(created),
+		})
+		if lag := a.now().Sub(created).Milliseconds(); lag > 0 {
+			result.MillisBehindLatest = aws.Int64(lag)
+		}
+	}
+
+	if output.NextShardIterator != nil {
+		iterator.Iterator = aws.ToString(output.NextShardIterator)
+		result.NextShardIterator = aws.String(encodeToken(iterator))
+		return result, nil
+	}
+
+	// the worker lists the shards of the stream again if the child shard has not been created yet
+	result.ChildShards, err = a.childShards(ctx, iterator.StreamArn, iterator.ShardId)
+	if err != nil {
+		return nil, toKinesisError(err)
+	}
+	return result, nil
+}
+
+// childShards returns the shards of the stream whose parent is the shard
+func (a *KinesisAdapter) childShards(ctx context.Context, arn, shardID string) ([]types.ChildShard, error) {
+	var children []types.ChildShard
+	input := &dynamodbstreams.DescribeStreamInput{StreamArn: aws.String(arn)}
+	for {
+		output, err := a.svc.DescribeStream(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range output.StreamDescription.Shards {
+			if aws.ToString(s.ParentShardId) == shardID {
+				children = append(children, types.ChildShard{ShardId: s.ShardId, ParentShards: []string{shardID}})
+			}
+		}
+
+		if output.StreamDescription.LastEvaluatedShardId == nil {
+			return children, nil
+		}
+		input.ExclusiveStartShardId = output.StreamDescription.LastEvaluatedShardId
+	}
+}
+
+// DescribeStreamSummary describes the stream without its shards
+func (a *KinesisAdapter) DescribeStreamSummary(ctx context.Context, params *kinesis.DescribeStreamSummaryInput, _ ...func(*kinesis.Options)) (*kinesis.DescribeStreamSummaryOutput, error) {
+	arn := streamArn(params.StreamARN, params.StreamName)
+	if arn == nil {
+		return nil, errStreamRequired
+	}
+
+	output, err := a.svc.DescribeStream(ctx, &dynamodbstreams.DescribeStreamInput{StreamArn: arn, Limit: aws.Int32(1)})
+	if err != nil {
+		return nil, toKinesisError(err)
+	}
+
+	description := output.StreamDescription
+	return &kinesis.DescribeStreamSummaryOutput{StreamDescriptionSummary: &types.StreamDescriptionSummary{
+		StreamARN:    description.StreamArn,
+		StreamName:   description.StreamArn,
+		StreamStatus: toKinesisStreamStatus(description.StreamStatus),
+	}}, nil
+}
+
+// SubscribeToShard is not supported by DynamoDB Streams
+func (a *KinesisAdapter) SubscribeToShard(context.Context, *kinesis.SubscribeToShardInput, ...func(*kinesis.Options)) (*kinesis.SubscribeToShardOutput, error) {
+	return nil, ErrEnhancedFanOutNotSupported
+}
+
+// DescribeStreamConsumer is not supported by DynamoDB Streams
+func (a *KinesisAdapter) DescribeStreamConsumer(context.Context, *kinesis.DescribeStreamConsumerInput, ...func(*kinesis.Options)) (*kinesis.DescribeStreamConsumerOutput, error) {
+	return nil, ErrEnhancedFanOutNotSupported
+}
+
+// RegisterStreamConsumer is not supported by DynamoDB Streams
+func (a *KinesisAdapter) RegisterStreamConsumer(context.Context, *kinesis.RegisterStreamConsumerInput, ...func(*kinesis.Options)) (*kinesis.RegisterStreamConsumerOutput, error) {
+	return nil, ErrEnhancedFanOutNotSupported
+}
+
+// streamArn returns the ARN of the stream, the stream name of the worker is the ARN in single-stream mode
+func streamArn(arn, name *string) *string {
+	if aws.ToString(arn) != "" {
+		return arn
+	}
+	if aws.ToString(name) != "" {
+		return name
+	}
+	return nil
+}
+
+func toKinesisShard(s streamtypes.Shard) types.Shard {
+	result := types.Shard{ShardId: s.ShardId, ParentShardId: s.ParentShardId, SequenceNumberRange: &types.SequenceNumberRange{}}
+	if s.SequenceNumberRange != nil {
+		result.SequenceNumberRange.StartingSequenceNumber = s.SequenceNumberRange.StartingSequenceNumber
+		result.SequenceNumberRange.EndingSequenceNumber = s.SequenceNumberRange.EndingSequenceNumber
+	}
+	return result
+}
+
+// toKinesisStreamStatus maps the status of a DynamoDB stream to the status of a Kinesis stream
+func toKinesisStreamStatus(status streamtypes.StreamStatus) types.StreamStatus {
+	switch status {
+	case streamtypes.StreamStatusEnabling:
+		return types.StreamStatusCreating
+	case streamtypes.StreamStatusEnabled:
+		return types.StreamStatusActive
+	case streamtypes.StreamStatusDisabling, streamtypes.StreamStatusDisabled:
+		return types.StreamStatusDeleting
+	}
+	return types.StreamStatus(status)
+}
+
+// toKinesisError maps the errors of DynamoDB Streams handled by the worker to the errors of Kinesis, so that
+// throttled reads are retried and expired iterators are renewed. The other errors, e.g.
+// TrimmedDataAccessException, are returned as they are.
+func toKinesisError(err error) error {
+	var limitErr *streamtypes.LimitExceededException
+	var expiredErr *streamtypes.ExpiredIteratorException
+	var notFoundErr *streamtypes.ResourceNotFoundException
+	var apiErr smithy.APIError
+	switch {
+	case errors.As(err, &limitErr):
+		return &types.ProvisionedThroughputExceededException{Message: limitErr.Message}
+	case errors.As(err, &expiredErr):
+		return &types.ExpiredIteratorException{Message: expiredErr.Message}
+	case errors.As(err, &notFoundErr):
+		return &types.ResourceNotFoundException{Message: notFoundErr.Message}
+	case errors.As(err, &apiErr) && apiErr.ErrorCode() == "ThrottlingException":
+		return &types.ProvisionedThroughputExceededException{Message: aws.String(apiErr.ErrorMessage())}
+	}
+	return err
+}
+
+func encodeToken(token interface{}) string {
+	content, _ := json.Marshal(token)
+	return base64.RawURLEncoding.EncodeToString(content)
+}
+
+func decodeToken(encoded string, token interface{}) error {
+	content, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(content, token)
+}
@@ -0,0 +1,281 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package dynamodbstreams
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	streamtypes "github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/stretchr/testify/assert"
+
+	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/worker"
+)
+
+const testStreamArn = "arn:aws:dynamodb:us-west-2:123456789012:table/orders/stream/2026-10-14T00:00:00.000"
+
+var _ worker.KinesisAPI = (*KinesisAdapter)(nil)
+
+// newStreamsServer returns a DynamoDB Streams endpoint with the closed shard 1 holding the record 100 and its
+// child shard 2 holding the record 300. The shards are described a page at a time.
+func newStreamsServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.True(t, strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 "))
+		var input map[string]interface{}
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&input))
+
+		var output interface{}
+		switch r.Header.Get("X-Amz-Target") {
+		case "DynamoDBStreams_20120810.DescribeStream":
+			assert.Equal(t, testStreamArn, input["StreamArn"])
+			description := map[string]interface{}{"StreamArn": testStreamArn, "StreamStatus": "ENABLED"}
+			if input["ExclusiveStartShardId"] == nil {
+				description["Shards"] = []map[string]interface{}{{
+					"ShardId":             "shardId-1",
+					"SequenceNumberRange": map[string]string{"StartingSequenceNumber": "100", "EndingSequenceNumber": "200"},
+				}}
+				description["LastEvaluatedShardId"] = "shardId-1"
+			} else {
+				description["Shards"] = []map[string]interface{}{{
+					"ShardId":             "shardId-2",
+					"ParentShardId":       "shardId-1",
+					"SequenceNumberRange": map[string]string{"StartingSequenceNumber": "300"},
+				}}
+			}
+			output = map[string]interface{}{"StreamDescription": description}
+		case "DynamoDBStreams_20120810.GetShardIterator":
+			output = map[string]interface{}{"ShardIterator": "iterator-" + input["ShardId"].(string)}
+		case "DynamoDBStreams_20120810.GetRecords":
+			switch input["ShardIterator"] {
+			case "iterator-shardId-1":
+				output = map[string]interface{}{"Records": []json.RawMessage{newChangeRecord("1", "100")}}
+			case "iterator-shardId-2":
+				output = map[string]interface{}{
+					"Records":           []json.RawMessage{newChangeRecord("2", "300")},
+					"NextShardIterator": "iterator-shardId-2-next",
+				}
+			case "throttled":
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"__type": "com.amazonaws.dynamodb.v20120810#LimitExceededException", "message": "slow down"}`))
+				return
+			case "trimmed":
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"__type": "com.amazonaws.dynamodb.v20120810#TrimmedDataAccessException", "message": "trimmed"}`))
+				return
+			default:
+				output = map[string]interface{}{"Records": []json.RawMessage{}, "NextShardIterator": input["ShardIterator"]}
+			}
+		default:
+			t.Errorf("unexpected request %s", r.Header.Get("X-Amz-Target"))
+		}
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		assert.Nil(t, json.NewEncoder(w).Encode(output))
+	}))
+}
+
+// newChangeRecord returns a change record created at the timestamp 100 in seconds
+func newChangeRecord(eventID, sequenceNumber string) json.RawMessage {
+	return json.RawMessage(`{"eventID": "` + eventID + `", "eventName": "INSERT", "dynamodb": {"ApproximateCreationDateTime": 100, "SequenceNumber": "` + sequenceNumber + `", "Keys": {"id": {"S": "a"}}}}`)
+}
+
+func newTestAdapter(url string) *KinesisAdapter {
+	adapter := NewKinesisAdapter(aws.Config{
+		Region:      "us-west-2",
+		Credentials: credentials.NewStaticCredentialsProvider("id", "secret", ""),
+		// the throttled reads are returned right away to the worker
+		Retryer: func() aws.Retryer { return aws.NopRetryer{} },
+	}).WithEndpoint(url)
+	adapter.now = func() time.Time { return time.Unix(101, 0) }
+	return adapter
+}
+
+func TestKinesisAdapter(t *testing.T) {
+	server := newStreamsServer(t)
+	defer server.Close()
+	adapter := newTestAdapter(server.URL)
+	ctx := context.Background()
+
+	// the followup page is requested by the next token only
+	shards, err := adapter.ListShards(ctx, &kinesis.ListShardsInput{StreamName: aws.String(testStreamArn)})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(shards.Shards))
+	assert.Equal(t, "200", aws.ToString(shards.Shards[0].SequenceNumberRange.EndingSequenceNumber))
+	shards, err = adapter.ListShards(ctx, &kinesis.ListShardsInput{NextToken: shards.NextToken})
+	assert.Nil(t, err)
+	assert.Equal(t, "shardId-2", aws.ToString(shards.Shards[0].ShardId))
+	assert.Equal(t, "shardId-1", aws.ToString(shards.Shards[0].ParentShardId))
+	assert.Nil(t, shards.NextToken)
+
+	iterator, err := adapter.GetShardIterator(ctx, &kinesis.GetShardIteratorInput{
+		StreamName:        aws.String(testStreamArn),
+		ShardId:           aws.String("shardId-1"),
+		ShardIteratorType: types.ShardIteratorTypeTrimHorizon,
+	})
+	assert.Nil(t, err)
+
+	// the child shard is returned with the last records of the closed shard
+	records, err := adapter.GetRecords(ctx, &kinesis.GetRecordsInput{ShardIterator: iterator.ShardIterator, Limit: aws.Int32(10000)})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(records.Records))
+	assert.Equal(t, "100", aws.ToString(records.Records[0].SequenceNumber))
+	assert.Equal(t, "1", aws.ToString(records.Records[0].PartitionKey))
+	assert.True(t, time.Unix(100, 0).Equal(aws.ToTime(records.Records[0].ApproximateArrivalTimestamp)))
+	assert.JSONEq(t, string(newChangeRecord("1", "100")), string(records.Records[0].Data))
+	assert.Equal(t, int64(1000), aws.ToInt64(records.MillisBehindLatest))
+	assert.Nil(t, records.NextShardIterator)
+	assert.Equal(t, []types.ChildShard{{ShardId: aws.String("shardId-2"), ParentShards: []string{"shardId-1"}}}, records.ChildShards)
+
+	summary, err := adapter.DescribeStreamSummary(ctx, &kinesis.DescribeStreamSummaryInput{StreamName: aws.String(testStreamArn)})
+	assert.Nil(t, err)
+	assert.Equal(t, types.StreamStatusActive, summary.StreamDescriptionSummary.StreamStatus)
+
+	_, err = adapter.GetShardIterator(ctx, &kinesis.GetShardIteratorInput{
+		StreamName:        aws.String(testStreamArn),
+		ShardId:           aws.String("shardId-1"),
+		ShardIteratorType: types.ShardIteratorTypeAtTimestamp,
+	})
+	assert.IsType(t, &types.InvalidArgumentException{}, err)
+	_, err = adapter.SubscribeToShard(ctx, &kinesis.SubscribeToShardInput{})
+	assert.Equal(t, ErrEnhancedFanOutNotSupported, err)
+}
+
+func TestKinesisAdapterErrors(t *testing.T) {
+	server := newStreamsServer(t)
+	defer server.Close()
+	adapter := newTestAdapter(server.URL)
+
+	// throttled reads are retried by the worker like the reads of Kinesis
+	_, err := adapter.GetRecords(context.Background(), &kinesis.GetRecordsInput{
+		ShardIterator: aws.String(encodeToken(&shardIterator{StreamArn: testStreamArn, ShardId: "shardId-1", Iterator: "throttled"})),
+	})
+	assert.Equal(t, &types.ProvisionedThroughputExceededException{Message: aws.String("slow down")}, err)
+
+	_, err = adapter.GetRecords(context.Background(), &kinesis.GetRecordsInput{ShardIterator: aws.String("invalid")})
+	assert.IsType(t, &types.InvalidArgumentException{}, err)
+
+	// the errors without Kinesis counterpart are returned as the errors of DynamoDB Streams
+	_, err = adapter.GetRecords(context.Background(), &kinesis.GetRecordsInput{
+		ShardIterator: aws.String(encodeToken(&shardIterator{StreamArn: testStreamArn, ShardId: "shardId-1", Iterator: "trimmed"})),
+	})
+	var trimmedErr *streamtypes.TrimmedDataAccessException
+	assert.True(t, errors.As(err, &trimmedErr))
+	assert.Equal(t, "trimmed", aws.ToString(trimmedErr.Message))
+}
+
+func TestMarshalChangeRecord(t *testing.T) {
+	data, err := marshalChangeRecord(streamtypes.Record{
+		EventID:   aws.String("1"),
+		EventName: streamtypes.OperationTypeModify,
+		Dynamodb: &streamtypes.StreamRecord{
+			ApproximateCreationDateTime: aws.Time(time.Unix(100, 500000000)),
+			Keys:                        map[string]streamtypes.AttributeValue{"id": &streamtypes.AttributeValueMemberS{Value: "a"}},
+			NewImage: map[string]streamtypes.AttributeValue{
+				"id":    &streamtypes.AttributeValueMemberS{Value: "a"},
+				"count": &streamtypes.AttributeValueMemberN{Value: "2"},
+				"tags":  &streamtypes.AttributeValueMemberL{Value: []streamtypes.AttributeValue{&streamtypes.AttributeValueMemberBOOL{Value: true}}},
+				"blob":  &streamtypes.AttributeValueMemberM{Value: map[string]streamtypes.AttributeValue{"b": &streamtypes.AttributeValueMemberB{Value: []byte("x")}}},
+			},
+			SequenceNumber: aws.String("100"),
+			StreamViewType: streamtypes.StreamViewTypeNewImage,
+		},
+	})
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"eventID": "1", "eventName": "MODIFY", "dynamodb": {
+		"ApproximateCreationDateTime": 100.5,
+		"Keys": {"id": {"S": "a"}},
+		"NewImage": {"id": {"S": "a"}, "count": {"N": "2"}, "tags": {"L": [{"BOOL": true}]}, "blob": {"M": {"b": {"B": "eA=="}}}},
+		"SequenceNumber": "100",
+		"StreamViewType": "NEW_IMAGE"}}`, string(data))
+}
+
+// sequenceRecorder records the delivered sequence numbers and checkpoints the end of closed shards
+type sequenceRecorder struct {
+	mux     *sync.Mutex
+	records *[]string
+}
+
+func (r sequenceRecorder) Initialize(*kcl.InitializationInput) {}
+
+func (r sequenceRecorder) ProcessRecords(input *kcl.ProcessRecordsInput) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	for _, record := range input.Records {
+		*r.records = append(*r.records, aws.ToString(record.SequenceNumber))
+	}
+	return nil
+}
+
+func (r sequenceRecorder) Shutdown(input *kcl.ShutdownInput) {
+	if input.ShutdownReason == kcl.TERMINATE {
+		_ = input.Checkpointer.Checkpoint(nil)
+	}
+}
+
+func (r sequenceRecorder) CreateProcessor() kcl.IRecordProcessor {
+	return r
+}
+
+func TestWorkerConsumesDynamoDBStream(t *testing.T) {
+	server := newStreamsServer(t)
+	defer server.Close()
+
+	var mux sync.Mutex
+	var records []string
+	kclConfig := config.NewKinesisClientLibConfig("app", testStreamArn, "us-west-2", "worker").
+		WithInitialPositionInStream(config.TRIM_HORIZON).
+		WithIdleTimeBetweenReadsInMillis(10).
+		WithShardSyncIntervalMillis(1000)
+	checkpointer := chk.NewMemoryCheckpoint(kclConfig)
+	w := worker.NewWorker(sequenceRecorder{mux: &mux, records: &records}, kclConfig).
+		WithKinesis(newTestAdapter(server.URL)).
+		WithCheckpointer(checkpointer)
+
+	assert.Nil(t, w.Start())
+	defer w.Shutdown()
+
+	// the child shard is consumed once its parent has been completed
+	assert.Eventually(t, func() bool {
+		mux.Lock()
+		defer mux.Unlock()
+		return len(records) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	mux.Lock()
+	assert.Equal(t, []string{"100", "300"}, records)
+	mux.Unlock()
+
+	shard := &par.ShardStatus{ID: "shardId-1", Mux: &sync.RWMutex{}}
+	assert.Nil(t, checkpointer.FetchCheckpoint(shard))
+	assert.Equal(t, chk.ShardEnd, shard.GetCheckpoint())
+}
@@ -0,0 +1,151 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package dynamodbstreams
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	streamtypes "github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+)
+
+type (
+	// changeRecord is the JSON of a change record as it is returned by the DynamoDB Streams API
+	changeRecord struct {
+		EventID      *string       `json:"eventID,omitempty"`
+		EventName    string        `json:"eventName,omitempty"`
+		EventVersion *string       `json:"eventVersion,omitempty"`
+		EventSource  *string       `json:"eventSource,omitempty"`
+		AwsRegion    *string       `json:"awsRegion,omitempty"`
+		DynamoDB     *streamRecord `json:"dynamodb,omitempty"`
+		UserIdentity *identity     `json:"userIdentity,omitempty"`
+	}
+
+	streamRecord struct {
+		ApproximateCreationDateTime *float64                  `json:",omitempty"`
+		Keys                        map[string]attributeValue `json:",omitempty"`
+		NewImage                    map[string]attributeValue `json:",omitempty"`
+		OldImage                    map[string]attributeValue `json:",omitempty"`
+		SequenceNumber              *string                   `json:",omitempty"`
+		SizeBytes                   *int64                    `json:",omitempty"`
+		StreamViewType              string                    `json:",omitempty"`
+	}
+
+	identity struct {
+		PrincipalId *string `json:"principalId,omitempty"`
+		Type        *string `json:"type,omitempty"`
+	}
+
+	// attributeValue is an attribute in the JSON of DynamoDB, keyed by the data type of the attribute
+	attributeValue map[string]interface{}
+)
+
+// marshalChangeRecord returns the JSON of the change record, the data of the Kinesis record the worker delivers
+func marshalChangeRecord(r streamtypes.Record) (json.RawMessage, error) {
+	record := &changeRecord{
+		EventID:      r.EventID,
+		EventName:    string(r.EventName),
+		EventVersion: r.EventVersion,
+		EventSource:  r.EventSource,
+		AwsRegion:    r.AwsRegion,
+	}
+	if r.UserIdentity != nil {
+		record.UserIdentity = &identity{PrincipalId: r.UserIdentity.PrincipalId, Type: r.UserIdentity.Type}
+	}
+	if s := r.Dynamodb; s != nil {
+		record.DynamoDB = &streamRecord{
+			SequenceNumber: s.SequenceNumber,
+			SizeBytes:      s.SizeBytes,
+			StreamViewType: string(s.StreamViewType),
+		}
+		if s.ApproximateCreationDateTime != nil {
+			seconds := float64(s.ApproximateCreationDateTime.UnixNano()) / float64(time.Second)
+			record.DynamoDB.ApproximateCreationDateTime = &seconds
+		}
+		var err error
+		if record.DynamoDB.Keys, err = toAttributeMap(s.Keys); err != nil {
+			return nil, err
+		}
+		if record.DynamoDB.NewImage, err = toAttributeMap(s.NewImage); err != nil {
+			return nil, err
+		}
+		if record.DynamoDB.OldImage, err = toAttributeMap(s.OldImage); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(record)
+}
+
+func toAttributeMap(attributes map[string]streamtypes.AttributeValue) (map[string]attributeValue, error) {
+	if attributes == nil {
+		return nil, nil
+	}
+	result := make(map[string]attributeValue, len(attributes))
+	for name, value := range attributes {
+		v, err := toAttributeValue(value)
+		if err != nil {
+			return nil, err
+		}
+		result[name] = v
+	}
+	return result, nil
+}
+
+func toAttributeValue(value streamtypes.AttributeValue) (attributeValue, error) {
+	switch v := value.(type) {
+	case *streamtypes.AttributeValueMemberB:
+		return attributeValue{"B": v.Value}, nil
+	case *streamtypes.AttributeValueMemberBOOL:
+		return attributeValue{"BOOL": v.Value}, nil
+	case *streamtypes.AttributeValueMemberBS:
+		return attributeValue{"BS": v.Value}, nil
+	case *streamtypes.AttributeValueMemberL:
+		list := make([]attributeValue, 0, len(v.Value))
+		for _, item := range v.Value {
+			a, err := toAttributeValue(item)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, a)
+		}
+		return attributeValue{"L": list}, nil
+	case *streamtypes.AttributeValueMemberM:
+		m, err := toAttributeMap(v.Value)
+		if err != nil {
+			return nil, err
+		}
+		return attributeValue{"M": m}, nil
+	case *streamtypes.AttributeValueMemberN:
+		return attributeValue{"N": v.Value}, nil
+	case *streamtypes.AttributeValueMemberNS:
+		return attributeValue{"NS": v.Value}, nil
+	case *streamtypes.AttributeValueMemberNULL:
+		return attributeValue{"NULL": v.Value}, nil
+	case *streamtypes.AttributeValueMemberS:
+		return attributeValue{"S": v.Value}, nil
+	case *streamtypes.AttributeValueMemberSS:
+		return attributeValue{"SS": v.Value}, nil
+	case *streamtypes.UnknownUnionMember:
+		// the value of a data type unknown to the SDK is not decoded, only its type is kept
+		return attributeValue{v.Tag: nil}, nil
+	}
+	return nil, fmt.Errorf("unsupported attribute value %T", value)
+}
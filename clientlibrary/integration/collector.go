@@ -0,0 +1,200 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package integration
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+)
+
+type (
+	// Record is a record delivered to a record processor of the collector
+	Record struct {
+		ShardID        string
+		SequenceNumber string
+		PartitionKey   string
+		Data           []byte
+	}
+
+	// RecordCollector is a record processor factory collecting the records delivered to its record processors. The
+	// record processors checkpoint each batch and the end of closed shards, so that the child shards of split or
+	// merged shards are consumed.
+	RecordCollector struct {
+		mux       sync.Mutex
+		records   []Record
+		shutdowns map[string][]kcl.ShutdownReason
+		// changed is closed and replaced when records have been collected or a record processor has been shut down
+		changed chan struct{}
+	}
+
+	collectingProcessor struct {
+		collector *RecordCollector
+		shardID   string
+	}
+)
+
+// NewRecordCollector creates a collector without records.
+func NewRecordCollector() *RecordCollector {
+	return &RecordCollector{
+		shutdowns: make(map[string][]kcl.ShutdownReason),
+		changed:   make(chan struct{}),
+	}
+}
+
+func (c *RecordCollector) CreateProcessor() kcl.IRecordProcessor {
+	return &collectingProcessor{collector: c}
+}
+
+// Records returns the records collected so far in the order they have been delivered. Records delivered more than
+// once, e.g. after a failover, are included each time.
+func (c *RecordCollector) Records() []Record {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return append([]Record(nil), c.records...)
+}
+
+// Shutdowns returns the reasons the record processors of each shard have been shut down with
+func (c *RecordCollector) Shutdowns() map[string][]kcl.ShutdownReason {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	shutdowns := make(map[string][]kcl.ShutdownReason, len(c.shutdowns))
+	for shardID, reasons := range c.shutdowns {
+		shutdowns[shardID] = append([]kcl.ShutdownReason(nil), reasons...)
+	}
+	return shutdowns
+}
+
+// WaitFor waits until the condition on the collected records is true. It returns false if the context is done first.
+func (c *RecordCollector) WaitFor(ctx context.Context, condition func(records []Record) bool) bool {
+	for {
+		c.mux.Lock()
+		done := condition(c.records)
+		changed := c.changed
+		c.mux.Unlock()
+		if done {
+			return true
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-changed:
+		}
+	}
+}
+
+// AssertRecords waits until the data of all expected records has been collected and fails the test if it times out
+// or unexpected records have been collected. The records may be delivered in any order and more than once.
+func (c *RecordCollector) AssertRecords(t testing.TB, timeout time.Duration, expected ...[]byte) bool {
+	t.Helper()
+
+	want := make(map[string]bool, len(expected))
+	for _, data := range expected {
+		want[string(data)] = true
+	}
+	received := func(records []Record) map[string]bool {
+		got := make(map[string]bool, len(records))
+		for _, r := range records {
+			got[string(r.Data)] = true
+		}
+		return got
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	c.WaitFor(ctx, func(records []Record) bool {
+		got := received(records)
+		for data := range want {
+			if !got[data] {
+				return false
+			}
+		}
+		return true
+	})
+
+	var missing, unexpected []string
+	got := received(c.Records())
+	for data := range want {
+		if !got[data] {
+			missing = append(missing, data)
+		}
+	}
+	for data := range got {
+		if !want[data] {
+			unexpected = append(unexpected, data)
+		}
+	}
+	if len(missing) == 0 && len(unexpected) == 0 {
+		return true
+	}
+	sort.Strings(missing)
+	sort.Strings(unexpected)
+	t.Errorf("collected records differ after %s, missing: %q, unexpected: %q", timeout, missing, unexpected)
+	return false
+}
+
+// notifyLocked wakes up the waiting callers, the mutex has to be held
+func (c *RecordCollector) notifyLocked() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+func (p *collectingProcessor) Initialize(input *kcl.InitializationInput) {
+	p.shardID = input.ShardId
+}
+
+func (p *collectingProcessor) ProcessRecords(input *kcl.ProcessRecordsInput) error {
+	if len(input.Records) == 0 {
+		return nil
+	}
+
+	p.collector.mux.Lock()
+	for _, r := range input.Records {
+		p.collector.records = append(p.collector.records, Record{
+			ShardID:        p.shardID,
+			SequenceNumber: aws.ToString(r.SequenceNumber),
+			PartitionKey:   aws.ToString(r.PartitionKey),
+			Data:           r.Data,
+		})
+	}
+	p.collector.notifyLocked()
+	p.collector.mux.Unlock()
+
+	return input.Checkpointer.Checkpoint(input.Records[len(input.Records)-1].SequenceNumber)
+}
+
+func (p *collectingProcessor) Shutdown(input *kcl.ShutdownInput) {
+	// the end of a closed shard has to be checkpointed before its child shards are consumed
+	if input.ShutdownReason == kcl.TERMINATE {
+		_ = input.Checkpointer.Checkpoint(nil)
+	}
+
+	p.collector.mux.Lock()
+	p.collector.shutdowns[p.shardID] = append(p.collector.shutdowns[p.shardID], input.ShutdownReason)
+	p.collector.notifyLocked()
+	p.collector.mux.Unlock()
+}
@@ -0,0 +1,138 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package integration
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/stretchr/testify/assert"
+
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/utils"
+	wk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/worker"
+)
+
+func TestMiddleHashKey(t *testing.T) {
+	key, err := middleHashKey(&types.HashKeyRange{
+		StartingHashKey: aws.String("0"),
+		EndingHashKey:   aws.String("340282366920938463463374607431768211455"),
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "170141183460469231731687303715884105728", key)
+
+	key, err = middleHashKey(&types.HashKeyRange{StartingHashKey: aws.String("10"), EndingHashKey: aws.String("11")})
+	assert.Nil(t, err)
+	assert.Equal(t, "11", key)
+
+	_, err = middleHashKey(&types.HashKeyRange{StartingHashKey: aws.String("10"), EndingHashKey: aws.String("10")})
+	assert.NotNil(t, err)
+}
+
+// checkpointRecorder records the checkpoints of a record processor
+type checkpointRecorder struct {
+	kcl.IRecordProcessorCheckpointer
+	checkpoints []*string
+}
+
+func (c *checkpointRecorder) Checkpoint(sequenceNumber *string) error {
+	c.checkpoints = append(c.checkpoints, sequenceNumber)
+	return nil
+}
+
+// failureRecorder records the failures of an assertion instead of failing the test
+type failureRecorder struct {
+	testing.TB
+	errors []string
+}
+
+func (f *failureRecorder) Errorf(format string, args ...interface{}) {
+	f.errors = append(f.errors, fmt.Sprintf(format, args...))
+}
+
+func TestRecordCollector(t *testing.T) {
+	collector := NewRecordCollector()
+	processor := collector.CreateProcessor()
+	checkpointer := &checkpointRecorder{}
+
+	processor.Initialize(&kcl.InitializationInput{ShardId: "shardId-0"})
+	go func() {
+		_ = processor.ProcessRecords(&kcl.ProcessRecordsInput{
+			Records: []types.Record{
+				{SequenceNumber: aws.String("1"), PartitionKey: aws.String("a"), Data: []byte("one")},
+				{SequenceNumber: aws.String("2"), PartitionKey: aws.String("b"), Data: []byte("two")},
+			},
+			Checkpointer: checkpointer,
+		})
+		processor.Shutdown(&kcl.ShutdownInput{ShutdownReason: kcl.TERMINATE, Checkpointer: checkpointer})
+	}()
+
+	assert.True(t, collector.AssertRecords(t, time.Second, []byte("two"), []byte("one")))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.True(t, collector.WaitFor(ctx, func([]Record) bool { return len(collector.shutdowns) == 1 }))
+
+	assert.Equal(t, Record{ShardID: "shardId-0", SequenceNumber: "1", PartitionKey: "a", Data: []byte("one")}, collector.Records()[0])
+	assert.Equal(t, map[string][]kcl.ShutdownReason{"shardId-0": {kcl.TERMINATE}}, collector.Shutdowns())
+	// each batch and the end of the shard are checkpointed
+	assert.Equal(t, []*string{aws.String("2"), nil}, checkpointer.checkpoints)
+
+	// unexpected records fail the assertion
+	failures := &failureRecorder{TB: t}
+	assert.False(t, collector.AssertRecords(failures, time.Millisecond, []byte("one")))
+	assert.Equal(t, []string{`collected records differ after 1ms, missing: [], unexpected: ["two"]`}, failures.errors)
+}
+
+func TestSplitShardWithLocalStack(t *testing.T) {
+	env := Start(t)
+	ctx := context.Background()
+
+	streamName := "kcl-integration-" + utils.MustNewUUID()[:8]
+	assert.Nil(t, env.CreateStream(ctx, streamName, 1))
+	defer func() { assert.Nil(t, env.DeleteStream(ctx, streamName)) }()
+
+	collector := NewRecordCollector()
+	worker := wk.NewWorker(collector, env.Config(streamName, streamName, "worker"))
+	assert.Nil(t, worker.Start())
+	defer worker.Shutdown()
+
+	var expected [][]byte
+	publish := func(from, to int) {
+		var records [][]byte
+		for i := from; i < to; i++ {
+			records = append(records, []byte(fmt.Sprintf("record-%d", i)))
+		}
+		assert.Nil(t, env.Publish(ctx, streamName, records...))
+		expected = append(expected, records...)
+	}
+
+	publish(0, 10)
+	assert.True(t, collector.AssertRecords(t, time.Minute, expected...))
+
+	// the records of the child shards are consumed once the parent shard has been completed
+	assert.Nil(t, env.SplitShard(ctx, streamName, "shardId-000000000000"))
+	publish(10, 20)
+	assert.True(t, collector.AssertRecords(t, time.Minute, expected...))
+	assert.Contains(t, collector.Shutdowns()["shardId-000000000000"], kcl.TERMINATE)
+}
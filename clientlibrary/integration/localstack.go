@@ -0,0 +1,260 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package integration
+// Helpers to test applications consuming Kinesis streams with the KCL against LocalStack: the environment provides
+// Kinesis and DynamoDB clients and configurations for workers, streams can be created, resharded and fed with test
+// records, and the records delivered to the record processors are collected to be asserted on.
+package integration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+)
+
+const (
+	// EndpointEnv is the environment variable of the endpoint of a running LocalStack, e.g. a service container of
+	// the CI. LocalStack is started with docker if it is not set and EnableEnv is set.
+	EndpointEnv = "LOCALSTACK_ENDPOINT"
+
+	// EnableEnv is the environment variable which has to be set to 1 to start LocalStack with docker, so that the
+	// unit tests do not start containers. The tests are skipped unless it or EndpointEnv is set.
+	EnableEnv = "KCL_LOCALSTACK"
+
+	// ImageEnv is the environment variable of the docker image started if no endpoint is provided
+	ImageEnv = "LOCALSTACK_IMAGE"
+
+	// DefaultImage is the docker image of LocalStack started by default
+	DefaultImage = "localstack/localstack:1.4"
+
+	// DefaultRegion is the region of the streams and lease tables
+	DefaultRegion = "us-east-1"
+
+	// startTimeout is how long to wait for LocalStack to become ready
+	startTimeout = 2 * time.Minute
+)
+
+// Environment is a LocalStack endpoint serving Kinesis and DynamoDB
+type Environment struct {
+	Endpoint    string
+	Region      string
+	Credentials aws.CredentialsProvider
+
+	// containerID is the docker container started by the environment, it is empty for a provided endpoint
+	containerID string
+
+	kc *kinesis.Client
+	dc *dynamodb.Client
+}
+
+// Start returns the environment of the endpoint in EndpointEnv or starts LocalStack with docker if EnableEnv is set to
+// 1. The test is skipped if neither an endpoint is provided nor LocalStack is enabled and docker is available. A
+// started container is removed when the test ends.
+func Start(t testing.TB) *Environment {
+	t.Helper()
+
+	if endpoint := os.Getenv(EndpointEnv); endpoint != "" {
+		env := Connect(endpoint, DefaultRegion)
+		if err := env.waitReady(context.Background()); err != nil {
+			t.Fatalf("LocalStack at %s is not ready: %+v", endpoint, err)
+		}
+		return env
+	}
+
+	if os.Getenv(EnableEnv) != "1" {
+		t.Skipf("neither %s nor %s=1 is set", EndpointEnv, EnableEnv)
+	}
+	if _, err := exec.LookPath("docker"); err != nil {
+		t.Skipf("%s is not set and docker is not available", EndpointEnv)
+	}
+	env, err := StartContainer(context.Background())
+	if err != nil {
+		t.Fatalf("failed to start LocalStack: %+v", err)
+	}
+	t.Cleanup(func() {
+		if err := env.Stop(); err != nil {
+			t.Errorf("failed to stop LocalStack: %+v", err)
+		}
+	})
+	return env
+}
+
+// Connect returns the environment of a running LocalStack.
+func Connect(endpoint, region string) *Environment {
+	env := &Environment{
+		Endpoint: endpoint,
+		Region:   region,
+		// LocalStack accepts any credentials
+		Credentials: credentials.NewStaticCredentialsProvider("test", "test", ""),
+	}
+
+	awsConfig := aws.Config{
+		Region:      region,
+		Credentials: env.Credentials,
+		EndpointResolverWithOptions: aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
+			return aws.Endpoint{PartitionID: "aws", URL: endpoint, SigningRegion: region}, nil
+		}),
+	}
+	env.kc = kinesis.NewFromConfig(awsConfig)
+	env.dc = dynamodb.NewFromConfig(awsConfig)
+	return env
+}
+
+// StartContainer starts LocalStack with docker on a free port and waits until it is ready. The container has to be
+// removed with Stop.
+func StartContainer(ctx context.Context) (*Environment, error) {
+	image := os.Getenv(ImageEnv)
+	if image == "" {
+		image = DefaultImage
+	}
+	port, err := freePort()
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := docker(ctx, "run", "-d", "--rm", "-p", fmt.Sprintf("127.0.0.1:%d:4566", port),
+		"-e", "SERVICES=kinesis,dynamodb", image)
+	if err != nil {
+		return nil, err
+	}
+
+	env := Connect(fmt.Sprintf("http://127.0.0.1:%d", port), DefaultRegion)
+	env.containerID = strings.TrimSpace(out)
+	if err := env.waitReady(ctx); err != nil {
+		_ = env.Stop()
+		return nil, err
+	}
+	return env, nil
+}
+
+// Stop removes the container started by the environment, a provided endpoint is left running.
+func (env *Environment) Stop() error {
+	if env.containerID == "" {
+		return nil
+	}
+	_, err := docker(context.Background(), "rm", "-f", env.containerID)
+	env.containerID = ""
+	return err
+}
+
+// KinesisClient returns a Kinesis client of the environment
+func (env *Environment) KinesisClient() *kinesis.Client {
+	return env.kc
+}
+
+// DynamoDBClient returns a DynamoDB client of the environment
+func (env *Environment) DynamoDBClient() *dynamodb.Client {
+	return env.dc
+}
+
+// Config returns a configuration of a worker consuming the stream of the environment. The intervals are shortened,
+// so that leases are taken over and shards are synced within seconds.
+func (env *Environment) Config(applicationName, streamName, workerID string) *config.KinesisClientLibConfiguration {
+	return config.NewKinesisClientLibConfigWithCredentials(applicationName, streamName, env.Region, workerID,
+		env.Credentials, env.Credentials).
+		WithKinesisEndpoint(env.Endpoint).
+		WithDynamoDBEndpoint(env.Endpoint).
+		WithInitialPositionInStream(config.TRIM_HORIZON).
+		WithFailoverTimeMillis(5000).
+		WithShardSyncIntervalMillis(1000).
+		WithIdleTimeBetweenReadsInMillis(100)
+}
+
+// waitReady polls the health endpoint of LocalStack until Kinesis and DynamoDB are available
+func (env *Environment) waitReady(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, startTimeout)
+	defer cancel()
+
+	var lastErr error
+	for {
+		if lastErr = env.checkHealth(ctx); lastErr == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for LocalStack: %w", lastErr)
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
+func (env *Environment) checkHealth(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, env.Endpoint+"/_localstack/health", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("health check returned %s", resp.Status)
+	}
+
+	// the services are listed as available or running once they can serve requests
+	var health struct {
+		Services map[string]string `json:"services"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&health); err != nil {
+		return err
+	}
+	for _, service := range []string{"kinesis", "dynamodb"} {
+		if status := health.Services[service]; status != "available" && status != "running" {
+			return fmt.Errorf("%s is not available yet: %q", service, status)
+		}
+	}
+	return nil
+}
+
+func docker(ctx context.Context, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("docker %s failed: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
@@ -0,0 +1,203 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package integration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+)
+
+// streamWaitTimeout is how long to wait for a stream to become active after it has been created or resharded
+const streamWaitTimeout = time.Minute
+
+// CreateStream creates a stream with the given number of shards and waits until it is active.
+func (env *Environment) CreateStream(ctx context.Context, streamName string, shards int) error {
+	if _, err := env.kc.CreateStream(ctx, &kinesis.CreateStreamInput{
+		StreamName: aws.String(streamName),
+		ShardCount: aws.Int32(int32(shards)),
+	}); err != nil {
+		return err
+	}
+	return env.waitForStream(ctx, streamName)
+}
+
+// DeleteStream deletes a stream and waits until it is gone.
+func (env *Environment) DeleteStream(ctx context.Context, streamName string) error {
+	if _, err := env.kc.DeleteStream(ctx, &kinesis.DeleteStreamInput{
+		StreamName:              aws.String(streamName),
+		EnforceConsumerDeletion: aws.Bool(true),
+	}); err != nil {
+		return err
+	}
+	return kinesis.NewStreamNotExistsWaiter(env.kc).Wait(ctx, &kinesis.DescribeStreamInput{
+		StreamName: aws.String(streamName),
+	}, streamWaitTimeout)
+}
+
+// ListShards returns all shards of the stream, including the closed ones.
+func (env *Environment) ListShards(ctx context.Context, streamName string) ([]types.Shard, error) {
+	var shards []types.Shard
+	input := &kinesis.ListShardsInput{StreamName: aws.String(streamName)}
+	for {
+		out, err := env.kc.ListShards(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		shards = append(shards, out.Shards...)
+		if out.NextToken == nil {
+			return shards, nil
+		}
+		input = &kinesis.ListShardsInput{NextToken: out.NextToken}
+	}
+}
+
+// SplitShard splits the shard in two halves of its hash key range and waits until the stream is active again. The
+// parent shard is closed, its records are still readable until they expire.
+func (env *Environment) SplitShard(ctx context.Context, streamName, shardID string) error {
+	shard, err := env.findShard(ctx, streamName, shardID)
+	if err != nil {
+		return err
+	}
+	startingHashKey, err := middleHashKey(shard.HashKeyRange)
+	if err != nil {
+		return err
+	}
+
+	if _, err := env.kc.SplitShard(ctx, &kinesis.SplitShardInput{
+		StreamName:         aws.String(streamName),
+		ShardToSplit:       aws.String(shardID),
+		NewStartingHashKey: aws.String(startingHashKey),
+	}); err != nil {
+		return err
+	}
+	return env.waitForStream(ctx, streamName)
+}
+
+// MergeShards merges two adjacent shards and waits until the stream is active again.
+func (env *Environment) MergeShards(ctx context.Context, streamName, shardID, adjacentShardID string) error {
+	if _, err := env.kc.MergeShards(ctx, &kinesis.MergeShardsInput{
+		StreamName:           aws.String(streamName),
+		ShardToMerge:         aws.String(shardID),
+		AdjacentShardToMerge: aws.String(adjacentShardID),
+	}); err != nil {
+		return err
+	}
+	return env.waitForStream(ctx, streamName)
+}
+
+// Publish puts the records into the stream with consecutive partition keys, so that they are spread over the shards.
+func (env *Environment) Publish(ctx context.Context, streamName string, records ...[]byte) error {
+	// PutRecords accepts up to 500 records per request
+	for start := 0; start < len(records); start += 500 {
+		end := start + 500
+		if end > len(records) {
+			end = len(records)
+		}
+
+		entries := make([]types.PutRecordsRequestEntry, 0, end-start)
+		for i := start; i < end; i++ {
+			entries = append(entries, types.PutRecordsRequestEntry{
+				Data:         records[i],
+				PartitionKey: aws.String(strconv.Itoa(i)),
+			})
+		}
+		out, err := env.kc.PutRecords(ctx, &kinesis.PutRecordsInput{
+			StreamName: aws.String(streamName),
+			Records:    entries,
+		})
+		if err != nil {
+			return err
+		}
+		if failed := aws.ToInt32(out.FailedRecordCount); failed > 0 {
+			return fmt.Errorf("failed to publish %d records", failed)
+		}
+	}
+	return nil
+}
+
+// PublishToShard puts the records into the shard by using its starting hash key as explicit hash key.
+func (env *Environment) PublishToShard(ctx context.Context, streamName, shardID string, records ...[]byte) error {
+	shard, err := env.findShard(ctx, streamName, shardID)
+	if err != nil {
+		return err
+	}
+
+	for _, data := range records {
+		if _, err := env.kc.PutRecord(ctx, &kinesis.PutRecordInput{
+			StreamName:      aws.String(streamName),
+			Data:            data,
+			PartitionKey:    aws.String(shardID),
+			ExplicitHashKey: shard.HashKeyRange.StartingHashKey,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (env *Environment) findShard(ctx context.Context, streamName, shardID string) (*types.Shard, error) {
+	shards, err := env.ListShards(ctx, streamName)
+	if err != nil {
+		return nil, err
+	}
+	for i := range shards {
+		if aws.ToString(shards[i].ShardId) == shardID {
+			return &shards[i], nil
+		}
+	}
+	return nil, fmt.Errorf("shard %s not found in stream %s", shardID, streamName)
+}
+
+func (env *Environment) waitForStream(ctx context.Context, streamName string) error {
+	return kinesis.NewStreamExistsWaiter(env.kc, func(o *kinesis.StreamExistsWaiterOptions) {
+		o.MinDelay = 100 * time.Millisecond
+		o.MaxDelay = time.Second
+	}).Wait(ctx, &kinesis.DescribeStreamInput{StreamName: aws.String(streamName)}, streamWaitTimeout)
+}
+
+// middleHashKey returns the starting hash key of the upper half of the hash key range
+func middleHashKey(hashKeyRange *types.HashKeyRange) (string, error) {
+	if hashKeyRange == nil {
+		return "", errors.New("the shard has no hash key range")
+	}
+	start, ok := new(big.Int).SetString(aws.ToString(hashKeyRange.StartingHashKey), 10)
+	if !ok {
+		return "", fmt.Errorf("invalid starting hash key %s", aws.ToString(hashKeyRange.StartingHashKey))
+	}
+	end, ok := new(big.Int).SetString(aws.ToString(hashKeyRange.EndingHashKey), 10)
+	if !ok {
+		return "", fmt.Errorf("invalid ending hash key %s", aws.ToString(hashKeyRange.EndingHashKey))
+	}
+	if start.Cmp(end) >= 0 {
+		return "", errors.New("the hash key range of the shard cannot be split")
+	}
+
+	middle := new(big.Int).Add(start, end)
+	middle.Rsh(middle, 1)
+	return middle.Add(middle, big.NewInt(1)).String(), nil
+}
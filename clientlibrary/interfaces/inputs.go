@@ -37,6 +37,7 @@
 package interfaces
 
 import (
+	"context"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -45,10 +46,9 @@ import (
 
 const (
 	/*
-	 * REQUESTED Indicates that the entire application is being shutdown, and if desired the record processor will be given a
-	 * final chance to checkpoint. This state will not trigger a direct call to
-	 * {@link com.amazonaws.services.kinesis.clientlibrary.interfaces.v2.IRecordProcessor#shutdown(ShutdownInput)}, but
-	 * instead depend on a different interface for backward compatibility.
+	 * REQUESTED Indicates that the shard is drained gracefully while the worker still holds its lease: the worker is
+	 * shut down by Worker.Shutdown, or the shard is released, rebalanced, handed off to a worker which claimed it,
+	 * paused or rewound. The record processor is given a final chance to checkpoint before the lease is released.
 	 */
 	REQUESTED ShutdownReason = iota + 1
 
@@ -62,8 +62,9 @@ const (
 
 	/*
 	 * Processing will be moved to a different record processor (fail over, load balancing use cases).
-	 * Applications SHOULD NOT checkpoint their progress (as another record processor may have already started
-	 * processing data).
+	 * Indicates that the lease of the shard has been lost, because it could not be renewed or a checkpoint has been
+	 * rejected, or that processing of the shard failed. Applications SHOULD NOT checkpoint their progress (as another
+	 * record processor may have already started processing data).
 	 */
 	ZOMBIE
 )
@@ -84,11 +85,40 @@ type (
 		// The shardId that the record processor is being initialized for.
 		ShardId string
 
+		// The stream of the shard in multi-stream mode, it is empty otherwise.
+		StreamName string
+
+		// The ARN of the stream of the shard in multi-stream mode if the stream is configured by its ARN, it is
+		// empty otherwise.
+		StreamARN string
+
 		// The last extended sequence number that was successfully checkpointed by the previous record processor.
 		ExtendedSequenceNumber *ExtendedSequenceNumber
+
+		// The pending extended sequence number that was prepared but not committed by the previous record processor.
+		// It is nil if there is no pending checkpoint.
+		PendingCheckpointSequenceNumber *ExtendedSequenceNumber
+
+		// The inclusive range of the hashed partition keys of the records of the shard as decimal 128-bit integers,
+		// e.g. to route the records by tenant without describing the stream.
+		StartingHashKey string
+		EndingHashKey   string
+
+		// The range of the sequence numbers of the shard as listed by ListShards. The ending sequence number is empty
+		// while the shard is open, both are empty for a child shard leased before the shards have been listed again.
+		StartingSequenceNumber string
+		EndingSequenceNumber   string
+
+		// The metadata stored by the previous record processor with the last checkpoint by CheckpointWithMetadata,
+		// e.g. partial aggregation state. It is nil if the last checkpoint has been stored without metadata.
+		CheckpointMetadata []byte
 	}
 
 	ProcessRecordsInput struct {
+		// The context of the span tracing the processing of this batch of records. Spans started by the
+		// RecordProcessor from this context are correlated with the spans of the KCL.
+		Context context.Context
+
 		// The time that this batch of records was received by the KCL.
 		CacheEntryTime *time.Time
 
@@ -98,11 +128,85 @@ type (
 		// The records received from Kinesis. These records may have been de-aggregated if they were published by the KPL.
 		Records []types.Record
 
+		// The records received from Kinesis with their sub-sequence numbers, in the same order as Records.
+		UserRecords []UserRecord
+
 		// A checkpointer that the RecordProcessor can use to checkpoint its progress.
 		Checkpointer IRecordProcessorCheckpointer
 
 		// How far behind this batch of records was when received from Kinesis.
 		MillisBehindLatest int64
+
+		// The number of times this batch has been delivered to a record processor including this delivery, starting
+		// at 1. A batch delivered again after a failure, also after the shard consumer restarted from the last
+		// checkpoint, has a higher count.
+		AttemptCount int
+
+		// How the records of this batch have been retrieved from Kinesis, e.g. for tuning the cost and throughput of
+		// the consumer. It is nil unless the batch has been read by the KCL.
+		BatchMetadata *BatchMetadata
+
+		// the metadata of the batch provided by the KCL, see V2
+		v2 *ProcessRecordsInputV2
+	}
+
+	// BatchMetadata describes the calls to Kinesis the records of a batch have been read with
+	BatchMetadata struct {
+		// The number of GetRecords calls the records have been read with, or of the events received from
+		// SubscribeToShard with enhanced fan-out.
+		Calls int
+
+		// The number of records read from Kinesis, before de-aggregation.
+		RecordCount int
+
+		// The number of bytes of the data of the records read from Kinesis.
+		BytesRead int64
+
+		// The time taken by the GetRecords calls, it is 0 with enhanced fan-out.
+		RetrievalLatency time.Duration
+
+		// The number of GetRecords calls throttled by Kinesis while the records were read.
+		Throttles int
+	}
+
+	// ProcessRecordsInputV2 is the ProcessRecordsInput enriched with the context of the batch of records.
+	ProcessRecordsInputV2 struct {
+		ProcessRecordsInput
+
+		// The shardId the records have been read from.
+		ShardId string
+
+		// The stream of the shard in multi-stream mode, it is empty otherwise.
+		StreamName string
+
+		// The ARN of the stream of the shard in multi-stream mode if the stream is configured by its ARN, it is
+		// empty otherwise.
+		StreamARN string
+
+		// The extended sequence number checkpointed for the shard when the batch was read. It is nil if nothing has
+		// been checkpointed yet.
+		LastCheckpoint *ExtendedSequenceNumber
+
+		// CatchUp is true if the batch was read while the consumer was behind the tip of the shard, i.e. more records
+		// are available without waiting.
+		CatchUp bool
+	}
+
+	// UserRecord is a record delivered to the RecordProcessor. The user records de-aggregated from a KPL aggregated
+	// record share its sequence number and are numbered by their position within the aggregated record.
+	UserRecord struct {
+		types.Record
+
+		// SubSequenceNumber is the position of the user record within the aggregated record, the records which are
+		// not aggregated have sub-sequence number 0.
+		SubSequenceNumber int64
+
+		// Aggregated is true if the record has been de-aggregated from a KPL aggregated record.
+		Aggregated bool
+
+		// Decoded is the value deserialized from the data of the record by the RecordDecoder of the configuration,
+		// it is nil without a decoder.
+		Decoded interface{}
 	}
 
 	ShutdownInput struct {
@@ -111,9 +215,60 @@ type (
 
 		// Checkpointer is used to record the current progress.
 		Checkpointer IRecordProcessorCheckpointer
+
+		// ChildShards are the shards which succeed the closed shard, e.g. to migrate the state kept per partition
+		// key to the consumers of the child shards. They are only set with TERMINATE, they are empty if Kinesis has
+		// not returned the child shards.
+		ChildShards []ChildShard
+	}
+
+	// ChildShard is a shard created by splitting or merging the closed shard
+	ChildShard struct {
+		// The shardId of the child shard.
+		ShardId string
+
+		// The parent shards of the child shard, two shards are merged into a child shard.
+		ParentShards []string
+
+		// The inclusive range of the hashed partition keys of the child shard as decimal 128-bit integers. They are
+		// empty if the range is unknown, e.g. for the shards of DynamoDB Streams.
+		StartingHashKey string
+		EndingHashKey   string
 	}
 )
 
+// Input returns the ProcessRecordsInput delivered to the record processor, which keeps the metadata of the batch
+// available through V2.
+func (input *ProcessRecordsInputV2) Input() *ProcessRecordsInput {
+	v1 := input.ProcessRecordsInput
+	v1.v2 = input
+	return &v1
+}
+
+// V2 returns the input enriched with the metadata of the batch. The fields of the ProcessRecordsInput are taken
+// from this input, the metadata is empty unless the input has been created by the KCL.
+func (input *ProcessRecordsInput) V2() *ProcessRecordsInputV2 {
+	v2 := &ProcessRecordsInputV2{}
+	if input.v2 != nil {
+		*v2 = *input.v2
+	}
+	v2.ProcessRecordsInput = *input
+	return v2
+}
+
+// AllUserRecords returns the UserRecords of the input. The Records are returned as user records which are not
+// aggregated if the input has been created without user records, e.g. by a test or a middleware.
+func (input *ProcessRecordsInput) AllUserRecords() []UserRecord {
+	if len(input.UserRecords) > 0 || len(input.Records) == 0 {
+		return input.UserRecords
+	}
+	records := make([]UserRecord, 0, len(input.Records))
+	for _, record := range input.Records {
+		records = append(records, UserRecord{Record: record})
+	}
+	return records
+}
+
 var shutdownReasonMap = map[ShutdownReason]*string{
 	REQUESTED: aws.String("REQUESTED"),
 	TERMINATE: aws.String("TERMINATE"),
@@ -0,0 +1,42 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package interfaces
+
+import "fmt"
+
+// PoisonRecordError can be returned by ProcessRecords to identify the user record which cannot be processed. Only
+// this record is published to the dead-letter queue, the records before it are regarded as processed and the
+// records after it are delivered again.
+type PoisonRecordError struct {
+	// SequenceNumber and SubSequenceNumber identify the user record
+	SequenceNumber    string
+	SubSequenceNumber int64
+
+	// Err is the reason why the record cannot be processed
+	Err error
+}
+
+func (e *PoisonRecordError) Error() string {
+	return fmt.Sprintf("poison record %s (sub-sequence %d): %v", e.SequenceNumber, e.SubSequenceNumber, e.Err)
+}
+
+func (e *PoisonRecordError) Unwrap() error {
+	return e.Err
+}
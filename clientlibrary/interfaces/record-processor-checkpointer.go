@@ -46,9 +46,12 @@ type (
 		 *
 		 * @error ThrottlingError Can't store checkpoint. Can be caused by checkpointing too frequently.
 		 *         Consider increasing the throughput/capacity of the checkpoint store or reducing checkpoint frequency.
-		 * @error ShutdownError The record processor instance has been shutdown. Another instance may have
+		 * @error LeaseLostError The lease of the shard has expired or been taken over. Another instance may have
 		 *         started processing some of these records already.
 		 *         The application should abort processing via this RecordProcessor instance.
+		 * @error FencingError The lease has been taken by another worker since it was acquired or renewed, the
+		 *         checkpoint has not been stored. The application should abort processing via this RecordProcessor
+		 *         instance.
 		 * @error InvalidStateError Can't store checkpoint.
 		 *         Unable to store the checkpoint in the DynamoDB table (e.g. table doesn't exist).
 		 * @error KinesisClientLibDependencyError Encountered an issue when storing the checkpoint. The application can
@@ -77,9 +80,12 @@ type (
 		 *        the Kinesis Client Library will start fetching records after this sequence number.
 		 * @error ThrottlingError Can't store checkpoint. Can be caused by checkpointing too frequently.
 		 *         Consider increasing the throughput/capacity of the checkpoint store or reducing checkpoint frequency.
-		 * @error ShutdownError The record processor instance has been shutdown. Another instance may have
+		 * @error LeaseLostError The lease of the shard has expired or been taken over. Another instance may have
 		 *         started processing some of these records already.
 		 *         The application should abort processing via this RecordProcessor instance.
+		 * @error FencingError The lease has been taken by another worker since it was acquired or renewed, the
+		 *         checkpoint has not been stored. The application should abort processing via this RecordProcessor
+		 *         instance.
 		 * @error InvalidStateError Can't store checkpoint.
 		 *         Unable to store the checkpoint in the DynamoDB table (e.g. table doesn't exist).
 		 * @error KinesisClientLibDependencyError Encountered an issue when storing the checkpoint. The application can
@@ -91,6 +97,62 @@ type (
 		 */
 		Checkpoint(sequenceNumber *string) error
 
+		// CheckpointWithSubSequence
+		/*
+		 * This method will checkpoint the progress at the provided sequenceNumber and subSequenceNumber, the latter
+		 * for records that were aggregated by the Kinesis Producer Library. This method is analogous to
+		 * {@link #checkpoint(String)} but provides the ability to checkpoint within an aggregated record.
+		 *
+		 * @param sequenceNumber A sequence number at which to checkpoint in this shard.
+		 * @param subSequenceNumber A sub-sequence number at which to checkpoint within this shard. Upon failover,
+		 *        the Kinesis Client Library will start delivering the user records after this sub-sequence number
+		 *        of the aggregated record.
+		 * @error ThrottlingError Can't store checkpoint. Can be caused by checkpointing too frequently.
+		 *         Consider increasing the throughput/capacity of the checkpoint store or reducing checkpoint frequency.
+		 * @error LeaseLostError The lease of the shard has expired or been taken over. Another instance may have
+		 *         started processing some of these records already.
+		 *         The application should abort processing via this RecordProcessor instance.
+		 * @error FencingError The lease has been taken by another worker since it was acquired or renewed, the
+		 *         checkpoint has not been stored. The application should abort processing via this RecordProcessor
+		 *         instance.
+		 * @error InvalidStateError Can't store checkpoint.
+		 *         Unable to store the checkpoint in the DynamoDB table (e.g. table doesn't exist).
+		 * @error KinesisClientLibDependencyError Encountered an issue when storing the checkpoint. The application can
+		 *         backoff and retry.
+		 * @error IllegalArgumentError The sequence number is invalid for one of the following reasons:
+		 *         1.) It appears to be out of range, i.e. it is smaller than the last check point value, or larger than the
+		 *         greatest sequence number seen by the associated record processor.
+		 *         2.) It is not a valid sequence number for a record in this shard.
+		 */
+		CheckpointWithSubSequence(sequenceNumber *string, subSequenceNumber int64) error
+
+		// CheckpointWithMetadata
+		/*
+		 * This method will checkpoint the progress at the provided sequenceNumber together with an opaque metadata
+		 * blob of the application, e.g. partial aggregation state or the time of the last processed event. The
+		 * metadata is stored with the checkpoint in the lease table and handed to the next record processor of the
+		 * shard through InitializationInput. A checkpoint without metadata clears the stored metadata.
+		 *
+		 * @param sequenceNumber A sequence number at which to checkpoint in this shard, nil checkpoints the end of a
+		 *        closed shard.
+		 * @param metadata The metadata stored with the checkpoint, at most MaxCheckpointMetadataSize bytes.
+		 * @error the errors are the same as for Checkpoint.
+		 */
+		CheckpointWithMetadata(sequenceNumber *string, metadata []byte) error
+
+		// CheckpointAsync
+		/*
+		 * This method will request a checkpoint at the provided sequenceNumber without waiting for it to be stored.
+		 * The requests of a shard are coalesced, only the last requested sequence number is stored when the pending
+		 * checkpoint is flushed every AsyncCheckpointIntervalMillis and when the record processor is shut down.
+		 * A checkpoint by Checkpoint or CheckpointWithSubSequence supersedes the pending checkpoint.
+		 *
+		 * @param sequenceNumber A sequence number at which to checkpoint in this shard.
+		 * @return a channel receiving the result of storing the checkpoint which covers this request, the errors
+		 *         are the same as for Checkpoint. The channel is closed afterwards.
+		 */
+		CheckpointAsync(sequenceNumber *string) <-chan error
+
 		// PrepareCheckpoint
 		/**
 		 * This method will record a pending checkpoint at the provided sequenceNumber.
@@ -101,9 +163,12 @@ type (
 		 *
 		 * @error ThrottlingError Can't store pending checkpoint. Can be caused by checkpointing too frequently.
 		 *         Consider increasing the throughput/capacity of the checkpoint store or reducing checkpoint frequency.
-		 * @error ShutdownError The record processor instance has been shutdown. Another instance may have
+		 * @error LeaseLostError The lease of the shard has expired or been taken over. Another instance may have
 		 *         started processing some of these records already.
 		 *         The application should abort processing via this RecordProcessor instance.
+		 * @error FencingError The lease has been taken by another worker since it was acquired or renewed, the
+		 *         checkpoint has not been stored. The application should abort processing via this RecordProcessor
+		 *         instance.
 		 * @error InvalidStateError Can't store pending checkpoint.
 		 *         Unable to store the checkpoint in the DynamoDB table (e.g. table doesn't exist).
 		 * @error KinesisClientLibDependencyError Encountered an issue when storing the pending checkpoint. The
@@ -0,0 +1,129 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package interfaces
+
+import (
+	"context"
+)
+
+type (
+	// IRecordProcessorWithContext is the context-aware version of IRecordProcessor. The context passed to Initialize
+	// and ProcessRecords is derived from the lifecycle of the worker and canceled once the worker shuts down. The context
+	// passed to Shutdown is not canceled by the worker shutdown but expires after the shutdown grace period, so that the
+	// record processor can still checkpoint its progress.
+	IRecordProcessorWithContext interface {
+		Initialize(ctx context.Context, initializationInput *InitializationInput)
+		ProcessRecords(ctx context.Context, processRecordsInput *ProcessRecordsInput) error
+		Shutdown(ctx context.Context, shutdownInput *ShutdownInput)
+	}
+
+	// IRecordProcessorWithContextFactory is interface for creating IRecordProcessorWithContext.
+	IRecordProcessorWithContextFactory interface {
+		CreateProcessor() IRecordProcessorWithContext
+	}
+
+	// IRecordProcessorV2 is the version of IRecordProcessorWithContext receiving the batches of records enriched
+	// with their metadata.
+	IRecordProcessorV2 interface {
+		Initialize(ctx context.Context, initializationInput *InitializationInput)
+		ProcessRecords(ctx context.Context, processRecordsInput *ProcessRecordsInputV2) error
+		Shutdown(ctx context.Context, shutdownInput *ShutdownInput)
+	}
+
+	// IRecordProcessorV2Factory is interface for creating IRecordProcessorV2.
+	IRecordProcessorV2Factory interface {
+		CreateProcessor() IRecordProcessorV2
+	}
+
+	// recordProcessorAdapter wraps an IRecordProcessor, the context is ignored
+	recordProcessorAdapter struct {
+		processor IRecordProcessor
+	}
+
+	// recordProcessorFactoryAdapter wraps an IRecordProcessorFactory
+	recordProcessorFactoryAdapter struct {
+		factory IRecordProcessorFactory
+	}
+
+	// recordProcessorV2Adapter wraps an IRecordProcessorV2
+	recordProcessorV2Adapter struct {
+		processor IRecordProcessorV2
+	}
+
+	// recordProcessorV2FactoryAdapter wraps an IRecordProcessorV2Factory
+	recordProcessorV2FactoryAdapter struct {
+		factory IRecordProcessorV2Factory
+	}
+)
+
+// NewRecordProcessorAdapter returns an IRecordProcessorWithContext delegating to the legacy record processor.
+func NewRecordProcessorAdapter(processor IRecordProcessor) IRecordProcessorWithContext {
+	return &recordProcessorAdapter{processor: processor}
+}
+
+// NewRecordProcessorFactoryAdapter returns an IRecordProcessorWithContextFactory creating adapters of the record
+// processors of the legacy factory.
+func NewRecordProcessorFactoryAdapter(factory IRecordProcessorFactory) IRecordProcessorWithContextFactory {
+	return &recordProcessorFactoryAdapter{factory: factory}
+}
+
+func (a *recordProcessorAdapter) Initialize(_ context.Context, initializationInput *InitializationInput) {
+	a.processor.Initialize(initializationInput)
+}
+
+func (a *recordProcessorAdapter) ProcessRecords(_ context.Context, processRecordsInput *ProcessRecordsInput) error {
+	return a.processor.ProcessRecords(processRecordsInput)
+}
+
+func (a *recordProcessorAdapter) Shutdown(_ context.Context, shutdownInput *ShutdownInput) {
+	a.processor.Shutdown(shutdownInput)
+}
+
+func (a *recordProcessorFactoryAdapter) CreateProcessor() IRecordProcessorWithContext {
+	return NewRecordProcessorAdapter(a.factory.CreateProcessor())
+}
+
+// NewRecordProcessorV2Adapter returns an IRecordProcessorWithContext delegating to the record processor with the
+// input enriched with the metadata of the batch.
+func NewRecordProcessorV2Adapter(processor IRecordProcessorV2) IRecordProcessorWithContext {
+	return &recordProcessorV2Adapter{processor: processor}
+}
+
+// NewRecordProcessorV2FactoryAdapter returns an IRecordProcessorWithContextFactory creating adapters of the record
+// processors of the given factory.
+func NewRecordProcessorV2FactoryAdapter(factory IRecordProcessorV2Factory) IRecordProcessorWithContextFactory {
+	return &recordProcessorV2FactoryAdapter{factory: factory}
+}
+
+func (a *recordProcessorV2Adapter) Initialize(ctx context.Context, initializationInput *InitializationInput) {
+	a.processor.Initialize(ctx, initializationInput)
+}
+
+func (a *recordProcessorV2Adapter) ProcessRecords(ctx context.Context, processRecordsInput *ProcessRecordsInput) error {
+	return a.processor.ProcessRecords(ctx, processRecordsInput.V2())
+}
+
+func (a *recordProcessorV2Adapter) Shutdown(ctx context.Context, shutdownInput *ShutdownInput) {
+	a.processor.Shutdown(ctx, shutdownInput)
+}
+
+func (a *recordProcessorV2FactoryAdapter) CreateProcessor() IRecordProcessorWithContext {
+	return NewRecordProcessorV2Adapter(a.factory.CreateProcessor())
+}
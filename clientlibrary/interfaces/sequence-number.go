@@ -36,6 +36,10 @@
 
 package interfaces
 
+import (
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/sequencenumber"
+)
+
 // ExtendedSequenceNumber represents a two-part sequence number for records aggregated by the Kinesis Producer Library.
 //
 // The KPL combines multiple user records into a single Kinesis record. Each user record therefore has an integer
@@ -45,3 +49,29 @@ type ExtendedSequenceNumber struct {
 	SequenceNumber    *string
 	SubSequenceNumber int64
 }
+
+// CompareTo returns -1 if the sequence number is before the other one, 0 if they are equal and +1 if it is after
+// the other one. The sub-sequence numbers are compared within the same aggregated record. A nil sequence number
+// stands for the end of the shard, which is after any sequence number, like the checkpoints at SHARD_END.
+func (e ExtendedSequenceNumber) CompareTo(other ExtendedSequenceNumber) (int, error) {
+	cmp, err := sequencenumber.Compare(orShardEnd(e.SequenceNumber), orShardEnd(other.SequenceNumber))
+	if err != nil || cmp != 0 {
+		return cmp, err
+	}
+
+	switch {
+	case e.SubSequenceNumber < other.SubSequenceNumber:
+		return -1, nil
+	case e.SubSequenceNumber > other.SubSequenceNumber:
+		return 1, nil
+	default:
+		return 0, nil
+	}
+}
+
+func orShardEnd(sequenceNumber *string) string {
+	if sequenceNumber == nil {
+		return sequencenumber.ShardEnd
+	}
+	return *sequenceNumber
+}
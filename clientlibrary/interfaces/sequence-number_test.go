@@ -0,0 +1,54 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package interfaces
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtendedSequenceNumberCompareTo(t *testing.T) {
+	at := func(sequenceNumber string, subSequenceNumber int64) ExtendedSequenceNumber {
+		return ExtendedSequenceNumber{SequenceNumber: aws.String(sequenceNumber), SubSequenceNumber: subSequenceNumber}
+	}
+
+	for _, test := range []struct {
+		a, b ExtendedSequenceNumber
+		cmp  int
+	}{
+		{at("9", 5), at("10", 0), -1},
+		{at("49590338271490256608559692540925702759324208523137515618", 0), at("49590338271490256608559692540925702759324208523137515618", 0), 0},
+		// the sub-sequence numbers are compared within an aggregated record
+		{at("100", 2), at("100", 1), 1},
+		{at("TRIM_HORIZON", 0), at("1", 0), -1},
+		// the end of the shard is after any sequence number
+		{ExtendedSequenceNumber{}, at("49590338271490256608559692540925702759324208523137515618", 0), 1},
+		{ExtendedSequenceNumber{}, at("SHARD_END", 0), 0},
+	} {
+		cmp, err := test.a.CompareTo(test.b)
+		assert.Nil(t, err)
+		assert.Equal(t, test.cmp, cmp)
+	}
+
+	_, err := at("1", 0).CompareTo(at("invalid", 0))
+	assert.NotNil(t, err)
+}
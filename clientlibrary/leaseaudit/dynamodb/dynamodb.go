@@ -0,0 +1,71 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package dynamodb implements a lease audit sink writing the lease events to a DynamoDB table
+package dynamodb
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsdynamodb "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/leaseaudit"
+)
+
+// PutItemAPI is the part of the DynamoDB client used by the sink
+type PutItemAPI interface {
+	PutItem(ctx context.Context, params *awsdynamodb.PutItemInput, optFns ...func(*awsdynamodb.Options)) (*awsdynamodb.PutItemOutput, error)
+}
+
+// Sink writes an item per lease event to the audit table. The table has the partition key ShardID and the sort key
+// EventTime, the time of the event formatted as RFC 3339 with nanoseconds followed by the worker ID, so that the
+// events of a shard are ordered by time.
+type Sink struct {
+	client    PutItemAPI
+	tableName string
+}
+
+// NewSink creates a sink for the audit table with the given name.
+func NewSink(client PutItemAPI, tableName string) *Sink {
+	return &Sink{client: client, tableName: tableName}
+}
+
+func (s *Sink) Record(ctx context.Context, event *leaseaudit.Event) error {
+	item := map[string]types.AttributeValue{
+		"ShardID":   &types.AttributeValueMemberS{Value: event.ShardID},
+		"EventTime": &types.AttributeValueMemberS{Value: event.Time.UTC().Format(time.RFC3339Nano) + "#" + event.WorkerID},
+		"EventType": &types.AttributeValueMemberS{Value: string(event.Type)},
+		"WorkerID":  &types.AttributeValueMemberS{Value: event.WorkerID},
+	}
+	if event.Checkpoint != "" {
+		item["Checkpoint"] = &types.AttributeValueMemberS{Value: event.Checkpoint}
+	}
+	if event.PreviousOwner != "" {
+		item["PreviousOwner"] = &types.AttributeValueMemberS{Value: event.PreviousOwner}
+	}
+
+	_, err := s.client.PutItem(ctx, &awsdynamodb.PutItemInput{
+		TableName: aws.String(s.tableName),
+		Item:      item,
+	})
+	return err
+}
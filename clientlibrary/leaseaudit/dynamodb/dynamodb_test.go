@@ -0,0 +1,71 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package dynamodb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsdynamodb "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/leaseaudit"
+)
+
+type mockPutItemClient struct {
+	inputs []*awsdynamodb.PutItemInput
+}
+
+func (m *mockPutItemClient) PutItem(_ context.Context, params *awsdynamodb.PutItemInput, _ ...func(*awsdynamodb.Options)) (*awsdynamodb.PutItemOutput, error) {
+	m.inputs = append(m.inputs, params)
+	return &awsdynamodb.PutItemOutput{}, nil
+}
+
+func TestRecord(t *testing.T) {
+	client := &mockPutItemClient{}
+	sink := NewSink(client, "leases-audit")
+
+	err := sink.Record(context.TODO(), &leaseaudit.Event{
+		Type:          leaseaudit.LeaseExpired,
+		Time:          time.Date(2023, 1, 2, 15, 4, 5, 6, time.UTC),
+		WorkerID:      "worker-2",
+		ShardID:       "shardId-0001",
+		Checkpoint:    "100",
+		PreviousOwner: "worker-1",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(client.inputs))
+	assert.Equal(t, "leases-audit", aws.ToString(client.inputs[0].TableName))
+	assert.Equal(t, map[string]types.AttributeValue{
+		"ShardID":       &types.AttributeValueMemberS{Value: "shardId-0001"},
+		"EventTime":     &types.AttributeValueMemberS{Value: "2023-01-02T15:04:05.000000006Z#worker-2"},
+		"EventType":     &types.AttributeValueMemberS{Value: "EXPIRED"},
+		"WorkerID":      &types.AttributeValueMemberS{Value: "worker-2"},
+		"Checkpoint":    &types.AttributeValueMemberS{Value: "100"},
+		"PreviousOwner": &types.AttributeValueMemberS{Value: "worker-1"},
+	}, client.inputs[0].Item)
+
+	// the checkpoint and the previous owner are optional
+	assert.Nil(t, sink.Record(context.TODO(), &leaseaudit.Event{Type: leaseaudit.LeaseAcquired, WorkerID: "worker-2", ShardID: "shardId-0002"}))
+	assert.Equal(t, 4, len(client.inputs[1].Item))
+}
@@ -0,0 +1,62 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package kinesis implements a lease audit sink publishing the lease events to a Kinesis stream
+package kinesis
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awskinesis "github.com/aws/aws-sdk-go-v2/service/kinesis"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/leaseaudit"
+)
+
+// PutRecordAPI is the part of the Kinesis client used by the sink
+type PutRecordAPI interface {
+	PutRecord(ctx context.Context, params *awskinesis.PutRecordInput, optFns ...func(*awskinesis.Options)) (*awskinesis.PutRecordOutput, error)
+}
+
+// Sink publishes the lease events as JSON records to the audit stream. The records are partitioned by shard ID, so
+// that the events of a shard are in order.
+type Sink struct {
+	client     PutRecordAPI
+	streamName string
+}
+
+// NewSink creates a sink for the audit stream with the given name.
+func NewSink(client PutRecordAPI, streamName string) *Sink {
+	return &Sink{client: client, streamName: streamName}
+}
+
+func (s *Sink) Record(ctx context.Context, event *leaseaudit.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.client.PutRecord(ctx, &awskinesis.PutRecordInput{
+		Data:         data,
+		PartitionKey: aws.String(event.ShardID),
+		StreamName:   aws.String(s.streamName),
+	})
+	return err
+}
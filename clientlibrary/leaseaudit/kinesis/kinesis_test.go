@@ -0,0 +1,69 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package kinesis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awskinesis "github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/leaseaudit"
+)
+
+type mockPutRecordClient struct {
+	inputs []*awskinesis.PutRecordInput
+	err    error
+}
+
+func (m *mockPutRecordClient) PutRecord(_ context.Context, params *awskinesis.PutRecordInput, _ ...func(*awskinesis.Options)) (*awskinesis.PutRecordOutput, error) {
+	m.inputs = append(m.inputs, params)
+	return &awskinesis.PutRecordOutput{}, m.err
+}
+
+func TestRecord(t *testing.T) {
+	client := &mockPutRecordClient{}
+	sink := NewSink(client, "leases-audit")
+
+	event := &leaseaudit.Event{
+		Type:       leaseaudit.LeaseReleased,
+		Time:       time.Date(2023, 1, 2, 15, 4, 5, 0, time.UTC),
+		WorkerID:   "worker-1",
+		ShardID:    "shardId-0001",
+		Checkpoint: "100",
+	}
+	assert.Nil(t, sink.Record(context.TODO(), event))
+	assert.Equal(t, 1, len(client.inputs))
+	assert.Equal(t, "leases-audit", aws.ToString(client.inputs[0].StreamName))
+	assert.Equal(t, "shardId-0001", aws.ToString(client.inputs[0].PartitionKey))
+
+	var recorded leaseaudit.Event
+	assert.Nil(t, json.Unmarshal(client.inputs[0].Data, &recorded))
+	assert.Equal(t, *event, recorded)
+	assert.NotContains(t, string(client.inputs[0].Data), "previousOwner")
+
+	client.err = errors.New("throttled")
+	assert.Equal(t, client.err, sink.Record(context.TODO(), event))
+}
@@ -0,0 +1,113 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package leaseaudit
+// The transitions of the leases of a worker are recorded to a sink, e.g. a DynamoDB table or a Kinesis stream, with
+// the checkpoint of the shard at the time of the transition. The audit log tells which worker processed which part
+// of a shard when duplicate or missed processing is analysed after an incident.
+package leaseaudit
+
+import (
+	"context"
+	"time"
+
+	"github.com/vmware/vmware-go-kcl-v2/logger"
+)
+
+// EventType is the transition of a lease
+type EventType string
+
+const (
+	// LeaseAcquired is recorded when a worker acquires a lease without owner or reclaims its own lease
+	LeaseAcquired EventType = "ACQUIRED"
+
+	// LeaseStolen is recorded when a worker acquires a lease claimed from another worker by lease stealing
+	LeaseStolen EventType = "STOLEN"
+
+	// LeaseExpired is recorded when a worker takes over the lease of another worker which has expired
+	LeaseExpired EventType = "EXPIRED"
+
+	// LeaseReleased is recorded when a worker releases a lease, e.g. at shutdown or when handing off a shard
+	LeaseReleased EventType = "RELEASED"
+
+	// LeaseLost is recorded when a worker finds that another worker has taken over its lease
+	LeaseLost EventType = "LOST"
+)
+
+type (
+	// Event is a transition of the lease of a shard
+	Event struct {
+		// Type is the transition of the lease
+		Type EventType `json:"type"`
+
+		// Time is the time of the transition
+		Time time.Time `json:"time"`
+
+		// WorkerID is the worker acquiring, releasing or losing the lease
+		WorkerID string `json:"workerId"`
+
+		// ShardID is the lease key of the shard
+		ShardID string `json:"shardId"`
+
+		// Checkpoint is the checkpoint of the shard known by the worker at the time of the transition
+		Checkpoint string `json:"checkpoint,omitempty"`
+
+		// PreviousOwner is the worker which held the lease before it was stolen or taken over after expiry
+		PreviousOwner string `json:"previousOwner,omitempty"`
+	}
+
+	// Sink records the lease events. The events are recorded synchronously by the worker and its shard consumers,
+	// the context of a call is canceled after 5 seconds. A failure is logged and does not affect the processing of
+	// the shards.
+	Sink interface {
+		Record(ctx context.Context, event *Event) error
+	}
+
+	// SinkFunc is an adapter to use a function as Sink, e.g. a callback of the application
+	SinkFunc func(ctx context.Context, event *Event) error
+
+	// LogSink writes the lease events to a logger
+	LogSink struct {
+		log logger.Logger
+	}
+)
+
+// Record calls f(ctx, event).
+func (f SinkFunc) Record(ctx context.Context, event *Event) error {
+	return f(ctx, event)
+}
+
+// NewLogSink returns a sink writing the lease events as structured log messages
+func NewLogSink(log logger.Logger) *LogSink {
+	return &LogSink{log: log}
+}
+
+func (s *LogSink) Record(_ context.Context, event *Event) error {
+	fields := logger.Fields{
+		"leaseEvent": string(event.Type),
+		"workerID":   event.WorkerID,
+		"shardID":    event.ShardID,
+		"checkpoint": event.Checkpoint,
+	}
+	if event.PreviousOwner != "" {
+		fields["previousOwner"] = event.PreviousOwner
+	}
+	s.log.WithFields(fields).Infof("Lease of shard %s %s by worker %s", event.ShardID, event.Type, event.WorkerID)
+	return nil
+}
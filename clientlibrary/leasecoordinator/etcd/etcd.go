@@ -0,0 +1,173 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package etcd
+// The shards are assigned to the workers by keys of etcd. The key of a shard holds the ID of the worker it is
+// assigned to and is attached to the etcd lease of a session of the worker, so that the shards of a worker which
+// stopped keeping its session alive are released by etcd once the lease has expired.
+package etcd
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/leasecoordinator"
+)
+
+// Coordinator assigns the shards by keys of etcd, the key of a shard is the prefix followed by the shard ID
+type Coordinator struct {
+	client *clientv3.Client
+	prefix string
+	ttl    time.Duration
+
+	mux sync.Mutex
+	// session keeps the etcd lease the keys of the shards are attached to alive, nil until it has been created
+	session *concurrency.Session
+}
+
+var _ leasecoordinator.LeaseCoordinator = (*Coordinator)(nil)
+
+// NewCoordinator returns a Coordinator keeping the assignments by the etcd client, which holds the endpoints, TLS
+// config and credentials of the etcd cluster. The shards of a worker are released by etcd once its session has not
+// been kept alive for the TTL.
+func NewCoordinator(client *clientv3.Client, prefix string, ttl time.Duration) *Coordinator {
+	return &Coordinator{
+		client: client,
+		prefix: prefix,
+		ttl:    ttl,
+	}
+}
+
+// Acquire puts the key of the shard with the worker ID unless it holds another worker ID, the key is attached to
+// the etcd lease of the session so that the assignment is kept alive with the session
+func (c *Coordinator) Acquire(ctx context.Context, shardID, workerID string) (bool, error) {
+	session, err := c.currentSession()
+	if err != nil {
+		return false, err
+	}
+
+	key := c.prefix + shardID
+	put := clientv3.OpPut(key, workerID, clientv3.WithLease(session.Lease()))
+	response, err := c.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+		Then(put).
+		Else(clientv3.OpGet(key)).
+		Commit()
+	if err != nil {
+		return false, c.checkSession(session, err)
+	}
+	if response.Succeeded {
+		return true, nil
+	}
+
+	kvs := response.Responses[0].GetResponseRange().GetKvs()
+	if len(kvs) == 0 {
+		// the key has been deleted in the meantime, the shard is assigned by the next call
+		return false, nil
+	}
+	if string(kvs[0].Value) != workerID {
+		return false, nil
+	}
+	if clientv3.LeaseID(kvs[0].Lease) == session.Lease() {
+		return true, nil
+	}
+
+	// the key is attached to the lease of a previous session of the worker, e.g. of a session which expired or of
+	// the worker before it restarted
+	response, err = c.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.Value(key), "=", workerID)).
+		Then(put).
+		Commit()
+	if err != nil {
+		return false, c.checkSession(session, err)
+	}
+	return response.Succeeded, nil
+}
+
+// Release deletes the key of the shard if it holds the worker ID
+func (c *Coordinator) Release(ctx context.Context, shardID, workerID string) error {
+	key := c.prefix + shardID
+	_, err := c.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.Value(key), "=", workerID)).
+		Then(clientv3.OpDelete(key)).
+		Commit()
+	return err
+}
+
+// Close closes the session of the worker, the shards still assigned to the worker are released by etcd as the
+// lease of the session is revoked. The etcd client is not closed.
+func (c *Coordinator) Close() error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if c.session == nil {
+		return nil
+	}
+	err := c.session.Close()
+	c.session = nil
+	return err
+}
+
+// currentSession returns the session of the worker, a new session is created once the previous one is done, e.g.
+// because its lease could not be kept alive before the TTL
+func (c *Coordinator) currentSession() (*concurrency.Session, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if c.session != nil {
+		select {
+		case <-c.session.Done():
+		default:
+			return c.session, nil
+		}
+	}
+
+	ttl := int((c.ttl + time.Second - 1) / time.Second)
+	if ttl < 1 {
+		ttl = 1
+	}
+	session, err := concurrency.NewSession(c.client, concurrency.WithTTL(ttl))
+	if err != nil {
+		return nil, err
+	}
+	c.session = session
+	return session, nil
+}
+
+// checkSession drops the session once etcd reports its lease as not found, so that the next call creates a new
+// session instead of waiting for the keep alive of the session to fail
+func (c *Coordinator) checkSession(session *concurrency.Session, err error) error {
+	if !errors.Is(err, rpctypes.ErrLeaseNotFound) {
+		return err
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.session == session {
+		session.Orphan()
+		c.session = nil
+	}
+	return err
+}
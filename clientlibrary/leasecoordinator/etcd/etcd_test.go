@@ -0,0 +1,283 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package etcd
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+// etcdServer serves the KV and lease services of the etcd v3 API, keeping the keys and leases in memory
+type etcdServer struct {
+	pb.UnimplementedKVServer
+	pb.UnimplementedLeaseServer
+
+	mux    sync.Mutex
+	kvs    map[string]*mvccpb.KeyValue
+	leases map[int64]int64
+	nextID int64
+}
+
+// newEtcdClient returns a client of an etcd server keeping the keys and leases in memory
+func newEtcdClient(t *testing.T) (*etcdServer, *clientv3.Client) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	s := &etcdServer{kvs: make(map[string]*mvccpb.KeyValue), leases: make(map[int64]int64)}
+	server := grpc.NewServer()
+	pb.RegisterKVServer(server, s)
+	pb.RegisterLeaseServer(server, s)
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(server.Stop)
+
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   []string{listener.Addr().String()},
+		DialTimeout: time.Second,
+		Logger:      zap.NewNop(),
+	})
+	assert.Nil(t, err)
+	t.Cleanup(func() { _ = client.Close() })
+	return s, client
+}
+
+func (s *etcdServer) LeaseGrant(_ context.Context, request *pb.LeaseGrantRequest) (*pb.LeaseGrantResponse, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.nextID++
+	s.leases[s.nextID] = request.TTL
+	return &pb.LeaseGrantResponse{Header: &pb.ResponseHeader{}, ID: s.nextID, TTL: request.TTL}, nil
+}
+
+func (s *etcdServer) LeaseRevoke(_ context.Context, request *pb.LeaseRevokeRequest) (*pb.LeaseRevokeResponse, error) {
+	s.expire(request.ID)
+	return &pb.LeaseRevokeResponse{Header: &pb.ResponseHeader{}}, nil
+}
+
+// LeaseKeepAlive answers the keep alive of an expired lease without TTL, like etcd
+func (s *etcdServer) LeaseKeepAlive(stream pb.Lease_LeaseKeepAliveServer) error {
+	for {
+		request, err := stream.Recv()
+		if err != nil {
+			return nil
+		}
+		s.mux.Lock()
+		ttl := s.leases[request.ID]
+		s.mux.Unlock()
+		if err := stream.Send(&pb.LeaseKeepAliveResponse{Header: &pb.ResponseHeader{}, ID: request.ID, TTL: ttl}); err != nil {
+			return nil
+		}
+	}
+}
+
+func (s *etcdServer) Txn(_ context.Context, request *pb.TxnRequest) (*pb.TxnResponse, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	succeeded := true
+	for _, c := range request.Compare {
+		kv, ok := s.kvs[string(c.Key)]
+		switch c.Target {
+		case pb.Compare_CREATE:
+			succeeded = succeeded && !ok
+		case pb.Compare_VALUE:
+			succeeded = succeeded && ok && string(kv.Value) == string(c.GetValue())
+		}
+	}
+
+	ops := request.Success
+	if !succeeded {
+		ops = request.Failure
+	}
+	response := &pb.TxnResponse{Header: &pb.ResponseHeader{}, Succeeded: succeeded}
+	for _, op := range ops {
+		switch {
+		case op.GetRequestPut() != nil:
+			put := op.GetRequestPut()
+			if _, ok := s.leases[put.Lease]; put.Lease != 0 && !ok {
+				return nil, rpctypes.ErrGRPCLeaseNotFound
+			}
+			s.kvs[string(put.Key)] = &mvccpb.KeyValue{Key: put.Key, Value: put.Value, Lease: put.Lease}
+			response.Responses = append(response.Responses, &pb.ResponseOp{Response: &pb.ResponseOp_ResponsePut{
+				ResponsePut: &pb.PutResponse{Header: &pb.ResponseHeader{}},
+			}})
+		case op.GetRequestDeleteRange() != nil:
+			delete(s.kvs, string(op.GetRequestDeleteRange().Key))
+			response.Responses = append(response.Responses, &pb.ResponseOp{Response: &pb.ResponseOp_ResponseDeleteRange{
+				ResponseDeleteRange: &pb.DeleteRangeResponse{Header: &pb.ResponseHeader{}},
+			}})
+		case op.GetRequestRange() != nil:
+			var kvs []*mvccpb.KeyValue
+			if kv, ok := s.kvs[string(op.GetRequestRange().Key)]; ok {
+				kvs = append(kvs, kv)
+			}
+			response.Responses = append(response.Responses, &pb.ResponseOp{Response: &pb.ResponseOp_ResponseRange{
+				ResponseRange: &pb.RangeResponse{Header: &pb.ResponseHeader{}, Kvs: kvs, Count: int64(len(kvs))},
+			}})
+		}
+	}
+	return response, nil
+}
+
+// expire expires the etcd lease and deletes the keys attached to it
+func (s *etcdServer) expire(leaseID int64) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	delete(s.leases, leaseID)
+	for key, kv := range s.kvs {
+		if kv.Lease == leaseID {
+			delete(s.kvs, key)
+		}
+	}
+}
+
+func (s *etcdServer) get(key string) (*mvccpb.KeyValue, bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	kv, ok := s.kvs[key]
+	return kv, ok
+}
+
+func leaseOf(c *Coordinator) int64 {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return int64(c.session.Lease())
+}
+
+func TestAcquireAndRelease(t *testing.T) {
+	server, client := newEtcdClient(t)
+	ctx := context.TODO()
+
+	c1 := NewCoordinator(client, "/kcl/orders/", 10*time.Second)
+	c2 := NewCoordinator(client, "/kcl/orders/", 10*time.Second)
+
+	acquired, err := c1.Acquire(ctx, "shard-0", "worker-1")
+	assert.Nil(t, err)
+	assert.True(t, acquired)
+	kv, ok := server.get("/kcl/orders/shard-0")
+	assert.True(t, ok)
+	assert.Equal(t, "worker-1", string(kv.Value))
+	assert.Equal(t, leaseOf(c1), kv.Lease)
+
+	acquired, err = c2.Acquire(ctx, "shard-0", "worker-2")
+	assert.Nil(t, err)
+	assert.False(t, acquired)
+
+	acquired, err = c1.Acquire(ctx, "shard-0", "worker-1")
+	assert.Nil(t, err)
+	assert.True(t, acquired)
+
+	// the shard is not released by another worker
+	assert.Nil(t, c2.Release(ctx, "shard-0", "worker-2"))
+	_, ok = server.get("/kcl/orders/shard-0")
+	assert.True(t, ok)
+
+	assert.Nil(t, c1.Release(ctx, "shard-0", "worker-1"))
+	_, ok = server.get("/kcl/orders/shard-0")
+	assert.False(t, ok)
+
+	acquired, err = c2.Acquire(ctx, "shard-0", "worker-2")
+	assert.Nil(t, err)
+	assert.True(t, acquired)
+
+	// the shards of a worker are released once its session is closed
+	assert.Nil(t, c2.Close())
+	_, ok = server.get("/kcl/orders/shard-0")
+	assert.False(t, ok)
+	assert.Nil(t, c1.Close())
+}
+
+func TestAcquireExpiredLease(t *testing.T) {
+	server, client := newEtcdClient(t)
+	ctx := context.TODO()
+
+	// the session is kept alive every third of a second with the min TTL
+	c1 := NewCoordinator(client, "/kcl/", 0)
+	c2 := NewCoordinator(client, "/kcl/", 0)
+
+	acquired, err := c1.Acquire(ctx, "shard-0", "worker-1")
+	assert.Nil(t, err)
+	assert.True(t, acquired)
+	expired := leaseOf(c1)
+
+	// the shards of a worker are released once its etcd lease has expired
+	server.expire(expired)
+	acquired, err = c2.Acquire(ctx, "shard-0", "worker-2")
+	assert.Nil(t, err)
+	assert.True(t, acquired)
+
+	// a new session is created once the keep alive of the expired lease has failed
+	assert.Eventually(t, func() bool {
+		acquired, err := c1.Acquire(ctx, "shard-1", "worker-1")
+		return err == nil && acquired
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.NotEqual(t, expired, leaseOf(c1))
+	acquired, err = c1.Acquire(ctx, "shard-0", "worker-1")
+	assert.Nil(t, err)
+	assert.False(t, acquired)
+}
+
+func TestAcquireRestartedWorker(t *testing.T) {
+	server, client := newEtcdClient(t)
+	ctx := context.TODO()
+
+	c1 := NewCoordinator(client, "/kcl/", 10*time.Second)
+	acquired, err := c1.Acquire(ctx, "shard-0", "worker-1")
+	assert.Nil(t, err)
+	assert.True(t, acquired)
+
+	// the worker keeps its shards after a restart, they are attached to the lease of its new session
+	restarted := NewCoordinator(client, "/kcl/", 10*time.Second)
+	acquired, err = restarted.Acquire(ctx, "shard-0", "worker-1")
+	assert.Nil(t, err)
+	assert.True(t, acquired)
+	kv, _ := server.get("/kcl/shard-0")
+	assert.Equal(t, leaseOf(restarted), kv.Lease)
+	assert.NotEqual(t, leaseOf(c1), kv.Lease)
+}
+
+func TestAcquireLeaseNotFound(t *testing.T) {
+	server, client := newEtcdClient(t)
+	ctx := context.TODO()
+
+	c := NewCoordinator(client, "/kcl/", 10*time.Second)
+	acquired, err := c.Acquire(ctx, "shard-0", "worker-1")
+	assert.Nil(t, err)
+	assert.True(t, acquired)
+	expired := leaseOf(c)
+
+	// the session is dropped as soon as etcd reports its lease as not found
+	server.expire(expired)
+	_, err = c.Acquire(ctx, "shard-1", "worker-1")
+	assert.ErrorIs(t, err, rpctypes.ErrLeaseNotFound)
+	acquired, err = c.Acquire(ctx, "shard-1", "worker-1")
+	assert.Nil(t, err)
+	assert.True(t, acquired)
+	assert.NotEqual(t, expired, leaseOf(c))
+}
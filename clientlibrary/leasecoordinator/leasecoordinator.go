@@ -0,0 +1,64 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package leasecoordinator
+// The shards are assigned to the workers by an external system, e.g. the leader election of Kubernetes, ZooKeeper
+// or etcd, instead of the leases of the lease table. The lease table, e.g. in DynamoDB, is still required: it stores
+// the checkpoints and fences them with the leases, a worker takes the lease of a shard in the table only once the
+// coordinator has assigned the shard to the worker.
+package leasecoordinator
+
+import (
+	"context"
+)
+
+type (
+	// LeaseCoordinator assigns the shards to the workers. Acquire is called by a worker before it takes the lease of
+	// a shard and every time it renews the lease, Release once the worker stops processing the shard. A worker
+	// hands off a shard as soon as the coordinator has assigned the shard to another worker. The context of the
+	// calls is canceled after the lease duration, FailoverTimeMillis.
+	LeaseCoordinator interface {
+		// Acquire assigns the shard to the worker, or keeps it assigned, and returns false if the shard is assigned
+		// to another worker
+		Acquire(ctx context.Context, shardID, workerID string) (bool, error)
+
+		// Release removes the assignment of the shard to the worker, it does nothing if the shard is assigned to
+		// another worker
+		Release(ctx context.Context, shardID, workerID string) error
+	}
+
+	// Funcs is an adapter to use functions as LeaseCoordinator, e.g. callbacks of the application
+	Funcs struct {
+		AcquireFunc func(ctx context.Context, shardID, workerID string) (bool, error)
+		ReleaseFunc func(ctx context.Context, shardID, workerID string) error
+	}
+)
+
+// Acquire calls f.AcquireFunc(ctx, shardID, workerID).
+func (f Funcs) Acquire(ctx context.Context, shardID, workerID string) (bool, error) {
+	return f.AcquireFunc(ctx, shardID, workerID)
+}
+
+// Release calls f.ReleaseFunc(ctx, shardID, workerID), it does nothing without ReleaseFunc.
+func (f Funcs) Release(ctx context.Context, shardID, workerID string) error {
+	if f.ReleaseFunc == nil {
+		return nil
+	}
+	return f.ReleaseFunc(ctx, shardID, workerID)
+}
@@ -31,6 +31,7 @@ package cloudwatch
 
 import (
 	"context"
+	"sort"
 	"sync"
 	"time"
 
@@ -38,12 +39,24 @@ import (
 	cwatch "github.com/aws/aws-sdk-go-v2/service/cloudwatch"
 	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
 
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/metrics"
 	"github.com/vmware/vmware-go-kcl-v2/logger"
 )
 
 // DefaultCloudwatchMetricsBufferDuration Buffer metrics for at most this long before publishing to CloudWatch.
 const DefaultCloudwatchMetricsBufferDuration = 10 * time.Second
 
+// the metrics of all extensions of the MonitoringService are reported
+var (
+	_ metrics.LagMonitoringService            = (*MonitoringService)(nil)
+	_ metrics.CheckpointMonitoringService     = (*MonitoringService)(nil)
+	_ metrics.LeaseTableMonitoringService     = (*MonitoringService)(nil)
+	_ metrics.ProcessorPanicMonitoringService = (*MonitoringService)(nil)
+	_ metrics.ThrottlingMonitoringService     = (*MonitoringService)(nil)
+	_ metrics.GetRecordsMonitoringService     = (*MonitoringService)(nil)
+	_ metrics.AlarmMonitoringService          = (*MonitoringService)(nil)
+)
+
 type MonitoringService struct {
 	appName     string
 	streamName  string
@@ -52,6 +65,9 @@ type MonitoringService struct {
 	credentials aws.CredentialsProvider
 	logger      logger.Logger
 
+	// dimensions are the static dimensions added to all metrics, e.g. the environment
+	dimensions []types.Dimension
+
 	// control how often to publish to CloudWatch
 	bufferDuration time.Duration
 
@@ -59,6 +75,23 @@ type MonitoringService struct {
 	waitGroup    *sync.WaitGroup
 	svc          *cwatch.Client
 	shardMetrics *sync.Map
+
+	// throttledRequests counts the throttled calls per AWS API of the worker
+	throttleMux       sync.Mutex
+	throttledRequests map[string]int64
+
+	// leaseTableCapacity sums the capacity of the lease table consumed per operation of the worker
+	capacityMux        sync.Mutex
+	leaseTableCapacity map[string]*consumedCapacity
+
+	// maxBehindLatestMillis are the max MillisBehindLatest of the shards of the worker since the last flush
+	behindLatestMux       sync.Mutex
+	maxBehindLatestMillis []float64
+}
+
+// consumedCapacity are the capacity units consumed by the calls of an operation
+type consumedCapacity struct {
+	read, write float64
 }
 
 type cloudWatchMetrics struct {
@@ -69,8 +102,23 @@ type cloudWatchMetrics struct {
 	behindLatestMillis []float64
 	leasesHeld         int64
 	leaseRenewals      int64
+	checkpointErrors   int64
+	checkpointTime     []float64
+	leaseContentions   int64
+	conditionalChecks  int64
+	processorPanics    int64
 	getRecordsTime     []float64
 	processRecordsTime []float64
+
+	// recordsRead, bytesRead and getRecordsLatency are those of the GetRecords calls, before de-aggregation
+	recordsRead        int64
+	bytesRead          int64
+	getRecordsLatency  []float64
+	getRecordsThrottle int64
+
+	// checkpointAgeMillis are the checkpoint ages of the shard since the last flush
+	checkpointAgeMillis []float64
+	alarmsRaised        int64
 }
 
 // NewMonitoringService returns a Monitoring service publishing metrics to CloudWatch.
@@ -89,6 +137,19 @@ func NewMonitoringServiceWithOptions(region string, creds aws.CredentialsProvide
 	}
 }
 
+// WithDimensions adds the static dimensions to all metrics, e.g. the environment or the service of the application.
+func (cw *MonitoringService) WithDimensions(dimensions map[string]string) *MonitoringService {
+	names := make([]string, 0, len(dimensions))
+	for name := range dimensions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cw.dimensions = append(cw.dimensions, types.Dimension{Name: aws.String(name), Value: aws.String(dimensions[name])})
+	}
+	return cw
+}
+
 func (cw *MonitoringService) Init(appName, streamName, workerID string) error {
 	cw.appName = appName
 	cw.streamName = streamName
@@ -99,6 +160,8 @@ func (cw *MonitoringService) Init(appName, streamName, workerID string) error {
 
 	cw.svc = cwatch.NewFromConfig(*cfg)
 	cw.shardMetrics = &sync.Map{}
+	cw.throttledRequests = map[string]int64{}
+	cw.leaseTableCapacity = map[string]*consumedCapacity{}
 
 	stopChan := make(chan struct{})
 	cw.stop = &stopChan
@@ -145,7 +208,7 @@ func (cw *MonitoringService) eventloop() {
 
 func (cw *MonitoringService) flushShard(shard string, metric *cloudWatchMetrics) bool {
 	metric.Lock()
-	defaultDimensions := []types.Dimension{
+	defaultDimensions := cw.withDimensions([]types.Dimension{
 		{
 			Name:  aws.String("Shard"),
 			Value: &shard,
@@ -154,9 +217,9 @@ func (cw *MonitoringService) flushShard(shard string, metric *cloudWatchMetrics)
 			Name:  aws.String("KinesisStreamName"),
 			Value: &cw.streamName,
 		},
-	}
+	})
 
-	leaseDimensions := []types.Dimension{
+	leaseDimensions := cw.withDimensions([]types.Dimension{
 		{
 			Name:  aws.String("Shard"),
 			Value: &shard,
@@ -169,7 +232,7 @@ func (cw *MonitoringService) flushShard(shard string, metric *cloudWatchMetrics)
 			Name:  aws.String("WorkerID"),
 			Value: &cw.workerID,
 		},
-	}
+	})
 	metricTimestamp := time.Now()
 
 	data := []types.MetricDatum{
@@ -187,6 +250,27 @@ func (cw *MonitoringService) flushShard(shard string, metric *cloudWatchMetrics)
 			Timestamp:  &metricTimestamp,
 			Value:      aws.Float64(float64(metric.processedBytes)),
 		},
+		{
+			Dimensions: defaultDimensions,
+			MetricName: aws.String("KinesisDataFetcher.getRecords.RecordsRead"),
+			Unit:       types.StandardUnitCount,
+			Timestamp:  &metricTimestamp,
+			Value:      aws.Float64(float64(metric.recordsRead)),
+		},
+		{
+			Dimensions: defaultDimensions,
+			MetricName: aws.String("KinesisDataFetcher.getRecords.BytesRead"),
+			Unit:       types.StandardUnitBytes,
+			Timestamp:  &metricTimestamp,
+			Value:      aws.Float64(float64(metric.bytesRead)),
+		},
+		{
+			Dimensions: defaultDimensions,
+			MetricName: aws.String("KinesisDataFetcher.getRecords.Throttles"),
+			Unit:       types.StandardUnitCount,
+			Timestamp:  &metricTimestamp,
+			Value:      aws.Float64(float64(metric.getRecordsThrottle)),
+		},
 		{
 			Dimensions: leaseDimensions,
 			MetricName: aws.String("RenewLease.Success"),
@@ -201,6 +285,41 @@ func (cw *MonitoringService) flushShard(shard string, metric *cloudWatchMetrics)
 			Timestamp:  &metricTimestamp,
 			Value:      aws.Float64(float64(metric.leasesHeld)),
 		},
+		{
+			Dimensions: leaseDimensions,
+			MetricName: aws.String("Checkpoint.Errors"),
+			Unit:       types.StandardUnitCount,
+			Timestamp:  &metricTimestamp,
+			Value:      aws.Float64(float64(metric.checkpointErrors)),
+		},
+		{
+			Dimensions: leaseDimensions,
+			MetricName: aws.String("Lease.Contentions"),
+			Unit:       types.StandardUnitCount,
+			Timestamp:  &metricTimestamp,
+			Value:      aws.Float64(float64(metric.leaseContentions)),
+		},
+		{
+			Dimensions: leaseDimensions,
+			MetricName: aws.String("ConditionalCheck.Failures"),
+			Unit:       types.StandardUnitCount,
+			Timestamp:  &metricTimestamp,
+			Value:      aws.Float64(float64(metric.conditionalChecks)),
+		},
+		{
+			Dimensions: leaseDimensions,
+			MetricName: aws.String("Processor.Panics"),
+			Unit:       types.StandardUnitCount,
+			Timestamp:  &metricTimestamp,
+			Value:      aws.Float64(float64(metric.processorPanics)),
+		},
+		{
+			Dimensions: defaultDimensions,
+			MetricName: aws.String("Alarms.Raised"),
+			Unit:       types.StandardUnitCount,
+			Timestamp:  &metricTimestamp,
+			Value:      aws.Float64(float64(metric.alarmsRaised)),
+		},
 	}
 
 	if len(metric.behindLatestMillis) > 0 {
@@ -217,6 +336,34 @@ func (cw *MonitoringService) flushShard(shard string, metric *cloudWatchMetrics)
 			}})
 	}
 
+	if len(metric.checkpointAgeMillis) > 0 {
+		data = append(data, types.MetricDatum{
+			Dimensions: defaultDimensions,
+			MetricName: aws.String("CheckpointAge"),
+			Unit:       types.StandardUnitMilliseconds,
+			Timestamp:  &metricTimestamp,
+			StatisticValues: &types.StatisticSet{
+				SampleCount: aws.Float64(float64(len(metric.checkpointAgeMillis))),
+				Sum:         sumFloat64(metric.checkpointAgeMillis),
+				Maximum:     maxFloat64(metric.checkpointAgeMillis),
+				Minimum:     minFloat64(metric.checkpointAgeMillis),
+			}})
+	}
+
+	if len(metric.checkpointTime) > 0 {
+		data = append(data, types.MetricDatum{
+			Dimensions: defaultDimensions,
+			MetricName: aws.String("Checkpoint.Time"),
+			Unit:       types.StandardUnitMilliseconds,
+			Timestamp:  &metricTimestamp,
+			StatisticValues: &types.StatisticSet{
+				SampleCount: aws.Float64(float64(len(metric.checkpointTime))),
+				Sum:         sumFloat64(metric.checkpointTime),
+				Maximum:     maxFloat64(metric.checkpointTime),
+				Minimum:     minFloat64(metric.checkpointTime),
+			}})
+	}
+
 	if len(metric.getRecordsTime) > 0 {
 		data = append(data, types.MetricDatum{
 			Dimensions: defaultDimensions,
@@ -231,6 +378,20 @@ func (cw *MonitoringService) flushShard(shard string, metric *cloudWatchMetrics)
 			}})
 	}
 
+	if len(metric.getRecordsLatency) > 0 {
+		data = append(data, types.MetricDatum{
+			Dimensions: defaultDimensions,
+			MetricName: aws.String("KinesisDataFetcher.getRecords.Latency"),
+			Unit:       types.StandardUnitMilliseconds,
+			Timestamp:  &metricTimestamp,
+			StatisticValues: &types.StatisticSet{
+				SampleCount: aws.Float64(float64(len(metric.getRecordsLatency))),
+				Sum:         sumFloat64(metric.getRecordsLatency),
+				Maximum:     maxFloat64(metric.getRecordsLatency),
+				Minimum:     minFloat64(metric.getRecordsLatency),
+			}})
+	}
+
 	if len(metric.processRecordsTime) > 0 {
 		data = append(data, types.MetricDatum{
 			Dimensions: defaultDimensions,
@@ -256,8 +417,19 @@ func (cw *MonitoringService) flushShard(shard string, metric *cloudWatchMetrics)
 		metric.processedBytes = 0
 		metric.behindLatestMillis = []float64{}
 		metric.leaseRenewals = 0
+		metric.checkpointErrors = 0
+		metric.checkpointTime = []float64{}
+		metric.leaseContentions = 0
+		metric.conditionalChecks = 0
+		metric.processorPanics = 0
 		metric.getRecordsTime = []float64{}
 		metric.processRecordsTime = []float64{}
+		metric.recordsRead = 0
+		metric.bytesRead = 0
+		metric.getRecordsLatency = []float64{}
+		metric.getRecordsThrottle = 0
+		metric.checkpointAgeMillis = []float64{}
+		metric.alarmsRaised = 0
 	} else {
 		cw.logger.Errorf("Error in publishing cloudwatch metrics. Error: %+v", err)
 	}
@@ -273,10 +445,156 @@ func (cw *MonitoringService) flush() error {
 		shard, metric := k.(string), v.(*cloudWatchMetrics)
 		return cw.flushShard(shard, metric)
 	})
+	cw.flushThrottledRequests()
+	cw.flushLeaseTableCapacity()
+	cw.flushMaxBehindLatest()
 
 	return nil
 }
 
+// flushThrottledRequests publishes the worker metrics of the throttled calls per AWS API
+func (cw *MonitoringService) flushThrottledRequests() {
+	cw.throttleMux.Lock()
+	defer cw.throttleMux.Unlock()
+
+	if len(cw.throttledRequests) == 0 {
+		return
+	}
+
+	metricTimestamp := time.Now()
+	data := make([]types.MetricDatum, 0, len(cw.throttledRequests))
+	for api, count := range cw.throttledRequests {
+		data = append(data, types.MetricDatum{
+			Dimensions: cw.withDimensions([]types.Dimension{
+				{
+					Name:  aws.String("API"),
+					Value: aws.String(api),
+				},
+				{
+					Name:  aws.String("KinesisStreamName"),
+					Value: &cw.streamName,
+				},
+				{
+					Name:  aws.String("WorkerID"),
+					Value: &cw.workerID,
+				},
+			}),
+			MetricName: aws.String("ThrottledRequests"),
+			Unit:       types.StandardUnitCount,
+			Timestamp:  &metricTimestamp,
+			Value:      aws.Float64(float64(count)),
+		})
+	}
+
+	_, err := cw.svc.PutMetricData(context.TODO(), &cwatch.PutMetricDataInput{
+		Namespace:  aws.String(cw.appName),
+		MetricData: data,
+	})
+
+	if err == nil {
+		cw.throttledRequests = map[string]int64{}
+	} else {
+		cw.logger.Errorf("Error in publishing cloudwatch metrics. Error: %+v", err)
+	}
+}
+
+// flushLeaseTableCapacity publishes the worker metrics of the capacity of the lease table consumed per operation
+func (cw *MonitoringService) flushLeaseTableCapacity() {
+	cw.capacityMux.Lock()
+	defer cw.capacityMux.Unlock()
+
+	if len(cw.leaseTableCapacity) == 0 {
+		return
+	}
+
+	metricTimestamp := time.Now()
+	data := make([]types.MetricDatum, 0, 2*len(cw.leaseTableCapacity))
+	for operation, capacity := range cw.leaseTableCapacity {
+		dimensions := cw.withDimensions([]types.Dimension{
+			{
+				Name:  aws.String("Operation"),
+				Value: aws.String(operation),
+			},
+			{
+				Name:  aws.String("KinesisStreamName"),
+				Value: &cw.streamName,
+			},
+			{
+				Name:  aws.String("WorkerID"),
+				Value: &cw.workerID,
+			},
+		})
+		data = append(data, types.MetricDatum{
+			Dimensions: dimensions,
+			MetricName: aws.String("LeaseTableConsumedReadCapacityUnits"),
+			Unit:       types.StandardUnitCount,
+			Timestamp:  &metricTimestamp,
+			Value:      aws.Float64(capacity.read),
+		}, types.MetricDatum{
+			Dimensions: dimensions,
+			MetricName: aws.String("LeaseTableConsumedWriteCapacityUnits"),
+			Unit:       types.StandardUnitCount,
+			Timestamp:  &metricTimestamp,
+			Value:      aws.Float64(capacity.write),
+		})
+	}
+
+	_, err := cw.svc.PutMetricData(context.TODO(), &cwatch.PutMetricDataInput{
+		Namespace:  aws.String(cw.appName),
+		MetricData: data,
+	})
+
+	if err == nil {
+		cw.leaseTableCapacity = map[string]*consumedCapacity{}
+	} else {
+		cw.logger.Errorf("Error in publishing cloudwatch metrics. Error: %+v", err)
+	}
+}
+
+// flushMaxBehindLatest publishes the worker metric of the max MillisBehindLatest of its shards
+func (cw *MonitoringService) flushMaxBehindLatest() {
+	cw.behindLatestMux.Lock()
+	defer cw.behindLatestMux.Unlock()
+
+	if len(cw.maxBehindLatestMillis) == 0 {
+		return
+	}
+
+	metricTimestamp := time.Now()
+	_, err := cw.svc.PutMetricData(context.TODO(), &cwatch.PutMetricDataInput{
+		Namespace: aws.String(cw.appName),
+		MetricData: []types.MetricDatum{
+			{
+				Dimensions: cw.withDimensions([]types.Dimension{
+					{
+						Name:  aws.String("KinesisStreamName"),
+						Value: &cw.streamName,
+					},
+					{
+						Name:  aws.String("WorkerID"),
+						Value: &cw.workerID,
+					},
+				}),
+				MetricName: aws.String("MaxMillisBehindLatest"),
+				Unit:       types.StandardUnitMilliseconds,
+				Timestamp:  &metricTimestamp,
+				StatisticValues: &types.StatisticSet{
+					SampleCount: aws.Float64(float64(len(cw.maxBehindLatestMillis))),
+					Sum:         sumFloat64(cw.maxBehindLatestMillis),
+					Maximum:     maxFloat64(cw.maxBehindLatestMillis),
+					Minimum:     minFloat64(cw.maxBehindLatestMillis),
+				},
+			},
+		},
+	})
+
+	if err == nil {
+		cw.maxBehindLatestMillis = []float64{}
+	} else {
+		cw.logger.Errorf("Error in publishing cloudwatch metrics. Error: %+v", err)
+	}
+}
+
 func (cw *MonitoringService) IncrRecordsProcessed(shard string, count int) {
 	m := cw.getOrCreatePerShardMetrics(shard)
 	m.Lock()
@@ -298,6 +616,19 @@ func (cw *MonitoringService) MillisBehindLatest(shard string, millSeconds float6
 	m.behindLatestMillis = append(m.behindLatestMillis, millSeconds)
 }
 
+func (cw *MonitoringService) DeleteMetricMillisBehindLatest(shard string) {
+	m := cw.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.behindLatestMillis = []float64{}
+}
+
+func (cw *MonitoringService) MaxMillisBehindLatest(millSeconds float64) {
+	cw.behindLatestMux.Lock()
+	defer cw.behindLatestMux.Unlock()
+	cw.maxBehindLatestMillis = append(cw.maxBehindLatestMillis, millSeconds)
+}
+
 func (cw *MonitoringService) LeaseGained(shard string) {
 	m := cw.getOrCreatePerShardMetrics(shard)
 	m.Lock()
@@ -319,12 +650,86 @@ func (cw *MonitoringService) LeaseRenewed(shard string) {
 	m.leaseRenewals++
 }
 
+func (cw *MonitoringService) IncrCheckpointErrors(shard string) {
+	m := cw.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.checkpointErrors++
+}
+
+func (cw *MonitoringService) RecordCheckpointTime(shard string, time float64) {
+	m := cw.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.checkpointTime = append(m.checkpointTime, time)
+}
+
+func (cw *MonitoringService) IncrLeaseContentions(shard string) {
+	m := cw.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.leaseContentions++
+}
+
+func (cw *MonitoringService) IncrConditionalCheckFailures(shard string) {
+	m := cw.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.conditionalChecks++
+}
+
+func (cw *MonitoringService) IncrProcessorPanics(shard string) {
+	m := cw.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.processorPanics++
+}
+
+func (cw *MonitoringService) IncrThrottledRequests(api string) {
+	cw.throttleMux.Lock()
+	defer cw.throttleMux.Unlock()
+	cw.throttledRequests[api]++
+}
+
+// IncrLeaseTableConsumedCapacity is called by the DynamoDB checkpointer, which is also used without Init by the
+// admin and snapshot packages
+func (cw *MonitoringService) IncrLeaseTableConsumedCapacity(operation string, readCapacityUnits, writeCapacityUnits float64) {
+	cw.capacityMux.Lock()
+	defer cw.capacityMux.Unlock()
+	if cw.leaseTableCapacity == nil {
+		cw.leaseTableCapacity = map[string]*consumedCapacity{}
+	}
+	capacity, ok := cw.leaseTableCapacity[operation]
+	if !ok {
+		capacity = &consumedCapacity{}
+		cw.leaseTableCapacity[operation] = capacity
+	}
+	capacity.read += readCapacityUnits
+	capacity.write += writeCapacityUnits
+}
+
 func (cw *MonitoringService) RecordGetRecordsTime(shard string, time float64) {
 	m := cw.getOrCreatePerShardMetrics(shard)
 	m.Lock()
 	defer m.Unlock()
 	m.getRecordsTime = append(m.getRecordsTime, time)
 }
+func (cw *MonitoringService) RecordGetRecordsCall(shard string, recordCount int, bytesRead int64, latency float64) {
+	m := cw.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.recordsRead += int64(recordCount)
+	m.bytesRead += bytesRead
+	m.getRecordsLatency = append(m.getRecordsLatency, latency)
+}
+
+func (cw *MonitoringService) IncrGetRecordsThrottles(shard string) {
+	m := cw.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.getRecordsThrottle++
+}
+
 func (cw *MonitoringService) RecordProcessRecordsTime(shard string, time float64) {
 	m := cw.getOrCreatePerShardMetrics(shard)
 	m.Lock()
@@ -332,6 +737,32 @@ func (cw *MonitoringService) RecordProcessRecordsTime(shard string, time float64
 	m.processRecordsTime = append(m.processRecordsTime, time)
 }
 
+func (cw *MonitoringService) CheckpointAge(shard string, milliSeconds float64) {
+	m := cw.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.checkpointAgeMillis = append(m.checkpointAgeMillis, milliSeconds)
+}
+
+func (cw *MonitoringService) DeleteMetricCheckpointAge(shard string) {
+	m := cw.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.checkpointAgeMillis = []float64{}
+}
+
+func (cw *MonitoringService) IncrAlarmsRaised(shard string) {
+	m := cw.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.alarmsRaised++
+}
+
+// withDimensions appends the static dimensions to the dimensions of a metric
+func (cw *MonitoringService) withDimensions(dimensions []types.Dimension) []types.Dimension {
+	return append(dimensions, cw.dimensions...)
+}
+
 func (cw *MonitoringService) getOrCreatePerShardMetrics(shard string) *cloudWatchMetrics {
 	var i interface{}
 	var ok bool
@@ -0,0 +1,620 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package emf
+// The implementation publishes kcl metrics in the CloudWatch embedded metric format (EMF). The metrics are
+// written as JSON documents to stdout (or any other writer) and extracted by CloudWatch Logs, which avoids the
+// throttling of PutMetricData at high shard counts.
+// For more info see: https://docs.aws.amazon.com/AmazonCloudWatch/latest/monitoring/CloudWatch_Embedded_Metric_Format_Specification.html
+package emf
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/metrics"
+	"github.com/vmware/vmware-go-kcl-v2/logger"
+)
+
+// DefaultEMFMetricsBufferDuration Buffer metrics for at most this long before writing them.
+const DefaultEMFMetricsBufferDuration = 10 * time.Second
+
+const (
+	unitCount        = "Count"
+	unitBytes        = "Bytes"
+	unitMilliseconds = "Milliseconds"
+)
+
+// the metrics of all extensions of the MonitoringService are reported
+var (
+	_ metrics.LagMonitoringService            = (*MonitoringService)(nil)
+	_ metrics.CheckpointMonitoringService     = (*MonitoringService)(nil)
+	_ metrics.LeaseTableMonitoringService     = (*MonitoringService)(nil)
+	_ metrics.ProcessorPanicMonitoringService = (*MonitoringService)(nil)
+	_ metrics.ThrottlingMonitoringService     = (*MonitoringService)(nil)
+	_ metrics.GetRecordsMonitoringService     = (*MonitoringService)(nil)
+	_ metrics.AlarmMonitoringService          = (*MonitoringService)(nil)
+)
+
+type MonitoringService struct {
+	appName    string
+	streamName string
+	workerID   string
+	logger     logger.Logger
+
+	// dimensions are the static dimensions added to all metrics, e.g. the environment
+	dimensions map[string]string
+
+	// control how often to write the metrics
+	bufferDuration time.Duration
+
+	// mux serializes the documents written to out
+	mux sync.Mutex
+	out io.Writer
+
+	stop         *chan struct{}
+	waitGroup    *sync.WaitGroup
+	shardMetrics *sync.Map
+
+	// throttledRequests counts the throttled calls per AWS API of the worker
+	throttleMux       sync.Mutex
+	throttledRequests map[string]int64
+
+	// leaseTableCapacity sums the capacity of the lease table consumed per operation of the worker
+	capacityMux        sync.Mutex
+	leaseTableCapacity map[string]*consumedCapacity
+
+	// maxBehindLatestMillis are the max MillisBehindLatest of the shards of the worker since the last flush
+	behindLatestMux       sync.Mutex
+	maxBehindLatestMillis []float64
+}
+
+// consumedCapacity are the capacity units consumed by the calls of an operation
+type consumedCapacity struct {
+	read, write float64
+}
+
+type emfMetrics struct {
+	sync.Mutex
+
+	processedRecords   int64
+	processedBytes     int64
+	behindLatestMillis []float64
+	leasesHeld         int64
+	leaseRenewals      int64
+	checkpointErrors   int64
+	checkpointTime     []float64
+	leaseContentions   int64
+	conditionalChecks  int64
+	processorPanics    int64
+	getRecordsTime     []float64
+	processRecordsTime []float64
+
+	// recordsRead, bytesRead and getRecordsLatency are those of the GetRecords calls, before de-aggregation
+	recordsRead        int64
+	bytesRead          int64
+	getRecordsLatency  []float64
+	getRecordsThrottle int64
+
+	// checkpointAgeMillis are the checkpoint ages of the shard since the last flush
+	checkpointAgeMillis []float64
+	alarmsRaised        int64
+}
+
+// metricDirective tells CloudWatch Logs which members of a document are metrics
+type metricDirective struct {
+	Namespace  string             `json:"Namespace"`
+	Dimensions [][]string         `json:"Dimensions"`
+	Metrics    []metricDefinition `json:"Metrics"`
+}
+
+type metricDefinition struct {
+	Name string `json:"Name"`
+	Unit string `json:"Unit"`
+}
+
+type metadata struct {
+	Timestamp         int64             `json:"Timestamp"`
+	CloudWatchMetrics []metricDirective `json:"CloudWatchMetrics"`
+}
+
+// NewMonitoringService returns a Monitoring service writing EMF documents to stdout.
+func NewMonitoringService() *MonitoringService {
+	return NewMonitoringServiceWithOptions(os.Stdout, logger.GetDefaultLogger(), DefaultEMFMetricsBufferDuration)
+}
+
+// NewMonitoringServiceWithOptions returns a Monitoring service writing EMF documents to the provided writer
+// with the provided buffering duration and logger.
+func NewMonitoringServiceWithOptions(out io.Writer, logger logger.Logger, bufferDur time.Duration) *MonitoringService {
+	return &MonitoringService{
+		out:            out,
+		logger:         logger,
+		bufferDuration: bufferDur,
+	}
+}
+
+// WithDimensions adds the static dimensions to all metrics, e.g. the environment or the service of the application.
+func (e *MonitoringService) WithDimensions(dimensions map[string]string) *MonitoringService {
+	if e.dimensions == nil {
+		e.dimensions = make(map[string]string, len(dimensions))
+	}
+	for name, value := range dimensions {
+		e.dimensions[name] = value
+	}
+	return e
+}
+
+func (e *MonitoringService) Init(appName, streamName, workerID string) error {
+	e.appName = appName
+	e.streamName = streamName
+	e.workerID = workerID
+
+	e.shardMetrics = &sync.Map{}
+	e.throttledRequests = map[string]int64{}
+	e.leaseTableCapacity = map[string]*consumedCapacity{}
+
+	stopChan := make(chan struct{})
+	e.stop = &stopChan
+	wg := sync.WaitGroup{}
+	e.waitGroup = &wg
+
+	return nil
+}
+
+func (e *MonitoringService) Start() error {
+	e.waitGroup.Add(1)
+	// entering eventloop for writing metrics
+	go e.eventloop()
+	return nil
+}
+
+func (e *MonitoringService) Shutdown() {
+	e.logger.Infof("Shutting down EMF metrics system...")
+	close(*e.stop)
+	e.waitGroup.Wait()
+	e.logger.Infof("EMF metrics system has been shutdown.")
+}
+
+// eventloop start daemon to flush metrics periodically
+func (e *MonitoringService) eventloop() {
+	defer e.waitGroup.Done()
+
+	for {
+		e.flush()
+
+		select {
+		case <-*e.stop:
+			e.logger.Infof("Shutting down monitoring system")
+			e.flush()
+			return
+		case <-time.After(e.bufferDuration):
+		}
+	}
+}
+
+// flush writes one document per shard, all metrics of a shard are batched in the same document
+func (e *MonitoringService) flush() {
+	e.logger.Debugf("Flushing metrics data. Stream: %s, Worker: %s", e.streamName, e.workerID)
+	e.shardMetrics.Range(func(k, v interface{}) bool {
+		shard, metric := k.(string), v.(*emfMetrics)
+		e.flushShard(shard, metric)
+		return true
+	})
+	e.flushThrottledRequests()
+	e.flushLeaseTableCapacity()
+	e.flushMaxBehindLatest()
+}
+
+// flushMaxBehindLatest writes the document of the max MillisBehindLatest of the shards of the worker
+func (e *MonitoringService) flushMaxBehindLatest() {
+	e.behindLatestMux.Lock()
+	defer e.behindLatestMux.Unlock()
+
+	if len(e.maxBehindLatestMillis) == 0 {
+		return
+	}
+	doc := map[string]interface{}{
+		"KinesisStreamName":     e.streamName,
+		"WorkerID":              e.workerID,
+		"MaxMillisBehindLatest": e.maxBehindLatestMillis,
+		"_aws": metadata{
+			Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+			CloudWatchMetrics: []metricDirective{
+				{
+					Namespace:  e.appName,
+					Dimensions: e.dimensionSet("KinesisStreamName", "WorkerID"),
+					Metrics:    []metricDefinition{{Name: "MaxMillisBehindLatest", Unit: unitMilliseconds}},
+				},
+			},
+		},
+	}
+	if e.write(doc) {
+		e.maxBehindLatestMillis = []float64{}
+	}
+}
+
+// flushThrottledRequests writes one document per throttled AWS API of the worker
+func (e *MonitoringService) flushThrottledRequests() {
+	e.throttleMux.Lock()
+	defer e.throttleMux.Unlock()
+
+	for api, count := range e.throttledRequests {
+		doc := map[string]interface{}{
+			"API":               api,
+			"KinesisStreamName": e.streamName,
+			"WorkerID":          e.workerID,
+			"ThrottledRequests": count,
+			"_aws": metadata{
+				Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+				CloudWatchMetrics: []metricDirective{
+					{
+						Namespace:  e.appName,
+						Dimensions: e.dimensionSet("API", "KinesisStreamName", "WorkerID"),
+						Metrics:    []metricDefinition{{Name: "ThrottledRequests", Unit: unitCount}},
+					},
+				},
+			},
+		}
+		if e.write(doc) {
+			delete(e.throttledRequests, api)
+		}
+	}
+}
+
+// flushLeaseTableCapacity writes one document per operation of the worker on the lease table
+func (e *MonitoringService) flushLeaseTableCapacity() {
+	e.capacityMux.Lock()
+	defer e.capacityMux.Unlock()
+
+	for operation, capacity := range e.leaseTableCapacity {
+		doc := map[string]interface{}{
+			"Operation":                            operation,
+			"KinesisStreamName":                    e.streamName,
+			"WorkerID":                             e.workerID,
+			"LeaseTableConsumedReadCapacityUnits":  capacity.read,
+			"LeaseTableConsumedWriteCapacityUnits": capacity.write,
+			"_aws": metadata{
+				Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+				CloudWatchMetrics: []metricDirective{
+					{
+						Namespace:  e.appName,
+						Dimensions: e.dimensionSet("Operation", "KinesisStreamName", "WorkerID"),
+						Metrics: []metricDefinition{
+							{Name: "LeaseTableConsumedReadCapacityUnits", Unit: unitCount},
+							{Name: "LeaseTableConsumedWriteCapacityUnits", Unit: unitCount},
+						},
+					},
+				},
+			},
+		}
+		if e.write(doc) {
+			delete(e.leaseTableCapacity, operation)
+		}
+	}
+}
+
+func (e *MonitoringService) flushShard(shard string, metric *emfMetrics) {
+	metric.Lock()
+	defer metric.Unlock()
+
+	defaultMetrics := []metricDefinition{
+		{Name: "RecordsProcessed", Unit: unitCount},
+		{Name: "DataBytesProcessed", Unit: unitBytes},
+		{Name: "KinesisDataFetcher.getRecords.RecordsRead", Unit: unitCount},
+		{Name: "KinesisDataFetcher.getRecords.BytesRead", Unit: unitBytes},
+		{Name: "KinesisDataFetcher.getRecords.Throttles", Unit: unitCount},
+		{Name: "Alarms.Raised", Unit: unitCount},
+	}
+	leaseMetrics := []metricDefinition{
+		{Name: "RenewLease.Success", Unit: unitCount},
+		{Name: "CurrentLeases", Unit: unitCount},
+		{Name: "Checkpoint.Errors", Unit: unitCount},
+		{Name: "Lease.Contentions", Unit: unitCount},
+		{Name: "ConditionalCheck.Failures", Unit: unitCount},
+		{Name: "Processor.Panics", Unit: unitCount},
+	}
+
+	doc := map[string]interface{}{
+		"Shard":                     shard,
+		"KinesisStreamName":         e.streamName,
+		"WorkerID":                  e.workerID,
+		"RecordsProcessed":          metric.processedRecords,
+		"DataBytesProcessed":        metric.processedBytes,
+		"RenewLease.Success":        metric.leaseRenewals,
+		"CurrentLeases":             metric.leasesHeld,
+		"Checkpoint.Errors":         metric.checkpointErrors,
+		"Lease.Contentions":         metric.leaseContentions,
+		"ConditionalCheck.Failures": metric.conditionalChecks,
+		"Processor.Panics":          metric.processorPanics,
+
+		"KinesisDataFetcher.getRecords.RecordsRead": metric.recordsRead,
+		"KinesisDataFetcher.getRecords.BytesRead":   metric.bytesRead,
+		"KinesisDataFetcher.getRecords.Throttles":   metric.getRecordsThrottle,
+		"Alarms.Raised": metric.alarmsRaised,
+	}
+
+	// distributions are published as arrays of values
+	if len(metric.behindLatestMillis) > 0 {
+		defaultMetrics = append(defaultMetrics, metricDefinition{Name: "MillisBehindLatest", Unit: unitMilliseconds})
+		doc["MillisBehindLatest"] = metric.behindLatestMillis
+	}
+	if len(metric.checkpointAgeMillis) > 0 {
+		defaultMetrics = append(defaultMetrics, metricDefinition{Name: "CheckpointAge", Unit: unitMilliseconds})
+		doc["CheckpointAge"] = metric.checkpointAgeMillis
+	}
+	if len(metric.checkpointTime) > 0 {
+		defaultMetrics = append(defaultMetrics, metricDefinition{Name: "Checkpoint.Time", Unit: unitMilliseconds})
+		doc["Checkpoint.Time"] = metric.checkpointTime
+	}
+	if len(metric.getRecordsTime) > 0 {
+		defaultMetrics = append(defaultMetrics, metricDefinition{Name: "KinesisDataFetcher.getRecords.Time", Unit: unitMilliseconds})
+		doc["KinesisDataFetcher.getRecords.Time"] = metric.getRecordsTime
+	}
+	if len(metric.getRecordsLatency) > 0 {
+		defaultMetrics = append(defaultMetrics, metricDefinition{Name: "KinesisDataFetcher.getRecords.Latency", Unit: unitMilliseconds})
+		doc["KinesisDataFetcher.getRecords.Latency"] = metric.getRecordsLatency
+	}
+	if len(metric.processRecordsTime) > 0 {
+		defaultMetrics = append(defaultMetrics, metricDefinition{Name: "RecordProcessor.processRecords.Time", Unit: unitMilliseconds})
+		doc["RecordProcessor.processRecords.Time"] = metric.processRecordsTime
+	}
+
+	doc["_aws"] = metadata{
+		Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+		CloudWatchMetrics: []metricDirective{
+			{
+				Namespace:  e.appName,
+				Dimensions: e.dimensionSet("Shard", "KinesisStreamName"),
+				Metrics:    defaultMetrics,
+			},
+			{
+				Namespace:  e.appName,
+				Dimensions: e.dimensionSet("Shard", "KinesisStreamName", "WorkerID"),
+				Metrics:    leaseMetrics,
+			},
+		},
+	}
+
+	if !e.write(doc) {
+		return
+	}
+
+	metric.processedRecords = 0
+	metric.processedBytes = 0
+	metric.behindLatestMillis = []float64{}
+	metric.leaseRenewals = 0
+	metric.checkpointErrors = 0
+	metric.checkpointTime = []float64{}
+	metric.leaseContentions = 0
+	metric.conditionalChecks = 0
+	metric.processorPanics = 0
+	metric.getRecordsTime = []float64{}
+	metric.processRecordsTime = []float64{}
+	metric.recordsRead = 0
+	metric.bytesRead = 0
+	metric.getRecordsLatency = []float64{}
+	metric.getRecordsThrottle = 0
+	metric.checkpointAgeMillis = []float64{}
+	metric.alarmsRaised = 0
+}
+
+// dimensionSet returns the dimension set of the dimensions with the static dimensions
+func (e *MonitoringService) dimensionSet(dimensions ...string) [][]string {
+	names := make([]string, 0, len(e.dimensions))
+	for name := range e.dimensions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return [][]string{append(dimensions, names...)}
+}
+
+// write writes a document on a single line with the values of the static dimensions and reports whether it
+// succeeded
+func (e *MonitoringService) write(doc map[string]interface{}) bool {
+	for name, value := range e.dimensions {
+		doc[name] = value
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		e.logger.Errorf("Error in encoding EMF metrics. Error: %+v", err)
+		return false
+	}
+
+	e.mux.Lock()
+	_, err = e.out.Write(append(data, '\n'))
+	e.mux.Unlock()
+	if err != nil {
+		e.logger.Errorf("Error in writing EMF metrics. Error: %+v", err)
+		return false
+	}
+	return true
+}
+
+func (e *MonitoringService) IncrRecordsProcessed(shard string, count int) {
+	m := e.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.processedRecords += int64(count)
+}
+
+func (e *MonitoringService) IncrBytesProcessed(shard string, count int64) {
+	m := e.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.processedBytes += count
+}
+
+func (e *MonitoringService) MillisBehindLatest(shard string, millSeconds float64) {
+	m := e.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.behindLatestMillis = append(m.behindLatestMillis, millSeconds)
+}
+
+func (e *MonitoringService) DeleteMetricMillisBehindLatest(shard string) {
+	m := e.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.behindLatestMillis = []float64{}
+}
+
+func (e *MonitoringService) MaxMillisBehindLatest(millSeconds float64) {
+	e.behindLatestMux.Lock()
+	defer e.behindLatestMux.Unlock()
+	e.maxBehindLatestMillis = append(e.maxBehindLatestMillis, millSeconds)
+}
+
+func (e *MonitoringService) LeaseGained(shard string) {
+	m := e.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.leasesHeld++
+}
+
+func (e *MonitoringService) LeaseLost(shard string) {
+	m := e.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.leasesHeld--
+}
+
+func (e *MonitoringService) LeaseRenewed(shard string) {
+	m := e.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.leaseRenewals++
+}
+
+func (e *MonitoringService) IncrCheckpointErrors(shard string) {
+	m := e.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.checkpointErrors++
+}
+
+func (e *MonitoringService) RecordCheckpointTime(shard string, time float64) {
+	m := e.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.checkpointTime = append(m.checkpointTime, time)
+}
+
+func (e *MonitoringService) IncrLeaseContentions(shard string) {
+	m := e.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.leaseContentions++
+}
+
+func (e *MonitoringService) IncrConditionalCheckFailures(shard string) {
+	m := e.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.conditionalChecks++
+}
+
+func (e *MonitoringService) IncrProcessorPanics(shard string) {
+	m := e.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.processorPanics++
+}
+
+func (e *MonitoringService) IncrThrottledRequests(api string) {
+	e.throttleMux.Lock()
+	defer e.throttleMux.Unlock()
+	e.throttledRequests[api]++
+}
+
+// IncrLeaseTableConsumedCapacity is called by the DynamoDB checkpointer, which is also used without Init by the
+// admin and snapshot packages
+func (e *MonitoringService) IncrLeaseTableConsumedCapacity(operation string, readCapacityUnits, writeCapacityUnits float64) {
+	e.capacityMux.Lock()
+	defer e.capacityMux.Unlock()
+	if e.leaseTableCapacity == nil {
+		e.leaseTableCapacity = map[string]*consumedCapacity{}
+	}
+	capacity, ok := e.leaseTableCapacity[operation]
+	if !ok {
+		capacity = &consumedCapacity{}
+		e.leaseTableCapacity[operation] = capacity
+	}
+	capacity.read += readCapacityUnits
+	capacity.write += writeCapacityUnits
+}
+
+func (e *MonitoringService) RecordGetRecordsTime(shard string, time float64) {
+	m := e.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.getRecordsTime = append(m.getRecordsTime, time)
+}
+
+func (e *MonitoringService) RecordGetRecordsCall(shard string, recordCount int, bytesRead int64, latency float64) {
+	m := e.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.recordsRead += int64(recordCount)
+	m.bytesRead += bytesRead
+	m.getRecordsLatency = append(m.getRecordsLatency, latency)
+}
+
+func (e *MonitoringService) IncrGetRecordsThrottles(shard string) {
+	m := e.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.getRecordsThrottle++
+}
+
+func (e *MonitoringService) RecordProcessRecordsTime(shard string, time float64) {
+	m := e.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.processRecordsTime = append(m.processRecordsTime, time)
+}
+
+func (e *MonitoringService) CheckpointAge(shard string, milliSeconds float64) {
+	m := e.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.checkpointAgeMillis = append(m.checkpointAgeMillis, milliSeconds)
+}
+
+func (e *MonitoringService) DeleteMetricCheckpointAge(shard string) {
+	m := e.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.checkpointAgeMillis = []float64{}
+}
+
+func (e *MonitoringService) IncrAlarmsRaised(shard string) {
+	m := e.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.alarmsRaised++
+}
+
+func (e *MonitoringService) getOrCreatePerShardMetrics(shard string) *emfMetrics {
+	i, _ := e.shardMetrics.LoadOrStore(shard, &emfMetrics{})
+	return i.(*emfMetrics)
+}
@@ -0,0 +1,151 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package emf
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/vmware-go-kcl-v2/logger"
+)
+
+func TestFlushShardDocument(t *testing.T) {
+	out := &bytes.Buffer{}
+	e := NewMonitoringServiceWithOptions(out, logger.GetDefaultLogger(), time.Hour)
+	assert.Nil(t, e.Init("app", "stream", "worker"))
+
+	e.IncrRecordsProcessed("0001", 10)
+	e.IncrRecordsProcessed("0001", 5)
+	e.MillisBehindLatest("0001", 100)
+	e.MillisBehindLatest("0001", 50)
+	e.LeaseGained("0001")
+	e.RecordCheckpointTime("0001", 12)
+	e.IncrLeaseContentions("0001")
+	e.IncrConditionalCheckFailures("0001")
+	e.RecordGetRecordsCall("0001", 3, 300, 20)
+	e.IncrGetRecordsThrottles("0001")
+	e.CheckpointAge("0001", 2000)
+	e.IncrAlarmsRaised("0001")
+	e.flush()
+
+	var doc map[string]interface{}
+	assert.Nil(t, json.Unmarshal(out.Bytes(), &doc))
+	assert.Equal(t, "0001", doc["Shard"])
+	assert.Equal(t, "stream", doc["KinesisStreamName"])
+	assert.Equal(t, "worker", doc["WorkerID"])
+	assert.Equal(t, float64(15), doc["RecordsProcessed"])
+	assert.Equal(t, []interface{}{float64(100), float64(50)}, doc["MillisBehindLatest"])
+	assert.Equal(t, float64(1), doc["CurrentLeases"])
+	assert.Equal(t, []interface{}{float64(12)}, doc["Checkpoint.Time"])
+	assert.Equal(t, float64(1), doc["Lease.Contentions"])
+	assert.Equal(t, float64(1), doc["ConditionalCheck.Failures"])
+	assert.Equal(t, float64(3), doc["KinesisDataFetcher.getRecords.RecordsRead"])
+	assert.Equal(t, float64(300), doc["KinesisDataFetcher.getRecords.BytesRead"])
+	assert.Equal(t, float64(1), doc["KinesisDataFetcher.getRecords.Throttles"])
+	assert.Equal(t, []interface{}{float64(20)}, doc["KinesisDataFetcher.getRecords.Latency"])
+	assert.Equal(t, []interface{}{float64(2000)}, doc["CheckpointAge"])
+	assert.Equal(t, float64(1), doc["Alarms.Raised"])
+
+	directives := doc["_aws"].(map[string]interface{})["CloudWatchMetrics"].([]interface{})
+	assert.Equal(t, 2, len(directives))
+	assert.Equal(t, "app", directives[0].(map[string]interface{})["Namespace"])
+
+	// counters are reset after the flush, the held leases are kept
+	out.Reset()
+	e.flush()
+	doc = map[string]interface{}{}
+	assert.Nil(t, json.Unmarshal(out.Bytes(), &doc))
+	assert.Equal(t, float64(0), doc["RecordsProcessed"])
+	assert.Nil(t, doc["MillisBehindLatest"])
+	assert.Nil(t, doc["Checkpoint.Time"])
+	assert.Equal(t, float64(0), doc["Lease.Contentions"])
+	assert.Equal(t, float64(1), doc["CurrentLeases"])
+}
+
+func TestDimensions(t *testing.T) {
+	out := &bytes.Buffer{}
+	e := NewMonitoringServiceWithOptions(out, logger.GetDefaultLogger(), time.Hour).
+		WithDimensions(map[string]string{"Service": "orders", "Environment": "prod"})
+	assert.Nil(t, e.Init("namespace", "stream", "worker"))
+
+	e.MaxMillisBehindLatest(100)
+	e.flush()
+
+	var doc map[string]interface{}
+	assert.Nil(t, json.Unmarshal(out.Bytes(), &doc))
+	assert.Equal(t, "prod", doc["Environment"])
+	assert.Equal(t, "orders", doc["Service"])
+
+	directive := doc["_aws"].(map[string]interface{})["CloudWatchMetrics"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "namespace", directive["Namespace"])
+	assert.Equal(t, []interface{}{[]interface{}{"KinesisStreamName", "WorkerID", "Environment", "Service"}}, directive["Dimensions"])
+}
+
+func TestFlushThrottledRequestsDocument(t *testing.T) {
+	out := &bytes.Buffer{}
+	e := NewMonitoringServiceWithOptions(out, logger.GetDefaultLogger(), time.Hour)
+	assert.Nil(t, e.Init("app", "stream", "worker"))
+
+	e.IncrThrottledRequests("Kinesis.GetRecords")
+	e.IncrThrottledRequests("Kinesis.GetRecords")
+	e.flush()
+
+	var doc map[string]interface{}
+	assert.Nil(t, json.Unmarshal(out.Bytes(), &doc))
+	assert.Equal(t, "Kinesis.GetRecords", doc["API"])
+	assert.Equal(t, "worker", doc["WorkerID"])
+	assert.Equal(t, float64(2), doc["ThrottledRequests"])
+
+	// nothing is written once the throttled requests have been published
+	out.Reset()
+	e.flush()
+	assert.Equal(t, 0, out.Len())
+}
+
+func TestFlushMaxBehindLatestDocument(t *testing.T) {
+	out := &bytes.Buffer{}
+	e := NewMonitoringServiceWithOptions(out, logger.GetDefaultLogger(), time.Hour)
+	assert.Nil(t, e.Init("app", "stream", "worker"))
+
+	e.MaxMillisBehindLatest(100)
+	e.MaxMillisBehindLatest(50)
+	e.flush()
+
+	var doc map[string]interface{}
+	assert.Nil(t, json.Unmarshal(out.Bytes(), &doc))
+	assert.Equal(t, "worker", doc["WorkerID"])
+	assert.Equal(t, []interface{}{float64(100), float64(50)}, doc["MaxMillisBehindLatest"])
+
+	// nothing is written once the max lag has been published
+	out.Reset()
+	e.flush()
+	assert.Equal(t, 0, out.Len())
+}
+
+func TestLeaseTableCapacityWithoutInit(t *testing.T) {
+	// the checkpointers of the admin and snapshot packages report the capacity without initialising the service
+	e := NewMonitoringServiceWithOptions(&bytes.Buffer{}, logger.GetDefaultLogger(), time.Hour)
+	e.IncrLeaseTableConsumedCapacity("GetItem", 0.5, 0)
+	assert.Equal(t, 0.5, e.leaseTableCapacity["GetItem"].read)
+}
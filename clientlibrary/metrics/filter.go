@@ -0,0 +1,223 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package metrics
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+// Metric names a metric of the MonitoringService which can be disabled, the names are those of the CloudWatch
+// metrics.
+type Metric string
+
+const (
+	RecordsProcessedMetric           Metric = "RecordsProcessed"
+	DataBytesProcessedMetric         Metric = "DataBytesProcessed"
+	MillisBehindLatestMetric         Metric = "MillisBehindLatest"
+	MaxMillisBehindLatestMetric      Metric = "MaxMillisBehindLatest"
+	CurrentLeasesMetric              Metric = "CurrentLeases"
+	RenewLeaseMetric                 Metric = "RenewLease.Success"
+	CheckpointErrorsMetric           Metric = "Checkpoint.Errors"
+	CheckpointTimeMetric             Metric = "Checkpoint.Time"
+	LeaseContentionsMetric           Metric = "Lease.Contentions"
+	ConditionalCheckFailuresMetric   Metric = "ConditionalCheck.Failures"
+	ProcessorPanicsMetric            Metric = "Processor.Panics"
+	ThrottledRequestsMetric          Metric = "ThrottledRequests"
+	LeaseTableConsumedCapacityMetric Metric = "LeaseTableConsumedCapacityUnits"
+	GetRecordsTimeMetric             Metric = "KinesisDataFetcher.getRecords.Time"
+	GetRecordsCallMetric             Metric = "KinesisDataFetcher.getRecords"
+	GetRecordsThrottlesMetric        Metric = "KinesisDataFetcher.getRecords.Throttles"
+	ProcessRecordsTimeMetric         Metric = "RecordProcessor.processRecords.Time"
+	CheckpointAgeMetric              Metric = "CheckpointAge"
+	AlarmsRaisedMetric               Metric = "Alarms.Raised"
+)
+
+// filteredMonitoringService drops the disabled metrics and the per-shard metrics of the shards not sampled before
+// they are reported to the wrapped MonitoringService. The metrics of the extensions the wrapped MonitoringService
+// does not implement are dropped as well.
+type filteredMonitoringService struct {
+	MonitoringService
+
+	disabled         map[Metric]bool
+	shardSampleRatio float64
+}
+
+// NewFilteredMonitoringService returns a MonitoringService reporting the metrics to the service except the
+// disabled metrics. The per-shard metrics of the record processing, i.e. the processed records and bytes,
+// MillisBehindLatest, the checkpoint time and age and the GetRecords and ProcessRecords metrics, are reported for
+// the fraction shardSampleRatio of the shards only, to reduce the number of metrics of streams with many shards.
+// The shards are sampled by the hash of their id, so a shard is either always or never reported. The lease, error
+// and alarm metrics are reported for all shards, as are all metrics with a ratio of 0 or 1.
+func NewFilteredMonitoringService(service MonitoringService, disabled []Metric, shardSampleRatio float64) MonitoringService {
+	f := &filteredMonitoringService{
+		MonitoringService: service,
+		disabled:          make(map[Metric]bool, len(disabled)),
+		shardSampleRatio:  shardSampleRatio,
+	}
+	for _, m := range disabled {
+		f.disabled[m] = true
+	}
+	return f
+}
+
+// enabled reports whether the metric is reported
+func (f *filteredMonitoringService) enabled(m Metric) bool {
+	return !f.disabled[m]
+}
+
+// sampled reports whether the per-shard metric of the record processing is reported for the shard
+func (f *filteredMonitoringService) sampled(m Metric, shard string) bool {
+	if !f.enabled(m) {
+		return false
+	}
+	if f.shardSampleRatio <= 0 || f.shardSampleRatio >= 1 {
+		return true
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(shard))
+	return float64(h.Sum32()) < f.shardSampleRatio*math.MaxUint32
+}
+
+func (f *filteredMonitoringService) IncrRecordsProcessed(shard string, count int) {
+	if f.sampled(RecordsProcessedMetric, shard) {
+		f.MonitoringService.IncrRecordsProcessed(shard, count)
+	}
+}
+
+func (f *filteredMonitoringService) IncrBytesProcessed(shard string, count int64) {
+	if f.sampled(DataBytesProcessedMetric, shard) {
+		f.MonitoringService.IncrBytesProcessed(shard, count)
+	}
+}
+
+func (f *filteredMonitoringService) MillisBehindLatest(shard string, milliSeconds float64) {
+	if f.sampled(MillisBehindLatestMetric, shard) {
+		f.MonitoringService.MillisBehindLatest(shard, milliSeconds)
+	}
+}
+
+func (f *filteredMonitoringService) MaxMillisBehindLatest(milliSeconds float64) {
+	if m, ok := f.MonitoringService.(LagMonitoringService); ok && f.enabled(MaxMillisBehindLatestMetric) {
+		m.MaxMillisBehindLatest(milliSeconds)
+	}
+}
+
+func (f *filteredMonitoringService) LeaseGained(shard string) {
+	if f.enabled(CurrentLeasesMetric) {
+		f.MonitoringService.LeaseGained(shard)
+	}
+}
+
+func (f *filteredMonitoringService) LeaseLost(shard string) {
+	if f.enabled(CurrentLeasesMetric) {
+		f.MonitoringService.LeaseLost(shard)
+	}
+}
+
+func (f *filteredMonitoringService) LeaseRenewed(shard string) {
+	if f.enabled(RenewLeaseMetric) {
+		f.MonitoringService.LeaseRenewed(shard)
+	}
+}
+
+func (f *filteredMonitoringService) IncrCheckpointErrors(shard string) {
+	if m, ok := f.MonitoringService.(CheckpointMonitoringService); ok && f.enabled(CheckpointErrorsMetric) {
+		m.IncrCheckpointErrors(shard)
+	}
+}
+
+func (f *filteredMonitoringService) RecordCheckpointTime(shard string, time float64) {
+	if m, ok := f.MonitoringService.(CheckpointMonitoringService); ok && f.sampled(CheckpointTimeMetric, shard) {
+		m.RecordCheckpointTime(shard, time)
+	}
+}
+
+func (f *filteredMonitoringService) IncrLeaseContentions(shard string) {
+	if m, ok := f.MonitoringService.(LeaseTableMonitoringService); ok && f.enabled(LeaseContentionsMetric) {
+		m.IncrLeaseContentions(shard)
+	}
+}
+
+func (f *filteredMonitoringService) IncrConditionalCheckFailures(shard string) {
+	if m, ok := f.MonitoringService.(LeaseTableMonitoringService); ok && f.enabled(ConditionalCheckFailuresMetric) {
+		m.IncrConditionalCheckFailures(shard)
+	}
+}
+
+func (f *filteredMonitoringService) IncrProcessorPanics(shard string) {
+	if m, ok := f.MonitoringService.(ProcessorPanicMonitoringService); ok && f.enabled(ProcessorPanicsMetric) {
+		m.IncrProcessorPanics(shard)
+	}
+}
+
+func (f *filteredMonitoringService) IncrThrottledRequests(api string) {
+	if m, ok := f.MonitoringService.(ThrottlingMonitoringService); ok && f.enabled(ThrottledRequestsMetric) {
+		m.IncrThrottledRequests(api)
+	}
+}
+
+func (f *filteredMonitoringService) IncrLeaseTableConsumedCapacity(operation string, readCapacityUnits, writeCapacityUnits float64) {
+	if m, ok := f.MonitoringService.(LeaseTableMonitoringService); ok && f.enabled(LeaseTableConsumedCapacityMetric) {
+		m.IncrLeaseTableConsumedCapacity(operation, readCapacityUnits, writeCapacityUnits)
+	}
+}
+
+func (f *filteredMonitoringService) RecordGetRecordsTime(shard string, time float64) {
+	if f.sampled(GetRecordsTimeMetric, shard) {
+		f.MonitoringService.RecordGetRecordsTime(shard, time)
+	}
+}
+
+func (f *filteredMonitoringService) RecordGetRecordsCall(shard string, recordCount int, bytesRead int64, latency float64) {
+	if m, ok := f.MonitoringService.(GetRecordsMonitoringService); ok && f.sampled(GetRecordsCallMetric, shard) {
+		m.RecordGetRecordsCall(shard, recordCount, bytesRead, latency)
+	}
+}
+
+func (f *filteredMonitoringService) IncrGetRecordsThrottles(shard string) {
+	if m, ok := f.MonitoringService.(GetRecordsMonitoringService); ok && f.enabled(GetRecordsThrottlesMetric) {
+		m.IncrGetRecordsThrottles(shard)
+	}
+}
+
+func (f *filteredMonitoringService) RecordProcessRecordsTime(shard string, time float64) {
+	if f.sampled(ProcessRecordsTimeMetric, shard) {
+		f.MonitoringService.RecordProcessRecordsTime(shard, time)
+	}
+}
+
+func (f *filteredMonitoringService) CheckpointAge(shard string, milliSeconds float64) {
+	if m, ok := f.MonitoringService.(AlarmMonitoringService); ok && f.sampled(CheckpointAgeMetric, shard) {
+		m.CheckpointAge(shard, milliSeconds)
+	}
+}
+
+func (f *filteredMonitoringService) DeleteMetricCheckpointAge(shard string) {
+	if m, ok := f.MonitoringService.(AlarmMonitoringService); ok {
+		m.DeleteMetricCheckpointAge(shard)
+	}
+}
+
+func (f *filteredMonitoringService) IncrAlarmsRaised(shard string) {
+	if m, ok := f.MonitoringService.(AlarmMonitoringService); ok && f.enabled(AlarmsRaisedMetric) {
+		m.IncrAlarmsRaised(shard)
+	}
+}
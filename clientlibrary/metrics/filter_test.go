@@ -0,0 +1,90 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package metrics
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingService counts the processed records and lease renewals per shard
+type recordingService struct {
+	NoopMonitoringService
+
+	processedRecords map[string]int
+	leaseRenewals    map[string]int
+	maxBehindLatest  []float64
+}
+
+func (r *recordingService) IncrRecordsProcessed(shard string, count int) {
+	r.processedRecords[shard] += count
+}
+
+func (r *recordingService) LeaseRenewed(shard string) {
+	r.leaseRenewals[shard]++
+}
+
+func (r *recordingService) MaxMillisBehindLatest(milliSeconds float64) {
+	r.maxBehindLatest = append(r.maxBehindLatest, milliSeconds)
+}
+
+func TestFilteredMonitoringServiceDisabledMetrics(t *testing.T) {
+	r := &recordingService{processedRecords: map[string]int{}, leaseRenewals: map[string]int{}}
+	f := NewFilteredMonitoringService(r, []Metric{RecordsProcessedMetric, MaxMillisBehindLatestMetric}, 1)
+
+	f.IncrRecordsProcessed("0001", 10)
+	f.LeaseRenewed("0001")
+	f.(LagMonitoringService).MaxMillisBehindLatest(100)
+
+	assert.Equal(t, 0, len(r.processedRecords))
+	assert.Equal(t, 1, r.leaseRenewals["0001"])
+	assert.Equal(t, 0, len(r.maxBehindLatest))
+}
+
+func TestFilteredMonitoringServiceShardSampling(t *testing.T) {
+	r := &recordingService{processedRecords: map[string]int{}, leaseRenewals: map[string]int{}}
+	f := NewFilteredMonitoringService(r, nil, 0.25)
+
+	for i := 0; i < 1000; i++ {
+		shard := fmt.Sprintf("shardId-%012d", i)
+		f.IncrRecordsProcessed(shard, 1)
+		f.IncrRecordsProcessed(shard, 1)
+		f.LeaseRenewed(shard)
+	}
+
+	// a sampled shard reports all its records, the lease metrics are not sampled
+	assert.InDelta(t, 250, len(r.processedRecords), 50)
+	for _, count := range r.processedRecords {
+		assert.Equal(t, 2, count)
+	}
+	assert.Equal(t, 1000, len(r.leaseRenewals))
+}
+
+func TestFilteredMonitoringServiceWithoutExtensions(t *testing.T) {
+	// the metrics of the extensions are dropped for a MonitoringService implementing none of them
+	f := NewFilteredMonitoringService(struct{ MonitoringService }{NoopMonitoringService{}}, nil, 1)
+	assert.NotPanics(t, func() {
+		f.(LagMonitoringService).MaxMillisBehindLatest(100)
+		f.(CheckpointMonitoringService).IncrCheckpointErrors("0001")
+		f.(AlarmMonitoringService).IncrAlarmsRaised("0001")
+	})
+}
@@ -29,6 +29,8 @@
 // THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
 package metrics
 
+// MonitoringService reports the metrics of the worker. The metrics added since are reported through the optional
+// extension interfaces below, which a MonitoringService implements as it supports them.
 type MonitoringService interface {
 	Init(appName, streamName, workerID string) error
 	Start() error
@@ -44,19 +46,73 @@ type MonitoringService interface {
 	Shutdown()
 }
 
-// NoopMonitoringService implements MonitoringService by does nothing.
+// LagMonitoringService reports the max lag of the shards consumed by the worker
+type LagMonitoringService interface {
+	MaxMillisBehindLatest(milliSeconds float64)
+}
+
+// CheckpointMonitoringService reports the failed checkpoints and the latency of the checkpoints
+type CheckpointMonitoringService interface {
+	IncrCheckpointErrors(shard string)
+	RecordCheckpointTime(shard string, time float64)
+}
+
+// LeaseTableMonitoringService reports the contention on the leases and the capacity consumed in the lease table
+type LeaseTableMonitoringService interface {
+	IncrLeaseContentions(shard string)
+	IncrConditionalCheckFailures(shard string)
+	IncrLeaseTableConsumedCapacity(operation string, readCapacityUnits, writeCapacityUnits float64)
+}
+
+// ProcessorPanicMonitoringService reports the panics recovered from the record processors
+type ProcessorPanicMonitoringService interface {
+	IncrProcessorPanics(shard string)
+}
+
+// ThrottlingMonitoringService reports the AWS requests throttled by the services, by service and operation
+type ThrottlingMonitoringService interface {
+	IncrThrottledRequests(api string)
+}
+
+// GetRecordsMonitoringService reports the reads and the throttles of the GetRecords calls
+type GetRecordsMonitoringService interface {
+	RecordGetRecordsCall(shard string, recordCount int, bytesRead int64, latency float64)
+	IncrGetRecordsThrottles(shard string)
+}
+
+// AlarmMonitoringService reports the checkpoint age evaluated by the alarms and the alarms raised
+type AlarmMonitoringService interface {
+	CheckpointAge(shard string, milliSeconds float64)
+	DeleteMetricCheckpointAge(shard string)
+	IncrAlarmsRaised(shard string)
+}
+
+// NoopMonitoringService implements MonitoringService and its extensions by does nothing.
 type NoopMonitoringService struct{}
 
 func (NoopMonitoringService) Init(_, _, _ string) error { return nil }
 func (NoopMonitoringService) Start() error              { return nil }
 func (NoopMonitoringService) Shutdown()                 {}
 
-func (NoopMonitoringService) IncrRecordsProcessed(_ string, _ int)         {}
-func (NoopMonitoringService) IncrBytesProcessed(_ string, _ int64)         {}
-func (NoopMonitoringService) MillisBehindLatest(_ string, _ float64)       {}
-func (NoopMonitoringService) DeleteMetricMillisBehindLatest(_ string)      {}
-func (NoopMonitoringService) LeaseGained(_ string)                         {}
-func (NoopMonitoringService) LeaseLost(_ string)                           {}
-func (NoopMonitoringService) LeaseRenewed(_ string)                        {}
-func (NoopMonitoringService) RecordGetRecordsTime(_ string, _ float64)     {}
-func (NoopMonitoringService) RecordProcessRecordsTime(_ string, _ float64) {}
+func (NoopMonitoringService) IncrRecordsProcessed(_ string, _ int)                     {}
+func (NoopMonitoringService) IncrBytesProcessed(_ string, _ int64)                     {}
+func (NoopMonitoringService) MillisBehindLatest(_ string, _ float64)                   {}
+func (NoopMonitoringService) DeleteMetricMillisBehindLatest(_ string)                  {}
+func (NoopMonitoringService) MaxMillisBehindLatest(_ float64)                          {}
+func (NoopMonitoringService) LeaseGained(_ string)                                     {}
+func (NoopMonitoringService) LeaseLost(_ string)                                       {}
+func (NoopMonitoringService) LeaseRenewed(_ string)                                    {}
+func (NoopMonitoringService) IncrCheckpointErrors(_ string)                            {}
+func (NoopMonitoringService) RecordCheckpointTime(_ string, _ float64)                 {}
+func (NoopMonitoringService) IncrLeaseContentions(_ string)                            {}
+func (NoopMonitoringService) IncrConditionalCheckFailures(_ string)                    {}
+func (NoopMonitoringService) IncrProcessorPanics(_ string)                             {}
+func (NoopMonitoringService) IncrThrottledRequests(_ string)                           {}
+func (NoopMonitoringService) IncrLeaseTableConsumedCapacity(_ string, _, _ float64)    {}
+func (NoopMonitoringService) RecordGetRecordsTime(_ string, _ float64)                 {}
+func (NoopMonitoringService) RecordGetRecordsCall(_ string, _ int, _ int64, _ float64) {}
+func (NoopMonitoringService) IncrGetRecordsThrottles(_ string)                         {}
+func (NoopMonitoringService) RecordProcessRecordsTime(_ string, _ float64)             {}
+func (NoopMonitoringService) CheckpointAge(_ string, _ float64)                        {}
+func (NoopMonitoringService) DeleteMetricCheckpointAge(_ string)                       {}
+func (NoopMonitoringService) IncrAlarmsRaised(_ string)                                {}
@@ -0,0 +1,368 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package opentelemetry
+// The implementation publishes kcl metrics through the OpenTelemetry metrics API so that they can be
+// exported to any backend supported by the OpenTelemetry SDK.
+package opentelemetry
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/metrics"
+	"github.com/vmware/vmware-go-kcl-v2/logger"
+)
+
+// meterName is the instrumentation name of the meter of the kcl metrics
+const meterName = "github.com/vmware/vmware-go-kcl-v2"
+
+// the metrics of all extensions of the MonitoringService are reported
+var (
+	_ metrics.LagMonitoringService            = (*MonitoringService)(nil)
+	_ metrics.CheckpointMonitoringService     = (*MonitoringService)(nil)
+	_ metrics.LeaseTableMonitoringService     = (*MonitoringService)(nil)
+	_ metrics.ProcessorPanicMonitoringService = (*MonitoringService)(nil)
+	_ metrics.ThrottlingMonitoringService     = (*MonitoringService)(nil)
+	_ metrics.GetRecordsMonitoringService     = (*MonitoringService)(nil)
+	_ metrics.AlarmMonitoringService          = (*MonitoringService)(nil)
+)
+
+// MonitoringService publishes kcl metrics to an OpenTelemetry meter provider.
+type MonitoringService struct {
+	appName       string
+	streamName    string
+	workerID      string
+	meterProvider metric.MeterProvider
+	logger        logger.Logger
+
+	// staticAttributes are added to all measurements, e.g. the environment
+	staticAttributes []attribute.KeyValue
+
+	processedRecords    metric.Int64Counter
+	processedBytes      metric.Int64Counter
+	behindLatestMillis  metric.Float64ObservableGauge
+	maxBehindLatest     metric.Float64ObservableGauge
+	leasesHeld          metric.Int64UpDownCounter
+	leaseRenewals       metric.Int64Counter
+	checkpointErrors    metric.Int64Counter
+	checkpointTime      metric.Float64Histogram
+	leaseContentions    metric.Int64Counter
+	conditionalChecks   metric.Int64Counter
+	processorPanics     metric.Int64Counter
+	throttledRequests   metric.Int64Counter
+	leaseTableCapacity  metric.Float64Counter
+	getRecordsTime      metric.Float64Histogram
+	processRecordsTime  metric.Float64Histogram
+	recordsRead         metric.Int64Counter
+	bytesRead           metric.Int64Counter
+	getRecordsLatency   metric.Float64Histogram
+	getRecordsThrottle  metric.Int64Counter
+	checkpointAgeMillis metric.Float64ObservableGauge
+	alarmsRaised        metric.Int64Counter
+
+	// behindLatest keeps the last MillisBehindLatest per shard which is observed by the gauge
+	behindLatest *sync.Map
+	// maxBehindLatestMillis keeps the last max MillisBehindLatest of the shards of the worker, it is nil until reported
+	maxBehindLatestMillis atomic.Value
+	// checkpointAges keeps the last checkpoint age per shard which is observed by the gauge
+	checkpointAges *sync.Map
+	registration   metric.Registration
+}
+
+// NewMonitoringService returns a Monitoring service publishing metrics to the OpenTelemetry meter provider.
+func NewMonitoringService(meterProvider metric.MeterProvider, logger logger.Logger) *MonitoringService {
+	return &MonitoringService{
+		meterProvider:  meterProvider,
+		logger:         logger,
+		behindLatest:   &sync.Map{},
+		checkpointAges: &sync.Map{},
+	}
+}
+
+// WithAttributes adds the static attributes to all measurements, e.g. the environment or the service of the
+// application.
+func (o *MonitoringService) WithAttributes(attributes ...attribute.KeyValue) *MonitoringService {
+	o.staticAttributes = append(o.staticAttributes, attributes...)
+	return o
+}
+
+func (o *MonitoringService) Init(appName, streamName, workerID string) error {
+	o.appName = appName
+	o.streamName = streamName
+	o.workerID = workerID
+
+	meter := o.meterProvider.Meter(meterName)
+
+	var err error
+	if o.processedRecords, err = meter.Int64Counter("kcl.processed_records",
+		metric.WithDescription("Number of records processed")); err != nil {
+		return err
+	}
+	if o.processedBytes, err = meter.Int64Counter("kcl.processed_bytes",
+		metric.WithDescription("Number of bytes processed"), metric.WithUnit("By")); err != nil {
+		return err
+	}
+	if o.behindLatestMillis, err = meter.Float64ObservableGauge("kcl.behind_latest_millis",
+		metric.WithDescription("The amount of milliseconds processing is behind"), metric.WithUnit("ms")); err != nil {
+		return err
+	}
+	if o.maxBehindLatest, err = meter.Float64ObservableGauge("kcl.max_behind_latest_millis",
+		metric.WithDescription("The max amount of milliseconds processing is behind of the shards of the worker"),
+		metric.WithUnit("ms")); err != nil {
+		return err
+	}
+	if o.leasesHeld, err = meter.Int64UpDownCounter("kcl.leases_held",
+		metric.WithDescription("The number of leases held by the worker")); err != nil {
+		return err
+	}
+	if o.leaseRenewals, err = meter.Int64Counter("kcl.lease_renewals",
+		metric.WithDescription("The number of successful lease renewals")); err != nil {
+		return err
+	}
+	if o.checkpointErrors, err = meter.Int64Counter("kcl.checkpoint_errors",
+		metric.WithDescription("The number of failed checkpoints")); err != nil {
+		return err
+	}
+	if o.checkpointTime, err = meter.Float64Histogram("kcl.checkpoint_duration",
+		metric.WithDescription("The time taken to write a checkpoint to the lease table"), metric.WithUnit("ms")); err != nil {
+		return err
+	}
+	if o.leaseContentions, err = meter.Int64Counter("kcl.lease_contentions",
+		metric.WithDescription("The number of leases not acquired because another worker holds or claims them")); err != nil {
+		return err
+	}
+	if o.conditionalChecks, err = meter.Int64Counter("kcl.conditional_check_failures",
+		metric.WithDescription("The number of writes to the lease table rejected because another worker modified the lease")); err != nil {
+		return err
+	}
+	if o.processorPanics, err = meter.Int64Counter("kcl.processor_panics",
+		metric.WithDescription("The number of panics of record processors")); err != nil {
+		return err
+	}
+	if o.throttledRequests, err = meter.Int64Counter("kcl.throttled_requests",
+		metric.WithDescription("The number of throttled calls to AWS APIs")); err != nil {
+		return err
+	}
+	if o.leaseTableCapacity, err = meter.Float64Counter("kcl.lease_table.consumed_capacity",
+		metric.WithDescription("The read and write capacity units of the lease table consumed by the worker")); err != nil {
+		return err
+	}
+	if o.getRecordsTime, err = meter.Float64Histogram("kcl.get_records_duration",
+		metric.WithDescription("The time taken to fetch records and process them"), metric.WithUnit("ms")); err != nil {
+		return err
+	}
+	if o.processRecordsTime, err = meter.Float64Histogram("kcl.process_records_duration",
+		metric.WithDescription("The time taken to process records"), metric.WithUnit("ms")); err != nil {
+		return err
+	}
+	if o.recordsRead, err = meter.Int64Counter("kcl.get_records.read_records",
+		metric.WithDescription("The number of Kinesis records read by GetRecords calls, before de-aggregation")); err != nil {
+		return err
+	}
+	if o.bytesRead, err = meter.Int64Counter("kcl.get_records.read_bytes",
+		metric.WithDescription("The number of bytes of the data of the records read by GetRecords calls"), metric.WithUnit("By")); err != nil {
+		return err
+	}
+	if o.getRecordsLatency, err = meter.Float64Histogram("kcl.get_records.latency",
+		metric.WithDescription("The time taken by a GetRecords call"), metric.WithUnit("ms")); err != nil {
+		return err
+	}
+	if o.getRecordsThrottle, err = meter.Int64Counter("kcl.get_records.throttles",
+		metric.WithDescription("The number of throttled GetRecords calls")); err != nil {
+		return err
+	}
+	if o.checkpointAgeMillis, err = meter.Float64ObservableGauge("kcl.checkpoint_age_millis",
+		metric.WithDescription("The amount of milliseconds the checkpoint of a shard has not advanced"), metric.WithUnit("ms")); err != nil {
+		return err
+	}
+	if o.alarmsRaised, err = meter.Int64Counter("kcl.alarms_raised",
+		metric.WithDescription("The number of alarms raised for a shard")); err != nil {
+		return err
+	}
+
+	o.registration, err = meter.RegisterCallback(func(_ context.Context, observer metric.Observer) error {
+		o.behindLatest.Range(func(k, v interface{}) bool {
+			observer.ObserveFloat64(o.behindLatestMillis, v.(float64), o.attributes(k.(string)))
+			return true
+		})
+		o.checkpointAges.Range(func(k, v interface{}) bool {
+			observer.ObserveFloat64(o.checkpointAgeMillis, v.(float64), o.attributes(k.(string)))
+			return true
+		})
+		if millSeconds, ok := o.maxBehindLatestMillis.Load().(float64); ok {
+			observer.ObserveFloat64(o.maxBehindLatest, millSeconds, metric.WithAttributes(o.withAttributes(
+				attribute.String("application", o.appName),
+				attribute.String("kinesisStream", o.streamName),
+				attribute.String("workerID", o.workerID),
+			)...))
+		}
+		return nil
+	}, o.behindLatestMillis, o.maxBehindLatest, o.checkpointAgeMillis)
+
+	return err
+}
+
+// Start does nothing, the metrics are collected by the readers of the meter provider.
+func (o *MonitoringService) Start() error {
+	return nil
+}
+
+func (o *MonitoringService) Shutdown() {
+	if o.registration == nil {
+		return
+	}
+
+	if err := o.registration.Unregister(); err != nil {
+		o.logger.Errorf("Error unregistering OpenTelemetry metrics callback. %+v", err)
+	}
+}
+
+func (o *MonitoringService) IncrRecordsProcessed(shard string, count int) {
+	o.processedRecords.Add(context.Background(), int64(count), o.attributes(shard))
+}
+
+func (o *MonitoringService) IncrBytesProcessed(shard string, count int64) {
+	o.processedBytes.Add(context.Background(), count, o.attributes(shard))
+}
+
+func (o *MonitoringService) MillisBehindLatest(shard string, millSeconds float64) {
+	o.behindLatest.Store(shard, millSeconds)
+}
+
+func (o *MonitoringService) DeleteMetricMillisBehindLatest(shard string) {
+	o.behindLatest.Delete(shard)
+}
+
+func (o *MonitoringService) MaxMillisBehindLatest(millSeconds float64) {
+	o.maxBehindLatestMillis.Store(millSeconds)
+}
+
+func (o *MonitoringService) LeaseGained(shard string) {
+	o.leasesHeld.Add(context.Background(), 1, o.attributes(shard))
+}
+
+func (o *MonitoringService) LeaseLost(shard string) {
+	o.leasesHeld.Add(context.Background(), -1, o.attributes(shard))
+}
+
+func (o *MonitoringService) LeaseRenewed(shard string) {
+	o.leaseRenewals.Add(context.Background(), 1, o.attributes(shard))
+}
+
+func (o *MonitoringService) IncrCheckpointErrors(shard string) {
+	o.checkpointErrors.Add(context.Background(), 1, o.attributes(shard))
+}
+
+func (o *MonitoringService) RecordCheckpointTime(shard string, time float64) {
+	o.checkpointTime.Record(context.Background(), time, o.attributes(shard))
+}
+
+func (o *MonitoringService) IncrLeaseContentions(shard string) {
+	o.leaseContentions.Add(context.Background(), 1, o.attributes(shard))
+}
+
+func (o *MonitoringService) IncrConditionalCheckFailures(shard string) {
+	o.conditionalChecks.Add(context.Background(), 1, o.attributes(shard))
+}
+
+func (o *MonitoringService) IncrProcessorPanics(shard string) {
+	o.processorPanics.Add(context.Background(), 1, o.attributes(shard))
+}
+
+func (o *MonitoringService) IncrThrottledRequests(api string) {
+	o.throttledRequests.Add(context.Background(), 1, metric.WithAttributes(o.withAttributes(
+		attribute.String("application", o.appName),
+		attribute.String("kinesisStream", o.streamName),
+		attribute.String("api", api),
+		attribute.String("workerID", o.workerID),
+	)...))
+}
+
+// IncrLeaseTableConsumedCapacity is called by the DynamoDB checkpointer, which is also used without Init by the
+// admin and snapshot packages
+func (o *MonitoringService) IncrLeaseTableConsumedCapacity(operation string, readCapacityUnits, writeCapacityUnits float64) {
+	if o.leaseTableCapacity == nil {
+		return
+	}
+	attributes := func(capacityType string) metric.AddOption {
+		return metric.WithAttributes(o.withAttributes(
+			attribute.String("application", o.appName),
+			attribute.String("kinesisStream", o.streamName),
+			attribute.String("operation", operation),
+			attribute.String("type", capacityType),
+			attribute.String("workerID", o.workerID),
+		)...)
+	}
+	if readCapacityUnits > 0 {
+		o.leaseTableCapacity.Add(context.Background(), readCapacityUnits, attributes("read"))
+	}
+	if writeCapacityUnits > 0 {
+		o.leaseTableCapacity.Add(context.Background(), writeCapacityUnits, attributes("write"))
+	}
+}
+
+func (o *MonitoringService) RecordGetRecordsTime(shard string, time float64) {
+	o.getRecordsTime.Record(context.Background(), time, o.attributes(shard))
+}
+
+func (o *MonitoringService) RecordGetRecordsCall(shard string, recordCount int, bytesRead int64, latency float64) {
+	o.recordsRead.Add(context.Background(), int64(recordCount), o.attributes(shard))
+	o.bytesRead.Add(context.Background(), bytesRead, o.attributes(shard))
+	o.getRecordsLatency.Record(context.Background(), latency, o.attributes(shard))
+}
+
+func (o *MonitoringService) IncrGetRecordsThrottles(shard string) {
+	o.getRecordsThrottle.Add(context.Background(), 1, o.attributes(shard))
+}
+
+func (o *MonitoringService) RecordProcessRecordsTime(shard string, time float64) {
+	o.processRecordsTime.Record(context.Background(), time, o.attributes(shard))
+}
+
+func (o *MonitoringService) CheckpointAge(shard string, milliSeconds float64) {
+	o.checkpointAges.Store(shard, milliSeconds)
+}
+
+func (o *MonitoringService) DeleteMetricCheckpointAge(shard string) {
+	o.checkpointAges.Delete(shard)
+}
+
+func (o *MonitoringService) IncrAlarmsRaised(shard string) {
+	o.alarmsRaised.Add(context.Background(), 1, o.attributes(shard))
+}
+
+// attributes returns the per-shard and per-worker attributes of a measurement
+func (o *MonitoringService) attributes(shard string) metric.MeasurementOption {
+	return metric.WithAttributes(o.withAttributes(
+		attribute.String("application", o.appName),
+		attribute.String("kinesisStream", o.streamName),
+		attribute.String("shard", shard),
+		attribute.String("workerID", o.workerID),
+	)...)
+}
+
+// withAttributes appends the static attributes to the attributes of a measurement
+func (o *MonitoringService) withAttributes(attributes ...attribute.KeyValue) []attribute.KeyValue {
+	return append(attributes, o.staticAttributes...)
+}
@@ -0,0 +1,125 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package opentelemetry
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+
+	"github.com/vmware/vmware-go-kcl-v2/logger"
+)
+
+func TestMetricsAttributes(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	o := NewMonitoringService(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)), logger.GetDefaultLogger())
+	assert.Nil(t, o.Init("app", "stream", "worker"))
+	defer o.Shutdown()
+
+	o.IncrRecordsProcessed("0001", 10)
+	o.MillisBehindLatest("0001", 100)
+	o.LeaseGained("0001")
+	o.IncrCheckpointErrors("0001")
+	o.RecordGetRecordsTime("0001", 5)
+	o.RecordGetRecordsCall("0001", 3, 300, 20)
+	o.IncrGetRecordsThrottles("0001")
+	o.CheckpointAge("0001", 2000)
+	o.IncrAlarmsRaised("0001")
+
+	values := collect(t, reader)
+	assert.Equal(t, float64(10), values["kcl.processed_records"])
+	assert.Equal(t, float64(100), values["kcl.behind_latest_millis"])
+	assert.Equal(t, float64(1), values["kcl.leases_held"])
+	assert.Equal(t, float64(1), values["kcl.checkpoint_errors"])
+	assert.Equal(t, float64(1), values["kcl.get_records_duration"])
+	assert.Equal(t, float64(3), values["kcl.get_records.read_records"])
+	assert.Equal(t, float64(300), values["kcl.get_records.read_bytes"])
+	assert.Equal(t, float64(1), values["kcl.get_records.latency"])
+	assert.Equal(t, float64(1), values["kcl.get_records.throttles"])
+	assert.Equal(t, float64(2000), values["kcl.checkpoint_age_millis"])
+	assert.Equal(t, float64(1), values["kcl.alarms_raised"])
+
+	o.DeleteMetricMillisBehindLatest("0001")
+	o.DeleteMetricCheckpointAge("0001")
+	values = collect(t, reader)
+	_, ok := values["kcl.behind_latest_millis"]
+	assert.False(t, ok)
+	_, ok = values["kcl.checkpoint_age_millis"]
+	assert.False(t, ok)
+}
+
+func TestStaticAttributes(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	o := NewMonitoringService(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)), logger.GetDefaultLogger()).
+		WithAttributes(attribute.String("env", "prod"))
+	assert.Nil(t, o.Init("app", "stream", "worker"))
+	defer o.Shutdown()
+
+	o.IncrRecordsProcessed("0001", 10)
+
+	rm := metricdata.ResourceMetrics{}
+	assert.Nil(t, reader.Collect(context.Background(), &rm))
+	dp := rm.ScopeMetrics[0].Metrics[0].Data.(metricdata.Sum[int64]).DataPoints[0]
+	env, ok := dp.Attributes.Value("env")
+	assert.True(t, ok)
+	assert.Equal(t, "prod", env.AsString())
+	assert.Equal(t, int64(10), dp.Value)
+}
+
+// collect returns the value of every metric and checks the attributes of the data points
+func collect(t *testing.T, reader sdkmetric.Reader) map[string]float64 {
+	expected := attribute.NewSet(
+		attribute.String("application", "app"),
+		attribute.String("kinesisStream", "stream"),
+		attribute.String("shard", "0001"),
+		attribute.String("workerID", "worker"),
+	)
+
+	rm := metricdata.ResourceMetrics{}
+	assert.Nil(t, reader.Collect(context.Background(), &rm))
+
+	values := map[string]float64{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			switch data := m.Data.(type) {
+			case metricdata.Sum[int64]:
+				for _, dp := range data.DataPoints {
+					assert.True(t, expected.Equals(&dp.Attributes), m.Name)
+					values[m.Name] = float64(dp.Value)
+				}
+			case metricdata.Gauge[float64]:
+				for _, dp := range data.DataPoints {
+					assert.True(t, expected.Equals(&dp.Attributes), m.Name)
+					values[m.Name] = dp.Value
+				}
+			case metricdata.Histogram[float64]:
+				for _, dp := range data.DataPoints {
+					assert.True(t, expected.Equals(&dp.Attributes), m.Name)
+					values[m.Name] = float64(dp.Count)
+				}
+			}
+		}
+	}
+	return values
+}
@@ -30,40 +30,99 @@
 package prometheus
 
 import (
+	"context"
 	"net/http"
+	"time"
 
 	prom "github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/metrics"
 	"github.com/vmware/vmware-go-kcl-v2/logger"
 )
 
+// DefaultMetricsPath is the HTTP path on which metrics are exposed to Prometheus.
+const DefaultMetricsPath = "/metrics"
+
+// the metrics of all extensions of the MonitoringService are reported
+var (
+	_ metrics.LagMonitoringService            = (*MonitoringService)(nil)
+	_ metrics.CheckpointMonitoringService     = (*MonitoringService)(nil)
+	_ metrics.LeaseTableMonitoringService     = (*MonitoringService)(nil)
+	_ metrics.ProcessorPanicMonitoringService = (*MonitoringService)(nil)
+	_ metrics.ThrottlingMonitoringService     = (*MonitoringService)(nil)
+	_ metrics.GetRecordsMonitoringService     = (*MonitoringService)(nil)
+	_ metrics.AlarmMonitoringService          = (*MonitoringService)(nil)
+)
+
 // MonitoringService publishes kcl metrics to Prometheus.
 // It might be trick if the service onboarding with KCL already uses Prometheus.
 type MonitoringService struct {
 	listenAddress string
+	metricsPath   string
 	namespace     string
 	streamName    string
 	workerID      string
 	region        string
 	logger        logger.Logger
 
+	// constLabels are the static labels added to all metrics, e.g. the environment
+	constLabels prom.Labels
+
+	registerer prom.Registerer
+	gatherer   prom.Gatherer
+	server     *http.Server
+
 	processedRecords   *prom.CounterVec
 	processedBytes     *prom.CounterVec
 	behindLatestMillis *prom.GaugeVec
+	maxBehindLatest    *prom.GaugeVec
 	leasesHeld         *prom.GaugeVec
 	leaseRenewals      *prom.CounterVec
+	checkpointErrors   *prom.CounterVec
+	checkpointTime     *prom.HistogramVec
+	leaseContentions   *prom.CounterVec
+	conditionalChecks  *prom.CounterVec
+	processorPanics    *prom.CounterVec
+	throttledRequests  *prom.CounterVec
+	leaseTableCapacity *prom.CounterVec
 	getRecordsTime     *prom.HistogramVec
 	processRecordsTime *prom.HistogramVec
+	recordsRead        *prom.CounterVec
+	bytesRead          *prom.CounterVec
+	getRecordsLatency  *prom.HistogramVec
+	getRecordsThrottle *prom.CounterVec
+	checkpointAge      *prom.GaugeVec
+	alarmsRaised       *prom.CounterVec
 }
 
 // NewMonitoringService returns a Monitoring service publishing metrics to Prometheus.
 func NewMonitoringService(listenAddress, region string, logger logger.Logger) *MonitoringService {
+	return NewMonitoringServiceWithOptions(listenAddress, DefaultMetricsPath, region, logger)
+}
+
+// NewMonitoringServiceWithOptions returns a Monitoring service publishing metrics to
+// Prometheus on the provided listen address and HTTP path.
+func NewMonitoringServiceWithOptions(listenAddress, metricsPath, region string, logger logger.Logger) *MonitoringService {
 	return &MonitoringService{
 		listenAddress: listenAddress,
+		metricsPath:   metricsPath,
 		region:        region,
 		logger:        logger,
+		registerer:    prom.DefaultRegisterer,
+		gatherer:      prom.DefaultGatherer,
+	}
+}
+
+// WithConstLabels adds the static labels to all metrics, e.g. the environment or the service of the application.
+func (p *MonitoringService) WithConstLabels(labels map[string]string) *MonitoringService {
+	if p.constLabels == nil {
+		p.constLabels = make(prom.Labels, len(labels))
 	}
+	for name, value := range labels {
+		p.constLabels[name] = value
+	}
+	return p
 }
 
 func (p *MonitoringService) Init(appName, streamName, workerID string) error {
@@ -72,45 +131,136 @@ func (p *MonitoringService) Init(appName, streamName, workerID string) error {
 	p.workerID = workerID
 
 	p.processedBytes = prom.NewCounterVec(prom.CounterOpts{
-		Name: p.namespace + `_processed_bytes`,
-		Help: "Number of bytes processed",
-	}, []string{"kinesisStream", "shard"})
+		Name:        p.namespace + `_processed_bytes`,
+		Help:        "Number of bytes processed",
+		ConstLabels: p.constLabels,
+	}, []string{"kinesisStream", "shard", "workerID"})
 	p.processedRecords = prom.NewCounterVec(prom.CounterOpts{
-		Name: p.namespace + `_processed_records`,
-		Help: "Number of records processed",
-	}, []string{"kinesisStream", "shard"})
+		Name:        p.namespace + `_processed_records`,
+		Help:        "Number of records processed",
+		ConstLabels: p.constLabels,
+	}, []string{"kinesisStream", "shard", "workerID"})
 	p.behindLatestMillis = prom.NewGaugeVec(prom.GaugeOpts{
-		Name: p.namespace + `_behind_latest_millis`,
-		Help: "The amount of milliseconds processing is behind",
-	}, []string{"kinesisStream", "shard"})
+		Name:        p.namespace + `_behind_latest_millis`,
+		Help:        "The amount of milliseconds processing is behind",
+		ConstLabels: p.constLabels,
+	}, []string{"kinesisStream", "shard", "workerID"})
+	p.maxBehindLatest = prom.NewGaugeVec(prom.GaugeOpts{
+		Name:        p.namespace + `_max_behind_latest_millis`,
+		Help:        "The max amount of milliseconds processing is behind of the shards of the worker",
+		ConstLabels: p.constLabels,
+	}, []string{"kinesisStream", "workerID"})
 	p.leasesHeld = prom.NewGaugeVec(prom.GaugeOpts{
-		Name: p.namespace + `_leases_held`,
-		Help: "The number of leases held by the worker",
+		Name:        p.namespace + `_leases_held`,
+		Help:        "The number of leases held by the worker",
+		ConstLabels: p.constLabels,
 	}, []string{"kinesisStream", "shard", "workerID"})
 	p.leaseRenewals = prom.NewCounterVec(prom.CounterOpts{
-		Name: p.namespace + `_lease_renewals`,
-		Help: "The number of successful lease renewals",
+		Name:        p.namespace + `_lease_renewals`,
+		Help:        "The number of successful lease renewals",
+		ConstLabels: p.constLabels,
+	}, []string{"kinesisStream", "shard", "workerID"})
+	p.checkpointErrors = prom.NewCounterVec(prom.CounterOpts{
+		Name:        p.namespace + `_checkpoint_errors`,
+		Help:        "The number of failed checkpoints",
+		ConstLabels: p.constLabels,
+	}, []string{"kinesisStream", "shard", "workerID"})
+	p.checkpointTime = prom.NewHistogramVec(prom.HistogramOpts{
+		Name:        p.namespace + `_checkpoint_duration_milliseconds`,
+		Help:        "The time taken to write a checkpoint to the lease table",
+		ConstLabels: p.constLabels,
 	}, []string{"kinesisStream", "shard", "workerID"})
+	p.leaseContentions = prom.NewCounterVec(prom.CounterOpts{
+		Name:        p.namespace + `_lease_contentions`,
+		Help:        "The number of leases not acquired because another worker holds or claims them",
+		ConstLabels: p.constLabels,
+	}, []string{"kinesisStream", "shard", "workerID"})
+	p.conditionalChecks = prom.NewCounterVec(prom.CounterOpts{
+		Name:        p.namespace + `_conditional_check_failures`,
+		Help:        "The number of writes to the lease table rejected because another worker modified the lease",
+		ConstLabels: p.constLabels,
+	}, []string{"kinesisStream", "shard", "workerID"})
+	p.processorPanics = prom.NewCounterVec(prom.CounterOpts{
+		Name:        p.namespace + `_processor_panics`,
+		Help:        "The number of panics of record processors",
+		ConstLabels: p.constLabels,
+	}, []string{"kinesisStream", "shard", "workerID"})
+	p.throttledRequests = prom.NewCounterVec(prom.CounterOpts{
+		Name:        p.namespace + `_throttled_requests`,
+		Help:        "The number of throttled calls to AWS APIs",
+		ConstLabels: p.constLabels,
+	}, []string{"kinesisStream", "api", "workerID"})
+	p.leaseTableCapacity = prom.NewCounterVec(prom.CounterOpts{
+		Name:        p.namespace + `_lease_table_consumed_capacity_units`,
+		Help:        "The read and write capacity units of the lease table consumed by the worker",
+		ConstLabels: p.constLabels,
+	}, []string{"kinesisStream", "operation", "type", "workerID"})
 	p.getRecordsTime = prom.NewHistogramVec(prom.HistogramOpts{
-		Name: p.namespace + `_get_records_duration_milliseconds`,
-		Help: "The time taken to fetch records and process them",
-	}, []string{"kinesisStream", "shard"})
+		Name:        p.namespace + `_get_records_duration_milliseconds`,
+		Help:        "The time taken to fetch records and process them",
+		ConstLabels: p.constLabels,
+	}, []string{"kinesisStream", "shard", "workerID"})
 	p.processRecordsTime = prom.NewHistogramVec(prom.HistogramOpts{
-		Name: p.namespace + `_process_records_duration_milliseconds`,
-		Help: "The time taken to process records",
-	}, []string{"kinesisStream", "shard"})
+		Name:        p.namespace + `_process_records_duration_milliseconds`,
+		Help:        "The time taken to process records",
+		ConstLabels: p.constLabels,
+	}, []string{"kinesisStream", "shard", "workerID"})
+	p.recordsRead = prom.NewCounterVec(prom.CounterOpts{
+		Name:        p.namespace + `_get_records_read_records`,
+		Help:        "The number of Kinesis records read by GetRecords calls, before de-aggregation",
+		ConstLabels: p.constLabels,
+	}, []string{"kinesisStream", "shard", "workerID"})
+	p.bytesRead = prom.NewCounterVec(prom.CounterOpts{
+		Name:        p.namespace + `_get_records_read_bytes`,
+		Help:        "The number of bytes of the data of the records read by GetRecords calls",
+		ConstLabels: p.constLabels,
+	}, []string{"kinesisStream", "shard", "workerID"})
+	p.getRecordsLatency = prom.NewHistogramVec(prom.HistogramOpts{
+		Name:        p.namespace + `_get_records_latency_milliseconds`,
+		Help:        "The time taken by a GetRecords call",
+		ConstLabels: p.constLabels,
+	}, []string{"kinesisStream", "shard", "workerID"})
+	p.getRecordsThrottle = prom.NewCounterVec(prom.CounterOpts{
+		Name:        p.namespace + `_get_records_throttles`,
+		Help:        "The number of throttled GetRecords calls",
+		ConstLabels: p.constLabels,
+	}, []string{"kinesisStream", "shard", "workerID"})
+	p.checkpointAge = prom.NewGaugeVec(prom.GaugeOpts{
+		Name:        p.namespace + `_checkpoint_age_millis`,
+		Help:        "The amount of milliseconds the checkpoint of a shard has not advanced",
+		ConstLabels: p.constLabels,
+	}, []string{"kinesisStream", "shard", "workerID"})
+	p.alarmsRaised = prom.NewCounterVec(prom.CounterOpts{
+		Name:        p.namespace + `_alarms_raised`,
+		Help:        "The number of alarms raised for a shard",
+		ConstLabels: p.constLabels,
+	}, []string{"kinesisStream", "shard", "workerID"})
 
 	metrics := []prom.Collector{
 		p.processedBytes,
 		p.processedRecords,
 		p.behindLatestMillis,
+		p.maxBehindLatest,
 		p.leasesHeld,
 		p.leaseRenewals,
+		p.checkpointErrors,
+		p.checkpointTime,
+		p.leaseContentions,
+		p.conditionalChecks,
+		p.processorPanics,
+		p.throttledRequests,
+		p.leaseTableCapacity,
 		p.getRecordsTime,
 		p.processRecordsTime,
+		p.recordsRead,
+		p.bytesRead,
+		p.getRecordsLatency,
+		p.getRecordsThrottle,
+		p.checkpointAge,
+		p.alarmsRaised,
 	}
 	for _, metric := range metrics {
-		err := prom.Register(metric)
+		err := p.registerer.Register(metric)
 		if err != nil {
 			return err
 		}
@@ -120,11 +270,14 @@ func (p *MonitoringService) Init(appName, streamName, workerID string) error {
 }
 
 func (p *MonitoringService) Start() error {
-	http.Handle("/metrics", promhttp.Handler())
+	mux := http.NewServeMux()
+	mux.Handle(p.metricsPath, promhttp.HandlerFor(p.gatherer, promhttp.HandlerOpts{}))
+	p.server = &http.Server{Addr: p.listenAddress, Handler: mux}
+
 	go func() {
-		p.logger.Infof("Starting Prometheus listener on %s", p.listenAddress)
-		err := http.ListenAndServe(p.listenAddress, nil)
-		if err != nil {
+		p.logger.Infof("Starting Prometheus listener on %s%s", p.listenAddress, p.metricsPath)
+		err := p.server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
 			p.logger.Errorf("Error starting Prometheus metrics endpoint. %+v", err)
 		}
 		p.logger.Infof("Stopped metrics server")
@@ -133,40 +286,122 @@ func (p *MonitoringService) Start() error {
 	return nil
 }
 
-func (p *MonitoringService) Shutdown() {}
+func (p *MonitoringService) Shutdown() {
+	if p.server == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := p.server.Shutdown(ctx); err != nil {
+		p.logger.Errorf("Error shutting down Prometheus metrics endpoint. %+v", err)
+	}
+}
 
 func (p *MonitoringService) IncrRecordsProcessed(shard string, count int) {
-	p.processedRecords.With(prom.Labels{"shard": shard, "kinesisStream": p.streamName}).Add(float64(count))
+	p.processedRecords.With(p.labels(shard)).Add(float64(count))
 }
 
 func (p *MonitoringService) IncrBytesProcessed(shard string, count int64) {
-	p.processedBytes.With(prom.Labels{"shard": shard, "kinesisStream": p.streamName}).Add(float64(count))
+	p.processedBytes.With(p.labels(shard)).Add(float64(count))
 }
 
 func (p *MonitoringService) MillisBehindLatest(shard string, millSeconds float64) {
-	p.behindLatestMillis.With(prom.Labels{"shard": shard, "kinesisStream": p.streamName}).Set(millSeconds)
+	p.behindLatestMillis.With(p.labels(shard)).Set(millSeconds)
 }
 
 func (p *MonitoringService) DeleteMetricMillisBehindLatest(shard string) {
-	p.behindLatestMillis.Delete(prom.Labels{"shard": shard, "kinesisStream": p.streamName})
+	p.behindLatestMillis.Delete(p.labels(shard))
+}
+
+func (p *MonitoringService) MaxMillisBehindLatest(millSeconds float64) {
+	p.maxBehindLatest.With(prom.Labels{"kinesisStream": p.streamName, "workerID": p.workerID}).Set(millSeconds)
 }
 
 func (p *MonitoringService) LeaseGained(shard string) {
-	p.leasesHeld.With(prom.Labels{"shard": shard, "kinesisStream": p.streamName, "workerID": p.workerID}).Inc()
+	p.leasesHeld.With(p.labels(shard)).Inc()
 }
 
 func (p *MonitoringService) LeaseLost(shard string) {
-	p.leasesHeld.With(prom.Labels{"shard": shard, "kinesisStream": p.streamName, "workerID": p.workerID}).Dec()
+	p.leasesHeld.With(p.labels(shard)).Dec()
 }
 
 func (p *MonitoringService) LeaseRenewed(shard string) {
-	p.leaseRenewals.With(prom.Labels{"shard": shard, "kinesisStream": p.streamName, "workerID": p.workerID}).Inc()
+	p.leaseRenewals.With(p.labels(shard)).Inc()
+}
+
+func (p *MonitoringService) IncrCheckpointErrors(shard string) {
+	p.checkpointErrors.With(p.labels(shard)).Inc()
+}
+
+func (p *MonitoringService) RecordCheckpointTime(shard string, time float64) {
+	p.checkpointTime.With(p.labels(shard)).Observe(time)
+}
+
+func (p *MonitoringService) IncrLeaseContentions(shard string) {
+	p.leaseContentions.With(p.labels(shard)).Inc()
+}
+
+func (p *MonitoringService) IncrConditionalCheckFailures(shard string) {
+	p.conditionalChecks.With(p.labels(shard)).Inc()
+}
+
+func (p *MonitoringService) IncrProcessorPanics(shard string) {
+	p.processorPanics.With(p.labels(shard)).Inc()
+}
+
+func (p *MonitoringService) IncrThrottledRequests(api string) {
+	p.throttledRequests.With(prom.Labels{"api": api, "kinesisStream": p.streamName, "workerID": p.workerID}).Inc()
+}
+
+// IncrLeaseTableConsumedCapacity is called by the DynamoDB checkpointer, which is also used without Init by the
+// admin and snapshot packages
+func (p *MonitoringService) IncrLeaseTableConsumedCapacity(operation string, readCapacityUnits, writeCapacityUnits float64) {
+	if p.leaseTableCapacity == nil {
+		return
+	}
+	labels := prom.Labels{"kinesisStream": p.streamName, "operation": operation, "workerID": p.workerID}
+	if readCapacityUnits > 0 {
+		labels["type"] = "read"
+		p.leaseTableCapacity.With(labels).Add(readCapacityUnits)
+	}
+	if writeCapacityUnits > 0 {
+		labels["type"] = "write"
+		p.leaseTableCapacity.With(labels).Add(writeCapacityUnits)
+	}
 }
 
 func (p *MonitoringService) RecordGetRecordsTime(shard string, time float64) {
-	p.getRecordsTime.With(prom.Labels{"shard": shard, "kinesisStream": p.streamName}).Observe(time)
+	p.getRecordsTime.With(p.labels(shard)).Observe(time)
+}
+
+func (p *MonitoringService) RecordGetRecordsCall(shard string, recordCount int, bytesRead int64, latency float64) {
+	p.recordsRead.With(p.labels(shard)).Add(float64(recordCount))
+	p.bytesRead.With(p.labels(shard)).Add(float64(bytesRead))
+	p.getRecordsLatency.With(p.labels(shard)).Observe(latency)
+}
+
+func (p *MonitoringService) IncrGetRecordsThrottles(shard string) {
+	p.getRecordsThrottle.With(p.labels(shard)).Inc()
 }
 
 func (p *MonitoringService) RecordProcessRecordsTime(shard string, time float64) {
-	p.processRecordsTime.With(prom.Labels{"shard": shard, "kinesisStream": p.streamName}).Observe(time)
+	p.processRecordsTime.With(p.labels(shard)).Observe(time)
+}
+
+func (p *MonitoringService) CheckpointAge(shard string, milliSeconds float64) {
+	p.checkpointAge.With(p.labels(shard)).Set(milliSeconds)
+}
+
+func (p *MonitoringService) DeleteMetricCheckpointAge(shard string) {
+	p.checkpointAge.Delete(p.labels(shard))
+}
+
+func (p *MonitoringService) IncrAlarmsRaised(shard string) {
+	p.alarmsRaised.With(p.labels(shard)).Inc()
+}
+
+// labels returns the per-shard and per-worker labels of a metric
+func (p *MonitoringService) labels(shard string) prom.Labels {
+	return prom.Labels{"shard": shard, "kinesisStream": p.streamName, "workerID": p.workerID}
 }
@@ -0,0 +1,121 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package prometheus
+
+import (
+	"testing"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/vmware-go-kcl-v2/logger"
+)
+
+func TestMetricsLabels(t *testing.T) {
+	registry := prom.NewRegistry()
+	p := NewMonitoringServiceWithOptions(":0", "/kcl/metrics", "us-west-2", logger.GetDefaultLogger())
+	p.registerer = registry
+	p.gatherer = registry
+	assert.Nil(t, p.Init("app", "stream", "worker"))
+	assert.Equal(t, "/kcl/metrics", p.metricsPath)
+
+	p.IncrRecordsProcessed("0001", 10)
+	p.MillisBehindLatest("0001", 100)
+	p.LeaseGained("0001")
+	p.IncrCheckpointErrors("0001")
+	p.RecordGetRecordsTime("0001", 5)
+	p.RecordGetRecordsCall("0001", 3, 300, 20)
+	p.IncrGetRecordsThrottles("0001")
+	p.CheckpointAge("0001", 2000)
+	p.IncrAlarmsRaised("0001")
+
+	families, err := registry.Gather()
+	assert.Nil(t, err)
+
+	values := map[string]float64{}
+	for _, family := range families {
+		metric := family.GetMetric()[0]
+		labels := map[string]string{}
+		for _, label := range metric.GetLabel() {
+			labels[label.GetName()] = label.GetValue()
+		}
+		assert.Equal(t, map[string]string{"kinesisStream": "stream", "shard": "0001", "workerID": "worker"}, labels, family.GetName())
+
+		switch {
+		case metric.Counter != nil:
+			values[family.GetName()] = metric.GetCounter().GetValue()
+		case metric.Gauge != nil:
+			values[family.GetName()] = metric.GetGauge().GetValue()
+		case metric.Histogram != nil:
+			values[family.GetName()] = float64(metric.GetHistogram().GetSampleCount())
+		}
+	}
+
+	assert.Equal(t, float64(10), values["app_processed_records"])
+	assert.Equal(t, float64(100), values["app_behind_latest_millis"])
+	assert.Equal(t, float64(1), values["app_leases_held"])
+	assert.Equal(t, float64(1), values["app_checkpoint_errors"])
+	assert.Equal(t, float64(1), values["app_get_records_duration_milliseconds"])
+	assert.Equal(t, float64(3), values["app_get_records_read_records"])
+	assert.Equal(t, float64(300), values["app_get_records_read_bytes"])
+	assert.Equal(t, float64(1), values["app_get_records_latency_milliseconds"])
+	assert.Equal(t, float64(1), values["app_get_records_throttles"])
+	assert.Equal(t, float64(2000), values["app_checkpoint_age_millis"])
+	assert.Equal(t, float64(1), values["app_alarms_raised"])
+
+	p.DeleteMetricMillisBehindLatest("0001")
+	p.DeleteMetricCheckpointAge("0001")
+	families, err = registry.Gather()
+	assert.Nil(t, err)
+	for _, family := range families {
+		assert.NotEqual(t, "app_behind_latest_millis", family.GetName())
+		assert.NotEqual(t, "app_checkpoint_age_millis", family.GetName())
+	}
+}
+
+func TestConstLabels(t *testing.T) {
+	registry := prom.NewRegistry()
+	p := NewMonitoringService(":0", "us-west-2", logger.GetDefaultLogger()).
+		WithConstLabels(map[string]string{"env": "prod"})
+	p.registerer = registry
+	p.gatherer = registry
+	assert.Nil(t, p.Init("namespace", "stream", "worker"))
+
+	p.IncrRecordsProcessed("0001", 10)
+	p.IncrThrottledRequests("Kinesis.GetRecords")
+
+	families, err := registry.Gather()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(families))
+	for _, family := range families {
+		labels := map[string]string{}
+		for _, label := range family.GetMetric()[0].GetLabel() {
+			labels[label.GetName()] = label.GetValue()
+		}
+		assert.Equal(t, "prod", labels["env"], family.GetName())
+	}
+	assert.Equal(t, "namespace_processed_records", families[0].GetName())
+}
+
+func TestLeaseTableCapacityWithoutInit(t *testing.T) {
+	// the checkpointers of the admin and snapshot packages report the capacity without initialising the service
+	p := NewMonitoringService(":0", "us-west-2", logger.GetDefaultLogger())
+	assert.NotPanics(t, func() { p.IncrLeaseTableConsumedCapacity("GetItem", 0.5, 0) })
+}
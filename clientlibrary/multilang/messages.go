@@ -0,0 +1,134 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package multilang
+
+import (
+	"errors"
+
+	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/worker"
+)
+
+// The actions of the messages of the MultiLang protocol
+const (
+	actionInitialize        = "initialize"
+	actionProcessRecords    = "processRecords"
+	actionLeaseLost         = "leaseLost"
+	actionShardEnded        = "shardEnded"
+	actionShutdownRequested = "shutdownRequested"
+	actionCheckpoint        = "checkpoint"
+	actionStatus            = "status"
+	actionRecord            = "record"
+)
+
+// The exceptions of the Java KCL reported to the child process for failed checkpoints, the MultiLang libraries
+// retry throttled checkpoints and stop checkpointing after a ShutdownException.
+const (
+	throttlingException   = "ThrottlingException"
+	shutdownException     = "ShutdownException"
+	invalidStateException = "InvalidStateException"
+)
+
+type (
+	initializeMessage struct {
+		Action            string  `json:"action"`
+		ShardID           string  `json:"shardId"`
+		SequenceNumber    *string `json:"sequenceNumber"`
+		SubSequenceNumber int64   `json:"subSequenceNumber"`
+	}
+
+	processRecordsMessage struct {
+		Action             string          `json:"action"`
+		MillisBehindLatest int64           `json:"millisBehindLatest"`
+		Records            []recordMessage `json:"records"`
+	}
+
+	recordMessage struct {
+		Action         string `json:"action"`
+		Data           []byte `json:"data"`
+		PartitionKey   string `json:"partitionKey"`
+		SequenceNumber string `json:"sequenceNumber"`
+		// SubSequenceNumber is the position of the record within a KPL aggregated record
+		SubSequenceNumber int64 `json:"subSequenceNumber"`
+		// ApproximateArrivalTimestamp is in milliseconds since the epoch
+		ApproximateArrivalTimestamp int64 `json:"approximateArrivalTimestamp"`
+	}
+
+	// actionMessage is a message of the leaseLost, shardEnded and shutdownRequested actions
+	actionMessage struct {
+		Action string `json:"action"`
+	}
+
+	// checkpointMessage is the response to a checkpoint requested by the child process
+	checkpointMessage struct {
+		Action            string  `json:"action"`
+		SequenceNumber    *string `json:"sequenceNumber"`
+		SubSequenceNumber *int64  `json:"subSequenceNumber"`
+		Error             *string `json:"error"`
+	}
+
+	// childMessage is a message of the child process, a checkpoint request or the status of a completed action
+	childMessage struct {
+		Action            string  `json:"action"`
+		ResponseFor       string  `json:"responseFor"`
+		SequenceNumber    *string `json:"sequenceNumber"`
+		SubSequenceNumber *int64  `json:"subSequenceNumber"`
+	}
+)
+
+func newProcessRecordsMessage(input *kcl.ProcessRecordsInput) *processRecordsMessage {
+	records := input.AllUserRecords()
+	message := &processRecordsMessage{
+		Action:             actionProcessRecords,
+		MillisBehindLatest: input.MillisBehindLatest,
+		Records:            make([]recordMessage, 0, len(records)),
+	}
+	for _, record := range records {
+		r := recordMessage{
+			Action:            actionRecord,
+			Data:              record.Data,
+			SubSequenceNumber: record.SubSequenceNumber,
+		}
+		if record.PartitionKey != nil {
+			r.PartitionKey = *record.PartitionKey
+		}
+		if record.SequenceNumber != nil {
+			r.SequenceNumber = *record.SequenceNumber
+		}
+		if record.ApproximateArrivalTimestamp != nil {
+			r.ApproximateArrivalTimestamp = record.ApproximateArrivalTimestamp.UnixMilli()
+		}
+		message.Records = append(message.Records, r)
+	}
+	return message
+}
+
+// exceptionName returns the exception of the Java KCL reported to the child process for a failed checkpoint
+func exceptionName(err error) string {
+	switch {
+	case errors.Is(err, chk.ErrThrottled):
+		return throttlingException
+	case errors.Is(err, chk.ErrLeaseLost), errors.Is(err, worker.ShutdownError), errors.Is(err, worker.LeaseExpiredError):
+		return shutdownException
+	default:
+		return invalidStateException
+	}
+}
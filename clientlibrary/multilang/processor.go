@@ -0,0 +1,384 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package multilang delegates the processing of the records of each shard to a child process speaking the
+// MultiLang protocol of the Java KCL, JSON messages on separate lines of stdin and stdout, so that record
+// processors written with the MultiLang libraries of the KCL, e.g. amazon_kclpy for Python or aws-kcl for Node.js,
+// are coordinated by this library instead of the Java MultiLangDaemon.
+//
+// A child process is started for each shard. The worker sends the initialize, processRecords, leaseLost,
+// shardEnded and shutdownRequested actions of version 2 of the protocol, the child process may request checkpoints
+// while processing an action and responds with the status of the action once it has completed. The output of the
+// child process on stderr is logged.
+package multilang
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+	"github.com/vmware/vmware-go-kcl-v2/logger"
+)
+
+// ProcessorFactory creates record processors delegating to a child process of a command per shard
+type ProcessorFactory struct {
+	command string
+	args    []string
+	env     []string
+	dir     string
+	timeout time.Duration
+	log     logger.Logger
+}
+
+// NewProcessorFactory returns a factory of record processors running the command with the arguments for each shard,
+// e.g. NewProcessorFactory("python3", "processor.py")
+func NewProcessorFactory(command string, args ...string) *ProcessorFactory {
+	return &ProcessorFactory{command: command, args: args, log: logger.GetDefaultLogger()}
+}
+
+// WithEnv adds the environment variables in the form key=value to the environment of the worker for the child
+// processes
+func (f *ProcessorFactory) WithEnv(env ...string) *ProcessorFactory {
+	f.env = append(f.env, env...)
+	return f
+}
+
+// WithDir runs the child processes in the directory instead of the working directory of the worker
+func (f *ProcessorFactory) WithDir(dir string) *ProcessorFactory {
+	f.dir = dir
+	return f
+}
+
+// WithTimeout kills a child process which does not complete an action, or does not exit after the shutdown of
+// the record processor, within the timeout. The worker waits for the child processes indefinitely without timeout.
+func (f *ProcessorFactory) WithTimeout(timeout time.Duration) *ProcessorFactory {
+	f.timeout = timeout
+	return f
+}
+
+// WithLogger logs the output of the child processes on stderr and their failures with the logger
+func (f *ProcessorFactory) WithLogger(log logger.Logger) *ProcessorFactory {
+	f.log = log
+	return f
+}
+
+// CreateProcessor returns a record processor which starts a child process when it is initialized
+func (f *ProcessorFactory) CreateProcessor() kcl.IRecordProcessor {
+	return &processor{factory: f, log: f.log}
+}
+
+// processor is a record processor delegating to a child process. After a failure of the child process, e.g. if it
+// exited or violated the protocol, the child process is killed and the failure is returned for the next batches of
+// records, so that the shard is processed again by a new record processor depending on the error policy.
+type processor struct {
+	factory *ProcessorFactory
+	shardID string
+	log     logger.Logger
+
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	encoder *json.Encoder
+	// lines are the lines of the child process on stdout, closed at the end of the output
+	lines chan []byte
+	// exited is closed once the child process has exited with waitErr
+	exited  chan struct{}
+	waitErr error
+
+	// err is the failure of the child process
+	err error
+
+	// lastRecord is the last delivered record, which is checkpointed for a checkpoint without sequence number
+	// requested on shutdown
+	lastRecord *kcl.UserRecord
+}
+
+// checkpointFunc checkpoints the sequence number requested by the child process, either of them may be nil
+type checkpointFunc func(sequenceNumber *string, subSequenceNumber *int64) error
+
+// errNoCheckpointOnInitialize is reported to the child process for checkpoints requested by initialize
+var errNoCheckpointOnInitialize = errors.New("checkpoints cannot be requested during initialize")
+
+// rejectCheckpoint rejects all checkpoints with err
+func rejectCheckpoint(err error) checkpointFunc {
+	return func(*string, *int64) error {
+		return err
+	}
+}
+
+func (p *processor) Initialize(input *kcl.InitializationInput) {
+	p.shardID = input.ShardId
+	p.log = p.factory.log.WithFields(logger.Fields{"shardID": input.ShardId})
+	if err := p.start(); err != nil {
+		p.err = fmt.Errorf("failed to start the child process: %w", err)
+		p.log.Errorf("%v", p.err)
+		return
+	}
+
+	message := &initializeMessage{Action: actionInitialize, ShardID: input.ShardId}
+	if input.ExtendedSequenceNumber != nil {
+		message.SequenceNumber = input.ExtendedSequenceNumber.SequenceNumber
+		message.SubSequenceNumber = input.ExtendedSequenceNumber.SubSequenceNumber
+	}
+	if err := p.perform(actionInitialize, message, rejectCheckpoint(errNoCheckpointOnInitialize)); err != nil {
+		p.log.Errorf("Failed to initialize the child process: %+v", err)
+	}
+}
+
+func (p *processor) ProcessRecords(input *kcl.ProcessRecordsInput) error {
+	records := input.AllUserRecords()
+	err := p.perform(actionProcessRecords, newProcessRecordsMessage(input), func(sequenceNumber *string, subSequenceNumber *int64) error {
+		// a checkpoint without sequence number checkpoints the last record of the batch
+		if sequenceNumber == nil {
+			if len(records) == 0 {
+				return nil
+			}
+			return checkpointRecord(input.Checkpointer, &records[len(records)-1])
+		}
+		return checkpoint(input.Checkpointer, sequenceNumber, subSequenceNumber)
+	})
+	if len(records) > 0 {
+		p.lastRecord = &records[len(records)-1]
+	}
+	return err
+}
+
+func (p *processor) Shutdown(input *kcl.ShutdownInput) {
+	defer p.stop()
+
+	var action string
+	var checkpointer checkpointFunc
+	switch input.ShutdownReason {
+	case kcl.TERMINATE:
+		// a checkpoint without sequence number checkpoints the end of the shard
+		action = actionShardEnded
+		checkpointer = func(sequenceNumber *string, subSequenceNumber *int64) error {
+			return checkpoint(input.Checkpointer, sequenceNumber, subSequenceNumber)
+		}
+	case kcl.ZOMBIE:
+		action = actionLeaseLost
+		checkpointer = rejectCheckpoint(chk.NewLeaseLostError(p.shardID, nil))
+	case kcl.REQUESTED:
+		action = actionShutdownRequested
+		checkpointer = func(sequenceNumber *string, subSequenceNumber *int64) error {
+			if sequenceNumber == nil {
+				if p.lastRecord == nil {
+					return nil
+				}
+				return checkpointRecord(input.Checkpointer, p.lastRecord)
+			}
+			return checkpoint(input.Checkpointer, sequenceNumber, subSequenceNumber)
+		}
+	default:
+		p.log.Errorf("Unsupported shutdown reason %d", input.ShutdownReason)
+		return
+	}
+
+	if err := p.perform(action, &actionMessage{Action: action}, checkpointer); err != nil {
+		p.log.Errorf("Failed to shut down the child process: %+v", err)
+	}
+}
+
+func checkpoint(checkpointer kcl.IRecordProcessorCheckpointer, sequenceNumber *string, subSequenceNumber *int64) error {
+	if subSequenceNumber != nil && sequenceNumber != nil {
+		return checkpointer.CheckpointWithSubSequence(sequenceNumber, *subSequenceNumber)
+	}
+	return checkpointer.Checkpoint(sequenceNumber)
+}
+
+func checkpointRecord(checkpointer kcl.IRecordProcessorCheckpointer, record *kcl.UserRecord) error {
+	if record.Aggregated {
+		return checkpointer.CheckpointWithSubSequence(record.SequenceNumber, record.SubSequenceNumber)
+	}
+	return checkpointer.Checkpoint(record.SequenceNumber)
+}
+
+// start starts the child process with goroutines reading its output
+func (p *processor) start() error {
+	cmd := exec.Command(p.factory.command, p.factory.args...)
+	cmd.Dir = p.factory.dir
+	if len(p.factory.env) > 0 {
+		cmd.Env = append(os.Environ(), p.factory.env...)
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	p.cmd = cmd
+	p.stdin = stdin
+	p.encoder = json.NewEncoder(stdin)
+	p.lines = make(chan []byte)
+	p.exited = make(chan struct{})
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		defer close(p.lines)
+		reader := bufio.NewReader(stdout)
+		for {
+			line, err := reader.ReadBytes('\n')
+			if line = bytes.TrimSpace(line); len(line) > 0 {
+				p.lines <- line
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			p.log.Infof("%s", scanner.Text())
+		}
+	}()
+	// the output is read until the end before waiting for the child process, which closes the pipes
+	go func() {
+		wg.Wait()
+		p.waitErr = cmd.Wait()
+		close(p.exited)
+	}()
+	return nil
+}
+
+// perform sends the message of the action to the child process and handles its checkpoint requests by checkpointer
+// until the action has been completed
+func (p *processor) perform(action string, message interface{}, checkpointer checkpointFunc) error {
+	if p.err != nil {
+		return p.err
+	}
+	if err := p.encoder.Encode(message); err != nil {
+		return p.fail(fmt.Errorf("failed to send %s to the child process: %w", action, err))
+	}
+
+	var timeout <-chan time.Time
+	if p.factory.timeout > 0 {
+		timer := time.NewTimer(p.factory.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	for {
+		select {
+		case line, ok := <-p.lines:
+			if !ok {
+				<-p.exited
+				return p.fail(fmt.Errorf("the child process exited during %s: %v", action, p.waitErr))
+			}
+
+			var response childMessage
+			if err := json.Unmarshal(line, &response); err != nil {
+				p.log.Warnf("Ignoring output of the child process which is not a message: %s", line)
+				continue
+			}
+			switch response.Action {
+			case actionStatus:
+				if response.ResponseFor != action {
+					return p.fail(fmt.Errorf("the child process responded for %s instead of %s", response.ResponseFor, action))
+				}
+				return nil
+			case actionCheckpoint:
+				result := p.checkpoint(action, &response, checkpointer)
+				if err := p.encoder.Encode(result); err != nil {
+					return p.fail(fmt.Errorf("failed to send the checkpoint result to the child process: %w", err))
+				}
+			default:
+				return p.fail(fmt.Errorf("unexpected action %s of the child process during %s", response.Action, action))
+			}
+		case <-timeout:
+			return p.fail(fmt.Errorf("the child process did not complete %s within %v", action, p.factory.timeout))
+		}
+	}
+}
+
+// checkpoint checkpoints the request of the child process and returns the response to it
+func (p *processor) checkpoint(action string, request *childMessage, checkpointer checkpointFunc) *checkpointMessage {
+	response := &checkpointMessage{
+		Action:            actionCheckpoint,
+		SequenceNumber:    request.SequenceNumber,
+		SubSequenceNumber: request.SubSequenceNumber,
+	}
+
+	if err := checkpointer(request.SequenceNumber, request.SubSequenceNumber); err != nil {
+		p.log.Warnf("Failed to checkpoint for the child process during %s: %+v", action, err)
+		exception := exceptionName(err)
+		response.Error = &exception
+	}
+	return response
+}
+
+// fail kills the child process after a failure, which is returned for all further actions
+func (p *processor) fail(err error) error {
+	p.err = err
+	_ = p.cmd.Process.Kill()
+	return err
+}
+
+// stop closes stdin of the child process and waits for it to exit
+func (p *processor) stop() {
+	if p.cmd == nil {
+		return
+	}
+	_ = p.stdin.Close()
+
+	// the lines which have not been read by an action are discarded, so that the output is read until its end
+	go func() {
+		for range p.lines {
+		}
+	}()
+
+	var timeout <-chan time.Time
+	if p.factory.timeout > 0 {
+		timer := time.NewTimer(p.factory.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-p.exited:
+	case <-timeout:
+		p.log.Warnf("Killing the child process which did not exit within %v", p.factory.timeout)
+		_ = p.cmd.Process.Kill()
+		<-p.exited
+	}
+	if p.waitErr != nil && p.err == nil {
+		p.log.Warnf("The child process exited with %v", p.waitErr)
+	}
+}
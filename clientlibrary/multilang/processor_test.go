@@ -0,0 +1,232 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package multilang
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/stretchr/testify/assert"
+
+	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+	"github.com/vmware/vmware-go-kcl-v2/logger"
+)
+
+// TestHelperProcess is the child process of the tests, a record processor written with the MultiLang protocol
+// which checkpoints at the end of each action and reports the results of the checkpoints on stderr
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("KCL_MULTILANG_HELPER") != "1" {
+		return
+	}
+	mode := os.Getenv("KCL_MULTILANG_MODE")
+
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(nil, 1024*1024)
+	send := func(message interface{}) {
+		line, _ := json.Marshal(message)
+		fmt.Println(string(line))
+	}
+	checkpoint := func(sequenceNumber interface{}) {
+		send(map[string]interface{}{"action": "checkpoint", "sequenceNumber": sequenceNumber, "subSequenceNumber": nil})
+		scanner.Scan()
+		fmt.Fprintf(os.Stderr, "checkpoint %s\n", scanner.Text())
+	}
+
+	for scanner.Scan() {
+		var message struct {
+			Action  string `json:"action"`
+			ShardID string `json:"shardId"`
+			Records []struct {
+				Data           []byte `json:"data"`
+				SequenceNumber string `json:"sequenceNumber"`
+			} `json:"records"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &message); err != nil {
+			os.Exit(2)
+		}
+
+		switch message.Action {
+		case "initialize":
+			fmt.Fprintf(os.Stderr, "initialized %s\n", message.ShardID)
+		case "processRecords":
+			switch mode {
+			case "exit":
+				os.Exit(3)
+			case "hang":
+				time.Sleep(time.Minute)
+			}
+			fmt.Println("not a message")
+			for _, record := range message.Records {
+				fmt.Fprintf(os.Stderr, "record %s %s\n", record.SequenceNumber, record.Data)
+			}
+			checkpoint(message.Records[0].SequenceNumber)
+			checkpoint(nil)
+		default:
+			checkpoint(nil)
+		}
+		send(map[string]string{"action": "status", "responseFor": message.Action})
+	}
+	os.Exit(0)
+}
+
+// recordingLogger records the messages logged at info level
+type recordingLogger struct {
+	logger.Logger
+
+	mux      sync.Mutex
+	messages []string
+}
+
+func (l *recordingLogger) Infof(format string, args ...interface{}) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.messages = append(l.messages, fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) WithFields(logger.Fields) logger.Logger { return l }
+
+func (l *recordingLogger) recorded() []string {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	return append([]string(nil), l.messages...)
+}
+
+// recordingCheckpointer records the checkpoints, which fail with err
+type recordingCheckpointer struct {
+	kcl.IRecordProcessorCheckpointer
+
+	checkpoints []string
+	err         error
+}
+
+func (c *recordingCheckpointer) Checkpoint(sequenceNumber *string) error {
+	c.checkpoints = append(c.checkpoints, aws.ToString(sequenceNumber))
+	return c.err
+}
+
+func (c *recordingCheckpointer) CheckpointWithSubSequence(sequenceNumber *string, subSequenceNumber int64) error {
+	c.checkpoints = append(c.checkpoints, fmt.Sprintf("%s/%d", aws.ToString(sequenceNumber), subSequenceNumber))
+	return c.err
+}
+
+func newTestFactory(log logger.Logger, mode string) *ProcessorFactory {
+	return NewProcessorFactory(os.Args[0], "-test.run=TestHelperProcess").
+		WithEnv("KCL_MULTILANG_HELPER=1", "KCL_MULTILANG_MODE="+mode).
+		WithLogger(log).
+		WithTimeout(5 * time.Second)
+}
+
+func TestProcessor(t *testing.T) {
+	log := &recordingLogger{Logger: logger.GetDefaultLogger()}
+	p := newTestFactory(log, "").CreateProcessor()
+	checkpointer := &recordingCheckpointer{}
+
+	p.Initialize(&kcl.InitializationInput{ShardId: "shardId-0"})
+	arrival := time.UnixMilli(1700000000000)
+	assert.Nil(t, p.ProcessRecords(&kcl.ProcessRecordsInput{
+		Checkpointer: checkpointer,
+		UserRecords: []kcl.UserRecord{
+			{Record: types.Record{SequenceNumber: aws.String("100"), Data: []byte("a"), ApproximateArrivalTimestamp: &arrival}},
+			{Record: types.Record{SequenceNumber: aws.String("101"), Data: []byte("b")}, SubSequenceNumber: 2, Aggregated: true},
+		},
+	}))
+	// a checkpoint without sequence number checkpoints the last record, also on shutdown
+	assert.Equal(t, []string{"100", "101/2"}, checkpointer.checkpoints)
+
+	p.Shutdown(&kcl.ShutdownInput{ShutdownReason: kcl.REQUESTED, Checkpointer: checkpointer})
+	assert.Equal(t, []string{"100", "101/2", "101/2"}, checkpointer.checkpoints)
+
+	assert.Equal(t, []string{
+		"initialized shardId-0",
+		"record 100 a",
+		"record 101 b",
+		`checkpoint {"action":"checkpoint","sequenceNumber":"100","subSequenceNumber":null,"error":null}`,
+		`checkpoint {"action":"checkpoint","sequenceNumber":null,"subSequenceNumber":null,"error":null}`,
+		`checkpoint {"action":"checkpoint","sequenceNumber":null,"subSequenceNumber":null,"error":null}`,
+	}, log.recorded())
+}
+
+func TestProcessorShutdown(t *testing.T) {
+	log := &recordingLogger{Logger: logger.GetDefaultLogger()}
+	factory := newTestFactory(log, "")
+
+	// the end of a closed shard is checkpointed without sequence number
+	p := factory.CreateProcessor()
+	checkpointer := &recordingCheckpointer{}
+	p.Initialize(&kcl.InitializationInput{ShardId: "shardId-0"})
+	p.Shutdown(&kcl.ShutdownInput{ShutdownReason: kcl.TERMINATE, Checkpointer: checkpointer})
+	assert.Equal(t, []string{""}, checkpointer.checkpoints)
+
+	// checkpoints fail after the lease has been lost
+	p = factory.CreateProcessor()
+	p.Initialize(&kcl.InitializationInput{ShardId: "shardId-1"})
+	p.Shutdown(&kcl.ShutdownInput{ShutdownReason: kcl.ZOMBIE})
+
+	// the failures of checkpoints are reported as exceptions of the Java KCL
+	p = factory.CreateProcessor()
+	checkpointer = &recordingCheckpointer{err: chk.NewThrottlingError("PutItem", nil)}
+	p.Initialize(&kcl.InitializationInput{ShardId: "shardId-2"})
+	p.Shutdown(&kcl.ShutdownInput{ShutdownReason: kcl.TERMINATE, Checkpointer: checkpointer})
+
+	messages := log.recorded()
+	assert.Len(t, messages, 6)
+	assert.Contains(t, messages, `checkpoint {"action":"checkpoint","sequenceNumber":null,"subSequenceNumber":null,"error":"ShutdownException"}`)
+	assert.Contains(t, messages, `checkpoint {"action":"checkpoint","sequenceNumber":null,"subSequenceNumber":null,"error":"ThrottlingException"}`)
+}
+
+func TestProcessorFailures(t *testing.T) {
+	log := &recordingLogger{Logger: logger.GetDefaultLogger()}
+	input := &kcl.ProcessRecordsInput{
+		Checkpointer: &recordingCheckpointer{},
+		UserRecords:  []kcl.UserRecord{{Record: types.Record{SequenceNumber: aws.String("100")}}},
+	}
+
+	// the failure of a child process which exited is returned for all further batches
+	p := newTestFactory(log, "exit").CreateProcessor()
+	p.Initialize(&kcl.InitializationInput{ShardId: "shardId-0"})
+	err := p.ProcessRecords(input)
+	assert.NotNil(t, err)
+	assert.True(t, strings.Contains(err.Error(), "exited during processRecords"), err.Error())
+	assert.Equal(t, err, p.ProcessRecords(input))
+	p.Shutdown(&kcl.ShutdownInput{ShutdownReason: kcl.ZOMBIE})
+
+	// a child process which does not complete an action is killed
+	p = newTestFactory(log, "hang").WithTimeout(100 * time.Millisecond).CreateProcessor()
+	p.Initialize(&kcl.InitializationInput{ShardId: "shardId-1"})
+	err = p.ProcessRecords(input)
+	assert.NotNil(t, err)
+	assert.True(t, strings.Contains(err.Error(), "did not complete processRecords"), err.Error())
+	p.Shutdown(&kcl.ShutdownInput{ShutdownReason: kcl.ZOMBIE})
+
+	// a command which cannot be started fails the batches
+	p = NewProcessorFactory("/nonexistent/processor").WithLogger(log).CreateProcessor()
+	p.Initialize(&kcl.InitializationInput{ShardId: "shardId-2"})
+	assert.NotNil(t, p.ProcessRecords(input))
+	p.Shutdown(&kcl.ShutdownInput{ShutdownReason: kcl.ZOMBIE})
+}
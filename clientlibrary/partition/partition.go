@@ -37,17 +37,61 @@ import (
 )
 
 type ShardStatus struct {
-	ID            string
+	// ID is the lease key of the shard. In multi-stream mode it is namespaced by the stream name.
+	ID string
+	// ShardID is the id of the shard in the stream, it is only set in multi-stream mode
+	ShardID string
+	// StreamName is the stream of the shard in multi-stream mode
+	StreamName string
+	// StreamARN is the ARN of the stream of the shard in multi-stream mode, if the stream is identified by its ARN
+	StreamARN     string
 	ParentShardId string
-	Checkpoint    string
-	AssignedTo    string
-	Mux           *sync.RWMutex
-	LeaseTimeout  time.Time
-	// Shard Range
+	// AdjacentParentShardId is the second parent of a shard resulting from a merge
+	AdjacentParentShardId string
+	Checkpoint            string
+	// SubSequenceNumber is the checkpointed user record within an aggregated KPL record.
+	// It is nil when the checkpoint covers the whole Kinesis record.
+	SubSequenceNumber *int64
+	// PendingCheckpoint is the prepared but not yet committed checkpoint (two-phase checkpointing)
+	PendingCheckpoint string
+	// CheckpointMetadata is the opaque metadata the record processor stored with the checkpoint
+	CheckpointMetadata []byte
+	AssignedTo         string
+	Mux                *sync.RWMutex
+	LeaseTimeout       time.Time
+	// LeaseCounter is incremented by the lease table each time the lease is taken or renewed, the checkpoints of
+	// the lease owner are fenced by it
+	LeaseCounter int64
+	// Shard Range, it is refreshed by every listing of the shards. A child shard found in the ChildShards of its
+	// closed parent has no range until the shards are listed.
 	StartingSequenceNumber string
 	// child shard doesn't have end sequence number
 	EndingSequenceNumber string
 	ClaimRequest         string
+	// StartingHashKey and EndingHashKey are the inclusive range of the hashed partition keys of the records of the
+	// shard as decimal 128-bit integers
+	StartingHashKey string
+	EndingHashKey   string
+}
+
+// GetShardID returns the id of the shard in the stream
+func (ss *ShardStatus) GetShardID() string {
+	if ss.ShardID != "" {
+		return ss.ShardID
+	}
+	return ss.ID
+}
+
+// GetParentShardIds returns the shards which have to be processed completely before this shard after resharding
+func (ss *ShardStatus) GetParentShardIds() []string {
+	var parents []string
+	if ss.ParentShardId != "" {
+		parents = append(parents, ss.ParentShardId)
+	}
+	if ss.AdjacentParentShardId != "" {
+		parents = append(parents, ss.AdjacentParentShardId)
+	}
+	return parents
 }
 
 func (ss *ShardStatus) GetLeaseOwner() string {
@@ -62,6 +106,18 @@ func (ss *ShardStatus) SetLeaseOwner(owner string) {
 	ss.AssignedTo = owner
 }
 
+func (ss *ShardStatus) GetLeaseCounter() int64 {
+	ss.Mux.RLock()
+	defer ss.Mux.RUnlock()
+	return ss.LeaseCounter
+}
+
+func (ss *ShardStatus) SetLeaseCounter(counter int64) {
+	ss.Mux.Lock()
+	defer ss.Mux.Unlock()
+	ss.LeaseCounter = counter
+}
+
 func (ss *ShardStatus) GetCheckpoint() string {
 	ss.Mux.RLock()
 	defer ss.Mux.RUnlock()
@@ -74,6 +130,73 @@ func (ss *ShardStatus) SetCheckpoint(c string) {
 	ss.Checkpoint = c
 }
 
+// GetSequenceNumberRange returns the starting and the ending sequence number of the shard, the ending sequence
+// number is empty while the shard is open
+func (ss *ShardStatus) GetSequenceNumberRange() (string, string) {
+	ss.Mux.RLock()
+	defer ss.Mux.RUnlock()
+	return ss.StartingSequenceNumber, ss.EndingSequenceNumber
+}
+
+// SetSequenceNumberRange sets the sequence number range of the shard from a listing of the shards
+func (ss *ShardStatus) SetSequenceNumberRange(starting, ending string) {
+	ss.Mux.Lock()
+	defer ss.Mux.Unlock()
+	ss.StartingSequenceNumber, ss.EndingSequenceNumber = starting, ending
+}
+
+func (ss *ShardStatus) GetSubSequenceNumber() *int64 {
+	ss.Mux.RLock()
+	defer ss.Mux.RUnlock()
+	if ss.SubSequenceNumber == nil {
+		return nil
+	}
+	subSequenceNumber := *ss.SubSequenceNumber
+	return &subSequenceNumber
+}
+
+func (ss *ShardStatus) SetSubSequenceNumber(subSequenceNumber *int64) {
+	ss.Mux.Lock()
+	defer ss.Mux.Unlock()
+	ss.SubSequenceNumber = subSequenceNumber
+}
+
+func (ss *ShardStatus) GetPendingCheckpoint() string {
+	ss.Mux.RLock()
+	defer ss.Mux.RUnlock()
+	return ss.PendingCheckpoint
+}
+
+func (ss *ShardStatus) SetPendingCheckpoint(c string) {
+	ss.Mux.Lock()
+	defer ss.Mux.Unlock()
+	ss.PendingCheckpoint = c
+}
+
+func (ss *ShardStatus) GetCheckpointMetadata() []byte {
+	ss.Mux.RLock()
+	defer ss.Mux.RUnlock()
+	return ss.CheckpointMetadata
+}
+
+func (ss *ShardStatus) SetCheckpointMetadata(metadata []byte) {
+	ss.Mux.Lock()
+	defer ss.Mux.Unlock()
+	ss.CheckpointMetadata = metadata
+}
+
+func (ss *ShardStatus) GetClaimRequest() string {
+	ss.Mux.RLock()
+	defer ss.Mux.RUnlock()
+	return ss.ClaimRequest
+}
+
+func (ss *ShardStatus) SetClaimRequest(c string) {
+	ss.Mux.Lock()
+	defer ss.Mux.Unlock()
+	ss.ClaimRequest = c
+}
+
 func (ss *ShardStatus) GetLeaseTimeout() time.Time {
 	ss.Mux.Lock()
 	defer ss.Mux.Unlock()
@@ -0,0 +1,379 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package scaling
+// The Advisor recommends the number of workers of an application and the shard count of its streams from the
+// throughput and the lag of the shards reported by the status of its workers, e.g. for autoscalers built on top of
+// the KCL. The throughput of a shard is the rate of the records delivered to its record processor between two
+// observations, it is the write rate of the shard as long as the consumer keeps up with it.
+package scaling
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/worker"
+	"github.com/vmware/vmware-go-kcl-v2/logger"
+)
+
+const (
+	// DefaultShardRecordsPerSecond is the number of records per second which can be written to a shard
+	DefaultShardRecordsPerSecond = 1000
+
+	// DefaultShardBytesPerSecond is the number of bytes per second which can be written to a shard
+	DefaultShardBytesPerSecond = 1024 * 1024
+
+	// DefaultTargetUtilization is the share of the write capacity of the shards a stream is scaled to
+	DefaultTargetUtilization = 0.7
+
+	// DefaultMaxMillisBehindLatest is the lag of a shard above which more workers are recommended
+	DefaultMaxMillisBehindLatest = 60000
+)
+
+type (
+	// Recommendation is the scaling advice of an observation of the workers of an application
+	Recommendation struct {
+		// Time is the time of the observation
+		Time time.Time
+
+		// CurrentWorkers is the number of running workers which have been observed
+		CurrentWorkers int
+
+		// TargetWorkers is the recommended number of workers
+		TargetWorkers int
+
+		// Streams are the recommendations for the consumed streams ordered by name
+		Streams []StreamRecommendation
+
+		// Reasons explain the recommended changes
+		Reasons []string
+	}
+
+	// StreamRecommendation is the scaling advice for a stream
+	StreamRecommendation struct {
+		StreamName string
+
+		// CurrentShards is the number of consumed shards of the stream
+		CurrentShards int
+
+		// TargetShardCount is the recommended shard count for UpdateShardCount, Kinesis allows doubling or halving
+		// the shard count at most in one call
+		TargetShardCount int
+
+		// RecordsPerSecond and BytesPerSecond are the total throughput of the shards measured since the previous
+		// observation
+		RecordsPerSecond float64
+		BytesPerSecond   float64
+
+		// Utilization is the share of the write capacity of the shards used by the throughput
+		Utilization float64
+
+		// MaxMillisBehindLatest is the max lag of the shards
+		MaxMillisBehindLatest int64
+
+		// Shards are the loads of the shards ordered by their lease key
+		Shards []ShardLoad
+	}
+
+	// ShardLoad is the throughput and the lag of a consumed shard
+	ShardLoad struct {
+		ShardID  string
+		WorkerID string
+
+		RecordsPerSecond   float64
+		BytesPerSecond     float64
+		MillisBehindLatest int64
+
+		// Measured is false until the shard has been observed twice on the same worker, its throughput is 0 then
+		Measured bool
+	}
+
+	// Callback is called with the recommendations which change the number of workers or the shard count of a stream
+	Callback func(ctx context.Context, recommendation *Recommendation)
+
+	// StatusSource returns the status of all workers of the application, e.g. from their status handlers
+	StatusSource func(ctx context.Context) ([]worker.WorkerStatus, error)
+
+	// Advisor recommends the scaling of an application from successive observations of its workers
+	Advisor struct {
+		streamName            string
+		shardRecordsPerSecond float64
+		shardBytesPerSecond   float64
+		targetUtilization     float64
+		maxShardsPerWorker    int
+		maxMillisBehindLatest int64
+		callback              Callback
+		log                   logger.Logger
+
+		// samples are the counters of the shards at the previous observation
+		samples map[string]sample
+	}
+
+	sample struct {
+		workerID string
+		time     time.Time
+		records  int64
+		bytes    int64
+	}
+)
+
+// NewAdvisor returns an advisor for an application consuming the stream in single-stream mode, the stream names of
+// the shards are used in multi-stream mode.
+func NewAdvisor(streamName string) *Advisor {
+	return &Advisor{
+		streamName:            streamName,
+		shardRecordsPerSecond: DefaultShardRecordsPerSecond,
+		shardBytesPerSecond:   DefaultShardBytesPerSecond,
+		targetUtilization:     DefaultTargetUtilization,
+		maxMillisBehindLatest: DefaultMaxMillisBehindLatest,
+		log:                   logger.GetDefaultLogger(),
+		samples:               make(map[string]sample),
+	}
+}
+
+// WithShardCapacity sets the records and the bytes per second which can be written to a shard
+func (a *Advisor) WithShardCapacity(recordsPerSecond, bytesPerSecond float64) *Advisor {
+	a.shardRecordsPerSecond = recordsPerSecond
+	a.shardBytesPerSecond = bytesPerSecond
+	return a
+}
+
+// WithTargetUtilization sets the share of the capacity of the shards the streams are scaled to. More shards are
+// recommended above it, fewer shards below half of it.
+func (a *Advisor) WithTargetUtilization(utilization float64) *Advisor {
+	a.targetUtilization = utilization
+	return a
+}
+
+// WithMaxShardsPerWorker sets the number of shards a worker can consume, e.g. the MaxLeasesForWorker of the
+// workers. The number of workers is only reduced if it is set.
+func (a *Advisor) WithMaxShardsPerWorker(shards int) *Advisor {
+	a.maxShardsPerWorker = shards
+	return a
+}
+
+// WithMaxMillisBehindLatest sets the lag of a shard above which another worker is recommended
+func (a *Advisor) WithMaxMillisBehindLatest(millis int64) *Advisor {
+	a.maxMillisBehindLatest = millis
+	return a
+}
+
+// WithCallback calls the callback with the recommendations which change the scaling of the application
+func (a *Advisor) WithCallback(callback Callback) *Advisor {
+	a.callback = callback
+	return a
+}
+
+// WithLogger sets the logger of the errors of the status source of Run
+func (a *Advisor) WithLogger(log logger.Logger) *Advisor {
+	a.log = log
+	return a
+}
+
+// Changed tells whether the recommendation changes the number of workers or the shard count of a stream
+func (r *Recommendation) Changed() bool {
+	if r.TargetWorkers != r.CurrentWorkers {
+		return true
+	}
+	for _, stream := range r.Streams {
+		if stream.TargetShardCount != stream.CurrentShards {
+			return true
+		}
+	}
+	return false
+}
+
+// UpdateShardCountInput returns the request scaling the stream uniformly to the target shard count, nil if the
+// shard count is not changed
+func (s *StreamRecommendation) UpdateShardCountInput() *kinesis.UpdateShardCountInput {
+	if s.TargetShardCount == s.CurrentShards {
+		return nil
+	}
+	return &kinesis.UpdateShardCountInput{
+		StreamName:       aws.String(s.StreamName),
+		TargetShardCount: aws.Int32(int32(s.TargetShardCount)),
+		ScalingType:      types.ScalingTypeUniformScaling,
+	}
+}
+
+// Run observes the workers returned by the source every interval until ctx is done. The recommendations are
+// passed to the callback, a failed observation is logged and skipped.
+func (a *Advisor) Run(ctx context.Context, interval time.Duration, source StatusSource) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case now := <-ticker.C:
+			statuses, err := source(ctx)
+			if err != nil {
+				a.log.Errorf("Failed to get the status of the workers: %+v", err)
+				continue
+			}
+			a.observe(ctx, now, statuses)
+		}
+	}
+}
+
+// Observe records the status of the workers of the application and returns the recommendation. The throughput
+// is measured from the previous observation, the callback is called if the recommendation changes the scaling.
+// Observe is not safe for concurrent use.
+func (a *Advisor) Observe(now time.Time, statuses ...worker.WorkerStatus) *Recommendation {
+	return a.observe(context.Background(), now, statuses)
+}
+
+func (a *Advisor) observe(ctx context.Context, now time.Time, statuses []worker.WorkerStatus) *Recommendation {
+	recommendation := &Recommendation{Time: now}
+	streams := make(map[string]*StreamRecommendation)
+	samples := make(map[string]sample, len(a.samples))
+
+	for _, status := range statuses {
+		if !status.Running {
+			continue
+		}
+		recommendation.CurrentWorkers++
+
+		for _, shard := range status.Shards {
+			streamName := shard.StreamName
+			if streamName == "" {
+				streamName = a.streamName
+			}
+			stream, ok := streams[streamName]
+			if !ok {
+				stream = &StreamRecommendation{StreamName: streamName}
+				streams[streamName] = stream
+			}
+
+			load := ShardLoad{ShardID: shard.ShardID, WorkerID: status.WorkerID, MillisBehindLatest: shard.MillisBehindLatest}
+			key := streamName + "/" + shard.ShardID
+			current := sample{workerID: status.WorkerID, time: now, records: shard.RecordsProcessed, bytes: shard.BytesProcessed}
+			// the counters start over when the lease moves to another worker
+			if previous, ok := a.samples[key]; ok && previous.workerID == current.workerID && now.After(previous.time) &&
+				current.records >= previous.records && current.bytes >= previous.bytes {
+				seconds := now.Sub(previous.time).Seconds()
+				load.RecordsPerSecond = float64(current.records-previous.records) / seconds
+				load.BytesPerSecond = float64(current.bytes-previous.bytes) / seconds
+				load.Measured = true
+			}
+			samples[key] = current
+
+			stream.CurrentShards++
+			stream.RecordsPerSecond += load.RecordsPerSecond
+			stream.BytesPerSecond += load.BytesPerSecond
+			if load.MillisBehindLatest > stream.MaxMillisBehindLatest {
+				stream.MaxMillisBehindLatest = load.MillisBehindLatest
+			}
+			stream.Shards = append(stream.Shards, load)
+		}
+	}
+	a.samples = samples
+
+	lagging := false
+	targetShards := 0
+	for _, stream := range streams {
+		a.adviseShardCount(stream, recommendation)
+		targetShards += stream.TargetShardCount
+		if stream.MaxMillisBehindLatest > a.maxMillisBehindLatest {
+			lagging = true
+		}
+		sort.Slice(stream.Shards, func(i, j int) bool { return stream.Shards[i].ShardID < stream.Shards[j].ShardID })
+		recommendation.Streams = append(recommendation.Streams, *stream)
+	}
+	sort.Slice(recommendation.Streams, func(i, j int) bool {
+		return recommendation.Streams[i].StreamName < recommendation.Streams[j].StreamName
+	})
+	a.adviseWorkers(lagging, targetShards, recommendation)
+
+	if a.callback != nil && recommendation.Changed() {
+		a.callback(ctx, recommendation)
+	}
+	return recommendation
+}
+
+// adviseShardCount recommends the shard count of the stream from the utilization of its shards. The shard count is
+// kept until all shards have been measured.
+func (a *Advisor) adviseShardCount(stream *StreamRecommendation, recommendation *Recommendation) {
+	stream.TargetShardCount = stream.CurrentShards
+	for _, shard := range stream.Shards {
+		if !shard.Measured {
+			return
+		}
+	}
+
+	stream.Utilization = math.Max(stream.RecordsPerSecond/(float64(stream.CurrentShards)*a.shardRecordsPerSecond),
+		stream.BytesPerSecond/(float64(stream.CurrentShards)*a.shardBytesPerSecond))
+	needed := int(math.Ceil(math.Max(stream.RecordsPerSecond/(a.shardRecordsPerSecond*a.targetUtilization),
+		stream.BytesPerSecond/(a.shardBytesPerSecond*a.targetUtilization))))
+	if needed < 1 {
+		needed = 1
+	}
+
+	switch {
+	case stream.Utilization > a.targetUtilization && needed > stream.CurrentShards:
+		stream.TargetShardCount = minInt(needed, 2*stream.CurrentShards)
+	case stream.Utilization < a.targetUtilization/2 && needed < stream.CurrentShards:
+		stream.TargetShardCount = maxInt(needed, (stream.CurrentShards+1)/2)
+	default:
+		return
+	}
+	recommendation.Reasons = append(recommendation.Reasons, fmt.Sprintf("the shards of stream %s are %.0f%% utilized, the target is %.0f%%",
+		stream.StreamName, 100*stream.Utilization, 100*a.targetUtilization))
+}
+
+// adviseWorkers recommends another worker while a shard is lagging, and as many workers as needed for the target
+// shards if the number of shards per worker is known
+func (a *Advisor) adviseWorkers(lagging bool, targetShards int, recommendation *Recommendation) {
+	target := recommendation.CurrentWorkers
+	if a.maxShardsPerWorker > 0 {
+		target = (targetShards + a.maxShardsPerWorker - 1) / a.maxShardsPerWorker
+	}
+	if lagging {
+		// a shard is consumed by a single worker, more workers than shards are idle
+		target = maxInt(target, minInt(recommendation.CurrentWorkers+1, targetShards))
+	}
+	if lagging && target > recommendation.CurrentWorkers {
+		recommendation.Reasons = append(recommendation.Reasons, fmt.Sprintf("the shards are more than %d ms behind the latest records", a.maxMillisBehindLatest))
+	} else if a.maxShardsPerWorker > 0 && target != recommendation.CurrentWorkers {
+		recommendation.Reasons = append(recommendation.Reasons, fmt.Sprintf("%d shards are consumed by workers of at most %d shards", targetShards, a.maxShardsPerWorker))
+	}
+	recommendation.TargetWorkers = maxInt(target, 1)
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,167 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package scaling
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/worker"
+)
+
+// workerStatus returns the status of a running worker consuming the shards with the records processed so far
+func workerStatus(workerID string, records map[string]int64, millisBehindLatest int64) worker.WorkerStatus {
+	status := worker.WorkerStatus{WorkerID: workerID, Running: true}
+	for shardID, n := range records {
+		status.Shards = append(status.Shards, worker.ShardConsumerStatus{
+			ShardID:            shardID,
+			RecordsProcessed:   n,
+			BytesProcessed:     n * 100,
+			MillisBehindLatest: millisBehindLatest,
+		})
+	}
+	return status
+}
+
+func TestAdvisorShardCount(t *testing.T) {
+	var called []*Recommendation
+	advisor := NewAdvisor("stream").WithCallback(func(_ context.Context, r *Recommendation) { called = append(called, r) })
+	now := time.Now()
+
+	// the throughput is unknown at the first observation
+	r := advisor.Observe(now, workerStatus("worker", map[string]int64{"0001": 0, "0002": 0}, 0))
+	assert.Equal(t, 1, r.CurrentWorkers)
+	assert.Equal(t, 1, r.TargetWorkers)
+	assert.Equal(t, 1, len(r.Streams))
+	assert.Equal(t, "stream", r.Streams[0].StreamName)
+	assert.Equal(t, 2, r.Streams[0].TargetShardCount)
+	assert.False(t, r.Streams[0].Shards[0].Measured)
+	assert.False(t, r.Changed())
+	assert.Nil(t, r.Streams[0].UpdateShardCountInput())
+
+	// 1800 records per second exceed the target utilization of 2 shards
+	now = now.Add(10 * time.Second)
+	r = advisor.Observe(now, workerStatus("worker", map[string]int64{"0001": 9000, "0002": 9000}, 0))
+	stream := r.Streams[0]
+	assert.InDelta(t, 1800, stream.RecordsPerSecond, 0.001)
+	assert.InDelta(t, 180000, stream.BytesPerSecond, 0.001)
+	assert.InDelta(t, 0.9, stream.Utilization, 0.001)
+	assert.Equal(t, 3, stream.TargetShardCount)
+	assert.True(t, r.Changed())
+	assert.Equal(t, 1, len(r.Reasons))
+	input := stream.UpdateShardCountInput()
+	assert.Equal(t, "stream", aws.ToString(input.StreamName))
+	assert.Equal(t, int32(3), aws.ToInt32(input.TargetShardCount))
+	assert.Equal(t, types.ScalingTypeUniformScaling, input.ScalingType)
+	assert.Equal(t, 1, len(called))
+
+	// the shard count is halved at most
+	now = now.Add(10 * time.Second)
+	r = advisor.Observe(now, workerStatus("worker", map[string]int64{"0001": 9010, "0002": 9010}, 0))
+	assert.Equal(t, 1, r.Streams[0].TargetShardCount)
+
+	// a shard moved to another worker is measured again
+	now = now.Add(10 * time.Second)
+	r = advisor.Observe(now, workerStatus("worker", map[string]int64{"0001": 9020}, 0), workerStatus("other", map[string]int64{"0002": 10}, 0))
+	assert.Equal(t, 2, r.CurrentWorkers)
+	assert.True(t, r.Streams[0].Shards[0].Measured)
+	assert.False(t, r.Streams[0].Shards[1].Measured)
+	assert.Equal(t, 2, r.Streams[0].TargetShardCount)
+	assert.Equal(t, 2, r.TargetWorkers)
+}
+
+func TestAdvisorWorkers(t *testing.T) {
+	advisor := NewAdvisor("stream").WithMaxShardsPerWorker(2).WithMaxMillisBehindLatest(1000)
+	now := time.Now()
+	shards := map[string]int64{"0001": 0, "0002": 0, "0003": 0, "0004": 0}
+
+	// the workers are lagging behind
+	r := advisor.Observe(now, workerStatus("worker", shards, 5000), workerStatus("other", map[string]int64{}, 0))
+	assert.Equal(t, 2, r.CurrentWorkers)
+	assert.Equal(t, 3, r.TargetWorkers)
+	assert.True(t, r.Changed())
+
+	// the workers are not recommended beyond the number of shards
+	r = advisor.Observe(now, workerStatus("a", shards, 5000), workerStatus("b", nil, 0), workerStatus("c", nil, 0), workerStatus("d", nil, 0))
+	assert.Equal(t, 4, r.TargetWorkers)
+
+	// idle workers are removed once the shards are caught up
+	r = advisor.Observe(now, workerStatus("a", shards, 0), workerStatus("b", nil, 0), workerStatus("c", nil, 0), workerStatus("d", nil, 0))
+	assert.Equal(t, 2, r.TargetWorkers)
+	assert.Equal(t, 1, len(r.Reasons))
+
+	// stopped workers are not counted
+	stopped := workerStatus("e", nil, 0)
+	stopped.Running = false
+	r = advisor.Observe(now, workerStatus("a", shards, 0), workerStatus("b", nil, 0), stopped)
+	assert.Equal(t, 2, r.CurrentWorkers)
+	assert.False(t, r.Changed())
+}
+
+func TestAdvisorMultiStream(t *testing.T) {
+	advisor := NewAdvisor("")
+	status := worker.WorkerStatus{WorkerID: "worker", Running: true, Shards: []worker.ShardConsumerStatus{
+		{ShardID: "0001", StreamName: "b"},
+		{ShardID: "0001", StreamName: "a"},
+	}}
+	r := advisor.Observe(time.Now(), status)
+	assert.Equal(t, 2, len(r.Streams))
+	assert.Equal(t, "a", r.Streams[0].StreamName)
+	assert.Equal(t, "b", r.Streams[1].StreamName)
+}
+
+func TestAdvisorRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	calls := 0
+	recommendations := make(chan *Recommendation, 1)
+	advisor := NewAdvisor("stream").WithCallback(func(_ context.Context, r *Recommendation) {
+		select {
+		case recommendations <- r:
+		default:
+		}
+	})
+	source := func(context.Context) ([]worker.WorkerStatus, error) {
+		calls++
+		if calls == 1 {
+			return nil, errors.New("unavailable")
+		}
+		// the stream is twice as busy as its shard can take
+		return []worker.WorkerStatus{workerStatus("worker", map[string]int64{"0001": int64(calls) * 100}, 0)}, nil
+	}
+	// the callback is called once the throughput has been measured
+	go func() {
+		select {
+		case <-recommendations:
+		case <-time.After(5 * time.Second):
+		}
+		cancel()
+	}()
+	advisor.WithShardCapacity(1, 1e9)
+	assert.ErrorIs(t, advisor.Run(ctx, 10*time.Millisecond, source), context.Canceled)
+	assert.GreaterOrEqual(t, calls, 3)
+}
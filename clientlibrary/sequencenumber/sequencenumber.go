@@ -0,0 +1,141 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package sequencenumber
+// The sequence numbers of Kinesis are unsigned integers of up to 186 bits, encoded as decimal strings. They can be
+// neither compared as strings, their length varies, nor parsed as 64 bit integers. The functions of this package
+// compare them as big integers and order the sentinel checkpoints of the KCL around them.
+package sequencenumber
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// The sentinel checkpoints are ordered AT_TIMESTAMP < TRIM_HORIZON < LATEST < any sequence number < SHARD_END
+const (
+	AtTimestamp = "AT_TIMESTAMP"
+	TrimHorizon = "TRIM_HORIZON"
+	Latest      = "LATEST"
+	ShardEnd    = "SHARD_END"
+)
+
+// ErrInvalidSequenceNumber is matched by errors.Is for values which are neither sequence numbers nor sentinels
+var ErrInvalidSequenceNumber = errors.New("invalid sequence number")
+
+// sentinelRanks are the positions of the sentinels relative to the sequence numbers, which are ranked 0
+var sentinelRanks = map[string]int{
+	AtTimestamp: -3,
+	TrimHorizon: -2,
+	Latest:      -1,
+	ShardEnd:    1,
+}
+
+// Parse returns the integer of the sequence number, which consists of decimal digits only
+func Parse(sequenceNumber string) (*big.Int, error) {
+	if sequenceNumber == "" {
+		return nil, fmt.Errorf("%w: empty value", ErrInvalidSequenceNumber)
+	}
+	for _, c := range sequenceNumber {
+		if c < '0' || c > '9' {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidSequenceNumber, sequenceNumber)
+		}
+	}
+	n, _ := new(big.Int).SetString(sequenceNumber, 10)
+	return n, nil
+}
+
+// IsValid returns true if the value is a sequence number, sentinels are not sequence numbers
+func IsValid(sequenceNumber string) bool {
+	_, err := Parse(sequenceNumber)
+	return err == nil
+}
+
+// IsSentinel returns true if the value is one of the sentinel checkpoints, e.g. TRIM_HORIZON or SHARD_END
+func IsSentinel(value string) bool {
+	_, ok := sentinelRanks[value]
+	return ok
+}
+
+// Compare returns -1 if a is before b, 0 if they are equal and +1 if a is after b. The values are sequence
+// numbers or sentinels, leading zeros of sequence numbers are ignored.
+func Compare(a, b string) (int, error) {
+	rankA, numberA, err := position(a)
+	if err != nil {
+		return 0, err
+	}
+	rankB, numberB, err := position(b)
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case rankA < rankB:
+		return -1, nil
+	case rankA > rankB:
+		return 1, nil
+	case numberA == nil:
+		// the same sentinel
+		return 0, nil
+	default:
+		return numberA.Cmp(numberB), nil
+	}
+}
+
+// Max returns the last of the sequence numbers or sentinels
+func Max(values ...string) (string, error) {
+	return extreme(values, 1)
+}
+
+// Min returns the first of the sequence numbers or sentinels
+func Min(values ...string) (string, error) {
+	return extreme(values, -1)
+}
+
+func extreme(values []string, order int) (string, error) {
+	if len(values) == 0 {
+		return "", fmt.Errorf("%w: no values", ErrInvalidSequenceNumber)
+	}
+
+	result := values[0]
+	// the first value is validated by the comparisons, or on its own if it is the only one
+	if _, _, err := position(result); err != nil {
+		return "", err
+	}
+	for _, value := range values[1:] {
+		cmp, err := Compare(value, result)
+		if err != nil {
+			return "", err
+		}
+		if cmp == order {
+			result = value
+		}
+	}
+	return result, nil
+}
+
+// position returns the rank of a sentinel, or rank 0 and the integer of a sequence number
+func position(value string) (int, *big.Int, error) {
+	if rank, ok := sentinelRanks[value]; ok {
+		return rank, nil, nil
+	}
+	n, err := Parse(value)
+	return 0, n, err
+}
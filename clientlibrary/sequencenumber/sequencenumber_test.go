@@ -0,0 +1,101 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package sequencenumber
+
+import (
+	"errors"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompare(t *testing.T) {
+	for _, test := range []struct {
+		a, b string
+		cmp  int
+	}{
+		// sequence numbers of different lengths are compared as integers
+		{"9", "10", -1},
+		{"49590338271490256608559692538361571095921575989136588898", "49590338271490256608559692538361571095921575989136588899", -1},
+		{"49590338271490256608559692540925702759324208523137515618", "4959033827149025660855969253836157109592157598913658889", 1},
+		{"0049", "49", 0},
+		{TrimHorizon, "1", -1},
+		{Latest, "1", -1},
+		{AtTimestamp, TrimHorizon, -1},
+		{TrimHorizon, Latest, -1},
+		{ShardEnd, "49590338271490256608559692540925702759324208523137515618", 1},
+		{ShardEnd, ShardEnd, 0},
+	} {
+		cmp, err := Compare(test.a, test.b)
+		assert.Nil(t, err)
+		assert.Equal(t, test.cmp, cmp, "%s <=> %s", test.a, test.b)
+		cmp, err = Compare(test.b, test.a)
+		assert.Nil(t, err)
+		assert.Equal(t, -test.cmp, cmp, "%s <=> %s", test.b, test.a)
+	}
+
+	for _, invalid := range []string{"", "-1", "+1", "1e3", "0x10", "latest"} {
+		_, err := Compare("1", invalid)
+		assert.True(t, errors.Is(err, ErrInvalidSequenceNumber), invalid)
+		assert.False(t, IsValid(invalid), invalid)
+	}
+}
+
+func TestParse(t *testing.T) {
+	// sequence numbers exceed 64 bits
+	n, err := Parse("49590338271490256608559692540925702759324208523137515618")
+	assert.Nil(t, err)
+	assert.Equal(t, "49590338271490256608559692540925702759324208523137515618", n.String())
+	assert.Greater(t, n.BitLen(), 64)
+
+	_, err = Parse(ShardEnd)
+	assert.True(t, errors.Is(err, ErrInvalidSequenceNumber))
+	assert.True(t, IsSentinel(ShardEnd))
+	assert.False(t, IsSentinel("1"))
+}
+
+func TestMinMax(t *testing.T) {
+	values := []string{"100", "99", Latest, "1000", ShardEnd, TrimHorizon}
+	max, err := Max(values...)
+	assert.Nil(t, err)
+	assert.Equal(t, ShardEnd, max)
+	min, err := Min(values...)
+	assert.Nil(t, err)
+	assert.Equal(t, TrimHorizon, min)
+
+	max, err = Max("99", "100")
+	assert.Nil(t, err)
+	assert.Equal(t, "100", max)
+
+	_, err = Max()
+	assert.NotNil(t, err)
+	_, err = Min("abc")
+	assert.NotNil(t, err)
+	_, err = Max("1", "abc")
+	assert.NotNil(t, err)
+
+	// sequence numbers are sorted by Compare
+	sort.Slice(values, func(i, j int) bool {
+		cmp, _ := Compare(values[i], values[j])
+		return cmp < 0
+	})
+	assert.Equal(t, []string{TrimHorizon, Latest, "99", "100", "1000", ShardEnd}, values)
+}
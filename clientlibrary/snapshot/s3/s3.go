@@ -0,0 +1,119 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package s3
+// The snapshots of the lease table are stored as objects of an S3 bucket.
+package s3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/snapshot"
+)
+
+type (
+	// S3API is the subset of the S3 client used by the Store
+	S3API interface {
+		PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+		GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	}
+
+	// Store keeps the snapshots as objects of an S3 bucket
+	Store struct {
+		bucket string
+		cfg    aws.Config
+		svc    S3API
+	}
+)
+
+var _ snapshot.Store = (*Store)(nil)
+
+// NewStore returns a Store writing the snapshots to the bucket in the region of the config
+func NewStore(cfg aws.Config, bucket string) *Store {
+	return &Store{
+		bucket: bucket,
+		cfg:    cfg,
+		svc:    s3.NewFromConfig(cfg),
+	}
+}
+
+// WithS3 sets the S3 client of the store, e.g. a mock for testing
+func (s *Store) WithS3(svc S3API) *Store {
+	s.svc = svc
+	return s
+}
+
+// WithEndpoint sets the endpoint of S3, e.g. of a local S3 compatible service. The bucket is addressed by the path
+// of the requests sent to the endpoint.
+func (s *Store) WithEndpoint(endpoint string) *Store {
+	s.svc = s3.NewFromConfig(s.cfg, func(o *s3.Options) {
+		o.EndpointResolver = s3.EndpointResolverFromURL(endpoint)
+		o.UsePathStyle = true
+	})
+	return s
+}
+
+// Put writes the snapshot to the object of the key
+func (s *Store) Put(ctx context.Context, key string, data []byte) error {
+	_, err := s.svc.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/octet-stream"),
+	})
+	return err
+}
+
+// Get reads the snapshot of the object of the key, or returns snapshot.ErrObjectNotFound
+func (s *Store) Get(ctx context.Context, key string) ([]byte, error) {
+	out, err := s.svc.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, fmt.Errorf("%w: %s", snapshot.ErrObjectNotFound, key)
+		}
+		return nil, err
+	}
+	defer out.Body.Close()
+
+	return io.ReadAll(out.Body)
+}
+
+// isNotFound returns whether the error is a missing object. The responses of some S3 compatible services have no
+// body, so that only their status tells the object is missing.
+func isNotFound(err error) bool {
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return true
+	}
+	var respErr *awshttp.ResponseError
+	return errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusNotFound
+}
@@ -0,0 +1,311 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package snapshot
+// The leases and checkpoints of a lease table are exported to snapshots, e.g. in S3, and restored into a fresh lease
+// table, for disaster recovery, to rename an application or to migrate the position of the consumers to another
+// region. The leases are restored without owner, so that the workers of the restored table acquire them.
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+	"github.com/vmware/vmware-go-kcl-v2/logger"
+)
+
+// Version is the version of the format of the snapshots written by Export
+const Version = 1
+
+var (
+	// ErrUnsupported is returned for checkpointers which do not implement checkpoint.LeaseAdmin
+	ErrUnsupported = errors.New("the checkpointer does not support listing the leases")
+
+	// ErrLeaseTableNotEmpty is returned when a snapshot is restored into a lease table holding leases already
+	ErrLeaseTableNotEmpty = errors.New("the lease table is not empty")
+
+	// ErrObjectNotFound is returned by a Store for a key without object
+	ErrObjectNotFound = errors.New("snapshot not found")
+)
+
+type (
+	// Snapshot holds the leases and checkpoints of a lease table at the time it was taken
+	Snapshot struct {
+		Version   int       `json:"version"`
+		CreatedAt time.Time `json:"createdAt"`
+		Leases    []Lease   `json:"leases"`
+	}
+
+	// Lease is the checkpoint of a shard, the owner is kept for inspection but is not restored. The lineage of the
+	// shards is not part of the snapshot, it is listed again by the workers of the restored lease table.
+	Lease struct {
+		ShardID            string `json:"shardId"`
+		Owner              string `json:"owner,omitempty"`
+		Checkpoint         string `json:"checkpoint,omitempty"`
+		SubSequenceNumber  *int64 `json:"subSequenceNumber,omitempty"`
+		PendingCheckpoint  string `json:"pendingCheckpoint,omitempty"`
+		CheckpointMetadata []byte `json:"checkpointMetadata,omitempty"`
+	}
+
+	// Store keeps the encoded snapshots by key, e.g. the objects of an S3 bucket
+	Store interface {
+		// Put writes the snapshot under the key, replacing the snapshot written before
+		Put(ctx context.Context, key string, data []byte) error
+
+		// Get reads the snapshot under the key, or returns ErrObjectNotFound
+		Get(ctx context.Context, key string) ([]byte, error)
+	}
+
+	// Codec encodes the snapshots, e.g. as JSON or Parquet
+	Codec interface {
+		Marshal(snapshot *Snapshot) ([]byte, error)
+		Unmarshal(data []byte, snapshot *Snapshot) error
+
+		// Extension is appended to the keys of the snapshots, e.g. ".json"
+		Extension() string
+	}
+
+	// JSONCodec encodes the snapshots as JSON documents
+	JSONCodec struct{}
+
+	// MemoryStore keeps the snapshots in memory, e.g. for tests
+	MemoryStore struct {
+		mux     sync.Mutex
+		objects map[string][]byte
+	}
+)
+
+func (JSONCodec) Marshal(snapshot *Snapshot) ([]byte, error) {
+	return json.Marshal(snapshot)
+}
+
+func (JSONCodec) Unmarshal(data []byte, snapshot *Snapshot) error {
+	return json.Unmarshal(data, snapshot)
+}
+
+func (JSONCodec) Extension() string {
+	return ".json"
+}
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{objects: make(map[string][]byte)}
+}
+
+func (s *MemoryStore) Put(_ context.Context, key string, data []byte) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.objects[key] = append([]byte(nil), data...)
+	return nil
+}
+
+func (s *MemoryStore) Get(_ context.Context, key string) ([]byte, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	data, ok := s.objects[key]
+	if !ok {
+		return nil, ErrObjectNotFound
+	}
+	return append([]byte(nil), data...), nil
+}
+
+// Take returns a snapshot of the leases and checkpoints of the lease table of an initialised checkpointer
+func Take(checkpointer chk.Checkpointer) (*Snapshot, error) {
+	leaseAdmin, ok := checkpointer.(chk.LeaseAdmin)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	shards, err := leaseAdmin.ListLeases()
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &Snapshot{Version: Version, CreatedAt: time.Now().UTC(), Leases: make([]Lease, len(shards))}
+	for i, shard := range shards {
+		snapshot.Leases[i] = Lease{
+			ShardID:            shard.ID,
+			Owner:              shard.GetLeaseOwner(),
+			Checkpoint:         shard.GetCheckpoint(),
+			SubSequenceNumber:  shard.GetSubSequenceNumber(),
+			PendingCheckpoint:  shard.GetPendingCheckpoint(),
+			CheckpointMetadata: shard.GetCheckpointMetadata(),
+		}
+	}
+	return snapshot, nil
+}
+
+// Export takes a snapshot of the lease table and writes it to the store under the key
+func Export(ctx context.Context, checkpointer chk.Checkpointer, store Store, key string, codec Codec) (*Snapshot, error) {
+	snapshot, err := Take(checkpointer)
+	if err != nil {
+		return nil, err
+	}
+	data, err := codec.Marshal(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	if err := store.Put(ctx, key, data); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// Load reads the snapshot under the key from the store
+func Load(ctx context.Context, store Store, key string, codec Codec) (*Snapshot, error) {
+	data, err := store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &Snapshot{}
+	if err := codec.Unmarshal(data, snapshot); err != nil {
+		return nil, err
+	}
+	if snapshot.Version != Version {
+		return nil, fmt.Errorf("unsupported snapshot version %d", snapshot.Version)
+	}
+	return snapshot, nil
+}
+
+// Restore writes the checkpoints of the snapshot into the empty lease table of an initialised checkpointer. The leases
+// are restored without owner and are acquired by the workers of the lease table as new leases. It returns
+// ErrLeaseTableNotEmpty if the lease table holds leases already, so that the positions of running consumers are
+// not overwritten.
+func Restore(checkpointer chk.Checkpointer, snapshot *Snapshot) error {
+	leaseAdmin, ok := checkpointer.(chk.LeaseAdmin)
+	if !ok {
+		return ErrUnsupported
+	}
+	leases, err := leaseAdmin.ListLeases()
+	if err != nil {
+		return err
+	}
+	if len(leases) > 0 {
+		return ErrLeaseTableNotEmpty
+	}
+
+	for _, lease := range snapshot.Leases {
+		shard := &par.ShardStatus{ID: lease.ShardID, Mux: &sync.RWMutex{}}
+		shard.SetCheckpoint(lease.Checkpoint)
+		shard.SetSubSequenceNumber(lease.SubSequenceNumber)
+		shard.SetPendingCheckpoint(lease.PendingCheckpoint)
+		shard.SetCheckpointMetadata(lease.CheckpointMetadata)
+		if err := checkpointer.CheckpointSequence(shard); err != nil {
+			return fmt.Errorf("failed to restore the checkpoint of shard %s: %w", lease.ShardID, err)
+		}
+		// the checkpoint is written with an empty lease owner, which is removed so that the lease is free
+		if err := leaseAdmin.ReleaseLease(lease.ShardID); err != nil {
+			return fmt.Errorf("failed to release the lease of shard %s: %w", lease.ShardID, err)
+		}
+	}
+	return nil
+}
+
+// Exporter exports the lease table periodically. Each snapshot is written under a key made of the prefix and the time
+// it was taken, e.g. prefix/2023-01-02T15:04:05Z.json, and under the LatestKey of the prefix.
+type Exporter struct {
+	checkpointer chk.Checkpointer
+	store        Store
+	prefix       string
+	interval     time.Duration
+	codec        Codec
+	log          logger.Logger
+}
+
+// NewExporter returns an Exporter writing the snapshots of the lease table of the checkpointer to the store every
+// interval, as JSON unless another codec is set
+func NewExporter(checkpointer chk.Checkpointer, store Store, prefix string, interval time.Duration) *Exporter {
+	return &Exporter{
+		checkpointer: checkpointer,
+		store:        store,
+		prefix:       prefix,
+		interval:     interval,
+		codec:        JSONCodec{},
+		log:          logger.GetDefaultLogger(),
+	}
+}
+
+// WithCodec sets the encoding of the snapshots
+func (e *Exporter) WithCodec(codec Codec) *Exporter {
+	e.codec = codec
+	return e
+}
+
+// WithLogger sets the logger of the failed exports
+func (e *Exporter) WithLogger(log logger.Logger) *Exporter {
+	e.log = log
+	return e
+}
+
+// LatestKey returns the key of the latest snapshot written by the exporter
+func (e *Exporter) LatestKey() string {
+	return LatestKey(e.prefix, e.codec)
+}
+
+// LatestKey returns the key of the latest snapshot written by an exporter with the prefix and the codec
+func LatestKey(prefix string, codec Codec) string {
+	return prefix + "/latest" + codec.Extension()
+}
+
+// Export writes a snapshot of the lease table to the store once
+func (e *Exporter) Export(ctx context.Context) (*Snapshot, error) {
+	snapshot, err := Take(e.checkpointer)
+	if err != nil {
+		return nil, err
+	}
+	data, err := e.codec.Marshal(snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	key := e.prefix + "/" + snapshot.CreatedAt.Format(time.RFC3339) + e.codec.Extension()
+	if err := e.store.Put(ctx, key, data); err != nil {
+		return nil, err
+	}
+	if err := e.store.Put(ctx, e.LatestKey(), data); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// Run exports the lease table every interval until the context is canceled. A failed export is logged and retried
+// at the next interval.
+func (e *Exporter) Run(ctx context.Context) error {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		if snapshot, err := e.Export(ctx); err != nil {
+			e.log.Errorf("Failed to export the lease table: %+v", err)
+		} else {
+			e.log.Debugf("Exported %d leases to %s", len(snapshot.Leases), e.LatestKey())
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,126 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+
+	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
+	cfg "github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+func newTestCheckpoint(t *testing.T, application string) *chk.MemoryCheckpoint {
+	table, err := chk.NewMemoryLeaseTable("")
+	assert.Nil(t, err)
+	kclConfig := cfg.NewKinesisClientLibConfig(application, "stream", "us-west-2", "worker")
+	checkpoint := chk.NewMemoryCheckpoint(kclConfig).WithLeaseTable(table)
+	assert.Nil(t, checkpoint.Init())
+	return checkpoint
+}
+
+func checkpointShard(t *testing.T, checkpoint chk.Checkpointer, id, parent, owner, sequenceNumber string) {
+	shard := &par.ShardStatus{ID: id, ParentShardId: parent, Mux: &sync.RWMutex{}}
+	shard.SetLeaseOwner(owner)
+	shard.SetCheckpoint(sequenceNumber)
+	shard.SetSubSequenceNumber(aws.Int64(3))
+	shard.SetCheckpointMetadata([]byte("metadata"))
+	assert.Nil(t, checkpoint.CheckpointSequence(shard))
+}
+
+func TestExportAndRestore(t *testing.T) {
+	source := newTestCheckpoint(t, "orders")
+	checkpointShard(t, source, "shard-0", "", "worker-1", "100")
+	checkpointShard(t, source, "shard-1", "shard-0", "worker-2", "200")
+
+	store := NewMemoryStore()
+	exported, err := Export(context.TODO(), source, store, "orders.json", JSONCodec{})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(exported.Leases))
+
+	loaded, err := Load(context.TODO(), store, "orders.json", JSONCodec{})
+	assert.Nil(t, err)
+	assert.Equal(t, Version, loaded.Version)
+	assert.Equal(t, "worker-2", loaded.Leases[1].Owner)
+
+	// the checkpoints are restored into the lease table of the renamed application without owner
+	target := newTestCheckpoint(t, "orders-v2")
+	assert.Nil(t, Restore(target, loaded))
+
+	shard := &par.ShardStatus{ID: "shard-1", Mux: &sync.RWMutex{}}
+	assert.Nil(t, target.FetchCheckpoint(shard))
+	assert.Equal(t, "200", shard.GetCheckpoint())
+	assert.Equal(t, int64(3), *shard.GetSubSequenceNumber())
+	assert.Equal(t, []byte("metadata"), shard.GetCheckpointMetadata())
+	assert.Equal(t, "", shard.GetLeaseOwner())
+
+	leases, err := target.ListLeases()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(leases))
+	assert.Nil(t, target.GetLease(&par.ShardStatus{ID: "shard-0", Mux: &sync.RWMutex{}}, "worker"))
+
+	// a lease table holding leases is not overwritten
+	assert.True(t, errors.Is(Restore(target, loaded), ErrLeaseTableNotEmpty))
+}
+
+func TestLoadErrors(t *testing.T) {
+	store := NewMemoryStore()
+	_, err := Load(context.TODO(), store, "missing.json", JSONCodec{})
+	assert.True(t, errors.Is(err, ErrObjectNotFound))
+
+	assert.Nil(t, store.Put(context.TODO(), "future.json", []byte(`{"version": 2}`)))
+	_, err = Load(context.TODO(), store, "future.json", JSONCodec{})
+	assert.NotNil(t, err)
+}
+
+func TestExporter(t *testing.T) {
+	checkpoint := newTestCheckpoint(t, "orders")
+	checkpointShard(t, checkpoint, "shard-0", "", "worker-1", "100")
+
+	store := NewMemoryStore()
+	exporter := NewExporter(checkpoint, store, "orders", 10*time.Millisecond)
+	assert.Equal(t, "orders/latest.json", exporter.LatestKey())
+
+	exported, err := exporter.Export(context.TODO())
+	assert.Nil(t, err)
+	_, err = Load(context.TODO(), store, "orders/"+exported.CreatedAt.Format(time.RFC3339)+".json", JSONCodec{})
+	assert.Nil(t, err)
+
+	// the latest snapshot follows the checkpoints
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- exporter.Run(ctx) }()
+
+	checkpointShard(t, checkpoint, "shard-0", "", "worker-1", "150")
+	assert.Eventually(t, func() bool {
+		latest, err := Load(context.TODO(), store, exporter.LatestKey(), JSONCodec{})
+		return err == nil && latest.Leases[0].Checkpoint == "150"
+	}, time.Second, 5*time.Millisecond)
+
+	cancel()
+	assert.True(t, errors.Is(<-done, context.Canceled))
+}
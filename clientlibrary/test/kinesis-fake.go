@@ -0,0 +1,601 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package test
+
+import (
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+)
+
+const (
+	// DefaultIteratorTTL is how long the shard iterators of a FakeKinesis are valid, as those of Kinesis
+	DefaultIteratorTTL = 5 * time.Minute
+
+	// fakeAccountARN is the prefix of the ARNs of the streams of a FakeKinesis
+	fakeAccountARN = "arn:aws:kinesis:us-west-2:123456789012:stream/"
+
+	// maxGetRecordsLimit is the max number of records returned by a GetRecords call
+	maxGetRecordsLimit = 10000
+)
+
+// maxHashKey is the largest hashed partition key, 2^128 - 1
+var maxHashKey = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 128), big.NewInt(1))
+
+type (
+	// FakeKinesis is an in-memory stream implementing worker.KinesisAPI for deterministic tests of record processors
+	// and of the worker. Records are put by PutRecord and routed to the open shards by the MD5 hash of their
+	// partition key. The shards can be split and merged while they are consumed, and faults can be scripted to
+	// throttle calls, expire shard iterators or fail the calls of single shards, e.g.
+	//
+	//	kc := test.NewFakeKinesis()
+	//	kc.CreateStream("stream", 2)
+	//	kc.PutRecord("stream", "pk", []byte("data"))
+	//	kc.InjectFault(test.Fault{Operation: "GetRecords", Err: test.ThrottlingError(), Count: 3})
+	//	w := worker.NewWorker(factory, kclConfig).WithKinesis(kc)
+	//
+	// Enhanced fan-out is not supported, SubscribeToShard fails.
+	FakeKinesis struct {
+		mux         sync.Mutex
+		now         func() time.Time
+		iteratorTTL time.Duration
+		streams     map[string]*fakeStream
+		iterators   map[string]*fakeIterator
+		faults      []*Fault
+		consumers   map[string]*types.Consumer
+		nextID      int
+	}
+
+	// Fault is an error returned by the calls of a FakeKinesis matching the operation, stream and shard.
+	Fault struct {
+		// Operation is the name of the API call, e.g. "GetRecords" or "ListShards"
+		Operation string
+
+		// StreamName restricts the fault to the calls of a stream, all streams are matched if it is empty.
+		StreamName string
+
+		// ShardID restricts the fault to the GetShardIterator and GetRecords calls of a shard, all shards are
+		// matched if it is empty.
+		ShardID string
+
+		// Err is returned by the matching calls.
+		Err error
+
+		// Count is the number of calls failed by the fault, it fails all calls if it is 0.
+		Count int
+	}
+
+	fakeStream struct {
+		name      string
+		createdAt time.Time
+		shards    []*fakeShard
+		// epoch is increased by ExpireIterators, the iterators of earlier epochs are expired
+		epoch       int
+		nextShardID int
+		sequence    int64
+	}
+
+	fakeShard struct {
+		shard   types.Shard
+		records []types.Record
+		// closed is true once the shard has been split or merged
+		closed   bool
+		children []types.ChildShard
+	}
+
+	fakeIterator struct {
+		stream   string
+		shardID  string
+		position int
+		issuedAt time.Time
+		epoch    int
+	}
+)
+
+// NewFakeKinesis creates a FakeKinesis without streams.
+func NewFakeKinesis() *FakeKinesis {
+	return &FakeKinesis{
+		now:         time.Now,
+		iteratorTTL: DefaultIteratorTTL,
+		streams:     make(map[string]*fakeStream),
+		iterators:   make(map[string]*fakeIterator),
+		consumers:   make(map[string]*types.Consumer),
+	}
+}
+
+// WithClock sets the clock of the arrival timestamps and of the expiry of the shard iterators.
+func (f *FakeKinesis) WithClock(now func() time.Time) *FakeKinesis {
+	f.now = now
+	return f
+}
+
+// WithIteratorTTL sets how long the shard iterators are valid.
+func (f *FakeKinesis) WithIteratorTTL(ttl time.Duration) *FakeKinesis {
+	f.iteratorTTL = ttl
+	return f
+}
+
+// ThrottlingError returns the error of Kinesis for throttled calls.
+func ThrottlingError() error {
+	return &types.ProvisionedThroughputExceededException{Message: aws.String("Rate exceeded for shard")}
+}
+
+// StreamARN returns the ARN of a stream of a FakeKinesis.
+func StreamARN(streamName string) string {
+	return fakeAccountARN + streamName
+}
+
+// CreateStream creates a stream whose shards divide the range of the hashed partition keys evenly.
+func (f *FakeKinesis) CreateStream(streamName string, shards int) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	s := &fakeStream{name: streamName, createdAt: f.now()}
+	size := new(big.Int).Div(new(big.Int).Add(maxHashKey, big.NewInt(1)), big.NewInt(int64(shards)))
+	for i := 0; i < shards; i++ {
+		start := new(big.Int).Mul(size, big.NewInt(int64(i)))
+		end := new(big.Int).Sub(new(big.Int).Add(start, size), big.NewInt(1))
+		if i == shards-1 {
+			end = maxHashKey
+		}
+		s.addShard(start, end, nil, nil)
+	}
+	f.streams[streamName] = s
+}
+
+// PutRecord appends a record to the open shard of the stream whose hash key range contains the MD5 hash of the
+// partition key. It returns the shard and the sequence number of the record.
+func (f *FakeKinesis) PutRecord(streamName, partitionKey string, data []byte) (string, string, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	s, err := f.stream(streamName, "")
+	if err != nil {
+		return "", "", err
+	}
+	sum := md5.Sum([]byte(partitionKey))
+	hashKey := new(big.Int).SetBytes(sum[:])
+	for _, shard := range s.shards {
+		if shard.closed || !shard.contains(hashKey) {
+			continue
+		}
+		s.sequence++
+		sequenceNumber := fmt.Sprintf("%056d", s.sequence)
+		shard.records = append(shard.records, types.Record{
+			Data:                        data,
+			PartitionKey:                aws.String(partitionKey),
+			SequenceNumber:              aws.String(sequenceNumber),
+			ApproximateArrivalTimestamp: aws.Time(f.now()),
+		})
+		return aws.ToString(shard.shard.ShardId), sequenceNumber, nil
+	}
+	return "", "", fmt.Errorf("no open shard of stream %s for partition key %s", streamName, partitionKey)
+}
+
+// SplitShard closes an open shard and creates two child shards, the second child starts at newStartingHashKey.
+func (f *FakeKinesis) SplitShard(streamName, shardID, newStartingHashKey string) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	s, err := f.stream(streamName, "")
+	if err != nil {
+		return err
+	}
+	parent, err := s.openShard(shardID)
+	if err != nil {
+		return err
+	}
+	start, end := parent.hashKeyRange()
+	split, ok := new(big.Int).SetString(newStartingHashKey, 10)
+	if !ok || split.Cmp(start) <= 0 || split.Cmp(end) > 0 {
+		return &types.InvalidArgumentException{Message: aws.String("invalid NewStartingHashKey " + newStartingHashKey)}
+	}
+
+	s.closeShard(parent,
+		s.addShard(start, new(big.Int).Sub(split, big.NewInt(1)), parent.shard.ShardId, nil),
+		s.addShard(split, end, parent.shard.ShardId, nil))
+	return nil
+}
+
+// MergeShards closes two adjacent open shards and creates their child shard.
+func (f *FakeKinesis) MergeShards(streamName, shardID, adjacentShardID string) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	s, err := f.stream(streamName, "")
+	if err != nil {
+		return err
+	}
+	parent, err := s.openShard(shardID)
+	if err != nil {
+		return err
+	}
+	adjacent, err := s.openShard(adjacentShardID)
+	if err != nil {
+		return err
+	}
+	start, end := parent.hashKeyRange()
+	adjacentStart, adjacentEnd := adjacent.hashKeyRange()
+	if new(big.Int).Add(end, big.NewInt(1)).Cmp(adjacentStart) == 0 {
+		end = adjacentEnd
+	} else if new(big.Int).Add(adjacentEnd, big.NewInt(1)).Cmp(start) == 0 {
+		start = adjacentStart
+	} else {
+		return &types.InvalidArgumentException{Message: aws.String("shards " + shardID + " and " + adjacentShardID + " are not adjacent")}
+	}
+
+	child := s.addShard(start, end, parent.shard.ShardId, adjacent.shard.ShardId)
+	s.closeShard(parent, child)
+	s.closeShard(adjacent, child)
+	return nil
+}
+
+// ExpireIterators expires all shard iterators of the stream returned so far, their GetRecords calls fail with an
+// ExpiredIteratorException.
+func (f *FakeKinesis) ExpireIterators(streamName string) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	s, err := f.stream(streamName, "")
+	if err != nil {
+		return err
+	}
+	s.epoch++
+	return nil
+}
+
+// InjectFault adds a fault, the faults are matched in the order they have been injected.
+func (f *FakeKinesis) InjectFault(fault Fault) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.faults = append(f.faults, &fault)
+}
+
+func (f *FakeKinesis) GetRecords(_ context.Context, params *kinesis.GetRecordsInput, _ ...func(*kinesis.Options)) (*kinesis.GetRecordsOutput, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	it, ok := f.iterators[aws.ToString(params.ShardIterator)]
+	if !ok {
+		return nil, &types.InvalidArgumentException{Message: aws.String("invalid shard iterator")}
+	}
+	if err := f.fault("GetRecords", it.stream, it.shardID); err != nil {
+		return nil, err
+	}
+	s := f.streams[it.stream]
+	if it.epoch < s.epoch || f.now().Sub(it.issuedAt) > f.iteratorTTL {
+		return nil, &types.ExpiredIteratorException{Message: aws.String("Iterator expired")}
+	}
+	shard, _ := s.shard(it.shardID)
+
+	limit := maxGetRecordsLimit
+	if params.Limit != nil && int(*params.Limit) < limit {
+		limit = int(*params.Limit)
+	}
+	end := it.position + limit
+	if end > len(shard.records) {
+		end = len(shard.records)
+	}
+	out := &kinesis.GetRecordsOutput{
+		Records:            append([]types.Record{}, shard.records[it.position:end]...),
+		MillisBehindLatest: aws.Int64(0),
+	}
+	if end < len(shard.records) {
+		arrival := aws.ToTime(shard.records[end].ApproximateArrivalTimestamp)
+		out.MillisBehindLatest = aws.Int64(f.now().Sub(arrival).Milliseconds())
+	}
+
+	// the iterator of a closed shard ends with its last record
+	if shard.closed && end == len(shard.records) {
+		out.ChildShards = shard.children
+		return out, nil
+	}
+	out.NextShardIterator = aws.String(f.newIterator(it.stream, it.shardID, end))
+	return out, nil
+}
+
+func (f *FakeKinesis) GetShardIterator(_ context.Context, params *kinesis.GetShardIteratorInput, _ ...func(*kinesis.Options)) (*kinesis.GetShardIteratorOutput, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	s, err := f.stream(aws.ToString(params.StreamName), aws.ToString(params.StreamARN))
+	if err != nil {
+		return nil, err
+	}
+	shardID := aws.ToString(params.ShardId)
+	if err := f.fault("GetShardIterator", s.name, shardID); err != nil {
+		return nil, err
+	}
+	shard, err := s.shard(shardID)
+	if err != nil {
+		return nil, err
+	}
+
+	var position int
+	switch params.ShardIteratorType {
+	case types.ShardIteratorTypeTrimHorizon:
+		position = 0
+	case types.ShardIteratorTypeLatest:
+		position = len(shard.records)
+	case types.ShardIteratorTypeAtSequenceNumber, types.ShardIteratorTypeAfterSequenceNumber:
+		sequenceNumber := aws.ToString(params.StartingSequenceNumber)
+		position = len(shard.records)
+		for i, r := range shard.records {
+			if aws.ToString(r.SequenceNumber) >= sequenceNumber {
+				position = i
+				if params.ShardIteratorType == types.ShardIteratorTypeAfterSequenceNumber && aws.ToString(r.SequenceNumber) == sequenceNumber {
+					position++
+				}
+				break
+			}
+		}
+	case types.ShardIteratorTypeAtTimestamp:
+		timestamp := aws.ToTime(params.Timestamp)
+		position = len(shard.records)
+		for i, r := range shard.records {
+			if !aws.ToTime(r.ApproximateArrivalTimestamp).Before(timestamp) {
+				position = i
+				break
+			}
+		}
+	default:
+		return nil, &types.InvalidArgumentException{Message: aws.String("invalid shard iterator type " + string(params.ShardIteratorType))}
+	}
+	return &kinesis.GetShardIteratorOutput{ShardIterator: aws.String(f.newIterator(s.name, shardID, position))}, nil
+}
+
+// ListShards lists the shards of the stream, MaxResults of them per page.
+func (f *FakeKinesis) ListShards(_ context.Context, params *kinesis.ListShardsInput, _ ...func(*kinesis.Options)) (*kinesis.ListShardsOutput, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	// the next token encodes the stream and the index of the next shard
+	streamName, start := aws.ToString(params.StreamName), 0
+	if params.NextToken != nil {
+		i := strings.LastIndex(*params.NextToken, "/")
+		n, err := strconv.Atoi((*params.NextToken)[i+1:])
+		if i < 0 || err != nil {
+			return nil, &types.InvalidArgumentException{Message: aws.String("invalid NextToken")}
+		}
+		streamName, start = (*params.NextToken)[:i], n
+	}
+	s, err := f.stream(streamName, aws.ToString(params.StreamARN))
+	if err != nil {
+		return nil, err
+	}
+	if err := f.fault("ListShards", s.name, ""); err != nil {
+		return nil, err
+	}
+
+	end := len(s.shards)
+	if params.MaxResults != nil && start+int(*params.MaxResults) < end {
+		end = start + int(*params.MaxResults)
+	}
+	out := &kinesis.ListShardsOutput{}
+	for _, shard := range s.shards[start:end] {
+		out.Shards = append(out.Shards, shard.shard)
+	}
+	if end < len(s.shards) {
+		out.NextToken = aws.String(s.name + "/" + strconv.Itoa(end))
+	}
+	return out, nil
+}
+
+// SubscribeToShard is not supported, the shards of a FakeKinesis are consumed by polling.
+func (f *FakeKinesis) SubscribeToShard(context.Context, *kinesis.SubscribeToShardInput, ...func(*kinesis.Options)) (*kinesis.SubscribeToShardOutput, error) {
+	return nil, errors.New("SubscribeToShard is not supported by FakeKinesis")
+}
+
+func (f *FakeKinesis) DescribeStreamSummary(_ context.Context, params *kinesis.DescribeStreamSummaryInput, _ ...func(*kinesis.Options)) (*kinesis.DescribeStreamSummaryOutput, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	s, err := f.stream(aws.ToString(params.StreamName), aws.ToString(params.StreamARN))
+	if err != nil {
+		return nil, err
+	}
+	if err := f.fault("DescribeStreamSummary", s.name, ""); err != nil {
+		return nil, err
+	}
+
+	open := 0
+	for _, shard := range s.shards {
+		if !shard.closed {
+			open++
+		}
+	}
+	return &kinesis.DescribeStreamSummaryOutput{
+		StreamDescriptionSummary: &types.StreamDescriptionSummary{
+			StreamName:              aws.String(s.name),
+			StreamARN:               aws.String(StreamARN(s.name)),
+			StreamStatus:            types.StreamStatusActive,
+			StreamCreationTimestamp: aws.Time(s.createdAt),
+			OpenShardCount:          aws.Int32(int32(open)),
+			RetentionPeriodHours:    aws.Int32(24),
+		},
+	}, nil
+}
+
+func (f *FakeKinesis) DescribeStreamConsumer(_ context.Context, params *kinesis.DescribeStreamConsumerInput, _ ...func(*kinesis.Options)) (*kinesis.DescribeStreamConsumerOutput, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	for arn, consumer := range f.consumers {
+		if arn == aws.ToString(params.ConsumerARN) ||
+			(aws.ToString(consumer.ConsumerName) == aws.ToString(params.ConsumerName) && strings.HasPrefix(arn, aws.ToString(params.StreamARN)+"/")) {
+			return &kinesis.DescribeStreamConsumerOutput{
+				ConsumerDescription: &types.ConsumerDescription{
+					ConsumerARN:               consumer.ConsumerARN,
+					ConsumerName:              consumer.ConsumerName,
+					ConsumerStatus:            consumer.ConsumerStatus,
+					ConsumerCreationTimestamp: consumer.ConsumerCreationTimestamp,
+					StreamARN:                 aws.String(arn[:strings.LastIndex(arn, "/consumer/")]),
+				},
+			}, nil
+		}
+	}
+	return nil, &types.ResourceNotFoundException{Message: aws.String("consumer not found")}
+}
+
+func (f *FakeKinesis) RegisterStreamConsumer(_ context.Context, params *kinesis.RegisterStreamConsumerInput, _ ...func(*kinesis.Options)) (*kinesis.RegisterStreamConsumerOutput, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	if _, err := f.stream("", aws.ToString(params.StreamARN)); err != nil {
+		return nil, err
+	}
+	arn := aws.ToString(params.StreamARN) + "/consumer/" + aws.ToString(params.ConsumerName)
+	if _, ok := f.consumers[arn]; ok {
+		return nil, &types.ResourceInUseException{Message: aws.String("consumer already exists")}
+	}
+	consumer := &types.Consumer{
+		ConsumerARN:               aws.String(arn),
+		ConsumerName:              params.ConsumerName,
+		ConsumerStatus:            types.ConsumerStatusActive,
+		ConsumerCreationTimestamp: aws.Time(f.now()),
+	}
+	f.consumers[arn] = consumer
+	return &kinesis.RegisterStreamConsumerOutput{Consumer: consumer}, nil
+}
+
+// stream returns the stream by name or ARN
+func (f *FakeKinesis) stream(streamName, streamARN string) (*fakeStream, error) {
+	if streamName == "" {
+		streamName = strings.TrimPrefix(streamARN, fakeAccountARN)
+	}
+	s, ok := f.streams[streamName]
+	if !ok {
+		return nil, &types.ResourceNotFoundException{Message: aws.String("Stream " + streamName + " not found")}
+	}
+	return s, nil
+}
+
+// fault returns the error of the first fault matching the call and counts the call against it
+func (f *FakeKinesis) fault(operation, streamName, shardID string) error {
+	for i, fault := range f.faults {
+		if fault.Operation != operation || (fault.StreamName != "" && fault.StreamName != streamName) ||
+			(fault.ShardID != "" && fault.ShardID != shardID) {
+			continue
+		}
+		if fault.Count > 0 {
+			fault.Count--
+			if fault.Count == 0 {
+				f.faults = append(f.faults[:i], f.faults[i+1:]...)
+			}
+		}
+		return fault.Err
+	}
+	return nil
+}
+
+// newIterator returns a new shard iterator at the position of the shard
+func (f *FakeKinesis) newIterator(streamName, shardID string, position int) string {
+	f.nextID++
+	id := fmt.Sprintf("%s/%s/%d/%d", streamName, shardID, position, f.nextID)
+	f.iterators[id] = &fakeIterator{
+		stream:   streamName,
+		shardID:  shardID,
+		position: position,
+		issuedAt: f.now(),
+		epoch:    f.streams[streamName].epoch,
+	}
+	return id
+}
+
+// addShard adds an open shard of the hash key range
+func (s *fakeStream) addShard(start, end *big.Int, parent, adjacentParent *string) *fakeShard {
+	shard := &fakeShard{shard: types.Shard{
+		ShardId:               aws.String(fmt.Sprintf("shardId-%012d", s.nextShardID)),
+		ParentShardId:         parent,
+		AdjacentParentShardId: adjacentParent,
+		HashKeyRange: &types.HashKeyRange{
+			StartingHashKey: aws.String(start.String()),
+			EndingHashKey:   aws.String(end.String()),
+		},
+		SequenceNumberRange: &types.SequenceNumberRange{
+			StartingSequenceNumber: aws.String(fmt.Sprintf("%056d", s.sequence+1)),
+		},
+	}}
+	s.nextShardID++
+	s.shards = append(s.shards, shard)
+	return shard
+}
+
+// closeShard closes the shard, the records of the shard are followed by those of its children
+func (s *fakeStream) closeShard(shard *fakeShard, children ...*fakeShard) {
+	shard.closed = true
+	shard.shard.SequenceNumberRange.EndingSequenceNumber = aws.String(fmt.Sprintf("%056d", s.sequence))
+	for _, child := range children {
+		parents := []string{aws.ToString(child.shard.ParentShardId)}
+		if child.shard.AdjacentParentShardId != nil {
+			parents = append(parents, aws.ToString(child.shard.AdjacentParentShardId))
+		}
+		shard.children = append(shard.children, types.ChildShard{
+			ShardId:      child.shard.ShardId,
+			ParentShards: parents,
+			HashKeyRange: child.shard.HashKeyRange,
+		})
+	}
+}
+
+// shard returns the shard by id
+func (s *fakeStream) shard(shardID string) (*fakeShard, error) {
+	for _, shard := range s.shards {
+		if aws.ToString(shard.shard.ShardId) == shardID {
+			return shard, nil
+		}
+	}
+	return nil, &types.ResourceNotFoundException{Message: aws.String("Shard " + shardID + " of stream " + s.name + " not found")}
+}
+
+// openShard returns the shard by id unless it has been closed
+func (s *fakeStream) openShard(shardID string) (*fakeShard, error) {
+	shard, err := s.shard(shardID)
+	if err != nil {
+		return nil, err
+	}
+	if shard.closed {
+		return nil, &types.ResourceInUseException{Message: aws.String("Shard " + shardID + " is closed")}
+	}
+	return shard, nil
+}
+
+// hashKeyRange returns the inclusive range of the hashed partition keys of the shard
+func (s *fakeShard) hashKeyRange() (*big.Int, *big.Int) {
+	start, _ := new(big.Int).SetString(aws.ToString(s.shard.HashKeyRange.StartingHashKey), 10)
+	end, _ := new(big.Int).SetString(aws.ToString(s.shard.HashKeyRange.EndingHashKey), 10)
+	return start, end
+}
+
+// contains reports whether the hashed partition key is in the range of the shard
+func (s *fakeShard) contains(hashKey *big.Int) bool {
+	start, end := s.hashKeyRange()
+	return hashKey.Cmp(start) >= 0 && hashKey.Cmp(end) <= 0
+}
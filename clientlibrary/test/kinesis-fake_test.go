@@ -0,0 +1,162 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFakeKinesisGetRecords(t *testing.T) {
+	now := time.Now()
+	kc := NewFakeKinesis().WithClock(func() time.Time { return now })
+	kc.CreateStream("stream", 1)
+	for _, pk := range []string{"a", "b", "c"} {
+		_, _, err := kc.PutRecord("stream", pk, []byte(pk))
+		assert.Nil(t, err)
+	}
+
+	ctx := context.TODO()
+	it, err := kc.GetShardIterator(ctx, &kinesis.GetShardIteratorInput{
+		StreamName:        aws.String("stream"),
+		ShardId:           aws.String("shardId-000000000000"),
+		ShardIteratorType: types.ShardIteratorTypeTrimHorizon,
+	})
+	assert.Nil(t, err)
+
+	now = now.Add(time.Second)
+	out, err := kc.GetRecords(ctx, &kinesis.GetRecordsInput{ShardIterator: it.ShardIterator, Limit: aws.Int32(2)})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(out.Records))
+	assert.Equal(t, "a", aws.ToString(out.Records[0].PartitionKey))
+	assert.Equal(t, int64(1000), aws.ToInt64(out.MillisBehindLatest))
+
+	out, err = kc.GetRecords(ctx, &kinesis.GetRecordsInput{ShardIterator: out.NextShardIterator})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(out.Records))
+	assert.Equal(t, int64(0), aws.ToInt64(out.MillisBehindLatest))
+	assert.NotNil(t, out.NextShardIterator)
+
+	after, err := kc.GetShardIterator(ctx, &kinesis.GetShardIteratorInput{
+		StreamName:             aws.String("stream"),
+		ShardId:                aws.String("shardId-000000000000"),
+		ShardIteratorType:      types.ShardIteratorTypeAfterSequenceNumber,
+		StartingSequenceNumber: out.Records[0].SequenceNumber,
+	})
+	assert.Nil(t, err)
+	out, err = kc.GetRecords(ctx, &kinesis.GetRecordsInput{ShardIterator: after.ShardIterator})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(out.Records))
+}
+
+func TestFakeKinesisResharding(t *testing.T) {
+	ctx := context.TODO()
+	kc := NewFakeKinesis()
+	kc.CreateStream("stream", 1)
+	_, _, err := kc.PutRecord("stream", "a", []byte("a"))
+	assert.Nil(t, err)
+
+	assert.Nil(t, kc.SplitShard("stream", "shardId-000000000000", "170141183460469231731687303715884105728"))
+	assert.NotNil(t, kc.SplitShard("stream", "shardId-000000000000", "1"))
+	assert.Nil(t, kc.MergeShards("stream", "shardId-000000000002", "shardId-000000000001"))
+
+	shards, err := kc.ListShards(ctx, &kinesis.ListShardsInput{StreamName: aws.String("stream"), MaxResults: aws.Int32(3)})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(shards.Shards))
+	assert.Equal(t, "170141183460469231731687303715884105727", aws.ToString(shards.Shards[1].HashKeyRange.EndingHashKey))
+	assert.NotNil(t, shards.Shards[0].SequenceNumberRange.EndingSequenceNumber)
+
+	shards, err = kc.ListShards(ctx, &kinesis.ListShardsInput{NextToken: shards.NextToken})
+	assert.Nil(t, err)
+	assert.Nil(t, shards.NextToken)
+	assert.Equal(t, 1, len(shards.Shards))
+	merged := shards.Shards[0]
+	assert.Equal(t, "shardId-000000000002", aws.ToString(merged.ParentShardId))
+	assert.Equal(t, "shardId-000000000001", aws.ToString(merged.AdjacentParentShardId))
+	assert.Equal(t, "0", aws.ToString(merged.HashKeyRange.StartingHashKey))
+
+	// the closed parent ends with its records and lists its children
+	it, err := kc.GetShardIterator(ctx, &kinesis.GetShardIteratorInput{
+		StreamARN:         aws.String(StreamARN("stream")),
+		ShardId:           aws.String("shardId-000000000000"),
+		ShardIteratorType: types.ShardIteratorTypeTrimHorizon,
+	})
+	assert.Nil(t, err)
+	out, err := kc.GetRecords(ctx, &kinesis.GetRecordsInput{ShardIterator: it.ShardIterator})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(out.Records))
+	assert.Nil(t, out.NextShardIterator)
+	assert.Equal(t, 2, len(out.ChildShards))
+
+	shard, _, err := kc.PutRecord("stream", "a", []byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, "shardId-000000000003", shard)
+
+	summary, err := kc.DescribeStreamSummary(ctx, &kinesis.DescribeStreamSummaryInput{StreamName: aws.String("stream")})
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1), aws.ToInt32(summary.StreamDescriptionSummary.OpenShardCount))
+}
+
+func TestFakeKinesisFaults(t *testing.T) {
+	ctx := context.TODO()
+	kc := NewFakeKinesis()
+	kc.CreateStream("stream", 2)
+	kc.InjectFault(Fault{Operation: "GetRecords", ShardID: "shardId-000000000001", Err: ThrottlingError(), Count: 2})
+
+	iterator := func(shardID string) *string {
+		it, err := kc.GetShardIterator(ctx, &kinesis.GetShardIteratorInput{
+			StreamName:        aws.String("stream"),
+			ShardId:           aws.String(shardID),
+			ShardIteratorType: types.ShardIteratorTypeLatest,
+		})
+		assert.Nil(t, err)
+		return it.ShardIterator
+	}
+
+	// only the calls of the faulty shard fail, until the fault has been used up
+	_, err := kc.GetRecords(ctx, &kinesis.GetRecordsInput{ShardIterator: iterator("shardId-000000000000")})
+	assert.Nil(t, err)
+	var throttled *types.ProvisionedThroughputExceededException
+	for i := 0; i < 2; i++ {
+		_, err = kc.GetRecords(ctx, &kinesis.GetRecordsInput{ShardIterator: iterator("shardId-000000000001")})
+		assert.ErrorAs(t, err, &throttled)
+	}
+	_, err = kc.GetRecords(ctx, &kinesis.GetRecordsInput{ShardIterator: iterator("shardId-000000000001")})
+	assert.Nil(t, err)
+
+	// expired iterators
+	it := iterator("shardId-000000000000")
+	assert.Nil(t, kc.ExpireIterators("stream"))
+	var expired *types.ExpiredIteratorException
+	_, err = kc.GetRecords(ctx, &kinesis.GetRecordsInput{ShardIterator: it})
+	assert.ErrorAs(t, err, &expired)
+	_, err = kc.GetRecords(ctx, &kinesis.GetRecordsInput{ShardIterator: iterator("shardId-000000000000")})
+	assert.Nil(t, err)
+
+	var notFound *types.ResourceNotFoundException
+	_, err = kc.ListShards(ctx, &kinesis.ListShardsInput{StreamName: aws.String("unknown")})
+	assert.ErrorAs(t, err, &notFound)
+}
@@ -0,0 +1,81 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package test provides mocks of the clients used by the worker for unit tests of applications and custom
+// integrations.
+package test
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/stretchr/testify/mock"
+)
+
+// MockKinesisAPI is a testify mock of the Kinesis API used by the worker, it implements worker.KinesisAPI. The
+// expectations are set on the context and the input of the calls, e.g.
+//
+//	kc := &test.MockKinesisAPI{}
+//	kc.On("ListShards", mock.Anything, mock.Anything).Return(&kinesis.ListShardsOutput{}, nil)
+//	w := worker.NewWorker(factory, kclConfig).WithKinesis(kc)
+type MockKinesisAPI struct {
+	mock.Mock
+}
+
+func (m *MockKinesisAPI) GetRecords(ctx context.Context, params *kinesis.GetRecordsInput, _ ...func(*kinesis.Options)) (*kinesis.GetRecordsOutput, error) {
+	ret := m.Called(ctx, params)
+	out, _ := ret.Get(0).(*kinesis.GetRecordsOutput)
+	return out, ret.Error(1)
+}
+
+func (m *MockKinesisAPI) GetShardIterator(ctx context.Context, params *kinesis.GetShardIteratorInput, _ ...func(*kinesis.Options)) (*kinesis.GetShardIteratorOutput, error) {
+	ret := m.Called(ctx, params)
+	out, _ := ret.Get(0).(*kinesis.GetShardIteratorOutput)
+	return out, ret.Error(1)
+}
+
+func (m *MockKinesisAPI) ListShards(ctx context.Context, params *kinesis.ListShardsInput, _ ...func(*kinesis.Options)) (*kinesis.ListShardsOutput, error) {
+	ret := m.Called(ctx, params)
+	out, _ := ret.Get(0).(*kinesis.ListShardsOutput)
+	return out, ret.Error(1)
+}
+
+func (m *MockKinesisAPI) SubscribeToShard(ctx context.Context, params *kinesis.SubscribeToShardInput, _ ...func(*kinesis.Options)) (*kinesis.SubscribeToShardOutput, error) {
+	ret := m.Called(ctx, params)
+	out, _ := ret.Get(0).(*kinesis.SubscribeToShardOutput)
+	return out, ret.Error(1)
+}
+
+func (m *MockKinesisAPI) DescribeStreamSummary(ctx context.Context, params *kinesis.DescribeStreamSummaryInput, _ ...func(*kinesis.Options)) (*kinesis.DescribeStreamSummaryOutput, error) {
+	ret := m.Called(ctx, params)
+	out, _ := ret.Get(0).(*kinesis.DescribeStreamSummaryOutput)
+	return out, ret.Error(1)
+}
+
+func (m *MockKinesisAPI) DescribeStreamConsumer(ctx context.Context, params *kinesis.DescribeStreamConsumerInput, _ ...func(*kinesis.Options)) (*kinesis.DescribeStreamConsumerOutput, error) {
+	ret := m.Called(ctx, params)
+	out, _ := ret.Get(0).(*kinesis.DescribeStreamConsumerOutput)
+	return out, ret.Error(1)
+}
+
+func (m *MockKinesisAPI) RegisterStreamConsumer(ctx context.Context, params *kinesis.RegisterStreamConsumerInput, _ ...func(*kinesis.Options)) (*kinesis.RegisterStreamConsumerOutput, error) {
+	ret := m.Called(ctx, params)
+	out, _ := ret.Get(0).(*kinesis.RegisterStreamConsumerOutput)
+	return out, ret.Error(1)
+}
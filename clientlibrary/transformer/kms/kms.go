@@ -0,0 +1,293 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package kms implements a record transformer decrypting records encrypted with KMS envelope encryption.
+//
+// An envelope is laid out as
+//
+//	version (1 byte) | length of the encrypted data key (2 bytes, big endian) | encrypted data key |
+//	nonce (12 bytes) | AES-256-GCM ciphertext and tag
+//
+// The data key is generated by KMS and encrypted with a KMS key, so only the small data key is sent to KMS for
+// decryption. Envelopes are written by Encrypter, which reuses a data key for several envelopes until it is rotated.
+package kms
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awskms "github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+)
+
+const (
+	// EnvelopeVersion is the version of the envelopes written by Encrypter
+	EnvelopeVersion = 1
+
+	// DefaultMaxCachedDataKeys is the number of decrypted data keys kept in memory by a Decrypter
+	DefaultMaxCachedDataKeys = 1000
+
+	// DefaultDataKeyMaxAge is the time an Encrypter reuses a data key before generating a new one
+	DefaultDataKeyMaxAge = 5 * time.Minute
+
+	// DefaultDataKeyMaxUses is the number of envelopes an Encrypter writes with a data key before generating a new
+	// one, it keeps the probability of a repeated random nonce negligible
+	DefaultDataKeyMaxUses = 1 << 20
+
+	nonceSize = 12
+)
+
+// ErrInvalidEnvelope is returned for records which are not envelopes written by Encrypter
+var ErrInvalidEnvelope = errors.New("invalid KMS envelope")
+
+type (
+	// DecryptAPI is the part of the KMS client used by the decrypter
+	DecryptAPI interface {
+		Decrypt(ctx context.Context, params *awskms.DecryptInput, optFns ...func(*awskms.Options)) (*awskms.DecryptOutput, error)
+	}
+
+	// GenerateDataKeyAPI is the part of the KMS client used by the encrypter
+	GenerateDataKeyAPI interface {
+		GenerateDataKey(ctx context.Context, params *awskms.GenerateDataKeyInput, optFns ...func(*awskms.Options)) (*awskms.GenerateDataKeyOutput, error)
+	}
+
+	// Decrypter is a record transformer replacing the envelope of each record with the decrypted payload. The
+	// decrypted data keys are cached, so producers reusing a data key for several records need a single call
+	// to KMS.
+	Decrypter struct {
+		client            DecryptAPI
+		keyID             string
+		encryptionContext map[string]string
+		maxCachedDataKeys int
+
+		mux      sync.Mutex
+		dataKeys map[string][]byte
+	}
+
+	// Encrypter writes the envelopes of the records, e.g. for the producers of a stream consumed with a Decrypter.
+	// A data key is reused until it reaches its max age or max uses, so that KMS is not called for each record.
+	Encrypter struct {
+		client            GenerateDataKeyAPI
+		keyID             string
+		encryptionContext map[string]string
+		maxAge            time.Duration
+		maxUses           int
+
+		mux     sync.Mutex
+		dataKey *dataKey
+	}
+
+	// dataKey is the data key reused by an Encrypter
+	dataKey struct {
+		encryptedKey []byte
+		aead         cipher.AEAD
+		generated    time.Time
+		uses         int
+	}
+)
+
+// NewDecrypter creates a decrypter decrypting the data keys with the KMS client.
+func NewDecrypter(client DecryptAPI) *Decrypter {
+	return &Decrypter{
+		client:            client,
+		maxCachedDataKeys: DefaultMaxCachedDataKeys,
+		dataKeys:          make(map[string][]byte),
+	}
+}
+
+// WithKeyID restricts the decryption to data keys encrypted with the KMS key, which is required for asymmetric keys.
+func (d *Decrypter) WithKeyID(keyID string) *Decrypter {
+	d.keyID = keyID
+	return d
+}
+
+// WithEncryptionContext sets the encryption context the data keys have been encrypted with.
+func (d *Decrypter) WithEncryptionContext(encryptionContext map[string]string) *Decrypter {
+	d.encryptionContext = encryptionContext
+	return d
+}
+
+// WithMaxCachedDataKeys sets the number of decrypted data keys kept in memory, 0 disables the cache.
+func (d *Decrypter) WithMaxCachedDataKeys(n int) *Decrypter {
+	d.maxCachedDataKeys = n
+	return d
+}
+
+func (d *Decrypter) Transform(ctx context.Context, record *kcl.UserRecord) error {
+	encryptedKey, nonce, ciphertext, err := parseEnvelope(record.Data)
+	if err != nil {
+		return err
+	}
+
+	dataKey, err := d.dataKey(ctx, encryptedKey)
+	if err != nil {
+		return err
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return err
+	}
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt record %s: %w", aws.ToString(record.SequenceNumber), err)
+	}
+	record.Data = plaintext
+	return nil
+}
+
+// dataKey returns the plaintext of the encrypted data key, decrypting it with KMS unless it is cached
+func (d *Decrypter) dataKey(ctx context.Context, encryptedKey []byte) ([]byte, error) {
+	d.mux.Lock()
+	dataKey, ok := d.dataKeys[string(encryptedKey)]
+	d.mux.Unlock()
+	if ok {
+		return dataKey, nil
+	}
+
+	input := &awskms.DecryptInput{
+		CiphertextBlob:    encryptedKey,
+		EncryptionContext: d.encryptionContext,
+	}
+	if d.keyID != "" {
+		input.KeyId = aws.String(d.keyID)
+	}
+	output, err := d.client.Decrypt(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt the data key: %w", err)
+	}
+
+	if d.maxCachedDataKeys > 0 {
+		d.mux.Lock()
+		if len(d.dataKeys) >= d.maxCachedDataKeys {
+			d.dataKeys = make(map[string][]byte)
+		}
+		d.dataKeys[string(encryptedKey)] = output.Plaintext
+		d.mux.Unlock()
+	}
+	return output.Plaintext, nil
+}
+
+// NewEncrypter creates an encrypter generating the data keys with the KMS key.
+func NewEncrypter(client GenerateDataKeyAPI, keyID string) *Encrypter {
+	return &Encrypter{
+		client:  client,
+		keyID:   keyID,
+		maxAge:  DefaultDataKeyMaxAge,
+		maxUses: DefaultDataKeyMaxUses,
+	}
+}
+
+// WithDataKeyRotation sets the time and the number of envelopes a data key is reused for, a new data key is
+// generated once either is reached. A maxUses of 1 generates a data key for each envelope.
+func (e *Encrypter) WithDataKeyRotation(maxAge time.Duration, maxUses int) *Encrypter {
+	e.maxAge = maxAge
+	e.maxUses = maxUses
+	return e
+}
+
+// WithEncryptionContext sets the encryption context the data keys are encrypted with.
+func (e *Encrypter) WithEncryptionContext(encryptionContext map[string]string) *Encrypter {
+	e.encryptionContext = encryptionContext
+	return e
+}
+
+// Encrypt returns the envelope of the payload encrypted with the current data key.
+func (e *Encrypter) Encrypt(ctx context.Context, payload []byte) ([]byte, error) {
+	key, err := e.currentDataKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, nonceSize)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	envelope := make([]byte, 3, 3+len(key.encryptedKey)+nonceSize+len(payload)+key.aead.Overhead())
+	envelope[0] = EnvelopeVersion
+	binary.BigEndian.PutUint16(envelope[1:3], uint16(len(key.encryptedKey)))
+	envelope = append(envelope, key.encryptedKey...)
+	envelope = append(envelope, nonce...)
+	return key.aead.Seal(envelope, nonce, payload, nil), nil
+}
+
+// currentDataKey returns the data key for the next envelope, generating a new one with KMS once the current one
+// has to be rotated
+func (e *Encrypter) currentDataKey(ctx context.Context) (*dataKey, error) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+
+	now := time.Now()
+	if key := e.dataKey; key != nil && key.uses < e.maxUses && now.Sub(key.generated) < e.maxAge {
+		key.uses++
+		return key, nil
+	}
+
+	output, err := e.client.GenerateDataKey(ctx, &awskms.GenerateDataKeyInput{
+		KeyId:             aws.String(e.keyID),
+		KeySpec:           types.DataKeySpecAes256,
+		EncryptionContext: e.encryptionContext,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate a data key: %w", err)
+	}
+	if len(output.CiphertextBlob) > 0xffff {
+		return nil, fmt.Errorf("encrypted data key of %d bytes is too large", len(output.CiphertextBlob))
+	}
+
+	aead, err := newAEAD(output.Plaintext)
+	if err != nil {
+		return nil, err
+	}
+	e.dataKey = &dataKey{encryptedKey: output.CiphertextBlob, aead: aead, generated: now, uses: 1}
+	return e.dataKey, nil
+}
+
+func parseEnvelope(data []byte) (encryptedKey, nonce, ciphertext []byte, err error) {
+	if len(data) < 3 || data[0] != EnvelopeVersion {
+		return nil, nil, nil, ErrInvalidEnvelope
+	}
+	keyLength := int(binary.BigEndian.Uint16(data[1:3]))
+	if len(data) < 3+keyLength+nonceSize {
+		return nil, nil, nil, ErrInvalidEnvelope
+	}
+	encryptedKey = data[3 : 3+keyLength]
+	nonce = data[3+keyLength : 3+keyLength+nonceSize]
+	return encryptedKey, nonce, data[3+keyLength+nonceSize:], nil
+}
+
+func newAEAD(dataKey []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid data key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,125 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package kms
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	awskms "github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/stretchr/testify/assert"
+
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+)
+
+// mockKMSClient "encrypts" the data keys by prefixing them with the key ID
+type mockKMSClient struct {
+	decryptCalls  int
+	generateCalls int
+}
+
+func (m *mockKMSClient) GenerateDataKey(_ context.Context, params *awskms.GenerateDataKeyInput, _ ...func(*awskms.Options)) (*awskms.GenerateDataKeyOutput, error) {
+	m.generateCalls++
+	dataKey := bytes.Repeat([]byte{byte(m.generateCalls)}, 32)
+	return &awskms.GenerateDataKeyOutput{
+		KeyId:          params.KeyId,
+		Plaintext:      dataKey,
+		CiphertextBlob: append([]byte(aws.ToString(params.KeyId)+":"), dataKey...),
+	}, nil
+}
+
+func (m *mockKMSClient) Decrypt(_ context.Context, params *awskms.DecryptInput, _ ...func(*awskms.Options)) (*awskms.DecryptOutput, error) {
+	m.decryptCalls++
+	i := bytes.IndexByte(params.CiphertextBlob, ':')
+	if i < 0 || params.EncryptionContext["tenant"] != "a" {
+		return nil, errors.New("access denied")
+	}
+	return &awskms.DecryptOutput{Plaintext: params.CiphertextBlob[i+1:]}, nil
+}
+
+func TestEnvelopeEncryption(t *testing.T) {
+	client := &mockKMSClient{}
+	encryptionContext := map[string]string{"tenant": "a"}
+	encrypter := NewEncrypter(client, "alias/stream").WithEncryptionContext(encryptionContext)
+	decrypter := NewDecrypter(client).WithEncryptionContext(encryptionContext)
+
+	envelope, err := encrypter.Encrypt(context.TODO(), []byte("payload"))
+	assert.Nil(t, err)
+	assert.False(t, bytes.Contains(envelope, []byte("payload")))
+
+	for i := 0; i < 2; i++ {
+		record := &kcl.UserRecord{Record: types.Record{SequenceNumber: aws.String("100"), Data: envelope}}
+		assert.Nil(t, decrypter.Transform(context.TODO(), record))
+		assert.Equal(t, []byte("payload"), record.Data)
+	}
+	// the data key is decrypted once
+	assert.Equal(t, 1, client.decryptCalls)
+
+	// tampered envelopes are rejected
+	tampered := append([]byte{}, envelope...)
+	tampered[len(tampered)-1] ^= 1
+	record := &kcl.UserRecord{Record: types.Record{SequenceNumber: aws.String("101"), Data: tampered}}
+	assert.ErrorContains(t, decrypter.Transform(context.TODO(), record), "failed to decrypt record 101")
+
+	record = &kcl.UserRecord{Record: types.Record{Data: []byte("plaintext")}}
+	assert.ErrorIs(t, decrypter.Transform(context.TODO(), record), ErrInvalidEnvelope)
+
+	// the data key cannot be decrypted with another encryption context
+	decrypter = NewDecrypter(client).WithEncryptionContext(map[string]string{"tenant": "b"})
+	record = &kcl.UserRecord{Record: types.Record{Data: envelope}}
+	assert.ErrorContains(t, decrypter.Transform(context.TODO(), record), "failed to decrypt the data key: access denied")
+}
+
+func TestEncrypterRotatesDataKey(t *testing.T) {
+	client := &mockKMSClient{}
+	encryptionContext := map[string]string{"tenant": "a"}
+	encrypter := NewEncrypter(client, "alias/stream").WithEncryptionContext(encryptionContext).
+		WithDataKeyRotation(time.Hour, 2)
+	decrypter := NewDecrypter(client).WithEncryptionContext(encryptionContext)
+
+	var envelopes [][]byte
+	for i := 0; i < 3; i++ {
+		envelope, err := encrypter.Encrypt(context.TODO(), []byte("payload"))
+		assert.Nil(t, err)
+		envelopes = append(envelopes, envelope)
+	}
+	// the data key is reused for two envelopes with different nonces
+	assert.Equal(t, 2, client.generateCalls)
+	assert.NotEqual(t, envelopes[0], envelopes[1])
+
+	for _, envelope := range envelopes {
+		record := &kcl.UserRecord{Record: types.Record{Data: envelope}}
+		assert.Nil(t, decrypter.Transform(context.TODO(), record))
+		assert.Equal(t, []byte("payload"), record.Data)
+	}
+	assert.Equal(t, 2, client.decryptCalls)
+
+	// the data key expires after its max age
+	encrypter.WithDataKeyRotation(time.Nanosecond, 100)
+	_, _ = encrypter.Encrypt(context.TODO(), []byte("payload"))
+	time.Sleep(time.Millisecond)
+	_, _ = encrypter.Encrypt(context.TODO(), []byte("payload"))
+	assert.Equal(t, 4, client.generateCalls)
+}
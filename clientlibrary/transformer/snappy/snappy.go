@@ -0,0 +1,44 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package snappy implements a record transformer decompressing snappy compressed records
+package snappy
+
+import (
+	"context"
+
+	"github.com/golang/snappy"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/transformer"
+)
+
+// Decompress returns a transformer decompressing the records compressed with the snappy block format, e.g. by
+// snappy.Encode, of at most transformer.MaxDecompressedSize bytes.
+func Decompress() transformer.RecordTransformer {
+	return transformer.Func(func(_ context.Context, data []byte) ([]byte, error) {
+		n, err := snappy.DecodedLen(data)
+		if err != nil {
+			return nil, err
+		}
+		if n > transformer.MaxDecompressedSize {
+			return nil, transformer.ErrPayloadTooLarge
+		}
+		return snappy.Decode(nil, data)
+	})
+}
@@ -0,0 +1,95 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package transformer
+// Record transformers rewrite the data of each record before it is delivered to the record processor, e.g. to
+// decrypt or decompress the payloads written by the producers.
+package transformer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+)
+
+// MaxDecompressedSize is the max size of a decompressed record, larger records are rejected, so that a small
+// compressed record cannot exhaust the memory of the worker
+const MaxDecompressedSize = 10 << 20
+
+// ErrPayloadTooLarge is returned for a compressed record larger than MaxDecompressedSize once decompressed
+var ErrPayloadTooLarge = errors.New("decompressed record too large")
+
+type (
+	// RecordTransformer transforms the data of a user record before it is delivered to the record processor. The
+	// records are transformed after de-aggregation, so the transformer sees the user records written by the
+	// producer. If a record cannot be transformed, it is published to the dead-letter queue if there is one,
+	// otherwise the shard consumer fails.
+	RecordTransformer interface {
+		Transform(ctx context.Context, record *kcl.UserRecord) error
+	}
+
+	// Func is an adapter to use a function transforming the data of a record as RecordTransformer
+	Func func(ctx context.Context, data []byte) ([]byte, error)
+
+	chain []RecordTransformer
+)
+
+// Transform replaces the data of the record with f(ctx, record.Data).
+func (f Func) Transform(ctx context.Context, record *kcl.UserRecord) error {
+	data, err := f(ctx, record.Data)
+	if err != nil {
+		return err
+	}
+	record.Data = data
+	return nil
+}
+
+// Chain returns a transformer applying the transformers in order, e.g. decryption before decompression.
+func Chain(transformers ...RecordTransformer) RecordTransformer {
+	return chain(transformers)
+}
+
+func (c chain) Transform(ctx context.Context, record *kcl.UserRecord) error {
+	for _, t := range c {
+		if err := t.Transform(ctx, record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Gzip returns a transformer decompressing gzip compressed records of at most MaxDecompressedSize bytes.
+func Gzip() RecordTransformer {
+	return Func(func(_ context.Context, data []byte) ([]byte, error) {
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		payload, err := io.ReadAll(io.LimitReader(r, MaxDecompressedSize+1))
+		if err == nil && len(payload) > MaxDecompressedSize {
+			err = ErrPayloadTooLarge
+		}
+		return payload, err
+	})
+}
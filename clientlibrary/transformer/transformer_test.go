@@ -0,0 +1,69 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package transformer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/stretchr/testify/assert"
+
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+)
+
+func TestGzip(t *testing.T) {
+	var compressed bytes.Buffer
+	w := gzip.NewWriter(&compressed)
+	_, _ = w.Write([]byte("payload"))
+	assert.Nil(t, w.Close())
+
+	record := &kcl.UserRecord{Record: types.Record{Data: compressed.Bytes()}}
+	assert.Nil(t, Gzip().Transform(context.TODO(), record))
+	assert.Equal(t, []byte("payload"), record.Data)
+
+	record = &kcl.UserRecord{Record: types.Record{Data: []byte("not compressed")}}
+	assert.NotNil(t, Gzip().Transform(context.TODO(), record))
+	assert.Equal(t, []byte("not compressed"), record.Data)
+
+	// a record which decompresses beyond the limit is rejected
+	compressed.Reset()
+	w = gzip.NewWriter(&compressed)
+	_, _ = w.Write(make([]byte, MaxDecompressedSize+1))
+	assert.Nil(t, w.Close())
+	record = &kcl.UserRecord{Record: types.Record{Data: compressed.Bytes()}}
+	assert.Equal(t, ErrPayloadTooLarge, Gzip().Transform(context.TODO(), record))
+}
+
+func TestChain(t *testing.T) {
+	upper := Func(func(_ context.Context, data []byte) ([]byte, error) {
+		return []byte(strings.ToUpper(string(data))), nil
+	})
+	suffix := Func(func(_ context.Context, data []byte) ([]byte, error) {
+		return append(data, '!'), nil
+	})
+
+	record := &kcl.UserRecord{Record: types.Record{Data: []byte("a")}}
+	assert.Nil(t, Chain(upper, suffix).Transform(context.TODO(), record))
+	assert.Equal(t, []byte("A!"), record.Data)
+}
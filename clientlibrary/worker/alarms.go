@@ -0,0 +1,198 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"sort"
+	"time"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/metrics"
+)
+
+type (
+	// alarmSample is the state of a shard consumer the alarms of its shard are evaluated with
+	alarmSample struct {
+		shardID            string
+		checkpoint         string
+		millisBehindLatest int64
+
+		// caughtUp is the last time the consumer received no records at the tip of the shard
+		caughtUp time.Time
+	}
+
+	// observedCheckpoint is the checkpoint of a shard and the time it has been observed first
+	observedCheckpoint struct {
+		checkpoint string
+		since      time.Time
+	}
+
+	// alarmEvaluator compares the checkpoint ages and the MillisBehindLatest of the shards consumed by a worker with
+	// the alarm thresholds. It is only used by the goroutine evaluating the alarms.
+	alarmEvaluator struct {
+		mService           metrics.MonitoringService
+		handler            config.AlarmHandler
+		checkpointAge      int64
+		millisBehindLatest int64
+
+		checkpoints map[string]observedCheckpoint
+		raised      map[string]map[config.AlarmType]bool
+	}
+)
+
+// newAlarmEvaluator returns nil unless an alarm threshold is configured
+func newAlarmEvaluator(kclConfig *config.KinesisClientLibConfiguration, mService metrics.MonitoringService) *alarmEvaluator {
+	if kclConfig.CheckpointAgeAlarmMillis == 0 && kclConfig.MillisBehindLatestAlarmMillis == 0 {
+		return nil
+	}
+	return &alarmEvaluator{
+		mService:           mService,
+		handler:            kclConfig.AlarmHandler,
+		checkpointAge:      int64(kclConfig.CheckpointAgeAlarmMillis),
+		millisBehindLatest: int64(kclConfig.MillisBehindLatestAlarmMillis),
+		checkpoints:        make(map[string]observedCheckpoint),
+		raised:             make(map[string]map[config.AlarmType]bool),
+	}
+}
+
+// evaluate raises and clears the alarms of the shards and reports their checkpoint ages. The checkpoint age of a
+// shard is the time since its checkpoint has advanced or its consumer has been caught up with the tip of the shard
+// without records to process, so that idle shards don't raise alarms. The alarms of the shards which are no longer
+// consumed are cleared.
+func (e *alarmEvaluator) evaluate(now time.Time, samples []alarmSample) {
+	consumed := make(map[string]bool, len(samples))
+	for _, sample := range samples {
+		consumed[sample.shardID] = true
+
+		observed, ok := e.checkpoints[sample.shardID]
+		if !ok || observed.checkpoint != sample.checkpoint {
+			observed = observedCheckpoint{checkpoint: sample.checkpoint, since: now}
+			e.checkpoints[sample.shardID] = observed
+		}
+		since := observed.since
+		if sample.caughtUp.After(since) {
+			since = sample.caughtUp
+		}
+		age := now.Sub(since).Milliseconds()
+		if m, ok := e.mService.(metrics.AlarmMonitoringService); ok {
+			m.CheckpointAge(sample.shardID, float64(age))
+		}
+
+		if e.checkpointAge > 0 {
+			e.set(sample.shardID, config.CheckpointAgeAlarm, age, e.checkpointAge)
+		}
+		if e.millisBehindLatest > 0 {
+			e.set(sample.shardID, config.MillisBehindLatestAlarm, sample.millisBehindLatest, e.millisBehindLatest)
+		}
+	}
+
+	var gone []string
+	for shardID := range e.checkpoints {
+		if !consumed[shardID] {
+			gone = append(gone, shardID)
+		}
+	}
+	sort.Strings(gone)
+	for _, shardID := range gone {
+		delete(e.checkpoints, shardID)
+		if m, ok := e.mService.(metrics.AlarmMonitoringService); ok {
+			m.DeleteMetricCheckpointAge(shardID)
+		}
+		e.clear(shardID)
+	}
+}
+
+// set raises the alarm of a shard once the value exceeds the threshold, and clears it once the value is back within
+// the threshold
+func (e *alarmEvaluator) set(shardID string, alarmType config.AlarmType, millis, threshold int64) {
+	exceeded := millis > threshold
+	if exceeded == e.raised[shardID][alarmType] {
+		return
+	}
+
+	if exceeded {
+		if e.raised[shardID] == nil {
+			e.raised[shardID] = make(map[config.AlarmType]bool)
+		}
+		e.raised[shardID][alarmType] = true
+		if m, ok := e.mService.(metrics.AlarmMonitoringService); ok {
+			m.IncrAlarmsRaised(shardID)
+		}
+	} else {
+		delete(e.raised[shardID], alarmType)
+	}
+	e.notify(config.Alarm{Type: alarmType, ShardID: shardID, Millis: millis, ThresholdMillis: threshold, Raised: exceeded})
+}
+
+// clear clears the raised alarms of a shard which is no longer consumed
+func (e *alarmEvaluator) clear(shardID string) {
+	for _, alarmType := range []config.AlarmType{config.CheckpointAgeAlarm, config.MillisBehindLatestAlarm} {
+		if !e.raised[shardID][alarmType] {
+			continue
+		}
+		threshold := e.checkpointAge
+		if alarmType == config.MillisBehindLatestAlarm {
+			threshold = e.millisBehindLatest
+		}
+		e.notify(config.Alarm{Type: alarmType, ShardID: shardID, ThresholdMillis: threshold})
+	}
+	delete(e.raised, shardID)
+}
+
+func (e *alarmEvaluator) notify(alarm config.Alarm) {
+	if e.handler != nil {
+		e.handler(alarm)
+	}
+}
+
+// alarmSamples returns the state of the shard consumers of the worker
+func (w *Worker) alarmSamples() []alarmSample {
+	w.statusMux.RLock()
+	defer w.statusMux.RUnlock()
+
+	samples := make([]alarmSample, 0, len(w.consumers))
+	for _, consumer := range w.consumers {
+		consumer.Lock()
+		samples = append(samples, alarmSample{
+			shardID:            consumer.shard.ID,
+			checkpoint:         consumer.shard.GetCheckpoint(),
+			millisBehindLatest: consumer.millisBehindLatest,
+			caughtUp:           consumer.caughtUp,
+		})
+		consumer.Unlock()
+	}
+	return samples
+}
+
+// evaluateAlarms evaluates the alarms of the shards consumed by the worker every AlarmEvaluationIntervalMillis until
+// the worker is shut down
+func (w *Worker) evaluateAlarms() {
+	ticker := time.NewTicker(time.Duration(w.kclConfig.AlarmEvaluationIntervalMillis) * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-*w.stop:
+			return
+		case now := <-ticker.C:
+			w.alarms.evaluate(now, w.alarmSamples())
+		}
+	}
+}
@@ -0,0 +1,125 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/metrics"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+// checkpointAgeRecorder records the checkpoint ages and the alarms reported to the monitoring service
+type checkpointAgeRecorder struct {
+	metrics.NoopMonitoringService
+	ages    map[string]float64
+	alarms  int
+	deleted []string
+}
+
+func (r *checkpointAgeRecorder) CheckpointAge(shard string, milliSeconds float64) {
+	r.ages[shard] = milliSeconds
+}
+
+func (r *checkpointAgeRecorder) DeleteMetricCheckpointAge(shard string) {
+	r.deleted = append(r.deleted, shard)
+}
+
+func (r *checkpointAgeRecorder) IncrAlarmsRaised(string) {
+	r.alarms++
+}
+
+func TestAlarmEvaluator(t *testing.T) {
+	var alarms []config.Alarm
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+		WithCheckpointAgeAlarm(60000).
+		WithMillisBehindLatestAlarm(30000).
+		WithAlarmHandler(func(alarm config.Alarm) { alarms = append(alarms, alarm) })
+	assert.Nil(t, kclConfig.Validate())
+	mService := &checkpointAgeRecorder{ages: map[string]float64{}}
+	e := newAlarmEvaluator(kclConfig, mService)
+
+	start := time.Now()
+	e.evaluate(start, []alarmSample{
+		{shardID: "shardId-0", checkpoint: "100"},
+		{shardID: "shardId-1", checkpoint: "200", millisBehindLatest: 40000},
+	})
+	assert.Equal(t, []config.Alarm{
+		{Type: config.MillisBehindLatestAlarm, ShardID: "shardId-1", Millis: 40000, ThresholdMillis: 30000, Raised: true},
+	}, alarms)
+
+	// the checkpoint of a shard with records to process has not advanced for longer than the threshold
+	alarms = nil
+	e.evaluate(start.Add(90*time.Second), []alarmSample{
+		{shardID: "shardId-0", checkpoint: "100"},
+		{shardID: "shardId-1", checkpoint: "300", millisBehindLatest: 40000, caughtUp: start.Add(10 * time.Second)},
+	})
+	assert.Equal(t, []config.Alarm{
+		{Type: config.CheckpointAgeAlarm, ShardID: "shardId-0", Millis: 90000, ThresholdMillis: 60000, Raised: true},
+	}, alarms)
+	assert.Equal(t, map[string]float64{"shardId-0": 90000, "shardId-1": 0}, mService.ages)
+	assert.Equal(t, 2, mService.alarms)
+
+	// an idle shard has nothing to checkpoint and a caught up shard is no longer behind
+	alarms = nil
+	e.evaluate(start.Add(100*time.Second), []alarmSample{
+		{shardID: "shardId-0", checkpoint: "100", caughtUp: start.Add(95 * time.Second)},
+		{shardID: "shardId-1", checkpoint: "300"},
+	})
+	assert.Equal(t, []config.Alarm{
+		{Type: config.CheckpointAgeAlarm, ShardID: "shardId-0", Millis: 5000, ThresholdMillis: 60000},
+		{Type: config.MillisBehindLatestAlarm, ShardID: "shardId-1", ThresholdMillis: 30000},
+	}, alarms)
+
+	// the alarms of a shard which is no longer consumed are cleared
+	alarms = nil
+	e.evaluate(start.Add(110*time.Second), []alarmSample{
+		{shardID: "shardId-0", checkpoint: "100", millisBehindLatest: 50000, caughtUp: start.Add(95 * time.Second)},
+	})
+	e.evaluate(start.Add(120*time.Second), nil)
+	assert.Equal(t, []config.Alarm{
+		{Type: config.MillisBehindLatestAlarm, ShardID: "shardId-0", Millis: 50000, ThresholdMillis: 30000, Raised: true},
+		{Type: config.MillisBehindLatestAlarm, ShardID: "shardId-0", ThresholdMillis: 30000},
+	}, alarms)
+	assert.Equal(t, []string{"shardId-1", "shardId-0"}, mService.deleted)
+	assert.Nil(t, newAlarmEvaluator(config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker"), mService))
+}
+
+func TestAlarmSamples(t *testing.T) {
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+		WithMillisBehindLatestAlarm(30000)
+	w := NewWorker(shutdownRecorderFactory{}, kclConfig)
+	shard := &par.ShardStatus{ID: "shardId-0", Checkpoint: "100", Mux: &sync.RWMutex{}}
+	status := w.registerConsumer(shard)
+	status.setMillisBehindLatest(40000, 1000)
+	status.setCaughtUp()
+
+	samples := w.alarmSamples()
+	assert.Equal(t, 1, len(samples))
+	assert.Equal(t, "shardId-0", samples[0].shardID)
+	assert.Equal(t, "100", samples[0].checkpoint)
+	assert.Equal(t, int64(40000), samples[0].millisBehindLatest)
+	assert.False(t, samples[0].caughtUp.IsZero())
+}
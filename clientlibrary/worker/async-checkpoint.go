@@ -0,0 +1,65 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"sync"
+)
+
+// asyncCheckpoint coalesces the checkpoints requested by CheckpointAsync for a shard. Only the last valid requested
+// sequence number is stored, each request receives the error of its own validation or the result of storing it.
+type asyncCheckpoint struct {
+	// writeMux serializes the checkpoints of the shard so that they are stored in the requested order
+	writeMux sync.Mutex
+
+	mux      sync.Mutex
+	requests []asyncRequest
+}
+
+// asyncRequest is a checkpoint requested by CheckpointAsync with the channel receiving its result
+type asyncRequest struct {
+	sequenceNumber *string
+	result         chan error
+}
+
+// request adds a pending checkpoint and returns the channel receiving the result of storing it
+func (a *asyncCheckpoint) request(sequenceNumber *string) <-chan error {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	result := make(chan error, 1)
+	a.requests = append(a.requests, asyncRequest{sequenceNumber: sequenceNumber, result: result})
+	return result
+}
+
+// take removes the pending checkpoints in the requested order
+func (a *asyncCheckpoint) take() []asyncRequest {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	requests := a.requests
+	a.requests = nil
+	return requests
+}
+
+func (r asyncRequest) done(err error) {
+	r.result <- err
+	close(r.result)
+}
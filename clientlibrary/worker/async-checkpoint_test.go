@@ -0,0 +1,109 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+
+	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/metrics"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+func newLeasedCheckpointer(t *testing.T) (*mockCheckpointer, *RecordProcessorCheckpointer) {
+	checkpointer := newMockCheckpointer()
+	shard := &par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}, LeaseTimeout: time.Now().Add(time.Minute)}
+	assert.Nil(t, checkpointer.GetLease(shard, "worker"))
+
+	rc := newRecordProcessorCheckpointer(shard, checkpointer, metrics.NoopMonitoringService{})
+	return checkpointer, rc.(*RecordProcessorCheckpointer)
+}
+
+func TestCheckpointAsyncCoalesces(t *testing.T) {
+	checkpointer, rc := newLeasedCheckpointer(t)
+
+	first := rc.CheckpointAsync(aws.String("100"))
+	second := rc.CheckpointAsync(aws.String("101"))
+	_, ok := checkpointer.checkpoints["0001"]
+	assert.False(t, ok)
+
+	// only the last requested sequence number is stored, all requests receive the result
+	assert.Nil(t, rc.flushAsyncCheckpoint())
+	assert.Equal(t, "101", checkpointer.checkpoints["0001"])
+	assert.Nil(t, <-first)
+	assert.Nil(t, <-second)
+
+	// nothing is pending anymore
+	checkpointer.checkpoints["0001"] = "200"
+	assert.Nil(t, rc.flushAsyncCheckpoint())
+	assert.Equal(t, "200", checkpointer.checkpoints["0001"])
+}
+
+func TestCheckpointAsyncResultPerRequest(t *testing.T) {
+	checkpointer, rc := newLeasedCheckpointer(t)
+	assert.Nil(t, rc.Checkpoint(aws.String("100")))
+
+	// the request rewinding the checkpoint fails on its own, the valid one is stored
+	valid := rc.CheckpointAsync(aws.String("200"))
+	rewound := rc.CheckpointAsync(aws.String("50"))
+	assert.Nil(t, rc.flushAsyncCheckpoint())
+	assert.Equal(t, "200", checkpointer.checkpoints["0001"])
+	assert.Nil(t, <-valid)
+	var skipped *SkippedSequenceError
+	assert.ErrorAs(t, <-rewound, &skipped)
+
+	// a pending request rewinding the checkpoint is not answered with the result of a newer checkpoint
+	rewound = rc.CheckpointAsync(aws.String("150"))
+	assert.Nil(t, rc.Checkpoint(aws.String("300")))
+	assert.ErrorAs(t, <-rewound, &skipped)
+}
+
+func TestCheckpointSupersedesAsync(t *testing.T) {
+	checkpointer, rc := newLeasedCheckpointer(t)
+
+	pending := rc.CheckpointAsync(aws.String("100"))
+	assert.Nil(t, rc.Checkpoint(aws.String("101")))
+	assert.Nil(t, <-pending)
+
+	// the pending checkpoint is not stored after the newer one
+	assert.Nil(t, rc.flushAsyncCheckpoint())
+	assert.Equal(t, "101", checkpointer.checkpoints["0001"])
+}
+
+func TestCheckpointAsyncReportsError(t *testing.T) {
+	checkpointer, rc := newLeasedCheckpointer(t)
+
+	// another worker has taken the lease
+	checkpointer.owners["0001"] = "other"
+	pending := rc.CheckpointAsync(aws.String("100"))
+	err := rc.flushAsyncCheckpoint()
+	assert.Equal(t, chk.NewLeaseLostError("0001", ShutdownError), err)
+	assert.ErrorIs(t, err, ShutdownError)
+	assert.ErrorIs(t, err, chk.ErrLeaseLost)
+	assert.Equal(t, err, <-pending)
+
+	_, ok := <-pending
+	assert.False(t, ok)
+}
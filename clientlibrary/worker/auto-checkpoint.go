@@ -0,0 +1,117 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/sequencenumber"
+)
+
+// autoCheckpointer checkpoints the batches processed by the record processor of a shard with AutoCheckpoint. It is
+// used by the goroutine of the shard consumer only.
+type autoCheckpointer struct {
+	interval    time.Duration
+	recordCount int
+
+	// last is the last record processed since the last checkpoint
+	last    *kcl.UserRecord
+	records int
+	since   time.Time
+}
+
+func newAutoCheckpointer(kclConfig *config.KinesisClientLibConfiguration) *autoCheckpointer {
+	return &autoCheckpointer{
+		interval:    time.Duration(kclConfig.AutoCheckpointIntervalMillis) * time.Millisecond,
+		recordCount: kclConfig.AutoCheckpointRecordCount,
+		since:       time.Now(),
+	}
+}
+
+// autoCheckpoints returns the auto checkpointer of the consumer, nil unless AutoCheckpoint is enabled
+func (sc *commonShardConsumer) autoCheckpoints() *autoCheckpointer {
+	if sc.autoCheckpoint == nil && sc.kclConfig.AutoCheckpoint {
+		sc.autoCheckpoint = newAutoCheckpointer(sc.kclConfig)
+	}
+	return sc.autoCheckpoint
+}
+
+// processed records the batch processed successfully by the record processor
+func (a *autoCheckpointer) processed(records []kcl.UserRecord) {
+	if len(records) == 0 {
+		return
+	}
+	last := records[len(records)-1]
+	a.last = &last
+	a.records += len(records)
+}
+
+// isDue tells whether the processed records are checkpointed, every batch is checkpointed without thresholds
+func (a *autoCheckpointer) isDue(now time.Time) bool {
+	if a.interval == 0 && a.recordCount == 0 {
+		return true
+	}
+	return (a.recordCount > 0 && a.records >= a.recordCount) || (a.interval > 0 && now.Sub(a.since) >= a.interval)
+}
+
+// checkpoint checkpoints the last processed record once it is due, or regardless of the thresholds with force. The
+// record is not checkpointed if the record processor has checkpointed it, or a later record, itself. A failed
+// checkpoint is retried after the next batch.
+func (a *autoCheckpointer) checkpoint(checkpointer kcl.IRecordProcessorCheckpointer, shard *par.ShardStatus, force bool) error {
+	now := time.Now()
+	if a == nil || a.last == nil || (!force && !a.isDue(now)) {
+		return nil
+	}
+
+	if isAfterCheckpoint(shard, *a.last) {
+		if err := checkpointRecord(checkpointer, *a.last); err != nil {
+			return err
+		}
+	}
+
+	a.last = nil
+	a.records = 0
+	a.since = now
+	return nil
+}
+
+// isAfterCheckpoint tells whether the record is after the checkpoint of the shard
+func isAfterCheckpoint(shard *par.ShardStatus, record kcl.UserRecord) bool {
+	checkpoint := shard.GetCheckpoint()
+	if checkpoint == chk.ShardEnd {
+		return false
+	}
+	// the initial positions in the stream are before any record
+	if !sequencenumber.IsValid(checkpoint) {
+		return true
+	}
+
+	var subSequenceNumber *int64
+	if record.Aggregated {
+		subSequenceNumber = aws.Int64(record.SubSequenceNumber)
+	}
+	return isBeforeCheckpoint(aws.ToString(record.SequenceNumber), subSequenceNumber, aws.String(checkpoint), shard.GetSubSequenceNumber())
+}
@@ -21,7 +21,11 @@
 package worker
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"math"
 	"sync"
 	"time"
 
@@ -29,14 +33,27 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/kinesis"
 	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
 	deagg "github.com/awslabs/kinesis-aggregation/go/v2/deaggregator"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 
 	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
 	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/deadletter"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/decoder"
 	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/leaseaudit"
 	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/metrics"
 	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+	"github.com/vmware/vmware-go-kcl-v2/logger"
 )
 
+// tracerName is the instrumentation name of the spans of the KCL
+const tracerName = "github.com/vmware/vmware-go-kcl-v2"
+
+// maxProcessRecordsBackoff is the max backoff before retrying a batch failed by the record processor
+const maxProcessRecordsBackoff = 30 * time.Second
+
 type shardConsumer interface {
 	getRecords() error
 }
@@ -52,39 +69,276 @@ type commonShardConsumer struct {
 	shard           *par.ShardStatus
 	kc              KinesisSubscriberGetter
 	checkpointer    chk.Checkpointer
-	recordProcessor kcl.IRecordProcessor
+	recordProcessor kcl.IRecordProcessorWithContext
 	kclConfig       *config.KinesisClientLibConfiguration
 	mService        metrics.MonitoringService
+	tracer          trace.Tracer
+
+	// log adds the shard to the fields of the logger of the worker
+	log logger.Logger
+
+	// ctx is the context of the worker which is canceled once the worker shuts down
+	ctx context.Context
+
+	// limiter limits the processing rate of all shard consumers of the worker
+	limiter *processingLimiter
+
+	// scheduler bounds the shard consumers of the worker fetching and processing records at the same time
+	scheduler *consumerScheduler
+
+	// lag keeps the lag of all shard consumers of the worker, it is nil unless the consumer is run by a worker
+	lag *lagTracker
+
+	// shardEnd is notified once the end of the shard has been reached to lease its child shards immediately
+	shardEnd *shardEndNotifier
+
+	// childShards are the child shards returned by Kinesis with the last records of the closed shard
+	childShards []types.ChildShard
+
+	// renewer renews the lease of the shard while it is consumed, it is nil until the consumer loop starts
+	renewer *leaseRenewer
+
+	// status is reported by Worker.Status, it is nil unless the consumer is run by a worker
+	status *consumerStatus
+
+	// stateListener is notified of lease and shard events, it is nil unless the consumer is run by a worker
+	stateListener WorkerStateListener
+
+	// settings are changed by Worker.UpdateConfig, the configuration is used unless the consumer is run by a worker
+	settings *reloadableConfig
+
+	// rewound is set once the checkpoint has been rewound, the lease is kept for the restarted consumer
+	rewound bool
+
+	// deliveries count the deliveries of failing batches, they are shared by the consumers of a worker
+	deliveries *deliveryTracker
+
+	// releaseRequest is completed once the lease released on request of the application has been removed
+	releaseRequest *releaseRequest
+
+	// isShutdown is set once the record processor has been notified that processing of the shard stops
+	isShutdown bool
+
+	// autoCheckpoint checkpoints the processed batches with AutoCheckpoint, see autoCheckpoints
+	autoCheckpoint *autoCheckpointer
+
+	// resumeFrom is set when the consumer restarts within an aggregated record, the user records up to
+	// and including its sub-sequence number have been processed already.
+	resumeFrom *kcl.ExtendedSequenceNumber
 }
 
-// Cleanup the internal lease cache
+// releaseLease clears the lease owner in the checkpointer so that other workers can claim the shard without
+// waiting for the lease to expire. It also cleans up the internal lease cache.
 func (sc *commonShardConsumer) releaseLease(shard string) {
-	log := sc.kclConfig.Logger
+	if sc.rewound {
+		return
+	}
+
+	log := sc.getLogger()
 	log.Infof("Release lease for shard %s", sc.shard.ID)
 	sc.shard.SetLeaseOwner("")
 
 	// Release the lease by wiping out the lease owner for the shard
 	// Note: we don't need to do anything in case of error here and shard lease will eventually be expired.
-	if err := sc.checkpointer.RemoveLeaseOwner(sc.shard.ID); err != nil {
+	err := sc.checkpointer.RemoveLeaseOwner(sc.shard.ID)
+	if err != nil {
 		log.Debugf("Failed to release shard lease or shard: %s Error: %+v", sc.shard.ID, err)
 	}
+	// a lease which could not be released otherwise is taken over by another worker once it has expired
+	if err == nil {
+		recordLeaseEvent(sc.kclConfig, log, leaseaudit.LeaseReleased, sc.shard, "")
+	} else if sc.renewer.isLost() && !isShardClaimed(sc.renewer.err) {
+		recordLeaseEvent(sc.kclConfig, log, leaseaudit.LeaseLost, sc.shard, "")
+	}
+	if sc.releaseRequest != nil {
+		sc.releaseRequest.done <- err
+	}
 
 	// reporting lease lose metrics
 	sc.mService.DeleteMetricMillisBehindLatest(shard)
+	sc.lag.remove(shard)
 	sc.mService.LeaseLost(sc.shard.ID)
+	if sc.stateListener != nil {
+		sc.stateListener.LeaseLost(sc.shard.ID)
+	}
+}
+
+// handOffLease shuts down the record processor because another worker has claimed the shard (lease stealing).
+// The lease is still held until it is released, so the record processor can checkpoint its progress before
+// the claiming worker takes over.
+func (sc *commonShardConsumer) handOffLease(checkpointer kcl.IRecordProcessorCheckpointer) {
+	sc.getLogger().Infof("Shard %s has been claimed by another worker, handing off the lease", sc.shard.ID)
+	sc.shutdownRecordProcessor(kcl.REQUESTED, checkpointer)
+}
+
+// shutdownRecordProcessor notifies the record processor once that processing of the shard stops. The
+// lease is released only after the callback has returned, so that the record processor can checkpoint
+// and the shard is immediately available to other workers afterwards.
+func (sc *commonShardConsumer) shutdownRecordProcessor(reason kcl.ShutdownReason, checkpointer kcl.IRecordProcessorCheckpointer) {
+	if sc.isShutdown {
+		return
+	}
+	sc.isShutdown = true
+	sc.status.setState(ConsumerShuttingDown)
+
+	sc.getLogger().Infof("Shutting down record processor of shard %s, reason: %s", sc.shard.ID, aws.ToString(kcl.ShutdownReasonMessage(reason)))
+	// the record processor may still checkpoint within the shutdown grace period after the worker context is canceled
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(sc.kclConfig.ShutdownGraceMillis)*time.Millisecond)
+	defer cancel()
+
+	// the records processed since the last automatic checkpoint are checkpointed while the lease is held, before
+	// the record processor may checkpoint the end of the shard
+	if reason != kcl.ZOMBIE {
+		if err := sc.autoCheckpoint.checkpoint(checkpointer, sc.shard, true); err != nil {
+			sc.getLogger().Errorf("Failed to checkpoint the processed records of shard %s. Error: %+v", sc.shard.ID, err)
+		}
+	}
+
+	shutdownInput := &kcl.ShutdownInput{ShutdownReason: reason, Checkpointer: checkpointer}
+	if reason == kcl.TERMINATE {
+		shutdownInput.ChildShards = toChildShards(sc.childShards)
+	}
+	sc.recordProcessor.Shutdown(ctx, shutdownInput)
+
+	// store the checkpoint requested asynchronously before the lease is released
+	if rc, ok := checkpointer.(*RecordProcessorCheckpointer); ok {
+		if err := rc.flushAsyncCheckpoint(); err != nil {
+			sc.getLogger().Errorf("Failed to flush the asynchronous checkpoint of shard %s. Error: %+v", sc.shard.ID, err)
+		}
+	}
+
+	if reason == kcl.TERMINATE {
+		if sc.stateListener != nil {
+			sc.stateListener.ShardEnded(sc.shard.ID)
+		}
+		if sc.shardEnd != nil {
+			sc.shardEnd.notify(sc.shard, sc.childShards)
+		}
+	}
+}
+
+// toChildShards converts the child shards returned by Kinesis to those of the ShutdownInput
+func toChildShards(shards []types.ChildShard) []kcl.ChildShard {
+	if len(shards) == 0 {
+		return nil
+	}
+
+	children := make([]kcl.ChildShard, 0, len(shards))
+	for _, s := range shards {
+		child := kcl.ChildShard{ShardId: aws.ToString(s.ShardId), ParentShards: s.ParentShards}
+		if s.HashKeyRange != nil {
+			child.StartingHashKey = aws.ToString(s.HashKeyRange.StartingHashKey)
+			child.EndingHashKey = aws.ToString(s.HashKeyRange.EndingHashKey)
+		}
+		children = append(children, child)
+	}
+	return children
+}
+
+// context returns the context of the worker
+func (sc *commonShardConsumer) context() context.Context {
+	if sc.ctx == nil {
+		return context.Background()
+	}
+	return sc.ctx
+}
+
+// getLogger returns the logger of the consumer, the logger of the configuration is used if none has been provided.
+func (sc *commonShardConsumer) getLogger() logger.Logger {
+	if sc.log == nil {
+		return sc.kclConfig.Logger
+	}
+	return sc.log
+}
+
+// getTracer returns the tracer of the consumer, spans are not recorded if no tracer has been provided.
+func (sc *commonShardConsumer) getTracer() trace.Tracer {
+	if sc.tracer == nil {
+		return trace.NewNoopTracerProvider().Tracer(tracerName)
+	}
+	return sc.tracer
+}
+
+// startSpan starts a span of the shard
+func (sc *commonShardConsumer) startSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	return sc.getTracer().Start(ctx, name, trace.WithAttributes(attribute.String("kinesis.shard_id", sc.shard.ID)))
+}
+
+// endSpan records the error of a failed call and ends the span
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// startAsyncCheckpointFlusher flushes the checkpoints requested by CheckpointAsync every AsyncCheckpointIntervalMillis
+// until the returned function is called.
+func (sc *commonShardConsumer) startAsyncCheckpointFlusher(checkpointer kcl.IRecordProcessorCheckpointer) func() {
+	rc, ok := checkpointer.(*RecordProcessorCheckpointer)
+	if !ok {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(time.Duration(sc.kclConfig.AsyncCheckpointIntervalMillis) * time.Millisecond)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				// the error is reported to the record processor through the channels of the requests
+				if err := rc.flushAsyncCheckpoint(); err != nil {
+					sc.getLogger().Warnf("Failed to flush the asynchronous checkpoint of shard %s. Error: %+v", sc.shard.ID, err)
+				}
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// isLeaseRequested returns true if another worker has claimed the shard, so that the lease is handed off before the
+// claiming worker takes it over
+func (sc *commonShardConsumer) isLeaseRequested() bool {
+	if !sc.kclConfig.EnableLeaseStealing {
+		return false
+	}
+	claimRequest := sc.shard.GetClaimRequest()
+	return claimRequest != "" && claimRequest != sc.shard.GetLeaseOwner() && !sc.shard.IsClaimRequestExpired(sc.kclConfig)
+}
+
+// isShardClaimed returns true if the lease could not be renewed because another worker has claimed the shard, the
+// message is compared for custom checkpointers which return an error of their own
+func isShardClaimed(err error) bool {
+	return errors.Is(err, chk.ErrShardAlreadyClaimed) || (err != nil && err.Error() == chk.ErrShardClaimed)
 }
 
 // getStartingPosition gets kinesis stating position.
 // First try to fetch checkpoint. If checkpoint is not found use InitialPositionInStream
 func (sc *commonShardConsumer) getStartingPosition() (*types.StartingPosition, error) {
 	err := sc.checkpointer.FetchCheckpoint(sc.shard)
-	if err != nil && err != chk.ErrSequenceIDNotFound {
+	if err != nil && !errors.Is(err, chk.ErrSequenceIDNotFound) {
 		return nil, err
 	}
 
 	checkpoint := sc.shard.GetCheckpoint()
+	if subSequenceNumber := sc.shard.GetSubSequenceNumber(); checkpoint != "" && subSequenceNumber != nil {
+		sc.getLogger().Debugf("Start shard: %v at checkpoint: %v, sub-sequence: %v", sc.shard.ID, checkpoint, *subSequenceNumber)
+		// the aggregated record has to be read again to deliver the remaining user records
+		sc.resumeFrom = &kcl.ExtendedSequenceNumber{SequenceNumber: aws.String(checkpoint), SubSequenceNumber: *subSequenceNumber}
+		return &types.StartingPosition{
+			Type:           types.ShardIteratorTypeAtSequenceNumber,
+			SequenceNumber: &checkpoint,
+		}, nil
+	}
+
 	if checkpoint != "" {
-		sc.kclConfig.Logger.Debugf("Start shard: %v at checkpoint: %v", sc.shard.ID, checkpoint)
+		sc.getLogger().Debugf("Start shard: %v at checkpoint: %v", sc.shard.ID, checkpoint)
 		return &types.StartingPosition{
 			Type:           types.ShardIteratorTypeAfterSequenceNumber,
 			SequenceNumber: &checkpoint,
@@ -92,15 +346,24 @@ func (sc *commonShardConsumer) getStartingPosition() (*types.StartingPosition, e
 	}
 
 	shardIteratorType := config.InitalPositionInStreamToShardIteratorType(sc.kclConfig.InitialPositionInStream)
-	sc.kclConfig.Logger.Debugf("No checkpoint recorded for shard: %v, starting with: %v", sc.shard.ID, aws.ToString(shardIteratorType))
-	if sc.kclConfig.InitialPositionInStream == config.AT_TIMESTAMP {
+	sc.getLogger().Debugf("No checkpoint recorded for shard: %v, starting with: %v", sc.shard.ID, aws.ToString(shardIteratorType))
+	switch sc.kclConfig.InitialPositionInStream {
+	case config.AT_TIMESTAMP:
 		return &types.StartingPosition{
 			Type:      types.ShardIteratorTypeAtTimestamp,
 			Timestamp: sc.kclConfig.InitialPositionInStreamExtended.Timestamp,
 		}, nil
-	}
-
-	if *shardIteratorType == "TRIM_HORIZON" {
+	case config.AT_SEQUENCE_NUMBER:
+		if sequenceNumber, ok := sc.kclConfig.InitialPositionInStreamExtended.SequenceNumbers[sc.shard.ID]; ok {
+			return &types.StartingPosition{
+				Type:           types.ShardIteratorTypeAtSequenceNumber,
+				SequenceNumber: aws.String(sequenceNumber),
+			}, nil
+		}
+		return &types.StartingPosition{
+			Type: types.ShardIteratorTypeTrimHorizon,
+		}, nil
+	case config.TRIM_HORIZON:
 		return &types.StartingPosition{
 			Type: types.ShardIteratorTypeTrimHorizon,
 		}, nil
@@ -111,53 +374,119 @@ func (sc *commonShardConsumer) getStartingPosition() (*types.StartingPosition, e
 	}, nil
 }
 
-// Need to wait until the parent shard finished
-func (sc *commonShardConsumer) waitOnParentShard() error {
-	if len(sc.shard.ParentShardId) == 0 {
-		return nil
+// recordProcessorCheckpointer returns the checkpointer of the record processor validating checkpoints as configured
+func (sc *commonShardConsumer) recordProcessorCheckpointer() kcl.IRecordProcessorCheckpointer {
+	rc := newRecordProcessorCheckpointer(sc.shard, sc.checkpointer, sc.mService).(*RecordProcessorCheckpointer)
+	rc.allowRewind = sc.kclConfig.AllowCheckpointRewind
+	rc.validator = sc.kclConfig.CheckpointValidator
+	// the consumer stops with ZOMBIE as soon as a checkpoint tells that the lease has been lost
+	rc.onLeaseLost = func(err error) { sc.renewer.markLost(err) }
+	return rc
+}
+
+// initializationInput builds the input for the record processor from the checkpoint fetched for the shard
+func (sc *commonShardConsumer) initializationInput() *kcl.InitializationInput {
+	startingSequenceNumber, endingSequenceNumber := sc.shard.GetSequenceNumberRange()
+	input := &kcl.InitializationInput{
+		ShardId:                sc.shard.GetShardID(),
+		StreamName:             sc.shard.StreamName,
+		StreamARN:              sc.shard.StreamARN,
+		ExtendedSequenceNumber: &kcl.ExtendedSequenceNumber{SequenceNumber: aws.String(sc.shard.GetCheckpoint())},
+		StartingHashKey:        sc.shard.StartingHashKey,
+		EndingHashKey:          sc.shard.EndingHashKey,
+		StartingSequenceNumber: startingSequenceNumber,
+		EndingSequenceNumber:   endingSequenceNumber,
+		CheckpointMetadata:     sc.shard.GetCheckpointMetadata(),
 	}
 
-	pshard := &par.ShardStatus{
-		ID:  sc.shard.ParentShardId,
-		Mux: &sync.RWMutex{},
+	if subSequenceNumber := sc.shard.GetSubSequenceNumber(); subSequenceNumber != nil {
+		input.ExtendedSequenceNumber.SubSequenceNumber = *subSequenceNumber
 	}
 
-	for {
-		if err := sc.checkpointer.FetchCheckpoint(pshard); err != nil {
-			return err
-		}
+	if pendingCheckpoint := sc.shard.GetPendingCheckpoint(); pendingCheckpoint != "" {
+		input.PendingCheckpointSequenceNumber = &kcl.ExtendedSequenceNumber{SequenceNumber: aws.String(pendingCheckpoint)}
+	}
 
-		// Parent shard is finished.
-		if pshard.GetCheckpoint() == chk.ShardEnd {
-			return nil
+	return input
+}
+
+// Need to wait until the parent shard finished
+func (sc *commonShardConsumer) waitOnParentShard() error {
+	for _, parentID := range sc.shard.GetParentShardIds() {
+		pshard := &par.ShardStatus{
+			ID:  parentID,
+			Mux: &sync.RWMutex{},
 		}
 
-		time.Sleep(time.Duration(sc.kclConfig.ParentShardPollIntervalMillis) * time.Millisecond)
+		for {
+			if err := sc.checkpointer.FetchCheckpoint(pshard); err != nil {
+				return err
+			}
+
+			// Parent shard is finished.
+			if pshard.GetCheckpoint() == chk.ShardEnd {
+				break
+			}
+
+			time.Sleep(time.Duration(sc.kclConfig.ParentShardPollIntervalMillis) * time.Millisecond)
+		}
 	}
+
+	return nil
 }
 
-func (sc *commonShardConsumer) processRecords(getRecordsStartTime time.Time, records []types.Record, millisBehindLatest *int64, recordCheckpointer kcl.IRecordProcessorCheckpointer) error {
-	log := sc.kclConfig.Logger
+func (sc *commonShardConsumer) processRecords(getRecordsStartTime time.Time, records []types.Record, millisBehindLatest *int64, metadata *kcl.BatchMetadata, recordCheckpointer kcl.IRecordProcessorCheckpointer) error {
+	log := sc.getLogger()
 
 	getRecordsTime := time.Since(getRecordsStartTime).Milliseconds()
 	sc.mService.RecordGetRecordsTime(sc.shard.ID, float64(getRecordsTime))
 
 	log.Debugf("Received %d original records.", len(records))
 
-	// De-aggregate the records if they were published by the KPL.
-	dars, err := deagg.DeaggregateRecords(records)
+	userRecords := sc.toUserRecords(records)
+	userRecords = sc.skipCheckpointedSubRecords(userRecords)
+	userRecords, err := sc.transformRecords(sc.context(), userRecords, recordCheckpointer)
 	if err != nil {
-		// The error is caused by bad KPL publisher and just skip the bad records
-		// instead of being stuck here.
-		log.Errorf("Error in de-aggregating KPL records: %+v", err)
+		return err
 	}
 
-	input := &kcl.ProcessRecordsInput{
-		Records:            dars,
-		MillisBehindLatest: *millisBehindLatest,
-		Checkpointer:       recordCheckpointer,
+	dars := make([]types.Record, len(userRecords))
+	for i := range userRecords {
+		dars[i] = userRecords[i].Record
 	}
 
+	ctx, span := sc.startSpan(sc.context(), "ProcessRecords")
+	span.SetAttributes(attribute.Int("kinesis.record_count", len(dars)))
+	defer span.End()
+
+	// checkpoints of this batch are traced as children of the span
+	if rc, ok := recordCheckpointer.(*RecordProcessorCheckpointer); ok {
+		recordCheckpointer = rc.withContext(ctx, sc.getTracer())
+	}
+
+	inputV2 := &kcl.ProcessRecordsInputV2{
+		ProcessRecordsInput: kcl.ProcessRecordsInput{
+			Context:            ctx,
+			Records:            dars,
+			UserRecords:        userRecords,
+			MillisBehindLatest: *millisBehindLatest,
+			Checkpointer:       recordCheckpointer,
+			BatchMetadata:      metadata,
+		},
+		ShardId:    sc.shard.GetShardID(),
+		StreamName: sc.shard.StreamName,
+		StreamARN:  sc.shard.StreamARN,
+		CatchUp:    *millisBehindLatest >= int64(sc.idleTimeBetweenReadsInMillis()),
+	}
+
+	if checkpoint := sc.shard.GetCheckpoint(); checkpoint != "" {
+		inputV2.LastCheckpoint = &kcl.ExtendedSequenceNumber{SequenceNumber: aws.String(checkpoint)}
+		if subSequenceNumber := sc.shard.GetSubSequenceNumber(); subSequenceNumber != nil {
+			inputV2.LastCheckpoint.SubSequenceNumber = *subSequenceNumber
+		}
+	}
+	input := inputV2.Input()
+
 	recordLength := len(input.Records)
 	recordBytes := int64(0)
 	log.Debugf("Received %d de-aggregated records, MillisBehindLatest: %v", recordLength, input.MillisBehindLatest)
@@ -166,23 +495,303 @@ func (sc *commonShardConsumer) processRecords(getRecordsStartTime time.Time, rec
 		recordBytes += int64(len(r.Data))
 	}
 
+	sc.limiter.acquire(recordLength, recordBytes)
+	defer sc.limiter.release(recordBytes)
+
 	if recordLength > 0 || sc.kclConfig.CallProcessRecordsEvenForEmptyRecordList {
 		processRecordsStartTime := time.Now()
 
 		// Delivery the events to the record processor
 		input.CacheEntryTime = &getRecordsStartTime
 		input.CacheExitTime = &processRecordsStartTime
-		err := sc.recordProcessor.ProcessRecords(input)
+		err := sc.processRecordsWithDeadLetter(ctx, input)
 		if err != nil {
-			return err
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			if sc.kclConfig.ProcessRecordsErrorPolicy != config.SkipOnError || ctx.Err() != nil {
+				return err
+			}
+			log.Errorf("Skipping %d records of shard %s after error: %+v", recordLength, sc.shard.ID, err)
 		}
 
 		processedRecordsTiming := time.Since(processRecordsStartTime).Milliseconds()
 		sc.mService.RecordProcessRecordsTime(sc.shard.ID, float64(processedRecordsTiming))
+
+		// the skipped records are not checkpointed automatically
+		if auto := sc.autoCheckpoints(); auto != nil && err == nil {
+			auto.processed(input.UserRecords)
+			if err := auto.checkpoint(input.Checkpointer, sc.shard, false); err != nil {
+				log.Errorf("Failed to checkpoint the processed records of shard %s. Error: %+v", sc.shard.ID, err)
+			}
+		}
+	} else if auto := sc.autoCheckpoints(); auto != nil {
+		// the records processed before the shard went idle are checkpointed once the interval has passed
+		if err := auto.checkpoint(input.Checkpointer, sc.shard, false); err != nil {
+			log.Errorf("Failed to checkpoint the processed records of shard %s. Error: %+v", sc.shard.ID, err)
+		}
 	}
 
 	sc.mService.IncrRecordsProcessed(sc.shard.ID, recordLength)
 	sc.mService.IncrBytesProcessed(sc.shard.ID, recordBytes)
 	sc.mService.MillisBehindLatest(sc.shard.ID, float64(*millisBehindLatest))
+	sc.status.addProcessed(recordLength, recordBytes)
+	// the records of a stream with steady writes keep arriving within the idle time between reads
+	sc.status.setMillisBehindLatest(*millisBehindLatest, int64(sc.idleTimeBetweenReadsInMillis()))
+	if len(records) == 0 && *millisBehindLatest == 0 {
+		sc.status.setCaughtUp()
+	}
+	sc.lag.update(sc.shard.ID, *millisBehindLatest)
+	return nil
+}
+
+// processRecordsWithDeadLetter delivers the records to the record processor, retrying a failed batch. Once the
+// retries are exhausted the failed records are published to the dead-letter queue and checkpointed, so that the
+// shard consumer can make progress.
+func (sc *commonShardConsumer) processRecordsWithDeadLetter(ctx context.Context, input *kcl.ProcessRecordsInput) error {
+	for {
+		err := sc.processRecordsWithRetries(ctx, input)
+		if err == nil || sc.kclConfig.DeadLetterPublisher == nil || len(input.UserRecords) == 0 {
+			return err
+		}
+
+		failed, remaining := splitPoisonRecord(input.UserRecords, err)
+		sc.getLogger().Errorf("Publishing %d records to the dead-letter queue after error: %+v", len(failed), err)
+		if dlqErr := sc.kclConfig.DeadLetterPublisher.Publish(ctx, &deadletter.Input{
+			ShardID: sc.shard.GetShardID(),
+			Records: failed,
+			Err:     err,
+		}); dlqErr != nil {
+			return fmt.Errorf("failed to publish records to the dead-letter queue: %w", dlqErr)
+		}
+
+		err = checkpointRecord(input.Checkpointer, failed[len(failed)-1])
+		if err != nil || len(remaining) == 0 {
+			return err
+		}
+
+		// the records after the poison record are delivered again
+		next := *input
+		next.UserRecords = remaining
+		next.Records = make([]types.Record, len(remaining))
+		for i := range remaining {
+			next.Records[i] = remaining[i].Record
+		}
+		input = &next
+	}
+}
+
+// processRecordsWithRetries calls the record processor until it succeeds or MaxProcessRecordsRetries is reached.
+// The batch is retried until it succeeds with RetryOnError, unless the shard consumer is shut down or the lease
+// cannot be renewed.
+func (sc *commonShardConsumer) processRecordsWithRetries(ctx context.Context, input *kcl.ProcessRecordsInput) error {
+	for retry := 0; ; retry++ {
+		input.AttemptCount = sc.deliveryTracker().attempt(sc.shard.ID, input.UserRecords)
+		err := sc.processRecordsUnordered(ctx, input)
+		if err == nil {
+			sc.deliveryTracker().delivered(sc.shard.ID)
+			return nil
+		}
+		if skipped, err := sc.handleFailedDelivery(ctx, input, err); skipped {
+			return err
+		}
+		if sc.kclConfig.ProcessRecordsErrorPolicy == config.RetryOnError {
+			// the batch is retried as long as the lease is renewed
+			if sc.renewer.isLost() {
+				sc.getLogger().Warnf("Lost the lease of shard %s while retrying ProcessRecords", sc.shard.ID)
+				return err
+			}
+		} else if retry >= sc.kclConfig.MaxProcessRecordsRetries {
+			return err
+		}
+
+		sc.getLogger().Warnf("Retrying ProcessRecords after error: %+v", err)
+		if !sc.waitToRetry(ctx, retry) {
+			return err
+		}
+	}
+}
+
+// waitToRetry backs off exponentially before the retry of a failed batch. It returns false if ctx is done or the
+// lease is lost meanwhile.
+func (sc *commonShardConsumer) waitToRetry(ctx context.Context, retry int) bool {
+	backoff := time.Duration(math.Exp2(math.Min(float64(retry), 16))*100) * time.Millisecond
+	if backoff > maxProcessRecordsBackoff {
+		backoff = maxProcessRecordsBackoff
+	}
+	select {
+	case <-ctx.Done():
+		return false
+	case <-sc.renewer.lostSignal():
+		return false
+	case <-time.After(backoff):
+		return true
+	}
+}
+
+// handleFailedDelivery calls the ProcessingFailedHandler once the batch has been delivered MaxDeliveryAttempts
+// times. It returns true with the error of the checkpoint if the handler skipped the failed records.
+func (sc *commonShardConsumer) handleFailedDelivery(ctx context.Context, input *kcl.ProcessRecordsInput, err error) (bool, error) {
+	if sc.kclConfig.MaxDeliveryAttempts <= 0 || input.AttemptCount < sc.kclConfig.MaxDeliveryAttempts {
+		return false, nil
+	}
+
+	action := sc.kclConfig.ProcessingFailedHandler(ctx, &config.ProcessingFailedInput{
+		ShardID:      sc.shard.GetShardID(),
+		Records:      input.UserRecords,
+		AttemptCount: input.AttemptCount,
+		Err:          err,
+	})
+	if action != config.SkipFailedRecords {
+		return false, nil
+	}
+
+	sc.getLogger().Errorf("Skipping %d records of shard %s after %d attempts, error: %+v", len(input.UserRecords), sc.shard.ID, input.AttemptCount, err)
+	sc.deliveryTracker().delivered(sc.shard.ID)
+	if len(input.UserRecords) == 0 {
+		return true, nil
+	}
+	return true, checkpointRecord(input.Checkpointer, input.UserRecords[len(input.UserRecords)-1])
+}
+
+// checkpointRecord checkpoints the user record, including its sub-sequence number if it has been aggregated
+func checkpointRecord(checkpointer kcl.IRecordProcessorCheckpointer, record kcl.UserRecord) error {
+	if record.Aggregated {
+		return checkpointer.CheckpointWithSubSequence(record.SequenceNumber, record.SubSequenceNumber)
+	}
+	return checkpointer.Checkpoint(record.SequenceNumber)
+}
+
+// splitPoisonRecord returns the poison record identified by a PoisonRecordError and the records after it.
+// Any other error fails the whole batch.
+func splitPoisonRecord(records []kcl.UserRecord, err error) (failed, remaining []kcl.UserRecord) {
+	var poison *kcl.PoisonRecordError
+	if errors.As(err, &poison) {
+		for i, r := range records {
+			if aws.ToString(r.SequenceNumber) == poison.SequenceNumber && r.SubSequenceNumber == poison.SubSequenceNumber {
+				return records[i : i+1], records[i+1:]
+			}
+		}
+	}
+	return records, nil
+}
+
+// toUserRecords de-aggregates the records published by the KPL if de-aggregation is enabled, otherwise the
+// records are delivered as they are.
+func (sc *commonShardConsumer) toUserRecords(records []types.Record) []kcl.UserRecord {
+	userRecords := make([]kcl.UserRecord, 0, len(records))
+	for _, r := range records {
+		if !sc.kclConfig.EnableKPLDeaggregation {
+			userRecords = append(userRecords, kcl.UserRecord{Record: r})
+			continue
+		}
+
+		dars, err := deagg.DeaggregateRecords([]types.Record{r})
+		if err != nil {
+			// The error is caused by bad KPL publisher and just skip the bad record
+			// instead of being stuck here.
+			sc.getLogger().Errorf("Error in de-aggregating KPL record %s: %+v", aws.ToString(r.SequenceNumber), err)
+			continue
+		}
+
+		// records which are not aggregated or fail the checksum are returned as they are
+		aggregated := len(dars) != 1 || !bytes.Equal(dars[0].Data, r.Data)
+		for i, dar := range dars {
+			userRecords = append(userRecords, kcl.UserRecord{Record: dar, SubSequenceNumber: int64(i), Aggregated: aggregated})
+		}
+	}
+	return userRecords
+}
+
+// transformRecords applies the RecordTransformer and the RecordDecoder to the user records. The records which cannot
+// be transformed or decoded are published to the dead-letter queue and dropped from the batch, without a dead-letter
+// queue the batch fails. If all records are dropped, the last one is checkpointed as the record processor has no
+// record to checkpoint.
+func (sc *commonShardConsumer) transformRecords(ctx context.Context, records []kcl.UserRecord, checkpointer kcl.IRecordProcessorCheckpointer) ([]kcl.UserRecord, error) {
+	if sc.kclConfig.RecordTransformer == nil && sc.kclConfig.RecordDecoder == nil {
+		return records, nil
+	}
+
+	transformed := records[:0]
+	for _, r := range records {
+		err := sc.transformRecordWithRetries(ctx, &r)
+		if err == nil {
+			transformed = append(transformed, r)
+			continue
+		}
+
+		if sc.kclConfig.DeadLetterPublisher == nil {
+			return nil, err
+		}
+		sc.getLogger().Errorf("Publishing record to the dead-letter queue after error: %+v", err)
+		if dlqErr := sc.kclConfig.DeadLetterPublisher.Publish(ctx, &deadletter.Input{
+			ShardID: sc.shard.GetShardID(),
+			Records: []kcl.UserRecord{r},
+			Err:     err,
+		}); dlqErr != nil {
+			return nil, fmt.Errorf("failed to publish records to the dead-letter queue: %w", dlqErr)
+		}
+	}
+
+	if len(transformed) == 0 && len(records) > 0 {
+		return transformed, checkpointRecord(checkpointer, records[len(records)-1])
+	}
+	return transformed, nil
+}
+
+// transformRecordWithRetries transforms and decodes the user record, a RetryableError of the decoder is retried
+// after a back-off as long as the lease is renewed
+func (sc *commonShardConsumer) transformRecordWithRetries(ctx context.Context, r *kcl.UserRecord) error {
+	original := *r
+	for retry := 0; ; retry++ {
+		err := sc.transformRecord(ctx, r)
+		var retryable *decoder.RetryableError
+		if err == nil || !errors.As(err, &retryable) {
+			return err
+		}
+
+		sc.getLogger().Warnf("Retrying to decode record after error: %+v", err)
+		if !sc.waitToRetry(ctx, retry) {
+			return err
+		}
+		*r = original
+	}
+}
+
+// transformRecord transforms and decodes the user record
+func (sc *commonShardConsumer) transformRecord(ctx context.Context, r *kcl.UserRecord) error {
+	if sc.kclConfig.RecordTransformer != nil {
+		if err := sc.kclConfig.RecordTransformer.Transform(ctx, r); err != nil {
+			return fmt.Errorf("failed to transform record %s: %w", aws.ToString(r.SequenceNumber), err)
+		}
+	}
+
+	if sc.kclConfig.RecordDecoder != nil {
+		decoded, err := sc.kclConfig.RecordDecoder.Decode(ctx, r)
+		if err != nil {
+			return fmt.Errorf("failed to decode record %s: %w", aws.ToString(r.SequenceNumber), err)
+		}
+		r.Decoded = decoded
+	}
 	return nil
 }
+
+// skipCheckpointedSubRecords drops the user records of the aggregated record the consumer resumed from
+// which have been checkpointed by the previous record processor.
+func (sc *commonShardConsumer) skipCheckpointedSubRecords(records []kcl.UserRecord) []kcl.UserRecord {
+	if sc.resumeFrom == nil || len(records) == 0 {
+		return records
+	}
+
+	sequenceNumber := aws.ToString(sc.resumeFrom.SequenceNumber)
+	filtered := make([]kcl.UserRecord, 0, len(records))
+	for _, r := range records {
+		if aws.ToString(r.SequenceNumber) == sequenceNumber && r.SubSequenceNumber <= sc.resumeFrom.SubSequenceNumber {
+			continue
+		}
+		filtered = append(filtered, r)
+	}
+
+	// all user records of an aggregated record are delivered within the same batch
+	sc.resumeFrom = nil
+	return filtered
+}
@@ -0,0 +1,725 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	rec "github.com/awslabs/kinesis-aggregation/go/v2/records"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/deadletter"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/decoder"
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/metrics"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/transformer"
+)
+
+func TestSkipCheckpointedSubRecords(t *testing.T) {
+	records := []kcl.UserRecord{
+		{Record: types.Record{SequenceNumber: aws.String("100"), Data: []byte("a")}, SubSequenceNumber: 0},
+		{Record: types.Record{SequenceNumber: aws.String("100"), Data: []byte("b")}, SubSequenceNumber: 1},
+		{Record: types.Record{SequenceNumber: aws.String("100"), Data: []byte("c")}, SubSequenceNumber: 2},
+		{Record: types.Record{SequenceNumber: aws.String("101"), Data: []byte("d")}},
+	}
+
+	sc := &commonShardConsumer{}
+	assert.Equal(t, records, sc.skipCheckpointedSubRecords(records))
+
+	sc.resumeFrom = &kcl.ExtendedSequenceNumber{SequenceNumber: aws.String("100"), SubSequenceNumber: 1}
+	filtered := sc.skipCheckpointedSubRecords(records)
+	assert.Equal(t, 2, len(filtered))
+	assert.Equal(t, []byte("c"), filtered[0].Data)
+	assert.Equal(t, []byte("d"), filtered[1].Data)
+	assert.Nil(t, sc.resumeFrom)
+
+	// only the first batch after restart is filtered
+	assert.Equal(t, records, sc.skipCheckpointedSubRecords(records))
+}
+
+func TestToUserRecords(t *testing.T) {
+	records := []types.Record{
+		{SequenceNumber: aws.String("100"), PartitionKey: aws.String("pk"), Data: generateAggregateRecord(3)},
+		{SequenceNumber: aws.String("101"), PartitionKey: aws.String("pk"), Data: []byte("d")},
+	}
+
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker")
+	sc := &commonShardConsumer{kclConfig: kclConfig}
+
+	// the records are de-aggregated by default
+	userRecords := sc.toUserRecords(records)
+	assert.Equal(t, 4, len(userRecords))
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, "100", aws.ToString(userRecords[i].SequenceNumber))
+		assert.Equal(t, int64(i), userRecords[i].SubSequenceNumber)
+		assert.True(t, userRecords[i].Aggregated)
+		assert.Equal(t, []byte(fmt.Sprintf("record-%d", i)), userRecords[i].Data)
+	}
+	assert.Equal(t, kcl.UserRecord{Record: records[1]}, userRecords[3])
+
+	kclConfig.WithKPLDeaggregation(false)
+	userRecords = sc.toUserRecords(records)
+	assert.Equal(t, 2, len(userRecords))
+	assert.False(t, userRecords[0].Aggregated)
+}
+
+// generateAggregateRecord generates an aggregate record in the format used by KPL.
+// https://github.com/awslabs/amazon-kinesis-producer/blob/master/aggregation-format.md
+func generateAggregateRecord(numRecords int) []byte {
+	aggr := &rec.AggregatedRecord{PartitionKeyTable: []string{"pk"}}
+	for i := 0; i < numRecords; i++ {
+		aggr.Records = append(aggr.Records, &rec.Record{
+			PartitionKeyIndex: proto.Uint64(0),
+			Data:              []byte(fmt.Sprintf("record-%d", i)),
+		})
+	}
+
+	data, _ := proto.Marshal(aggr)
+	md5Hash := md5.Sum(data)
+	aggRecord := append([]byte("\xf3\x89\x9a\xc2"), data...)
+	return append(aggRecord, md5Hash[:]...)
+}
+
+type shutdownRecorder struct {
+	reasons     []kcl.ShutdownReason
+	childShards [][]kcl.ChildShard
+}
+
+func (r *shutdownRecorder) Initialize(*kcl.InitializationInput) {}
+
+func (r *shutdownRecorder) ProcessRecords(*kcl.ProcessRecordsInput) error { return nil }
+
+func (r *shutdownRecorder) Shutdown(input *kcl.ShutdownInput) {
+	r.reasons = append(r.reasons, input.ShutdownReason)
+	r.childShards = append(r.childShards, input.ChildShards)
+}
+
+func TestShutdownRecordProcessorOnce(t *testing.T) {
+	recorder := &shutdownRecorder{}
+	shardEnd := newShardEndNotifier()
+	sc := &commonShardConsumer{
+		shard:           &par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}},
+		recordProcessor: kcl.NewRecordProcessorAdapter(recorder),
+		kclConfig:       config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker"),
+		shardEnd:        shardEnd,
+	}
+
+	sc.shutdownRecordProcessor(kcl.TERMINATE, nil)
+	// the deferred shutdown after the shard end has been reached is a no-op
+	sc.shutdownRecordProcessor(kcl.ZOMBIE, nil)
+
+	assert.Equal(t, []kcl.ShutdownReason{kcl.TERMINATE}, recorder.reasons)
+
+	// reaching the shard end wakes up the worker
+	assert.Equal(t, 1, len(shardEnd.signal))
+	assert.Equal(t, 1, len(shardEnd.take()))
+}
+
+func TestShutdownRecordProcessorChildShards(t *testing.T) {
+	recorder := &shutdownRecorder{}
+	sc := &commonShardConsumer{
+		shard:           &par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}},
+		recordProcessor: kcl.NewRecordProcessorAdapter(recorder),
+		kclConfig:       config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker"),
+		childShards: []types.ChildShard{
+			{
+				ShardId:      aws.String("0002"),
+				ParentShards: []string{"0001"},
+				HashKeyRange: &types.HashKeyRange{StartingHashKey: aws.String("0"), EndingHashKey: aws.String("99")},
+			},
+			{
+				ShardId:      aws.String("0003"),
+				ParentShards: []string{"0001"},
+				HashKeyRange: &types.HashKeyRange{StartingHashKey: aws.String("100"), EndingHashKey: aws.String("199")},
+			},
+		},
+	}
+
+	sc.shutdownRecordProcessor(kcl.TERMINATE, nil)
+	assert.Equal(t, [][]kcl.ChildShard{{
+		{ShardId: "0002", ParentShards: []string{"0001"}, StartingHashKey: "0", EndingHashKey: "99"},
+		{ShardId: "0003", ParentShards: []string{"0001"}, StartingHashKey: "100", EndingHashKey: "199"},
+	}}, recorder.childShards)
+
+	// the child shards are only delivered with the end of the shard
+	sc.isShutdown = false
+	sc.shutdownRecordProcessor(kcl.ZOMBIE, nil)
+	assert.Nil(t, recorder.childShards[1])
+}
+
+// tracingProcessor starts its own span from the context of the batch and checkpoints the last record
+type tracingProcessor struct {
+	tracerProvider *sdktrace.TracerProvider
+}
+
+func (p *tracingProcessor) Initialize(context.Context, *kcl.InitializationInput) {}
+
+func (p *tracingProcessor) ProcessRecords(ctx context.Context, input *kcl.ProcessRecordsInput) error {
+	_, span := p.tracerProvider.Tracer("app").Start(ctx, "handle")
+	defer span.End()
+	return input.Checkpointer.Checkpoint(input.Records[len(input.Records)-1].SequenceNumber)
+}
+
+func (p *tracingProcessor) Shutdown(context.Context, *kcl.ShutdownInput) {}
+
+func TestProcessRecordsSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	checkpointer := newMockCheckpointer()
+	shard := &par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}, LeaseTimeout: time.Now().Add(time.Minute)}
+	assert.Nil(t, checkpointer.GetLease(shard, "worker"))
+
+	sc := &commonShardConsumer{
+		shard:           shard,
+		checkpointer:    checkpointer,
+		recordProcessor: &tracingProcessor{tracerProvider: tracerProvider},
+		kclConfig:       config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker"),
+		mService:        metrics.NoopMonitoringService{},
+		tracer:          tracerProvider.Tracer(tracerName),
+	}
+
+	records := []types.Record{{SequenceNumber: aws.String("100"), Data: []byte("a")}}
+	rc := newRecordProcessorCheckpointer(shard, checkpointer, sc.mService)
+	assert.Nil(t, sc.processRecords(time.Now(), records, aws.Int64(0), nil, rc))
+	assert.Equal(t, "100", checkpointer.checkpoints["0001"])
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	assert.Equal(t, 3, len(spans))
+
+	// the spans of the record processor and the checkpoint are children of the ProcessRecords span
+	parent := spans["ProcessRecords"].SpanContext().SpanID()
+	assert.Equal(t, parent, spans["handle"].Parent().SpanID())
+	assert.Equal(t, parent, spans["Checkpoint"].Parent().SpanID())
+}
+
+// contextRecorder records the error of the context passed to Shutdown
+type contextRecorder struct {
+	tracingProcessor
+	shutdownErr error
+}
+
+func (p *contextRecorder) Shutdown(ctx context.Context, _ *kcl.ShutdownInput) {
+	p.shutdownErr = ctx.Err()
+}
+
+func TestShutdownContextOutlivesWorker(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	recorder := &contextRecorder{}
+	sc := &commonShardConsumer{
+		shard:           &par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}},
+		recordProcessor: recorder,
+		kclConfig:       config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker"),
+		ctx:             ctx,
+	}
+
+	// the worker context is canceled once the worker shuts down
+	cancel()
+	assert.NotNil(t, sc.context().Err())
+
+	sc.shutdownRecordProcessor(kcl.REQUESTED, nil)
+	assert.Nil(t, recorder.shutdownErr)
+}
+
+func TestGetStartingPositionAtSequenceNumber(t *testing.T) {
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+		WithSequenceNumberAtInitialPositionInStream(map[string]string{"0001": "100"})
+	checkpointer := newMockCheckpointer()
+
+	sc := &commonShardConsumer{
+		shard:        &par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}},
+		checkpointer: checkpointer,
+		kclConfig:    kclConfig,
+	}
+	startPosition, err := sc.getStartingPosition()
+	assert.Nil(t, err)
+	assert.Equal(t, types.ShardIteratorTypeAtSequenceNumber, startPosition.Type)
+	assert.Equal(t, "100", aws.ToString(startPosition.SequenceNumber))
+
+	// shards without sequence number start from the oldest record
+	sc.shard = &par.ShardStatus{ID: "0002", Mux: &sync.RWMutex{}}
+	startPosition, err = sc.getStartingPosition()
+	assert.Nil(t, err)
+	assert.Equal(t, types.ShardIteratorTypeTrimHorizon, startPosition.Type)
+
+	// the checkpoint takes precedence over the initial position
+	checkpointer.checkpoints["0001"] = "200"
+	sc.shard = &par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}}
+	startPosition, err = sc.getStartingPosition()
+	assert.Nil(t, err)
+	assert.Equal(t, types.ShardIteratorTypeAfterSequenceNumber, startPosition.Type)
+	assert.Equal(t, "200", aws.ToString(startPosition.SequenceNumber))
+}
+
+// poisonProcessor fails on the record with the poison sequence number and checkpoints the other batches
+type poisonProcessor struct {
+	poison string
+	err    error
+	calls  int
+}
+
+func (p *poisonProcessor) Initialize(*kcl.InitializationInput) {}
+
+func (p *poisonProcessor) ProcessRecords(input *kcl.ProcessRecordsInput) error {
+	p.calls++
+	if p.err != nil {
+		return p.err
+	}
+	for _, r := range input.UserRecords {
+		if aws.ToString(r.SequenceNumber) == p.poison {
+			return &kcl.PoisonRecordError{SequenceNumber: p.poison, Err: errors.New("bad record")}
+		}
+	}
+	return input.Checkpointer.Checkpoint(input.Records[len(input.Records)-1].SequenceNumber)
+}
+
+func (p *poisonProcessor) Shutdown(*kcl.ShutdownInput) {}
+
+func TestProcessRecordsDeadLetter(t *testing.T) {
+	records := []types.Record{
+		{SequenceNumber: aws.String("100"), Data: []byte("a")},
+		{SequenceNumber: aws.String("101"), Data: []byte("b")},
+		{SequenceNumber: aws.String("102"), Data: []byte("c")},
+	}
+
+	newConsumer := func(processor *poisonProcessor, publisher deadletter.Publisher) (*commonShardConsumer, *mockCheckpointer) {
+		checkpointer := newMockCheckpointer()
+		shard := &par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}, LeaseTimeout: time.Now().Add(time.Minute)}
+		assert.Nil(t, checkpointer.GetLease(shard, "worker"))
+
+		kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").WithMaxProcessRecordsRetries(1)
+		if publisher != nil {
+			kclConfig.WithDeadLetterPublisher(publisher)
+		}
+		return &commonShardConsumer{
+			shard:           shard,
+			checkpointer:    checkpointer,
+			recordProcessor: kcl.NewRecordProcessorAdapter(processor),
+			kclConfig:       kclConfig,
+			mService:        metrics.NoopMonitoringService{},
+		}, checkpointer
+	}
+
+	var published []*deadletter.Input
+	publisher := deadletter.PublisherFunc(func(_ context.Context, input *deadletter.Input) error {
+		published = append(published, input)
+		return nil
+	})
+
+	// only the poison record is published, the records after it are delivered again
+	processor := &poisonProcessor{poison: "101"}
+	sc, checkpointer := newConsumer(processor, publisher)
+	rc := newRecordProcessorCheckpointer(sc.shard, checkpointer, sc.mService)
+	assert.Nil(t, sc.processRecords(time.Now(), records, aws.Int64(0), nil, rc))
+	assert.Equal(t, 3, processor.calls)
+	assert.Equal(t, 1, len(published))
+	assert.Equal(t, "0001", published[0].ShardID)
+	assert.Equal(t, []kcl.UserRecord{{Record: records[1]}}, published[0].Records)
+	assert.Equal(t, "102", checkpointer.checkpoints["0001"])
+
+	// any other error fails the whole batch
+	published = nil
+	processor = &poisonProcessor{err: errors.New("failed")}
+	sc, checkpointer = newConsumer(processor, publisher)
+	rc = newRecordProcessorCheckpointer(sc.shard, checkpointer, sc.mService)
+	assert.Nil(t, sc.processRecords(time.Now(), records, aws.Int64(0), nil, rc))
+	assert.Equal(t, 2, processor.calls)
+	assert.Equal(t, 3, len(published[0].Records))
+	assert.Equal(t, processor.err, published[0].Err)
+	assert.Equal(t, "102", checkpointer.checkpoints["0001"])
+
+	// without a dead-letter queue the error is returned once the retries are exhausted
+	processor = &poisonProcessor{err: errors.New("failed")}
+	sc, checkpointer = newConsumer(processor, nil)
+	rc = newRecordProcessorCheckpointer(sc.shard, checkpointer, sc.mService)
+	assert.Equal(t, processor.err, sc.processRecords(time.Now(), records, aws.Int64(0), nil, rc))
+	assert.Equal(t, 2, processor.calls)
+	assert.Equal(t, "", checkpointer.checkpoints["0001"])
+}
+
+// flakyProcessor fails the first batches with an error
+type flakyProcessor struct {
+	failures int
+	calls    int
+}
+
+func (p *flakyProcessor) Initialize(*kcl.InitializationInput) {}
+
+func (p *flakyProcessor) ProcessRecords(input *kcl.ProcessRecordsInput) error {
+	if p.calls++; p.calls <= p.failures {
+		return errors.New("failed")
+	}
+	return input.Checkpointer.Checkpoint(input.Records[len(input.Records)-1].SequenceNumber)
+}
+
+func (p *flakyProcessor) Shutdown(*kcl.ShutdownInput) {}
+
+func TestProcessRecordsErrorPolicy(t *testing.T) {
+	records := []types.Record{{SequenceNumber: aws.String("100"), Data: []byte("a")}}
+	newConsumer := func(processor kcl.IRecordProcessor, policy config.ProcessRecordsErrorPolicy) (*commonShardConsumer, *mockCheckpointer) {
+		checkpointer := newMockCheckpointer()
+		shard := &par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}, LeaseTimeout: time.Now().Add(time.Minute)}
+		assert.Nil(t, checkpointer.GetLease(shard, "worker"))
+		return &commonShardConsumer{
+			shard:           shard,
+			checkpointer:    checkpointer,
+			recordProcessor: kcl.NewRecordProcessorAdapter(processor),
+			kclConfig:       config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").WithProcessRecordsErrorPolicy(policy),
+			mService:        metrics.NoopMonitoringService{},
+		}, checkpointer
+	}
+
+	// the shard consumer stops by default
+	processor := &flakyProcessor{failures: 1}
+	sc, checkpointer := newConsumer(processor, config.HaltOnError)
+	rc := newRecordProcessorCheckpointer(sc.shard, checkpointer, sc.mService)
+	assert.NotNil(t, sc.processRecords(time.Now(), records, aws.Int64(0), nil, rc))
+	assert.Equal(t, 1, processor.calls)
+
+	// the failed batch is skipped without being checkpointed
+	processor = &flakyProcessor{failures: 1}
+	sc, checkpointer = newConsumer(processor, config.SkipOnError)
+	rc = newRecordProcessorCheckpointer(sc.shard, checkpointer, sc.mService)
+	assert.Nil(t, sc.processRecords(time.Now(), records, aws.Int64(0), nil, rc))
+	assert.Equal(t, 1, processor.calls)
+	assert.Equal(t, "", checkpointer.checkpoints["0001"])
+
+	// the same batch is delivered again until it succeeds
+	processor = &flakyProcessor{failures: 2}
+	sc, checkpointer = newConsumer(processor, config.RetryOnError)
+	rc = newRecordProcessorCheckpointer(sc.shard, checkpointer, sc.mService)
+	assert.Nil(t, sc.processRecords(time.Now(), records, aws.Int64(0), nil, rc))
+	assert.Equal(t, 3, processor.calls)
+	assert.Equal(t, "100", checkpointer.checkpoints["0001"])
+}
+
+func TestProcessRecordsAutoCheckpoint(t *testing.T) {
+	records := func(sequenceNumbers ...string) []types.Record {
+		batch := make([]types.Record, 0, len(sequenceNumbers))
+		for _, sequenceNumber := range sequenceNumbers {
+			batch = append(batch, types.Record{SequenceNumber: aws.String(sequenceNumber), Data: []byte("a")})
+		}
+		return batch
+	}
+	newConsumer := func(processor kcl.IRecordProcessorV2, kclConfig *config.KinesisClientLibConfiguration) (*commonShardConsumer, *mockCheckpointer, kcl.IRecordProcessorCheckpointer) {
+		checkpointer := newMockCheckpointer()
+		shard := &par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}, LeaseTimeout: time.Now().Add(time.Minute)}
+		assert.Nil(t, checkpointer.GetLease(shard, "worker"))
+		sc := &commonShardConsumer{
+			shard:           shard,
+			checkpointer:    checkpointer,
+			recordProcessor: kcl.NewRecordProcessorV2Adapter(processor),
+			kclConfig:       kclConfig,
+			mService:        metrics.NoopMonitoringService{},
+		}
+		return sc, checkpointer, newRecordProcessorCheckpointer(shard, checkpointer, sc.mService)
+	}
+	kclConfig := func() *config.KinesisClientLibConfiguration {
+		return config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker")
+	}
+
+	// every batch is checkpointed without thresholds
+	sc, checkpointer, rc := newConsumer(&inputV2Recorder{}, kclConfig().WithAutoCheckpoint(0, 0))
+	assert.Nil(t, sc.processRecords(time.Now(), records("100", "101"), aws.Int64(0), nil, rc))
+	assert.Equal(t, "101", checkpointer.checkpoints["0001"])
+
+	// the batches are checkpointed once the record count is reached
+	sc, checkpointer, rc = newConsumer(&inputV2Recorder{}, kclConfig().WithAutoCheckpoint(60000, 3))
+	assert.Nil(t, sc.processRecords(time.Now(), records("100"), aws.Int64(0), nil, rc))
+	assert.Equal(t, "", checkpointer.checkpoints["0001"])
+	assert.Nil(t, sc.processRecords(time.Now(), records("101", "102"), aws.Int64(0), nil, rc))
+	assert.Equal(t, "102", checkpointer.checkpoints["0001"])
+
+	// the records processed since are checkpointed before the record processor is shut down
+	assert.Nil(t, sc.processRecords(time.Now(), records("103"), aws.Int64(0), nil, rc))
+	assert.Equal(t, "102", checkpointer.checkpoints["0001"])
+	sc.shutdownRecordProcessor(kcl.REQUESTED, rc)
+	assert.Equal(t, "103", checkpointer.checkpoints["0001"])
+
+	// the records processed before the shard went idle are checkpointed by an empty poll once the interval has passed
+	sc, checkpointer, rc = newConsumer(&inputV2Recorder{}, kclConfig().WithAutoCheckpoint(50, 0))
+	assert.Nil(t, sc.processRecords(time.Now(), records("100"), aws.Int64(0), nil, rc))
+	assert.Nil(t, sc.processRecords(time.Now(), nil, aws.Int64(0), nil, rc))
+	assert.Equal(t, "", checkpointer.checkpoints["0001"])
+	time.Sleep(60 * time.Millisecond)
+	assert.Nil(t, sc.processRecords(time.Now(), nil, aws.Int64(0), nil, rc))
+	assert.Equal(t, "100", checkpointer.checkpoints["0001"])
+
+	// the failed batches are not checkpointed
+	sc, checkpointer, rc = newConsumer(&inputV2Recorder{}, kclConfig().WithAutoCheckpoint(0, 0).WithProcessRecordsErrorPolicy(config.SkipOnError))
+	sc.recordProcessor = kcl.NewRecordProcessorAdapter(&flakyProcessor{failures: 1})
+	assert.Nil(t, sc.processRecords(time.Now(), records("100"), aws.Int64(0), nil, rc))
+	assert.Equal(t, "", checkpointer.checkpoints["0001"])
+
+	// nothing is checkpointed without AutoCheckpoint
+	sc, checkpointer, rc = newConsumer(&inputV2Recorder{}, kclConfig())
+	assert.Nil(t, sc.processRecords(time.Now(), records("100"), aws.Int64(0), nil, rc))
+	sc.shutdownRecordProcessor(kcl.REQUESTED, rc)
+	assert.Equal(t, "", checkpointer.checkpoints["0001"])
+}
+
+type inputV2Recorder struct {
+	inputs []*kcl.ProcessRecordsInputV2
+}
+
+func (p *inputV2Recorder) Initialize(ctx context.Context, input *kcl.InitializationInput) {}
+
+func (p *inputV2Recorder) ProcessRecords(ctx context.Context, input *kcl.ProcessRecordsInputV2) error {
+	p.inputs = append(p.inputs, input)
+	return nil
+}
+
+func (p *inputV2Recorder) Shutdown(ctx context.Context, input *kcl.ShutdownInput) {}
+
+func TestProcessRecordsInputV2(t *testing.T) {
+	checkpointer := newMockCheckpointer()
+	shard := &par.ShardStatus{
+		ID:                "0001",
+		StreamName:        "stream",
+		Checkpoint:        "99",
+		SubSequenceNumber: aws.Int64(2),
+		Mux:               &sync.RWMutex{},
+		LeaseTimeout:      time.Now().Add(time.Minute),
+	}
+	processor := &inputV2Recorder{}
+	sc := &commonShardConsumer{
+		shard:           shard,
+		checkpointer:    checkpointer,
+		recordProcessor: kcl.NewRecordProcessorV2Adapter(processor),
+		kclConfig:       config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker"),
+		mService:        metrics.NoopMonitoringService{},
+	}
+	rc := newRecordProcessorCheckpointer(sc.shard, checkpointer, sc.mService)
+
+	records := []types.Record{{SequenceNumber: aws.String("100"), Data: []byte("a")}}
+	metadata := &kcl.BatchMetadata{Calls: 1, RecordCount: 1, BytesRead: 1, RetrievalLatency: time.Millisecond}
+	assert.Nil(t, sc.processRecords(time.Now(), records, aws.Int64(60000), metadata, rc))
+	assert.Nil(t, sc.processRecords(time.Now(), records, aws.Int64(0), nil, rc))
+
+	assert.Equal(t, 2, len(processor.inputs))
+	input := processor.inputs[0]
+	assert.Equal(t, "0001", input.ShardId)
+	assert.Equal(t, "stream", input.StreamName)
+	assert.Equal(t, &kcl.ExtendedSequenceNumber{SequenceNumber: aws.String("99"), SubSequenceNumber: 2}, input.LastCheckpoint)
+	assert.True(t, input.CatchUp)
+	assert.Equal(t, int64(60000), input.MillisBehindLatest)
+	assert.Equal(t, 1, len(input.Records))
+	assert.NotNil(t, input.CacheEntryTime)
+	assert.NotNil(t, input.CacheExitTime)
+	assert.Equal(t, metadata, input.BatchMetadata)
+	assert.False(t, processor.inputs[1].CatchUp)
+
+	// the metadata is empty for inputs which have not been created by the KCL
+	assert.Equal(t, "", (&kcl.ProcessRecordsInput{}).V2().ShardId)
+}
+
+func TestRecordTransformer(t *testing.T) {
+	upper := transformer.Func(func(_ context.Context, data []byte) ([]byte, error) {
+		if string(data) == "bad" {
+			return nil, errors.New("cannot transform")
+		}
+		return []byte(strings.ToUpper(string(data))), nil
+	})
+	records := []types.Record{
+		{SequenceNumber: aws.String("100"), Data: []byte("a")},
+		{SequenceNumber: aws.String("101"), Data: []byte("bad")},
+		{SequenceNumber: aws.String("102"), Data: []byte("c")},
+	}
+	newConsumer := func(kclConfig *config.KinesisClientLibConfiguration) (*commonShardConsumer, *inputV2Recorder, kcl.IRecordProcessorCheckpointer) {
+		checkpointer := newMockCheckpointer()
+		shard := &par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}, LeaseTimeout: time.Now().Add(time.Minute)}
+		_ = checkpointer.GetLease(shard, "worker")
+		processor := &inputV2Recorder{}
+		sc := &commonShardConsumer{
+			shard:           shard,
+			checkpointer:    checkpointer,
+			recordProcessor: kcl.NewRecordProcessorV2Adapter(processor),
+			kclConfig:       kclConfig.WithRecordTransformer(upper),
+			mService:        metrics.NoopMonitoringService{},
+		}
+		return sc, processor, newRecordProcessorCheckpointer(shard, checkpointer, sc.mService)
+	}
+
+	// the batch fails without a dead-letter queue
+	sc, processor, rc := newConsumer(config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker"))
+	err := sc.processRecords(time.Now(), records, aws.Int64(0), nil, rc)
+	assert.ErrorContains(t, err, "failed to transform record 101: cannot transform")
+	assert.Equal(t, 0, len(processor.inputs))
+
+	// the record which cannot be transformed is published to the dead-letter queue
+	var published []*deadletter.Input
+	publisher := deadletter.PublisherFunc(func(_ context.Context, input *deadletter.Input) error {
+		published = append(published, input)
+		return nil
+	})
+	sc, processor, rc = newConsumer(config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").WithDeadLetterPublisher(publisher))
+	assert.Nil(t, sc.processRecords(time.Now(), records, aws.Int64(0), nil, rc))
+	assert.Equal(t, 1, len(published))
+	assert.Equal(t, "101", aws.ToString(published[0].Records[0].SequenceNumber))
+
+	assert.Equal(t, 1, len(processor.inputs))
+	input := processor.inputs[0]
+	assert.Equal(t, 2, len(input.Records))
+	assert.Equal(t, []byte("A"), input.Records[0].Data)
+	assert.Equal(t, []byte("C"), input.UserRecords[1].Data)
+
+	// a batch of records which all cannot be transformed is checkpointed
+	sc, processor, rc = newConsumer(config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").WithDeadLetterPublisher(publisher))
+	assert.Nil(t, sc.processRecords(time.Now(), records[1:2], aws.Int64(0), nil, rc))
+	assert.Equal(t, 0, len(processor.inputs))
+	assert.Equal(t, "101", sc.shard.GetCheckpoint())
+}
+
+func TestRecordDecoder(t *testing.T) {
+	records := []types.Record{
+		{SequenceNumber: aws.String("100"), Data: []byte(`{"id":"a"}`)},
+		{SequenceNumber: aws.String("101"), Data: []byte("not json")},
+	}
+	var published []*deadletter.Input
+	publisher := deadletter.PublisherFunc(func(_ context.Context, input *deadletter.Input) error {
+		published = append(published, input)
+		return nil
+	})
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+		WithRecordDecoder(decoder.JSON(func() interface{} { return &map[string]string{} })).
+		WithDeadLetterPublisher(publisher)
+
+	checkpointer := newMockCheckpointer()
+	shard := &par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}, LeaseTimeout: time.Now().Add(time.Minute)}
+	processor := &inputV2Recorder{}
+	sc := &commonShardConsumer{
+		shard:           shard,
+		checkpointer:    checkpointer,
+		recordProcessor: kcl.NewRecordProcessorV2Adapter(processor),
+		kclConfig:       kclConfig,
+		mService:        metrics.NoopMonitoringService{},
+	}
+	rc := newRecordProcessorCheckpointer(shard, checkpointer, sc.mService)
+
+	// the record which cannot be decoded is published to the dead-letter queue
+	assert.Nil(t, sc.processRecords(time.Now(), records, aws.Int64(0), nil, rc))
+	assert.Equal(t, 1, len(published))
+	assert.ErrorContains(t, published[0].Err, "failed to decode record 101")
+
+	assert.Equal(t, 1, len(processor.inputs))
+	input := processor.inputs[0]
+	assert.Equal(t, 1, len(input.UserRecords))
+	assert.Equal(t, &map[string]string{"id": "a"}, input.UserRecords[0].Decoded)
+
+	// a retryable error is decoded again instead of being published to the dead-letter queue
+	calls := 0
+	sc.kclConfig = config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+		WithRecordDecoder(decoder.Func(func(_ context.Context, data []byte) (interface{}, error) {
+			if calls++; calls < 3 {
+				return nil, decoder.Retryable(errors.New("registry unavailable"))
+			}
+			return string(data), nil
+		})).
+		WithDeadLetterPublisher(publisher)
+	assert.Nil(t, sc.processRecords(time.Now(), records[1:], aws.Int64(0), nil, rc))
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, 1, len(published))
+	assert.Equal(t, 2, len(processor.inputs))
+	assert.Equal(t, "not json", processor.inputs[1].UserRecords[0].Decoded)
+}
+
+// failingProcessor fails all batches and records their attempt counts
+type failingProcessor struct {
+	attempts []int
+}
+
+func (p *failingProcessor) Initialize(*kcl.InitializationInput) {}
+
+func (p *failingProcessor) ProcessRecords(input *kcl.ProcessRecordsInput) error {
+	p.attempts = append(p.attempts, input.AttemptCount)
+	return errors.New("failed")
+}
+
+func (p *failingProcessor) Shutdown(*kcl.ShutdownInput) {}
+
+func TestProcessRecordsMaxDeliveryAttempts(t *testing.T) {
+	records := []types.Record{
+		{SequenceNumber: aws.String("100"), Data: []byte("a")},
+		{SequenceNumber: aws.String("101"), Data: []byte("b")},
+	}
+
+	var failed []*config.ProcessingFailedInput
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+		WithMaxDeliveryAttempts(3, func(_ context.Context, input *config.ProcessingFailedInput) config.ProcessingFailedAction {
+			failed = append(failed, input)
+			return config.SkipFailedRecords
+		})
+	processor := &failingProcessor{}
+	checkpointer := newMockCheckpointer()
+	deliveries := newDeliveryTracker()
+
+	// the attempts are counted across the restarts of the shard consumer
+	newConsumer := func() (*commonShardConsumer, kcl.IRecordProcessorCheckpointer) {
+		shard := &par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}, LeaseTimeout: time.Now().Add(time.Minute)}
+		assert.Nil(t, checkpointer.GetLease(shard, "worker"))
+		sc := &commonShardConsumer{
+			shard:           shard,
+			checkpointer:    checkpointer,
+			recordProcessor: kcl.NewRecordProcessorAdapter(processor),
+			kclConfig:       kclConfig,
+			mService:        metrics.NoopMonitoringService{},
+			deliveries:      deliveries,
+		}
+		return sc, newRecordProcessorCheckpointer(sc.shard, checkpointer, sc.mService)
+	}
+
+	for i := 0; i < 2; i++ {
+		sc, rc := newConsumer()
+		assert.NotNil(t, sc.processRecords(time.Now(), records, aws.Int64(0), nil, rc))
+	}
+	assert.Empty(t, failed)
+
+	// the records are skipped by the handler on the third delivery
+	sc, rc := newConsumer()
+	assert.Nil(t, sc.processRecords(time.Now(), records, aws.Int64(0), nil, rc))
+	assert.Equal(t, []int{1, 2, 3}, processor.attempts)
+	assert.Equal(t, 1, len(failed))
+	assert.Equal(t, "0001", failed[0].ShardID)
+	assert.Equal(t, 3, failed[0].AttemptCount)
+	assert.Equal(t, 2, len(failed[0].Records))
+	assert.EqualError(t, failed[0].Err, "failed")
+	assert.Equal(t, "101", checkpointer.checkpoints["0001"])
+
+	// a batch starting at another record is counted from the first delivery
+	assert.NotNil(t, sc.processRecords(time.Now(), records[1:], aws.Int64(0), nil, rc))
+	assert.Equal(t, []int{1, 2, 3, 1}, processor.attempts)
+}
@@ -0,0 +1,179 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package worker
+package worker
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	"github.com/vmware/vmware-go-kcl-v2/logger"
+)
+
+type (
+	// ConfigUpdate holds the settings changed by Worker.UpdateConfig while the worker is running, nil fields keep
+	// their current values
+	ConfigUpdate struct {
+		// IdleTimeBetweenReadsInMillis is the max delay between the GetRecords calls of the default polling strategy
+		IdleTimeBetweenReadsInMillis *int
+
+		// MaxRecords is the max number of records returned by a GetRecords call
+		MaxRecords *int
+
+		// MaxLeasesForWorker is the max number of leases held by the worker
+		MaxLeasesForWorker *int
+
+		// LogLevel is the level of the logger of the configuration, which has to implement the optional
+		// logger.LevelSetter interface
+		LogLevel *string
+	}
+
+	// reloadableConfig keeps the settings which can be changed while the worker is running. The configuration of
+	// the worker keeps the initial values.
+	reloadableConfig struct {
+		mux                          sync.RWMutex
+		idleTimeBetweenReadsInMillis int
+		maxRecords                   int
+		maxLeasesForWorker           int
+	}
+)
+
+func newReloadableConfig(kclConfig *config.KinesisClientLibConfiguration) *reloadableConfig {
+	return &reloadableConfig{
+		idleTimeBetweenReadsInMillis: kclConfig.IdleTimeBetweenReadsInMillis,
+		maxRecords:                   kclConfig.MaxRecords,
+		maxLeasesForWorker:           kclConfig.MaxLeasesForWorker,
+	}
+}
+
+func (c *reloadableConfig) getIdleTimeBetweenReadsInMillis() int {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	return c.idleTimeBetweenReadsInMillis
+}
+
+func (c *reloadableConfig) getMaxRecords() int {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	return c.maxRecords
+}
+
+func (c *reloadableConfig) getMaxLeasesForWorker() int {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	return c.maxLeasesForWorker
+}
+
+// WithConfigUpdates applies the updates received from the channel while the worker is running, e.g. from a watched
+// configuration file. Invalid updates are logged and ignored.
+func (w *Worker) WithConfigUpdates(updates <-chan ConfigUpdate) *Worker {
+	w.configUpdates = updates
+	return w
+}
+
+// UpdateConfig changes settings of the running worker without restarting it, the leases are kept. The updated
+// settings are used by the shard consumers from their next GetRecords call and by the next lease acquisition.
+func (w *Worker) UpdateConfig(update ConfigUpdate) error {
+	// the updated settings are validated like the configuration of the worker, e.g. MaxRecords is bounded by the
+	// limit of GetRecords
+	w.settings.mux.RLock()
+	updated := *w.kclConfig
+	updated.IdleTimeBetweenReadsInMillis = w.settings.idleTimeBetweenReadsInMillis
+	updated.MaxRecords = w.settings.maxRecords
+	updated.MaxLeasesForWorker = w.settings.maxLeasesForWorker
+	w.settings.mux.RUnlock()
+	if update.IdleTimeBetweenReadsInMillis != nil {
+		updated.IdleTimeBetweenReadsInMillis = *update.IdleTimeBetweenReadsInMillis
+	}
+	if update.MaxRecords != nil {
+		updated.MaxRecords = *update.MaxRecords
+	}
+	if update.MaxLeasesForWorker != nil {
+		updated.MaxLeasesForWorker = *update.MaxLeasesForWorker
+	}
+	if err := updated.Validate(); err != nil {
+		return err
+	}
+
+	// the level is changed first, so that an unsupported logger leaves the other settings unchanged
+	if update.LogLevel != nil {
+		setter, ok := w.kclConfig.Logger.(logger.LevelSetter)
+		if !ok {
+			return fmt.Errorf("the level of logger %T cannot be changed", w.kclConfig.Logger)
+		}
+		if err := setter.SetLevel(*update.LogLevel); err != nil {
+			return err
+		}
+	}
+
+	w.settings.mux.Lock()
+	defer w.settings.mux.Unlock()
+	w.settings.idleTimeBetweenReadsInMillis = updated.IdleTimeBetweenReadsInMillis
+	w.settings.maxRecords = updated.MaxRecords
+	w.settings.maxLeasesForWorker = updated.MaxLeasesForWorker
+	w.log.Infof("Configuration updated: idle time %d ms, max records %d, max leases %d",
+		w.settings.idleTimeBetweenReadsInMillis, w.settings.maxRecords, w.settings.maxLeasesForWorker)
+	return nil
+}
+
+// watchConfigUpdates applies the configuration updates until the worker shuts down
+func (w *Worker) watchConfigUpdates() {
+	for {
+		select {
+		case <-*w.stop:
+			return
+		case update, ok := <-w.configUpdates:
+			if !ok {
+				return
+			}
+			if err := w.UpdateConfig(update); err != nil {
+				w.log.Errorf("Invalid configuration update: %+v", err)
+			}
+		}
+	}
+}
+
+// maxRecords returns the max number of records of a GetRecords call
+func (sc *commonShardConsumer) maxRecords() int {
+	if sc.settings == nil {
+		return sc.kclConfig.MaxRecords
+	}
+	return sc.settings.getMaxRecords()
+}
+
+// idleTimeBetweenReadsInMillis returns the current idle time between GetRecords calls
+func (sc *commonShardConsumer) idleTimeBetweenReadsInMillis() int {
+	if sc.settings == nil {
+		return sc.kclConfig.IdleTimeBetweenReadsInMillis
+	}
+	return sc.settings.getIdleTimeBetweenReadsInMillis()
+}
+
+// pollingStrategy returns the configured polling strategy or the default one with the current idle time
+func (sc *commonShardConsumer) pollingStrategy() config.PollingStrategy {
+	if sc.kclConfig.PollingStrategy != nil {
+		return sc.kclConfig.PollingStrategy
+	}
+	return config.AdaptivePollingStrategy{
+		MaxDelayMillis:     sc.idleTimeBetweenReadsInMillis(),
+		LagThresholdMillis: config.DefaultPollingLagThresholdMillis,
+	}
+}
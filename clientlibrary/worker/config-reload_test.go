@@ -0,0 +1,103 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	uzerolog "github.com/rs/zerolog"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	"github.com/vmware/vmware-go-kcl-v2/logger"
+	"github.com/vmware/vmware-go-kcl-v2/logger/zerolog"
+)
+
+func TestUpdateConfig(t *testing.T) {
+	lLogger := logrus.New()
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+		WithLogger(logger.NewLogrusLogger(lLogger))
+	w := NewWorker(shutdownRecorderFactory{}, kclConfig)
+	sc := &commonShardConsumer{kclConfig: kclConfig, settings: w.settings}
+
+	assert.Nil(t, w.UpdateConfig(ConfigUpdate{
+		IdleTimeBetweenReadsInMillis: aws.Int(50),
+		MaxRecords:                   aws.Int(10),
+		MaxLeasesForWorker:           aws.Int(3),
+		LogLevel:                     aws.String(logger.Debug),
+	}))
+	assert.Equal(t, 10, sc.maxRecords())
+	assert.Equal(t, config.AdaptivePollingStrategy{MaxDelayMillis: 50, LagThresholdMillis: config.DefaultPollingLagThresholdMillis}, sc.pollingStrategy())
+	assert.Equal(t, 3, w.settings.getMaxLeasesForWorker())
+	assert.Equal(t, logrus.DebugLevel, lLogger.GetLevel())
+
+	// the initial configuration is left unchanged
+	assert.Equal(t, config.DefaultMaxRecords, kclConfig.MaxRecords)
+
+	// nothing is changed by an invalid update
+	assert.NotNil(t, w.UpdateConfig(ConfigUpdate{MaxRecords: aws.Int(20), MaxLeasesForWorker: aws.Int(0)}))
+	assert.NotNil(t, w.UpdateConfig(ConfigUpdate{MaxRecords: aws.Int(20), LogLevel: aws.String("verbose")}))
+	assert.NotNil(t, w.UpdateConfig(ConfigUpdate{MaxRecords: aws.Int(10001)}))
+	assert.Equal(t, 10, sc.maxRecords())
+	assert.Equal(t, 3, w.settings.getMaxLeasesForWorker())
+
+	// a configured polling strategy is not changed by the idle time
+	kclConfig.WithPollingStrategy(config.FixedPollingStrategy{IdleTimeMillis: 5})
+	assert.Equal(t, config.FixedPollingStrategy{IdleTimeMillis: 5}, sc.pollingStrategy())
+}
+
+func TestUpdateConfigLogLevel(t *testing.T) {
+	var buf bytes.Buffer
+	log := zerolog.NewZerologLoggerFromLogger(uzerolog.New(&buf).Level(uzerolog.InfoLevel))
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").WithLogger(log)
+	w := NewWorker(shutdownRecorderFactory{}, kclConfig)
+
+	assert.Nil(t, w.UpdateConfig(ConfigUpdate{LogLevel: aws.String(logger.Debug)}))
+	log.Debugf("debugging")
+	assert.Contains(t, buf.String(), "debugging")
+}
+
+func TestWithConfigUpdates(t *testing.T) {
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker")
+	updates := make(chan ConfigUpdate)
+	w := NewWorker(shutdownRecorderFactory{}, kclConfig).WithConfigUpdates(updates)
+
+	stop := make(chan struct{})
+	w.stop = &stop
+	done := make(chan struct{})
+	go func() {
+		w.watchConfigUpdates()
+		close(done)
+	}()
+
+	updates <- ConfigUpdate{MaxRecords: aws.Int(0)}
+	updates <- ConfigUpdate{MaxLeasesForWorker: aws.Int(7)}
+	assert.Eventually(t, func() bool {
+		return w.settings.getMaxLeasesForWorker() == 7
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, config.DefaultMaxRecords, w.settings.getMaxRecords())
+
+	close(stop)
+	<-done
+}
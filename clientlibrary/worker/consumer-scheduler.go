@@ -0,0 +1,126 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"sync"
+)
+
+// consumerScheduler bounds the shard consumers of a worker which fetch and process records at the same time. A shard
+// consumer takes a turn before reading a batch and ends it once the batch has been processed, so that the memory of
+// the batches and the processing overhead depend on the concurrency limit rather than on the number of leases. The
+// turns are granted in the order they have been requested, so each shard is served in round-robin while there are
+// more shards waiting than the limit. A throttled shard consumer ends its turn while it backs off, so that it does not
+// hold back the other shards. A nil scheduler is unlimited.
+type consumerScheduler struct {
+	mux sync.Mutex
+	// free is the number of turns which can be granted without waiting
+	free int
+	// waiting are the shard consumers waiting for a turn, in the order they requested it
+	waiting []chan struct{}
+}
+
+func newConsumerScheduler(maxConcurrency int) *consumerScheduler {
+	if maxConcurrency <= 0 {
+		return nil
+	}
+	return &consumerScheduler{free: maxConcurrency}
+}
+
+// acquire blocks until the shard consumer is granted a turn. It returns false if the stop channel was closed while
+// waiting.
+func (s *consumerScheduler) acquire(stop <-chan struct{}) bool {
+	if s == nil {
+		return true
+	}
+
+	s.mux.Lock()
+	// a turn is only taken without waiting if nobody is queued, so shards which just ended a turn do not overtake
+	if s.free > 0 && len(s.waiting) == 0 {
+		s.free--
+		s.mux.Unlock()
+		return true
+	}
+	granted := make(chan struct{})
+	s.waiting = append(s.waiting, granted)
+	s.mux.Unlock()
+
+	select {
+	case <-granted:
+		return true
+	case <-stop:
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for i, c := range s.waiting {
+		if c == granted {
+			s.waiting = append(s.waiting[:i], s.waiting[i+1:]...)
+			return false
+		}
+	}
+	// the turn has been granted while stopping, it is passed on
+	s.releaseLocked()
+	return false
+}
+
+// release ends the turn of a shard consumer and grants it to the longest waiting one
+func (s *consumerScheduler) release() {
+	if s == nil {
+		return
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.releaseLocked()
+}
+
+func (s *consumerScheduler) releaseLocked() {
+	if len(s.waiting) == 0 {
+		s.free++
+		return
+	}
+	next := s.waiting[0]
+	s.waiting = s.waiting[1:]
+	close(next)
+}
+
+// turn is the turn of a single shard consumer, it can be ended more than once
+type turn struct {
+	scheduler *consumerScheduler
+	held      bool
+}
+
+// begin waits for a turn unless one is held already. It returns false if the stop channel was closed while waiting.
+func (t *turn) begin(stop <-chan struct{}) bool {
+	if t.held {
+		return true
+	}
+	t.held = t.scheduler.acquire(stop)
+	return t.held
+}
+
+// end gives the turn back if one is held
+func (t *turn) end() {
+	if t.held {
+		t.scheduler.release()
+		t.held = false
+	}
+}
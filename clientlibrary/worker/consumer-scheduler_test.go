@@ -0,0 +1,177 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/stretchr/testify/assert"
+
+	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+)
+
+func TestConsumerSchedulerBoundsConcurrency(t *testing.T) {
+	s := newConsumerScheduler(2)
+
+	var running, maxRunning int32
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				assert.True(t, s.acquire(nil))
+				n := atomic.AddInt32(&running, 1)
+				for {
+					m := atomic.LoadInt32(&maxRunning)
+					if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+						break
+					}
+				}
+				time.Sleep(time.Millisecond)
+				atomic.AddInt32(&running, -1)
+				s.release()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.LessOrEqual(t, maxRunning, int32(2))
+	assert.Equal(t, 2, s.free)
+}
+
+func TestConsumerSchedulerIsFair(t *testing.T) {
+	s := newConsumerScheduler(1)
+	assert.True(t, s.acquire(nil))
+
+	// the turns are granted in the order they have been requested
+	var order []int
+	mux := &sync.Mutex{}
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.True(t, s.acquire(nil))
+			mux.Lock()
+			order = append(order, i)
+			mux.Unlock()
+			s.release()
+		}(i)
+		assert.Eventually(t, func() bool {
+			s.mux.Lock()
+			defer s.mux.Unlock()
+			return len(s.waiting) == i+1
+		}, time.Second, time.Millisecond)
+	}
+
+	s.release()
+	wg.Wait()
+	assert.Equal(t, []int{0, 1, 2}, order)
+}
+
+func TestConsumerSchedulerStop(t *testing.T) {
+	s := newConsumerScheduler(1)
+	assert.True(t, s.acquire(nil))
+
+	stop := make(chan struct{})
+	close(stop)
+	assert.False(t, s.acquire(stop))
+	assert.Empty(t, s.waiting)
+
+	s.release()
+	assert.Equal(t, 1, s.free)
+
+	// a turn can be ended more than once
+	schedulerTurn := &turn{scheduler: s}
+	assert.True(t, schedulerTurn.begin(nil))
+	assert.True(t, schedulerTurn.begin(nil))
+	schedulerTurn.end()
+	schedulerTurn.end()
+	assert.Equal(t, 1, s.free)
+}
+
+func TestUnlimitedConsumerScheduler(t *testing.T) {
+	s := newConsumerScheduler(0)
+	assert.Nil(t, s)
+	assert.True(t, s.acquire(nil))
+	s.release()
+}
+
+func TestThrottledShardPassesTurn(t *testing.T) {
+	var sequenceNumber int64
+	server := newKinesisServer(t, kinesisHandlers{
+		"ListShards": listShards(testShard("shardId-0", ""), testShard("shardId-1", "")),
+		"GetShardIterator": func(input map[string]interface{}) interface{} {
+			return map[string]interface{}{"ShardIterator": input["ShardId"]}
+		},
+		"GetRecords": func(input map[string]interface{}) interface{} {
+			if input["ShardIterator"] == "shardId-0" {
+				return kinesisError{status: http.StatusBadRequest, errType: "ProvisionedThroughputExceededException", message: "slow down"}
+			}
+			n := atomic.AddInt64(&sequenceNumber, 1)
+			return getRecordsOutput("shardId-1", kinesisRecord(fmt.Sprint(n), "YQ=="))
+		},
+	})
+	defer server.Close()
+
+	table, _ := chk.NewMemoryLeaseTable("")
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+		WithIdleTimeBetweenReadsInMillis(10).
+		WithShardSyncIntervalMillis(10).
+		WithMaxConcurrentShardConsumers(1).
+		WithMaxRetryCount(1000).
+		WithRetryPolicy(config.RetryPolicy{MaxAttempts: 1, BaseDelayMillis: 60000, MaxDelayMillis: 60000})
+	processor := &replayProcessor{}
+	w := NewWorker(replayProcessorFactory{processor}, kclConfig).
+		WithKinesis(kinesis.New(kinesis.Options{
+			Region:           "us-west-2",
+			Credentials:      credentials.NewStaticCredentialsProvider("id", "secret", ""),
+			EndpointResolver: kinesis.EndpointResolverFromURL(server.URL),
+			Retryer:          aws.NopRetryer{},
+		})).
+		WithCheckpointer(chk.NewMemoryCheckpoint(kclConfig).WithLeaseTable(table))
+	assert.Nil(t, w.Start())
+
+	// the throttled shard backs off without its turn, so the other shard is still consumed
+	assert.Eventually(t, func() bool {
+		return len(processor.delivered()) > 10
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the worker stops without waiting for the back-off of the throttled shard
+	shutdown := make(chan struct{})
+	go func() {
+		w.Shutdown()
+		close(shutdown)
+	}()
+	select {
+	case <-shutdown:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the worker has not stopped during the back-off")
+	}
+}
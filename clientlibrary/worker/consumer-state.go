@@ -0,0 +1,146 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+// ConsumerState is the state of the goroutine consuming a shard. The consumer of a shard moves from
+// STARTING through INITIALIZING and PROCESSING to SHUTTING_DOWN and SHUTDOWN_COMPLETE, the transitions
+// between the states are listed by consumerTransitions.
+type ConsumerState int
+
+const (
+	// ConsumerWaitingOnParent The consumer waits until the parent shards have been processed to their end. The
+	// state keeps the STARTING name it was reported with before the consumer states were split.
+	ConsumerWaitingOnParent ConsumerState = iota + 1
+
+	// ConsumerProcessing The consumer delivers records to the record processor.
+	ConsumerProcessing
+
+	// ConsumerRestarting The record processor panicked and waits to be restarted.
+	ConsumerRestarting
+
+	// ConsumerShuttingDown The record processor is being shut down.
+	ConsumerShuttingDown
+
+	// ConsumerPaused The shard has been paused by the application, the lease is renewed but no records are delivered.
+	ConsumerPaused
+
+	// ConsumerInitializing The consumer gets the position to read the shard from and initializes the record processor.
+	ConsumerInitializing
+
+	// ConsumerShutdownComplete The consumer has stopped and released the lease unless it has been lost.
+	ConsumerShutdownComplete
+)
+
+// ConsumerStarting is the state of a consumer before it initializes the record processor.
+//
+// Deprecated: use ConsumerWaitingOnParent.
+const ConsumerStarting = ConsumerWaitingOnParent
+
+var consumerStateNames = map[ConsumerState]string{
+	ConsumerWaitingOnParent:  "STARTING",
+	ConsumerInitializing:     "INITIALIZING",
+	ConsumerProcessing:       "PROCESSING",
+	ConsumerPaused:           "PAUSED",
+	ConsumerRestarting:       "RESTARTING",
+	ConsumerShuttingDown:     "SHUTTING_DOWN",
+	ConsumerShutdownComplete: "SHUTDOWN_COMPLETE",
+}
+
+// consumerTransitions are the states a consumer can move to from each state. A consumer restarts waiting on the
+// parent shards after its record processor has been restarted or its shard has been rewound.
+var consumerTransitions = map[ConsumerState][]ConsumerState{
+	ConsumerWaitingOnParent: {ConsumerInitializing, ConsumerRestarting, ConsumerShutdownComplete},
+	ConsumerInitializing:    {ConsumerProcessing, ConsumerRestarting, ConsumerShutdownComplete},
+	ConsumerProcessing:      {ConsumerPaused, ConsumerShuttingDown, ConsumerRestarting},
+	ConsumerPaused:          {ConsumerProcessing, ConsumerShuttingDown, ConsumerRestarting},
+	ConsumerShuttingDown:    {ConsumerShutdownComplete, ConsumerWaitingOnParent, ConsumerRestarting},
+	ConsumerRestarting:      {ConsumerWaitingOnParent, ConsumerShutdownComplete},
+}
+
+type (
+	// ShardConsumerStateListener is notified of the transitions of the shard consumers of a worker, e.g. to track
+	// shards blocked on their parents or to wait for a deterministic shutdown in tests. The callback is called
+	// synchronously by the shard consumers, concurrently for different shards, and therefore has to return quickly.
+	ShardConsumerStateListener interface {
+		StateChanged(shardID string, from, to ConsumerState)
+	}
+
+	// ShardConsumerStateListenerFunc is an adapter to use a function as ShardConsumerStateListener
+	ShardConsumerStateListenerFunc func(shardID string, from, to ConsumerState)
+)
+
+// StateChanged calls f(shardID, from, to).
+func (f ShardConsumerStateListenerFunc) StateChanged(shardID string, from, to ConsumerState) {
+	f(shardID, from, to)
+}
+
+func (s ConsumerState) String() string {
+	return consumerStateNames[s]
+}
+
+// MarshalText encodes the state by its name.
+func (s ConsumerState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// canTransition returns true if a consumer can move from the state to the other state
+func (s ConsumerState) canTransition(to ConsumerState) bool {
+	for _, state := range consumerTransitions[s] {
+		if state == to {
+			return true
+		}
+	}
+	return false
+}
+
+// WithShardConsumerStateListener sets the listener notified of the transitions of the shard consumers. The listener
+// has to be set before the worker is started.
+func (w *Worker) WithShardConsumerStateListener(listener ShardConsumerStateListener) *Worker {
+	w.consumerStateListener = listener
+	return w
+}
+
+// setState moves the consumer to the state and notifies the listener. A transition which is not listed by
+// consumerTransitions is logged and ignored, moving to the current state does nothing.
+func (s *consumerStatus) setState(state ConsumerState) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	from := s.state
+	if from == state {
+		s.Unlock()
+		return
+	}
+	if !from.canTransition(state) {
+		s.Unlock()
+		if s.log != nil {
+			s.log.Warnf("Ignoring invalid transition of the consumer of shard %s from %s to %s", s.shard.ID, from, state)
+		}
+		return
+	}
+	s.state = state
+	s.Unlock()
+
+	// the listener is called without the lock, so that it can get the status of the worker
+	if s.listener != nil {
+		s.listener.StateChanged(s.shard.ID, from, state)
+	}
+}
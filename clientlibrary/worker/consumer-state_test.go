@@ -0,0 +1,117 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+// transitionRecorder records the transitions of the shard consumers
+type transitionRecorder struct {
+	mux         sync.Mutex
+	transitions []string
+}
+
+func (r *transitionRecorder) StateChanged(shardID string, from, to ConsumerState) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.transitions = append(r.transitions, shardID+" "+from.String()+" -> "+to.String())
+}
+
+func TestConsumerStateTransitions(t *testing.T) {
+	recorder := &transitionRecorder{}
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker")
+	status := &consumerStatus{
+		shard:    &par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}},
+		state:    ConsumerWaitingOnParent,
+		listener: recorder,
+		log:      kclConfig.Logger,
+	}
+
+	status.setState(ConsumerInitializing)
+	// moving to the current state is not a transition
+	status.setState(ConsumerInitializing)
+	// records are not processed before the record processor has been initialized
+	status.setState(ConsumerPaused)
+	assert.Equal(t, ConsumerInitializing, status.state)
+	status.setState(ConsumerProcessing)
+	status.setState(ConsumerShuttingDown)
+	status.setState(ConsumerShutdownComplete)
+	// the consumer does not leave SHUTDOWN_COMPLETE
+	status.setState(ConsumerWaitingOnParent)
+	assert.Equal(t, ConsumerShutdownComplete, status.state)
+
+	assert.Equal(t, []string{
+		"0001 STARTING -> INITIALIZING",
+		"0001 INITIALIZING -> PROCESSING",
+		"0001 PROCESSING -> SHUTTING_DOWN",
+		"0001 SHUTTING_DOWN -> SHUTDOWN_COMPLETE",
+	}, recorder.transitions)
+
+	// the status of consumers without a worker is not tracked
+	var none *consumerStatus
+	none.setState(ConsumerProcessing)
+}
+
+func TestShardConsumerStateListener(t *testing.T) {
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+		WithProcessorPanicPolicy(config.RestartOnPanic).
+		WithProcessorRestartBackoffMillis(1)
+	w, checkpointer, closeServer := newPanicTestWorker(t, kclConfig, 1)
+	defer closeServer()
+
+	recorder := &transitionRecorder{}
+	var ownerAtShutdown []string
+	w.WithShardConsumerStateListener(ShardConsumerStateListenerFunc(func(shardID string, from, to ConsumerState) {
+		recorder.StateChanged(shardID, from, to)
+		if to == ConsumerShutdownComplete {
+			owner, _ := checkpointer.GetLeaseOwner(shardID)
+			ownerAtShutdown = append(ownerAtShutdown, owner)
+		}
+	}))
+
+	// the parent shard has been processed to its end
+	checkpointer.checkpoints["0000"] = chk.ShardEnd
+	shard := &par.ShardStatus{ID: "0001", ParentShardId: "0000", Mux: &sync.RWMutex{}}
+	assert.Nil(t, checkpointer.GetLease(shard, "worker"))
+	w.runShardConsumer(shard)
+
+	assert.Equal(t, []string{
+		"0001 STARTING -> INITIALIZING",
+		"0001 INITIALIZING -> PROCESSING",
+		// the record processor panics, it is shut down and restarted
+		"0001 PROCESSING -> SHUTTING_DOWN",
+		"0001 SHUTTING_DOWN -> RESTARTING",
+		"0001 RESTARTING -> STARTING",
+		"0001 STARTING -> INITIALIZING",
+		"0001 INITIALIZING -> PROCESSING",
+		"0001 PROCESSING -> SHUTTING_DOWN",
+		"0001 SHUTTING_DOWN -> SHUTDOWN_COMPLETE",
+	}, recorder.transitions)
+	// the lease has been released once the consumer has shut down
+	assert.Equal(t, []string{""}, ownerAtShutdown)
+}
@@ -0,0 +1,260 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package worker
+package worker
+
+import (
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+// defaultCPUProfileSeconds is the duration of a CPU profile without seconds parameter
+const defaultCPUProfileSeconds = 30
+
+// workerVars holds the expvar variables of the workers with a debug server by their worker ID
+var workerVars = expvar.NewMap("kcl")
+
+// LeaseStatus is the state of the lease of a shard of the streams as last seen by the worker
+type LeaseStatus struct {
+	// ShardID is the lease key of the shard
+	ShardID string `json:"shardId"`
+
+	// StreamName is the stream of the shard in multi-stream mode
+	StreamName string `json:"streamName,omitempty"`
+
+	// ParentShardIDs are the lease keys of the parent shards, if any
+	ParentShardIDs []string `json:"parentShardIds,omitempty"`
+
+	// LeaseOwner is the worker holding the lease, it is empty if the lease is not held
+	LeaseOwner string `json:"leaseOwner"`
+
+	// Checkpoint is the last checkpointed sequence number of the shard
+	Checkpoint string `json:"checkpoint"`
+
+	// LeaseTimeout is the time the lease expires unless it is renewed
+	LeaseTimeout time.Time `json:"leaseTimeout"`
+
+	// ClaimRequest is the worker which claimed the shard to steal the lease, if any
+	ClaimRequest string `json:"claimRequest,omitempty"`
+}
+
+// Leases returns the leases of the shards of the streams as last synced by the worker, ordered by their lease key
+func (w *Worker) Leases() []LeaseStatus {
+	w.statusMux.RLock()
+	shards := w.leases
+	w.statusMux.RUnlock()
+
+	leases := make([]LeaseStatus, 0, len(shards))
+	for _, shard := range shards {
+		leases = append(leases, LeaseStatus{
+			ShardID:        shard.ID,
+			StreamName:     shard.StreamName,
+			ParentShardIDs: shard.GetParentShardIds(),
+			LeaseOwner:     shard.GetLeaseOwner(),
+			Checkpoint:     shard.GetCheckpoint(),
+			LeaseTimeout:   shard.GetLeaseTimeout(),
+			ClaimRequest:   shard.GetClaimRequest(),
+		})
+	}
+	return leases
+}
+
+// snapshotLeases keeps the shards of the event loop for Leases, the state of each shard is guarded by its own mutex
+func (w *Worker) snapshotLeases() {
+	shards := make([]*par.ShardStatus, 0, len(w.shardStatus))
+	for _, shard := range w.shardStatus {
+		shards = append(shards, shard)
+	}
+	sort.Slice(shards, func(i, j int) bool {
+		return shards[i].ID < shards[j].ID
+	})
+
+	w.statusMux.Lock()
+	defer w.statusMux.Unlock()
+	w.leases = shards
+}
+
+// DebugHandler returns an HTTP handler serving pprof profiles at /debug/pprof/, the expvar variables at /debug/vars,
+// the leases at /debug/leases, the state of the shard consumers at /debug/shards and the hottest shards at
+// /debug/hotshards, e.g. to debug stuck shards. The requests are authorized by the DebugAuthorizer of the
+// configuration, if any.
+func (w *Worker) DebugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", servePprof)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/debug/leases", func(rw http.ResponseWriter, _ *http.Request) {
+		w.writeJSON(rw, w.Leases())
+	})
+	mux.HandleFunc("/debug/shards", func(rw http.ResponseWriter, _ *http.Request) {
+		w.writeJSON(rw, w.Status())
+	})
+	mux.HandleFunc("/debug/hotshards", func(rw http.ResponseWriter, _ *http.Request) {
+		w.writeJSON(rw, w.HotShards())
+	})
+
+	authorizer := w.kclConfig.DebugAuthorizer
+	if authorizer == nil {
+		return mux
+	}
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if err := authorizer(r); err != nil {
+			http.Error(rw, err.Error(), http.StatusForbidden)
+			return
+		}
+		mux.ServeHTTP(rw, r)
+	})
+}
+
+func (w *Worker) writeJSON(rw http.ResponseWriter, value interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(value); err != nil {
+		w.log.Errorf("Failed to encode debug response: %+v", err)
+	}
+}
+
+// servePprof serves the profiles of runtime/pprof, net/http/pprof is not used as it registers its handlers with
+// the default mux of the application
+func servePprof(rw http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/debug/pprof/")
+	if name == "" {
+		rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, profile := range pprof.Profiles() {
+			_, _ = fmt.Fprintf(rw, "%s %d\n", profile.Name(), profile.Count())
+		}
+		_, _ = fmt.Fprintln(rw, "profile")
+		return
+	}
+
+	if name == "profile" {
+		seconds, err := strconv.Atoi(r.URL.Query().Get("seconds"))
+		if err != nil || seconds <= 0 {
+			seconds = defaultCPUProfileSeconds
+		}
+		rw.Header().Set("Content-Type", "application/octet-stream")
+		if err := pprof.StartCPUProfile(rw); err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		select {
+		case <-time.After(time.Duration(seconds) * time.Second):
+		case <-r.Context().Done():
+		}
+		pprof.StopCPUProfile()
+		return
+	}
+
+	profile := pprof.Lookup(name)
+	if profile == nil {
+		http.NotFound(rw, r)
+		return
+	}
+	debug, _ := strconv.Atoi(r.URL.Query().Get("debug"))
+	if debug > 0 {
+		rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	} else {
+		rw.Header().Set("Content-Type", "application/octet-stream")
+	}
+	_ = profile.WriteTo(rw, debug)
+}
+
+// debugVars returns the expvar variables of the worker
+func (w *Worker) debugVars() interface{} {
+	status := w.Status()
+	states := make(map[string]int)
+	var maxMillisBehindLatest int64
+	for _, shard := range status.Shards {
+		states[shard.State.String()]++
+		if shard.MillisBehindLatest > maxMillisBehindLatest {
+			maxMillisBehindLatest = shard.MillisBehindLatest
+		}
+	}
+
+	leases := w.Leases()
+	var leasesHeld int
+	for _, lease := range leases {
+		if lease.LeaseOwner == w.workerID {
+			leasesHeld++
+		}
+	}
+
+	return map[string]interface{}{
+		"running":               status.Running,
+		"consumers":             len(status.Shards),
+		"consumerStates":        states,
+		"leases":                len(leases),
+		"leasesHeld":            leasesHeld,
+		"maxMillisBehindLatest": maxMillisBehindLatest,
+	}
+}
+
+// startDebugServer serves the DebugHandler at the DebugServerAddress of the configuration, if any
+func (w *Worker) startDebugServer() error {
+	if w.kclConfig.DebugServerAddress == "" {
+		return nil
+	}
+
+	listener, err := net.Listen("tcp", debugServerAddress(w.kclConfig.DebugServerAddress))
+	if err != nil {
+		return fmt.Errorf("failed to start the debug server: %w", err)
+	}
+	w.debugListener = listener
+	w.debugServer = &http.Server{Handler: w.DebugHandler(), ReadHeaderTimeout: 10 * time.Second}
+	workerVars.Set(w.workerID, expvar.Func(w.debugVars))
+
+	w.log.Infof("Starting debug server at %s", listener.Addr())
+	go func() {
+		if err := w.debugServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			w.log.Errorf("Debug server failed: %+v", err)
+		}
+	}()
+	return nil
+}
+
+// debugServerAddress binds an address without host, e.g. ":6060", to localhost instead of all interfaces as the
+// profiles and the leases are served to anyone who can reach the debug server unless a DebugAuthorizer is set
+func debugServerAddress(address string) string {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || host != "" {
+		return address
+	}
+	return net.JoinHostPort("localhost", port)
+}
+
+// stopDebugServer closes the debug server, if any
+func (w *Worker) stopDebugServer() {
+	if w.debugServer == nil {
+		return
+	}
+	if err := w.debugServer.Close(); err != nil {
+		w.log.Warnf("Failed to close the debug server: %+v", err)
+	}
+	workerVars.Delete(w.workerID)
+}
@@ -0,0 +1,143 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+)
+
+func TestDebugServer(t *testing.T) {
+	var positions []string
+	var mux sync.Mutex
+	server := newReplayServer(t, &positions, &mux)
+	defer server.Close()
+
+	authorizer := func(r *http.Request) error {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			return errors.New("unauthorized")
+		}
+		return nil
+	}
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+		WithIdleTimeBetweenReadsInMillis(10).
+		WithShardSyncIntervalMillis(10).
+		WithHotShardsReport(10, 1).
+		WithDebugServer("127.0.0.1:0", authorizer)
+	processor := &replayProcessor{}
+	w := NewWorker(replayProcessorFactory{processor}, kclConfig).
+		WithKinesis(newTestKinesisClient(server.URL)).
+		WithCheckpointer(chk.NewMemoryCheckpoint(kclConfig))
+
+	assert.Nil(t, w.Start())
+	defer w.Shutdown()
+	assert.Eventually(t, func() bool {
+		return len(processor.delivered()) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	get := func(path string, authorized bool) (int, []byte) {
+		req, err := http.NewRequest(http.MethodGet, "http://"+w.debugListener.Addr().String()+path, nil)
+		assert.Nil(t, err)
+		if authorized {
+			req.Header.Set("Authorization", "Bearer token")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		assert.Nil(t, err)
+		return resp.StatusCode, body
+	}
+
+	code, _ := get("/debug/leases", false)
+	assert.Equal(t, http.StatusForbidden, code)
+
+	code, body := get("/debug/leases", true)
+	assert.Equal(t, http.StatusOK, code)
+	var leases []LeaseStatus
+	assert.Nil(t, json.Unmarshal(body, &leases))
+	assert.Len(t, leases, 1)
+	assert.Equal(t, "shardId-0", leases[0].ShardID)
+	assert.Equal(t, "worker", leases[0].LeaseOwner)
+
+	code, body = get("/debug/shards", true)
+	assert.Equal(t, http.StatusOK, code)
+	var status struct {
+		Running bool `json:"running"`
+		Shards  []struct {
+			ShardID string `json:"shardId"`
+			State   string `json:"state"`
+		} `json:"shards"`
+	}
+	assert.Nil(t, json.Unmarshal(body, &status))
+	assert.True(t, status.Running)
+	assert.Len(t, status.Shards, 1)
+
+	assert.Eventually(t, func() bool {
+		return len(w.HotShards()) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	code, body = get("/debug/hotshards", true)
+	assert.Equal(t, http.StatusOK, code)
+	var hot []config.HotShard
+	assert.Nil(t, json.Unmarshal(body, &hot))
+	assert.Len(t, hot, 1)
+	assert.Equal(t, "shardId-0", hot[0].ShardID)
+
+	code, body = get("/debug/vars", true)
+	assert.Equal(t, http.StatusOK, code)
+	var vars struct {
+		KCL map[string]struct {
+			Running    bool `json:"running"`
+			LeasesHeld int  `json:"leasesHeld"`
+		} `json:"kcl"`
+	}
+	assert.Nil(t, json.Unmarshal(body, &vars))
+	assert.True(t, vars.KCL["worker"].Running)
+	assert.Equal(t, 1, vars.KCL["worker"].LeasesHeld)
+
+	code, body = get("/debug/pprof/", true)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, string(body), "goroutine")
+
+	code, body = get("/debug/pprof/goroutine?debug=1", true)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, string(body), "goroutine profile")
+
+	code, _ = get("/debug/pprof/unknown", true)
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestDebugServerAddress(t *testing.T) {
+	// the debug server is not exposed on all interfaces unless it is asked for
+	assert.Equal(t, "localhost:6060", debugServerAddress(":6060"))
+	assert.Equal(t, "127.0.0.1:6060", debugServerAddress("127.0.0.1:6060"))
+	assert.Equal(t, "0.0.0.0:6060", debugServerAddress("0.0.0.0:6060"))
+	assert.Equal(t, "[::]:6060", debugServerAddress("[::]:6060"))
+}
@@ -0,0 +1,198 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+)
+
+type (
+	// DedupKey identifies a user record of a shard
+	DedupKey struct {
+		// ShardID is the lease key of the shard
+		ShardID           string
+		SequenceNumber    string
+		SubSequenceNumber int64
+	}
+
+	// DedupCache remembers the records processed within a dedup window, e.g. in memory or in an external store
+	// shared by the workers of an application
+	DedupCache interface {
+		// Contains reports for each key whether the record has been processed within the dedup window
+		Contains(ctx context.Context, keys []DedupKey) ([]bool, error)
+
+		// Add remembers the keys of processed records for the dedup window
+		Add(ctx context.Context, keys []DedupKey) error
+	}
+
+	// MemoryDedupCache is a DedupCache of the records processed by the worker within a TTL. The records are only
+	// remembered by the worker, the records of a shard whose lease moves to another worker are not filtered by it.
+	MemoryDedupCache struct {
+		ttl        time.Duration
+		maxEntries int
+
+		mux     sync.Mutex
+		entries map[DedupKey]*list.Element
+		// order holds the dedupEntry of the keys by the time they were added, i.e. by their expiry
+		order *list.List
+	}
+
+	dedupEntry struct {
+		key     DedupKey
+		expires time.Time
+	}
+)
+
+// NewMemoryDedupCache returns a cache remembering processed records for the TTL. At most maxEntries records are
+// remembered, the oldest records are forgotten first. The number of records is unlimited if maxEntries is 0.
+func NewMemoryDedupCache(ttl time.Duration, maxEntries int) *MemoryDedupCache {
+	return &MemoryDedupCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[DedupKey]*list.Element),
+		order:      list.New(),
+	}
+}
+
+// Contains reports for each key whether the record has been added within the TTL
+func (c *MemoryDedupCache) Contains(_ context.Context, keys []DedupKey) ([]bool, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	now := time.Now()
+	contained := make([]bool, len(keys))
+	for i, key := range keys {
+		if element, ok := c.entries[key]; ok {
+			contained[i] = now.Before(element.Value.(*dedupEntry).expires)
+		}
+	}
+	return contained, nil
+}
+
+// Add remembers the keys for the TTL
+func (c *MemoryDedupCache) Add(_ context.Context, keys []DedupKey) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	now := time.Now()
+	expires := now.Add(c.ttl)
+	for _, key := range keys {
+		if element, ok := c.entries[key]; ok {
+			c.order.Remove(element)
+		}
+		c.entries[key] = c.order.PushBack(&dedupEntry{key: key, expires: expires})
+	}
+
+	for element := c.order.Front(); element != nil; element = c.order.Front() {
+		entry := element.Value.(*dedupEntry)
+		if now.Before(entry.expires) && (c.maxEntries <= 0 || c.order.Len() <= c.maxEntries) {
+			break
+		}
+		c.order.Remove(element)
+		delete(c.entries, entry.key)
+	}
+	return nil
+}
+
+// Len returns the number of remembered records, including expired ones which have not been evicted yet
+func (c *MemoryDedupCache) Len() int {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.order.Len()
+}
+
+// DedupMiddleware returns a middleware which filters the records processed within the dedup window of the cache
+// from the batches, e.g. the records delivered again after lease churn because the previous lease owner processed
+// them but did not checkpoint them. The records of a batch are added to the cache once it has been processed
+// successfully. A batch of duplicates only is not passed to the record processor, its last record is checkpointed
+// instead so that the checkpoint of the shard keeps advancing.
+//
+// The records are delivered at least once: if the cache fails, the batch is processed without filtering. After a
+// rewind of a shard the records within the dedup window are filtered too. A MemoryDedupCache doesn't survive a
+// lease moving to another worker, e.g. after a restart or lease stealing, the cache has to be shared by the workers
+// to filter the records delivered to the new lease owner.
+func DedupMiddleware(cache DedupCache) Middleware {
+	return ProcessRecordsMiddleware(func(next ProcessRecordsFunc) ProcessRecordsFunc {
+		return func(ctx context.Context, input *kcl.ProcessRecordsInput) error {
+			records := input.AllUserRecords()
+			if len(records) == 0 {
+				return next(ctx, input)
+			}
+
+			v2 := input.V2()
+			shardID := v2.ShardId
+			if v2.StreamARN != "" {
+				shardID = v2.StreamARN + ":" + shardID
+			} else if v2.StreamName != "" {
+				shardID = v2.StreamName + ":" + shardID
+			}
+			keys := make([]DedupKey, len(records))
+			for i, record := range records {
+				keys[i] = DedupKey{
+					ShardID:           shardID,
+					SequenceNumber:    aws.ToString(record.SequenceNumber),
+					SubSequenceNumber: record.SubSequenceNumber,
+				}
+			}
+
+			contained, err := cache.Contains(ctx, keys)
+			if err == nil && len(contained) == len(keys) {
+				input, keys = filterDuplicates(input, records, keys, contained)
+				if len(keys) == 0 {
+					if input.Checkpointer == nil {
+						return nil
+					}
+					return checkpointRecord(input.Checkpointer, records[len(records)-1])
+				}
+			}
+
+			if err := next(ctx, input); err != nil {
+				return err
+			}
+			// the batch has been processed, a failure to remember it only lets the records be delivered again
+			_ = cache.Add(ctx, keys)
+			return nil
+		}
+	})
+}
+
+// filterDuplicates returns a copy of the input without the contained records and the keys of the remaining ones
+func filterDuplicates(input *kcl.ProcessRecordsInput, records []kcl.UserRecord, keys []DedupKey, contained []bool) (*kcl.ProcessRecordsInput, []DedupKey) {
+	filtered := *input
+	filtered.Records = nil
+	filtered.UserRecords = nil
+	var remaining []DedupKey
+	for i, record := range records {
+		if contained[i] {
+			continue
+		}
+		filtered.Records = append(filtered.Records, record.Record)
+		filtered.UserRecords = append(filtered.UserRecords, record)
+		remaining = append(remaining, keys[i])
+	}
+	return &filtered, remaining
+}
@@ -0,0 +1,127 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/stretchr/testify/assert"
+
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+)
+
+// failingDedupCache fails all lookups
+type failingDedupCache struct {
+	*MemoryDedupCache
+}
+
+func (failingDedupCache) Contains(context.Context, []DedupKey) ([]bool, error) {
+	return nil, errors.New("unavailable")
+}
+
+func newDedupInput(shardID string, sequenceNumbers ...string) *kcl.ProcessRecordsInput {
+	input := &kcl.ProcessRecordsInputV2{ShardId: shardID}
+	for _, sequenceNumber := range sequenceNumbers {
+		record := types.Record{SequenceNumber: aws.String(sequenceNumber)}
+		input.Records = append(input.Records, record)
+		input.UserRecords = append(input.UserRecords, kcl.UserRecord{Record: record})
+	}
+	return input.Input()
+}
+
+func TestDedupMiddleware(t *testing.T) {
+	var delivered [][]string
+	var fail bool
+	process := func(_ context.Context, input *kcl.ProcessRecordsInput) error {
+		var sequenceNumbers []string
+		for _, record := range input.UserRecords {
+			sequenceNumbers = append(sequenceNumbers, aws.ToString(record.SequenceNumber))
+		}
+		delivered = append(delivered, sequenceNumbers)
+		if fail {
+			return errors.New("failed")
+		}
+		return nil
+	}
+
+	cache := NewMemoryDedupCache(time.Minute, 0)
+	middleware := DedupMiddleware(cache)
+	processor := middleware(&processRecordsInterceptor{processRecords: process})
+	ctx := context.Background()
+
+	assert.Nil(t, processor.ProcessRecords(ctx, newDedupInput("shardId-0", "100", "101")))
+	// the records delivered again are filtered, also if the whole batch has been processed
+	assert.Nil(t, processor.ProcessRecords(ctx, newDedupInput("shardId-0", "101", "102")))
+	assert.Nil(t, processor.ProcessRecords(ctx, newDedupInput("shardId-0", "100", "102")))
+	// the records are keyed by their shard
+	assert.Nil(t, processor.ProcessRecords(ctx, newDedupInput("shardId-1", "100")))
+	assert.Equal(t, [][]string{{"100", "101"}, {"102"}, {"100"}}, delivered)
+
+	// the checkpoint advances past a batch of duplicates only
+	checkpointer := &sequenceRecorder{}
+	input := newDedupInput("shardId-0", "101", "102")
+	input.Checkpointer = checkpointer
+	assert.Nil(t, processor.ProcessRecords(ctx, input))
+	assert.Equal(t, 3, len(delivered))
+	assert.Equal(t, []string{"102"}, checkpointer.checkpoints())
+
+	// a failed batch is delivered again
+	fail = true
+	assert.NotNil(t, processor.ProcessRecords(ctx, newDedupInput("shardId-0", "103")))
+	fail = false
+	assert.Nil(t, processor.ProcessRecords(ctx, newDedupInput("shardId-0", "103")))
+	assert.Equal(t, [][]string{{"100", "101"}, {"102"}, {"100"}, {"103"}, {"103"}}, delivered)
+
+	// the batch is processed without filtering if the cache fails
+	processor = DedupMiddleware(failingDedupCache{cache})(&processRecordsInterceptor{processRecords: process})
+	assert.Nil(t, processor.ProcessRecords(ctx, newDedupInput("shardId-0", "100")))
+	assert.Equal(t, []string{"100"}, delivered[len(delivered)-1])
+}
+
+func TestMemoryDedupCache(t *testing.T) {
+	ctx := context.Background()
+	key := func(sequenceNumber string) DedupKey {
+		return DedupKey{ShardID: "shardId-0", SequenceNumber: sequenceNumber}
+	}
+
+	// the oldest records are evicted first
+	cache := NewMemoryDedupCache(time.Minute, 2)
+	assert.Nil(t, cache.Add(ctx, []DedupKey{key("100"), key("101")}))
+	assert.Nil(t, cache.Add(ctx, []DedupKey{key("100")}))
+	assert.Nil(t, cache.Add(ctx, []DedupKey{key("102")}))
+	contained, err := cache.Contains(ctx, []DedupKey{key("100"), key("101"), key("102"), {ShardID: "shardId-0", SequenceNumber: "100", SubSequenceNumber: 1}})
+	assert.Nil(t, err)
+	assert.Equal(t, []bool{true, false, true, false}, contained)
+	assert.Equal(t, 2, cache.Len())
+
+	// the records expire after the TTL
+	cache = NewMemoryDedupCache(50*time.Millisecond, 0)
+	assert.Nil(t, cache.Add(ctx, []DedupKey{key("100")}))
+	time.Sleep(100 * time.Millisecond)
+	contained, _ = cache.Contains(ctx, []DedupKey{key("100")})
+	assert.Equal(t, []bool{false}, contained)
+	assert.Nil(t, cache.Add(ctx, []DedupKey{key("101")}))
+	assert.Equal(t, 1, cache.Len())
+}
@@ -0,0 +1,84 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package worker
+package worker
+
+import (
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+)
+
+type (
+	// deliveryTracker counts the deliveries of the failing batch of each shard. A batch is identified by its first
+	// user record, so that the attempts are still counted when the batch is read again from the last checkpoint.
+	deliveryTracker struct {
+		mux     sync.Mutex
+		batches map[string]batchDelivery
+	}
+
+	// batchDelivery is the first user record of a failing batch and the number of its deliveries
+	batchDelivery struct {
+		sequenceNumber    string
+		subSequenceNumber int64
+		attempts          int
+	}
+)
+
+func newDeliveryTracker() *deliveryTracker {
+	return &deliveryTracker{batches: make(map[string]batchDelivery)}
+}
+
+// attempt counts a delivery of the records to the record processor of the shard and returns the number of the
+// delivery. The deliveries of empty batches are not counted.
+func (t *deliveryTracker) attempt(shardID string, records []kcl.UserRecord) int {
+	if len(records) == 0 {
+		return 1
+	}
+
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	first := records[0]
+	batch, ok := t.batches[shardID]
+	if !ok || batch.sequenceNumber != aws.ToString(first.SequenceNumber) || batch.subSequenceNumber != first.SubSequenceNumber {
+		batch = batchDelivery{sequenceNumber: aws.ToString(first.SequenceNumber), subSequenceNumber: first.SubSequenceNumber}
+	}
+	batch.attempts++
+	t.batches[shardID] = batch
+	return batch.attempts
+}
+
+// delivered forgets the batch of the shard once it has been processed or skipped
+func (t *deliveryTracker) delivered(shardID string) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	delete(t.batches, shardID)
+}
+
+// deliveryTracker returns the tracker of the worker, a consumer run without a worker counts its own deliveries
+func (sc *commonShardConsumer) deliveryTracker() *deliveryTracker {
+	if sc.deliveries == nil {
+		sc.deliveries = newDeliveryTracker()
+	}
+	return sc.deliveries
+}
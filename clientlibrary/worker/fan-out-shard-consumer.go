@@ -48,16 +48,17 @@ type FanOutShardConsumer struct {
 func (sc *FanOutShardConsumer) getRecords() error {
 	defer sc.releaseLease(sc.shard.ID)
 
-	log := sc.kclConfig.Logger
+	log := sc.getLogger()
 
 	// If the shard is child shard, need to wait until the parent finished.
 	if err := sc.waitOnParentShard(); err != nil {
 		// If parent shard has been deleted by Kinesis system already, just ignore the error.
-		if err != chk.ErrSequenceIDNotFound {
+		if !errors.Is(err, chk.ErrSequenceIDNotFound) {
 			log.Errorf("Error in waiting for parent shard: %v to finish. Error: %+v", sc.shard.ParentShardId, err)
 			return err
 		}
 	}
+	sc.status.setState(ConsumerInitializing)
 
 	shardSub, err := sc.subscribeToShard()
 	if err != nil {
@@ -75,36 +76,48 @@ func (sc *FanOutShardConsumer) getRecords() error {
 		}
 	}()
 
-	input := &kcl.InitializationInput{
-		ShardId:                sc.shard.ID,
-		ExtendedSequenceNumber: &kcl.ExtendedSequenceNumber{SequenceNumber: aws.String(sc.shard.GetCheckpoint())},
-	}
-	sc.recordProcessor.Initialize(input)
-	recordCheckpointer := NewRecordProcessorCheckpoint(sc.shard, sc.checkpointer)
+	// the lease is renewed until the record processor has been shut down
+	defer sc.startLeaseRenewer(sc.consumerID)()
+
+	sc.recordProcessor.Initialize(sc.context(), sc.initializationInput())
+	sc.status.setState(ConsumerProcessing)
+	recordCheckpointer := sc.recordProcessorCheckpointer()
+	// the lease is lost or processing failed unless the record processor has been shut down already
+	defer sc.shutdownRecordProcessor(kcl.ZOMBIE, recordCheckpointer)
+	// the flusher is stopped before the final flush at shutdown
+	defer sc.startAsyncCheckpointFlusher(recordCheckpointer)()
 
+	// the records of sparse events are collected into larger batches if batching is enabled
+	batcher := sc.newRecordBatcher()
 	var continuationSequenceNumber *string
-	refreshLeaseTimer := time.After(time.Until(sc.shard.LeaseTimeout.Add(-time.Duration(sc.kclConfig.LeaseRefreshPeriodMillis) * time.Millisecond)))
 	for {
+		// the claim request of another worker may have been seen when checkpointing or syncing the leases
+		if sc.isLeaseRequested() {
+			sc.handOffLease(recordCheckpointer)
+			return nil
+		}
+
+		// no events are read while the shard is paused by the application
+		if _, stopped, err := sc.waitWhilePaused(*sc.stop, recordCheckpointer); stopped {
+			return err
+		}
+
 		getRecordsStartTime := time.Now()
 		select {
 		case <-*sc.stop:
-			shutdownInput := &kcl.ShutdownInput{ShutdownReason: kcl.REQUESTED, Checkpointer: recordCheckpointer}
-			sc.recordProcessor.Shutdown(shutdownInput)
+			sc.shutdownRecordProcessor(kcl.REQUESTED, recordCheckpointer)
 			return nil
-		case <-refreshLeaseTimer:
-			log.Debugf("Refreshing lease on shard: %s for worker: %s", sc.shard.ID, sc.consumerID)
-			err = sc.checkpointer.GetLease(sc.shard, sc.consumerID)
-			if err != nil {
-				if errors.As(err, &chk.ErrLeaseNotAcquired{}) {
-					log.Warnf("Failed in acquiring lease on shard: %s for worker: %s", sc.shard.ID, sc.consumerID)
-					return nil
-				}
-				log.Errorf("Error in refreshing lease on shard: %s for worker: %s. Error: %+v", sc.shard.ID, sc.consumerID, err)
+		case req := <-sc.status.rewindRequests():
+			return sc.rewind(req, recordCheckpointer)
+		case req := <-sc.status.releaseRequests():
+			return sc.release(req, recordCheckpointer)
+		case <-sc.renewer.lostSignal():
+			return sc.leaseLost(recordCheckpointer)
+		case <-batcher.expired():
+			// the collected records are delivered although no more events have been received
+			if ok, err := sc.processBatch(batcher, recordCheckpointer); !ok || err != nil {
 				return err
 			}
-			refreshLeaseTimer = time.After(time.Until(sc.shard.LeaseTimeout.Add(-time.Duration(sc.kclConfig.LeaseRefreshPeriodMillis) * time.Millisecond)))
-			// log metric for renewed lease for worker
-			sc.mService.LeaseRenewed(sc.shard.ID)
 		case event, ok := <-shardSub.GetStream().Events():
 			if !ok {
 				// need to resubscribe to shard
@@ -125,49 +138,80 @@ func (sc *FanOutShardConsumer) getRecords() error {
 				continue
 			}
 			continuationSequenceNumber = subEvent.Value.ContinuationSequenceNumber
-			sc.processRecords(getRecordsStartTime, subEvent.Value.Records, subEvent.Value.MillisBehindLatest, recordCheckpointer)
+
+			// the records are collected until the batch is due, the last records of a closed shard are delivered at once
+			due := batcher.add(getRecordsStartTime, subEvent.Value.Records, subEvent.Value.MillisBehindLatest, readMetadata(subEvent.Value.Records, 0))
+			if due || continuationSequenceNumber == nil {
+				if ok, err := sc.processBatch(batcher, recordCheckpointer); !ok || err != nil {
+					return err
+				}
+			}
 
 			// The shard has been closed, so no new records can be read from it
 			if continuationSequenceNumber == nil {
 				log.Infof("Shard %s closed", sc.shard.ID)
-				shutdownInput := &kcl.ShutdownInput{ShutdownReason: kcl.TERMINATE, Checkpointer: recordCheckpointer}
-				sc.recordProcessor.Shutdown(shutdownInput)
+				sc.childShards = subEvent.Value.ChildShards
+				sc.shutdownRecordProcessor(kcl.TERMINATE, recordCheckpointer)
 				return nil
 			}
 		}
 	}
 }
 
+// processBatch delivers the records collected by the batcher in turns with the other shard consumers of the worker.
+// It returns false once the record processor has been shut down because the worker stops.
+func (sc *FanOutShardConsumer) processBatch(batcher *recordBatcher, checkpointer kcl.IRecordProcessorCheckpointer) (bool, error) {
+	// stop reading events while the processing limits of the worker are exceeded
+	if !sc.limiter.wait(*sc.stop) {
+		sc.shutdownRecordProcessor(kcl.REQUESTED, checkpointer)
+		return false, nil
+	}
+	// the events are processed in turns with the other shard consumers of the worker
+	if !sc.scheduler.acquire(*sc.stop) {
+		sc.shutdownRecordProcessor(kcl.REQUESTED, checkpointer)
+		return false, nil
+	}
+	defer sc.scheduler.release()
+
+	startTime, records, millisBehindLatest, metadata := batcher.take()
+	return true, sc.processRecords(startTime, records, millisBehindLatest, metadata, checkpointer)
+}
+
 func (sc *FanOutShardConsumer) subscribeToShard() (*kinesis.SubscribeToShardOutput, error) {
 	startPosition, err := sc.getStartingPosition()
 	if err != nil {
 		return nil, err
 	}
 
-	return sc.kc.SubscribeToShard(context.TODO(), &kinesis.SubscribeToShardInput{
+	_, span := sc.startSpan(sc.context(), "SubscribeToShard")
+	shardSub, err := sc.kc.SubscribeToShard(context.TODO(), &kinesis.SubscribeToShardInput{
 		ConsumerARN:      &sc.consumerARN,
-		ShardId:          &sc.shard.ID,
+		ShardId:          aws.String(sc.shard.GetShardID()),
 		StartingPosition: startPosition,
 	})
+	endSpan(span, err)
+	return shardSub, err
 }
 
 func (sc *FanOutShardConsumer) resubscribe(shardSub *kinesis.SubscribeToShardOutput, continuationSequence *string) (*kinesis.SubscribeToShardOutput, error) {
 	err := shardSub.GetStream().Close()
 	if err != nil {
-		sc.kclConfig.Logger.Errorf("Unable to close event stream for %s: %v", sc.shard.ID, err)
+		sc.getLogger().Errorf("Unable to close event stream for %s: %v", sc.shard.ID, err)
 		return nil, err
 	}
 	startPosition := &types.StartingPosition{
 		Type:           types.ShardIteratorTypeAfterSequenceNumber,
 		SequenceNumber: continuationSequence,
 	}
+	_, span := sc.startSpan(sc.context(), "SubscribeToShard")
 	shardSub, err = sc.kc.SubscribeToShard(context.TODO(), &kinesis.SubscribeToShardInput{
 		ConsumerARN:      &sc.consumerARN,
-		ShardId:          &sc.shard.ID,
+		ShardId:          aws.String(sc.shard.GetShardID()),
 		StartingPosition: startPosition,
 	})
+	endSpan(span, err)
 	if err != nil {
-		sc.kclConfig.Logger.Errorf("Unable to resubscribe to shard %s: %v", sc.shard.ID, err)
+		sc.getLogger().Errorf("Unable to resubscribe to shard %s: %v", sc.shard.ID, err)
 		return nil, err
 	}
 	return shardSub, nil
@@ -0,0 +1,122 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"sync"
+	"time"
+)
+
+// getRecordsBudget limits the GetRecords calls per second of all polling shard consumers of a worker, so that the
+// shards catching up together do not exceed the read limits of the stream and of the account. The calls are granted
+// in the order they have been requested, so each shard gets its share of the budget and no shard is starved by the
+// shards which are read again right after their previous call. Kinesis throttles the calls per shard, a throttled
+// shard backs off on its own without lowering the rate of the other shards. A nil budget is unlimited.
+type getRecordsBudget struct {
+	// rate is the number of calls per second
+	rate float64
+
+	mux sync.Mutex
+	// tokens is the number of calls which can be made without waiting, up to one second worth of calls
+	tokens     float64
+	lastRefill time.Time
+	// waiting are the tickets of the calls waiting for a token, in the order they have been requested
+	waiting    []uint64
+	nextTicket uint64
+}
+
+func newGetRecordsBudget(maxCallsPerSecond int) *getRecordsBudget {
+	if maxCallsPerSecond <= 0 {
+		return nil
+	}
+	return &getRecordsBudget{
+		rate:       float64(maxCallsPerSecond),
+		tokens:     float64(maxCallsPerSecond),
+		lastRefill: time.Now(),
+	}
+}
+
+// acquire blocks until a GetRecords call can be made. It returns false if the stop channel was closed while waiting.
+func (b *getRecordsBudget) acquire(stop <-chan struct{}) bool {
+	if b == nil {
+		return true
+	}
+
+	b.mux.Lock()
+	b.refill()
+	// a call is only made without waiting if nobody is queued, so shards read again right away do not overtake
+	if len(b.waiting) == 0 && b.tokens >= 1 {
+		b.tokens--
+		b.mux.Unlock()
+		return true
+	}
+	ticket := b.nextTicket
+	b.nextTicket++
+	b.waiting = append(b.waiting, ticket)
+	b.mux.Unlock()
+
+	for {
+		b.mux.Lock()
+		b.refill()
+		position := b.position(ticket)
+		if position == 0 && b.tokens >= 1 {
+			b.tokens--
+			b.waiting = b.waiting[1:]
+			b.mux.Unlock()
+			return true
+		}
+		// the calls queued ahead are granted first, the tokens may be available already while they wake up
+		delay := time.Duration((float64(position+1) - b.tokens) / b.rate * float64(time.Second))
+		if delay < time.Millisecond {
+			delay = time.Millisecond
+		}
+		b.mux.Unlock()
+
+		select {
+		case <-stop:
+			b.mux.Lock()
+			i := b.position(ticket)
+			b.waiting = append(b.waiting[:i], b.waiting[i+1:]...)
+			b.mux.Unlock()
+			return false
+		case <-time.After(delay):
+		}
+	}
+}
+
+// position returns the number of calls queued ahead of the ticket
+func (b *getRecordsBudget) position(ticket uint64) int {
+	for i, t := range b.waiting {
+		if t == ticket {
+			return i
+		}
+	}
+	return len(b.waiting)
+}
+
+// refill adds the tokens for the time passed since the last refill, up to one second worth of calls
+func (b *getRecordsBudget) refill() {
+	now := time.Now()
+	b.tokens += now.Sub(b.lastRefill).Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.lastRefill = now
+}
@@ -0,0 +1,90 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetRecordsBudgetLimitsRate(t *testing.T) {
+	assert.Nil(t, newGetRecordsBudget(0))
+	assert.True(t, (*getRecordsBudget)(nil).acquire(nil))
+
+	b := newGetRecordsBudget(100)
+	start := time.Now()
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 15; j++ {
+				assert.True(t, b.acquire(nil))
+			}
+		}()
+	}
+	wg.Wait()
+
+	// one second worth of calls is granted at once, the others at the rate of the budget
+	elapsed := time.Since(start)
+	assert.GreaterOrEqual(t, elapsed, 400*time.Millisecond)
+	assert.Less(t, elapsed, 2*time.Second)
+	assert.Equal(t, 0, len(b.waiting))
+}
+
+func TestGetRecordsBudgetIsFair(t *testing.T) {
+	b := newGetRecordsBudget(10)
+	b.tokens = 0
+
+	var mux sync.Mutex
+	var granted []int
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.True(t, b.acquire(nil))
+			mux.Lock()
+			granted = append(granted, i)
+			mux.Unlock()
+		}(i)
+		// the calls are queued one after the other before the first token is available
+		assert.Eventually(t, func() bool {
+			b.mux.Lock()
+			defer b.mux.Unlock()
+			return len(b.waiting) == i+1
+		}, time.Second, time.Millisecond)
+	}
+	wg.Wait()
+	assert.Equal(t, []int{0, 1, 2, 3, 4}, granted)
+}
+
+func TestGetRecordsBudgetStop(t *testing.T) {
+	b := newGetRecordsBudget(1)
+	b.tokens = 0
+
+	stop := make(chan struct{})
+	close(stop)
+	assert.False(t, b.acquire(stop))
+	assert.Equal(t, 0, len(b.waiting))
+}
@@ -0,0 +1,185 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	"github.com/vmware/vmware-go-kcl-v2/logger"
+)
+
+type (
+	// throughputSample is the count of the records and bytes processed by the consumer of a shard
+	throughputSample struct {
+		shard   config.HotShard
+		records int64
+		bytes   int64
+	}
+
+	// observedThroughput is a throughput sample of a shard and the time it has been taken
+	observedThroughput struct {
+		records int64
+		bytes   int64
+		at      time.Time
+	}
+
+	// hotShardTracker aggregates the throughput of the shards consumed by a worker over the report intervals and
+	// reports the hottest shards of each interval
+	hotShardTracker struct {
+		topN    int
+		handler config.HotShardsHandler
+		log     logger.Logger
+
+		// observed is only used by the goroutine reporting the hot shards
+		observed map[string]observedThroughput
+
+		mux sync.Mutex
+		hot []config.HotShard
+	}
+)
+
+// newHotShardTracker returns nil unless the hot shards are reported
+func newHotShardTracker(kclConfig *config.KinesisClientLibConfiguration, log logger.Logger) *hotShardTracker {
+	if kclConfig.HotShardsReportIntervalMillis == 0 {
+		return nil
+	}
+	return &hotShardTracker{
+		topN:     kclConfig.HotShardsTopN,
+		handler:  kclConfig.HotShardsHandler,
+		log:      log,
+		observed: make(map[string]observedThroughput),
+	}
+}
+
+// report computes the throughput of the shards since their last samples and reports the topN hottest shards. The
+// throughput of a shard is known once it has been sampled twice by the same consumer, the counters of a consumer
+// start at 0 when the lease is gained.
+func (t *hotShardTracker) report(now time.Time, samples []throughputSample) []config.HotShard {
+	observed := make(map[string]observedThroughput, len(samples))
+	shards := make([]config.HotShard, 0, len(samples))
+	for _, sample := range samples {
+		id := sample.shard.ShardID
+		observed[id] = observedThroughput{records: sample.records, bytes: sample.bytes, at: now}
+
+		last, ok := t.observed[id]
+		elapsed := now.Sub(last.at).Seconds()
+		if !ok || elapsed <= 0 || sample.records < last.records || sample.bytes < last.bytes {
+			continue
+		}
+		shard := sample.shard
+		shard.RecordsPerSecond = float64(sample.records-last.records) / elapsed
+		shard.BytesPerSecond = float64(sample.bytes-last.bytes) / elapsed
+		shards = append(shards, shard)
+	}
+	t.observed = observed
+
+	sort.Slice(shards, func(i, j int) bool {
+		if shards[i].BytesPerSecond != shards[j].BytesPerSecond {
+			return shards[i].BytesPerSecond > shards[j].BytesPerSecond
+		}
+		if shards[i].RecordsPerSecond != shards[j].RecordsPerSecond {
+			return shards[i].RecordsPerSecond > shards[j].RecordsPerSecond
+		}
+		return shards[i].ShardID < shards[j].ShardID
+	})
+	if len(shards) > t.topN {
+		shards = shards[:t.topN]
+	}
+
+	t.mux.Lock()
+	t.hot = shards
+	t.mux.Unlock()
+
+	if len(shards) > 0 {
+		t.log.Infof("Hot shards: %s", formatHotShards(shards))
+	}
+	if t.handler != nil {
+		t.handler(shards)
+	}
+	return shards
+}
+
+// hottest returns the hot shards of the last report
+func (t *hotShardTracker) hottest() []config.HotShard {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	return append([]config.HotShard(nil), t.hot...)
+}
+
+func formatHotShards(shards []config.HotShard) string {
+	formatted := make([]string, 0, len(shards))
+	for _, shard := range shards {
+		formatted = append(formatted, fmt.Sprintf("%s (%.1f records/s, %.1f bytes/s, hash keys %s-%s)",
+			shard.ShardID, shard.RecordsPerSecond, shard.BytesPerSecond, shard.StartingHashKey, shard.EndingHashKey))
+	}
+	return strings.Join(formatted, ", ")
+}
+
+// HotShards returns the HotShardsTopN shards with the highest throughput in bytes over the last report interval,
+// nil unless HotShardsReportIntervalMillis is set
+func (w *Worker) HotShards() []config.HotShard {
+	if w.hotShards == nil {
+		return nil
+	}
+	return w.hotShards.hottest()
+}
+
+// throughputSamples returns the records and bytes processed by the shard consumers of the worker
+func (w *Worker) throughputSamples() []throughputSample {
+	w.statusMux.RLock()
+	defer w.statusMux.RUnlock()
+
+	samples := make([]throughputSample, 0, len(w.consumers))
+	for _, consumer := range w.consumers {
+		consumer.Lock()
+		samples = append(samples, throughputSample{
+			shard: config.HotShard{
+				ShardID:         consumer.shard.ID,
+				StreamName:      consumer.shard.StreamName,
+				StartingHashKey: consumer.shard.StartingHashKey,
+				EndingHashKey:   consumer.shard.EndingHashKey,
+			},
+			records: consumer.recordsProcessed,
+			bytes:   consumer.bytesProcessed,
+		})
+		consumer.Unlock()
+	}
+	return samples
+}
+
+// reportHotShards reports the hot shards every HotShardsReportIntervalMillis until the worker is shut down
+func (w *Worker) reportHotShards() {
+	ticker := time.NewTicker(time.Duration(w.kclConfig.HotShardsReportIntervalMillis) * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-*w.stop:
+			return
+		case now := <-ticker.C:
+			w.hotShards.report(now, w.throughputSamples())
+		}
+	}
+}
@@ -0,0 +1,80 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+)
+
+func TestHotShardTracker(t *testing.T) {
+	var reported [][]config.HotShard
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+		WithHotShardsReport(10000, 2).
+		WithHotShardsHandler(func(shards []config.HotShard) { reported = append(reported, shards) })
+	assert.Nil(t, kclConfig.Validate())
+	tracker := newHotShardTracker(kclConfig, kclConfig.Logger)
+
+	sample := func(id, start, end string, records, bytes int64) throughputSample {
+		return throughputSample{
+			shard:   config.HotShard{ShardID: id, StartingHashKey: start, EndingHashKey: end},
+			records: records,
+			bytes:   bytes,
+		}
+	}
+
+	// the throughput is known from the second sample
+	start := time.Now()
+	assert.Empty(t, tracker.report(start, []throughputSample{
+		sample("shardId-0", "0", "99", 100, 1000),
+		sample("shardId-1", "100", "199", 100, 1000),
+	}))
+
+	hot := tracker.report(start.Add(10*time.Second), []throughputSample{
+		sample("shardId-0", "0", "99", 200, 11000),
+		sample("shardId-1", "100", "199", 1100, 6000),
+		sample("shardId-2", "200", "299", 500, 500000),
+	})
+	assert.Equal(t, []config.HotShard{
+		{ShardID: "shardId-0", StartingHashKey: "0", EndingHashKey: "99", RecordsPerSecond: 10, BytesPerSecond: 1000},
+		{ShardID: "shardId-1", StartingHashKey: "100", EndingHashKey: "199", RecordsPerSecond: 100, BytesPerSecond: 500},
+	}, hot)
+	assert.Equal(t, hot, tracker.hottest())
+
+	// only the top N shards are reported, the counters of a shard leased again start over
+	hot = tracker.report(start.Add(20*time.Second), []throughputSample{
+		sample("shardId-0", "0", "99", 10, 100),
+		sample("shardId-1", "100", "199", 1200, 7000),
+		sample("shardId-2", "200", "299", 1500, 1500000),
+	})
+	assert.Equal(t, 2, len(hot))
+	assert.Equal(t, "shardId-2", hot[0].ShardID)
+	assert.Equal(t, float64(100000), hot[0].BytesPerSecond)
+	assert.Equal(t, "shardId-1", hot[1].ShardID)
+	assert.Equal(t, 3, len(reported))
+
+	// the report is disabled by default
+	assert.Nil(t, newHotShardTracker(config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker"), kclConfig.Logger))
+	assert.Nil(t, (&Worker{}).HotShards())
+}
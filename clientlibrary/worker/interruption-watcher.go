@@ -0,0 +1,185 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package worker
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+)
+
+const (
+	// spotInstanceActionPath is the path of the instance metadata announcing the interruption of a spot instance
+	spotInstanceActionPath = "spot/instance-action"
+
+	// DefaultSpotInterruptionPollInterval is the default interval between the instance metadata requests of
+	// SpotInterruptionWatcher
+	DefaultSpotInterruptionPollInterval = 5 * time.Second
+)
+
+type (
+	// InterruptionWatcher announces the imminent termination of the host of a worker, e.g. the interruption of
+	// a spot instance or the scale-in of an ECS service or Kubernetes deployment. The worker shuts down gracefully
+	// once interrupted, so that its leases are released with a final checkpoint and taken over by the other workers
+	// right away instead of after their expiry.
+	InterruptionWatcher interface {
+		// Watch blocks until the host is about to be terminated and returns the reason of the interruption. It
+		// returns the error of ctx once ctx is done.
+		Watch(ctx context.Context) (string, error)
+	}
+
+	// SignalInterruptionWatcher is interrupted by the signals sent to the process. ECS and Kubernetes send SIGTERM
+	// to the containers of tasks and pods which are stopped, e.g. on scale-in or when draining a spot instance.
+	SignalInterruptionWatcher struct {
+		signals []os.Signal
+	}
+
+	// SpotInterruptionWatcher polls the instance metadata service of EC2 for the interruption notice of a spot
+	// instance, which is issued two minutes before the instance is stopped or terminated.
+	SpotInterruptionWatcher struct {
+		client       *imds.Client
+		pollInterval time.Duration
+	}
+
+	// spotInstanceAction is the interruption notice of a spot instance
+	spotInstanceAction struct {
+		Action string `json:"action"`
+		Time   string `json:"time"`
+	}
+)
+
+// NewSignalInterruptionWatcher returns a watcher interrupted by the signals, SIGTERM if none are given. The signals
+// are also delivered to the other channels registered by signal.Notify, but they do not terminate the process
+// anymore, the application has to exit once the worker has shut down.
+func NewSignalInterruptionWatcher(signals ...os.Signal) *SignalInterruptionWatcher {
+	if len(signals) == 0 {
+		signals = []os.Signal{syscall.SIGTERM}
+	}
+	return &SignalInterruptionWatcher{signals: signals}
+}
+
+// Watch blocks until one of the signals is received
+func (s *SignalInterruptionWatcher) Watch(ctx context.Context) (string, error) {
+	received := make(chan os.Signal, 1)
+	signal.Notify(received, s.signals...)
+	defer signal.Stop(received)
+
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case sig := <-received:
+		return fmt.Sprintf("received signal %v", sig), nil
+	}
+}
+
+// NewSpotInterruptionWatcher returns a watcher polling the instance metadata by the client every poll interval, nil
+// creates the default client and a non-positive interval defaults to DefaultSpotInterruptionPollInterval.
+func NewSpotInterruptionWatcher(client *imds.Client, pollInterval time.Duration) *SpotInterruptionWatcher {
+	if client == nil {
+		client = imds.New(imds.Options{})
+	}
+	if pollInterval <= 0 {
+		pollInterval = DefaultSpotInterruptionPollInterval
+	}
+	return &SpotInterruptionWatcher{client: client, pollInterval: pollInterval}
+}
+
+// Watch blocks until the interruption notice of the spot instance is issued. Failing requests are retried at the
+// next poll, the instance metadata service may not be reachable temporarily.
+func (s *SpotInterruptionWatcher) Watch(ctx context.Context) (string, error) {
+	ticker := time.NewTicker(s.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		if action, err := s.instanceAction(ctx); err == nil && action != nil {
+			return fmt.Sprintf("spot instance %s at %s", action.Action, action.Time), nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// instanceAction returns the interruption notice of the spot instance, nil if it has not been issued
+func (s *SpotInterruptionWatcher) instanceAction(ctx context.Context) (*spotInstanceAction, error) {
+	output, err := s.client.GetMetadata(ctx, &imds.GetMetadataInput{Path: spotInstanceActionPath})
+	if err != nil {
+		// the instance action is not found until the notice is issued
+		var statusErr interface{ HTTPStatusCode() int }
+		if errors.As(err, &statusErr) && statusErr.HTTPStatusCode() == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer output.Content.Close()
+
+	content, err := io.ReadAll(output.Content)
+	if err != nil {
+		return nil, err
+	}
+	action := &spotInstanceAction{}
+	if err := json.Unmarshal(content, action); err != nil {
+		return nil, err
+	}
+	return action, nil
+}
+
+// WithInterruptionWatchers sets the watchers announcing the termination of the host of the worker, which shuts down
+// gracefully within ShutdownGraceMillis once the first of them is interrupted. The watchers have to be set before
+// the worker is started.
+func (w *Worker) WithInterruptionWatchers(watchers ...InterruptionWatcher) *Worker {
+	w.interruptionWatchers = watchers
+	return w
+}
+
+// watchInterruptions shuts down the worker once one of the interruption watchers is interrupted
+func (w *Worker) watchInterruptions() {
+	ctx, cancel := context.WithCancel(w.ctx)
+	defer cancel()
+
+	interrupted := make(chan string, len(w.interruptionWatchers))
+	for _, watcher := range w.interruptionWatchers {
+		go func(watcher InterruptionWatcher) {
+			if reason, err := watcher.Watch(ctx); err == nil {
+				interrupted <- reason
+			}
+		}(watcher)
+	}
+
+	select {
+	case <-*w.stop:
+	case reason := <-interrupted:
+		w.log.Warnf("Worker is interrupted: %s, shutting down and releasing the leases.", reason)
+		w.Shutdown()
+	}
+}
@@ -0,0 +1,127 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+)
+
+type interruptionFunc func(ctx context.Context) (string, error)
+
+func (f interruptionFunc) Watch(ctx context.Context) (string, error) {
+	return f(ctx)
+}
+
+func TestSpotInterruptionWatcher(t *testing.T) {
+	var mux sync.Mutex
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/latest/api/token" {
+			w.Header().Set("X-Aws-Ec2-Metadata-Token-Ttl-Seconds", "21600")
+			_, _ = w.Write([]byte("token"))
+			return
+		}
+
+		mux.Lock()
+		defer mux.Unlock()
+		assert.Equal(t, "/latest/meta-data/spot/instance-action", r.URL.Path)
+		assert.Equal(t, "token", r.Header.Get("X-Aws-Ec2-Metadata-Token"))
+		// the notice is issued by the third request
+		requests++
+		if requests < 3 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{"action": "terminate", "time": "2026-10-14T08:22:00Z"}`))
+	}))
+	defer server.Close()
+
+	watcher := NewSpotInterruptionWatcher(imds.New(imds.Options{Endpoint: server.URL}), time.Millisecond)
+	reason, err := watcher.Watch(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "spot instance terminate at 2026-10-14T08:22:00Z", reason)
+
+	// the watcher returns once the context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = NewSpotInterruptionWatcher(imds.New(imds.Options{Endpoint: "http://127.0.0.1:1"}), time.Millisecond).Watch(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestSignalInterruptionWatcher(t *testing.T) {
+	watcher := NewSignalInterruptionWatcher(syscall.SIGUSR1)
+	result := make(chan string)
+	go func() {
+		reason, _ := watcher.Watch(context.Background())
+		result <- reason
+	}()
+
+	// the signal is sent until the watcher has registered for it
+	for {
+		assert.Nil(t, syscall.Kill(syscall.Getpid(), syscall.SIGUSR1))
+		select {
+		case reason := <-result:
+			assert.Equal(t, "received signal user defined signal 1", reason)
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestWorkerShutsDownOnInterruption(t *testing.T) {
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker")
+	interrupt := make(chan struct{})
+	w := NewWorker(shutdownRecorderFactory{}, kclConfig).WithInterruptionWatchers(
+		interruptionFunc(func(ctx context.Context) (string, error) {
+			<-ctx.Done()
+			return "", ctx.Err()
+		}),
+		interruptionFunc(func(ctx context.Context) (string, error) {
+			<-interrupt
+			return "spot instance terminate", nil
+		}),
+	)
+
+	stop := make(chan struct{})
+	w.stop = &stop
+	w.waitGroup = &sync.WaitGroup{}
+	w.ctx, w.cancel = context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.watchInterruptions()
+		close(done)
+	}()
+
+	close(interrupt)
+	<-done
+	assert.True(t, w.done)
+	assert.NotNil(t, w.ctx.Err())
+}
@@ -0,0 +1,194 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/test"
+)
+
+func TestSyncShardWithMockKinesis(t *testing.T) {
+	kc := &test.MockKinesisAPI{}
+	kc.On("ListShards", mock.Anything, &kinesis.ListShardsInput{StreamName: aws.String("stream")}).Return(&kinesis.ListShardsOutput{
+		Shards: []types.Shard{{
+			ShardId:             aws.String("shardId-0"),
+			SequenceNumberRange: &types.SequenceNumberRange{StartingSequenceNumber: aws.String("0")},
+			HashKeyRange: &types.HashKeyRange{
+				StartingHashKey: aws.String("0"),
+				EndingHashKey:   aws.String("340282366920938463463374607431768211455"),
+			},
+		}},
+		NextToken: aws.String("page-2"),
+	}, nil)
+	kc.On("ListShards", mock.Anything, &kinesis.ListShardsInput{NextToken: aws.String("page-2")}).Return(&kinesis.ListShardsOutput{
+		Shards: []types.Shard{{
+			ShardId:             aws.String("shardId-1"),
+			ParentShardId:       aws.String("shardId-0"),
+			SequenceNumberRange: &types.SequenceNumberRange{StartingSequenceNumber: aws.String("1")},
+		}},
+	}, nil)
+
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker")
+	w := NewWorker(shutdownRecorderFactory{}, kclConfig).WithKinesis(kc).WithCheckpointer(newMockCheckpointer())
+	w.shardStatus = map[string]*par.ShardStatus{}
+
+	assert.Nil(t, w.syncShard())
+	assert.Equal(t, 2, len(w.shardStatus))
+	assert.Equal(t, "shardId-0", w.shardStatus["shardId-1"].ParentShardId)
+	assert.Equal(t, "0", w.shardStatus["shardId-0"].StartingHashKey)
+	assert.Equal(t, "340282366920938463463374607431768211455", w.shardStatus["shardId-0"].EndingHashKey)
+	assert.Equal(t, "", w.shardStatus["shardId-1"].StartingHashKey)
+
+	sc := &commonShardConsumer{shard: w.shardStatus["shardId-0"], kclConfig: kclConfig}
+	input := sc.initializationInput()
+	assert.Equal(t, "0", input.StartingHashKey)
+	assert.Equal(t, "340282366920938463463374607431768211455", input.EndingHashKey)
+	assert.Equal(t, "0", input.StartingSequenceNumber)
+	assert.Equal(t, "", input.EndingSequenceNumber)
+	kc.AssertExpectations(t)
+}
+
+func TestSyncShardWithFakeKinesis(t *testing.T) {
+	kc := test.NewFakeKinesis()
+	kc.CreateStream("stream", 2)
+	assert.Nil(t, kc.SplitShard("stream", "shardId-000000000001", "255211775190703847597530955573826158592"))
+
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker")
+	w := NewWorker(shutdownRecorderFactory{}, kclConfig).WithKinesis(kc).WithCheckpointer(newMockCheckpointer())
+	w.shardStatus = map[string]*par.ShardStatus{}
+
+	assert.Nil(t, w.syncShard())
+	assert.Equal(t, 4, len(w.shardStatus))
+	assert.Equal(t, "shardId-000000000001", w.shardStatus["shardId-000000000002"].ParentShardId)
+	assert.Equal(t, "shardId-000000000001", w.shardStatus["shardId-000000000003"].ParentShardId)
+	assert.NotEqual(t, "", w.shardStatus["shardId-000000000001"].EndingSequenceNumber)
+}
+
+func TestSyncShardRefreshesSequenceNumberRanges(t *testing.T) {
+	kc := test.NewFakeKinesis()
+	kc.CreateStream("stream", 1)
+
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker")
+	w := NewWorker(shutdownRecorderFactory{}, kclConfig).WithKinesis(kc).WithCheckpointer(newMockCheckpointer())
+	w.shardStatus = map[string]*par.ShardStatus{}
+
+	assert.Nil(t, w.syncShard())
+	parent := w.shardStatus["shardId-000000000000"]
+	_, ending := parent.GetSequenceNumberRange()
+	assert.Equal(t, "", ending)
+
+	// the child shard is known from the ChildShards of its parent before it is listed
+	assert.Nil(t, kc.SplitShard("stream", "shardId-000000000000", "170141183460469231731687303715884105728"))
+	w.addChildShards([]closedShard{{
+		shard:    parent,
+		children: []types.ChildShard{{ShardId: aws.String("shardId-000000000001"), ParentShards: []string{"shardId-000000000000"}}},
+	}})
+	starting, _ := w.shardStatus["shardId-000000000001"].GetSequenceNumberRange()
+	assert.Equal(t, "", starting)
+
+	assert.Nil(t, w.syncShard())
+	_, ending = parent.GetSequenceNumberRange()
+	assert.NotEqual(t, "", ending)
+	starting, _ = w.shardStatus["shardId-000000000001"].GetSequenceNumberRange()
+	assert.NotEqual(t, "", starting)
+}
+
+func TestPinnedShards(t *testing.T) {
+	kc := test.NewFakeKinesis()
+	kc.CreateStream("stream", 3)
+	assert.Nil(t, kc.SplitShard("stream", "shardId-000000000001", "170141183460469231731687303715884105728"))
+
+	// without lease coordination the leases are kept in memory
+	kclConfig := config.NewKinesisClientLibConfig("pinned", "stream", "us-west-2", "worker").
+		WithPinnedShards(false, "shardId-000000000001")
+	w := NewWorker(shutdownRecorderFactory{}, kclConfig).WithKinesis(kc)
+	assert.Nil(t, w.initialize())
+	assert.IsType(t, &chk.MemoryCheckpoint{}, w.checkpointer.(*monitoredCheckpointer).Checkpointer)
+
+	// neither the other shards nor the child shards are consumed
+	assert.Nil(t, w.syncShard())
+	assert.Equal(t, 1, len(w.shardStatus))
+	assert.Contains(t, w.shardStatus, "shardId-000000000001")
+	acquired := w.acquireLeases()
+	assert.Equal(t, 1, len(acquired))
+	assert.Equal(t, "worker", acquired[0].GetLeaseOwner())
+
+	// a pinned child shard is leased although its parent is not consumed
+	kclConfig = config.NewKinesisClientLibConfig("pinned", "stream", "us-west-2", "worker").
+		WithPinnedShards(false, "shardId-000000000003")
+	w = NewWorker(shutdownRecorderFactory{}, kclConfig).WithKinesis(kc)
+	assert.Nil(t, w.initialize())
+	assert.Nil(t, w.syncShard())
+	assert.Equal(t, 1, len(w.shardStatus))
+	assert.Equal(t, "shardId-000000000001", w.shardStatus["shardId-000000000003"].ParentShardId)
+	acquired = w.acquireLeases()
+	assert.Equal(t, 1, len(acquired))
+	assert.Equal(t, "shardId-000000000003", acquired[0].ID)
+
+	// with lease coordination the configured checkpointer is used
+	kclConfig = config.NewKinesisClientLibConfig("pinned", "stream", "us-west-2", "worker").
+		WithPinnedShards(true, "shardId-000000000000", "shardId-000000000002")
+	checkpointer := newMockCheckpointer()
+	w = NewWorker(shutdownRecorderFactory{}, kclConfig).WithKinesis(kc).WithCheckpointer(checkpointer)
+	w.shardStatus = map[string]*par.ShardStatus{}
+	assert.Nil(t, w.syncShard())
+	assert.Equal(t, 2, len(w.shardStatus))
+	assert.Contains(t, w.shardStatus, "shardId-000000000000")
+	assert.Contains(t, w.shardStatus, "shardId-000000000002")
+}
+
+func TestFetchConsumerARNWithMockKinesis(t *testing.T) {
+	streamARN := "arn:aws:kinesis:us-west-2:123456789012:stream/stream"
+	consumerARN := streamARN + "/consumer/app:1"
+
+	kc := &test.MockKinesisAPI{}
+	kc.On("DescribeStreamSummary", mock.Anything, &kinesis.DescribeStreamSummaryInput{StreamName: aws.String("stream")}).
+		Return(&kinesis.DescribeStreamSummaryOutput{
+			StreamDescriptionSummary: &types.StreamDescriptionSummary{StreamARN: aws.String(streamARN)},
+		}, nil)
+	// the consumer is registered if it is not found
+	kc.On("DescribeStreamConsumer", mock.Anything, mock.Anything).
+		Return(nil, &types.ResourceNotFoundException{Message: aws.String("not found")})
+	kc.On("RegisterStreamConsumer", mock.Anything, &kinesis.RegisterStreamConsumerInput{
+		ConsumerName: aws.String("app"),
+		StreamARN:    aws.String(streamARN),
+	}).Return(&kinesis.RegisterStreamConsumerOutput{
+		Consumer: &types.Consumer{ConsumerARN: aws.String(consumerARN), ConsumerStatus: types.ConsumerStatusActive},
+	}, nil)
+
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+		WithEnhancedFanOutConsumerName("app")
+	w := NewWorker(shutdownRecorderFactory{}, kclConfig).WithKinesis(kc)
+
+	arn, err := w.fetchConsumerARN(w.kc, "stream", "")
+	assert.Nil(t, err)
+	assert.Equal(t, consumerARN, arn)
+	kc.AssertExpectations(t)
+}
@@ -0,0 +1,114 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type (
+	// kinesisHandler returns the output of a Kinesis operation for its decoded JSON input, or a kinesisError to fail
+	// the request
+	kinesisHandler func(input map[string]interface{}) interface{}
+
+	// kinesisHandlers are the handlers of the operations served by a fake Kinesis endpoint by operation name, e.g.
+	// ListShards
+	kinesisHandlers map[string]kinesisHandler
+
+	// kinesisError is returned by a kinesisHandler to fail the request with an error of Kinesis
+	kinesisError struct {
+		status  int
+		errType string
+		message string
+	}
+)
+
+// newKinesisServer returns a fake Kinesis endpoint serving the JSON API with the handlers, the requests of the other
+// operations fail the test
+func newKinesisServer(t *testing.T, handlers kinesisHandlers) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var input map[string]interface{}
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&input))
+
+		operation := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "Kinesis_20131202.")
+		handler, ok := handlers[operation]
+		if !ok {
+			t.Errorf("unexpected request %s", operation)
+			handler = func(map[string]interface{}) interface{} {
+				return kinesisError{status: http.StatusBadRequest, errType: "InvalidArgumentException", message: "unexpected request"}
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		output := handler(input)
+		if err, ok := output.(kinesisError); ok {
+			w.WriteHeader(err.status)
+			output = map[string]string{"__type": err.errType, "message": err.message}
+		}
+		assert.Nil(t, json.NewEncoder(w).Encode(output))
+	}))
+}
+
+// listShards returns a handler of ListShards listing the shards
+func listShards(shards ...map[string]interface{}) kinesisHandler {
+	return func(map[string]interface{}) interface{} {
+		return map[string]interface{}{"Shards": append([]map[string]interface{}{}, shards...)}
+	}
+}
+
+// testShard returns a shard of a ListShards output, the shard is closed if it has an ending sequence number
+func testShard(shardID, endingSequenceNumber string) map[string]interface{} {
+	sequenceNumberRange := map[string]string{"StartingSequenceNumber": "0"}
+	if endingSequenceNumber != "" {
+		sequenceNumberRange["EndingSequenceNumber"] = endingSequenceNumber
+	}
+	return map[string]interface{}{"ShardId": shardID, "SequenceNumberRange": sequenceNumberRange}
+}
+
+// shardIterator returns a handler of GetShardIterator returning the iterator
+func shardIterator(iterator string) kinesisHandler {
+	return func(map[string]interface{}) interface{} {
+		return map[string]interface{}{"ShardIterator": iterator}
+	}
+}
+
+// kinesisRecord returns a record of a GetRecords output with the partition key pk and the base64 encoded data
+func kinesisRecord(sequenceNumber, data string) map[string]string {
+	return map[string]string{"SequenceNumber": sequenceNumber, "PartitionKey": "pk", "Data": data}
+}
+
+// getRecordsOutput returns a GetRecords output caught up with the shard, the end of a closed shard has been reached
+// without next shard iterator
+func getRecordsOutput(nextShardIterator interface{}, records ...map[string]string) map[string]interface{} {
+	output := map[string]interface{}{
+		"Records":            append([]map[string]string{}, records...),
+		"MillisBehindLatest": 0,
+	}
+	if nextShardIterator != nil {
+		output["NextShardIterator"] = nextShardIterator
+	}
+	return output
+}
@@ -0,0 +1,135 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"sync"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/metrics"
+)
+
+// Lag is how far the shard consumers of a worker are behind the tips of their shards
+type Lag struct {
+	// Shards is the MillisBehindLatest of the last batch of records per consumed shard
+	Shards map[string]int64 `json:"shards"`
+
+	// MaxMillisBehindLatest is the max lag of the consumed shards, 0 if the worker does not consume any shard
+	MaxMillisBehindLatest int64 `json:"maxMillisBehindLatest"`
+}
+
+// lagTracker keeps the lag of the shards consumed by a worker. It reports the max lag to the monitoring service
+// and calls the lag handler once the lag of a shard exceeds the threshold.
+type lagTracker struct {
+	mService  metrics.MonitoringService
+	threshold int64
+	handler   config.LagHandler
+
+	mux      sync.Mutex
+	shards   map[string]int64
+	exceeded map[string]bool
+}
+
+func newLagTracker(kclConfig *config.KinesisClientLibConfiguration, mService metrics.MonitoringService) *lagTracker {
+	return &lagTracker{
+		mService:  mService,
+		threshold: kclConfig.LagThresholdMillis,
+		handler:   kclConfig.LagHandler,
+		shards:    make(map[string]int64),
+		exceeded:  make(map[string]bool),
+	}
+}
+
+// update records the lag of the last batch of records of the shard
+func (t *lagTracker) update(shardID string, millisBehindLatest int64) {
+	if t == nil {
+		return
+	}
+
+	t.mux.Lock()
+	t.shards[shardID] = millisBehindLatest
+	// the max lag is reported under the lock, so that concurrent updates are reported in order
+	t.reportMaxLag()
+	// the handler is called when the threshold is crossed rather than for every batch above it
+	exceeded := t.handler != nil && millisBehindLatest > t.threshold
+	crossed := exceeded && !t.exceeded[shardID]
+	if exceeded {
+		t.exceeded[shardID] = true
+	} else {
+		delete(t.exceeded, shardID)
+	}
+	t.mux.Unlock()
+
+	if crossed {
+		t.handler(shardID, millisBehindLatest)
+	}
+}
+
+// remove forgets the lag of a shard which is no longer consumed by the worker
+func (t *lagTracker) remove(shardID string) {
+	if t == nil {
+		return
+	}
+
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	delete(t.shards, shardID)
+	delete(t.exceeded, shardID)
+	t.reportMaxLag()
+}
+
+// reportMaxLag reports the max lag of the shards if the MonitoringService supports it, the caller holds the lock
+func (t *lagTracker) reportMaxLag() {
+	if m, ok := t.mService.(metrics.LagMonitoringService); ok {
+		m.MaxMillisBehindLatest(float64(t.maxLag()))
+	}
+}
+
+// maxLag returns the max lag of the shards, the caller holds the lock
+func (t *lagTracker) maxLag() int64 {
+	var maxLag int64
+	for _, millisBehindLatest := range t.shards {
+		if millisBehindLatest > maxLag {
+			maxLag = millisBehindLatest
+		}
+	}
+	return maxLag
+}
+
+func (t *lagTracker) lag() Lag {
+	lag := Lag{Shards: make(map[string]int64)}
+	if t == nil {
+		return lag
+	}
+
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	for shardID, millisBehindLatest := range t.shards {
+		lag.Shards[shardID] = millisBehindLatest
+	}
+	lag.MaxMillisBehindLatest = t.maxLag()
+	return lag
+}
+
+// Lag returns how far the shard consumers of the worker are behind the tips of their shards, as reported by
+// Kinesis with the last batch of records of every shard.
+func (w *Worker) Lag() Lag {
+	return w.lag.lag()
+}
@@ -0,0 +1,74 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/metrics"
+)
+
+// maxLagRecorder records the max lag reported to the monitoring service
+type maxLagRecorder struct {
+	metrics.NoopMonitoringService
+	reported []float64
+}
+
+func (r *maxLagRecorder) MaxMillisBehindLatest(milliSeconds float64) {
+	r.reported = append(r.reported, milliSeconds)
+}
+
+func TestLagTracker(t *testing.T) {
+	type exceeded struct {
+		shardID            string
+		millisBehindLatest int64
+	}
+	var calls []exceeded
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+		WithLagThreshold(1000, func(shardID string, millisBehindLatest int64) {
+			calls = append(calls, exceeded{shardID, millisBehindLatest})
+		})
+	mService := &maxLagRecorder{}
+	w := NewWorker(shutdownRecorderFactory{}, kclConfig)
+	assert.Equal(t, Lag{Shards: map[string]int64{}}, w.Lag())
+
+	w.lag = newLagTracker(kclConfig, mService)
+	w.lag.update("shardId-0", 500)
+	w.lag.update("shardId-1", 2000)
+	w.lag.update("shardId-1", 3000)
+	w.lag.update("shardId-0", 1500)
+	assert.Equal(t, Lag{
+		Shards:                map[string]int64{"shardId-0": 1500, "shardId-1": 3000},
+		MaxMillisBehindLatest: 3000,
+	}, w.Lag())
+
+	// the handler is called again once the lag has recovered and exceeds the threshold again
+	w.lag.update("shardId-1", 1000)
+	w.lag.update("shardId-1", 1200)
+	assert.Equal(t, []exceeded{{"shardId-1", 2000}, {"shardId-0", 1500}, {"shardId-1", 1200}}, calls)
+
+	w.lag.remove("shardId-0")
+	w.lag.remove("shardId-1")
+	assert.Equal(t, Lag{Shards: map[string]int64{}}, w.Lag())
+	assert.Equal(t, []float64{500, 2000, 3000, 3000, 1500, 1500, 1200, 0}, mService.reported)
+}
@@ -0,0 +1,71 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package worker
+package worker
+
+import (
+	"context"
+	"time"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/leaseaudit"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+	"github.com/vmware/vmware-go-kcl-v2/logger"
+)
+
+// leaseAuditTimeout bounds the time the worker and the shard consumers wait for the LeaseAuditSink
+const leaseAuditTimeout = 5 * time.Second
+
+// recordLeaseEvent records a transition of the lease of the shard to the LeaseAuditSink. A failure is logged, the
+// processing of the shard goes on.
+func recordLeaseEvent(kclConfig *config.KinesisClientLibConfiguration, log logger.Logger, eventType leaseaudit.EventType,
+	shard *par.ShardStatus, previousOwner string) {
+	sink := kclConfig.LeaseAuditSink
+	if sink == nil {
+		return
+	}
+
+	event := &leaseaudit.Event{
+		Type:          eventType,
+		Time:          time.Now().UTC(),
+		WorkerID:      kclConfig.WorkerID,
+		ShardID:       shard.ID,
+		Checkpoint:    shard.GetCheckpoint(),
+		PreviousOwner: previousOwner,
+	}
+	// the events of the consumers shutting down with the worker are recorded as well
+	ctx, cancel := context.WithTimeout(context.Background(), leaseAuditTimeout)
+	defer cancel()
+	if err := sink.Record(ctx, event); err != nil {
+		log.Errorf("Failed to record the %s event of the lease of shard %s: %+v", eventType, shard.ID, err)
+	}
+}
+
+// acquisitionEvent returns the transition of a lease acquired from its previous owner
+func acquisitionEvent(previousOwner, workerID string, stolen bool) leaseaudit.EventType {
+	switch {
+	case stolen:
+		return leaseaudit.LeaseStolen
+	case previousOwner != "" && previousOwner != workerID:
+		return leaseaudit.LeaseExpired
+	default:
+		return leaseaudit.LeaseAcquired
+	}
+}
@@ -0,0 +1,105 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/leaseaudit"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+// leaseEventRecorder records the lease events of a worker
+type leaseEventRecorder struct {
+	mux    sync.Mutex
+	events []leaseaudit.Event
+}
+
+func (r *leaseEventRecorder) Record(_ context.Context, event *leaseaudit.Event) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.events = append(r.events, *event)
+	return nil
+}
+
+func (r *leaseEventRecorder) recorded() []leaseaudit.Event {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return append([]leaseaudit.Event(nil), r.events...)
+}
+
+func TestAcquisitionEvent(t *testing.T) {
+	assert.Equal(t, leaseaudit.LeaseAcquired, acquisitionEvent("", "worker", false))
+	assert.Equal(t, leaseaudit.LeaseAcquired, acquisitionEvent("worker", "worker", false))
+	assert.Equal(t, leaseaudit.LeaseExpired, acquisitionEvent("other", "worker", false))
+	assert.Equal(t, leaseaudit.LeaseStolen, acquisitionEvent("other", "worker", true))
+}
+
+func TestWorkerRecordsLeaseEvents(t *testing.T) {
+	var down int32
+	server := newRegionServer(t, &down)
+	defer server.Close()
+
+	// the lease of another worker has expired
+	table, _ := chk.NewMemoryLeaseTable("")
+	recorder := &leaseEventRecorder{}
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+		WithIdleTimeBetweenReadsInMillis(10).
+		WithShardSyncIntervalMillis(10).
+		WithLeaseAuditSink(recorder)
+	checkpointer := chk.NewMemoryCheckpoint(kclConfig).WithLeaseTable(table)
+	assert.Nil(t, checkpointer.Init())
+	shard := &par.ShardStatus{ID: "shardId-0", Mux: &sync.RWMutex{}, LeaseTimeout: time.Now().Add(-time.Minute)}
+	shard.SetLeaseOwner("other")
+	shard.SetCheckpoint("5")
+	assert.Nil(t, checkpointer.CheckpointSequence(shard))
+
+	processor := &replayProcessor{}
+	w := NewWorker(replayProcessorFactory{processor}, kclConfig).
+		WithKinesis(newTestKinesisClient(server.URL)).
+		WithCheckpointer(checkpointer)
+	assert.Nil(t, w.Start())
+	assert.Eventually(t, func() bool {
+		return len(processor.delivered()) > 2
+	}, 5*time.Second, 10*time.Millisecond)
+	w.Shutdown()
+
+	events := recorder.recorded()
+	assert.Equal(t, 2, len(events))
+	assert.Equal(t, leaseaudit.LeaseExpired, events[0].Type)
+	assert.Equal(t, "worker", events[0].WorkerID)
+	assert.Equal(t, "shardId-0", events[0].ShardID)
+	assert.Equal(t, "5", events[0].Checkpoint)
+	assert.Equal(t, "other", events[0].PreviousOwner)
+
+	// the lease is released with the last checkpoint at shutdown
+	records := processor.delivered()
+	assert.Equal(t, "6", records[0])
+	assert.Equal(t, leaseaudit.LeaseReleased, events[1].Type)
+	assert.Equal(t, records[len(records)-1], events[1].Checkpoint)
+	assert.Empty(t, events[1].PreviousOwner)
+}
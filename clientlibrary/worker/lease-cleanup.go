@@ -0,0 +1,126 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"errors"
+
+	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+// cleanupLeases deletes the leases of the shards checkpointed at SHARD_END whose child shards are being processed
+// already. The leases of shards which no longer exist in the stream are deleted by syncShard.
+func (w *Worker) cleanupLeases() {
+	log := w.log
+
+	for _, shard := range w.shardStatus {
+		if w.cleanedLeases[shard.ID] {
+			continue
+		}
+
+		children := w.childShards(shard)
+		if len(children) == 0 {
+			continue
+		}
+
+		if shard.GetCheckpoint() != chk.ShardEnd {
+			if err := w.checkpointer.FetchCheckpoint(shard); err != nil {
+				if !errors.Is(err, chk.ErrSequenceIDNotFound) {
+					log.Warnf("Couldn't fetch checkpoint of shard %s for lease cleanup: %+v", shard.ID, err)
+				}
+				continue
+			}
+			if shard.GetCheckpoint() != chk.ShardEnd {
+				continue
+			}
+		}
+
+		if started, err := w.isShardsStarted(children); err != nil || !started {
+			if err != nil {
+				log.Warnf("Couldn't fetch checkpoint of child shards of %s for lease cleanup: %+v", shard.ID, err)
+			}
+			continue
+		}
+
+		if err := w.checkpointer.RemoveLeaseInfo(shard.ID); err != nil {
+			log.Errorf("Failed to remove lease of completed shard %s: %+v", shard.ID, err)
+			continue
+		}
+		log.Infof("Removed lease of completed shard %s", shard.ID)
+		w.cleanedLeases[shard.ID] = true
+	}
+}
+
+// isLeaseCleanedUp returns true if the shard has no lease because it has been deleted after the shard has been
+// completed. The shard is regarded as checkpointed at SHARD_END then.
+func (w *Worker) isLeaseCleanedUp(shard *par.ShardStatus) (bool, error) {
+	if w.cleanedLeases[shard.ID] {
+		return true, nil
+	}
+
+	children := w.childShards(shard)
+	if len(children) == 0 {
+		return false, nil
+	}
+
+	started, err := w.isShardsStarted(children)
+	if err != nil || !started {
+		return false, err
+	}
+
+	shard.SetCheckpoint(chk.ShardEnd)
+	w.cleanedLeases[shard.ID] = true
+	return true, nil
+}
+
+// childShards returns the known shards which have the shard as parent
+func (w *Worker) childShards(shard *par.ShardStatus) []*par.ShardStatus {
+	var children []*par.ShardStatus
+	for _, s := range w.shardStatus {
+		for _, parentID := range s.GetParentShardIds() {
+			if parentID == shard.ID {
+				children = append(children, s)
+			}
+		}
+	}
+	return children
+}
+
+// isShardsStarted returns true if all shards have been checkpointed
+func (w *Worker) isShardsStarted(shards []*par.ShardStatus) (bool, error) {
+	for _, shard := range shards {
+		if shard.GetCheckpoint() != "" {
+			continue
+		}
+
+		if err := w.checkpointer.FetchCheckpoint(shard); err != nil {
+			if errors.Is(err, chk.ErrSequenceIDNotFound) {
+				return false, nil
+			}
+			return false, err
+		}
+
+		if shard.GetCheckpoint() == "" {
+			return false, nil
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,87 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+// newSplitShards returns a parent shard which has been split into two child shards
+func newSplitShards() map[string]*par.ShardStatus {
+	return map[string]*par.ShardStatus{
+		"parent": {ID: "parent", Mux: &sync.RWMutex{}},
+		"child1": {ID: "child1", ParentShardId: "parent", Mux: &sync.RWMutex{}},
+		"child2": {ID: "child2", ParentShardId: "parent", Mux: &sync.RWMutex{}},
+	}
+}
+
+func newLeaseCleanupTestWorker(checkpointer chk.Checkpointer) *Worker {
+	w := NewWorker(shutdownRecorderFactory{}, config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker")).
+		WithCheckpointer(checkpointer)
+	w.shardStatus = newSplitShards()
+	return w
+}
+
+func TestCleanupLeases(t *testing.T) {
+	checkpointer := newMockCheckpointer()
+	checkpointer.checkpoints["parent"] = chk.ShardEnd
+	checkpointer.checkpoints["child1"] = "100"
+	w := newLeaseCleanupTestWorker(checkpointer)
+
+	// the lease is kept until all child shards are being processed
+	w.cleanupLeases()
+	assert.Contains(t, checkpointer.checkpoints, "parent")
+
+	checkpointer.checkpoints["child2"] = "200"
+	w.cleanupLeases()
+	assert.NotContains(t, checkpointer.checkpoints, "parent")
+	assert.True(t, w.cleanedLeases["parent"])
+
+	// the leases of child shards which have not been completed are kept
+	assert.Contains(t, checkpointer.checkpoints, "child1")
+	assert.Contains(t, checkpointer.checkpoints, "child2")
+}
+
+func TestCleanedUpLeaseAfterRestart(t *testing.T) {
+	checkpointer := newMockCheckpointer()
+	checkpointer.checkpoints["child1"] = "100"
+	checkpointer.checkpoints["child2"] = "200"
+	w := newLeaseCleanupTestWorker(checkpointer)
+
+	// the child shards can be processed although the lease of their parent has been deleted
+	completed, err := w.isParentShardsCompleted(w.shardStatus["child1"])
+	assert.Nil(t, err)
+	assert.True(t, completed)
+	assert.Equal(t, chk.ShardEnd, w.shardStatus["parent"].GetCheckpoint())
+
+	// a new shard without lease is not regarded as completed
+	w = newLeaseCleanupTestWorker(newMockCheckpointer())
+	cleaned, err := w.isLeaseCleanedUp(w.shardStatus["parent"])
+	assert.Nil(t, err)
+	assert.False(t, cleaned)
+	assert.Equal(t, "", w.shardStatus["parent"].GetCheckpoint())
+}
@@ -0,0 +1,86 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"context"
+	"time"
+
+	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/leasecoordinator"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+// coordinatedCheckpointer takes the lease of a shard in the lease table only once the lease coordinator has assigned
+// the shard to the worker. The lease of the table still fences the checkpoints of the shard, so that a worker the
+// shard has been assigned away from cannot overwrite the checkpoints of the new owner. The calls to the coordinator
+// are canceled after the lease duration, a lease which cannot be renewed meanwhile would expire anyway.
+type coordinatedCheckpointer struct {
+	chk.Checkpointer
+	coordinator leasecoordinator.LeaseCoordinator
+	workerID    string
+	timeout     time.Duration
+}
+
+func newCoordinatedCheckpointer(checkpointer chk.Checkpointer, coordinator leasecoordinator.LeaseCoordinator, workerID string, timeout time.Duration) chk.Checkpointer {
+	return &coordinatedCheckpointer{Checkpointer: checkpointer, coordinator: coordinator, workerID: workerID, timeout: timeout}
+}
+
+// GetLease acquires or renews the lease of the shard once the coordinator has assigned the shard to the worker. A
+// lease held by the worker is handed off once the shard has been assigned to another worker, so that the record
+// processor can checkpoint before the new owner takes the lease.
+func (c *coordinatedCheckpointer) GetLease(shard *par.ShardStatus, newAssignTo string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	assigned, err := c.coordinator.Acquire(ctx, shard.ID, newAssignTo)
+	if err != nil {
+		return err
+	}
+	if !assigned {
+		if shard.GetLeaseOwner() == newAssignTo {
+			return chk.NewLeaseNotAcquiredError("shard assigned to another worker by the lease coordinator", chk.ErrShardAlreadyClaimed)
+		}
+		return chk.NewLeaseNotAcquiredError("shard assigned to another worker by the lease coordinator", nil)
+	}
+	return c.Checkpointer.GetLease(shard, newAssignTo)
+}
+
+// RemoveLeaseOwner releases the lease of the shard and then its assignment to the worker
+func (c *coordinatedCheckpointer) RemoveLeaseOwner(shardID string) error {
+	if err := c.Checkpointer.RemoveLeaseOwner(shardID); err != nil {
+		return err
+	}
+	return c.release(shardID)
+}
+
+// RemoveLeaseInfo removes the lease of the shard, e.g. once the shard has expired, and its assignment to the worker
+func (c *coordinatedCheckpointer) RemoveLeaseInfo(shardID string) error {
+	if err := c.Checkpointer.RemoveLeaseInfo(shardID); err != nil {
+		return err
+	}
+	return c.release(shardID)
+}
+
+// release removes the assignment of the shard to the worker
+func (c *coordinatedCheckpointer) release(shardID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	return c.coordinator.Release(ctx, shardID, c.workerID)
+}
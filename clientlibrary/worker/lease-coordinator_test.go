@@ -0,0 +1,135 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/leasecoordinator"
+)
+
+// assignments is a lease coordinator assigning a shard to the first worker acquiring it
+type assignments struct {
+	mux     sync.Mutex
+	workers map[string]string
+	err     error
+}
+
+func (a *assignments) coordinator() leasecoordinator.LeaseCoordinator {
+	return leasecoordinator.Funcs{
+		AcquireFunc: func(_ context.Context, shardID, workerID string) (bool, error) {
+			a.mux.Lock()
+			defer a.mux.Unlock()
+			if a.err != nil {
+				return false, a.err
+			}
+			if _, ok := a.workers[shardID]; !ok {
+				a.workers[shardID] = workerID
+			}
+			return a.workers[shardID] == workerID, nil
+		},
+		ReleaseFunc: func(_ context.Context, shardID, workerID string) error {
+			a.mux.Lock()
+			defer a.mux.Unlock()
+			if a.workers[shardID] == workerID {
+				delete(a.workers, shardID)
+			}
+			return nil
+		},
+	}
+}
+
+func (a *assignments) assign(shardID, workerID string) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	a.workers[shardID] = workerID
+}
+
+func TestCoordinatedCheckpointer(t *testing.T) {
+	table, err := chk.NewMemoryLeaseTable("")
+	assert.Nil(t, err)
+	a := &assignments{workers: map[string]string{}}
+	newCheckpointer := func(workerID string) chk.Checkpointer {
+		kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", workerID)
+		checkpointer := newCoordinatedCheckpointer(chk.NewMemoryCheckpoint(kclConfig).WithLeaseTable(table), a.coordinator(), workerID, time.Minute)
+		assert.Nil(t, checkpointer.Init())
+		return checkpointer
+	}
+	owner, other := newCheckpointer("owner"), newCheckpointer("other")
+
+	shard := newTestShards(1)[0]
+	assert.Nil(t, owner.GetLease(shard, "owner"))
+	assert.Equal(t, "owner", shard.GetLeaseOwner())
+
+	// the shard is not acquired by a worker it is not assigned to
+	err = other.GetLease(newTestShards(1)[0], "other")
+	assert.True(t, errors.As(err, &chk.ErrLeaseNotAcquired{}))
+	assert.False(t, isShardClaimed(err))
+
+	// the lease is handed off once the shard has been assigned to another worker
+	a.assign(shard.ID, "other")
+	err = owner.GetLease(shard, "owner")
+	assert.True(t, errors.As(err, &chk.ErrLeaseNotAcquired{}))
+	assert.True(t, isShardClaimed(err))
+
+	// the lease of the table is still held by the owner until it has been released
+	shard.SetCheckpoint("1")
+	assert.Nil(t, owner.CheckpointSequence(shard))
+	err = other.GetLease(newTestShards(1)[0], "other")
+	assert.True(t, errors.As(err, &chk.ErrLeaseNotAcquired{}))
+	assert.Nil(t, owner.RemoveLeaseOwner(shard.ID))
+	assert.Equal(t, "other", a.workers[shard.ID])
+
+	taken := newTestShards(1)[0]
+	assert.Nil(t, other.GetLease(taken, "other"))
+	assert.Equal(t, "other", taken.GetLeaseOwner())
+
+	// the assignment is released with the lease
+	assert.Nil(t, other.RemoveLeaseOwner(taken.ID))
+	assert.Empty(t, a.workers)
+
+	// the lease is not acquired without the coordinator
+	a.err = errors.New("coordinator unavailable")
+	assert.Equal(t, a.err, owner.GetLease(newTestShards(1)[0], "owner"))
+}
+
+func TestCoordinatedCheckpointerTimeout(t *testing.T) {
+	table, err := chk.NewMemoryLeaseTable("")
+	assert.Nil(t, err)
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker")
+	// a coordinator which does not respond is given up on after the lease duration
+	blocked := leasecoordinator.Funcs{
+		AcquireFunc: func(ctx context.Context, _, _ string) (bool, error) {
+			<-ctx.Done()
+			return false, ctx.Err()
+		},
+	}
+	checkpointer := newCoordinatedCheckpointer(chk.NewMemoryCheckpoint(kclConfig).WithLeaseTable(table), blocked, "worker", 10*time.Millisecond)
+	assert.Nil(t, checkpointer.Init())
+	assert.ErrorIs(t, checkpointer.GetLease(newTestShards(1)[0], "worker"), context.DeadlineExceeded)
+}
@@ -0,0 +1,170 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+// leaseRenewer renews the lease of a shard in its own goroutine every lease refresh period, independently of the
+// consumer loop, so that a slow ProcessRecords call does not let the lease lapse mid-batch. The consumer is notified
+// through lost once the lease cannot be renewed or a checkpoint has been rejected because the lease has been lost,
+// the renewer stops then.
+type leaseRenewer struct {
+	sc    *commonShardConsumer
+	owner string
+
+	// mux serializes the renewals with the checkpoints of the shard, as both write its lease
+	mux *sync.Mutex
+
+	// err is the error of the failed renewal or checkpoint, it is set before lost is closed
+	err      error
+	lost     chan struct{}
+	lostOnce sync.Once
+
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// leaseSerializingCheckpointer writes the checkpoints of a shard under the lock of its lease renewer, so that
+// a renewal does not overwrite a checkpoint with the sequence number read before it
+type leaseSerializingCheckpointer struct {
+	chk.Checkpointer
+	mux *sync.Mutex
+}
+
+func (c *leaseSerializingCheckpointer) CheckpointSequence(shard *par.ShardStatus) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.Checkpointer.CheckpointSequence(shard)
+}
+
+// startLeaseRenewer renews the lease of the shard for the owner until the returned function is called. The
+// checkpoints of the consumer are serialized with the renewals from then on.
+func (sc *commonShardConsumer) startLeaseRenewer(owner string) func() {
+	r := &leaseRenewer{
+		sc:      sc,
+		owner:   owner,
+		mux:     &sync.Mutex{},
+		lost:    make(chan struct{}),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	sc.renewer = r
+	sc.checkpointer = &leaseSerializingCheckpointer{Checkpointer: sc.checkpointer, mux: r.mux}
+
+	go r.run()
+	return func() {
+		close(r.done)
+		<-r.stopped
+	}
+}
+
+func (r *leaseRenewer) run() {
+	defer close(r.stopped)
+
+	for {
+		refreshPeriod := time.Duration(r.sc.kclConfig.LeaseRefreshPeriodMillis) * time.Millisecond
+		select {
+		case <-r.done:
+			return
+		case <-r.lost:
+			return
+		case <-time.After(time.Until(r.sc.shard.GetLeaseTimeout().Add(-refreshPeriod))):
+		}
+
+		if err := r.renew(); err != nil {
+			r.markLost(err)
+			return
+		}
+	}
+}
+
+// renew renews the lease once and counts the renewal
+func (r *leaseRenewer) renew() error {
+	r.sc.getLogger().Debugf("Refreshing lease on shard: %s for worker: %s", r.sc.shard.ID, r.owner)
+
+	r.mux.Lock()
+	err := r.sc.checkpointer.GetLease(r.sc.shard, r.owner)
+	r.mux.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// log metric for renewed lease for worker
+	r.sc.mService.LeaseRenewed(r.sc.shard.ID)
+	r.sc.status.leaseRenewed()
+	return nil
+}
+
+// markLost signals the consumer that the lease has been lost because of err, only the first error is kept
+func (r *leaseRenewer) markLost(err error) {
+	if r == nil {
+		return
+	}
+	r.lostOnce.Do(func() {
+		r.err = err
+		close(r.lost)
+	})
+}
+
+// lostSignal returns the channel closed once the lease could not be renewed, it is never closed without a renewer
+func (r *leaseRenewer) lostSignal() <-chan struct{} {
+	if r == nil {
+		return nil
+	}
+	return r.lost
+}
+
+// isLost returns true once the lease could not be renewed
+func (r *leaseRenewer) isLost() bool {
+	select {
+	case <-r.lostSignal():
+		return true
+	default:
+		return false
+	}
+}
+
+// leaseLost stops the consumer once the lease could not be renewed or a checkpoint has been rejected because the
+// lease has been lost. The lease is handed off if another worker has claimed the shard, the record processor is
+// shut down with ZOMBIE otherwise. It is an error unless the lease has been taken by another worker.
+func (sc *commonShardConsumer) leaseLost(checkpointer kcl.IRecordProcessorCheckpointer) error {
+	log := sc.getLogger()
+	err := sc.renewer.err
+	if isShardClaimed(err) {
+		sc.handOffLease(checkpointer)
+		return nil
+	}
+	if errors.As(err, &chk.ErrLeaseNotAcquired{}) || errors.Is(err, chk.ErrLeaseLost) {
+		log.Warnf("Lost the lease on shard: %s for worker: %s. Error: %v", sc.shard.ID, sc.renewer.owner, err)
+		sc.shutdownRecordProcessor(kcl.ZOMBIE, checkpointer)
+		return nil
+	}
+	log.Errorf("Error in refreshing lease on shard: %s for worker: %s. Error: %+v", sc.shard.ID, sc.renewer.owner, err)
+	sc.shutdownRecordProcessor(kcl.ZOMBIE, checkpointer)
+	return err
+}
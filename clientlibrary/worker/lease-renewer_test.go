@@ -0,0 +1,117 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+
+	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/metrics"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+func TestLeaseRenewer(t *testing.T) {
+	table, _ := chk.NewMemoryLeaseTable("")
+	newConfig := func(workerID string) *config.KinesisClientLibConfiguration {
+		return config.NewKinesisClientLibConfig("app", "stream", "us-west-2", workerID).
+			WithFailoverTimeMillis(200).
+			WithLeaseRefreshPeriodMillis(100)
+	}
+	kclConfig := newConfig("worker")
+	owner := chk.NewMemoryCheckpoint(kclConfig).WithLeaseTable(table)
+	shard := &par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}}
+	assert.Nil(t, owner.GetLease(shard, "worker"))
+
+	status := &consumerStatus{shard: shard}
+	recorder := &shutdownRecorder{}
+	sc := &commonShardConsumer{
+		shard:           shard,
+		checkpointer:    owner,
+		kclConfig:       kclConfig,
+		mService:        metrics.NoopMonitoringService{},
+		status:          status,
+		recordProcessor: kcl.NewRecordProcessorAdapter(recorder),
+	}
+	stop := sc.startLeaseRenewer("worker")
+	defer stop()
+
+	// the lease is renewed while the consumer is busy, e.g. with a slow ProcessRecords call
+	time.Sleep(450 * time.Millisecond)
+	assert.False(t, sc.renewer.isLost())
+	assert.True(t, sc.shard.GetLeaseTimeout().After(time.Now()))
+	assert.GreaterOrEqual(t, status.snapshot(time.Now()).LeaseRenewals, int64(2))
+	shard.SetCheckpoint("deadbeef")
+	assert.Nil(t, sc.checkpointer.CheckpointSequence(shard))
+
+	// the consumer is notified once another worker has taken the lease
+	assert.Nil(t, owner.RemoveLeaseOwner(shard.ID))
+	assert.Nil(t, chk.NewMemoryCheckpoint(newConfig("other")).WithLeaseTable(table).GetLease(&par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}}, "other"))
+	select {
+	case <-sc.renewer.lostSignal():
+	case <-time.After(time.Second):
+		t.Fatal("the lost lease has not been signaled")
+	}
+	assert.Nil(t, sc.leaseLost(nil))
+	assert.Equal(t, []kcl.ShutdownReason{kcl.ZOMBIE}, recorder.reasons)
+}
+
+func TestLeaseLostOnRejectedCheckpoint(t *testing.T) {
+	table, _ := chk.NewMemoryLeaseTable("")
+	newConfig := func(workerID string) *config.KinesisClientLibConfiguration {
+		return config.NewKinesisClientLibConfig("app", "stream", "us-west-2", workerID).
+			WithFailoverTimeMillis(60000)
+	}
+	kclConfig := newConfig("worker")
+	owner := chk.NewMemoryCheckpoint(kclConfig).WithLeaseTable(table)
+	shard := &par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}}
+	assert.Nil(t, owner.GetLease(shard, "worker"))
+
+	recorder := &shutdownRecorder{}
+	sc := &commonShardConsumer{
+		shard:           shard,
+		checkpointer:    owner,
+		kclConfig:       kclConfig,
+		mService:        metrics.NoopMonitoringService{},
+		status:          &consumerStatus{shard: shard},
+		recordProcessor: kcl.NewRecordProcessorAdapter(recorder),
+	}
+	stop := sc.startLeaseRenewer("worker")
+	defer stop()
+	checkpointer := sc.recordProcessorCheckpointer()
+
+	// another worker takes the lease long before it would be renewed
+	assert.Nil(t, owner.RemoveLeaseOwner(shard.ID))
+	assert.Nil(t, chk.NewMemoryCheckpoint(newConfig("other")).WithLeaseTable(table).GetLease(&par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}}, "other"))
+
+	err := checkpointer.Checkpoint(aws.String("deadbeef"))
+	assert.ErrorIs(t, err, chk.ErrLeaseLost)
+	assert.True(t, sc.renewer.isLost())
+
+	// the record processor is shut down as a zombie, the lease has been taken over
+	assert.Nil(t, sc.leaseLost(checkpointer))
+	assert.Equal(t, []kcl.ShutdownReason{kcl.ZOMBIE}, recorder.reasons)
+}
@@ -0,0 +1,89 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+)
+
+type (
+	// Middleware wraps the record processor of every shard to add cross-cutting concerns, e.g. metrics or
+	// panic recovery. It is called with the next record processor of the chain each time a shard is leased.
+	Middleware func(next kcl.IRecordProcessorWithContext) kcl.IRecordProcessorWithContext
+
+	// ProcessRecordsFunc processes a batch of records like IRecordProcessorWithContext.ProcessRecords
+	ProcessRecordsFunc func(ctx context.Context, input *kcl.ProcessRecordsInput) error
+
+	// processRecordsInterceptor replaces ProcessRecords of the wrapped record processor
+	processRecordsInterceptor struct {
+		kcl.IRecordProcessorWithContext
+		processRecords ProcessRecordsFunc
+	}
+)
+
+// Use adds middlewares to the record processors created by the worker. The first middleware is the outermost one.
+// Middlewares have to be added before the worker is started.
+func (w *Worker) Use(middlewares ...Middleware) *Worker {
+	w.middlewares = append(w.middlewares, middlewares...)
+	return w
+}
+
+// createProcessor creates a record processor wrapped by the middlewares
+func (w *Worker) createProcessor() kcl.IRecordProcessorWithContext {
+	processor := w.processorFactory.CreateProcessor()
+	for i := len(w.middlewares) - 1; i >= 0; i-- {
+		processor = w.middlewares[i](processor)
+	}
+	return processor
+}
+
+// ProcessRecordsMiddleware returns a middleware which only wraps ProcessRecords of the record processors,
+// Initialize and Shutdown are passed through.
+func ProcessRecordsMiddleware(wrap func(next ProcessRecordsFunc) ProcessRecordsFunc) Middleware {
+	return func(next kcl.IRecordProcessorWithContext) kcl.IRecordProcessorWithContext {
+		return &processRecordsInterceptor{
+			IRecordProcessorWithContext: next,
+			processRecords:              wrap(next.ProcessRecords),
+		}
+	}
+}
+
+func (p *processRecordsInterceptor) ProcessRecords(ctx context.Context, input *kcl.ProcessRecordsInput) error {
+	return p.processRecords(ctx, input)
+}
+
+// RecoverMiddleware returns a middleware which recovers from panics of ProcessRecords. The panic is returned as
+// error, so the shard consumer shuts down and releases the lease instead of crashing the worker.
+func RecoverMiddleware() Middleware {
+	return ProcessRecordsMiddleware(func(next ProcessRecordsFunc) ProcessRecordsFunc {
+		return func(ctx context.Context, input *kcl.ProcessRecordsInput) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("panic in ProcessRecords: %v\n%s", r, debug.Stack())
+				}
+			}()
+			return next(ctx, input)
+		}
+	})
+}
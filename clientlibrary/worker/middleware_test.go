@@ -0,0 +1,79 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+)
+
+// panicProcessor panics on every batch of records
+type panicProcessor struct {
+	shutdownRecorder
+}
+
+func (p *panicProcessor) ProcessRecords(*kcl.ProcessRecordsInput) error {
+	panic("boom")
+}
+
+// processorFactory always returns the same record processor
+type processorFactory struct {
+	processor kcl.IRecordProcessor
+}
+
+func (f processorFactory) CreateProcessor() kcl.IRecordProcessor { return f.processor }
+
+func TestMiddlewareOrder(t *testing.T) {
+	var calls []string
+	trace := func(name string) Middleware {
+		return ProcessRecordsMiddleware(func(next ProcessRecordsFunc) ProcessRecordsFunc {
+			return func(ctx context.Context, input *kcl.ProcessRecordsInput) error {
+				calls = append(calls, name)
+				return next(ctx, input)
+			}
+		})
+	}
+
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker")
+	recorder := &shutdownRecorder{}
+	w := NewWorker(processorFactory{recorder}, kclConfig).Use(trace("outer"), trace("middle")).Use(trace("inner"))
+
+	processor := w.createProcessor()
+	assert.Nil(t, processor.ProcessRecords(context.Background(), &kcl.ProcessRecordsInput{}))
+	assert.Equal(t, []string{"outer", "middle", "inner"}, calls)
+
+	// Shutdown is passed through to the record processor
+	processor.Shutdown(context.Background(), &kcl.ShutdownInput{ShutdownReason: kcl.TERMINATE})
+	assert.Equal(t, []kcl.ShutdownReason{kcl.TERMINATE}, recorder.reasons)
+}
+
+func TestRecoverMiddleware(t *testing.T) {
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker")
+	w := NewWorker(processorFactory{&panicProcessor{}}, kclConfig).Use(RecoverMiddleware())
+
+	err := w.createProcessor().ProcessRecords(context.Background(), &kcl.ProcessRecordsInput{})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "panic in ProcessRecords: boom")
+}
@@ -0,0 +1,85 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+// mockCheckpointer keeps checkpoints in memory, leases are always granted
+type mockCheckpointer struct {
+	checkpoints map[string]string
+	owners      map[string]string
+}
+
+func newMockCheckpointer() *mockCheckpointer {
+	return &mockCheckpointer{checkpoints: map[string]string{}, owners: map[string]string{}}
+}
+
+func (m *mockCheckpointer) Init() error {
+	return nil
+}
+
+func (m *mockCheckpointer) GetLease(shard *par.ShardStatus, owner string) error {
+	shard.SetLeaseOwner(owner)
+	m.owners[shard.ID] = owner
+	return nil
+}
+
+func (m *mockCheckpointer) CheckpointSequence(shard *par.ShardStatus) error {
+	m.checkpoints[shard.ID] = shard.GetCheckpoint()
+	return nil
+}
+
+func (m *mockCheckpointer) FetchCheckpoint(shard *par.ShardStatus) error {
+	checkpoint, ok := m.checkpoints[shard.ID]
+	if !ok {
+		return chk.ErrSequenceIDNotFound
+	}
+	shard.SetCheckpoint(checkpoint)
+	return nil
+}
+
+func (m *mockCheckpointer) RemoveLeaseInfo(shardID string) error {
+	delete(m.checkpoints, shardID)
+	return nil
+}
+
+func (m *mockCheckpointer) RemoveLeaseOwner(shardID string) error {
+	delete(m.owners, shardID)
+	return nil
+}
+
+func (m *mockCheckpointer) GetLeaseOwner(shardID string) (string, error) {
+	owner, ok := m.owners[shardID]
+	if !ok {
+		return "", chk.NoLeaseOwnerErr
+	}
+	return owner, nil
+}
+
+func (m *mockCheckpointer) ListActiveWorkers(map[string]*par.ShardStatus) (map[string][]*par.ShardStatus, error) {
+	return nil, nil
+}
+
+func (m *mockCheckpointer) ClaimShard(*par.ShardStatus, string) error {
+	return nil
+}
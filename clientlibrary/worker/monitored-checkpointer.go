@@ -0,0 +1,84 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"errors"
+	"time"
+
+	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/metrics"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+// monitoredCheckpointer reports the latency of the checkpoints and the contention on the leases of any checkpointer
+// to the monitoring service. The throttling of the lease table, e.g. ProvisionedThroughputExceeded, is counted by
+// the retryer of the AWS SDK as throttled requests.
+type monitoredCheckpointer struct {
+	chk.Checkpointer
+	mService metrics.MonitoringService
+}
+
+func newMonitoredCheckpointer(checkpointer chk.Checkpointer, mService metrics.MonitoringService) chk.Checkpointer {
+	if _, ok := checkpointer.(*monitoredCheckpointer); ok {
+		return checkpointer
+	}
+	return &monitoredCheckpointer{Checkpointer: checkpointer, mService: mService}
+}
+
+func (m *monitoredCheckpointer) GetLease(shard *par.ShardStatus, newAssignTo string) error {
+	err := m.Checkpointer.GetLease(shard, newAssignTo)
+	if leaseTable, ok := m.mService.(metrics.LeaseTableMonitoringService); ok &&
+		(errors.As(err, &chk.ErrLeaseNotAcquired{}) || isShardClaimed(err)) {
+		leaseTable.IncrLeaseContentions(shard.ID)
+	}
+	m.countConditionalCheckFailure(shard.ID, err)
+	return err
+}
+
+func (m *monitoredCheckpointer) CheckpointSequence(shard *par.ShardStatus) error {
+	start := time.Now()
+	err := m.Checkpointer.CheckpointSequence(shard)
+	if checkpoints, ok := m.mService.(metrics.CheckpointMonitoringService); ok {
+		checkpoints.RecordCheckpointTime(shard.ID, float64(time.Since(start).Milliseconds()))
+	}
+	m.countConditionalCheckFailure(shard.ID, err)
+	return err
+}
+
+func (m *monitoredCheckpointer) RemoveLeaseOwner(shardID string) error {
+	err := m.Checkpointer.RemoveLeaseOwner(shardID)
+	m.countConditionalCheckFailure(shardID, err)
+	return err
+}
+
+func (m *monitoredCheckpointer) ClaimShard(shard *par.ShardStatus, claimID string) error {
+	err := m.Checkpointer.ClaimShard(shard, claimID)
+	m.countConditionalCheckFailure(shard.ID, err)
+	return err
+}
+
+// countConditionalCheckFailure counts the writes to the lease table which are rejected because another worker
+// modified the lease in the meantime
+func (m *monitoredCheckpointer) countConditionalCheckFailure(shardID string, err error) {
+	if leaseTable, ok := m.mService.(metrics.LeaseTableMonitoringService); ok && chk.IsConditionalCheckFailed(err) {
+		leaseTable.IncrConditionalCheckFailures(shardID)
+	}
+}
@@ -0,0 +1,86 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/metrics"
+)
+
+// leaseMetricsRecorder records the checkpoint and lease metrics reported to the monitoring service
+type leaseMetricsRecorder struct {
+	metrics.NoopMonitoringService
+	checkpointTimes   map[string]int
+	contentions       map[string]int
+	conditionalChecks map[string]int
+}
+
+func newLeaseMetricsRecorder() *leaseMetricsRecorder {
+	return &leaseMetricsRecorder{checkpointTimes: map[string]int{}, contentions: map[string]int{}, conditionalChecks: map[string]int{}}
+}
+
+func (r *leaseMetricsRecorder) RecordCheckpointTime(shard string, _ float64) {
+	r.checkpointTimes[shard]++
+}
+
+func (r *leaseMetricsRecorder) IncrLeaseContentions(shard string) {
+	r.contentions[shard]++
+}
+
+func (r *leaseMetricsRecorder) IncrConditionalCheckFailures(shard string) {
+	r.conditionalChecks[shard]++
+}
+
+func TestMonitoredCheckpointer(t *testing.T) {
+	table, err := chk.NewMemoryLeaseTable("")
+	assert.Nil(t, err)
+	newCheckpointer := func(workerID string, mService metrics.MonitoringService) chk.Checkpointer {
+		kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", workerID)
+		checkpointer := newMonitoredCheckpointer(chk.NewMemoryCheckpoint(kclConfig).WithLeaseTable(table), mService)
+		assert.Nil(t, checkpointer.Init())
+		return checkpointer
+	}
+	ownerMetrics, otherMetrics := newLeaseMetricsRecorder(), newLeaseMetricsRecorder()
+	owner, other := newCheckpointer("owner", ownerMetrics), newCheckpointer("other", otherMetrics)
+	// checkpointers are only wrapped once
+	assert.Equal(t, owner, newMonitoredCheckpointer(owner, ownerMetrics))
+
+	shard := newTestShards(1)[0]
+	assert.Nil(t, owner.GetLease(shard, "owner"))
+	shard.SetCheckpoint("1")
+	assert.Nil(t, owner.CheckpointSequence(shard))
+	assert.Equal(t, map[string]int{"0000": 1}, ownerMetrics.checkpointTimes)
+
+	// the lease is held by the owner
+	err = other.GetLease(newTestShards(1)[0], "other")
+	assert.True(t, errors.As(err, &chk.ErrLeaseNotAcquired{}))
+	assert.Equal(t, map[string]int{"0000": 1}, otherMetrics.contentions)
+	assert.True(t, chk.IsConditionalCheckFailed(other.RemoveLeaseOwner(shard.ID)))
+	assert.Equal(t, map[string]int{"0000": 1}, otherMetrics.conditionalChecks)
+
+	assert.Empty(t, ownerMetrics.contentions)
+	assert.Empty(t, ownerMetrics.conditionalChecks)
+}
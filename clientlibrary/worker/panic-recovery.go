@@ -0,0 +1,104 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"runtime/debug"
+	"time"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/metrics"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+// maxProcessorRestartBackoff is the max backoff before restarting a panicked record processor
+const maxProcessorRestartBackoff = 30 * time.Second
+
+// runShardConsumer processes a leased shard and recovers from panics of the record processor according to the
+// configured policy. The lease has been released already when a panic is recovered.
+func (w *Worker) runShardConsumer(shard *par.ShardStatus) {
+	log := w.log
+	backoff := time.Duration(w.kclConfig.ProcessorRestartBackoffMillis) * time.Millisecond
+	status := w.registerConsumer(shard)
+	defer w.unregisterConsumer(shard)
+	// the consumer has stopped for good once the deferred release of the lease of the last attempt has run
+	defer status.setState(ConsumerShutdownComplete)
+
+	for {
+		recovered, stack, err := w.consumeShard(shard)
+		if err == errShardRewound {
+			// the lease is still held, the consumer restarts at the rewound checkpoint
+			log.Infof("Restarting shard consumer of shard %s at the rewound checkpoint", shard.ID)
+			status.setState(ConsumerWaitingOnParent)
+			continue
+		}
+		if recovered == nil {
+			if err != nil {
+				log.Errorf("Error in getRecords: %+v", err)
+			}
+			return
+		}
+
+		log.Errorf("Record processor of shard %s panicked: %v\n%s", shard.ID, recovered, stack)
+		if m, ok := w.mService.(metrics.ProcessorPanicMonitoringService); ok {
+			m.IncrProcessorPanics(shard.ID)
+		}
+		if w.kclConfig.ProcessorPanicHandler != nil {
+			w.kclConfig.ProcessorPanicHandler(shard.ID, recovered, stack)
+		}
+
+		switch w.kclConfig.ProcessorPanicPolicy {
+		case config.CrashOnPanic:
+			panic(recovered)
+		case config.RestartOnPanic:
+			log.Infof("Restarting record processor of shard %s in %s", shard.ID, backoff)
+			status.setState(ConsumerRestarting)
+			select {
+			case <-*w.stop:
+				return
+			case <-time.After(backoff):
+			}
+			if backoff *= 2; backoff > maxProcessorRestartBackoff {
+				backoff = maxProcessorRestartBackoff
+			}
+
+			if err := w.checkpointer.GetLease(shard, w.workerID); err != nil {
+				log.Warnf("Cannot get lease to restart record processor of shard %s: %+v", shard.ID, err)
+				return
+			}
+			w.mService.LeaseGained(shard.ID)
+			w.stateListener.LeaseAcquired(shard.ID)
+			status.setState(ConsumerWaitingOnParent)
+			status.leaseRenewed()
+		default:
+			return
+		}
+	}
+}
+
+// consumeShard returns the recovered value and the stack trace if the shard consumer panics
+func (w *Worker) consumeShard(shard *par.ShardStatus) (recovered interface{}, stack []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			recovered, stack = r, debug.Stack()
+		}
+	}()
+	return nil, nil, w.newShardConsumer(shard).getRecords()
+}
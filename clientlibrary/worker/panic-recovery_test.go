@@ -0,0 +1,140 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+// newGetRecordsServer returns a Kinesis endpoint which returns one record on every GetRecords call
+func newGetRecordsServer(t *testing.T) *httptest.Server {
+	return newKinesisServer(t, kinesisHandlers{
+		"GetShardIterator": shardIterator("iterator"),
+		"GetRecords": func(map[string]interface{}) interface{} {
+			return getRecordsOutput("iterator", kinesisRecord("100", "YQ=="))
+		},
+	})
+}
+
+// panickingProcessor panics until the worker has restarted it the given number of times and stops the worker then
+type panickingProcessor struct {
+	shutdownRecorder
+	panics *int
+	stop   func()
+}
+
+func (p *panickingProcessor) ProcessRecords(*kcl.ProcessRecordsInput) error {
+	if *p.panics > 0 {
+		*p.panics--
+		panic("boom")
+	}
+	p.stop()
+	return nil
+}
+
+type panickingProcessorFactory struct {
+	panics *int
+	stop   func()
+}
+
+func (f panickingProcessorFactory) CreateProcessor() kcl.IRecordProcessor {
+	return &panickingProcessor{panics: f.panics, stop: f.stop}
+}
+
+func newPanicTestWorker(t *testing.T, kclConfig *config.KinesisClientLibConfiguration, panics int) (*Worker, *mockCheckpointer, func()) {
+	server := newGetRecordsServer(t)
+	checkpointer := newMockCheckpointer()
+	stop := make(chan struct{})
+	stopOnce := sync.Once{}
+
+	w := NewWorker(panickingProcessorFactory{panics: &panics, stop: func() { stopOnce.Do(func() { close(stop) }) }}, kclConfig).
+		WithKinesis(newTestKinesisClient(server.URL)).
+		WithCheckpointer(checkpointer)
+	w.stop = &stop
+	return w, checkpointer, server.Close
+}
+
+func TestReleaseLeaseOnPanic(t *testing.T) {
+	var recovered []interface{}
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+		WithProcessorPanicHandler(func(shardID string, r interface{}, stack []byte) {
+			assert.Equal(t, "0001", shardID)
+			assert.NotEmpty(t, stack)
+			recovered = append(recovered, r)
+		})
+	w, checkpointer, closeServer := newPanicTestWorker(t, kclConfig, 1)
+	defer closeServer()
+
+	shard := &par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}}
+	assert.Nil(t, checkpointer.GetLease(shard, "worker"))
+	w.runShardConsumer(shard)
+
+	assert.Equal(t, []interface{}{"boom"}, recovered)
+	// the lease has been released
+	assert.Equal(t, "", shard.GetLeaseOwner())
+	assert.NotContains(t, checkpointer.owners, "0001")
+}
+
+func TestRestartOnPanic(t *testing.T) {
+	var numPanics int
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+		WithProcessorPanicPolicy(config.RestartOnPanic).
+		WithProcessorRestartBackoffMillis(1).
+		WithProcessorPanicHandler(func(string, interface{}, []byte) { numPanics++ })
+	w, checkpointer, closeServer := newPanicTestWorker(t, kclConfig, 2)
+	defer closeServer()
+
+	shard := &par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}}
+	assert.Nil(t, checkpointer.GetLease(shard, "worker"))
+
+	done := make(chan struct{})
+	go func() {
+		w.runShardConsumer(shard)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("record processor has not been restarted")
+	}
+	// the record processor processed the records after two restarts
+	assert.Equal(t, 2, numPanics)
+}
+
+func TestCrashOnPanic(t *testing.T) {
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+		WithProcessorPanicPolicy(config.CrashOnPanic)
+	w, checkpointer, closeServer := newPanicTestWorker(t, kclConfig, 1)
+	defer closeServer()
+
+	shard := &par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}}
+	assert.Nil(t, checkpointer.GetLease(shard, "worker"))
+	assert.PanicsWithValue(t, "boom", func() { w.runShardConsumer(shard) })
+}
@@ -0,0 +1,143 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package worker
+package worker
+
+import (
+	"errors"
+	"sort"
+
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+)
+
+// ErrShardNotPaused is returned when a shard to resume has not been paused
+var ErrShardNotPaused = errors.New("the shard is not paused")
+
+// PauseShard halts the consumption of a shard consumed by the worker, e.g. while a downstream tenant is unavailable.
+// The consumer stops fetching and delivering records after the current batch, but keeps renewing the lease, so that
+// the shard is not taken over by other workers. The shard stays paused until ResumeShard is called, also if the
+// worker acquires its lease again in the meantime. Pausing a paused shard has no effect.
+func (w *Worker) PauseShard(shardID string) error {
+	w.statusMux.Lock()
+	defer w.statusMux.Unlock()
+
+	status := w.consumers[shardID]
+	if status == nil {
+		return ErrShardNotConsumed
+	}
+	resumed, ok := w.pausedShards[shardID]
+	if !ok {
+		resumed = make(chan struct{})
+		w.pausedShards[shardID] = resumed
+	}
+	status.pause(resumed)
+	return nil
+}
+
+// ResumeShard resumes the consumption of a shard paused by PauseShard. The polling consumer reads the shard again
+// from the checkpoint, because its shard iterator may have expired meanwhile, so the records delivered to the record
+// processor but not checkpointed before the pause are delivered again. The records are also processed again from the
+// checkpoint if the lease has been lost meanwhile.
+func (w *Worker) ResumeShard(shardID string) error {
+	w.statusMux.Lock()
+	defer w.statusMux.Unlock()
+
+	resumed, ok := w.pausedShards[shardID]
+	if !ok {
+		return ErrShardNotPaused
+	}
+	delete(w.pausedShards, shardID)
+	close(resumed)
+	if status := w.consumers[shardID]; status != nil {
+		status.pause(nil)
+	}
+	return nil
+}
+
+// PausedShards returns the lease keys of the shards paused by PauseShard in order
+func (w *Worker) PausedShards() []string {
+	w.statusMux.RLock()
+	defer w.statusMux.RUnlock()
+
+	shardIDs := make([]string, 0, len(w.pausedShards))
+	for shardID := range w.pausedShards {
+		shardIDs = append(shardIDs, shardID)
+	}
+	sort.Strings(shardIDs)
+	return shardIDs
+}
+
+// pause sets the channel closed once the paused shard is resumed, nil resumes the shard
+func (s *consumerStatus) pause(resumed chan struct{}) {
+	s.Lock()
+	defer s.Unlock()
+	s.resumed = resumed
+}
+
+// resumedSignal returns the channel closed once the shard is resumed, it is nil unless the shard is paused
+func (s *consumerStatus) resumedSignal() <-chan struct{} {
+	if s == nil {
+		return nil
+	}
+	s.Lock()
+	defer s.Unlock()
+	return s.resumed
+}
+
+// waitWhilePaused holds the consumer while its shard is paused, the lease is renewed by the lease renewer meanwhile.
+// It reports whether the shard has been paused, and returns stopped if the consumer has to return the error instead
+// of resuming, because the worker stops, the shard is rewound or released, or the lease has been lost.
+func (sc *commonShardConsumer) waitWhilePaused(stop <-chan struct{}, checkpointer kcl.IRecordProcessorCheckpointer) (paused, stopped bool, err error) {
+	resumed := sc.status.resumedSignal()
+	if resumed == nil {
+		return false, false, nil
+	}
+
+	log := sc.getLogger()
+	log.Infof("Shard %s paused", sc.shard.ID)
+	sc.status.setState(ConsumerPaused)
+	select {
+	case <-stop:
+		sc.shutdownRecordProcessor(kcl.REQUESTED, checkpointer)
+		return true, true, nil
+	case req := <-sc.status.rewindRequests():
+		return true, true, sc.rewind(req, checkpointer)
+	case req := <-sc.status.releaseRequests():
+		return true, true, sc.release(req, checkpointer)
+	case <-sc.renewer.lostSignal():
+		return true, true, sc.leaseLost(checkpointer)
+	case <-resumed:
+	}
+	log.Infof("Shard %s resumed", sc.shard.ID)
+	sc.status.setState(ConsumerProcessing)
+	return true, false, nil
+}
+
+// resumedShardIterator returns a shard iterator at the checkpoint of a resumed shard, since the iterator held while
+// the shard was paused expires after five minutes. The pending asynchronous checkpoint is flushed first, so that the
+// records checkpointed before the pause are not delivered again.
+func (sc *PollingShardConsumer) resumedShardIterator(checkpointer kcl.IRecordProcessorCheckpointer) (*string, error) {
+	if rc, ok := checkpointer.(*RecordProcessorCheckpointer); ok {
+		if err := rc.flushAsyncCheckpoint(); err != nil {
+			return nil, err
+		}
+	}
+	return sc.getShardIterator()
+}
@@ -0,0 +1,168 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+// newEndlessServer returns a Kinesis endpoint with a single shard returning one record per GetRecords call, the
+// calls are counted by reads. The shard iterators handed out before the latest increment of generation have expired.
+func newEndlessServer(t *testing.T, reads, generation *int64) *httptest.Server {
+	return newKinesisServer(t, kinesisHandlers{
+		"ListShards": listShards(testShard("shardId-0", "")),
+		"GetShardIterator": func(input map[string]interface{}) interface{} {
+			sequenceNumber := 0
+			if after, ok := input["StartingSequenceNumber"].(string); ok {
+				sequenceNumber, _ = strconv.Atoi(after)
+				sequenceNumber++
+			}
+			return map[string]interface{}{"ShardIterator": fmt.Sprintf("%d/%d", atomic.LoadInt64(generation), sequenceNumber)}
+		},
+		"GetRecords": func(input map[string]interface{}) interface{} {
+			atomic.AddInt64(reads, 1)
+			var issued int64
+			var sequenceNumber int
+			_, _ = fmt.Sscanf(input["ShardIterator"].(string), "%d/%d", &issued, &sequenceNumber)
+			current := atomic.LoadInt64(generation)
+			if issued < current {
+				return kinesisError{status: http.StatusBadRequest, errType: "ExpiredIteratorException", message: "iterator expired"}
+			}
+			return getRecordsOutput(fmt.Sprintf("%d/%d", current, sequenceNumber+1), kinesisRecord(strconv.Itoa(sequenceNumber), "YQ=="))
+		},
+	})
+}
+
+func TestPauseShard(t *testing.T) {
+	var reads, generation int64
+	server := newEndlessServer(t, &reads, &generation)
+	defer server.Close()
+
+	table, _ := chk.NewMemoryLeaseTable("")
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+		WithIdleTimeBetweenReadsInMillis(10).
+		WithShardSyncIntervalMillis(10).
+		WithFailoverTimeMillis(300).
+		WithLeaseRefreshPeriodMillis(250)
+	checkpointer := chk.NewMemoryCheckpoint(kclConfig).WithLeaseTable(table)
+	processor := &replayProcessor{}
+	w := NewWorker(replayProcessorFactory{processor}, kclConfig).
+		WithKinesis(newTestKinesisClient(server.URL)).
+		WithCheckpointer(checkpointer)
+
+	assert.Equal(t, ErrShardNotConsumed, w.PauseShard("shardId-0"))
+	assert.Equal(t, ErrShardNotPaused, w.ResumeShard("shardId-0"))
+
+	assert.Nil(t, w.Start())
+	defer w.Shutdown()
+	assert.Eventually(t, func() bool {
+		return len(processor.delivered()) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the shard is not read while it is paused
+	assert.Nil(t, w.PauseShard("shardId-0"))
+	assert.Nil(t, w.PauseShard("shardId-0"))
+	assert.Equal(t, []string{"shardId-0"}, w.PausedShards())
+	assert.Eventually(t, func() bool {
+		status := w.Status()
+		return len(status.Shards) == 1 && status.Shards[0].State == ConsumerPaused
+	}, 5*time.Second, 10*time.Millisecond)
+	pausedReads := atomic.LoadInt64(&reads)
+	delivered := len(processor.delivered())
+	renewals := w.Status().Shards[0].LeaseRenewals
+
+	// the lease is renewed meanwhile
+	time.Sleep(500 * time.Millisecond)
+	assert.Equal(t, pausedReads, atomic.LoadInt64(&reads))
+	assert.Equal(t, delivered, len(processor.delivered()))
+	assert.Greater(t, w.Status().Shards[0].LeaseRenewals, renewals)
+	shard := &par.ShardStatus{ID: "shardId-0", Mux: &sync.RWMutex{}}
+	assert.Nil(t, checkpointer.FetchCheckpoint(shard))
+	assert.Equal(t, "worker", shard.GetLeaseOwner())
+
+	// the records following the processed ones are delivered once the shard is resumed
+	assert.Nil(t, w.ResumeShard("shardId-0"))
+	assert.Empty(t, w.PausedShards())
+	assert.Equal(t, ErrShardNotPaused, w.ResumeShard("shardId-0"))
+	assert.Eventually(t, func() bool {
+		return len(processor.delivered()) > delivered
+	}, 5*time.Second, 10*time.Millisecond)
+	records := processor.delivered()
+	for i, sequenceNumber := range records {
+		assert.Equal(t, strconv.Itoa(i), sequenceNumber)
+	}
+}
+
+func TestResumeShardExpiredIterator(t *testing.T) {
+	var reads, generation int64
+	server := newEndlessServer(t, &reads, &generation)
+	defer server.Close()
+
+	table, _ := chk.NewMemoryLeaseTable("")
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+		WithIdleTimeBetweenReadsInMillis(10).
+		WithShardSyncIntervalMillis(10).
+		WithFailoverTimeMillis(300).
+		WithLeaseRefreshPeriodMillis(250)
+	processor := &replayProcessor{}
+	w := NewWorker(replayProcessorFactory{processor}, kclConfig).
+		WithKinesis(newTestKinesisClient(server.URL)).
+		WithCheckpointer(chk.NewMemoryCheckpoint(kclConfig).WithLeaseTable(table))
+
+	assert.Nil(t, w.Start())
+	defer w.Shutdown()
+	assert.Eventually(t, func() bool {
+		return len(processor.delivered()) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	assert.Nil(t, w.PauseShard("shardId-0"))
+	assert.Eventually(t, func() bool {
+		status := w.Status()
+		return len(status.Shards) == 1 && status.Shards[0].State == ConsumerPaused
+	}, 5*time.Second, 10*time.Millisecond)
+	delivered := len(processor.delivered())
+
+	// the iterator held by the paused consumer expires, the shard is read again from the checkpoint
+	atomic.AddInt64(&generation, 1)
+	assert.Nil(t, w.ResumeShard("shardId-0"))
+	assert.Eventually(t, func() bool {
+		return len(processor.delivered()) > delivered+3
+	}, 5*time.Second, 10*time.Millisecond)
+	// the consumer goes on without failing on the expired iterator
+	processor.mux.Lock()
+	assert.Empty(t, processor.reasons)
+	processor.mux.Unlock()
+	for i, sequenceNumber := range processor.delivered() {
+		assert.Equal(t, strconv.Itoa(i), sequenceNumber)
+	}
+}
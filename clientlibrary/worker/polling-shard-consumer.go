@@ -32,7 +32,6 @@ package worker
 import (
 	"context"
 	"errors"
-	"math"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -40,6 +39,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
 
 	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
 	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
 	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/metrics"
 )
@@ -63,6 +63,7 @@ var (
 type PollingShardConsumer struct {
 	commonShardConsumer
 	streamName    string
+	streamARN     string
 	stop          *chan struct{}
 	consumerID    string
 	mService      metrics.MonitoringService
@@ -71,6 +72,9 @@ type PollingShardConsumer struct {
 	remBytes      int
 	lastCheckTime time.Time
 	bytesRead     int
+
+	// getRecordsBudget limits the GetRecords calls of all shard consumers of the worker
+	getRecordsBudget *getRecordsBudget
 }
 
 func (sc *PollingShardConsumer) getShardIterator() (*string, error) {
@@ -80,11 +84,15 @@ func (sc *PollingShardConsumer) getShardIterator() (*string, error) {
 	}
 
 	shardIterArgs := &kinesis.GetShardIteratorInput{
-		ShardId:                &sc.shard.ID,
+		ShardId:                aws.String(sc.shard.GetShardID()),
 		ShardIteratorType:      startPosition.Type,
 		StartingSequenceNumber: startPosition.SequenceNumber,
 		Timestamp:              startPosition.Timestamp,
-		StreamName:             &sc.streamName,
+	}
+	if sc.streamARN != "" {
+		shardIterArgs.StreamARN = aws.String(sc.streamARN)
+	} else {
+		shardIterArgs.StreamName = aws.String(sc.streamName)
 	}
 
 	iterResp, err := sc.kc.GetShardIterator(context.TODO(), shardIterArgs)
@@ -100,16 +108,17 @@ func (sc *PollingShardConsumer) getShardIterator() (*string, error) {
 func (sc *PollingShardConsumer) getRecords() error {
 	defer sc.releaseLease(sc.shard.ID)
 
-	log := sc.kclConfig.Logger
+	log := sc.getLogger()
 
 	// If the shard is child shard, need to wait until the parent finished.
 	if err := sc.waitOnParentShard(); err != nil {
 		// If parent shard has been deleted by Kinesis system already, just ignore the error.
-		if err != chk.ErrSequenceIDNotFound {
+		if !errors.Is(err, chk.ErrSequenceIDNotFound) {
 			log.Errorf("Error in waiting for parent shard: %v to finish. Error: %+v", sc.shard.ParentShardId, err)
 			return err
 		}
 	}
+	sc.status.setState(ConsumerInitializing)
 
 	shardIterator, err := sc.getShardIterator()
 	if err != nil {
@@ -117,15 +126,21 @@ func (sc *PollingShardConsumer) getRecords() error {
 		return err
 	}
 
-	// Start processing events and notify record processor on shard and starting checkpoint
-	input := &kcl.InitializationInput{
-		ShardId:                sc.shard.ID,
-		ExtendedSequenceNumber: &kcl.ExtendedSequenceNumber{SequenceNumber: aws.String(sc.shard.GetCheckpoint())},
-	}
-	sc.recordProcessor.Initialize(input)
+	// the lease is renewed until the record processor has been shut down
+	defer sc.startLeaseRenewer(sc.consumerID)()
 
-	recordCheckpointer := NewRecordProcessorCheckpoint(sc.shard, sc.checkpointer)
+	// Start processing events and notify record processor on shard and starting checkpoint
+	sc.recordProcessor.Initialize(sc.context(), sc.initializationInput())
+	sc.status.setState(ConsumerProcessing)
+
+	recordCheckpointer := sc.recordProcessorCheckpointer()
+	// the lease is lost or processing failed unless the record processor has been shut down already
+	defer sc.shutdownRecordProcessor(kcl.ZOMBIE, recordCheckpointer)
+	// the flusher is stopped before the final flush at shutdown
+	defer sc.startAsyncCheckpointFlusher(recordCheckpointer)()
 	retriedErrors := 0
+	// retrieval counts the throttled calls until the next read succeeds
+	var retrieval kcl.BatchMetadata
 
 	// define API call rate limit starting window
 	sc.currTime = rateLimitTimeNow()
@@ -133,122 +148,300 @@ func (sc *PollingShardConsumer) getRecords() error {
 	sc.bytesRead = 0
 	sc.remBytes = MaxBytes
 
+	// the records are read ahead while the record processor works on the current batch if prefetching is enabled
+	prefetcher := sc.startPrefetching(shardIterator)
+	defer func() {
+		// the prefetcher is started again when the shard is resumed
+		if prefetcher != nil {
+			prefetcher.stop()
+		}
+	}()
+	// the batches are fetched and processed in turns with the other shard consumers of the worker
+	schedulerTurn := &turn{scheduler: sc.scheduler}
+	defer schedulerTurn.end()
+	// the records of sparse reads are collected into larger batches if batching is enabled
+	batcher := sc.newRecordBatcher()
 	for {
-		if time.Now().UTC().After(sc.shard.GetLeaseTimeout().Add(-time.Duration(sc.kclConfig.LeaseRefreshPeriodMillis) * time.Millisecond)) {
-			log.Debugf("Refreshing lease on shard: %s for worker: %s", sc.shard.ID, sc.consumerID)
-			err = sc.checkpointer.GetLease(sc.shard, sc.consumerID)
+		// the lease is renewed by the lease renewer while the records are fetched and processed
+		if sc.renewer.isLost() {
+			return sc.leaseLost(recordCheckpointer)
+		}
+
+		// the claim request of another worker may have been seen when checkpointing or syncing the leases
+		if sc.isLeaseRequested() {
+			sc.handOffLease(recordCheckpointer)
+			return nil
+		}
+
+		// no records are fetched while the shard is paused by the application
+		paused, stopped, err := sc.waitWhilePaused(*sc.stop, recordCheckpointer)
+		if stopped {
+			return err
+		}
+		if paused {
+			// the shard is read again from the checkpoint, the records read ahead or collected before are dropped
+			shardIterator, err = sc.resumedShardIterator(recordCheckpointer)
 			if err != nil {
-				if errors.As(err, &chk.ErrLeaseNotAcquired{}) {
-					log.Warnf("Failed in acquiring lease on shard: %s for worker: %s", sc.shard.ID, sc.consumerID)
-					return nil
-				}
-				// log and return error
-				log.Errorf("Error in refreshing lease on shard: %s for worker: %s. Error: %+v",
-					sc.shard.ID, sc.consumerID, err)
+				log.Errorf("Unable to get shard iterator for resumed shard %s: %v", sc.shard.ID, err)
 				return err
 			}
-			// log metric for renewed lease for worker
-			sc.mService.LeaseRenewed(sc.shard.ID)
+			batcher.take()
+			if prefetcher != nil {
+				prefetcher.stop()
+				prefetcher = sc.startPrefetching(shardIterator)
+			}
 		}
 
-		getRecordsStartTime := time.Now()
-
-		log.Debugf("Trying to read %d record from iterator: %v", sc.kclConfig.MaxRecords, aws.ToString(shardIterator))
-
-		// Get records from stream and retry as needed
-		getRecordsArgs := &kinesis.GetRecordsInput{
-			Limit:         aws.Int32(int32(sc.kclConfig.MaxRecords)),
-			ShardIterator: shardIterator,
+		// back off from fetching records while the processing limits of the worker are exceeded
+		if !sc.limiter.wait(*sc.stop) {
+			sc.shutdownRecordProcessor(kcl.REQUESTED, recordCheckpointer)
+			return nil
 		}
-		getResp, coolDownPeriod, err := sc.callGetRecordsAPI(getRecordsArgs)
-		if err != nil {
-			//aws-sdk-go-v2 https://github.com/aws/aws-sdk-go-v2/blob/main/CHANGELOG.md#error-handling
-			var throughputExceededErr *types.ProvisionedThroughputExceededException
-			var kmsThrottlingErr *types.KMSThrottlingException
-			if errors.As(err, &throughputExceededErr) {
-				retriedErrors++
-				if retriedErrors > sc.kclConfig.MaxRetryCount {
-					log.Errorf("message", "Throughput Exceeded Error: "+
-						"reached max retry count getting records from shard",
-						"shardId", sc.shard.ID,
-						"retryCount", retriedErrors,
-						"error", err)
-					return err
+
+		var (
+			getRecordsStartTime time.Time
+			getResp             *kinesis.GetRecordsOutput
+			metadata            kcl.BatchMetadata
+			maxRecords          int
+			due                 bool
+		)
+		if prefetcher != nil {
+			// the records read ahead are taken in order while stopping, rewinding and releasing are still served
+			batch := prefetcher.next()
+			for batch == nil && !due {
+				select {
+				case <-*sc.stop:
+					sc.shutdownRecordProcessor(kcl.REQUESTED, recordCheckpointer)
+					return nil
+				case req := <-sc.status.rewindRequests():
+					return sc.rewind(req, recordCheckpointer)
+				case req := <-sc.status.releaseRequests():
+					return sc.release(req, recordCheckpointer)
+				case <-sc.renewer.lostSignal():
+					return sc.leaseLost(recordCheckpointer)
+				case <-batcher.expired():
+					// the collected records are delivered although no more records have been read ahead
+					due = true
+					continue
+				case <-prefetcher.available:
 				}
-				// If there is insufficient provisioned throughput on the stream,
-				// subsequent calls made within the next 1 second throw ProvisionedThroughputExceededException.
-				// ref: https://docs.aws.amazon.com/streams/latest/dev/service-sizes-and-limits.html
-				sc.waitASecond(sc.currTime)
-				continue
+				batch = prefetcher.next()
 			}
-			if err == localTPSExceededError {
-				log.Infof("localTPSExceededError so sleep for a second")
-				sc.waitASecond(sc.currTime)
-				continue
+			if due {
+				getResp = &kinesis.GetRecordsOutput{NextShardIterator: shardIterator}
+			} else if batch.err != nil {
+				return batch.err
+			} else {
+				getRecordsStartTime, getResp, maxRecords, metadata = batch.startTime, batch.output, batch.maxRecords, batch.metadata
 			}
-			if err == maxBytesExceededError {
-				log.Infof("maxBytesExceededError so sleep for %+v seconds", coolDownPeriod)
-				time.Sleep(time.Duration(coolDownPeriod) * time.Second)
-				continue
+			if !schedulerTurn.begin(*sc.stop) {
+				sc.shutdownRecordProcessor(kcl.REQUESTED, recordCheckpointer)
+				return nil
 			}
-			if errors.As(err, &kmsThrottlingErr) {
-				log.Errorf("Error getting records from shard %v: %+v", sc.shard.ID, err)
-				retriedErrors++
-				// Greater than MaxRetryCount so we get the last retry
-				if retriedErrors > sc.kclConfig.MaxRetryCount {
-					log.Errorf("message", "KMS Throttling Error: "+
-						"reached max retry count getting records from shard",
-						"shardId", sc.shard.ID,
-						"retryCount", retriedErrors,
-						"error", err)
-					return err
+		} else {
+			if !schedulerTurn.begin(*sc.stop) {
+				sc.shutdownRecordProcessor(kcl.REQUESTED, recordCheckpointer)
+				return nil
+			}
+			getRecordsStartTime = time.Now()
+			// the max number of records and the idle time may be changed while the shard is consumed
+			maxRecords = sc.maxRecords()
+			var delay time.Duration
+			getResp, delay, err = sc.fetchRecords(shardIterator, maxRecords, &retriedErrors, &retrieval, *sc.stop)
+			if err != nil {
+				return err
+			}
+			if getResp == nil {
+				// the turn is passed on to the other shards while backing off, the worker may also have been
+				// stopped while waiting for the GetRecords budget
+				schedulerTurn.end()
+				if !backOff(delay, *sc.stop) {
+					sc.shutdownRecordProcessor(kcl.REQUESTED, recordCheckpointer)
+					return nil
 				}
-				// exponential backoff
-				// https://docs.aws.amazon.com/amazondynamodb/latest/developerguide/Programming.Errors.html#Programming.Errors.RetryAndBackoff
-				time.Sleep(time.Duration(math.Exp2(float64(retriedErrors))*100) * time.Millisecond)
 				continue
 			}
-			log.Errorf("Error getting records from Kinesis that cannot be retried: %+v Request: %s", err, getRecordsArgs)
-			return err
+			metadata, retrieval = retrieval, kcl.BatchMetadata{}
 		}
-		// reset the retry count after success
-		retriedErrors = 0
 
-		err = sc.processRecords(getRecordsStartTime, getResp.Records, getResp.MillisBehindLatest, recordCheckpointer)
-		if err != nil {
-			return err
+		// the records are collected until the batch is due, the last records of a closed shard are delivered at once
+		if !due {
+			due = batcher.add(getRecordsStartTime, getResp.Records, getResp.MillisBehindLatest, metadata) || getResp.NextShardIterator == nil
 		}
+		if due {
+			batchStartTime, records, millisBehindLatest, batchMetadata := batcher.take()
+			err = sc.processRecords(batchStartTime, records, millisBehindLatest, batchMetadata, recordCheckpointer)
+			if err != nil {
+				return err
+			}
+		}
+		schedulerTurn.end()
 
 		// The shard has been closed, so no new records can be read from it
 		if getResp.NextShardIterator == nil {
 			log.Infof("Shard %s closed", sc.shard.ID)
-			shutdownInput := &kcl.ShutdownInput{ShutdownReason: kcl.TERMINATE, Checkpointer: recordCheckpointer}
-			sc.recordProcessor.Shutdown(shutdownInput)
+			sc.childShards = getResp.ChildShards
+			sc.shutdownRecordProcessor(kcl.TERMINATE, recordCheckpointer)
 			return nil
 		}
 		shardIterator = getResp.NextShardIterator
 
-		// Idle between each read, the user is responsible for checkpoint the progress
-		// This value is only used when no records are returned; if records are returned, it should immediately
-		// retrieve the next set of records.
-		if len(getResp.Records) == 0 && aws.ToInt64(getResp.MillisBehindLatest) < int64(sc.kclConfig.IdleTimeBetweenReadsInMillis) {
-			time.Sleep(time.Duration(sc.kclConfig.IdleTimeBetweenReadsInMillis) * time.Millisecond)
+		// the prefetcher idles between its own reads
+		if prefetcher == nil {
+			sc.idle(getResp, maxRecords, *sc.stop, batcher.expired())
 		}
 
 		select {
 		case <-*sc.stop:
-			shutdownInput := &kcl.ShutdownInput{ShutdownReason: kcl.REQUESTED, Checkpointer: recordCheckpointer}
-			sc.recordProcessor.Shutdown(shutdownInput)
+			sc.shutdownRecordProcessor(kcl.REQUESTED, recordCheckpointer)
 			return nil
+		case req := <-sc.status.rewindRequests():
+			return sc.rewind(req, recordCheckpointer)
+		case req := <-sc.status.releaseRequests():
+			return sc.release(req, recordCheckpointer)
 		default:
 		}
 	}
 }
 
-func (sc *PollingShardConsumer) waitASecond(timePassed time.Time) {
+// idle waits between the reads of the shard, the user is responsible for checkpointing the progress. The polling
+// strategy decides the delay from the size of the batch and the lag of the consumer; it retrieves the next set of
+// records immediately while the consumer is behind. Closing stop interrupts the idle time, as does the expiry of the
+// records collected for the next batch.
+func (sc *PollingShardConsumer) idle(getResp *kinesis.GetRecordsOutput, maxRecords int, stop <-chan struct{}, expired <-chan time.Time) {
+	delay := sc.pollingStrategy().NextPollDelay(config.PollResult{
+		ShardID:            sc.shard.ID,
+		RecordCount:        len(getResp.Records),
+		MaxRecords:         maxRecords,
+		MillisBehindLatest: aws.ToInt64(getResp.MillisBehindLatest),
+	})
+	if delay <= 0 {
+		return
+	}
+	select {
+	case <-stop:
+	case <-expired:
+	case <-time.After(delay):
+	}
+}
+
+// fetchRecords makes one GetRecords call from the shard iterator. The output is nil without an error if the call has
+// been throttled and should be retried after the returned back-off; retriedErrors counts the retries since the last
+// success. The caller backs off itself, so that it can end its scheduler turn and stop while backing off. The
+// throttled and successful calls are added to the retrieval metadata and reported to the monitoring service. The
+// output is nil as well if the stop channel has been closed while waiting for the GetRecords budget of the worker.
+func (sc *PollingShardConsumer) fetchRecords(shardIterator *string, maxRecords int, retriedErrors *int, retrieval *kcl.BatchMetadata, stop <-chan struct{}) (*kinesis.GetRecordsOutput, time.Duration, error) {
+	log := sc.getLogger()
+	if !sc.getRecordsBudget.acquire(stop) {
+		return nil, 0, nil
+	}
+	log.Debugf("Trying to read %d record from iterator: %v", maxRecords, aws.ToString(shardIterator))
+
+	// Get records from stream and retry as needed
+	getRecordsArgs := &kinesis.GetRecordsInput{
+		Limit:         aws.Int32(int32(maxRecords)),
+		ShardIterator: shardIterator,
+	}
+	if sc.streamARN != "" {
+		getRecordsArgs.StreamARN = aws.String(sc.streamARN)
+	}
+	_, span := sc.startSpan(sc.context(), "GetRecords")
+	callStartTime := time.Now()
+	getResp, coolDownPeriod, err := sc.callGetRecordsAPI(getRecordsArgs)
+	latency := time.Since(callStartTime)
+	endSpan(span, err)
+	if err != nil {
+		//aws-sdk-go-v2 https://github.com/aws/aws-sdk-go-v2/blob/main/CHANGELOG.md#error-handling
+		var throughputExceededErr *types.ProvisionedThroughputExceededException
+		var kmsThrottlingErr *types.KMSThrottlingException
+		if errors.As(err, &throughputExceededErr) || errors.As(err, &kmsThrottlingErr) {
+			retrieval.Throttles++
+			if m, ok := sc.commonShardConsumer.mService.(metrics.GetRecordsMonitoringService); ok {
+				m.IncrGetRecordsThrottles(sc.shard.ID)
+			}
+		}
+		if errors.As(err, &throughputExceededErr) {
+			*retriedErrors++
+			if *retriedErrors > sc.kclConfig.MaxRetryCount {
+				log.Errorf("message", "Throughput Exceeded Error: "+
+					"reached max retry count getting records from shard",
+					"shardId", sc.shard.ID,
+					"retryCount", *retriedErrors,
+					"error", err)
+				return nil, 0, chk.NewThrottlingError("GetRecords", err)
+			}
+			// If there is insufficient provisioned throughput on the stream,
+			// subsequent calls made within the next 1 second throw ProvisionedThroughputExceededException.
+			// ref: https://docs.aws.amazon.com/streams/latest/dev/service-sizes-and-limits.html
+			return nil, sc.restOfSecond(sc.currTime) + sc.kclConfig.RetryPolicy.Delay(*retriedErrors), nil
+		}
+		if err == localTPSExceededError {
+			log.Infof("localTPSExceededError so sleep for a second")
+			return nil, sc.restOfSecond(sc.currTime), nil
+		}
+		if err == maxBytesExceededError {
+			log.Infof("maxBytesExceededError so sleep for %+v seconds", coolDownPeriod)
+			return nil, time.Duration(coolDownPeriod) * time.Second, nil
+		}
+		if errors.As(err, &kmsThrottlingErr) {
+			log.Errorf("Error getting records from shard %v: %+v", sc.shard.ID, err)
+			*retriedErrors++
+			// Greater than MaxRetryCount so we get the last retry
+			if *retriedErrors > sc.kclConfig.MaxRetryCount {
+				log.Errorf("message", "KMS Throttling Error: "+
+					"reached max retry count getting records from shard",
+					"shardId", sc.shard.ID,
+					"retryCount", *retriedErrors,
+					"error", err)
+				return nil, 0, chk.NewThrottlingError("GetRecords", err)
+			}
+			// exponential backoff
+			// https://docs.aws.amazon.com/amazondynamodb/latest/developerguide/Programming.Errors.html#Programming.Errors.RetryAndBackoff
+			return nil, sc.kclConfig.RetryPolicy.Delay(*retriedErrors), nil
+		}
+		log.Errorf("Error getting records from Kinesis that cannot be retried: %+v Request: %s", err, getRecordsArgs)
+		return nil, 0, err
+	}
+
+	// reset the retry count after success
+	*retriedErrors = 0
+
+	read := readMetadata(getResp.Records, latency)
+	retrieval.Calls += read.Calls
+	retrieval.RecordCount += read.RecordCount
+	retrieval.BytesRead += read.BytesRead
+	retrieval.RetrievalLatency += read.RetrievalLatency
+	if m, ok := sc.commonShardConsumer.mService.(metrics.GetRecordsMonitoringService); ok {
+		m.RecordGetRecordsCall(sc.shard.ID, read.RecordCount, read.BytesRead, float64(latency.Milliseconds()))
+	}
+	return getResp, 0, nil
+}
+
+// restOfSecond returns the time left until a second has passed since timePassed
+func (sc *PollingShardConsumer) restOfSecond(timePassed time.Time) time.Duration {
 	waitTime := time.Since(timePassed)
 	if waitTime < time.Second {
-		time.Sleep(time.Second - waitTime)
+		return time.Second - waitTime
+	}
+	return 0
+}
+
+// backOff waits for the delay before a throttled read is retried. It returns false if the stop channel has been
+// closed meanwhile.
+func backOff(delay time.Duration, stop <-chan struct{}) bool {
+	if delay <= 0 {
+		select {
+		case <-stop:
+			return false
+		default:
+			return true
+		}
+	}
+	select {
+	case <-stop:
+		return false
+	case <-time.After(delay):
+		return true
 	}
 }
 
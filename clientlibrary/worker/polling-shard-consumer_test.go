@@ -22,13 +22,20 @@ package worker
 import (
 	"context"
 	"errors"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/metrics"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
 )
 
 var (
@@ -183,6 +190,63 @@ func TestCallGetRecordsAPI(t *testing.T) {
 
 }
 
+// getRecordsRecorder records the GetRecords calls and throttles reported to the monitoring service
+type getRecordsRecorder struct {
+	metrics.NoopMonitoringService
+	records, throttles int
+	bytes              int64
+}
+
+func (r *getRecordsRecorder) RecordGetRecordsCall(_ string, recordCount int, bytesRead int64, _ float64) {
+	r.records += recordCount
+	r.bytes += bytesRead
+}
+
+func (r *getRecordsRecorder) IncrGetRecordsThrottles(string) { r.throttles++ }
+
+func TestFetchRecordsMetadata(t *testing.T) {
+	m := MockKinesisSubscriberGetter{}
+	m.On("GetRecords", mock.Anything, mock.Anything, mock.Anything).Return((*kinesis.GetRecordsOutput)(nil), &types.KMSThrottlingException{}).Once()
+	m.On("GetRecords", mock.Anything, mock.Anything, mock.Anything).Return(&kinesis.GetRecordsOutput{
+		Records: []types.Record{{Data: []byte("abc")}, {Data: []byte("de")}},
+	}, nil).Once()
+
+	recorder := &getRecordsRecorder{}
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker")
+	kclConfig.RetryPolicy.BaseDelayMillis, kclConfig.RetryPolicy.MaxDelayMillis = 1, 1
+	sc := &PollingShardConsumer{
+		commonShardConsumer: commonShardConsumer{
+			kc:        &m,
+			kclConfig: kclConfig,
+			mService:  recorder,
+			shard:     &par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}},
+		},
+		callsLeft: kinesisReadTPSLimit,
+		currTime:  time.Now(),
+	}
+
+	retriedErrors := 0
+	var retrieval kcl.BatchMetadata
+	out, _, err := sc.fetchRecords(aws.String("iterator"), 10, &retriedErrors, &retrieval, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, out)
+	assert.Equal(t, 1, retrieval.Throttles)
+
+	// the throttled call is reported with the next successful read
+	out, _, err = sc.fetchRecords(aws.String("iterator"), 10, &retriedErrors, &retrieval, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(out.Records))
+	assert.Equal(t, 1, retrieval.Calls)
+	assert.Equal(t, 2, retrieval.RecordCount)
+	assert.Equal(t, int64(5), retrieval.BytesRead)
+	assert.Equal(t, 1, retrieval.Throttles)
+
+	assert.Equal(t, 2, recorder.records)
+	assert.Equal(t, int64(5), recorder.bytes)
+	assert.Equal(t, 1, recorder.throttles)
+	m.AssertExpectations(t)
+}
+
 type MockKinesisSubscriberGetter struct {
 	mock.Mock
 }
@@ -0,0 +1,194 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+)
+
+// prefetchedBatch is the output of a GetRecords call read ahead from a shard, or the error which stopped reading
+type prefetchedBatch struct {
+	startTime  time.Time
+	output     *kinesis.GetRecordsOutput
+	metadata   kcl.BatchMetadata
+	maxRecords int
+	records    int
+	bytes      int
+	err        error
+}
+
+func newPrefetchedBatch(startTime time.Time, output *kinesis.GetRecordsOutput, maxRecords int) *prefetchedBatch {
+	batch := &prefetchedBatch{
+		startTime:  startTime,
+		output:     output,
+		maxRecords: maxRecords,
+		records:    len(output.Records),
+	}
+	for _, record := range output.Records {
+		batch.bytes += len(record.Data)
+	}
+	return batch
+}
+
+// recordPrefetcher buffers the batches read ahead from a shard, in order, up to a max number of records and bytes.
+// An empty buffer accepts any batch, so that a batch larger than the limits does not stall the shard.
+type recordPrefetcher struct {
+	maxRecords int
+	maxBytes   int
+
+	mux     sync.Mutex
+	batches []*prefetchedBatch
+	records int
+	bytes   int
+
+	// available is signaled when a batch is added and freed when a batch is taken
+	available chan struct{}
+	freed     chan struct{}
+	done      chan struct{}
+	finished  chan struct{}
+}
+
+func newRecordPrefetcher(maxRecords, maxBytes int) *recordPrefetcher {
+	return &recordPrefetcher{
+		maxRecords: maxRecords,
+		maxBytes:   maxBytes,
+		available:  make(chan struct{}, 1),
+		freed:      make(chan struct{}, 1),
+		done:       make(chan struct{}),
+		finished:   make(chan struct{}),
+	}
+}
+
+// put adds the batch to the buffer once there is room for it. It returns false if the prefetcher has been stopped.
+func (p *recordPrefetcher) put(batch *prefetchedBatch) bool {
+	for {
+		p.mux.Lock()
+		if len(p.batches) == 0 || p.fits(batch) {
+			p.batches = append(p.batches, batch)
+			p.records += batch.records
+			p.bytes += batch.bytes
+			p.mux.Unlock()
+			notify(p.available)
+			return true
+		}
+		p.mux.Unlock()
+
+		select {
+		case <-p.done:
+			return false
+		case <-p.freed:
+		}
+	}
+}
+
+func (p *recordPrefetcher) fits(batch *prefetchedBatch) bool {
+	if p.records+batch.records > p.maxRecords {
+		return false
+	}
+	return p.maxBytes == 0 || p.bytes+batch.bytes <= p.maxBytes
+}
+
+// next takes the oldest batch from the buffer, or returns nil if the buffer is empty
+func (p *recordPrefetcher) next() *prefetchedBatch {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	if len(p.batches) == 0 {
+		return nil
+	}
+	batch := p.batches[0]
+	p.batches[0] = nil
+	p.batches = p.batches[1:]
+	p.records -= batch.records
+	p.bytes -= batch.bytes
+	notify(p.freed)
+	return batch
+}
+
+// stop stops reading ahead and waits until the pending read has completed
+func (p *recordPrefetcher) stop() {
+	close(p.done)
+	<-p.finished
+}
+
+// notify signals the channel unless it has been signaled already
+func notify(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+// startPrefetching reads ahead from the shard iterator while the record processor works on the current batch. It
+// returns nil if prefetching is disabled.
+func (sc *PollingShardConsumer) startPrefetching(shardIterator *string) *recordPrefetcher {
+	if sc.kclConfig.PrefetchMaxRecords <= 0 {
+		return nil
+	}
+
+	prefetcher := newRecordPrefetcher(sc.kclConfig.PrefetchMaxRecords, sc.kclConfig.PrefetchMaxBytes)
+	go sc.prefetchRecords(prefetcher, shardIterator)
+	return prefetcher
+}
+
+// prefetchRecords reads from the shard into the prefetcher until the shard is closed, a read fails or the prefetcher
+// is stopped. The idle time between the reads is decided by the polling strategy, as for the reads which are not
+// prefetched.
+func (sc *PollingShardConsumer) prefetchRecords(prefetcher *recordPrefetcher, shardIterator *string) {
+	defer close(prefetcher.finished)
+
+	retriedErrors := 0
+	var retrieval kcl.BatchMetadata
+	for {
+		select {
+		case <-prefetcher.done:
+			return
+		default:
+		}
+
+		startTime := time.Now()
+		maxRecords := sc.maxRecords()
+		getResp, delay, err := sc.fetchRecords(shardIterator, maxRecords, &retriedErrors, &retrieval, prefetcher.done)
+		if err != nil {
+			prefetcher.put(&prefetchedBatch{err: err})
+			return
+		}
+		if getResp == nil {
+			// the back-off of a throttled read does not delay the stop of the prefetcher
+			if !backOff(delay, prefetcher.done) {
+				return
+			}
+			continue
+		}
+
+		batch := newPrefetchedBatch(startTime, getResp, maxRecords)
+		batch.metadata, retrieval = retrieval, kcl.BatchMetadata{}
+		if !prefetcher.put(batch) || getResp.NextShardIterator == nil {
+			return
+		}
+		shardIterator = getResp.NextShardIterator
+		sc.idle(getResp, maxRecords, prefetcher.done, nil)
+	}
+}
@@ -0,0 +1,182 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/metrics"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+// shardReader returns batches of two records up to the end of the shard
+type shardReader struct {
+	KinesisSubscriberGetter
+	mux     sync.Mutex
+	calls   int
+	batches int
+}
+
+func (r *shardReader) GetRecords(ctx context.Context, params *kinesis.GetRecordsInput, optFns ...func(*kinesis.Options)) (*kinesis.GetRecordsOutput, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	n := r.calls
+	r.calls++
+	out := &kinesis.GetRecordsOutput{
+		Records: []types.Record{
+			{SequenceNumber: aws.String(fmt.Sprint(2 * n)), Data: []byte("ab")},
+			{SequenceNumber: aws.String(fmt.Sprint(2*n + 1)), Data: []byte("cd")},
+		},
+		MillisBehindLatest: aws.Int64(0),
+	}
+	if n+1 < r.batches {
+		out.NextShardIterator = aws.String(fmt.Sprintf("iterator-%d", n+1))
+	}
+	return out, nil
+}
+
+func (r *shardReader) getCalls() int {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return r.calls
+}
+
+func TestPrefetchRecords(t *testing.T) {
+	reader := &shardReader{batches: 4}
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+		WithPrefetch(4, 0).
+		WithPollingStrategy(config.FixedPollingStrategy{})
+	sc := &PollingShardConsumer{
+		commonShardConsumer: commonShardConsumer{
+			kc:        reader,
+			kclConfig: kclConfig,
+			mService:  metrics.NoopMonitoringService{},
+			shard:     &par.ShardStatus{ID: "shardId-0"},
+		},
+		currTime:  time.Now(),
+		callsLeft: kinesisReadTPSLimit,
+		remBytes:  MaxBytes,
+	}
+
+	prefetcher := sc.startPrefetching(aws.String("iterator-0"))
+	// two batches of two records fill the buffer, the third batch waits for room in the buffer
+	assert.Eventually(t, func() bool { return reader.getCalls() == 3 }, time.Second, time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 3, reader.getCalls())
+
+	var sequenceNumbers []string
+	for len(sequenceNumbers) < 8 {
+		batch := prefetcher.next()
+		if batch == nil {
+			<-prefetcher.available
+			continue
+		}
+		assert.Nil(t, batch.err)
+		for _, record := range batch.output.Records {
+			sequenceNumbers = append(sequenceNumbers, aws.ToString(record.SequenceNumber))
+		}
+	}
+	assert.Equal(t, []string{"0", "1", "2", "3", "4", "5", "6", "7"}, sequenceNumbers)
+
+	// the prefetcher has stopped at the end of the shard
+	prefetcher.stop()
+	assert.Equal(t, 4, reader.getCalls())
+	assert.Nil(t, prefetcher.next())
+
+	// prefetching is disabled by default
+	sc.kclConfig = config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker")
+	assert.Nil(t, sc.startPrefetching(aws.String("iterator-0")))
+}
+
+// throttledReader fails all GetRecords calls with ProvisionedThroughputExceededException
+type throttledReader struct {
+	KinesisSubscriberGetter
+	calls int32
+}
+
+func (r *throttledReader) GetRecords(ctx context.Context, params *kinesis.GetRecordsInput, optFns ...func(*kinesis.Options)) (*kinesis.GetRecordsOutput, error) {
+	atomic.AddInt32(&r.calls, 1)
+	return nil, &types.ProvisionedThroughputExceededException{Message: aws.String("throttled")}
+}
+
+func TestPrefetcherStopsWhileBackingOff(t *testing.T) {
+	reader := &throttledReader{}
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+		WithPrefetch(4, 0).
+		WithMaxRetryCount(100)
+	sc := &PollingShardConsumer{
+		commonShardConsumer: commonShardConsumer{
+			kc:        reader,
+			kclConfig: kclConfig,
+			mService:  metrics.NoopMonitoringService{},
+			shard:     &par.ShardStatus{ID: "shardId-0"},
+		},
+		currTime:  time.Now(),
+		callsLeft: kinesisReadTPSLimit,
+		remBytes:  MaxBytes,
+	}
+
+	prefetcher := sc.startPrefetching(aws.String("iterator-0"))
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&reader.calls) > 0 }, time.Second, time.Millisecond)
+
+	// the throttled read backs off for about a second, the stop does not wait for it
+	start := time.Now()
+	prefetcher.stop()
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&reader.calls))
+}
+
+func TestRecordPrefetcherLimits(t *testing.T) {
+	prefetcher := newRecordPrefetcher(10, 5)
+	batch := func(records, bytes int) *prefetchedBatch {
+		return &prefetchedBatch{records: records, bytes: bytes}
+	}
+
+	// a batch larger than the limits is accepted by the empty buffer
+	assert.True(t, prefetcher.put(batch(1, 8)))
+
+	put := make(chan bool)
+	go func() { put <- prefetcher.put(batch(1, 1)) }()
+	select {
+	case <-put:
+		t.Fatal("the batch should wait for room in the buffer")
+	case <-time.After(20 * time.Millisecond):
+	}
+	assert.Equal(t, 8, prefetcher.next().bytes)
+	assert.True(t, <-put)
+
+	// a full buffer does not block a stopped prefetcher
+	assert.True(t, prefetcher.put(batch(8, 1)))
+	go func() { put <- prefetcher.put(batch(2, 1)) }()
+	close(prefetcher.done)
+	assert.False(t, <-put)
+}
@@ -0,0 +1,125 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"sync"
+	"time"
+)
+
+// limiterBackoff is how long shard consumers wait before checking again whether the in-flight bytes went below the limit
+const limiterBackoff = 100 * time.Millisecond
+
+// processingLimiter limits the records processed per second and the bytes being processed by all shard consumers
+// of a worker. Shard consumers wait before fetching records while a limit is exceeded, so that the records are not
+// buffered. A zero limit is unlimited.
+type processingLimiter struct {
+	maxRecordsPerSecond int
+	maxInFlightBytes    int64
+
+	mux sync.Mutex
+	// tokens is the number of records which can be processed, it becomes negative if a batch exceeds the budget
+	tokens        float64
+	lastRefill    time.Time
+	inFlightBytes int64
+}
+
+func newProcessingLimiter(maxRecordsPerSecond int, maxInFlightBytes int64) *processingLimiter {
+	return &processingLimiter{
+		maxRecordsPerSecond: maxRecordsPerSecond,
+		maxInFlightBytes:    maxInFlightBytes,
+		tokens:              float64(maxRecordsPerSecond),
+		lastRefill:          time.Now(),
+	}
+}
+
+// wait blocks until records can be fetched again. It returns false if the stop channel was closed while waiting.
+func (l *processingLimiter) wait(stop <-chan struct{}) bool {
+	for {
+		delay := l.delay()
+		if delay <= 0 {
+			return true
+		}
+
+		select {
+		case <-stop:
+			return false
+		case <-time.After(delay):
+		}
+	}
+}
+
+// acquire records a batch of records being processed
+func (l *processingLimiter) acquire(records int, bytes int64) {
+	if l == nil {
+		return
+	}
+
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.refill()
+	if l.maxRecordsPerSecond > 0 {
+		l.tokens -= float64(records)
+	}
+	l.inFlightBytes += bytes
+}
+
+// release records that a batch has been processed
+func (l *processingLimiter) release(bytes int64) {
+	if l == nil {
+		return
+	}
+
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.inFlightBytes -= bytes
+}
+
+// delay returns how long to wait until the limits are no longer exceeded
+func (l *processingLimiter) delay() time.Duration {
+	if l == nil {
+		return 0
+	}
+
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.refill()
+
+	var delay time.Duration
+	if l.maxRecordsPerSecond > 0 && l.tokens < 1 {
+		delay = time.Duration((1 - l.tokens) / float64(l.maxRecordsPerSecond) * float64(time.Second))
+	}
+	if l.maxInFlightBytes > 0 && l.inFlightBytes >= l.maxInFlightBytes && delay < limiterBackoff {
+		delay = limiterBackoff
+	}
+	return delay
+}
+
+// refill adds the tokens for the time passed since the last refill, up to one second worth of records
+func (l *processingLimiter) refill() {
+	now := time.Now()
+	if l.maxRecordsPerSecond > 0 {
+		l.tokens += now.Sub(l.lastRefill).Seconds() * float64(l.maxRecordsPerSecond)
+		if l.tokens > float64(l.maxRecordsPerSecond) {
+			l.tokens = float64(l.maxRecordsPerSecond)
+		}
+	}
+	l.lastRefill = now
+}
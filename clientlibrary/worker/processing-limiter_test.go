@@ -0,0 +1,67 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProcessingLimiterRecordsPerSecond(t *testing.T) {
+	l := newProcessingLimiter(100, 0)
+	assert.Equal(t, time.Duration(0), l.delay())
+
+	// a batch exceeding the budget has to be paid back before fetching again
+	l.acquire(150, 0)
+	delay := l.delay()
+	assert.True(t, delay > 450*time.Millisecond && delay <= 510*time.Millisecond, delay)
+
+	l.lastRefill = l.lastRefill.Add(-time.Second)
+	assert.Equal(t, time.Duration(0), l.delay())
+}
+
+func TestProcessingLimiterInFlightBytes(t *testing.T) {
+	l := newProcessingLimiter(0, 1000)
+	l.acquire(10, 600)
+	assert.Equal(t, time.Duration(0), l.delay())
+
+	l.acquire(10, 600)
+	assert.Equal(t, limiterBackoff, l.delay())
+
+	l.release(600)
+	assert.Equal(t, time.Duration(0), l.delay())
+
+	// waiting is interrupted by the worker shutdown
+	l.acquire(10, 600)
+	stop := make(chan struct{})
+	close(stop)
+	assert.False(t, l.wait(stop))
+}
+
+func TestUnlimitedProcessingLimiter(t *testing.T) {
+	var l *processingLimiter
+	l.acquire(1000, 1000)
+	l.release(1000)
+	assert.True(t, l.wait(nil))
+
+	assert.True(t, newProcessingLimiter(0, 0).wait(nil))
+}
@@ -0,0 +1,211 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package worker
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+)
+
+// ErrWorkerNotRunning is returned when the leases of a worker are rebalanced before it has been started
+var ErrWorkerNotRunning = errors.New("the worker is not running")
+
+type (
+	// releaseRequest asks the consumer of a shard to shut down its record processor and release the lease
+	releaseRequest struct {
+		done chan error
+	}
+
+	// rebalanceRequest asks the event loop for the shards held above the fair share of the worker
+	rebalanceRequest struct {
+		shardIDs []string
+		err      error
+		done     chan struct{}
+	}
+)
+
+// ReleaseShard shuts down the record processor of a shard consumed by the worker with REQUESTED and releases the
+// lease once it has returned, so that another worker takes the shard over right away instead of waiting for the
+// lease to expire, e.g. before a deployment or while a node is drained. The worker does not acquire the lease
+// again within FailoverTimeMillis, the time the other workers would have waited for it to expire.
+func (w *Worker) ReleaseShard(shardID string) error {
+	w.statusMux.Lock()
+	status := w.consumers[shardID]
+	if status != nil {
+		w.releasedShards[shardID] = time.Now()
+	}
+	w.statusMux.Unlock()
+	if status == nil {
+		return ErrShardNotConsumed
+	}
+
+	req := &releaseRequest{done: make(chan error, 1)}
+	select {
+	case status.release <- req:
+	default:
+		return fmt.Errorf("shard %s is being released already", shardID)
+	}
+
+	select {
+	case err := <-req.done:
+		return err
+	case <-w.ctx.Done():
+		return fmt.Errorf("worker shut down while releasing shard %s", shardID)
+	}
+}
+
+// Rebalance releases the leases held by the worker above its fair share of the leases of the active workers,
+// rounded up and bounded by MaxLeasesForWorker, so that they are taken over by the workers holding fewer leases.
+// A worker below its fair share claims leases of the most loaded worker right away if lease stealing is enabled.
+// It returns the first error of the released shards in the order of their lease keys.
+func (w *Worker) Rebalance() error {
+	if w.ctx == nil {
+		return ErrWorkerNotRunning
+	}
+
+	req := &rebalanceRequest{done: make(chan struct{})}
+	select {
+	case w.rebalanceRequests <- req:
+	case <-w.ctx.Done():
+		return ErrWorkerNotRunning
+	}
+	select {
+	case <-req.done:
+	case <-w.ctx.Done():
+		return ErrWorkerNotRunning
+	}
+	if req.err != nil {
+		return req.err
+	}
+
+	errs := make([]error, len(req.shardIDs))
+	var wg sync.WaitGroup
+	for i, shardID := range req.shardIDs {
+		wg.Add(1)
+		go func(i int, shardID string) {
+			defer wg.Done()
+			errs[i] = w.ReleaseShard(shardID)
+		}(i, shardID)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return fmt.Errorf("failed to release shard %s: %w", req.shardIDs[i], err)
+		}
+	}
+	return nil
+}
+
+// handleRebalance finds the shards to shed for a rebalance requested by the application, it is called by the event
+// loop which owns the shard status
+func (w *Worker) handleRebalance(req *rebalanceRequest) {
+	defer close(req.done)
+
+	workers, err := w.checkpointer.ListActiveWorkers(w.shardStatus)
+	if err != nil {
+		// the shards without lease owner are acquired before the leases held are rebalanced
+		if !errors.Is(err, chk.ErrShardNotAssigned) {
+			req.err = err
+		}
+		return
+	}
+
+	var numShards int
+	for _, shards := range workers {
+		numShards += len(shards)
+	}
+	numWorkers := len(workers)
+	if _, ok := workers[w.workerID]; !ok {
+		numWorkers++
+	}
+	target := numShards / numWorkers
+	if numShards%numWorkers != 0 {
+		target++
+	}
+	if maxLeases := w.settings.getMaxLeasesForWorker(); target > maxLeases {
+		target = maxLeases
+	}
+
+	held := workers[w.workerID]
+	if len(held) <= target {
+		if len(held) < target && w.kclConfig.EnableLeaseStealing {
+			req.err = w.rebalance()
+		}
+		return
+	}
+
+	shardIDs := make([]string, 0, len(held))
+	for _, shard := range held {
+		shardIDs = append(shardIDs, shard.ID)
+	}
+	sort.Strings(shardIDs)
+	req.shardIDs = shardIDs[target:]
+	w.log.Infof("Rebalancing %d leases above the fair share of %d leases: %v", len(req.shardIDs), target, req.shardIDs)
+}
+
+// isReleased returns true if the shard has been released by the worker within FailoverTimeMillis
+func (w *Worker) isReleased(shardID string) bool {
+	w.statusMux.Lock()
+	defer w.statusMux.Unlock()
+
+	releasedAt, ok := w.releasedShards[shardID]
+	if !ok {
+		return false
+	}
+	if time.Since(releasedAt) >= time.Duration(w.kclConfig.FailoverTimeMillis)*time.Millisecond {
+		delete(w.releasedShards, shardID)
+		return false
+	}
+	return true
+}
+
+// releaseRequests returns the releases requested for the shard, it blocks forever without a worker
+func (s *consumerStatus) releaseRequests() <-chan *releaseRequest {
+	if s == nil {
+		return nil
+	}
+	return s.release
+}
+
+// cancelRelease fails a pending release of a consumer which has stopped
+func (s *consumerStatus) cancelRelease() {
+	select {
+	case req := <-s.release:
+		req.done <- ErrShardNotConsumed
+	default:
+	}
+}
+
+// release shuts down the record processor for the lease to be released by the consumer, the request is completed
+// once the lease owner has been removed
+func (sc *commonShardConsumer) release(req *releaseRequest, checkpointer kcl.IRecordProcessorCheckpointer) error {
+	sc.getLogger().Infof("Releasing the lease of shard %s", sc.shard.ID)
+	sc.shutdownRecordProcessor(kcl.REQUESTED, checkpointer)
+	sc.releaseRequest = req
+	return nil
+}
@@ -0,0 +1,114 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+
+	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+func TestReleaseShard(t *testing.T) {
+	var positions []string
+	var mux sync.Mutex
+	server := newReplayServer(t, &positions, &mux)
+	defer server.Close()
+
+	table, _ := chk.NewMemoryLeaseTable("")
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+		WithIdleTimeBetweenReadsInMillis(10).
+		WithShardSyncIntervalMillis(10)
+	checkpointer := chk.NewMemoryCheckpoint(kclConfig).WithLeaseTable(table)
+	processor := &replayProcessor{}
+	recorder := &eventRecorder{}
+	w := NewWorker(replayProcessorFactory{processor}, kclConfig).
+		WithKinesis(newTestKinesisClient(server.URL)).
+		WithCheckpointer(checkpointer).
+		WithStateListener(recorder)
+
+	assert.Equal(t, ErrShardNotConsumed, w.ReleaseShard("shardId-0"))
+	assert.Equal(t, ErrWorkerNotRunning, w.Rebalance())
+
+	assert.Nil(t, w.Start())
+	defer w.Shutdown()
+	assert.Eventually(t, func() bool {
+		return len(processor.delivered()) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the record processor checkpoints before the lease is released
+	assert.Nil(t, w.ReleaseShard("shardId-0"))
+	processor.mux.Lock()
+	assert.Equal(t, []kcl.ShutdownReason{kcl.REQUESTED}, processor.reasons)
+	processor.mux.Unlock()
+
+	shard := &par.ShardStatus{ID: "shardId-0", Mux: &sync.RWMutex{}}
+	assert.Nil(t, checkpointer.FetchCheckpoint(shard))
+	assert.Equal(t, "101", shard.GetCheckpoint())
+	assert.Equal(t, "", shard.GetLeaseOwner())
+
+	// the released lease is left to the other workers for the failover time
+	time.Sleep(100 * time.Millisecond)
+	assert.Nil(t, checkpointer.FetchCheckpoint(shard))
+	assert.Equal(t, "", shard.GetLeaseOwner())
+	assert.Equal(t, []string{"started worker", "acquired shardId-0", "lost shardId-0"}, recorder.recorded())
+	assert.Equal(t, ErrShardNotConsumed, w.ReleaseShard("shardId-0"))
+}
+
+func TestHandleRebalance(t *testing.T) {
+	table, _ := chk.NewMemoryLeaseTable("")
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker")
+	checkpointer := chk.NewMemoryCheckpoint(kclConfig).WithLeaseTable(table)
+	w := NewWorker(shutdownRecorderFactory{}, kclConfig).WithCheckpointer(checkpointer)
+
+	w.shardStatus = map[string]*par.ShardStatus{}
+	for i, owner := range []string{"worker", "worker", "worker", "other"} {
+		shard := &par.ShardStatus{ID: "shard-" + string(rune('0'+i)), Mux: &sync.RWMutex{}}
+		assert.Nil(t, checkpointer.GetLease(shard, owner))
+		w.shardStatus[shard.ID] = shard
+	}
+
+	// the fair share of 4 leases and 2 workers is 2 leases
+	req := &rebalanceRequest{done: make(chan struct{})}
+	w.handleRebalance(req)
+	assert.Nil(t, req.err)
+	assert.Equal(t, []string{"shard-2"}, req.shardIDs)
+
+	// the fair share is bounded by the max number of leases of the worker
+	assert.Nil(t, w.UpdateConfig(ConfigUpdate{MaxLeasesForWorker: aws.Int(1)}))
+	req = &rebalanceRequest{done: make(chan struct{})}
+	w.handleRebalance(req)
+	assert.Nil(t, req.err)
+	assert.Equal(t, []string{"shard-1", "shard-2"}, req.shardIDs)
+
+	// the leases are not rebalanced while shards are not assigned
+	w.shardStatus["shard-4"] = &par.ShardStatus{ID: "shard-4", Mux: &sync.RWMutex{}}
+	req = &rebalanceRequest{done: make(chan struct{})}
+	w.handleRebalance(req)
+	assert.Nil(t, req.err)
+	assert.Empty(t, req.shardIDs)
+}
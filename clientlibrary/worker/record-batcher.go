@@ -0,0 +1,107 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+)
+
+// recordBatcher collects the records read from a shard until there are at least minBatchSize of them or the first
+// of them has waited for maxWait, so that sparse streams deliver fewer, larger batches to the record processor.
+type recordBatcher struct {
+	minBatchSize int
+	maxWait      time.Duration
+
+	records            []types.Record
+	startTime          time.Time
+	millisBehindLatest *int64
+	metadata           kcl.BatchMetadata
+	deadline           time.Time
+	timer              *time.Timer
+}
+
+// newRecordBatcher returns a batcher which delivers every read at once if minBatchSize is 1 or less.
+func newRecordBatcher(minBatchSize int, maxWait time.Duration) *recordBatcher {
+	return &recordBatcher{minBatchSize: minBatchSize, maxWait: maxWait}
+}
+
+// add collects the records read at startTime with the metadata of the read and reports whether the collected batch
+// is due. A read without records is due unless records have been collected already, so that the lag of the shard is
+// still reported while it is idle.
+func (b *recordBatcher) add(startTime time.Time, records []types.Record, millisBehindLatest *int64, metadata kcl.BatchMetadata) bool {
+	if len(b.records) == 0 {
+		b.startTime = startTime
+	}
+	b.records = append(b.records, records...)
+	b.millisBehindLatest = millisBehindLatest
+	b.metadata.Calls += metadata.Calls
+	b.metadata.RecordCount += metadata.RecordCount
+	b.metadata.BytesRead += metadata.BytesRead
+	b.metadata.RetrievalLatency += metadata.RetrievalLatency
+	b.metadata.Throttles += metadata.Throttles
+
+	if len(b.records) == 0 || len(b.records) >= b.minBatchSize {
+		return true
+	}
+	if b.timer == nil {
+		b.deadline = time.Now().Add(b.maxWait)
+		b.timer = time.NewTimer(b.maxWait)
+	}
+	return !time.Now().Before(b.deadline)
+}
+
+// expired is signaled once the collected records have waited for maxWait, it blocks forever while no records are
+// collected.
+func (b *recordBatcher) expired() <-chan time.Time {
+	if b.timer == nil {
+		return nil
+	}
+	return b.timer.C
+}
+
+// take returns the collected batch with the start time of its first read, the lag of its last read and the metadata
+// summed over its reads, and starts collecting the next batch.
+func (b *recordBatcher) take() (time.Time, []types.Record, *int64, *kcl.BatchMetadata) {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	records, metadata := b.records, b.metadata
+	b.records, b.metadata = nil, kcl.BatchMetadata{}
+	return b.startTime, records, b.millisBehindLatest, &metadata
+}
+
+// readMetadata returns the metadata of one read of the records which took the latency
+func readMetadata(records []types.Record, latency time.Duration) kcl.BatchMetadata {
+	metadata := kcl.BatchMetadata{Calls: 1, RecordCount: len(records), RetrievalLatency: latency}
+	for _, r := range records {
+		metadata.BytesRead += int64(len(r.Data))
+	}
+	return metadata
+}
+
+// newRecordBatcher returns the batcher of the records read by the shard consumer
+func (sc *commonShardConsumer) newRecordBatcher() *recordBatcher {
+	return newRecordBatcher(sc.kclConfig.MinBatchSize, time.Duration(sc.kclConfig.MaxBatchWaitTimeMillis)*time.Millisecond)
+}
@@ -0,0 +1,94 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/stretchr/testify/assert"
+
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+)
+
+func batcherRecords(from, n int) []types.Record {
+	records := make([]types.Record, n)
+	for i := range records {
+		records[i] = types.Record{SequenceNumber: aws.String(fmt.Sprint(from + i))}
+	}
+	return records
+}
+
+func TestRecordBatcherMinBatchSize(t *testing.T) {
+	b := newRecordBatcher(5, time.Minute)
+	first := time.Now()
+
+	// an empty read is delivered while no records are collected
+	assert.True(t, b.add(first, nil, aws.Int64(10), readMetadata(nil, time.Millisecond)))
+	_, records, millisBehindLatest, metadata := b.take()
+	assert.Equal(t, 0, len(records))
+	assert.Equal(t, int64(10), *millisBehindLatest)
+	assert.Equal(t, 1, metadata.Calls)
+	assert.Nil(t, b.expired())
+
+	assert.False(t, b.add(first, batcherRecords(0, 2), aws.Int64(3), readMetadata(batcherRecords(0, 2), time.Millisecond)))
+	assert.NotNil(t, b.expired())
+	assert.False(t, b.add(first.Add(time.Second), nil, aws.Int64(2), kcl.BatchMetadata{Throttles: 1}))
+	assert.True(t, b.add(first.Add(2*time.Second), batcherRecords(2, 3), aws.Int64(1), readMetadata(batcherRecords(2, 3), time.Millisecond)))
+
+	startTime, records, millisBehindLatest, metadata := b.take()
+	assert.Equal(t, first, startTime)
+	assert.Equal(t, batcherRecords(0, 5), records)
+	assert.Equal(t, int64(1), *millisBehindLatest)
+	// the metadata is summed over the reads of the batch
+	assert.Equal(t, kcl.BatchMetadata{
+		Calls:            2,
+		RecordCount:      5,
+		RetrievalLatency: 2 * time.Millisecond,
+		Throttles:        1,
+	}, *metadata)
+	assert.Nil(t, b.expired())
+}
+
+func TestRecordBatcherMaxWait(t *testing.T) {
+	b := newRecordBatcher(100, 20*time.Millisecond)
+	assert.False(t, b.add(time.Now(), batcherRecords(0, 1), aws.Int64(0), kcl.BatchMetadata{}))
+
+	select {
+	case <-b.expired():
+	case <-time.After(time.Second):
+		t.Fatal("the collected records have not expired")
+	}
+	// the records read after the expiry are delivered with the collected ones
+	assert.True(t, b.add(time.Now(), batcherRecords(1, 1), aws.Int64(0), kcl.BatchMetadata{}))
+	_, records, _, _ := b.take()
+	assert.Equal(t, batcherRecords(0, 2), records)
+}
+
+func TestRecordBatcherDisabled(t *testing.T) {
+	b := newRecordBatcher(0, 0)
+	assert.True(t, b.add(time.Now(), batcherRecords(0, 1), aws.Int64(0), kcl.BatchMetadata{}))
+	_, records, _, _ := b.take()
+	assert.Equal(t, 1, len(records))
+	assert.Nil(t, b.expired())
+}
@@ -21,20 +21,41 @@
 package worker
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
 	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/metrics"
 	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/sequencenumber"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"math"
 	"time"
 )
 
+// ShutdownError and LeaseExpiredError are wrapped by the LeaseLostError returned when a record processor
+// checkpoints a shard whose lease has been lost, errors.Is(err, chk.ErrLeaseLost) matches both of them.
+// MaxCheckpointMetadataSize is the maximum number of bytes of metadata stored with a checkpoint, leases are stored
+// as items of at most 400 KB in DynamoDB
+const MaxCheckpointMetadataSize = 64 * 1024
+
 var (
 	ShutdownError     = errors.New("another instance may have started processing some of these records already")
 	LeaseExpiredError = errors.New("the lease has on the shard has expired")
 )
 
 type (
+	// SkippedSequenceError is returned when a record processor checkpoints a sequence number before the current
+	// checkpoint of the shard
+	SkippedSequenceError struct {
+		ShardID        string
+		Checkpoint     string
+		SequenceNumber string
+	}
 
 	// PreparedCheckpointer
 	/*
@@ -56,16 +77,52 @@ type (
 	RecordProcessorCheckpointer struct {
 		shard      *par.ShardStatus
 		checkpoint chk.Checkpointer
+		mService   metrics.MonitoringService
+
+		// ctx is the context of the span tracing the records being checkpointed
+		ctx    context.Context
+		tracer trace.Tracer
+
+		// async keeps the checkpoints requested by CheckpointAsync until they are flushed
+		async *asyncCheckpoint
+
+		// allowRewind disables the rejection of checkpoints before the current checkpoint
+		allowRewind bool
+		validator   config.CheckpointValidator
+
+		// onLeaseLost is called when a checkpoint is rejected because another worker has taken the lease
+		onLeaseLost func(error)
 	}
 )
 
+func (e *SkippedSequenceError) Error() string {
+	return fmt.Sprintf("sequence number %s is before the current checkpoint %s of shard %s", e.SequenceNumber, e.Checkpoint, e.ShardID)
+}
+
 func NewRecordProcessorCheckpoint(shard *par.ShardStatus, checkpoint chk.Checkpointer) kcl.IRecordProcessorCheckpointer {
+	return newRecordProcessorCheckpointer(shard, checkpoint, metrics.NoopMonitoringService{})
+}
+
+// newRecordProcessorCheckpointer returns a checkpointer reporting failed checkpoints to the monitoring service
+func newRecordProcessorCheckpointer(shard *par.ShardStatus, checkpoint chk.Checkpointer, mService metrics.MonitoringService) kcl.IRecordProcessorCheckpointer {
 	return &RecordProcessorCheckpointer{
 		shard:      shard,
 		checkpoint: checkpoint,
+		mService:   mService,
+		ctx:        context.Background(),
+		tracer:     trace.NewNoopTracerProvider().Tracer(tracerName),
+		async:      &asyncCheckpoint{},
 	}
 }
 
+// withContext returns a copy of the checkpointer tracing its checkpoints as children of the span of the context
+func (rc *RecordProcessorCheckpointer) withContext(ctx context.Context, tracer trace.Tracer) *RecordProcessorCheckpointer {
+	checkpointer := *rc
+	checkpointer.ctx = ctx
+	checkpointer.tracer = tracer
+	return &checkpointer
+}
+
 func (pc *PreparedCheckpointer) GetPendingCheckpoint() *kcl.ExtendedSequenceNumber {
 	return pc.pendingCheckpointSequenceNumber
 }
@@ -75,17 +132,81 @@ func (pc *PreparedCheckpointer) Checkpoint() error {
 }
 
 func (rc *RecordProcessorCheckpointer) Checkpoint(sequenceNumber *string) error {
-	// return shutdown error if lease is expired or another worker has started processing records for this shard
-	currLeaseOwner, err := rc.checkpoint.GetLeaseOwner(rc.shard.ID)
-	if err != nil {
-		return err
+	return rc.commit(func() error {
+		return rc.checkpointAt(sequenceNumber, nil)
+	})
+}
+
+// CheckpointWithMetadata checkpoints the progress at the sequence number and stores the metadata with it
+func (rc *RecordProcessorCheckpointer) CheckpointWithMetadata(sequenceNumber *string, metadata []byte) error {
+	if len(metadata) > MaxCheckpointMetadataSize {
+		return fmt.Errorf("checkpoint metadata of %d bytes exceeds the limit of %d bytes", len(metadata), MaxCheckpointMetadataSize)
 	}
-	if rc.shard.AssignedTo != currLeaseOwner {
-		return ShutdownError
+
+	return rc.commit(func() error {
+		return rc.checkpointAt(sequenceNumber, metadata)
+	})
+}
+
+// CheckpointAsync requests a checkpoint which is stored by the next flush of the pending checkpoint
+func (rc *RecordProcessorCheckpointer) CheckpointAsync(sequenceNumber *string) <-chan error {
+	return rc.async.request(sequenceNumber)
+}
+
+// flushAsyncCheckpoint stores the last valid checkpoint requested by CheckpointAsync, if any
+func (rc *RecordProcessorCheckpointer) flushAsyncCheckpoint() error {
+	rc.async.writeMux.Lock()
+	defer rc.async.writeMux.Unlock()
+
+	requests := rc.validateAsyncRequests(rc.async.take())
+	if len(requests) == 0 {
+		return nil
 	}
-	if time.Now().After(rc.shard.LeaseTimeout) {
-		return LeaseExpiredError
+
+	err := rc.checkpointAt(requests[len(requests)-1].sequenceNumber, nil)
+	for _, request := range requests {
+		request.done(err)
 	}
+	return err
+}
+
+// commit stores a checkpoint which supersedes the pending asynchronous checkpoints
+func (rc *RecordProcessorCheckpointer) commit(write func() error) error {
+	rc.async.writeMux.Lock()
+	defer rc.async.writeMux.Unlock()
+
+	requests := rc.validateAsyncRequests(rc.async.take())
+	err := write()
+	for _, request := range requests {
+		request.done(err)
+	}
+	return err
+}
+
+// validateAsyncRequests answers the asynchronous checkpoints whose own sequence number fails the validation and
+// returns the others
+func (rc *RecordProcessorCheckpointer) validateAsyncRequests(requests []asyncRequest) []asyncRequest {
+	valid := requests[:0]
+	for _, request := range requests {
+		if err := rc.validateCheckpoint(request.sequenceNumber, nil); err != nil {
+			request.done(err)
+			continue
+		}
+		valid = append(valid, request)
+	}
+	return valid
+}
+
+// checkpointAt stores the checkpoint at the sequence number with the metadata, nil checkpoints the end of a closed
+// shard
+func (rc *RecordProcessorCheckpointer) checkpointAt(sequenceNumber *string, metadata []byte) error {
+	if err := rc.checkLease(); err != nil {
+		return err
+	}
+	if err := rc.validateCheckpoint(sequenceNumber, nil); err != nil {
+		return err
+	}
+
 	// checkpoint the last sequence of a closed shard
 	if sequenceNumber == nil {
 		rc.shard.SetCheckpoint(chk.ShardEnd)
@@ -93,9 +214,164 @@ func (rc *RecordProcessorCheckpointer) Checkpoint(sequenceNumber *string) error
 		rc.shard.SetCheckpoint(aws.ToString(sequenceNumber))
 	}
 
-	return rc.checkpoint.CheckpointSequence(rc.shard)
+	rc.shard.SetSubSequenceNumber(nil)
+	rc.shard.SetCheckpointMetadata(metadata)
+
+	// committing a checkpoint also discards the pending one, both are written in a single request
+	rc.shard.SetPendingCheckpoint("")
+
+	return rc.checkpointSequence()
 }
 
-func (rc *RecordProcessorCheckpointer) PrepareCheckpoint(_ *string) (kcl.IPreparedCheckpointer, error) {
-	return &PreparedCheckpointer{}, nil
+// CheckpointWithSubSequence checkpoints the progress at a user record within an aggregated KPL record
+func (rc *RecordProcessorCheckpointer) CheckpointWithSubSequence(sequenceNumber *string, subSequenceNumber int64) error {
+	if sequenceNumber == nil {
+		return errors.New("sequence number is required to checkpoint at a sub-sequence number")
+	}
+	if subSequenceNumber < 0 {
+		return fmt.Errorf("invalid sub-sequence number: %d", subSequenceNumber)
+	}
+
+	return rc.commit(func() error {
+		if err := rc.checkLease(); err != nil {
+			return err
+		}
+		if err := rc.validateCheckpoint(sequenceNumber, &subSequenceNumber); err != nil {
+			return err
+		}
+
+		rc.shard.SetCheckpoint(aws.ToString(sequenceNumber))
+		rc.shard.SetSubSequenceNumber(&subSequenceNumber)
+		rc.shard.SetCheckpointMetadata(nil)
+		rc.shard.SetPendingCheckpoint("")
+
+		return rc.checkpointSequence()
+	})
+}
+
+// PrepareCheckpoint records a pending checkpoint in the lease table. The pending checkpoint is
+// handed to the next record processor of the shard through InitializationInput until it is
+// committed by IPreparedCheckpointer.Checkpoint.
+func (rc *RecordProcessorCheckpointer) PrepareCheckpoint(sequenceNumber *string) (kcl.IPreparedCheckpointer, error) {
+	rc.async.writeMux.Lock()
+	defer rc.async.writeMux.Unlock()
+
+	if err := rc.checkLease(); err != nil {
+		return nil, err
+	}
+	if err := rc.validateCheckpoint(sequenceNumber, nil); err != nil {
+		return nil, err
+	}
+
+	pendingCheckpoint := chk.ShardEnd
+	if sequenceNumber != nil {
+		pendingCheckpoint = aws.ToString(sequenceNumber)
+	}
+
+	rc.shard.SetPendingCheckpoint(pendingCheckpoint)
+	if err := rc.checkpointSequence(); err != nil {
+		return nil, err
+	}
+
+	return &PreparedCheckpointer{
+		pendingCheckpointSequenceNumber: &kcl.ExtendedSequenceNumber{SequenceNumber: sequenceNumber},
+		checkpointer:                    rc,
+	}, nil
+}
+
+// checkpointSequence persists the checkpoint of the shard and counts the failures
+func (rc *RecordProcessorCheckpointer) checkpointSequence() error {
+	_, span := rc.tracer.Start(rc.ctx, "Checkpoint")
+	defer span.End()
+
+	err := rc.checkpoint.CheckpointSequence(rc.shard)
+	if err != nil {
+		if m, ok := rc.mService.(metrics.CheckpointMonitoringService); ok {
+			m.IncrCheckpointErrors(rc.shard.ID)
+		}
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return rc.leaseLost(err)
+}
+
+// checkLease returns a LeaseLostError if lease is expired or another worker has started processing records for this shard
+func (rc *RecordProcessorCheckpointer) checkLease() error {
+	currLeaseOwner, err := rc.checkpoint.GetLeaseOwner(rc.shard.ID)
+	if err != nil {
+		return err
+	}
+	if rc.shard.GetLeaseOwner() != currLeaseOwner {
+		return rc.leaseLost(chk.NewLeaseLostError(rc.shard.ID, ShutdownError))
+	}
+	if time.Now().After(rc.shard.GetLeaseTimeout()) {
+		return chk.NewLeaseLostError(rc.shard.ID, LeaseExpiredError)
+	}
+	return nil
+}
+
+// leaseLost notifies the shard consumer if err tells that another worker has taken the lease, so that the record
+// processor is shut down without waiting for the next renewal. An expired lease may still be renewed.
+func (rc *RecordProcessorCheckpointer) leaseLost(err error) error {
+	if rc.onLeaseLost != nil && errors.Is(err, chk.ErrLeaseLost) && !errors.Is(err, LeaseExpiredError) {
+		rc.onLeaseLost(err)
+	}
+	return err
+}
+
+// validateCheckpoint returns a SkippedSequenceError if the sequence number is before the current checkpoint and a
+// ShardClosedError if the end of the shard has been checkpointed already, unless rewinds are allowed, and the error
+// of the validation hook otherwise
+func (rc *RecordProcessorCheckpointer) validateCheckpoint(sequenceNumber *string, subSequenceNumber *int64) error {
+	current := rc.shard.GetCheckpoint()
+	currentSubSequence := rc.shard.GetSubSequenceNumber()
+
+	if !rc.allowRewind && current == chk.ShardEnd && sequenceNumber != nil {
+		return &chk.ShardClosedError{ShardID: rc.shard.ID}
+	}
+
+	if !rc.allowRewind && isBeforeCheckpoint(current, currentSubSequence, sequenceNumber, subSequenceNumber) {
+		return &SkippedSequenceError{
+			ShardID:        rc.shard.ID,
+			Checkpoint:     current,
+			SequenceNumber: aws.ToString(sequenceNumber),
+		}
+	}
+
+	if rc.validator == nil {
+		return nil
+	}
+
+	var currentCheckpoint *kcl.ExtendedSequenceNumber
+	if current != "" {
+		currentCheckpoint = &kcl.ExtendedSequenceNumber{SequenceNumber: aws.String(current), SubSequenceNumber: aws.ToInt64(currentSubSequence)}
+	}
+	return rc.validator(rc.shard.ID, currentCheckpoint, &kcl.ExtendedSequenceNumber{SequenceNumber: sequenceNumber, SubSequenceNumber: aws.ToInt64(subSequenceNumber)})
+}
+
+// isBeforeCheckpoint compares the extended sequence numbers, a missing sub-sequence number stands for the whole
+// aggregated record. Values which are not sequence numbers, e.g. the initial positions, are never rejected.
+func isBeforeCheckpoint(checkpoint string, checkpointSubSequence *int64, sequenceNumber *string, subSequenceNumber *int64) bool {
+	// the end of the shard is after any sequence number
+	if sequenceNumber == nil {
+		return false
+	}
+	if checkpoint == chk.ShardEnd {
+		return true
+	}
+	if !sequencenumber.IsValid(checkpoint) || !sequencenumber.IsValid(*sequenceNumber) {
+		return false
+	}
+
+	requested := kcl.ExtendedSequenceNumber{SequenceNumber: sequenceNumber, SubSequenceNumber: subSequenceOrMax(subSequenceNumber)}
+	current := kcl.ExtendedSequenceNumber{SequenceNumber: &checkpoint, SubSequenceNumber: subSequenceOrMax(checkpointSubSequence)}
+	cmp, err := requested.CompareTo(current)
+	return err == nil && cmp < 0
+}
+
+func subSequenceOrMax(subSequenceNumber *int64) int64 {
+	if subSequenceNumber == nil {
+		return math.MaxInt64
+	}
+	return *subSequenceNumber
 }
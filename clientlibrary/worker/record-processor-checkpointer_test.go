@@ -0,0 +1,130 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+
+	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+)
+
+func TestCheckpointRejectsRewind(t *testing.T) {
+	checkpointer, rc := newLeasedCheckpointer(t)
+
+	assert.Nil(t, rc.Checkpoint(aws.String("100000000000000000000000000000000000000000000000002")))
+
+	// sequence numbers are compared as big integers
+	err := rc.Checkpoint(aws.String("99999999999999999999999999999999999999999999999999"))
+	assert.Equal(t, &SkippedSequenceError{
+		ShardID:        "0001",
+		Checkpoint:     "100000000000000000000000000000000000000000000000002",
+		SequenceNumber: "99999999999999999999999999999999999999999999999999",
+	}, err)
+	assert.Equal(t, "100000000000000000000000000000000000000000000000002", checkpointer.checkpoints["0001"])
+
+	_, err = rc.PrepareCheckpoint(aws.String("100000000000000000000000000000000000000000000000001"))
+	assert.IsType(t, &SkippedSequenceError{}, err)
+
+	// the same record can be checkpointed again
+	assert.Nil(t, rc.Checkpoint(aws.String("100000000000000000000000000000000000000000000000002")))
+
+	// a checkpoint of the whole record is after all its sub-sequence numbers
+	assert.IsType(t, &SkippedSequenceError{}, rc.CheckpointWithSubSequence(aws.String("100000000000000000000000000000000000000000000000002"), 3))
+	assert.Nil(t, rc.CheckpointWithSubSequence(aws.String("100000000000000000000000000000000000000000000000003"), 3))
+	assert.IsType(t, &SkippedSequenceError{}, rc.CheckpointWithSubSequence(aws.String("100000000000000000000000000000000000000000000000003"), 2))
+	assert.Nil(t, rc.CheckpointWithSubSequence(aws.String("100000000000000000000000000000000000000000000000003"), 4))
+
+	// nothing can be checkpointed after the end of the shard
+	assert.Nil(t, rc.Checkpoint(nil))
+	assert.Equal(t, chk.ShardEnd, checkpointer.checkpoints["0001"])
+	err = rc.Checkpoint(aws.String("200000000000000000000000000000000000000000000000000"))
+	assert.Equal(t, &chk.ShardClosedError{ShardID: "0001"}, err)
+	assert.ErrorIs(t, err, chk.ErrShardClosed)
+	assert.Equal(t, "shard 0001 has been closed", err.Error())
+}
+
+func TestCheckpointAllowRewind(t *testing.T) {
+	checkpointer, rc := newLeasedCheckpointer(t)
+	rc.allowRewind = true
+
+	assert.Nil(t, rc.Checkpoint(aws.String("200")))
+	assert.Nil(t, rc.Checkpoint(aws.String("100")))
+	assert.Equal(t, "100", checkpointer.checkpoints["0001"])
+}
+
+func TestCheckpointWithMetadata(t *testing.T) {
+	_, rc := newLeasedCheckpointer(t)
+
+	assert.Nil(t, rc.CheckpointWithMetadata(aws.String("100"), []byte("state")))
+	assert.Equal(t, []byte("state"), rc.shard.GetCheckpointMetadata())
+
+	// the metadata is kept until the next checkpoint
+	_, err := rc.PrepareCheckpoint(aws.String("101"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("state"), rc.shard.GetCheckpointMetadata())
+
+	assert.Nil(t, rc.Checkpoint(aws.String("102")))
+	assert.Nil(t, rc.shard.GetCheckpointMetadata())
+
+	err = rc.CheckpointWithMetadata(aws.String("103"), make([]byte, MaxCheckpointMetadataSize+1))
+	assert.Error(t, err)
+	assert.Equal(t, "102", rc.shard.GetCheckpoint())
+}
+
+func TestCheckpointValidator(t *testing.T) {
+	checkpointer, rc := newLeasedCheckpointer(t)
+
+	var currents, requests []*kcl.ExtendedSequenceNumber
+	rejected := errors.New("rejected")
+	rc.validator = func(shardID string, current, requested *kcl.ExtendedSequenceNumber) error {
+		assert.Equal(t, "0001", shardID)
+		currents = append(currents, current)
+		requests = append(requests, requested)
+		if aws.ToString(requested.SequenceNumber) == "300" {
+			return rejected
+		}
+		return nil
+	}
+
+	assert.Nil(t, rc.CheckpointWithSubSequence(aws.String("100"), 1))
+	assert.Nil(t, rc.Checkpoint(aws.String("200")))
+	assert.Equal(t, rejected, rc.Checkpoint(aws.String("300")))
+	assert.Equal(t, "200", checkpointer.checkpoints["0001"])
+
+	assert.Equal(t, []*kcl.ExtendedSequenceNumber{
+		nil,
+		{SequenceNumber: aws.String("100"), SubSequenceNumber: 1},
+		{SequenceNumber: aws.String("200")},
+	}, currents)
+	assert.Equal(t, []*kcl.ExtendedSequenceNumber{
+		{SequenceNumber: aws.String("100"), SubSequenceNumber: 1},
+		{SequenceNumber: aws.String("200")},
+		{SequenceNumber: aws.String("300")},
+	}, requests)
+
+	// the hook is not called for rejected rewinds
+	assert.IsType(t, &SkippedSequenceError{}, rc.Checkpoint(aws.String("150")))
+	assert.Equal(t, 3, len(requests))
+}
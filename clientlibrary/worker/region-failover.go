@@ -0,0 +1,363 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package worker
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+
+	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/sequencenumber"
+)
+
+const (
+	// regionFailoverKey is the item of the lease table whose checkpoint is the name of the stream consumed by the
+	// workers of the application, so that they all follow a failover or a failback
+	regionFailoverKey = "region-failover"
+	// regionFailoverLockKey is leased by the worker failing the application over or back
+	regionFailoverLockKey = "region-failover-lock"
+)
+
+// regionStream is the stream consumed by the worker in one region
+type regionStream struct {
+	kc          KinesisAPI
+	streamName  string
+	streamARN   string
+	regionName  string
+	consumerARN string
+}
+
+// regionFailover tracks the availability of the primary stream for the event loop. The worker fails the application
+// over to the secondary stream once the shards of the primary stream could not be listed for
+// RegionFailoverAfterMillis, and back once the primary stream has been available for as long again. The stream the
+// application consumes is kept in the lease table, the other workers follow it at their next shard sync.
+type regionFailover struct {
+	after            time.Duration
+	unavailableSince time.Time
+	availableSince   time.Time
+	failedOver       bool
+
+	// primary and secondary are known once the worker has switched streams
+	primary   *regionStream
+	secondary *regionStream
+}
+
+// newRegionFailover returns nil unless a secondary stream is configured
+func newRegionFailover(kclConfig *config.KinesisClientLibConfiguration) *regionFailover {
+	if kclConfig.SecondaryStreamName == "" {
+		return nil
+	}
+	return &regionFailover{after: time.Duration(kclConfig.RegionFailoverAfterMillis) * time.Millisecond}
+}
+
+// synced records the result of a shard sync of the primary stream and returns true once the primary stream has been
+// unavailable for long enough to fail over. A throttled listing shows that the primary stream is available.
+func (f *regionFailover) synced(err error, now time.Time) bool {
+	if f == nil || f.failedOver {
+		return false
+	}
+	if err == nil || isListShardsThrottled(err) {
+		f.unavailableSince = time.Time{}
+		return false
+	}
+	if f.unavailableSince.IsZero() {
+		f.unavailableSince = now
+	}
+	return now.Sub(f.unavailableSince) >= f.after
+}
+
+// probed records the result of a listing of the primary stream after failover and returns true once the primary
+// stream has been available for long enough to fail back
+func (f *regionFailover) probed(err error, now time.Time) bool {
+	if f == nil || !f.failedOver {
+		return false
+	}
+	if err != nil && !isListShardsThrottled(err) {
+		f.availableSince = time.Time{}
+		return false
+	}
+	if f.availableSince.IsZero() {
+		f.availableSince = now
+	}
+	return now.Sub(f.availableSince) >= f.after
+}
+
+// isActive tells whether the worker has failed over to the secondary stream
+func (f *regionFailover) isActive() bool {
+	return f != nil && f.failedOver
+}
+
+// WithSecondaryKinesis is used to provide the Kinesis service of the region of the secondary stream, e.g. for unit
+// testing. By default it is created from the Kinesis config of the worker at failover.
+func (w *Worker) WithSecondaryKinesis(svc KinesisAPI) *Worker {
+	w.secondaryKc = svc
+	return w
+}
+
+// IsFailedOver tells whether the worker consumes the secondary stream since the primary stream has been unavailable
+func (w *Worker) IsFailedOver() bool {
+	w.statusMux.RLock()
+	defer w.statusMux.RUnlock()
+	return w.failover.isActive()
+}
+
+// syncFailover follows the stream the application consumes according to the lease table. Once failed over, it
+// lists the shards of the primary stream and fails back once the primary stream has been available for
+// RegionFailoverAfterMillis.
+func (w *Worker) syncFailover() error {
+	if w.failover == nil {
+		return nil
+	}
+
+	_, failedOver, err := w.fetchFailoverState()
+	if err != nil {
+		return err
+	}
+	if failedOver != w.failover.failedOver {
+		w.log.Infof("Following the failover of the application to stream %s", w.failoverStreamName(failedOver))
+		return w.switchStream(failedOver)
+	}
+	if !failedOver {
+		return nil
+	}
+
+	primary := w.primaryStream()
+	args := &kinesis.ListShardsInput{MaxResults: aws.Int32(1)}
+	if primary.streamARN != "" {
+		args.StreamARN = aws.String(primary.streamARN)
+	} else {
+		args.StreamName = aws.String(primary.streamName)
+	}
+	_, err = primary.kc.ListShards(context.TODO(), args)
+	if !w.failover.probed(err, time.Now()) {
+		return nil
+	}
+	w.log.Warnf("Stream %s has been available for %d ms, failing back from stream %s", primary.streamName,
+		w.kclConfig.RegionFailoverAfterMillis, w.streamName)
+	return w.failOverOrBack(false)
+}
+
+// failOver switches the application to the secondary stream once the primary stream has been unavailable
+func (w *Worker) failOver() error {
+	w.log.Warnf("Stream %s has been unavailable for %d ms, failing over to stream %s in %s", w.streamName,
+		w.kclConfig.RegionFailoverAfterMillis, w.kclConfig.SecondaryStreamName, w.kclConfig.SecondaryRegionName)
+	return w.failOverOrBack(true)
+}
+
+// failOverOrBack switches the application to the secondary stream or back to the primary stream. The worker takes
+// the failover lock of the lease table, translates the checkpoints of the consumed stream into checkpoints of the
+// other stream and records the stream in the lease table before it switches. The other workers follow the recorded
+// stream without translating the checkpoints again.
+func (w *Worker) failOverOrBack(secondary bool) error {
+	lock := &par.ShardStatus{ID: regionFailoverLockKey, Mux: &sync.RWMutex{}}
+	if err := w.checkpointer.GetLease(lock, w.workerID); err != nil {
+		// another worker is switching the application, it is followed at the next shard sync
+		return fmt.Errorf("failed to take the failover lock: %w", err)
+	}
+	defer func() {
+		if err := w.checkpointer.RemoveLeaseOwner(lock.ID); err != nil {
+			w.log.Errorf("Failed to release the failover lock: %+v", err)
+		}
+	}()
+
+	state, failedOver, err := w.fetchFailoverState()
+	if err != nil {
+		return err
+	}
+	if failedOver != secondary {
+		if err := w.translateCheckpoints(secondary); err != nil {
+			return err
+		}
+		state.SetCheckpoint(w.failoverStreamName(secondary))
+		if err := w.checkpointer.CheckpointSequence(state); err != nil {
+			return err
+		}
+	}
+	return w.switchStream(secondary)
+}
+
+// fetchFailoverState returns the item of the lease table recording the stream the application consumes and whether
+// it is the secondary stream
+func (w *Worker) fetchFailoverState() (*par.ShardStatus, bool, error) {
+	state := &par.ShardStatus{ID: regionFailoverKey, Mux: &sync.RWMutex{}}
+	if err := w.checkpointer.FetchCheckpoint(state); err != nil {
+		if errors.Is(err, chk.ErrSequenceIDNotFound) {
+			return state, false, nil
+		}
+		return nil, false, err
+	}
+	return state, state.GetCheckpoint() == w.kclConfig.SecondaryStreamName, nil
+}
+
+func (w *Worker) failoverStreamName(secondary bool) string {
+	if secondary {
+		return w.kclConfig.SecondaryStreamName
+	}
+	return w.kclConfig.StreamName
+}
+
+// primaryStream returns the stream the worker has been started with
+func (w *Worker) primaryStream() *regionStream {
+	if w.failover.primary == nil {
+		w.failover.primary = &regionStream{
+			kc:          w.kc,
+			streamName:  w.streamName,
+			streamARN:   w.streamARN,
+			regionName:  w.regionName,
+			consumerARN: w.consumerARN,
+		}
+	}
+	return w.failover.primary
+}
+
+// secondaryStream returns the replica of the stream, its Kinesis client is created from the Kinesis config of the
+// worker unless it has been provided by WithSecondaryKinesis
+func (w *Worker) secondaryStream() (*regionStream, error) {
+	if w.failover.secondary != nil {
+		return w.failover.secondary, nil
+	}
+
+	kc := w.secondaryKc
+	if kc == nil {
+		cfg, err := w.kclConfig.LoadAWSConfig(kinesis.ServiceID, "", w.kclConfig.KinesisCredentials,
+			w.kclConfig.KinesisRoleARN, w.mService)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Region = w.kclConfig.SecondaryRegionName
+		kc = kinesis.NewFromConfig(cfg)
+	}
+
+	secondary := &regionStream{
+		kc:         kc,
+		streamName: w.kclConfig.SecondaryStreamName,
+		regionName: w.kclConfig.SecondaryRegionName,
+	}
+	if w.kclConfig.EnableEnhancedFanOutConsumer {
+		var err error
+		secondary.consumerARN, err = w.fetchConsumerARN(kc, secondary.streamName, "")
+		if err != nil {
+			return nil, err
+		}
+	}
+	w.failover.secondary = secondary
+	return secondary, nil
+}
+
+// switchStream switches the worker to the secondary stream or back to the primary stream. The consumers of the
+// current stream are asked to release their leases, the leases are kept for the time the application switches back.
+func (w *Worker) switchStream(secondary bool) error {
+	target := w.primaryStream()
+	if secondary {
+		var err error
+		if target, err = w.secondaryStream(); err != nil {
+			return err
+		}
+	}
+
+	w.statusMux.Lock()
+	defer w.statusMux.Unlock()
+	for _, status := range w.consumers {
+		// the release is not awaited, the consumers of an unavailable stream may be retrying their reads
+		select {
+		case status.release <- &releaseRequest{done: make(chan error, 1)}:
+		default:
+		}
+	}
+	w.kc = target.kc
+	w.streamName, w.streamARN = target.streamName, target.streamARN
+	w.regionName = target.regionName
+	w.consumerARN = target.consumerARN
+	w.failover.failedOver = secondary
+	w.failover.unavailableSince, w.failover.availableSince = time.Time{}, time.Time{}
+
+	// the shards of the other stream are forgotten without removing their leases
+	w.shardStatus = make(map[string]*par.ShardStatus)
+	w.shardSync = newShardSyncState(w.kclConfig)
+	return nil
+}
+
+// translateCheckpoints writes the checkpoints of the shards of the consumed stream translated by the
+// SequenceNumberTranslator into the leases of the shards of the other stream. A checkpoint of the other stream is
+// only overwritten by a later one, e.g. one left by a previous failover is replaced. Without a translator the
+// secondary stream is consumed from InitialPositionInStream and the primary stream resumes from its checkpoints.
+func (w *Worker) translateCheckpoints(secondary bool) error {
+	translate := w.kclConfig.SequenceNumberTranslator
+	if translate == nil {
+		return nil
+	}
+
+	for _, shard := range w.shardStatus {
+		if err := w.checkpointer.FetchCheckpoint(shard); err != nil {
+			if errors.Is(err, chk.ErrSequenceIDNotFound) {
+				continue
+			}
+			return err
+		}
+		checkpoint := shard.GetCheckpoint()
+		if checkpoint == "" || checkpoint == chk.ShardEnd {
+			continue
+		}
+
+		shardID, sequenceNumber, err := translate(w.streamName, shard.GetShardID(), checkpoint)
+		if err != nil {
+			return fmt.Errorf("failed to translate the checkpoint of shard %s: %w", shard.ID, err)
+		}
+		if shardID == "" {
+			continue
+		}
+
+		target := &par.ShardStatus{ID: shardID, Mux: &sync.RWMutex{}}
+		if secondary {
+			target.ID, target.ShardID = w.kclConfig.SecondaryStreamName+":"+shardID, shardID
+		}
+		err = w.checkpointer.FetchCheckpoint(target)
+		if err == nil {
+			current := target.GetCheckpoint()
+			if current == chk.ShardEnd {
+				continue
+			}
+			if cmp, err := sequencenumber.Compare(current, sequenceNumber); err != nil || cmp >= 0 {
+				continue
+			}
+		} else if !errors.Is(err, chk.ErrSequenceIDNotFound) {
+			return err
+		}
+		target.SetCheckpoint(sequenceNumber)
+		target.SetSubSequenceNumber(nil)
+		target.SetPendingCheckpoint("")
+		target.SetCheckpointMetadata(nil)
+		if err := w.checkpointer.CheckpointSequence(target); err != nil {
+			return err
+		}
+		w.log.Infof("Translated checkpoint %s of shard %s into checkpoint %s of shard %s", checkpoint, shard.ID,
+			sequenceNumber, target.ID)
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/stretchr/testify/assert"
+
+	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+// newRegionServer returns a Kinesis endpoint with a single shard returning one record per GetRecords call, starting
+// after the requested sequence number. All requests fail with InternalFailure while down is set.
+func newRegionServer(t *testing.T, down *int32) *httptest.Server {
+	available := func(handler kinesisHandler) kinesisHandler {
+		return func(input map[string]interface{}) interface{} {
+			if atomic.LoadInt32(down) != 0 {
+				return kinesisError{status: http.StatusInternalServerError, errType: "InternalFailure", message: "unavailable"}
+			}
+			return handler(input)
+		}
+	}
+
+	return newKinesisServer(t, kinesisHandlers{
+		"ListShards": available(listShards(testShard("shardId-0", ""))),
+		"GetShardIterator": available(func(input map[string]interface{}) interface{} {
+			iterator := 0
+			if sequenceNumber, ok := input["StartingSequenceNumber"].(string); ok {
+				iterator, _ = strconv.Atoi(sequenceNumber)
+				iterator++
+			}
+			return map[string]interface{}{"ShardIterator": strconv.Itoa(iterator)}
+		}),
+		"GetRecords": available(func(input map[string]interface{}) interface{} {
+			sequenceNumber, _ := strconv.Atoi(input["ShardIterator"].(string))
+			return getRecordsOutput(strconv.Itoa(sequenceNumber+1), kinesisRecord(strconv.Itoa(sequenceNumber), "YQ=="))
+		}),
+	})
+}
+
+// newUnretriedKinesisClient returns a Kinesis client failing at once, so that the unavailability is seen quickly
+func newUnretriedKinesisClient(url string) *kinesis.Client {
+	return kinesis.New(kinesis.Options{
+		Region:           "us-west-2",
+		Credentials:      credentials.NewStaticCredentialsProvider("id", "secret", ""),
+		EndpointResolver: kinesis.EndpointResolverFromURL(url),
+		Retryer:          aws.NopRetryer{},
+	})
+}
+
+// translateReplica translates the sequence numbers of the primary stream into the replica, which holds the records
+// of the primary stream from the sequence number 1000
+func translateReplica(streamName, shardID, sequenceNumber string) (string, string, error) {
+	n, err := strconv.Atoi(sequenceNumber)
+	if streamName == "replica" {
+		return shardID, strconv.Itoa(n - 1000), err
+	}
+	return shardID, strconv.Itoa(1000 + n), err
+}
+
+func TestRegionFailoverSynced(t *testing.T) {
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker")
+	assert.Nil(t, newRegionFailover(kclConfig))
+	assert.False(t, newRegionFailover(kclConfig).synced(errors.New("unavailable"), time.Now()))
+
+	kclConfig.WithFailover("us-east-1", "replica", 1000)
+	failover := newRegionFailover(kclConfig)
+	now := time.Now()
+	assert.False(t, failover.synced(errors.New("unavailable"), now))
+	assert.False(t, failover.synced(errors.New("unavailable"), now.Add(999*time.Millisecond)))
+
+	// the primary stream has been available meanwhile
+	assert.False(t, failover.synced(&types.LimitExceededException{}, now.Add(time.Second)))
+	assert.False(t, failover.synced(errors.New("unavailable"), now.Add(2*time.Second)))
+	assert.True(t, failover.synced(errors.New("unavailable"), now.Add(3*time.Second)))
+
+	failover.failedOver = true
+	assert.True(t, failover.isActive())
+	assert.False(t, failover.synced(errors.New("unavailable"), now.Add(4*time.Second)))
+}
+
+func TestRegionFailoverProbed(t *testing.T) {
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+		WithFailover("us-east-1", "replica", 1000)
+	failover := newRegionFailover(kclConfig)
+	now := time.Now()
+	assert.False(t, failover.probed(nil, now))
+
+	failover.failedOver = true
+	assert.False(t, failover.probed(nil, now))
+	assert.False(t, failover.probed(errors.New("unavailable"), now.Add(500*time.Millisecond)))
+	assert.False(t, failover.probed(nil, now.Add(time.Second)))
+	assert.False(t, failover.probed(&types.LimitExceededException{}, now.Add(1500*time.Millisecond)))
+	assert.True(t, failover.probed(nil, now.Add(2*time.Second)))
+}
+
+func TestWorkerFailsOverToSecondaryStream(t *testing.T) {
+	var primaryDown, secondaryDown int32
+	primary := newRegionServer(t, &primaryDown)
+	defer primary.Close()
+	secondary := newRegionServer(t, &secondaryDown)
+	defer secondary.Close()
+
+	table, _ := chk.NewMemoryLeaseTable("")
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+		WithIdleTimeBetweenReadsInMillis(10).
+		WithShardSyncIntervalMillis(10).
+		WithFailover("us-east-1", "replica", 200).
+		WithSequenceNumberTranslator(translateReplica)
+	checkpointer := chk.NewMemoryCheckpoint(kclConfig).WithLeaseTable(table)
+	processor := &replayProcessor{}
+	w := NewWorker(replayProcessorFactory{processor}, kclConfig).
+		WithKinesis(newUnretriedKinesisClient(primary.URL)).
+		WithSecondaryKinesis(newUnretriedKinesisClient(secondary.URL)).
+		WithCheckpointer(checkpointer)
+
+	assert.Nil(t, w.Start())
+	defer w.Shutdown()
+	assert.Eventually(t, func() bool {
+		return len(processor.delivered()) > 2
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.False(t, w.IsFailedOver())
+
+	atomic.StoreInt32(&primaryDown, 1)
+	assert.Eventually(t, w.IsFailedOver, 5*time.Second, 10*time.Millisecond)
+
+	// the records of the replica follow the translated checkpoint of the primary stream
+	assert.Eventually(t, func() bool {
+		records := processor.delivered()
+		n, _ := strconv.Atoi(records[len(records)-1])
+		return n > 1000
+	}, 5*time.Second, 10*time.Millisecond)
+
+	primaryShard := &par.ShardStatus{ID: "shardId-0", Mux: &sync.RWMutex{}}
+	assert.Nil(t, checkpointer.FetchCheckpoint(primaryShard))
+	var first string
+	for _, record := range processor.delivered() {
+		if n, _ := strconv.Atoi(record); n >= 1000 {
+			first = record
+			break
+		}
+	}
+	checkpoint, _ := strconv.Atoi(primaryShard.GetCheckpoint())
+	assert.Equal(t, strconv.Itoa(1000+checkpoint+1), first)
+
+	// the leases of the replica are namespaced by its name and the lease of the primary stream is kept
+	secondaryShard := &par.ShardStatus{ID: "replica:shardId-0", Mux: &sync.RWMutex{}}
+	assert.Eventually(t, func() bool {
+		return checkpointer.FetchCheckpoint(secondaryShard) == nil && secondaryShard.GetLeaseOwner() == "worker"
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Nil(t, checkpointer.FetchCheckpoint(primaryShard))
+
+	// the stream consumed by the application is recorded for the other workers
+	state := &par.ShardStatus{ID: regionFailoverKey, Mux: &sync.RWMutex{}}
+	assert.Nil(t, checkpointer.FetchCheckpoint(state))
+	assert.Equal(t, "replica", state.GetCheckpoint())
+
+	// the worker fails back once the primary stream is available again and resumes from the translated checkpoint
+	// of the replica instead of the checkpoint left at failover
+	atomic.StoreInt32(&primaryDown, 0)
+	assert.Eventually(t, func() bool { return !w.IsFailedOver() }, 5*time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		records := processor.delivered()
+		n, _ := strconv.Atoi(records[len(records)-1])
+		return n < 1000
+	}, 5*time.Second, 10*time.Millisecond)
+
+	records := processor.delivered()
+	var failedBack int
+	for i := len(records) - 1; i > 0; i-- {
+		if n, _ := strconv.Atoi(records[i-1]); n >= 1000 {
+			failedBack, _ = strconv.Atoi(records[i])
+			break
+		}
+	}
+	assert.Greater(t, failedBack, checkpoint+1)
+	assert.Nil(t, checkpointer.FetchCheckpoint(state))
+	assert.Equal(t, "stream", state.GetCheckpoint())
+}
+
+func TestWorkerFollowsRegionFailover(t *testing.T) {
+	var primaryDown, secondaryDown int32
+	primary := newRegionServer(t, &primaryDown)
+	defer primary.Close()
+	secondary := newRegionServer(t, &secondaryDown)
+	defer secondary.Close()
+
+	table, _ := chk.NewMemoryLeaseTable("")
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+		WithIdleTimeBetweenReadsInMillis(10).
+		WithShardSyncIntervalMillis(10).
+		WithFailover("us-east-1", "replica", 1000)
+	checkpointer := chk.NewMemoryCheckpoint(kclConfig).WithLeaseTable(table)
+
+	// another worker has failed the application over, although the primary stream is available to this worker
+	state := &par.ShardStatus{ID: regionFailoverKey, Mux: &sync.RWMutex{}}
+	state.SetCheckpoint("replica")
+	assert.Nil(t, checkpointer.CheckpointSequence(state))
+
+	processor := &replayProcessor{}
+	w := NewWorker(replayProcessorFactory{processor}, kclConfig).
+		WithKinesis(newUnretriedKinesisClient(primary.URL)).
+		WithSecondaryKinesis(newUnretriedKinesisClient(secondary.URL)).
+		WithCheckpointer(checkpointer)
+
+	assert.Nil(t, w.Start())
+	defer w.Shutdown()
+	assert.Eventually(t, w.IsFailedOver, 5*time.Second, 10*time.Millisecond)
+
+	// the worker fails the application back since the primary stream has been available meanwhile
+	assert.Eventually(t, func() bool {
+		return !w.IsFailedOver() && checkpointer.FetchCheckpoint(state) == nil && state.GetCheckpoint() == "stream"
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,232 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package worker
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+// rewoundSubSequenceNumber is stored with the checkpoint of a rewound shard, so that the consumer restarts at the
+// sequence number and delivers all of its user records again
+const rewoundSubSequenceNumber = -1
+
+var (
+	// ErrShardNotConsumed is returned when a shard to rewind is not consumed by the worker
+	ErrShardNotConsumed = errors.New("the shard is not consumed by the worker")
+
+	// errShardRewound is returned by a shard consumer stopped to be restarted at a rewound checkpoint
+	errShardRewound = errors.New("the shard has been rewound")
+)
+
+type (
+	// RewindPosition is the position a shard is rewound to, either a sequence number or a timestamp
+	RewindPosition struct {
+		// SequenceNumber is the first record processed again
+		SequenceNumber string
+
+		// Timestamp is the approximate arrival time from which the records are processed again
+		Timestamp *time.Time
+	}
+
+	// rewindRequest asks the consumer of a shard to restart at the sequence number
+	rewindRequest struct {
+		sequenceNumber string
+		done           chan error
+	}
+)
+
+// AtSequenceNumber returns the position of the record with the sequence number
+func AtSequenceNumber(sequenceNumber string) RewindPosition {
+	return RewindPosition{SequenceNumber: sequenceNumber}
+}
+
+// AtTimestamp returns the position of the first record which arrived at or after the timestamp
+func AtTimestamp(timestamp time.Time) RewindPosition {
+	return RewindPosition{Timestamp: &timestamp}
+}
+
+// RewindShard resets the checkpoint of a shard consumed by the worker to the position and restarts its consumer
+// from there, e.g. to process records again after an incident. The record processor is shut down with REQUESTED
+// before the checkpoint is stored, so that its checkpoints do not overwrite the new position. The lease is held
+// by the worker until the consumer has restarted.
+func (w *Worker) RewindShard(shardID string, position RewindPosition) error {
+	w.statusMux.RLock()
+	status := w.consumers[shardID]
+	w.statusMux.RUnlock()
+	if status == nil {
+		return ErrShardNotConsumed
+	}
+
+	sequenceNumber, err := w.resolveRewindPosition(status.shard, position)
+	if err != nil {
+		return err
+	}
+
+	req := &rewindRequest{sequenceNumber: sequenceNumber, done: make(chan error, 1)}
+	select {
+	case status.rewind <- req:
+	default:
+		return fmt.Errorf("shard %s is being rewound already", shardID)
+	}
+
+	select {
+	case err := <-req.done:
+		return err
+	case <-w.ctx.Done():
+		return fmt.Errorf("worker shut down while rewinding shard %s", shardID)
+	}
+}
+
+// RewindAll rewinds all shards consumed by the worker to the position. It returns the first error of the shards
+// in the order of their lease keys.
+func (w *Worker) RewindAll(position RewindPosition) error {
+	w.statusMux.RLock()
+	shardIDs := make([]string, 0, len(w.consumers))
+	for shardID := range w.consumers {
+		shardIDs = append(shardIDs, shardID)
+	}
+	w.statusMux.RUnlock()
+	sort.Strings(shardIDs)
+
+	errs := make([]error, len(shardIDs))
+	var wg sync.WaitGroup
+	for i, shardID := range shardIDs {
+		wg.Add(1)
+		go func(i int, shardID string) {
+			defer wg.Done()
+			errs[i] = w.RewindShard(shardID, position)
+		}(i, shardID)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return fmt.Errorf("failed to rewind shard %s: %w", shardIDs[i], err)
+		}
+	}
+	return nil
+}
+
+// resolveRewindPosition returns the sequence number of the first record at the position
+func (w *Worker) resolveRewindPosition(shard *par.ShardStatus, position RewindPosition) (string, error) {
+	if position.Timestamp == nil {
+		if position.SequenceNumber == "" {
+			return "", errors.New("a sequence number or a timestamp is required to rewind a shard")
+		}
+		return position.SequenceNumber, nil
+	}
+
+	// the stream and its client are swapped when the worker fails over
+	w.statusMux.RLock()
+	kc, streamName, streamARN := w.kc, w.streamName, w.streamARN
+	w.statusMux.RUnlock()
+	if shard.StreamName != "" {
+		streamName, streamARN = shard.StreamName, shard.StreamARN
+	}
+
+	iterArgs := &kinesis.GetShardIteratorInput{
+		ShardId:           aws.String(shard.GetShardID()),
+		ShardIteratorType: types.ShardIteratorTypeAtTimestamp,
+		Timestamp:         position.Timestamp,
+	}
+	if streamARN != "" {
+		iterArgs.StreamARN = aws.String(streamARN)
+	} else {
+		iterArgs.StreamName = aws.String(streamName)
+	}
+	iterResp, err := kc.GetShardIterator(context.TODO(), iterArgs)
+	if err != nil {
+		return "", err
+	}
+
+	// the iterator may have to be advanced over empty batches until it reaches the records at the timestamp
+	shardIterator := iterResp.ShardIterator
+	for shardIterator != nil {
+		getRecordsArgs := &kinesis.GetRecordsInput{ShardIterator: shardIterator, Limit: aws.Int32(1)}
+		if streamARN != "" {
+			getRecordsArgs.StreamARN = aws.String(streamARN)
+		}
+		getResp, err := kc.GetRecords(context.TODO(), getRecordsArgs)
+		if err != nil {
+			return "", err
+		}
+		if len(getResp.Records) > 0 {
+			return aws.ToString(getResp.Records[0].SequenceNumber), nil
+		}
+		if aws.ToInt64(getResp.MillisBehindLatest) == 0 {
+			break
+		}
+		shardIterator = getResp.NextShardIterator
+	}
+
+	return "", fmt.Errorf("no record of shard %s arrived after %s", shard.ID, position.Timestamp)
+}
+
+// rewindRequests returns the rewinds requested for the shard, it blocks forever without a worker
+func (s *consumerStatus) rewindRequests() <-chan *rewindRequest {
+	if s == nil {
+		return nil
+	}
+	return s.rewind
+}
+
+// cancelRewind fails a pending rewind of a consumer which has stopped
+func (s *consumerStatus) cancelRewind() {
+	select {
+	case req := <-s.rewind:
+		req.done <- ErrShardNotConsumed
+	default:
+	}
+}
+
+// rewind shuts down the record processor and stores the checkpoint at the rewound position while the lease is
+// still held. The consumer returns errShardRewound to be restarted from the new checkpoint.
+func (sc *commonShardConsumer) rewind(req *rewindRequest, checkpointer kcl.IRecordProcessorCheckpointer) error {
+	sc.getLogger().Infof("Rewinding shard %s to sequence number %s", sc.shard.ID, req.sequenceNumber)
+	sc.shutdownRecordProcessor(kcl.REQUESTED, checkpointer)
+
+	sc.shard.SetCheckpoint(req.sequenceNumber)
+	sc.shard.SetSubSequenceNumber(aws.Int64(rewoundSubSequenceNumber))
+	sc.shard.SetPendingCheckpoint("")
+	// the metadata of the record processor belongs to the checkpoint which has been rewound
+	sc.shard.SetCheckpointMetadata(nil)
+	err := sc.checkpointer.CheckpointSequence(sc.shard)
+	req.done <- err
+	if err != nil {
+		sc.getLogger().Errorf("Failed to rewind shard %s. Error: %+v", sc.shard.ID, err)
+		return err
+	}
+
+	sc.rewound = true
+	return errShardRewound
+}
@@ -0,0 +1,175 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+
+	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+// replayProcessor records the delivered records and checkpoints the last record of each batch
+type replayProcessor struct {
+	mux     sync.Mutex
+	records []string
+	reasons []kcl.ShutdownReason
+}
+
+func (p *replayProcessor) Initialize(*kcl.InitializationInput) {}
+
+func (p *replayProcessor) ProcessRecords(input *kcl.ProcessRecordsInput) error {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	for _, r := range input.Records {
+		p.records = append(p.records, aws.ToString(r.SequenceNumber))
+	}
+	if len(input.Records) > 0 {
+		return input.Checkpointer.Checkpoint(input.Records[len(input.Records)-1].SequenceNumber)
+	}
+	return nil
+}
+
+func (p *replayProcessor) Shutdown(input *kcl.ShutdownInput) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.reasons = append(p.reasons, input.ShutdownReason)
+}
+
+func (p *replayProcessor) delivered() []string {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return append([]string(nil), p.records...)
+}
+
+type replayProcessorFactory struct {
+	processor *replayProcessor
+}
+
+func (f replayProcessorFactory) CreateProcessor() kcl.IRecordProcessor {
+	return f.processor
+}
+
+// newReplayServer returns a Kinesis endpoint with a single shard holding the records 100 and 101, which arrived
+// at the timestamps 100 and 101 in seconds. It records the positions of the requested shard iterators.
+func newReplayServer(t *testing.T, positions *[]string, mux *sync.Mutex) *httptest.Server {
+	return newKinesisServer(t, kinesisHandlers{
+		"ListShards": listShards(testShard("shardId-0", "")),
+		"GetShardIterator": func(input map[string]interface{}) interface{} {
+			position, _ := input["ShardIteratorType"].(string)
+			if sequenceNumber, ok := input["StartingSequenceNumber"].(string); ok {
+				position += " " + sequenceNumber
+			}
+			mux.Lock()
+			*positions = append(*positions, position)
+			mux.Unlock()
+
+			iterator := "100"
+			if input["StartingSequenceNumber"] == "101" {
+				iterator = "101"
+			}
+			// no record arrived after the timestamp 101
+			if timestamp, ok := input["Timestamp"].(float64); ok && timestamp > 101 {
+				iterator = "end"
+			}
+			return map[string]interface{}{"ShardIterator": iterator}
+		},
+		"GetRecords": func(input map[string]interface{}) interface{} {
+			var records []map[string]string
+			switch input["ShardIterator"] {
+			case "100":
+				records = append(records, kinesisRecord("100", "YQ=="))
+				if input["Limit"] != float64(1) {
+					records = append(records, kinesisRecord("101", "Yg=="))
+				}
+			case "101":
+				records = append(records, kinesisRecord("101", "Yg=="))
+			}
+			return getRecordsOutput("end", records...)
+		},
+	})
+}
+
+func TestRewindShard(t *testing.T) {
+	var positions []string
+	var mux sync.Mutex
+	server := newReplayServer(t, &positions, &mux)
+	defer server.Close()
+
+	table, _ := chk.NewMemoryLeaseTable("")
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+		WithIdleTimeBetweenReadsInMillis(10)
+	checkpointer := chk.NewMemoryCheckpoint(kclConfig).WithLeaseTable(table)
+	processor := &replayProcessor{}
+	recorder := &eventRecorder{}
+	w := NewWorker(replayProcessorFactory{processor}, kclConfig).
+		WithKinesis(newTestKinesisClient(server.URL)).
+		WithCheckpointer(checkpointer).
+		WithStateListener(recorder)
+
+	assert.Equal(t, ErrShardNotConsumed, w.RewindShard("shardId-0", AtSequenceNumber("100")))
+
+	assert.Nil(t, w.Start())
+	defer w.Shutdown()
+	assert.Eventually(t, func() bool {
+		return len(processor.delivered()) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the record 101 is delivered again after a rewind to its sequence number
+	assert.Nil(t, w.RewindShard("shardId-0", AtSequenceNumber("101")))
+	assert.Eventually(t, func() bool {
+		return len(processor.delivered()) == 3
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// both records are delivered again after a rewind to the arrival of the first record
+	assert.Nil(t, w.RewindAll(AtTimestamp(time.Unix(100, 0))))
+	assert.Eventually(t, func() bool {
+		return len(processor.delivered()) == 5
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// there is no record to rewind to after the last one
+	assert.NotNil(t, w.RewindShard("shardId-0", AtTimestamp(time.Unix(200, 0))))
+	assert.NotNil(t, w.RewindShard("shardId-0", RewindPosition{}))
+
+	assert.Equal(t, []string{"100", "101", "101", "100", "101"}, processor.delivered())
+	processor.mux.Lock()
+	assert.Equal(t, []kcl.ShutdownReason{kcl.REQUESTED, kcl.REQUESTED}, processor.reasons)
+	processor.mux.Unlock()
+
+	mux.Lock()
+	assert.Equal(t, []string{"LATEST", "AT_SEQUENCE_NUMBER 101", "AT_TIMESTAMP", "AT_SEQUENCE_NUMBER 100", "AT_TIMESTAMP"}, positions)
+	mux.Unlock()
+
+	// the lease is kept while the consumer restarts
+	assert.Equal(t, []string{"started worker", "acquired shardId-0"}, recorder.recorded())
+
+	shard := &par.ShardStatus{ID: "shardId-0", Mux: &sync.RWMutex{}}
+	assert.Nil(t, checkpointer.FetchCheckpoint(shard))
+	assert.Equal(t, "101", shard.GetCheckpoint())
+	assert.Equal(t, "worker", shard.GetLeaseOwner())
+}
@@ -0,0 +1,110 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"context"
+	"time"
+
+	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
+)
+
+// runOnceIdleLoops is the number of consecutive iterations of the event loop without new leases in which every
+// consumer has to be caught up, so that the child shards of the shards ending meanwhile are leased before the worker
+// stops
+const runOnceIdleLoops = 2
+
+// runOnceState tells RunOnce once the shards owned by the worker are caught up. It is used by the event loop only.
+type runOnceState struct {
+	caughtUp  chan struct{}
+	idleLoops int
+	done      bool
+}
+
+// RunOnce runs the worker as a batch job: it starts the worker, consumes the shards until every shard owned by the
+// worker has been caught up with its tip, i.e. the MillisBehindLatest of its last batch did not exceed the
+// IdleTimeBetweenReadsInMillis, so that the shards of streams with steady writes are caught up as well, or until ctx
+// is done, and then shuts the worker down. The record processors are shut down with REQUESTED while the leases are
+// held and the leases are released. The records processed since the last checkpoint are checkpointed at the shutdown
+// with AutoCheckpoint, the record processor has to checkpoint them at the REQUESTED shutdown otherwise. It returns
+// nil once the shards have been caught up, the error of ctx if it is done first, or the error of the shutdown.
+func (w *Worker) RunOnce(ctx context.Context) error {
+	w.runOnce = &runOnceState{caughtUp: make(chan struct{})}
+	if err := w.Start(); err != nil {
+		return err
+	}
+
+	var err error
+	select {
+	case <-w.runOnce.caughtUp:
+		w.log.Infof("All shards of worker %s are caught up, shutting down", w.workerID)
+	case <-ctx.Done():
+		err = ctx.Err()
+		w.log.Infof("Stopping worker %s before the shards are caught up: %v", w.workerID, err)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(w.kclConfig.ShutdownGraceMillis)*time.Millisecond)
+	defer cancel()
+	if shutdownErr := w.ShutdownWithContext(shutdownCtx); shutdownErr != nil {
+		return shutdownErr
+	}
+	return err
+}
+
+// checkRunOnce tells RunOnce once no leases have been acquired and all consumers of the worker have been caught up for
+// runOnceIdleLoops iterations of the event loop
+func (w *Worker) checkRunOnce(acquired int) {
+	state := w.runOnce
+	if state == nil || state.done {
+		return
+	}
+	if acquired > 0 || !w.isCaughtUp() {
+		state.idleLoops = 0
+		return
+	}
+	if state.idleLoops++; state.idleLoops >= runOnceIdleLoops {
+		state.done = true
+		close(state.caughtUp)
+	}
+}
+
+// isCaughtUp returns true if the consumer of every open shard leased by the worker has been caught up with the tip of
+// its shard, the consumers of the shards which have just been leased may not have been started yet
+func (w *Worker) isCaughtUp() bool {
+	w.statusMux.RLock()
+	defer w.statusMux.RUnlock()
+
+	for _, shard := range w.shardStatus {
+		if shard.GetLeaseOwner() != w.workerID || shard.GetCheckpoint() == chk.ShardEnd {
+			continue
+		}
+		consumer, ok := w.consumers[shard.ID]
+		if !ok {
+			return false
+		}
+		consumer.Lock()
+		caughtUp := consumer.nearTip
+		consumer.Unlock()
+		if !caughtUp {
+			return false
+		}
+	}
+	return true
+}
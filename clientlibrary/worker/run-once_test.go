@@ -0,0 +1,116 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+
+	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/test"
+)
+
+func TestRunOnce(t *testing.T) {
+	kc := test.NewFakeKinesis()
+	kc.CreateStream("stream", 2)
+	for i := 0; i < 10; i++ {
+		_, _, err := kc.PutRecord("stream", fmt.Sprintf("key-%d", i), []byte("data"))
+		assert.Nil(t, err)
+	}
+
+	kclConfig := config.NewKinesisClientLibConfig("run-once", "stream", "us-west-2", "worker").
+		WithInitialPositionInStream(config.TRIM_HORIZON).
+		WithIdleTimeBetweenReadsInMillis(10).
+		WithShardSyncIntervalMillis(10)
+	table, err := chk.NewMemoryLeaseTable("")
+	assert.Nil(t, err)
+	checkpointer := chk.NewMemoryCheckpoint(kclConfig).WithLeaseTable(table)
+	processor := &replayProcessor{}
+	w := NewWorker(replayProcessorFactory{processor}, kclConfig).WithKinesis(kc).WithCheckpointer(checkpointer)
+
+	// the worker stops once both shards are caught up
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	assert.Nil(t, w.RunOnce(ctx))
+	assert.Equal(t, 10, len(processor.delivered()))
+	assert.Equal(t, []kcl.ShutdownReason{kcl.REQUESTED, kcl.REQUESTED}, processor.reasons)
+	assert.False(t, w.Status().Running)
+
+	// the worker stops at the deadline otherwise
+	kc.InjectFault(test.Fault{Operation: "GetRecords", Err: test.ThrottlingError()})
+	processor = &replayProcessor{}
+	w = NewWorker(replayProcessorFactory{processor}, kclConfig).WithKinesis(kc).WithCheckpointer(checkpointer)
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, w.RunOnce(ctx), context.DeadlineExceeded)
+	assert.Empty(t, processor.delivered())
+}
+
+// lastRecordProcessor records the sequence number of the last record without checkpointing
+type lastRecordProcessor struct {
+	shutdownRecorder
+	last string
+}
+
+func (p *lastRecordProcessor) ProcessRecords(input *kcl.ProcessRecordsInput) error {
+	if len(input.Records) > 0 {
+		p.last = aws.ToString(input.Records[len(input.Records)-1].SequenceNumber)
+	}
+	return nil
+}
+
+func TestRunOnceWithSteadyWrites(t *testing.T) {
+	// every read returns a record at the tip of the shard
+	var reads, generation int64
+	server := newEndlessServer(t, &reads, &generation)
+	defer server.Close()
+
+	kclConfig := config.NewKinesisClientLibConfig("run-once", "stream", "us-west-2", "worker").
+		WithIdleTimeBetweenReadsInMillis(10).
+		WithShardSyncIntervalMillis(10).
+		WithAutoCheckpoint(60000, 0)
+	table, err := chk.NewMemoryLeaseTable("")
+	assert.Nil(t, err)
+	checkpointer := chk.NewMemoryCheckpoint(kclConfig).WithLeaseTable(table)
+	processor := &lastRecordProcessor{}
+	w := NewWorker(processorFactory{processor}, kclConfig).
+		WithKinesis(newTestKinesisClient(server.URL)).
+		WithCheckpointer(checkpointer)
+
+	// the worker stops although the shard never returns an empty batch
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	assert.Nil(t, w.RunOnce(ctx))
+	assert.NotEmpty(t, processor.last)
+
+	// the last processed record is checkpointed automatically before the worker stops
+	shard := &par.ShardStatus{ID: "shardId-0", Mux: &sync.RWMutex{}}
+	assert.Nil(t, checkpointer.FetchCheckpoint(shard))
+	assert.Equal(t, processor.last, shard.GetCheckpoint())
+}
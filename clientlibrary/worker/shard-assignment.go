@@ -0,0 +1,101 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+// assignedWorker returns the ID of the worker a shard is pre-assigned to by the ShardAssignment, or "" if the shard
+// is leased by any worker
+func (w *Worker) assignedWorker(shard *par.ShardStatus) string {
+	if w.kclConfig.ShardAssignment == nil {
+		return ""
+	}
+
+	s := types.Shard{ShardId: aws.String(shard.GetShardID())}
+	if shard.ParentShardId != "" {
+		s.ParentShardId = aws.String(shard.ParentShardId)
+	}
+	if shard.StartingHashKey != "" {
+		s.HashKeyRange = &types.HashKeyRange{
+			StartingHashKey: aws.String(shard.StartingHashKey),
+			EndingHashKey:   aws.String(shard.EndingHashKey),
+		}
+	}
+	return w.kclConfig.ShardAssignment(shard.StreamName, s)
+}
+
+// isLeasableByAssignment returns true unless the shard is pre-assigned to another worker. A shard assigned to another
+// worker is leased once its lease has not been held for ShardAssignmentFallbackMillis, as seen by this worker.
+func (w *Worker) isLeasableByAssignment(shard *par.ShardStatus) bool {
+	assigned := w.assignedWorker(shard)
+	if assigned == "" || assigned == w.workerID {
+		return true
+	}
+	if w.kclConfig.ShardAssignmentFallbackMillis == 0 {
+		return false
+	}
+
+	// the wait starts over while the lease is held, by the assigned worker or by another worker
+	if shard.GetLeaseOwner() != "" && !w.isLeaseExpired(shard, time.Now()) {
+		delete(w.assignmentWaits, shard.ID)
+		return false
+	}
+
+	waitingSince, ok := w.assignmentWaits[shard.ID]
+	if !ok {
+		w.assignmentWaits[shard.ID] = time.Now()
+		return false
+	}
+	if time.Since(waitingSince) < time.Duration(w.kclConfig.ShardAssignmentFallbackMillis)*time.Millisecond {
+		return false
+	}
+
+	w.log.Infof("Shard %s assigned to %s has not been leased for %d ms, taking it over", shard.ID, assigned, w.kclConfig.ShardAssignmentFallbackMillis)
+	delete(w.assignmentWaits, shard.ID)
+	return true
+}
+
+// claimAssignedShard claims a shard pre-assigned to the worker from the worker which has taken it over, so that it
+// is handed back with lease stealing. It returns true if the shard has been claimed.
+func (w *Worker) claimAssignedShard(shard *par.ShardStatus) bool {
+	if !w.kclConfig.EnableLeaseStealing || w.assignedWorker(shard) != w.workerID {
+		return false
+	}
+
+	owner := shard.GetLeaseOwner()
+	if owner == "" || owner == w.workerID || shard.GetClaimRequest() != "" || w.isLeaseExpired(shard, time.Now()) {
+		return false
+	}
+
+	if err := w.checkpointer.ClaimShard(shard, w.workerID); err != nil {
+		w.log.Warnf("Couldn't claim shard %s assigned to the worker from %s: %+v", shard.ID, owner, err)
+		return false
+	}
+	w.log.Infof("Claimed shard %s assigned to the worker from %s", shard.ID, owner)
+	w.shardClaims[shard.ID] = time.Now()
+	return true
+}
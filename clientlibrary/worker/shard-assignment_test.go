@@ -0,0 +1,138 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/metrics"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+func TestShardAssignment(t *testing.T) {
+	table, _ := chk.NewMemoryLeaseTable("")
+	assignment := config.StaticShardAssignment(map[string]string{"0000": "worker_1", "0001": "worker_2"})
+	newAssignedWorker := func(workerID string, fallbackMillis int) *Worker {
+		kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", workerID).
+			WithFailoverTimeMillis(1000).
+			WithMaxLeasesToAcquireAtOneTime(3).
+			WithShardAssignment(assignment).
+			WithLeaseStealing(true)
+		kclConfig.ShardAssignmentFallbackMillis = fallbackMillis
+		w := NewWorker(processorFactory{}, kclConfig).
+			WithCheckpointer(chk.NewMemoryCheckpoint(kclConfig).WithLeaseTable(table))
+		w.shardStatus = map[string]*par.ShardStatus{}
+		w.shardClaims = map[string]time.Time{}
+		for _, shard := range newTestShards(3) {
+			shard.Checkpoint = "deadbeef"
+			w.shardStatus[shard.ID] = shard
+		}
+		return w
+	}
+	leaseIDs := func(shards []*par.ShardStatus) []string {
+		var ids []string
+		for _, shard := range shards {
+			ids = append(ids, shard.ID)
+		}
+		return ids
+	}
+
+	// the shards assigned to other workers are left to them
+	worker1 := newAssignedWorker("worker_1", 0)
+	assert.ElementsMatch(t, []string{"0000", "0002"}, leaseIDs(worker1.acquireLeases()))
+	assert.Empty(t, worker1.acquireLeases())
+
+	// another worker takes over a shard whose assigned worker has not leased it for the fallback time
+	worker3 := newAssignedWorker("worker_3", 50)
+	assert.Empty(t, worker3.acquireLeases())
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, []string{"0001"}, leaseIDs(worker3.acquireLeases()))
+
+	// the assigned worker claims the shard back, and takes it over once it has been handed off
+	worker2 := newAssignedWorker("worker_2", 0)
+	assert.Empty(t, worker2.acquireLeases())
+	assert.Contains(t, worker2.shardClaims, "0001")
+
+	shard := worker3.shardStatus["0001"]
+	assert.Nil(t, worker3.checkpointer.FetchCheckpoint(shard))
+	sc := &commonShardConsumer{shard: shard, kclConfig: worker3.kclConfig}
+	assert.True(t, sc.isLeaseRequested())
+	assert.Nil(t, worker3.checkpointer.RemoveLeaseOwner("0001"))
+
+	assert.Equal(t, []string{"0001"}, leaseIDs(worker2.acquireLeases()))
+	assert.Empty(t, worker2.shardClaims)
+
+}
+
+func TestRebalanceShardAssignment(t *testing.T) {
+	table, _ := chk.NewMemoryLeaseTable("")
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker_2").
+		WithShardAssignment(config.StaticShardAssignment(map[string]string{"0000": "worker_1", "0001": "worker_1"})).
+		WithLeaseStealing(true)
+	w := NewWorker(processorFactory{}, kclConfig).
+		WithCheckpointer(chk.NewMemoryCheckpoint(kclConfig).WithLeaseTable(table))
+	w.shardStatus = map[string]*par.ShardStatus{}
+	w.shardClaims = map[string]time.Time{}
+	for _, shard := range newTestShards(3) {
+		shard.Checkpoint = "deadbeef"
+		assert.Nil(t, w.checkpointer.GetLease(shard, "worker_1"))
+		w.shardStatus[shard.ID] = shard
+	}
+
+	// the fair share of 2 leases is only reached with the shards which are not assigned to the lease owner
+	assert.Nil(t, w.rebalance())
+	assert.Equal(t, 1, len(w.shardClaims))
+	assert.Contains(t, w.shardClaims, "0002")
+}
+
+// expiryCheckpointer tells the expiry of the leases like the checkpointers observing the lease renewals
+type expiryCheckpointer struct {
+	chk.Checkpointer
+	expired bool
+}
+
+func (c *expiryCheckpointer) IsLeaseExpired(*par.ShardStatus, time.Time) bool {
+	return c.expired
+}
+
+func TestShardAssignmentLeaseExpiry(t *testing.T) {
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker_1").
+		WithShardAssignment(config.StaticShardAssignment(map[string]string{"0000": "worker_1"})).
+		WithLeaseStealing(true)
+	checkpointer := &expiryCheckpointer{Checkpointer: newMockCheckpointer()}
+	w := NewWorker(processorFactory{}, kclConfig).WithCheckpointer(newMonitoredCheckpointer(checkpointer, metrics.NoopMonitoringService{}))
+	w.shardClaims = map[string]time.Time{}
+
+	// the lease timeout written by the owner is not compared with the local clock
+	shard := newTestShards(1)[0]
+	shard.AssignedTo = "worker_2"
+	shard.LeaseTimeout = time.Now().Add(time.Hour)
+	checkpointer.expired = true
+	assert.False(t, w.claimAssignedShard(shard))
+
+	checkpointer.expired = false
+	shard.LeaseTimeout = time.Now().Add(-time.Hour)
+	assert.True(t, w.claimAssignedShard(shard))
+}
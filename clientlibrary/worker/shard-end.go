@@ -0,0 +1,72 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package worker
+package worker
+
+import (
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+type (
+	// closedShard is a shard whose end has been reached with the child shards returned by Kinesis
+	closedShard struct {
+		shard    *par.ShardStatus
+		children []types.ChildShard
+	}
+
+	// shardEndNotifier signals the worker when shard consumers reach the end of their shards. It keeps the child
+	// shards of the closed shards, so that they are leased without waiting for the next listing of the shards.
+	shardEndNotifier struct {
+		mux    sync.Mutex
+		closed []closedShard
+		signal chan struct{}
+	}
+)
+
+func newShardEndNotifier() *shardEndNotifier {
+	return &shardEndNotifier{signal: make(chan struct{}, 1)}
+}
+
+// notify records the closed shard and wakes up the worker
+func (n *shardEndNotifier) notify(shard *par.ShardStatus, children []types.ChildShard) {
+	n.mux.Lock()
+	n.closed = append(n.closed, closedShard{shard: shard, children: children})
+	n.mux.Unlock()
+
+	select {
+	case n.signal <- struct{}{}:
+	default:
+		// the worker has been signaled already
+	}
+}
+
+// take returns the shards closed since the last call
+func (n *shardEndNotifier) take() []closedShard {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	closed := n.closed
+	n.closed = nil
+	return closed
+}
@@ -0,0 +1,91 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+)
+
+// newSplitShardServer returns a Kinesis endpoint listing a single shard, which is closed with one child shard
+// returned by GetRecords
+func newSplitShardServer(t *testing.T, listShardsCalls *int32) *httptest.Server {
+	list := listShards(testShard("shardId-0", "100"))
+	return newKinesisServer(t, kinesisHandlers{
+		"ListShards": func(input map[string]interface{}) interface{} {
+			atomic.AddInt32(listShardsCalls, 1)
+			return list(input)
+		},
+		"GetShardIterator": func(input map[string]interface{}) interface{} {
+			return map[string]interface{}{"ShardIterator": input["ShardId"]}
+		},
+		"GetRecords": func(input map[string]interface{}) interface{} {
+			if input["ShardIterator"] != "shardId-0" {
+				return getRecordsOutput(input["ShardIterator"])
+			}
+			output := getRecordsOutput(nil, kinesisRecord("100", "YQ=="))
+			output["ChildShards"] = []map[string]interface{}{{
+				"ShardId":      "shardId-1",
+				"ParentShards": []string{"shardId-0"},
+				"HashKeyRange": map[string]string{"StartingHashKey": "0", "EndingHashKey": "1"},
+			}}
+			return output
+		},
+	})
+}
+
+func TestChildShardsLeasedAtShardEnd(t *testing.T) {
+	var listShards int32
+	server := newSplitShardServer(t, &listShards)
+	defer server.Close()
+
+	table, _ := chk.NewMemoryLeaseTable("")
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+		WithMaxLeasesForWorker(2)
+	recorder := &eventRecorder{}
+	w := NewWorker(shardEndCheckpointerFactory{}, kclConfig).
+		WithKinesis(newTestKinesisClient(server.URL)).
+		WithCheckpointer(chk.NewMemoryCheckpoint(kclConfig).WithLeaseTable(table)).
+		WithStateListener(recorder)
+
+	assert.Nil(t, w.Start())
+	assert.Eventually(t, func() bool {
+		return len(recorder.recorded()) == 5
+	}, 5*time.Second, 10*time.Millisecond)
+	w.Shutdown()
+
+	// the child shard is leased without listing the shards again, concurrently to the release of the parent lease
+	assert.ElementsMatch(t, []string{
+		"started worker",
+		"acquired shardId-0",
+		"ended shardId-0",
+		"lost shardId-0",
+		"acquired shardId-1",
+	}, recorder.recorded()[:5])
+	assert.Equal(t, int32(1), atomic.LoadInt32(&listShards))
+	assert.Equal(t, "shardId-0", w.shardStatus["shardId-1"].ParentShardId)
+}
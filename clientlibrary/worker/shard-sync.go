@@ -0,0 +1,160 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package worker
+package worker
+
+import (
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+)
+
+// listShardsTokenTimeout is the time an interrupted listing of the shards is resumed within. The NextToken returned by
+// ListShards expires after 300 seconds.
+const listShardsTokenTimeout = 4 * time.Minute
+
+type (
+	// shardListing is a listing of the shards of a stream interrupted by throttling. The next sync resumes it from the
+	// NextToken of the last listed page instead of listing all shards again.
+	shardListing struct {
+		nextToken string
+		// listed are the lease keys of the shards listed before the listing was interrupted
+		listed map[string]bool
+		issued time.Time
+	}
+
+	// shardSyncState keeps the state of the shard syncs across iterations of the event loop, it is only accessed
+	// by the event loop.
+	shardSyncState struct {
+		kclConfig *config.KinesisClientLibConfiguration
+
+		// listings are the interrupted listings of the shards by stream name
+		listings map[string]*shardListing
+
+		// lineage are the lease keys of the child shards by lease key of their parents, so that the children of a
+		// closed shard are leased without syncing the shards if they have been listed already
+		lineage map[string][]string
+
+		// shardEndUntil is the end of the rapid syncs following the end of a shard
+		shardEndUntil time.Time
+		// shardEndInterval is the interval of the rapid syncs, which doubles while ListShards is throttled
+		shardEndInterval time.Duration
+	}
+)
+
+func newShardSyncState(kclConfig *config.KinesisClientLibConfiguration) *shardSyncState {
+	return &shardSyncState{
+		kclConfig:        kclConfig,
+		listings:         make(map[string]*shardListing),
+		lineage:          make(map[string][]string),
+		shardEndInterval: time.Duration(kclConfig.ShardEndSyncIntervalMillis) * time.Millisecond,
+	}
+}
+
+// resume returns the interrupted listing of the shards of the stream, or nil if the shards have to be listed from the
+// start. A listing is resumed at most once.
+func (s *shardSyncState) resume(streamName string) *shardListing {
+	listing, ok := s.listings[streamName]
+	if !ok {
+		return nil
+	}
+	delete(s.listings, streamName)
+	if time.Since(listing.issued) >= listShardsTokenTimeout {
+		return nil
+	}
+	return listing
+}
+
+// interrupt keeps the listing of the shards of the stream to resume it from the NextToken by the next sync
+func (s *shardSyncState) interrupt(streamName, nextToken string, listed map[string]bool) {
+	s.listings[streamName] = &shardListing{nextToken: nextToken, listed: listed, issued: time.Now()}
+}
+
+// addChild records the child shard of a parent shard, both identified by their lease keys
+func (s *shardSyncState) addChild(parent, child string) {
+	if parent == "" {
+		return
+	}
+	for _, c := range s.lineage[parent] {
+		if c == child {
+			return
+		}
+	}
+	s.lineage[parent] = append(s.lineage[parent], child)
+}
+
+// children returns the lease keys of the known child shards of the shard
+func (s *shardSyncState) children(parent string) []string {
+	return s.lineage[parent]
+}
+
+// prune forgets the lineage of the shards for which keep returns false, e.g. expired shards
+func (s *shardSyncState) prune(keep func(key string) bool) {
+	for parent := range s.lineage {
+		if !keep(parent) {
+			delete(s.lineage, parent)
+		}
+	}
+}
+
+// shardEnded starts the rapid syncs following the end of a shard
+func (s *shardSyncState) shardEnded() {
+	if s.kclConfig.ShardEndSyncIntervalMillis > 0 {
+		s.shardEndUntil = time.Now().Add(time.Duration(s.kclConfig.ShardEndSyncDurationMillis) * time.Millisecond)
+	}
+}
+
+// synced updates the interval of the rapid syncs after a sync of the shards. The interval doubles up to
+// ShardSyncIntervalMillis while ListShards is throttled and is reset by a successful sync.
+func (s *shardSyncState) synced(err error) {
+	interval := time.Duration(s.kclConfig.ShardEndSyncIntervalMillis) * time.Millisecond
+	if err == nil || !isListShardsThrottled(err) {
+		s.shardEndInterval = interval
+		return
+	}
+
+	maxInterval := time.Duration(s.kclConfig.ShardSyncIntervalMillis) * time.Millisecond
+	s.shardEndInterval *= 2
+	if s.shardEndInterval < interval {
+		s.shardEndInterval = interval
+	}
+	if s.shardEndInterval > maxInterval {
+		s.shardEndInterval = maxInterval
+	}
+}
+
+// nextSync returns the number of milliseconds to wait for before the next sync of the shards given the regular,
+// jittered interval
+func (s *shardSyncState) nextSync(intervalMillis int) int {
+	shardEndMillis := int(s.shardEndInterval / time.Millisecond)
+	if shardEndMillis <= 0 || !time.Now().Before(s.shardEndUntil) || shardEndMillis > intervalMillis {
+		return intervalMillis
+	}
+	return shardEndMillis
+}
+
+// isListShardsThrottled tells whether ListShards failed because the rate of the calls exceeded its limit
+func isListShardsThrottled(err error) bool {
+	var limitExceededErr *types.LimitExceededException
+	return errors.As(err, &limitExceededErr)
+}
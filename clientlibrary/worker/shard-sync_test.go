@@ -0,0 +1,124 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/test"
+)
+
+func TestSyncShardResumesThrottledListing(t *testing.T) {
+	kc := &test.MockKinesisAPI{}
+	// the first page is only listed once
+	kc.On("ListShards", mock.Anything, &kinesis.ListShardsInput{StreamName: aws.String("stream")}).Return(&kinesis.ListShardsOutput{
+		Shards: []types.Shard{{
+			ShardId:             aws.String("shardId-0"),
+			SequenceNumberRange: &types.SequenceNumberRange{StartingSequenceNumber: aws.String("0")},
+		}},
+		NextToken: aws.String("page-2"),
+	}, nil).Once()
+	kc.On("ListShards", mock.Anything, &kinesis.ListShardsInput{NextToken: aws.String("page-2")}).
+		Return(nil, &types.LimitExceededException{Message: aws.String("rate exceeded")}).Once()
+	kc.On("ListShards", mock.Anything, &kinesis.ListShardsInput{NextToken: aws.String("page-2")}).Return(&kinesis.ListShardsOutput{
+		Shards: []types.Shard{{
+			ShardId:             aws.String("shardId-1"),
+			ParentShardId:       aws.String("shardId-0"),
+			SequenceNumberRange: &types.SequenceNumberRange{StartingSequenceNumber: aws.String("1")},
+		}},
+	}, nil).Once()
+
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker")
+	checkpointer := newMockCheckpointer()
+	w := NewWorker(shutdownRecorderFactory{}, kclConfig).WithKinesis(kc).WithCheckpointer(checkpointer)
+	w.shardStatus = map[string]*par.ShardStatus{}
+
+	err := w.syncShard()
+	assert.True(t, isListShardsThrottled(err))
+	assert.Contains(t, w.shardStatus, "shardId-0")
+
+	// the listing is resumed from the NextToken and the shards of the first page are kept
+	checkpointer.checkpoints["shardId-0"] = "100"
+	assert.Nil(t, w.syncShard())
+	assert.Equal(t, 2, len(w.shardStatus))
+	assert.Equal(t, "100", checkpointer.checkpoints["shardId-0"])
+	kc.AssertExpectations(t)
+
+	// the child shards of a closed shard are known from the listing
+	assert.True(t, w.addChildShards([]closedShard{{shard: w.shardStatus["shardId-0"]}}))
+	assert.False(t, w.addChildShards([]closedShard{{shard: w.shardStatus["shardId-1"]}}))
+}
+
+func TestShardSyncStateExpiredListing(t *testing.T) {
+	s := newShardSyncState(config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker"))
+	s.interrupt("stream", "page-2", map[string]bool{"shardId-0": true})
+	s.listings["stream"].issued = time.Now().Add(-listShardsTokenTimeout)
+	assert.Nil(t, s.resume("stream"))
+
+	s.interrupt("stream", "page-2", map[string]bool{"shardId-0": true})
+	assert.Equal(t, "page-2", s.resume("stream").nextToken)
+	// a listing is resumed once
+	assert.Nil(t, s.resume("stream"))
+}
+
+func TestShardSyncStateShardEnd(t *testing.T) {
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+		WithShardSyncIntervalMillis(10000).
+		WithShardEndSync(1000, 60000)
+	s := newShardSyncState(kclConfig)
+
+	// the shards are synced at the regular interval until a shard ends
+	assert.Equal(t, 8000, s.nextSync(8000))
+	s.shardEnded()
+	assert.Equal(t, 1000, s.nextSync(8000))
+	// the jittered interval is not exceeded
+	assert.Equal(t, 500, s.nextSync(500))
+
+	// the interval doubles while ListShards is throttled
+	throttled := &types.LimitExceededException{Message: aws.String("rate exceeded")}
+	for _, expected := range []int{2000, 4000, 8000, 10000, 10000} {
+		s.synced(throttled)
+		assert.Equal(t, expected, s.nextSync(20000))
+	}
+	s.synced(errors.New("failed"))
+	assert.Equal(t, 1000, s.nextSync(8000))
+	s.synced(throttled)
+	s.synced(nil)
+	assert.Equal(t, 1000, s.nextSync(8000))
+
+	s.shardEndUntil = time.Now()
+	assert.Equal(t, 8000, s.nextSync(8000))
+
+	// the rapid syncs are disabled by default
+	s = newShardSyncState(config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker"))
+	s.shardEnded()
+	s.synced(throttled)
+	assert.Equal(t, 8000, s.nextSync(8000))
+}
@@ -0,0 +1,65 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+type (
+	// WorkerStateListener is notified of the scheduling events of a worker, e.g. to update service discovery,
+	// warm caches or emit custom metrics. The callbacks are called synchronously, concurrently by the shard
+	// consumers, and therefore have to return quickly.
+	WorkerStateListener interface {
+		// WorkerStarted is called once the worker has started its event loop
+		WorkerStarted(workerID string)
+
+		// WorkerStopped is called once the worker has shut down its shard consumers
+		WorkerStopped(workerID string)
+
+		// LeaseAcquired is called whenever the worker has acquired the lease of a shard to process it
+		LeaseAcquired(shardID string)
+
+		// LeaseLost is called whenever the worker has stopped processing a shard and released its lease
+		LeaseLost(shardID string)
+
+		// ShardEnded is called once all records of a closed shard have been processed
+		ShardEnded(shardID string)
+
+		// ShardSyncCompleted is called after every shard sync with the lease keys of the shards of the streams
+		ShardSyncCompleted(shardIDs []string)
+	}
+
+	// NoopWorkerStateListener ignores all events, it can be embedded to implement only some callbacks
+	NoopWorkerStateListener struct{}
+)
+
+// WithStateListener sets the listener notified of the scheduling events of the worker. The listener has to be set
+// before the worker is started, a nil listener leaves the events unobserved.
+func (w *Worker) WithStateListener(listener WorkerStateListener) *Worker {
+	if listener == nil {
+		listener = NoopWorkerStateListener{}
+	}
+	w.stateListener = listener
+	return w
+}
+
+func (NoopWorkerStateListener) WorkerStarted(workerID string)        {}
+func (NoopWorkerStateListener) WorkerStopped(workerID string)        {}
+func (NoopWorkerStateListener) LeaseAcquired(shardID string)         {}
+func (NoopWorkerStateListener) LeaseLost(shardID string)             {}
+func (NoopWorkerStateListener) ShardEnded(shardID string)            {}
+func (NoopWorkerStateListener) ShardSyncCompleted(shardIDs []string) {}
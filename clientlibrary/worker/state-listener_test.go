@@ -0,0 +1,149 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+// eventRecorder records the events of a worker
+type eventRecorder struct {
+	NoopWorkerStateListener
+	mux    sync.Mutex
+	events []string
+	shards []string
+}
+
+func (r *eventRecorder) record(event string) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *eventRecorder) recorded() []string {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return append([]string(nil), r.events...)
+}
+
+func (r *eventRecorder) WorkerStarted(workerID string) { r.record("started " + workerID) }
+
+func (r *eventRecorder) WorkerStopped(workerID string) { r.record("stopped " + workerID) }
+
+func (r *eventRecorder) LeaseAcquired(shardID string) { r.record("acquired " + shardID) }
+
+func (r *eventRecorder) LeaseLost(shardID string) { r.record("lost " + shardID) }
+
+func (r *eventRecorder) ShardEnded(shardID string) { r.record("ended " + shardID) }
+
+func (r *eventRecorder) ShardSyncCompleted(shardIDs []string) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.shards = shardIDs
+}
+
+// shardEndCheckpointer checkpoints the end of the shard once it is closed
+type shardEndCheckpointer struct {
+	shutdownRecorder
+}
+
+func (c *shardEndCheckpointer) Shutdown(input *kcl.ShutdownInput) {
+	if input.ShutdownReason == kcl.TERMINATE {
+		_ = input.Checkpointer.Checkpoint(nil)
+	}
+}
+
+type shardEndCheckpointerFactory struct{}
+
+func (shardEndCheckpointerFactory) CreateProcessor() kcl.IRecordProcessor {
+	return &shardEndCheckpointer{}
+}
+
+// newClosedShardServer returns a Kinesis endpoint listing a single closed shard with one record
+func newClosedShardServer(t *testing.T) *httptest.Server {
+	return newKinesisServer(t, kinesisHandlers{
+		"ListShards":       listShards(testShard("shardId-0", "100")),
+		"GetShardIterator": shardIterator("iterator"),
+		"GetRecords": func(map[string]interface{}) interface{} {
+			return getRecordsOutput(nil, kinesisRecord("100", "YQ=="))
+		},
+	})
+}
+
+func TestWorkerStateListener(t *testing.T) {
+	server := newClosedShardServer(t)
+	defer server.Close()
+
+	table, _ := chk.NewMemoryLeaseTable("")
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker")
+	recorder := &eventRecorder{}
+	w := NewWorker(shardEndCheckpointerFactory{}, kclConfig).
+		WithKinesis(newTestKinesisClient(server.URL)).
+		WithCheckpointer(chk.NewMemoryCheckpoint(kclConfig).WithLeaseTable(table)).
+		WithStateListener(recorder)
+
+	assert.Nil(t, w.Start())
+	assert.Eventually(t, func() bool {
+		return len(recorder.recorded()) == 4
+	}, 5*time.Second, 10*time.Millisecond)
+	// the shard is not leased again once its end has been checkpointed
+	time.Sleep(100 * time.Millisecond)
+	w.Shutdown()
+
+	assert.Equal(t, []string{
+		"started worker",
+		"acquired shardId-0",
+		"ended shardId-0",
+		"lost shardId-0",
+		"stopped worker",
+	}, recorder.recorded())
+	assert.Equal(t, []string{"shardId-0"}, recorder.shards)
+}
+
+func TestWorkerStateListenerNil(t *testing.T) {
+	server := newClosedShardServer(t)
+	defer server.Close()
+
+	table, _ := chk.NewMemoryLeaseTable("")
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker")
+	checkpointer := chk.NewMemoryCheckpoint(kclConfig).WithLeaseTable(table)
+	w := NewWorker(shardEndCheckpointerFactory{}, kclConfig).
+		WithKinesis(newTestKinesisClient(server.URL)).
+		WithCheckpointer(checkpointer).
+		WithStateListener(nil)
+
+	// the events are not observed, the shard is still leased and processed to its end
+	assert.Nil(t, w.Start())
+	shard := &par.ShardStatus{ID: "shardId-0", Mux: &sync.RWMutex{}}
+	assert.Eventually(t, func() bool {
+		return checkpointer.FetchCheckpoint(shard) == nil && shard.GetCheckpoint() == chk.ShardEnd
+	}, 5*time.Second, 10*time.Millisecond)
+	w.Shutdown()
+}
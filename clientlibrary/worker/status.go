@@ -0,0 +1,263 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+	"github.com/vmware/vmware-go-kcl-v2/logger"
+)
+
+type (
+	// WorkerStatus is a snapshot of the state of a worker, e.g. for readiness and liveness probes
+	WorkerStatus struct {
+		WorkerID string `json:"workerId"`
+
+		// Running is true between Start and Shutdown of the worker
+		Running bool `json:"running"`
+
+		// Shards are the shards consumed by the worker ordered by their lease key
+		Shards []ShardConsumerStatus `json:"shards"`
+	}
+
+	// ShardConsumerStatus is the state of the consumer of a shard held by the worker
+	ShardConsumerStatus struct {
+		// ShardID is the lease key of the shard
+		ShardID string `json:"shardId"`
+
+		// StreamName is the stream of the shard in multi-stream mode
+		StreamName string `json:"streamName,omitempty"`
+
+		State ConsumerState `json:"state"`
+
+		// Checkpoint is the last checkpointed sequence number of the shard
+		Checkpoint string `json:"checkpoint"`
+
+		// MillisBehindLatest of the last batch of records
+		MillisBehindLatest int64 `json:"millisBehindLatest"`
+
+		// LeaseTimeout is the time the lease expires unless it is renewed
+		LeaseTimeout time.Time `json:"leaseTimeout"`
+
+		// LastLeaseRenewal is the time the lease has been gained or renewed the last time
+		LastLeaseRenewal time.Time `json:"lastLeaseRenewal"`
+
+		// LeaseRenewalAgeMillis is the time since the last lease renewal
+		LeaseRenewalAgeMillis int64 `json:"leaseRenewalAgeMillis"`
+
+		// LeaseRenewals is the number of renewals of the lease by the consumer, it is a heartbeat of the lease renewer
+		LeaseRenewals int64 `json:"leaseRenewals"`
+
+		// RecordsProcessed and BytesProcessed count the records delivered to the record processor since the lease
+		// has been gained, their rates are the throughput of the shard
+		RecordsProcessed int64 `json:"recordsProcessed"`
+		BytesProcessed   int64 `json:"bytesProcessed"`
+	}
+)
+
+// consumerStatus tracks the state of a shard consumer. The methods are no-ops on a nil status, so that shard
+// consumers can be used without a worker.
+type consumerStatus struct {
+	sync.Mutex
+
+	shard              *par.ShardStatus
+	state              ConsumerState
+	millisBehindLatest int64
+	lastLeaseRenewal   time.Time
+	leaseRenewals      int64
+	recordsProcessed   int64
+	bytesProcessed     int64
+
+	// caughtUp is the last time the consumer received no records at the tip of the shard
+	caughtUp time.Time
+
+	// nearTip tells whether the last batch was read within the idle time between reads from the tip of the shard
+	nearTip bool
+
+	// rewind passes a requested rewind to the consumer
+	rewind chan *rewindRequest
+
+	// release passes a requested release of the lease to the consumer
+	release chan *releaseRequest
+
+	// resumed is closed once the shard paused by the application is resumed, it is nil unless the shard is paused
+	resumed chan struct{}
+
+	// listener is notified of the transitions of the state
+	listener ShardConsumerStateListener
+	log      logger.Logger
+}
+
+func (s *consumerStatus) leaseRenewed() {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.lastLeaseRenewal = time.Now()
+	s.leaseRenewals++
+}
+
+// setMillisBehindLatest records the lag of the last batch, which is near the tip of the shard if it does not exceed
+// the threshold
+func (s *consumerStatus) setMillisBehindLatest(millisBehindLatest, nearTipMillis int64) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.millisBehindLatest = millisBehindLatest
+	s.nearTip = millisBehindLatest <= nearTipMillis
+}
+
+// addProcessed counts the records of a batch delivered to the record processor
+func (s *consumerStatus) addProcessed(records int, bytes int64) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.recordsProcessed += int64(records)
+	s.bytesProcessed += bytes
+}
+
+// setCaughtUp records that the consumer received no records at the tip of the shard, so that it has nothing left to
+// checkpoint
+func (s *consumerStatus) setCaughtUp() {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.caughtUp = time.Now()
+}
+
+func (s *consumerStatus) snapshot(now time.Time) ShardConsumerStatus {
+	s.Lock()
+	defer s.Unlock()
+	return ShardConsumerStatus{
+		ShardID:               s.shard.ID,
+		StreamName:            s.shard.StreamName,
+		State:                 s.state,
+		Checkpoint:            s.shard.GetCheckpoint(),
+		MillisBehindLatest:    s.millisBehindLatest,
+		LeaseTimeout:          s.shard.GetLeaseTimeout(),
+		LastLeaseRenewal:      s.lastLeaseRenewal,
+		LeaseRenewalAgeMillis: now.Sub(s.lastLeaseRenewal).Milliseconds(),
+		LeaseRenewals:         s.leaseRenewals,
+		RecordsProcessed:      s.recordsProcessed,
+		BytesProcessed:        s.bytesProcessed,
+	}
+}
+
+// registerConsumer starts tracking the consumer of a shard whose lease has just been gained
+func (w *Worker) registerConsumer(shard *par.ShardStatus) *consumerStatus {
+	status := &consumerStatus{
+		shard:            shard,
+		state:            ConsumerWaitingOnParent,
+		lastLeaseRenewal: time.Now(),
+		rewind:           make(chan *rewindRequest, 1),
+		release:          make(chan *releaseRequest, 1),
+		listener:         w.consumerStateListener,
+		log:              w.log,
+	}
+	w.statusMux.Lock()
+	defer w.statusMux.Unlock()
+	// a shard stays paused when its lease is acquired again
+	status.resumed = w.pausedShards[shard.ID]
+	w.consumers[shard.ID] = status
+	return status
+}
+
+func (w *Worker) unregisterConsumer(shard *par.ShardStatus) {
+	w.statusMux.Lock()
+	defer w.statusMux.Unlock()
+	if status, ok := w.consumers[shard.ID]; ok {
+		status.cancelRewind()
+		status.cancelRelease()
+	}
+	delete(w.consumers, shard.ID)
+}
+
+// drainingShards returns the lease keys of the shards whose consumers are still running
+func (w *Worker) drainingShards() []string {
+	w.statusMux.RLock()
+	defer w.statusMux.RUnlock()
+
+	shards := make([]string, 0, len(w.consumers))
+	for shard := range w.consumers {
+		shards = append(shards, shard)
+	}
+	sort.Strings(shards)
+	return shards
+}
+
+func (w *Worker) consumerStatus(shard *par.ShardStatus) *consumerStatus {
+	w.statusMux.RLock()
+	defer w.statusMux.RUnlock()
+	return w.consumers[shard.ID]
+}
+
+func (w *Worker) setRunning(running bool) {
+	w.statusMux.Lock()
+	defer w.statusMux.Unlock()
+	w.running = running
+}
+
+// Status returns the state of the worker and of the consumers of the shards it holds.
+func (w *Worker) Status() WorkerStatus {
+	w.statusMux.RLock()
+	defer w.statusMux.RUnlock()
+
+	now := time.Now()
+	status := WorkerStatus{
+		WorkerID: w.workerID,
+		Running:  w.running,
+		Shards:   make([]ShardConsumerStatus, 0, len(w.consumers)),
+	}
+	for _, consumer := range w.consumers {
+		status.Shards = append(status.Shards, consumer.snapshot(now))
+	}
+	sort.Slice(status.Shards, func(i, j int) bool {
+		return status.Shards[i].ShardID < status.Shards[j].ShardID
+	})
+	return status
+}
+
+// StatusHandler returns an HTTP handler serving the status of the worker as JSON. It responds with
+// 503 Service Unavailable unless the worker is running, so that it can be used as readiness probe.
+func (w *Worker) StatusHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		status := w.Status()
+
+		rw.Header().Set("Content-Type", "application/json")
+		if !status.Running {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+		if err := json.NewEncoder(rw).Encode(status); err != nil {
+			w.log.Errorf("Failed to encode worker status: %+v", err)
+		}
+	})
+}
@@ -0,0 +1,114 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+// statusProcessor checkpoints the first batch and captures the worker status while processing the second one
+type statusProcessor struct {
+	shutdownRecorder
+	worker   **Worker
+	captured *WorkerStatus
+	stop     func()
+	calls    int
+}
+
+func (p *statusProcessor) ProcessRecords(input *kcl.ProcessRecordsInput) error {
+	p.calls++
+	if p.calls == 1 {
+		return input.Checkpointer.Checkpoint(input.Records[0].SequenceNumber)
+	}
+	*p.captured = (*p.worker).Status()
+	p.stop()
+	return nil
+}
+
+func TestWorkerStatus(t *testing.T) {
+	server := newGetRecordsServer(t)
+	defer server.Close()
+
+	var w *Worker
+	var captured WorkerStatus
+	stop := make(chan struct{})
+	stopOnce := sync.Once{}
+	processor := &statusProcessor{worker: &w, captured: &captured, stop: func() { stopOnce.Do(func() { close(stop) }) }}
+
+	checkpointer := newMockCheckpointer()
+	w = NewWorker(processorFactory{processor}, config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker")).
+		WithKinesis(newTestKinesisClient(server.URL)).
+		WithCheckpointer(checkpointer)
+	w.stop = &stop
+
+	shard := &par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}, LeaseTimeout: time.Now().Add(time.Minute)}
+	assert.Nil(t, checkpointer.GetLease(shard, "worker"))
+	w.runShardConsumer(shard)
+
+	assert.Equal(t, "worker", captured.WorkerID)
+	assert.Equal(t, 1, len(captured.Shards))
+	status := captured.Shards[0]
+	assert.Equal(t, "0001", status.ShardID)
+	assert.Equal(t, ConsumerProcessing, status.State)
+	assert.Equal(t, "100", status.Checkpoint)
+	assert.False(t, status.LastLeaseRenewal.IsZero())
+	assert.Equal(t, shard.GetLeaseTimeout(), status.LeaseTimeout)
+	// the first batch has been counted
+	assert.Greater(t, status.RecordsProcessed, int64(0))
+	assert.Greater(t, status.BytesProcessed, int64(0))
+
+	// the shard is no longer reported once its consumer has stopped
+	assert.Empty(t, w.Status().Shards)
+}
+
+func TestStatusHandler(t *testing.T) {
+	w := NewWorker(shutdownRecorderFactory{}, config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker"))
+	w.registerConsumer(&par.ShardStatus{ID: "0001", Checkpoint: "100", Mux: &sync.RWMutex{}})
+
+	// the worker is not ready before it has been started
+	rec := httptest.NewRecorder()
+	w.StatusHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	w.setRunning(true)
+	rec = httptest.NewRecorder()
+	w.StatusHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var body map[string]interface{}
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, true, body["running"])
+	shard := body["shards"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "0001", shard["shardId"])
+	assert.Equal(t, "STARTING", shard["state"])
+	assert.Equal(t, "100", shard["checkpoint"])
+}
@@ -0,0 +1,250 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package worker
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"runtime/debug"
+	"sort"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+)
+
+var errUnorderedPrepareCheckpoint = errors.New("prepared checkpoints are not supported with unordered processing")
+
+type (
+	// watermark checkpoints a batch processed out of order at the last record before which all the records of the
+	// batch have been checkpointed by the record processor
+	watermark struct {
+		checkpointer kcl.IRecordProcessorCheckpointer
+		records      []kcl.UserRecord
+
+		mux  sync.Mutex
+		done []bool
+		// next is the index of the first record which has not been checkpointed
+		next int
+	}
+
+	// partitionCheckpointer is the checkpointer of the records of a batch delivered to one goroutine, indices are
+	// the positions of these records in the batch
+	partitionCheckpointer struct {
+		mark    *watermark
+		indices []int
+	}
+)
+
+// processRecordsUnordered delivers the records to the record processor, fanned out to UnorderedProcessingConcurrency
+// concurrent calls by partition key. The first error of the calls fails the batch once all of them have returned, a
+// panic of a call is returned as its error since it cannot be recovered by the shard consumer.
+func (sc *commonShardConsumer) processRecordsUnordered(ctx context.Context, input *kcl.ProcessRecordsInput) error {
+	concurrency := sc.kclConfig.UnorderedProcessingConcurrency
+	if concurrency <= 1 || len(input.UserRecords) <= 1 {
+		return sc.recordProcessor.ProcessRecords(ctx, input)
+	}
+
+	mark := &watermark{
+		checkpointer: input.Checkpointer,
+		records:      input.UserRecords,
+		done:         make([]bool, len(input.UserRecords)),
+	}
+
+	partitions := partitionRecords(input.UserRecords, concurrency)
+	errs := make([]error, len(partitions))
+	var wg sync.WaitGroup
+	for i, indices := range partitions {
+		partition := *input
+		partition.UserRecords = make([]kcl.UserRecord, len(indices))
+		partition.Records = make([]types.Record, len(indices))
+		for j, index := range indices {
+			partition.UserRecords[j] = input.UserRecords[index]
+			partition.Records[j] = input.UserRecords[index].Record
+		}
+		partition.Checkpointer = &partitionCheckpointer{mark: mark, indices: indices}
+
+		wg.Add(1)
+		go func(i int, partition *kcl.ProcessRecordsInput) {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					errs[i] = fmt.Errorf("panic in ProcessRecords: %v\n%s", r, debug.Stack())
+				}
+			}()
+			errs[i] = sc.recordProcessor.ProcessRecords(ctx, partition)
+		}(i, &partition)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// partitionRecords returns the indices of the records delivered to each goroutine, the records with the same
+// partition key are kept together in order. Empty partitions are omitted.
+func partitionRecords(records []kcl.UserRecord, concurrency int) [][]int {
+	partitions := make([][]int, concurrency)
+	for i, r := range records {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(aws.ToString(r.PartitionKey)))
+		p := h.Sum32() % uint32(concurrency)
+		partitions[p] = append(partitions[p], i)
+	}
+
+	nonEmpty := partitions[:0]
+	for _, indices := range partitions {
+		if len(indices) > 0 {
+			nonEmpty = append(nonEmpty, indices)
+		}
+	}
+	return nonEmpty
+}
+
+// complete marks the records as checkpointed and returns the record the watermark advanced to, if any
+func (w *watermark) complete(indices []int) (kcl.UserRecord, bool) {
+	for _, index := range indices {
+		w.done[index] = true
+	}
+
+	before := w.next
+	for w.next < len(w.done) && w.done[w.next] {
+		w.next++
+	}
+	if w.next == before {
+		return kcl.UserRecord{}, false
+	}
+	return w.records[w.next-1], true
+}
+
+// checkpoint marks the records as checkpointed and checkpoints the record the watermark advanced to. The metadata is
+// stored with the checkpoint unless it is within an aggregated record.
+func (w *watermark) checkpoint(indices []int, metadata []byte) error {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	record, advanced := w.complete(indices)
+	if !advanced {
+		return nil
+	}
+	if metadata != nil && !record.Aggregated {
+		return w.checkpointer.CheckpointWithMetadata(record.SequenceNumber, metadata)
+	}
+	return checkpointRecord(w.checkpointer, record)
+}
+
+// checkpointAsync marks the records as checkpointed and requests an asynchronous checkpoint at the record the
+// watermark advanced to. A record within an aggregated record is checkpointed synchronously.
+func (w *watermark) checkpointAsync(indices []int) <-chan error {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	record, advanced := w.complete(indices)
+	if advanced && !record.Aggregated {
+		return w.checkpointer.CheckpointAsync(record.SequenceNumber)
+	}
+
+	result := make(chan error, 1)
+	if advanced {
+		result <- checkpointRecord(w.checkpointer, record)
+	} else {
+		result <- nil
+	}
+	close(result)
+	return result
+}
+
+// upTo returns the indices of the records of the partition up to the sequence number, including all the user
+// records of an aggregated record unless the sub-sequence number is given. The records of a partition are ordered
+// by their extended sequence numbers, the last of them at or before the requested one has to be requested.
+func (pc *partitionCheckpointer) upTo(sequenceNumber *string, subSequenceNumber *int64) ([]int, error) {
+	if sequenceNumber == nil {
+		return nil, errors.New("the end of the shard cannot be checkpointed with unordered processing")
+	}
+
+	requested := kcl.ExtendedSequenceNumber{SequenceNumber: sequenceNumber, SubSequenceNumber: subSequenceOrMax(subSequenceNumber)}
+	var compareErr error
+	n := sort.Search(len(pc.indices), func(i int) bool {
+		r := pc.mark.records[pc.indices[i]]
+		cmp, err := kcl.ExtendedSequenceNumber{SequenceNumber: r.SequenceNumber, SubSequenceNumber: r.SubSequenceNumber}.CompareTo(requested)
+		if err != nil {
+			compareErr = err
+		}
+		return cmp > 0
+	})
+	if compareErr == nil && n > 0 {
+		last := pc.mark.records[pc.indices[n-1]]
+		if aws.ToString(last.SequenceNumber) == *sequenceNumber && (subSequenceNumber == nil || last.SubSequenceNumber == *subSequenceNumber) {
+			return pc.indices[:n], nil
+		}
+	}
+	return nil, fmt.Errorf("sequence number %s is not a record of the partition", *sequenceNumber)
+}
+
+func (pc *partitionCheckpointer) Checkpoint(sequenceNumber *string) error {
+	indices, err := pc.upTo(sequenceNumber, nil)
+	if err != nil {
+		return err
+	}
+	return pc.mark.checkpoint(indices, nil)
+}
+
+func (pc *partitionCheckpointer) CheckpointWithSubSequence(sequenceNumber *string, subSequenceNumber int64) error {
+	indices, err := pc.upTo(sequenceNumber, &subSequenceNumber)
+	if err != nil {
+		return err
+	}
+	return pc.mark.checkpoint(indices, nil)
+}
+
+func (pc *partitionCheckpointer) CheckpointWithMetadata(sequenceNumber *string, metadata []byte) error {
+	if len(metadata) > MaxCheckpointMetadataSize {
+		return fmt.Errorf("checkpoint metadata of %d bytes exceeds the limit of %d bytes", len(metadata), MaxCheckpointMetadataSize)
+	}
+
+	indices, err := pc.upTo(sequenceNumber, nil)
+	if err != nil {
+		return err
+	}
+	return pc.mark.checkpoint(indices, metadata)
+}
+
+func (pc *partitionCheckpointer) CheckpointAsync(sequenceNumber *string) <-chan error {
+	indices, err := pc.upTo(sequenceNumber, nil)
+	if err != nil {
+		result := make(chan error, 1)
+		result <- err
+		close(result)
+		return result
+	}
+	return pc.mark.checkpointAsync(indices)
+}
+
+func (pc *partitionCheckpointer) PrepareCheckpoint(*string) (kcl.IPreparedCheckpointer, error) {
+	return nil, errUnorderedPrepareCheckpoint
+}
@@ -0,0 +1,250 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+)
+
+// sequenceRecorder is a checkpointer recording the checkpointed sequence numbers
+type sequenceRecorder struct {
+	mux       sync.Mutex
+	sequences []string
+}
+
+func (r *sequenceRecorder) record(sequenceNumber *string) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.sequences = append(r.sequences, aws.ToString(sequenceNumber))
+	return nil
+}
+
+func (r *sequenceRecorder) checkpoints() []string {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return append([]string(nil), r.sequences...)
+}
+
+func (r *sequenceRecorder) Checkpoint(sequenceNumber *string) error { return r.record(sequenceNumber) }
+
+func (r *sequenceRecorder) CheckpointWithSubSequence(sequenceNumber *string, _ int64) error {
+	return r.record(sequenceNumber)
+}
+
+func (r *sequenceRecorder) CheckpointWithMetadata(sequenceNumber *string, _ []byte) error {
+	return r.record(sequenceNumber)
+}
+
+func (r *sequenceRecorder) CheckpointAsync(sequenceNumber *string) <-chan error {
+	result := make(chan error, 1)
+	result <- r.record(sequenceNumber)
+	return result
+}
+
+func (r *sequenceRecorder) PrepareCheckpoint(*string) (kcl.IPreparedCheckpointer, error) {
+	return nil, nil
+}
+
+func keyedRecords(keys ...string) []kcl.UserRecord {
+	records := make([]kcl.UserRecord, len(keys))
+	for i, key := range keys {
+		records[i] = kcl.UserRecord{Record: types.Record{
+			SequenceNumber: aws.String(fmt.Sprintf("%d", i+1)),
+			PartitionKey:   aws.String(key),
+		}}
+	}
+	return records
+}
+
+func TestPartitionRecords(t *testing.T) {
+	records := keyedRecords("a", "b", "a", "c", "b", "a")
+	partitions := partitionRecords(records, 4)
+
+	seen := map[int]bool{}
+	partitionOf := map[string]int{}
+	for p, indices := range partitions {
+		assert.NotEmpty(t, indices)
+		for i, index := range indices {
+			seen[index] = true
+			// the records of a partition keep the order of the batch and a key is never split
+			if i > 0 {
+				assert.Less(t, indices[i-1], index)
+			}
+			key := aws.ToString(records[index].PartitionKey)
+			if previous, ok := partitionOf[key]; ok {
+				assert.Equal(t, previous, p)
+			}
+			partitionOf[key] = p
+		}
+	}
+	assert.Equal(t, len(records), len(seen))
+}
+
+func TestWatermarkCheckpoint(t *testing.T) {
+	recorder := &sequenceRecorder{}
+	mark := &watermark{checkpointer: recorder, records: keyedRecords("a", "b", "a", "b"), done: make([]bool, 4)}
+	a := &partitionCheckpointer{mark: mark, indices: []int{0, 2}}
+	b := &partitionCheckpointer{mark: mark, indices: []int{1, 3}}
+
+	// the records of b are blocked by the first record of a
+	assert.Nil(t, b.Checkpoint(aws.String("4")))
+	assert.Empty(t, recorder.checkpoints())
+
+	assert.Nil(t, a.Checkpoint(aws.String("1")))
+	assert.Equal(t, []string{"2"}, recorder.checkpoints())
+
+	assert.Nil(t, <-a.CheckpointAsync(aws.String("3")))
+	assert.Equal(t, []string{"2", "4"}, recorder.checkpoints())
+
+	// the records of other partitions cannot be checkpointed
+	assert.NotNil(t, a.Checkpoint(aws.String("2")))
+	assert.NotNil(t, a.Checkpoint(nil))
+	_, err := a.PrepareCheckpoint(aws.String("3"))
+	assert.ErrorIs(t, err, errUnorderedPrepareCheckpoint)
+}
+
+func TestWatermarkCheckpointSequenceOrder(t *testing.T) {
+	recorder := &sequenceRecorder{}
+	records := keyedRecords("a", "a", "a")
+	// the sequence numbers are ordered as integers, not as strings
+	for i, sequenceNumber := range []string{"9", "10", "11"} {
+		records[i].SequenceNumber = aws.String(sequenceNumber)
+	}
+	mark := &watermark{checkpointer: recorder, records: records, done: make([]bool, 3)}
+	a := &partitionCheckpointer{mark: mark, indices: []int{0, 1, 2}}
+
+	assert.Nil(t, a.Checkpoint(aws.String("10")))
+	assert.Equal(t, []string{"10"}, recorder.checkpoints())
+	// only the records of the batch are checkpointed
+	assert.NotNil(t, a.Checkpoint(aws.String("12")))
+	assert.NotNil(t, a.Checkpoint(aws.String("invalid")))
+}
+
+// concurrentProcessor checkpoints each record, the first batch waits until all the batches have been delivered
+type concurrentProcessor struct {
+	mux     sync.Mutex
+	calls   int
+	started chan struct{}
+	keys    map[string][]string
+}
+
+func (p *concurrentProcessor) Initialize(context.Context, *kcl.InitializationInput) {}
+
+func (p *concurrentProcessor) ProcessRecords(ctx context.Context, input *kcl.ProcessRecordsInput) error {
+	p.mux.Lock()
+	p.calls++
+	first := p.calls == 1
+	p.mux.Unlock()
+
+	if first {
+		select {
+		case <-p.started:
+		case <-time.After(5 * time.Second):
+			return fmt.Errorf("batches are not delivered concurrently")
+		}
+	} else {
+		p.started <- struct{}{}
+	}
+
+	for _, r := range input.UserRecords {
+		p.mux.Lock()
+		key := aws.ToString(r.PartitionKey)
+		p.keys[key] = append(p.keys[key], aws.ToString(r.SequenceNumber))
+		p.mux.Unlock()
+		if err := input.Checkpointer.Checkpoint(r.SequenceNumber); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *concurrentProcessor) Shutdown(context.Context, *kcl.ShutdownInput) {}
+
+func TestProcessRecordsUnordered(t *testing.T) {
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").WithUnorderedProcessing(2)
+	records := keyedRecords("a", "b", "a", "b", "a")
+	partitions := partitionRecords(records, 2)
+	if !assert.Equal(t, 2, len(partitions)) {
+		return
+	}
+
+	processor := &concurrentProcessor{started: make(chan struct{}, 1), keys: map[string][]string{}}
+	sc := &commonShardConsumer{kclConfig: kclConfig, recordProcessor: processor}
+	recorder := &sequenceRecorder{}
+
+	err := sc.processRecordsUnordered(context.Background(), &kcl.ProcessRecordsInput{
+		UserRecords:  records,
+		Checkpointer: recorder,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, processor.calls)
+
+	// the records of a key are processed in order, the checkpoints only advance to the end of the batch
+	assert.Equal(t, []string{"1", "3", "5"}, processor.keys["a"])
+	assert.Equal(t, []string{"2", "4"}, processor.keys["b"])
+	checkpoints := recorder.checkpoints()
+	assert.Equal(t, "5", checkpoints[len(checkpoints)-1])
+	for i := 1; i < len(checkpoints); i++ {
+		assert.Less(t, checkpoints[i-1], checkpoints[i])
+	}
+}
+
+// keyPanickingProcessor panics on the records of a partition key
+type keyPanickingProcessor struct {
+	key string
+}
+
+func (p *keyPanickingProcessor) Initialize(context.Context, *kcl.InitializationInput) {}
+
+func (p *keyPanickingProcessor) ProcessRecords(ctx context.Context, input *kcl.ProcessRecordsInput) error {
+	for _, r := range input.UserRecords {
+		if aws.ToString(r.PartitionKey) == p.key {
+			panic("bad record " + aws.ToString(r.SequenceNumber))
+		}
+	}
+	return nil
+}
+
+func (p *keyPanickingProcessor) Shutdown(context.Context, *kcl.ShutdownInput) {}
+
+func TestProcessRecordsUnorderedPanic(t *testing.T) {
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").WithUnorderedProcessing(2)
+	sc := &commonShardConsumer{kclConfig: kclConfig, recordProcessor: &keyPanickingProcessor{key: "b"}}
+
+	// the panic of a fanned out call fails the batch instead of crashing the worker
+	err := sc.processRecordsUnordered(context.Background(), &kcl.ProcessRecordsInput{
+		UserRecords:  keyedRecords("a", "b", "a"),
+		Checkpointer: &sequenceRecorder{},
+	})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "panic in ProcessRecords: bad record 2")
+	}
+}
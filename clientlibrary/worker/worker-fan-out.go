@@ -24,23 +24,23 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"math"
 	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/kinesis"
 	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
 )
 
 // fetchConsumerARNWithRetry tries to fetch consumer ARN. Retries 10 times with exponential backoff in case of an error
-func (w *Worker) fetchConsumerARNWithRetry() (string, error) {
+func (w *Worker) fetchConsumerARNWithRetry(streamName, streamARN string) (string, error) {
 	for retry := 0; ; retry++ {
-		consumerARN, err := w.fetchConsumerARN()
+		consumerARN, err := w.fetchConsumerARN(w.kc, streamName, streamARN)
 		if err == nil {
 			return consumerARN, nil
 		}
 		if retry < 10 {
-			sleepDuration := time.Duration(math.Exp2(float64(retry))*100) * time.Millisecond
-			w.kclConfig.Logger.Errorf("Could not get consumer ARN: %v, retrying after: %s", err, sleepDuration)
+			sleepDuration := w.kclConfig.RetryPolicy.Delay(retry + 1)
+			w.log.Errorf("Could not get consumer ARN: %v, retrying after: %s", err, sleepDuration)
 			time.Sleep(sleepDuration)
 			continue
 		}
@@ -50,22 +50,26 @@ func (w *Worker) fetchConsumerARNWithRetry() (string, error) {
 
 // fetchConsumerARN gets enhanced fan-out consumerARN.
 // Registers enhanced fan-out consumer if the consumer is not found
-func (w *Worker) fetchConsumerARN() (string, error) {
-	log := w.kclConfig.Logger
-	log.Debugf("Fetching stream consumer ARN")
+func (w *Worker) fetchConsumerARN(kc KinesisAPI, streamName, streamARN string) (string, error) {
+	log := w.log
+	log.Debugf("Fetching stream consumer ARN of stream %s", streamName)
 
-	streamDescription, err := w.kc.DescribeStream(context.TODO(), &kinesis.DescribeStreamInput{
-		StreamName: &w.kclConfig.StreamName,
-	})
+	// the stream only needs to be described if it is identified by its name
+	if streamARN == "" {
+		streamSummary, err := kc.DescribeStreamSummary(context.TODO(), &kinesis.DescribeStreamSummaryInput{
+			StreamName: &streamName,
+		})
 
-	if err != nil {
-		log.Errorf("Could not describe stream: %v", err)
-		return "", err
+		if err != nil {
+			log.Errorf("Could not describe stream: %v", err)
+			return "", err
+		}
+		streamARN = aws.ToString(streamSummary.StreamDescriptionSummary.StreamARN)
 	}
 
-	streamConsumerDescription, err := w.kc.DescribeStreamConsumer(context.TODO(), &kinesis.DescribeStreamConsumerInput{
+	streamConsumerDescription, err := kc.DescribeStreamConsumer(context.TODO(), &kinesis.DescribeStreamConsumerInput{
 		ConsumerName: &w.kclConfig.EnhancedFanOutConsumerName,
-		StreamARN:    streamDescription.StreamDescription.StreamARN,
+		StreamARN:    &streamARN,
 	})
 
 	if err == nil {
@@ -80,9 +84,9 @@ func (w *Worker) fetchConsumerARN() (string, error) {
 	var notFoundErr *types.ResourceNotFoundException
 	if errors.As(err, &notFoundErr) {
 		log.Infof("Enhanced fan-out consumer not found, registering new consumer with name: %s", w.kclConfig.EnhancedFanOutConsumerName)
-		out, err := w.kc.RegisterStreamConsumer(context.TODO(), &kinesis.RegisterStreamConsumerInput{
+		out, err := kc.RegisterStreamConsumer(context.TODO(), &kinesis.RegisterStreamConsumerInput{
 			ConsumerName: &w.kclConfig.EnhancedFanOutConsumerName,
-			StreamARN:    streamDescription.StreamDescription.StreamARN,
+			StreamARN:    &streamARN,
 		})
 		if err != nil {
 			log.Errorf("Could not register enhanced fan-out consumer: %v", err)
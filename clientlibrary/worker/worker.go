@@ -33,20 +33,27 @@ import (
 	"context"
 	"crypto/rand"
 	"errors"
+	"fmt"
 	"math/big"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/aws/retry"
-	awsConfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"go.opentelemetry.io/otel/trace"
 
 	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
 	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
 	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/leaseaudit"
 	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/metrics"
 	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+	"github.com/vmware/vmware-go-kcl-v2/logger"
 )
 
 // Worker is the high level class that Kinesis applications use to start processing data. It initializes and oversees
@@ -54,48 +61,199 @@ import (
 // the shards).
 type Worker struct {
 	streamName  string
+	streamARN   string
 	regionName  string
 	workerID    string
 	consumerARN string
 
-	processorFactory kcl.IRecordProcessorFactory
+	// consumerARNs are the enhanced fan-out consumers of the streams in multi-stream mode, by streamKey
+	consumerARNs map[string]string
+
+	processorFactory kcl.IRecordProcessorWithContextFactory
+	middlewares      []Middleware
 	kclConfig        *config.KinesisClientLibConfiguration
-	kc               *kinesis.Client
-	checkpointer     chk.Checkpointer
-	mService         metrics.MonitoringService
+	log              logger.Logger
+	kc               KinesisAPI
+	// configErr lists the invalid settings of the configuration, the worker fails to start with it
+	configErr error
+	// secondaryKc is the Kinesis client of the region of the secondary stream
+	secondaryKc   KinesisAPI
+	checkpointer  chk.Checkpointer
+	mService      metrics.MonitoringService
+	tracer        trace.Tracer
+	stateListener WorkerStateListener
+
+	// consumerStateListener is notified of the transitions of the shard consumers, none by default
+	consumerStateListener ShardConsumerStateListener
+
+	// settings can be changed by UpdateConfig while the worker is running
+	settings      *reloadableConfig
+	configUpdates <-chan ConfigUpdate
+
+	// interruptionWatchers shut down the worker once its host is about to be terminated
+	interruptionWatchers []InterruptionWatcher
 
 	stop      *chan struct{}
 	waitGroup *sync.WaitGroup
 	done      bool
+	// shutdownMux guards done, the worker can be shut down by the application and by an interruption watcher
+	shutdownMux sync.Mutex
+
+	// ctx is canceled once the worker shuts down
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// limiter is shared by the shard consumers to limit the processing rate of the worker
+	limiter *processingLimiter
+	// scheduler is shared by the shard consumers to bound the shards processed at the same time
+	scheduler *consumerScheduler
+	// getRecordsBudget is shared by the polling shard consumers to limit the GetRecords calls of the worker
+	getRecordsBudget *getRecordsBudget
+
+	// lag keeps the lag of the shards consumed by the worker
+	lag *lagTracker
+
+	// alarms evaluates the alarm thresholds of the consumed shards, it is nil unless a threshold is configured
+	alarms *alarmEvaluator
+	// hotShards reports the shards with the highest throughput, it is nil unless the report is enabled
+	hotShards *hotShardTracker
+
+	// shardEnd is notified by shard consumers reaching the end of a shard to lease its child shards immediately
+	shardEnd *shardEndNotifier
+	// shardSync paces the shard syncs and resumes the listings of the shards interrupted by throttling
+	shardSync *shardSyncState
+	// failover switches the worker between the primary and the secondary stream, the stream, its client and the
+	// shards are swapped under statusMux
+	failover *regionFailover
+
+	// deliveries count the deliveries of the failing batches across restarts of the shard consumers
+	deliveries *deliveryTracker
+
+	// rebalanceRequests pass the rebalances requested by the application to the event loop
+	rebalanceRequests chan *rebalanceRequest
 
 	randomSeed int64
 
 	shardStatus          map[string]*par.ShardStatus
 	shardStealInProgress bool
+
+	// shardClaims are the times the shards have been claimed at, the lease owners have until the handoff timeout
+	// to checkpoint and release the leases
+	shardClaims map[string]time.Time
+
+	// assignmentWaits are the times the worker started to wait for the leases of the shards assigned to other
+	// workers, the shards are leased after ShardAssignmentFallbackMillis
+	assignmentWaits map[string]time.Time
+
+	// leasesReclaimed is set once the leases held by the worker ID before the start have been reclaimed
+	leasesReclaimed bool
+
+	// runOnce stops a worker run by RunOnce once its shards are caught up, it is nil otherwise
+	runOnce *runOnceState
+
+	// cleanedLeases are the completed shards whose leases have been deleted
+	cleanedLeases map[string]bool
+
+	// listedShards are the shards of the streams listed by the last shard sync, skippedShards those of them which
+	// are not listed by the ListShardsFilter. The parents outside the filters of the worker are looked up in the
+	// lease table.
+	listedShards  map[string]bool
+	skippedShards map[string]bool
+
+	// consumers track the state of the shard consumers for Status
+	statusMux sync.RWMutex
+	consumers map[string]*consumerStatus
+	running   bool
+
+	// releasedShards are the times the leases have been released by ReleaseShard, guarded by statusMux
+	releasedShards map[string]time.Time
+
+	// pausedShards are closed once the shards paused by PauseShard are resumed, guarded by statusMux
+	pausedShards map[string]chan struct{}
+
+	// leases are the shards of the last iteration of the event loop for Leases, guarded by statusMux
+	leases []*par.ShardStatus
+
+	// debugServer serves the DebugHandler if a DebugServerAddress is configured
+	debugServer   *http.Server
+	debugListener net.Listener
 }
 
-// NewWorker constructs a Worker instance for processing Kinesis stream data.
+// NewWorker constructs a Worker instance for processing Kinesis stream data. The configuration is validated, Start
+// returns a config.ValidationErrors if it is not valid.
 func NewWorker(factory kcl.IRecordProcessorFactory, kclConfig *config.KinesisClientLibConfiguration) *Worker {
+	return NewWorkerWithContext(kcl.NewRecordProcessorFactoryAdapter(factory), kclConfig)
+}
+
+// New constructs a Worker instance for the given stream and application from functional options. The configuration
+// is validated first and a config.ValidationErrors is returned if it is not valid.
+func New(streamName, applicationName string, factory kcl.IRecordProcessorFactory, opts ...config.Option) (*Worker, error) {
+	kclConfig, err := config.New(streamName, applicationName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return NewWorker(factory, kclConfig), nil
+}
+
+// NewWorkerV2 constructs a Worker instance for processing Kinesis stream data by record processors receiving the
+// batches of records enriched with their metadata.
+func NewWorkerV2(factory kcl.IRecordProcessorV2Factory, kclConfig *config.KinesisClientLibConfiguration) *Worker {
+	return NewWorkerWithContext(kcl.NewRecordProcessorV2FactoryAdapter(factory), kclConfig)
+}
+
+// NewWorkerWithContext constructs a Worker instance for processing Kinesis stream data with context-aware
+// record processors.
+func NewWorkerWithContext(factory kcl.IRecordProcessorWithContextFactory, kclConfig *config.KinesisClientLibConfiguration) *Worker {
 	mService := kclConfig.MonitoringService
 	if mService == nil {
 		// Replaces nil with noop monitor service (not emitting any metrics).
 		mService = metrics.NoopMonitoringService{}
+	} else if len(kclConfig.DisabledMetrics) > 0 || kclConfig.ShardMetricsSampleRatio < 1 {
+		mService = metrics.NewFilteredMonitoringService(mService, kclConfig.DisabledMetrics, kclConfig.ShardMetricsSampleRatio)
+	}
+
+	tracerProvider := kclConfig.TracerProvider
+	if tracerProvider == nil {
+		// Replaces nil with noop tracer provider (not recording any spans).
+		tracerProvider = trace.NewNoopTracerProvider()
+	}
+
+	// the stream of each shard is logged by its consumer in multi-stream mode
+	fields := logger.Fields{"workerID": kclConfig.WorkerID}
+	if !kclConfig.IsMultiStreamMode() {
+		fields["stream"] = kclConfig.StreamName
 	}
 
 	return &Worker{
 		streamName:       kclConfig.StreamName,
+		streamARN:        kclConfig.StreamARN,
 		regionName:       kclConfig.RegionName,
 		workerID:         kclConfig.WorkerID,
 		processorFactory: factory,
 		kclConfig:        kclConfig,
+		configErr:        kclConfig.Validate(),
+		log:              kclConfig.Logger.WithFields(fields),
 		mService:         mService,
+		tracer:           tracerProvider.Tracer(tracerName),
+		stateListener:    NoopWorkerStateListener{},
+		settings:         newReloadableConfig(kclConfig),
 		done:             false,
 		randomSeed:       time.Now().UTC().UnixNano(),
+		consumers:        make(map[string]*consumerStatus),
+		cleanedLeases:    make(map[string]bool),
+		assignmentWaits:  make(map[string]time.Time),
+		shardSync:        newShardSyncState(kclConfig),
+		failover:         newRegionFailover(kclConfig),
+
+		deliveries:        newDeliveryTracker(),
+		rebalanceRequests: make(chan *rebalanceRequest),
+		releasedShards:    make(map[string]time.Time),
+		pausedShards:      make(map[string]chan struct{}),
 	}
 }
 
 // WithKinesis is used to provide Kinesis service for either custom implementation or unit testing.
-func (w *Worker) WithKinesis(svc *kinesis.Client) *Worker {
+func (w *Worker) WithKinesis(svc KinesisAPI) *Worker {
 	w.kc = svc
 	return w
 }
@@ -107,9 +265,16 @@ func (w *Worker) WithCheckpointer(checker chk.Checkpointer) *Worker {
 	return w
 }
 
+// WithDynamoDB is used to provide the DynamoDB client of the default DynamoDB based checkpointer, e.g. to use an
+// already configured client.
+func (w *Worker) WithDynamoDB(svc chk.DynamoDBAPI) *Worker {
+	w.checkpointer = chk.NewDynamoCheckpoint(w.kclConfig).WithDynamoDB(svc)
+	return w
+}
+
 // Start Run starts consuming data from the stream, and pass it to the application record processors.
 func (w *Worker) Start() error {
-	log := w.kclConfig.Logger
+	log := w.log
 	if err := w.initialize(); err != nil {
 		log.Errorf("Failed to initialize Worker: %+v", err)
 		return err
@@ -122,6 +287,11 @@ func (w *Worker) Start() error {
 		return err
 	}
 
+	if err := w.startDebugServer(); err != nil {
+		log.Errorf("Failed to start debug server: %+v", err)
+		return err
+	}
+
 	log.Infof("Starting worker event loop.")
 	w.waitGroup.Add(1)
 	go func() {
@@ -129,53 +299,111 @@ func (w *Worker) Start() error {
 		// entering event loop
 		w.eventLoop()
 	}()
+	if w.configUpdates != nil {
+		go w.watchConfigUpdates()
+	}
+	if len(w.interruptionWatchers) > 0 {
+		go w.watchInterruptions()
+	}
+	if w.alarms != nil {
+		go w.evaluateAlarms()
+	}
+	if w.hotShards != nil {
+		go w.reportHotShards()
+	}
+	w.setRunning(true)
+	w.stateListener.WorkerStarted(w.workerID)
 	return nil
 }
 
-// Shutdown signals worker to shut down. Worker will try initiating shutdown of all record processors.
+// ErrShutdownTimeout is returned by ShutdownWithContext if shard consumers did not drain before the context is done
+type ErrShutdownTimeout struct {
+	// Shards are the lease keys of the shards which did not drain in time
+	Shards []string
+}
+
+func (e ErrShutdownTimeout) Error() string {
+	return fmt.Sprintf("shard consumers did not shut down in time: %s", strings.Join(e.Shards, ", "))
+}
+
+// Shutdown signals worker to shut down. Worker will try initiating shutdown of all record processors and waits
+// for them at most ShutdownGraceMillis.
 func (w *Worker) Shutdown() {
-	log := w.kclConfig.Logger
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(w.kclConfig.ShutdownGraceMillis)*time.Millisecond)
+	defer cancel()
+
+	if err := w.ShutdownWithContext(ctx); err != nil {
+		w.log.Warnf("%v, the remaining leases will expire.", err)
+	}
+}
+
+// ShutdownWithContext stops fetching new records and waits until the record processors have finished their
+// in-flight batches, have been shut down with a final checkpoint and the leases have been released. The
+// record processors which have not drained once ctx is done are canceled and an ErrShutdownTimeout listing
+// their shards is returned.
+func (w *Worker) ShutdownWithContext(ctx context.Context) error {
+	log := w.log
 	log.Infof("Worker shutdown in requested.")
 
+	w.shutdownMux.Lock()
 	if w.done || w.stop == nil {
-		return
+		w.shutdownMux.Unlock()
+		return nil
 	}
 
 	close(*w.stop)
 	w.done = true
-	w.waitGroup.Wait()
+	w.shutdownMux.Unlock()
+	w.setRunning(false)
+
+	// Wait for the shard consumers to shut down their record processors and release their leases
+	consumersDone := make(chan struct{})
+	go func() {
+		w.waitGroup.Wait()
+		close(consumersDone)
+	}()
 
+	var err error
+	select {
+	case <-consumersDone:
+	case <-ctx.Done():
+		err = ErrShutdownTimeout{Shards: w.drainingShards()}
+	}
+
+	// the context of the record processors is canceled only once they had the chance to finish their batches
+	w.cancel()
+	w.stopDebugServer()
 	w.mService.Shutdown()
+	w.stateListener.WorkerStopped(w.workerID)
 	log.Infof("Worker loop is complete. Exiting from worker.")
+	return err
 }
 
 // initialize
 func (w *Worker) initialize() error {
-	log := w.kclConfig.Logger
+	log := w.log
 	log.Infof("Worker initialization in progress...")
 
+	if w.configErr != nil {
+		return w.configErr
+	}
+
+	// the monitoring service is initialized first, the throttled calls of the AWS clients are counted by it
+	namespace := w.kclConfig.MetricsNamespace
+	if namespace == "" {
+		namespace = w.kclConfig.ApplicationName
+	}
+	if err := w.mService.Init(namespace, w.streamName, w.workerID); err != nil {
+		log.Errorf("Failed to start monitoring service: %+v", err)
+	}
+
 	// Create default Kinesis client
 	if w.kc == nil {
 		// create session for Kinesis
 		log.Infof("Creating Kinesis client")
 
-		resolver := aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
-			return aws.Endpoint{
-				PartitionID:   "aws",
-				URL:           w.kclConfig.KinesisEndpoint,
-				SigningRegion: w.regionName,
-			}, nil
-		})
-
-		cfg, err := awsConfig.LoadDefaultConfig(
-			context.TODO(),
-			awsConfig.WithRegion(w.regionName),
-			awsConfig.WithCredentialsProvider(w.kclConfig.KinesisCredentials),
-			awsConfig.WithEndpointResolverWithOptions(resolver),
-			awsConfig.WithRetryer(func() aws.Retryer {
-				return retry.AddWithMaxBackoffDelay(retry.NewStandard(), retry.DefaultMaxBackoff)
-			}),
-		)
+		cfg, err := w.kclConfig.LoadAWSConfig(kinesis.ServiceID, w.kclConfig.KinesisEndpoint, w.kclConfig.KinesisCredentials,
+			w.kclConfig.KinesisRoleARN, w.mService)
 
 		if err != nil {
 			// no need to move forward
@@ -186,20 +414,33 @@ func (w *Worker) initialize() error {
 		log.Infof("Use custom Kinesis service.")
 	}
 
-	// Create default dynamodb based checkpointer implementation
+	// Create default checkpointer implementation for the configured backend, the pinned shards are leased by the
+	// worker alone unless their leases are coordinated
 	if w.checkpointer == nil {
-		log.Infof("Creating DynamoDB based checkpointer")
-		w.checkpointer = chk.NewDynamoCheckpoint(w.kclConfig)
+		if w.isPinnedWithoutCoordination() {
+			w.checkpointer = chk.NewMemoryCheckpoint(w.kclConfig)
+		} else {
+			w.checkpointer = chk.NewCheckpointer(w.kclConfig)
+		}
+		log.Infof("Created %T checkpointer", w.checkpointer)
 	} else {
 		log.Infof("Use custom checkpointer implementation.")
 	}
+	if w.kclConfig.LeaseCoordinator != nil {
+		log.Infof("Shards are assigned by %T lease coordinator", w.kclConfig.LeaseCoordinator)
+		w.checkpointer = newCoordinatedCheckpointer(w.checkpointer, w.kclConfig.LeaseCoordinator, w.workerID,
+			time.Duration(w.kclConfig.FailoverTimeMillis)*time.Millisecond)
+	}
+	w.checkpointer = newMonitoredCheckpointer(w.checkpointer, w.mService)
 
-	if w.kclConfig.EnableEnhancedFanOutConsumer {
+	// the consumers of the streams are fetched while syncing shards in multi-stream mode
+	w.consumerARNs = make(map[string]string)
+	if w.kclConfig.EnableEnhancedFanOutConsumer && !w.kclConfig.IsMultiStreamMode() {
 		log.Debugf("Enhanced fan-out is enabled")
 		w.consumerARN = w.kclConfig.EnhancedFanOutConsumerARN
 		if w.consumerARN == "" {
 			var err error
-			w.consumerARN, err = w.fetchConsumerARNWithRetry()
+			w.consumerARN, err = w.fetchConsumerARNWithRetry(w.streamName, w.streamARN)
 			if err != nil {
 				log.Errorf("Failed to fetch consumer ARN for: %s, %v", w.kclConfig.EnhancedFanOutConsumerName, err)
 				return err
@@ -207,11 +448,6 @@ func (w *Worker) initialize() error {
 		}
 	}
 
-	err := w.mService.Init(w.kclConfig.ApplicationName, w.streamName, w.workerID)
-	if err != nil {
-		log.Errorf("Failed to start monitoring service: %+v", err)
-	}
-
 	log.Infof("Initializing Checkpointer")
 	if err := w.checkpointer.Init(); err != nil {
 		log.Errorf("Failed to start Checkpointer: %+v", err)
@@ -219,9 +455,19 @@ func (w *Worker) initialize() error {
 	}
 
 	w.shardStatus = make(map[string]*par.ShardStatus)
+	w.shardClaims = make(map[string]time.Time)
 
 	stopChan := make(chan struct{})
 	w.stop = &stopChan
+	w.ctx, w.cancel = context.WithCancel(context.Background())
+
+	w.shardEnd = newShardEndNotifier()
+	w.lag = newLagTracker(w.kclConfig, w.mService)
+	w.alarms = newAlarmEvaluator(w.kclConfig, w.mService)
+	w.hotShards = newHotShardTracker(w.kclConfig, w.log)
+	w.limiter = newProcessingLimiter(w.kclConfig.MaxRecordsPerSecond, w.kclConfig.MaxInFlightBytes)
+	w.scheduler = newConsumerScheduler(w.kclConfig.MaxConcurrentShardConsumers)
+	w.getRecordsBudget = newGetRecordsBudget(w.kclConfig.MaxGetRecordsCallsPerSecond)
 
 	w.waitGroup = &sync.WaitGroup{}
 
@@ -232,38 +478,67 @@ func (w *Worker) initialize() error {
 
 // newShardConsumer creates shard consumer for the specified shard
 func (w *Worker) newShardConsumer(shard *par.ShardStatus) shardConsumer {
+	fields := logger.Fields{"shardID": shard.GetShardID()}
+	if shard.StreamName != "" {
+		fields["stream"] = shard.StreamName
+	}
+
 	common := commonShardConsumer{
 		shard:           shard,
+		log:             w.log.WithFields(fields),
 		kc:              w.kc,
 		checkpointer:    w.checkpointer,
-		recordProcessor: w.processorFactory.CreateProcessor(),
+		recordProcessor: w.createProcessor(),
 		kclConfig:       w.kclConfig,
 		mService:        w.mService,
+		tracer:          w.tracer,
+		ctx:             w.ctx,
+		limiter:         w.limiter,
+		scheduler:       w.scheduler,
+		lag:             w.lag,
+		shardEnd:        w.shardEnd,
+		status:          w.consumerStatus(shard),
+		stateListener:   w.stateListener,
+		settings:        w.settings,
+		deliveries:      w.deliveries,
 	}
 	if w.kclConfig.EnableEnhancedFanOutConsumer {
-		w.kclConfig.Logger.Infof("Start enhanced fan-out shard consumer for shard: %v", shard.ID)
+		consumerARN := w.consumerARN
+		if shard.StreamName != "" {
+			consumerARN = w.consumerARNs[streamKey(shard.StreamName, shard.StreamARN)]
+		}
+		w.log.Infof("Start enhanced fan-out shard consumer for shard: %v", shard.ID)
 		return &FanOutShardConsumer{
 			commonShardConsumer: common,
-			consumerARN:         w.consumerARN,
+			consumerARN:         consumerARN,
 			consumerID:          w.workerID,
 			stop:                w.stop,
 		}
 	}
-	w.kclConfig.Logger.Infof("Start polling shard consumer for shard: %v", shard.ID)
+	streamName, streamARN := w.streamName, w.streamARN
+	if shard.StreamName != "" {
+		streamName, streamARN = shard.StreamName, shard.StreamARN
+	}
+	w.log.Infof("Start polling shard consumer for shard: %v", shard.ID)
 	return &PollingShardConsumer{
 		commonShardConsumer: common,
-		streamName:          w.streamName,
+		streamName:          streamName,
+		streamARN:           streamARN,
 		consumerID:          w.workerID,
 		stop:                w.stop,
 		mService:            w.mService,
+		getRecordsBudget:    w.getRecordsBudget,
 	}
 }
 
 // eventLoop
 func (w *Worker) eventLoop() {
-	log := w.kclConfig.Logger
+	log := w.log
 
 	var foundShards int
+	lastLeaseCleanup := time.Now()
+	// the shards are not listed when the child shards of all closed shards are known
+	syncShards := true
 	for {
 		// Add [-50%, +50%] random jitter to ShardSyncIntervalMillis. When multiple workers
 		// starts at the same time, this decreases the probability of them calling
@@ -272,110 +547,303 @@ func (w *Worker) eventLoop() {
 		rnd, _ := rand.Int(rand.Reader, big.NewInt(int64(w.kclConfig.ShardSyncIntervalMillis)))
 		shardSyncSleep := w.kclConfig.ShardSyncIntervalMillis/2 + int(rnd.Int64())
 
-		err := w.syncShard()
-		if err != nil {
-			log.Errorf("Error syncing shards: %+v, Retrying in %d ms...", err, shardSyncSleep)
-			time.Sleep(time.Duration(shardSyncSleep) * time.Millisecond)
-			continue
+		if syncShards {
+			if err := w.syncFailover(); err != nil {
+				log.Errorf("Error syncing the region failover: %+v", err)
+			}
+			err := w.syncShard()
+			w.shardSync.synced(err)
+			if w.failover.synced(err, time.Now()) {
+				if err := w.failOver(); err != nil {
+					log.Errorf("Failed to fail over to stream %s: %+v", w.kclConfig.SecondaryStreamName, err)
+				} else {
+					continue
+				}
+			}
+			if err != nil {
+				shardSyncSleep = w.shardSync.nextSync(shardSyncSleep)
+				log.Errorf("Error syncing shards: %+v, Retrying in %d ms...", err, shardSyncSleep)
+				time.Sleep(time.Duration(shardSyncSleep) * time.Millisecond)
+				continue
+			}
+
+			shardIDs := make([]string, 0, len(w.shardStatus))
+			for id := range w.shardStatus {
+				shardIDs = append(shardIDs, id)
+			}
+			sort.Strings(shardIDs)
+			w.stateListener.ShardSyncCompleted(shardIDs)
 		}
+		syncShards = true
+		// the shards are synced rapidly for a while after the end of a shard
+		shardSyncSleep = w.shardSync.nextSync(shardSyncSleep)
 
 		if foundShards == 0 || foundShards != len(w.shardStatus) {
 			foundShards = len(w.shardStatus)
 			log.Infof("Found %d shards", foundShards)
 		}
 
-		// Count the number of leases held by this worker excluding the processed shard
-		counter := 0
-		for _, shard := range w.shardStatus {
-			if shard.GetLeaseOwner() == w.workerID && shard.GetCheckpoint() != chk.ShardEnd {
-				counter++
+		// the leases are acquired and their consumers started before a shutdown stops the consumers, so that a
+		// lease released by a consumer shutting down is not acquired again
+		w.shutdownMux.Lock()
+		if w.done {
+			w.shutdownMux.Unlock()
+			log.Infof("Shutting down...")
+			return
+		}
+		acquired := w.acquireLeases()
+		for _, shard := range acquired {
+			// log metrics on got lease
+			w.mService.LeaseGained(shard.ID)
+			w.stateListener.LeaseAcquired(shard.ID)
+			w.waitGroup.Add(1)
+			go func(shard *par.ShardStatus) {
+				defer w.waitGroup.Done()
+				w.runShardConsumer(shard)
+			}(shard)
+		}
+		w.shutdownMux.Unlock()
+
+		if w.kclConfig.EnableLeaseStealing {
+			if err := w.rebalance(); err != nil {
+				log.Warnf("Error in rebalance: %+v", err)
 			}
 		}
 
-		// max number of lease has not been reached yet
-		if counter < w.kclConfig.MaxLeasesForWorker {
-			for _, shard := range w.shardStatus {
-				// already owner of the shard
-				if shard.GetLeaseOwner() == w.workerID {
-					continue
-				}
+		w.snapshotLeases()
+		w.checkRunOnce(len(acquired))
+
+		if w.kclConfig.CleanupLeasesUponShardCompletion &&
+			time.Since(lastLeaseCleanup) >= time.Duration(w.kclConfig.LeaseCleanupIntervalMillis)*time.Millisecond {
+			w.cleanupLeases()
+			lastLeaseCleanup = time.Now()
+		}
+
+		select {
+		case <-*w.stop:
+			log.Infof("Shutting down...")
+			return
+		case <-w.shardEnd.signal:
+			w.shardSync.shardEnded()
+			// child shards of a closed shard can be processed now
+			if w.addChildShards(w.shardEnd.take()) {
+				log.Infof("Shard end reached, leasing child shards...")
+				syncShards = false
+			} else {
+				log.Infof("Shard end reached, syncing shards...")
+			}
+		case req := <-w.rebalanceRequests:
+			w.handleRebalance(req)
+			syncShards = false
+		case <-time.After(time.Duration(shardSyncSleep) * time.Millisecond):
+			log.Debugf("Waited %d ms to sync shards...", shardSyncSleep)
+		}
+	}
+}
+
+// isParentShardsCompleted returns true if all parents of the shard are checkpointed at SHARD_END. A parent shard
+// which no longer exists in the stream has expired and is regarded as completed. A parent shard outside the filters
+// of the worker is consumed by other workers, it is completed once its lease is checkpointed at SHARD_END. The
+// parents of a pinned shard which are not pinned are completed as well unless the leases are coordinated, since no
+// worker shares the leases kept in memory.
+func (w *Worker) isParentShardsCompleted(shard *par.ShardStatus) (bool, error) {
+	for _, parentID := range shard.GetParentShardIds() {
+		parent, consumed := w.shardStatus[parentID]
+		if !consumed {
+			if !w.listedShards[parentID] || w.isPinnedWithoutCoordination() {
+				continue
+			}
+			parent = &par.ShardStatus{ID: parentID, Mux: &sync.RWMutex{}}
+		}
 
-				err := w.checkpointer.FetchCheckpoint(shard)
+		if parent.GetCheckpoint() != chk.ShardEnd {
+			err := w.checkpointer.FetchCheckpoint(parent)
+			if err != nil && !errors.Is(err, chk.ErrSequenceIDNotFound) {
+				return false, err
+			}
+
+			// the lease of the completed parent shard may have been deleted by the lease cleanup
+			if errors.Is(err, chk.ErrSequenceIDNotFound) {
+				cleanedUp, err := w.isLeaseCleanedUp(parent)
 				if err != nil {
-					// checkpoint may not exist yet is not an error condition.
-					if err != chk.ErrSequenceIDNotFound {
-						log.Warnf("Couldn't fetch checkpoint: %+v", err)
-						// move on to next shard
-						continue
-					}
+					return false, err
+				}
+				// a parent shard skipped by the ListShardsFilter without lease is not consumed by any worker
+				if !cleanedUp && !consumed && w.skippedShards[parentID] {
+					continue
 				}
+			}
+		}
+
+		if parent.GetCheckpoint() != chk.ShardEnd {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// acquireLeases acquires the leases of shards which are available for processing by this worker and returns
+// the shards whose leases have been acquired.
+func (w *Worker) acquireLeases() []*par.ShardStatus {
+	log := w.log
+
+	// the leases held by the worker ID before the start are reclaimed at once by the first acquisition
+	var reclaimed []*par.ShardStatus
+	if w.kclConfig.ReclaimLeasesOnStartup && !w.leasesReclaimed {
+		reclaimed = w.reclaimLeases()
+		w.leasesReclaimed = true
+	}
+
+	// Count the number of leases held by this worker excluding the processed shard
+	counter := 0
+	for _, shard := range w.shardStatus {
+		if shard.GetLeaseOwner() == w.workerID && shard.GetCheckpoint() != chk.ShardEnd {
+			counter++
+		}
+	}
 
-				// The shard is closed and we have processed all records
-				if shard.GetCheckpoint() == chk.ShardEnd {
+	// no more than MaxLeasesToAcquireAtOneTime leases are acquired at once, so that the leases spread over
+	// the workers starting at the same time
+	toAcquire := w.settings.getMaxLeasesForWorker() - counter
+	if toAcquire > w.kclConfig.MaxLeasesToAcquireAtOneTime {
+		toAcquire = w.kclConfig.MaxLeasesToAcquireAtOneTime
+	}
+
+	var acquired []*par.ShardStatus
+	// max number of lease has not been reached yet
+	if toAcquire > 0 {
+		for _, shard := range w.shardStatus {
+			// already owner of the shard, or released on request of the application
+			if shard.GetLeaseOwner() == w.workerID || w.isReleased(shard.ID) {
+				continue
+			}
+
+			err := w.checkpointer.FetchCheckpoint(shard)
+			if err != nil {
+				// checkpoint may not exist yet is not an error condition.
+				if !errors.Is(err, chk.ErrSequenceIDNotFound) {
+					log.Warnf("Couldn't fetch checkpoint: %+v", err)
+					// move on to next shard
 					continue
 				}
 
-				var stealShard bool
-				if w.kclConfig.EnableLeaseStealing && shard.ClaimRequest != "" {
-					upcomingStealingInterval := time.Now().UTC().Add(time.Duration(w.kclConfig.LeaseStealingIntervalMillis) * time.Millisecond)
-					if shard.GetLeaseTimeout().Before(upcomingStealingInterval) && !shard.IsClaimRequestExpired(w.kclConfig) {
-						if shard.ClaimRequest == w.workerID {
-							stealShard = true
-							log.Debugf("Stealing shard: %s", shard.ID)
-						} else {
-							log.Debugf("Shard being stolen: %s", shard.ID)
-							continue
-						}
+				// the lease of a completed shard may have been deleted by the lease cleanup
+				if cleaned, err := w.isLeaseCleanedUp(shard); err != nil || cleaned {
+					if err != nil {
+						log.Warnf("Couldn't fetch checkpoint of child shards of %s: %+v", shard.ID, err)
 					}
+					continue
 				}
+			}
 
-				err = w.checkpointer.GetLease(shard, w.workerID)
+			// The shard is closed and we have processed all records
+			if shard.GetCheckpoint() == chk.ShardEnd {
+				continue
+			}
+
+			// Records of a child shard can only be processed once its parents have been processed completely
+			if completed, err := w.isParentShardsCompleted(shard); err != nil || !completed {
 				if err != nil {
-					// cannot get lease on the shard
-					if !errors.As(err, &chk.ErrLeaseNotAcquired{}) {
-						log.Errorf("Cannot get lease: %+v", err)
+					log.Warnf("Couldn't fetch checkpoint of parent shards of %s: %+v", shard.ID, err)
+				} else {
+					log.Debugf("Parent shards of %s have not been processed completely yet", shard.ID)
+				}
+				continue
+			}
+
+			// the shards pre-assigned to other workers are left to them, the shards pre-assigned to this worker are
+			// claimed back from the workers which have taken them over
+			if !w.isLeasableByAssignment(shard) || w.claimAssignedShard(shard) {
+				continue
+			}
+
+			var stealShard bool
+			if claimRequest := shard.GetClaimRequest(); w.kclConfig.EnableLeaseStealing && claimRequest != "" {
+				upcomingStealingInterval := time.Now().Add(time.Duration(w.kclConfig.LeaseStealingIntervalMillis) * time.Millisecond)
+				if w.isLeaseExpired(shard, upcomingStealingInterval) && !shard.IsClaimRequestExpired(w.kclConfig) {
+					if claimRequest == w.workerID {
+						// the lease owner checkpoints and releases the lease unless the handoff times out
+						if !w.isHandedOff(shard) {
+							log.Debugf("Waiting for shard %s to be handed off", shard.ID)
+							continue
+						}
+						stealShard = true
+						log.Debugf("Stealing shard: %s", shard.ID)
+					} else {
+						log.Debugf("Shard being stolen: %s", shard.ID)
+						continue
 					}
-					continue
 				}
+			}
 
-				if stealShard {
-					log.Debugf("Successfully stole shard: %+v", shard.ID)
-					w.shardStealInProgress = false
+			// the owner fetched with the checkpoint, GetLease fails if the lease has changed hands since
+			previousOwner := shard.GetLeaseOwner()
+			err = w.checkpointer.GetLease(shard, w.workerID)
+			if err != nil {
+				// cannot get lease on the shard
+				if !errors.As(err, &chk.ErrLeaseNotAcquired{}) {
+					log.Errorf("Cannot get lease: %+v", err)
 				}
+				continue
+			}
+			recordLeaseEvent(w.kclConfig, log, acquisitionEvent(previousOwner, w.workerID, stealShard), shard, previousOwner)
 
-				// log metrics on got lease
-				w.mService.LeaseGained(shard.ID)
-				w.waitGroup.Add(1)
-				go func(shard *par.ShardStatus) {
-					defer w.waitGroup.Done()
-					if err := w.newShardConsumer(shard).getRecords(); err != nil {
-						log.Errorf("Error in getRecords: %+v", err)
-					}
-				}(shard)
-				// exit from for loop and not to grab more shard for now.
+			if stealShard {
+				log.Debugf("Successfully stole shard: %+v", shard.ID)
+				w.shardStealInProgress = false
+				delete(w.shardClaims, shard.ID)
+			}
+
+			acquired = append(acquired, shard)
+			// exit from for loop and not to grab more shards for now.
+			if len(acquired) >= toAcquire {
 				break
 			}
 		}
+	}
 
-		if w.kclConfig.EnableLeaseStealing {
-			err = w.rebalance()
-			if err != nil {
-				log.Warnf("Error in rebalance: %+v", err)
-			}
+	return append(reclaimed, acquired...)
+}
+
+// reclaimLeases acquires the leases of the shards which are still held by the worker ID, e.g. by this worker before
+// a restart with a stable worker ID, regardless of MaxLeasesToAcquireAtOneTime. The lease owners and checkpoints
+// are read by one scan of the lease table.
+func (w *Worker) reclaimLeases() []*par.ShardStatus {
+	log := w.log
+
+	// the shards without lease owner are acquired afterwards
+	if _, err := w.checkpointer.ListActiveWorkers(w.shardStatus); err != nil && !errors.Is(err, chk.ErrShardNotAssigned) {
+		log.Warnf("Couldn't list the leases to reclaim: %+v", err)
+		return nil
+	}
+
+	var reclaimed []*par.ShardStatus
+	for _, shard := range w.shardStatus {
+		if len(reclaimed) >= w.settings.getMaxLeasesForWorker() {
+			break
 		}
 
-		select {
-		case <-*w.stop:
-			log.Infof("Shutting down...")
-			return
-		case <-time.After(time.Duration(shardSyncSleep) * time.Millisecond):
-			log.Debugf("Waited %d ms to sync shards...", shardSyncSleep)
+		if shard.GetLeaseOwner() != w.workerID || shard.GetCheckpoint() == chk.ShardEnd {
+			continue
 		}
+
+		if err := w.checkpointer.GetLease(shard, w.workerID); err != nil {
+			log.Warnf("Cannot reclaim lease of shard %s: %+v", shard.ID, err)
+			shard.SetLeaseOwner("")
+			continue
+		}
+
+		log.Infof("Reclaimed lease of shard %s", shard.ID)
+		recordLeaseEvent(w.kclConfig, log, leaseaudit.LeaseAcquired, shard, "")
+		reclaimed = append(reclaimed, shard)
 	}
+
+	return reclaimed
 }
 
 func (w *Worker) rebalance() error {
-	log := w.kclConfig.Logger
+	log := w.log
 
 	workers, err := w.checkpointer.ListActiveWorkers(w.shardStatus)
 	if err != nil {
@@ -383,145 +851,467 @@ func (w *Worker) rebalance() error {
 		return err
 	}
 
-	// Only attempt to steal one shard at time, to allow for linear convergence
+	// Only attempt to steal again once the claimed shards have been handed over, to allow for linear convergence
 	if w.shardStealInProgress {
-		shardInfo := make(map[string]bool)
-		err := w.getShardIDs("", shardInfo)
-		if err != nil {
-			return err
-		}
 		for _, shard := range w.shardStatus {
-			if shard.ClaimRequest != "" && shard.ClaimRequest == w.workerID {
+			if shard.GetClaimRequest() == w.workerID && !shard.IsClaimRequestExpired(w.kclConfig) {
 				log.Debugf("Steal in progress. workerID: %s", w.workerID)
 				return nil
 			}
-			// Our shard steal was stomped on by a Checkpoint.
-			// We could deal with that, but instead just try again
-			w.shardStealInProgress = false
 		}
+		// Our shard steal was stomped on by a Checkpoint.
+		// We could deal with that, but instead just try again
+		w.shardStealInProgress = false
+		w.shardClaims = make(map[string]time.Time)
+	}
+
+	workerSteal, numLeasesToSteal := computeLeasesToSteal(workers, w.workerID, w.settings.getMaxLeasesForWorker(), w.kclConfig.MaxLeasesToStealAtOneTime)
+	if numLeasesToSteal == 0 {
+		log.Debugf("Balanced shard allocation, not stealing any shards. workerID: %s", w.workerID)
+		return nil
 	}
 
+	// Steal random shards from the worker with the most shards, except those pre-assigned to other workers
+	var candidates []*par.ShardStatus
+	for _, shard := range workers[workerSteal] {
+		if assigned := w.assignedWorker(shard); assigned == "" || assigned == w.workerID {
+			candidates = append(candidates, shard)
+		}
+	}
+	if numLeasesToSteal > len(candidates) {
+		numLeasesToSteal = len(candidates)
+	}
+	for i := 0; i < numLeasesToSteal; i++ {
+		rnd, _ := rand.Int(rand.Reader, big.NewInt(int64(len(candidates))))
+		randIndex := int(rnd.Int64())
+		shardToSteal := candidates[randIndex]
+		candidates = append(candidates[:randIndex], candidates[randIndex+1:]...)
+
+		log.Debugf("Stealing shard %s from %s", shardToSteal.ID, workerSteal)
+		if err := w.checkpointer.ClaimShard(w.shardStatus[shardToSteal.ID], w.workerID); err != nil {
+			return err
+		}
+		w.shardStealInProgress = true
+		w.shardClaims[shardToSteal.ID] = time.Now()
+	}
+	return nil
+}
+
+// isHandedOff returns true if the owner of a shard claimed by the worker has released the lease, or if the
+// handoff has timed out. The lease is taken over once it has expired in that case.
+func (w *Worker) isHandedOff(shard *par.ShardStatus) bool {
+	claimedAt, ok := w.shardClaims[shard.ID]
+	if !ok || time.Since(claimedAt) >= time.Duration(w.kclConfig.LeaseStealingHandoffTimeoutMillis)*time.Millisecond {
+		return true
+	}
+
+	owner, err := w.checkpointer.GetLeaseOwner(shard.ID)
+	if err == chk.NoLeaseOwnerErr {
+		return true
+	}
+	if err != nil {
+		w.log.Warnf("Couldn't fetch the lease owner of shard %s: %+v", shard.ID, err)
+	}
+	return owner == ""
+}
+
+// isLeaseExpired tells whether the lease of the shard has expired by the time, as observed by the checkpointer if it
+// implements chk.LeaseExpiryChecker and from the lease timeout of the shard otherwise
+func (w *Worker) isLeaseExpired(shard *par.ShardStatus, at time.Time) bool {
+	if checker, ok := unwrapCheckpointer(w.checkpointer).(chk.LeaseExpiryChecker); ok {
+		return checker.IsLeaseExpired(shard, at)
+	}
+	return !shard.GetLeaseTimeout().After(at)
+}
+
+// unwrapCheckpointer returns the checkpointer wrapped by the worker to monitor or coordinate its leases
+func unwrapCheckpointer(checkpointer chk.Checkpointer) chk.Checkpointer {
+	for {
+		switch c := checkpointer.(type) {
+		case *monitoredCheckpointer:
+			checkpointer = c.Checkpointer
+		case *coordinatedCheckpointer:
+			checkpointer = c.Checkpointer
+		default:
+			return checkpointer
+		}
+	}
+}
+
+// computeLeasesToSteal returns the most loaded worker and the number of leases to steal from it. As in the
+// Java KCL, the target number of leases per worker is the number of leases divided by the number of workers
+// (rounded up) and no more than maxLeasesToStealAtOneTime leases are stolen at once.
+func computeLeasesToSteal(workers map[string][]*par.ShardStatus, workerID string, maxLeasesForWorker, maxLeasesToStealAtOneTime int) (string, int) {
 	var numShards int
 	for _, shards := range workers {
 		numShards += len(shards)
 	}
 
 	numWorkers := len(workers)
-
-	// 1:1 shards to workers is optimal, so we cannot possibly rebalance
-	if numWorkers >= numShards {
-		log.Debugf("Optimal shard allocation, not stealing any shards. workerID: %s, %v > %v. ", w.workerID, numWorkers, numShards)
-		return nil
-	}
-
-	currentShards, ok := workers[w.workerID]
-	var numCurrentShards int
-	if !ok {
-		numCurrentShards = 0
+	if _, ok := workers[workerID]; !ok {
 		numWorkers++
-	} else {
-		numCurrentShards = len(currentShards)
 	}
 
-	optimalShards := numShards / numWorkers
+	target := numShards / numWorkers
+	if numShards%numWorkers != 0 {
+		target++
+	}
+	if target > maxLeasesForWorker {
+		target = maxLeasesForWorker
+	}
 
-	// We have more than or equal optimal shards, so no rebalancing can take place
-	if numCurrentShards >= optimalShards || numCurrentShards == w.kclConfig.MaxLeasesForWorker {
-		log.Debugf("We have enough shards, not attempting to steal any. workerID: %s", w.workerID)
-		return nil
+	numLeasesToReachTarget := target - len(workers[workerID])
+	if numLeasesToReachTarget <= 0 {
+		return "", 0
 	}
 
-	var workerSteal string
+	var mostLoadedWorker string
+	var mostLoadedLeases int
 	for worker, shards := range workers {
-		if worker != w.workerID && len(shards) > optimalShards {
-			workerSteal = worker
-			optimalShards = len(shards)
+		if worker != workerID && len(shards) > mostLoadedLeases {
+			mostLoadedWorker = worker
+			mostLoadedLeases = len(shards)
 		}
 	}
-	// Not all shards are allocated so fallback to default shard allocation mechanisms
-	if workerSteal == "" {
-		log.Infof("Not all shards are allocated, not stealing any. workerID: %s", w.workerID)
-		return nil
+
+	if mostLoadedLeases < target {
+		return "", 0
 	}
 
-	// Steal a random shard from the worker with the most shards
-	w.shardStealInProgress = true
-	rnd, _ := rand.Int(rand.Reader, big.NewInt(int64(len(workers[workerSteal]))))
-	randIndex := int(rnd.Int64())
-	shardToSteal := workers[workerSteal][randIndex]
-	log.Debugf("Stealing shard %s from %s", shardToSteal, workerSteal)
+	numLeasesToSteal := mostLoadedLeases - target
+	if numLeasesToSteal > numLeasesToReachTarget {
+		numLeasesToSteal = numLeasesToReachTarget
+	}
+	// steal one lease if we need more than one and the most loaded worker has exactly the target
+	if numLeasesToReachTarget > 1 && numLeasesToSteal == 0 {
+		numLeasesToSteal = 1
+	}
+	if numLeasesToSteal > maxLeasesToStealAtOneTime {
+		numLeasesToSteal = maxLeasesToStealAtOneTime
+	}
 
-	err = w.checkpointer.ClaimShard(w.shardStatus[shardToSteal.ID], w.workerID)
-	if err != nil {
-		w.shardStealInProgress = false
-		return err
+	if numLeasesToSteal == 0 {
+		return "", 0
 	}
-	return nil
+	return mostLoadedWorker, numLeasesToSteal
 }
 
-// List all shards and store them into shardStatus table
-// If shard has been removed, need to exclude it from cached shard status.
-func (w *Worker) getShardIDs(nextToken string, shardInfo map[string]bool) error {
-	log := w.kclConfig.Logger
+// getStreams returns the names or ARNs of the streams consumed by the worker in multi-stream mode
+func (w *Worker) getStreams() ([]string, error) {
+	if w.kclConfig.StreamProvider != nil {
+		return w.kclConfig.StreamProvider()
+	}
+	return w.kclConfig.Streams, nil
+}
+
+// parseStream returns the name and the ARN of a stream identified by its name or its ARN,
+// e.g. arn:aws:kinesis:us-west-2:123456789012:stream/name
+func parseStream(stream string) (string, string) {
+	if !strings.HasPrefix(stream, "arn:") {
+		return stream, ""
+	}
+	if i := strings.LastIndex(stream, ":stream/"); i >= 0 {
+		return stream[i+len(":stream/"):], stream
+	}
+	return stream, stream
+}
 
-	args := &kinesis.ListShardsInput{}
+// streamKey identifies a stream by its ARN, so that the streams of the same name in different accounts or regions
+// are told apart, or by its name if it is configured by its name
+func streamKey(streamName, streamARN string) string {
+	if streamARN != "" {
+		return streamARN
+	}
+	return streamName
+}
 
-	// When you have a nextToken, you can't set the streamName
-	if nextToken != "" {
-		args.NextToken = aws.String(nextToken)
-	} else {
-		args.StreamName = aws.String(w.streamName)
+// leaseKey returns the key of the lease of the shard, which is namespaced by the streamKey in multi-stream mode and
+// once the worker has failed over to the secondary stream
+func (w *Worker) leaseKey(stream, shardID string) string {
+	if shardID == "" || (!w.kclConfig.IsMultiStreamMode() && !w.failover.isActive()) {
+		return shardID
 	}
+	return stream + ":" + shardID
+}
 
-	listShards, err := w.kc.ListShards(context.TODO(), args)
+// List all shards and store them into shardStatus table
+// If shard has been removed, need to exclude it from cached shard status.
+// A listing interrupted by throttling is resumed from its NextToken by the next sync.
+func (w *Worker) getShardIDs(streamName, streamARN string, shardInfo, skipped map[string]bool) error {
+	log := w.log
+
+	// The ListShardsFilter only selects the new shards to consume. The shards it skips, e.g. the closed ones, still
+	// exist, so that their leases are kept and their child shards wait for them.
+	filtered, err := w.listFilteredShards(streamName, streamARN)
 	if err != nil {
-		log.Errorf("Error in ListShards: %s Error: %+v Request: %s", w.streamName, err, args)
 		return err
 	}
 
-	for _, s := range listShards.Shards {
-		// record avail shardId from fresh reading from Kinesis
-		shardInfo[*s.ShardId] = true
+	stream := streamKey(streamName, streamARN)
+	nextToken := ""
+	listed := make(map[string]bool)
+	if listing := w.shardSync.resume(stream); listing != nil {
+		log.Debugf("Resuming the listing of the shards of %s", streamName)
+		nextToken = listing.nextToken
+		listed = listing.listed
+	}
+
+	for {
+		args := &kinesis.ListShardsInput{}
 
-		// found new shard
-		if _, ok := w.shardStatus[*s.ShardId]; !ok {
-			log.Infof("Found new shard with id %s", *s.ShardId)
-			w.shardStatus[*s.ShardId] = &par.ShardStatus{
-				ID:                     *s.ShardId,
-				ParentShardId:          aws.ToString(s.ParentShardId),
+		// When you have a nextToken, you can't set the streamName
+		if nextToken != "" {
+			args.NextToken = aws.String(nextToken)
+		} else if streamARN == "" {
+			args.StreamName = aws.String(streamName)
+		}
+		// the stream ARN authorizes the access to streams of other accounts
+		if streamARN != "" {
+			args.StreamARN = aws.String(streamARN)
+		}
+
+		listShards, err := w.kc.ListShards(context.TODO(), args)
+		if err != nil {
+			log.Errorf("Error in ListShards: %s Error: %+v Request: %s", streamName, err, args)
+			if nextToken != "" && isListShardsThrottled(err) {
+				w.shardSync.interrupt(stream, nextToken, listed)
+			}
+			return err
+		}
+
+		for _, s := range listShards.Shards {
+			key := w.leaseKey(stream, *s.ShardId)
+			// record avail shardId from fresh reading from Kinesis
+			listed[key] = true
+			w.shardSync.addChild(w.leaseKey(stream, aws.ToString(s.ParentShardId)), key)
+			w.shardSync.addChild(w.leaseKey(stream, aws.ToString(s.AdjacentParentShardId)), key)
+
+			if filtered != nil && !filtered[key] {
+				skipped[key] = true
+				continue
+			}
+			// the shards outside the filter are left to other workers
+			if !w.isShardConsumed(streamName, s) {
+				continue
+			}
+
+			if shard, ok := w.shardStatus[key]; ok {
+				// the ending sequence number is only listed once the shard is closed
+				shard.SetSequenceNumberRange(aws.ToString(s.SequenceNumberRange.StartingSequenceNumber),
+					aws.ToString(s.SequenceNumberRange.EndingSequenceNumber))
+				continue
+			}
+
+			// found new shard
+			log.Infof("Found new shard with id %s", key)
+			shard := &par.ShardStatus{
+				ID:                     key,
+				ParentShardId:          w.leaseKey(stream, aws.ToString(s.ParentShardId)),
+				AdjacentParentShardId:  w.leaseKey(stream, aws.ToString(s.AdjacentParentShardId)),
 				Mux:                    &sync.RWMutex{},
 				StartingSequenceNumber: aws.ToString(s.SequenceNumberRange.StartingSequenceNumber),
 				EndingSequenceNumber:   aws.ToString(s.SequenceNumberRange.EndingSequenceNumber),
 			}
+			if s.HashKeyRange != nil {
+				shard.StartingHashKey = aws.ToString(s.HashKeyRange.StartingHashKey)
+				shard.EndingHashKey = aws.ToString(s.HashKeyRange.EndingHashKey)
+			}
+			if w.kclConfig.IsMultiStreamMode() {
+				shard.ShardID = *s.ShardId
+				shard.StreamName = streamName
+				shard.StreamARN = streamARN
+			} else if w.failover.isActive() {
+				shard.ShardID = *s.ShardId
+			}
+			w.shardStatus[key] = shard
 		}
+
+		if listShards.NextToken == nil {
+			break
+		}
+		nextToken = aws.ToString(listShards.NextToken)
+	}
+
+	for key := range listed {
+		shardInfo[key] = true
+	}
+	return nil
+}
+
+// listFilteredShards returns the shards of the stream listed by the ListShardsFilter, it returns nil without filter
+func (w *Worker) listFilteredShards(streamName, streamARN string) (map[string]bool, error) {
+	if w.kclConfig.ListShardsFilter == nil {
+		return nil, nil
 	}
 
-	if listShards.NextToken != nil {
-		err := w.getShardIDs(aws.ToString(listShards.NextToken), shardInfo)
+	filtered := make(map[string]bool)
+	args := &kinesis.ListShardsInput{ShardFilter: w.kclConfig.ListShardsFilter}
+	if streamARN != "" {
+		args.StreamARN = aws.String(streamARN)
+	} else {
+		args.StreamName = aws.String(streamName)
+	}
+	for {
+		listShards, err := w.kc.ListShards(context.TODO(), args)
 		if err != nil {
-			log.Errorf("Error in ListShards: %s Error: %+v Request: %s", w.streamName, err, args)
-			return err
+			w.log.Errorf("Error in ListShards: %s Error: %+v Request: %s", streamName, err, args)
+			return nil, err
+		}
+		for _, s := range listShards.Shards {
+			filtered[w.leaseKey(streamKey(streamName, streamARN), aws.ToString(s.ShardId))] = true
 		}
+
+		if listShards.NextToken == nil {
+			return filtered, nil
+		}
+		// When you have a nextToken, you can't set the streamName
+		args = &kinesis.ListShardsInput{NextToken: listShards.NextToken, StreamARN: args.StreamARN}
 	}
+}
 
-	return nil
+// isShardConsumed tells whether the shard is pinned to the worker, if any are, and matched by its shard filter
+func (w *Worker) isShardConsumed(streamName string, shard types.Shard) bool {
+	if len(w.kclConfig.PinnedShardIDs) > 0 && !w.isShardPinned(aws.ToString(shard.ShardId)) {
+		return false
+	}
+	return w.kclConfig.ShardFilter == nil || w.kclConfig.ShardFilter(streamName, shard)
+}
+
+// isPinnedWithoutCoordination tells whether the worker is pinned to shards whose leases are not coordinated with the
+// workers of the lease table
+func (w *Worker) isPinnedWithoutCoordination() bool {
+	return len(w.kclConfig.PinnedShardIDs) > 0 && !w.kclConfig.PinnedShardLeaseCoordination
+}
+
+// isShardPinned tells whether the shard is one of the PinnedShardIDs
+func (w *Worker) isShardPinned(shardID string) bool {
+	for _, id := range w.kclConfig.PinnedShardIDs {
+		if id == shardID {
+			return true
+		}
+	}
+	return false
+}
+
+// addChildShards adds the child shards returned by Kinesis for closed shards to the shard status, so that they can be
+// leased before the shards are listed again. It returns false if the child shards of a closed shard are not known.
+func (w *Worker) addChildShards(closed []closedShard) bool {
+	if len(closed) == 0 {
+		return false
+	}
+
+	known := true
+	for _, c := range closed {
+		if len(c.children) == 0 {
+			// the child shards may have been listed already
+			if !w.isLineageKnown(c.shard.ID) {
+				known = false
+			}
+			continue
+		}
+
+		streamName, stream := c.shard.StreamName, streamKey(c.shard.StreamName, c.shard.StreamARN)
+		if streamName == "" {
+			streamName, stream = w.streamName, streamKey(w.streamName, w.streamARN)
+		}
+		for _, child := range c.children {
+			key := w.leaseKey(stream, aws.ToString(child.ShardId))
+			for _, parent := range child.ParentShards {
+				w.shardSync.addChild(w.leaseKey(stream, parent), key)
+			}
+			if _, ok := w.shardStatus[key]; ok {
+				continue
+			}
+			if !w.isShardConsumed(streamName, types.Shard{ShardId: child.ShardId, HashKeyRange: child.HashKeyRange}) {
+				continue
+			}
+
+			w.log.Infof("Found child shard with id %s of closed shard %s", key, c.shard.ID)
+			shard := &par.ShardStatus{
+				ID:  key,
+				Mux: &sync.RWMutex{},
+			}
+			if child.HashKeyRange != nil {
+				shard.StartingHashKey = aws.ToString(child.HashKeyRange.StartingHashKey)
+				shard.EndingHashKey = aws.ToString(child.HashKeyRange.EndingHashKey)
+			}
+			if len(child.ParentShards) > 0 {
+				shard.ParentShardId = w.leaseKey(stream, child.ParentShards[0])
+			}
+			if len(child.ParentShards) > 1 {
+				shard.AdjacentParentShardId = w.leaseKey(stream, child.ParentShards[1])
+			}
+			if w.kclConfig.IsMultiStreamMode() {
+				shard.ShardID = aws.ToString(child.ShardId)
+				shard.StreamName = c.shard.StreamName
+				shard.StreamARN = c.shard.StreamARN
+			} else if w.failover.isActive() {
+				shard.ShardID = aws.ToString(child.ShardId)
+			}
+			w.shardStatus[key] = shard
+		}
+	}
+
+	return known
+}
+
+// isLineageKnown tells whether the child shards of the shard have been listed, so that they are in the shard status
+// unless they are left to other workers by the shard filter
+func (w *Worker) isLineageKnown(shardID string) bool {
+	return len(w.shardSync.children(shardID)) > 0
 }
 
 // syncShard to sync the cached shard info with actual shard info from Kinesis
 func (w *Worker) syncShard() error {
-	log := w.kclConfig.Logger
+	log := w.log
 	shardInfo := make(map[string]bool)
-	err := w.getShardIDs("", shardInfo)
+	skipped := make(map[string]bool)
+	consumedStreams := make(map[string]bool)
 
-	if err != nil {
-		return err
+	if !w.kclConfig.IsMultiStreamMode() {
+		if err := w.getShardIDs(w.streamName, w.streamARN, shardInfo, skipped); err != nil {
+			return err
+		}
+	} else {
+		streams, err := w.getStreams()
+		if err != nil {
+			return err
+		}
+
+		for _, stream := range streams {
+			streamName, streamARN := parseStream(stream)
+			consumedStreams[streamKey(streamName, streamARN)] = true
+
+			// a new stream needs its own enhanced fan-out consumer in multi-stream mode
+			if w.kclConfig.EnableEnhancedFanOutConsumer && w.consumerARNs[streamKey(streamName, streamARN)] == "" {
+				consumerARN, err := w.fetchConsumerARNWithRetry(streamName, streamARN)
+				if err != nil {
+					return err
+				}
+				w.consumerARNs[streamKey(streamName, streamARN)] = consumerARN
+			}
+
+			if err := w.getShardIDs(streamName, streamARN, shardInfo, skipped); err != nil {
+				return err
+			}
+		}
 	}
 
 	for _, shard := range w.shardStatus {
+		// The stream is no longer consumed. Its leases are kept, so that it resumes from the checkpoints
+		// if it is consumed again.
+		if stream := streamKey(shard.StreamName, shard.StreamARN); shard.StreamName != "" && !consumedStreams[stream] {
+			log.Infof("Stream %s of shard %s is no longer consumed", stream, shard.ID)
+			delete(w.shardStatus, shard.ID)
+			delete(w.consumerARNs, stream)
+			continue
+		}
+
 		// The cached shard no longer existed, remove it.
 		if _, ok := shardInfo[shard.ID]; !ok {
 			// remove the shard from local status cache
 			delete(w.shardStatus, shard.ID)
+			delete(w.cleanedLeases, shard.ID)
 			// remove the shard entry in dynamoDB as well
 			// Note: syncShard runs periodically. we don't need to do anything in case of error here.
 			if err := w.checkpointer.RemoveLeaseInfo(shard.ID); err != nil {
@@ -529,6 +1319,10 @@ func (w *Worker) syncShard() error {
 			}
 		}
 	}
+	// the lineage of the expired shards is forgotten
+	w.shardSync.prune(func(key string) bool { return shardInfo[key] })
+	w.listedShards = shardInfo
+	w.skippedShards = skipped
 
 	return nil
 }
@@ -0,0 +1,671 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/stretchr/testify/assert"
+
+	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/metrics/cloudwatch"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+	"github.com/vmware/vmware-go-kcl-v2/logger"
+)
+
+func newTestShards(n int) []*par.ShardStatus {
+	shards := make([]*par.ShardStatus, n)
+	for i := range shards {
+		shards[i] = &par.ShardStatus{ID: fmt.Sprintf("%04d", i), Mux: &sync.RWMutex{}}
+	}
+	return shards
+}
+
+func TestNew(t *testing.T) {
+	worker, err := New("stream", "app", processorFactory{},
+		config.WithRegion("us-west-2"),
+		config.WithWorkerID("worker"),
+		config.WithMemoryCheckpointer(""))
+	assert.Nil(t, err)
+	assert.Equal(t, "stream", worker.streamName)
+	assert.Equal(t, "us-west-2", worker.regionName)
+	assert.Equal(t, "worker", worker.workerID)
+
+	_, err = New("stream", "app", processorFactory{},
+		config.WithRegion("us-west-2"),
+		config.WithMaxRecords(-1))
+	var errs config.ValidationErrors
+	assert.True(t, errors.As(err, &errs))
+	assert.Equal(t, "MaxRecords", errs[0].Field)
+}
+
+func TestNewWorkerValidatesConfiguration(t *testing.T) {
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker")
+	kclConfig.MaxRecords = -1
+
+	// the settings changed without the WithXxx methods are validated before the worker starts
+	err := NewWorker(processorFactory{}, kclConfig).WithCheckpointer(newMockCheckpointer()).Start()
+	var errs config.ValidationErrors
+	assert.True(t, errors.As(err, &errs))
+	assert.Equal(t, "MaxRecords", errs[0].Field)
+}
+
+func TestInitializeThrottled(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "id")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	// the stream is throttled once while the consumer ARN is fetched during the initialization
+	var mux sync.Mutex
+	throttled := false
+	server := newKinesisServer(t, kinesisHandlers{
+		"DescribeStreamSummary": func(map[string]interface{}) interface{} {
+			mux.Lock()
+			defer mux.Unlock()
+			if !throttled {
+				throttled = true
+				return kinesisError{status: http.StatusBadRequest, errType: "LimitExceededException", message: "slow down"}
+			}
+			return map[string]interface{}{"StreamDescriptionSummary": map[string]interface{}{
+				"StreamARN": "arn:aws:kinesis:us-west-2:123456789012:stream/stream", "StreamName": "stream",
+			}}
+		},
+		"DescribeStreamConsumer": func(map[string]interface{}) interface{} {
+			return map[string]interface{}{"ConsumerDescription": map[string]interface{}{
+				"ConsumerARN": "consumer", "ConsumerName": "app", "ConsumerStatus": "ACTIVE",
+			}}
+		},
+	})
+	defer server.Close()
+
+	// the throttles are counted by a monitoring service whose state is set up by Init
+	mService := cloudwatch.NewMonitoringService("us-west-2", credentials.NewStaticCredentialsProvider("id", "secret", ""))
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+		WithKinesisEndpoint(server.URL).
+		WithEnhancedFanOutConsumer(true).
+		WithMonitoringService(mService)
+	w := NewWorker(processorFactory{}, kclConfig).WithCheckpointer(chk.NewMemoryCheckpoint(kclConfig))
+
+	assert.Nil(t, w.initialize())
+	assert.Equal(t, "consumer", w.consumerARN)
+	assert.True(t, throttled)
+}
+
+func TestComputeLeasesToSteal(t *testing.T) {
+	// a new worker joins a worker holding all the shards
+	workers := map[string][]*par.ShardStatus{"worker_1": newTestShards(6)}
+	worker, n := computeLeasesToSteal(workers, "worker_2", 100, 1)
+	assert.Equal(t, "worker_1", worker)
+	assert.Equal(t, 1, n)
+
+	worker, n = computeLeasesToSteal(workers, "worker_2", 100, 5)
+	assert.Equal(t, "worker_1", worker)
+	assert.Equal(t, 3, n)
+
+	// the target is capped by the max leases for a worker
+	worker, n = computeLeasesToSteal(workers, "worker_2", 2, 5)
+	assert.Equal(t, "worker_1", worker)
+	assert.Equal(t, 2, n)
+
+	// steal from the most loaded worker
+	workers = map[string][]*par.ShardStatus{
+		"worker_1": newTestShards(2),
+		"worker_2": newTestShards(4),
+		"worker_3": newTestShards(3),
+	}
+	worker, n = computeLeasesToSteal(workers, "worker_1", 100, 5)
+	assert.Equal(t, "worker_2", worker)
+	assert.Equal(t, 1, n)
+
+	// balanced
+	workers = map[string][]*par.ShardStatus{
+		"worker_1": newTestShards(1),
+		"worker_2": newTestShards(2),
+	}
+	_, n = computeLeasesToSteal(workers, "worker_1", 100, 5)
+	assert.Equal(t, 0, n)
+	_, n = computeLeasesToSteal(workers, "worker_2", 100, 5)
+	assert.Equal(t, 0, n)
+
+	// more workers than shards
+	workers = map[string][]*par.ShardStatus{"worker_1": newTestShards(1)}
+	_, n = computeLeasesToSteal(workers, "worker_2", 100, 5)
+	assert.Equal(t, 0, n)
+}
+
+func TestIsParentShardsCompleted(t *testing.T) {
+	checkpointer := newMockCheckpointer()
+	w := &Worker{
+		checkpointer: checkpointer,
+		shardStatus: map[string]*par.ShardStatus{
+			"parent":   {ID: "parent", Mux: &sync.RWMutex{}},
+			"adjacent": {ID: "adjacent", Mux: &sync.RWMutex{}},
+		},
+	}
+
+	shard := &par.ShardStatus{ID: "child", Mux: &sync.RWMutex{}}
+	completed, err := w.isParentShardsCompleted(shard)
+	assert.Nil(t, err)
+	assert.True(t, completed)
+
+	// merged shard, the parents have not been checkpointed yet
+	shard.ParentShardId = "parent"
+	shard.AdjacentParentShardId = "adjacent"
+	completed, err = w.isParentShardsCompleted(shard)
+	assert.Nil(t, err)
+	assert.False(t, completed)
+
+	checkpointer.checkpoints["parent"] = chk.ShardEnd
+	checkpointer.checkpoints["adjacent"] = "deadbeef"
+	completed, err = w.isParentShardsCompleted(shard)
+	assert.Nil(t, err)
+	assert.False(t, completed)
+
+	checkpointer.checkpoints["adjacent"] = chk.ShardEnd
+	completed, err = w.isParentShardsCompleted(shard)
+	assert.Nil(t, err)
+	assert.True(t, completed)
+
+	// the parent shard has expired from the stream
+	shard.ParentShardId = "expired"
+	shard.AdjacentParentShardId = ""
+	completed, err = w.isParentShardsCompleted(shard)
+	assert.Nil(t, err)
+	assert.True(t, completed)
+}
+
+func TestParseStream(t *testing.T) {
+	streamName, streamARN := parseStream("stream")
+	assert.Equal(t, "stream", streamName)
+	assert.Equal(t, "", streamARN)
+
+	streamName, streamARN = parseStream("arn:aws:kinesis:us-west-2:123456789012:stream/stream")
+	assert.Equal(t, "stream", streamName)
+	assert.Equal(t, "arn:aws:kinesis:us-west-2:123456789012:stream/stream", streamARN)
+}
+
+// newListShardsServer returns a Kinesis endpoint listing the given shards per stream name or ARN
+func newListShardsServer(t *testing.T, shards map[string][]string) *httptest.Server {
+	return newKinesisServer(t, kinesisHandlers{
+		"ListShards": func(input map[string]interface{}) interface{} {
+			streamName, _ := input["StreamName"].(string)
+			streamARN, _ := input["StreamARN"].(string)
+			var listed []map[string]interface{}
+			for _, id := range shards[streamName+streamARN] {
+				listed = append(listed, testShard(id, ""))
+			}
+			return listShards(listed...)(input)
+		},
+	})
+}
+
+func TestSyncShardMultiStream(t *testing.T) {
+	streamARN := "arn:aws:kinesis:us-west-2:123456789012:stream/stream_2"
+	// the stream of the same name in another account has its own leases
+	otherARN := "arn:aws:kinesis:us-west-2:210987654321:stream/stream_2"
+	server := newListShardsServer(t, map[string][]string{
+		"stream_1": {"shardId-0", "shardId-1"},
+		streamARN:  {"shardId-0"},
+		otherARN:   {"shardId-0"},
+	})
+	defer server.Close()
+
+	streams := []string{"stream_1", streamARN, otherARN}
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+		WithStreamProvider(func() ([]string, error) { return streams, nil })
+
+	checkpointer := newMockCheckpointer()
+	w := NewWorker(shutdownRecorderFactory{}, kclConfig).WithKinesis(newTestKinesisClient(server.URL)).WithCheckpointer(checkpointer)
+	w.shardStatus = map[string]*par.ShardStatus{}
+
+	assert.Nil(t, w.syncShard())
+	assert.Equal(t, 4, len(w.shardStatus))
+	assert.Contains(t, w.shardStatus, "stream_1:shardId-0")
+	shard := w.shardStatus[streamARN+":shardId-0"]
+	assert.Equal(t, "shardId-0", shard.GetShardID())
+	assert.Equal(t, "stream_2", shard.StreamName)
+	assert.Equal(t, streamARN, shard.StreamARN)
+	assert.Equal(t, otherARN, w.shardStatus[otherARN+":shardId-0"].StreamARN)
+
+	// the leases of a stream which is no longer consumed are kept
+	checkpointer.checkpoints[streamARN+":shardId-0"] = "100"
+	streams = []string{"stream_1", otherARN}
+	assert.Nil(t, w.syncShard())
+	assert.Equal(t, 3, len(w.shardStatus))
+	assert.Contains(t, w.shardStatus, "stream_1:shardId-1")
+	assert.Contains(t, w.shardStatus, otherARN+":shardId-0")
+	assert.Equal(t, "100", checkpointer.checkpoints[streamARN+":shardId-0"])
+}
+
+func TestSyncShardStreamARN(t *testing.T) {
+	// the stream of another account is only listed by its ARN
+	streamARN := "arn:aws:kinesis:us-west-2:123456789012:stream/stream"
+	server := newListShardsServer(t, map[string][]string{streamARN: {"shardId-0"}})
+	defer server.Close()
+
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").WithStreamARN(streamARN)
+	w := NewWorker(shutdownRecorderFactory{}, kclConfig).WithKinesis(newTestKinesisClient(server.URL)).WithCheckpointer(newMockCheckpointer())
+	w.shardStatus = map[string]*par.ShardStatus{}
+
+	assert.Nil(t, w.syncShard())
+	assert.Equal(t, 1, len(w.shardStatus))
+	assert.Contains(t, w.shardStatus, "shardId-0")
+
+	consumer := w.newShardConsumer(w.shardStatus["shardId-0"]).(*PollingShardConsumer)
+	assert.Equal(t, streamARN, consumer.streamARN)
+}
+
+func TestSyncShardFilter(t *testing.T) {
+	var filterTypes []string
+	var shards []map[string]interface{}
+	for i, hashKeys := range [][]string{{"0", "99"}, {"100", "199"}, {"200", "299"}} {
+		shard := testShard(fmt.Sprintf("shardId-%d", i), "")
+		shard["HashKeyRange"] = map[string]string{"StartingHashKey": hashKeys[0], "EndingHashKey": hashKeys[1]}
+		shards = append(shards, shard)
+	}
+	list := listShards(shards...)
+	server := newKinesisServer(t, kinesisHandlers{
+		"ListShards": func(input map[string]interface{}) interface{} {
+			filter, _ := input["ShardFilter"].(map[string]interface{})
+			filterType, _ := filter["Type"].(string)
+			filterTypes = append(filterTypes, filterType)
+			return list(input)
+		},
+	})
+	defer server.Close()
+
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+		WithShardFilter(config.HashKeyRangeFilter(big.NewInt(150), big.NewInt(250))).
+		WithListShardsFilter(types.ShardFilter{Type: types.ShardFilterTypeAtLatest})
+	checkpointer := newMockCheckpointer()
+	checkpointer.checkpoints["shardId-0"] = "100"
+	w := NewWorker(shutdownRecorderFactory{}, kclConfig).WithKinesis(newTestKinesisClient(server.URL)).WithCheckpointer(checkpointer)
+	w.shardStatus = map[string]*par.ShardStatus{}
+
+	assert.Nil(t, w.syncShard())
+	// the shards are listed once with the filter to select the new shards, and once without for their lineage
+	assert.Equal(t, []string{"AT_LATEST", ""}, filterTypes)
+	assert.Equal(t, 2, len(w.shardStatus))
+	assert.Contains(t, w.shardStatus, "shardId-1")
+	assert.Contains(t, w.shardStatus, "shardId-2")
+	// the lease of a shard outside the filter is left to other workers
+	assert.Equal(t, "100", checkpointer.checkpoints["shardId-0"])
+
+	// the child shards outside the filter are not leased either
+	assert.True(t, w.addChildShards([]closedShard{{
+		shard: w.shardStatus["shardId-1"],
+		children: []types.ChildShard{
+			{ShardId: aws.String("shardId-3"), ParentShards: []string{"shardId-1"},
+				HashKeyRange: &types.HashKeyRange{StartingHashKey: aws.String("100"), EndingHashKey: aws.String("149")}},
+			{ShardId: aws.String("shardId-4"), ParentShards: []string{"shardId-1"},
+				HashKeyRange: &types.HashKeyRange{StartingHashKey: aws.String("150"), EndingHashKey: aws.String("199")}},
+		},
+	}}))
+	assert.NotContains(t, w.shardStatus, "shardId-3")
+	assert.Contains(t, w.shardStatus, "shardId-4")
+}
+
+func TestSyncShardFilterKeepsParents(t *testing.T) {
+	// the child shard has been split from the parent shard, which has closed outside the filters of the worker
+	parent := testShard("shardId-0", "100")
+	parent["HashKeyRange"] = map[string]string{"StartingHashKey": "0", "EndingHashKey": "99"}
+	child := testShard("shardId-1", "")
+	child["ParentShardId"] = "shardId-0"
+	child["HashKeyRange"] = map[string]string{"StartingHashKey": "0", "EndingHashKey": "199"}
+	all, open := listShards(parent, child), listShards(child)
+	server := newKinesisServer(t, kinesisHandlers{
+		"ListShards": func(input map[string]interface{}) interface{} {
+			if _, ok := input["ShardFilter"]; ok {
+				return open(input)
+			}
+			return all(input)
+		},
+	})
+	defer server.Close()
+
+	for name, kclConfig := range map[string]*config.KinesisClientLibConfiguration{
+		"ListShardsFilter": config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+			WithListShardsFilter(types.ShardFilter{Type: types.ShardFilterTypeAtLatest}),
+		"ShardFilter": config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+			WithShardFilter(config.HashKeyRangeFilter(big.NewInt(150), big.NewInt(250))),
+	} {
+		t.Run(name, func(t *testing.T) {
+			// the parent shard is still consumed by another worker
+			checkpointer := newMockCheckpointer()
+			checkpointer.checkpoints["shardId-0"] = "50"
+			w := NewWorker(shutdownRecorderFactory{}, kclConfig).WithKinesis(newTestKinesisClient(server.URL)).WithCheckpointer(checkpointer)
+			w.shardStatus = map[string]*par.ShardStatus{}
+
+			assert.Nil(t, w.syncShard())
+			assert.Equal(t, 1, len(w.shardStatus))
+			assert.Contains(t, w.shardStatus, "shardId-1")
+			// the lease of the parent shard is kept and the child shard waits for it
+			assert.Equal(t, "50", checkpointer.checkpoints["shardId-0"])
+			completed, err := w.isParentShardsCompleted(w.shardStatus["shardId-1"])
+			assert.Nil(t, err)
+			assert.False(t, completed)
+
+			checkpointer.checkpoints["shardId-0"] = chk.ShardEnd
+			completed, err = w.isParentShardsCompleted(w.shardStatus["shardId-1"])
+			assert.Nil(t, err)
+			assert.True(t, completed)
+		})
+	}
+
+	// a closed parent shard skipped by the ListShardsFilter is not waited for unless it has been leased
+	w := NewWorker(shutdownRecorderFactory{}, config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+		WithListShardsFilter(types.ShardFilter{Type: types.ShardFilterTypeAtLatest})).
+		WithKinesis(newTestKinesisClient(server.URL)).
+		WithCheckpointer(newMockCheckpointer())
+	w.shardStatus = map[string]*par.ShardStatus{}
+	assert.Nil(t, w.syncShard())
+	completed, err := w.isParentShardsCompleted(w.shardStatus["shardId-1"])
+	assert.Nil(t, err)
+	assert.True(t, completed)
+}
+
+type shutdownRecorderFactory struct{}
+
+func (shutdownRecorderFactory) CreateProcessor() kcl.IRecordProcessor { return &shutdownRecorder{} }
+
+func newTestKinesisClient(url string) *kinesis.Client {
+	return kinesis.New(kinesis.Options{
+		Region:           "us-west-2",
+		Credentials:      credentials.NewStaticCredentialsProvider("id", "secret", ""),
+		EndpointResolver: kinesis.EndpointResolverFromURL(url),
+	})
+}
+
+// fieldsRecorder records the key/value pairs of the last message
+type fieldsRecorder struct {
+	keyValues []interface{}
+}
+
+func (r *fieldsRecorder) Debug(_ string, keyValues ...interface{}) { r.keyValues = keyValues }
+
+func (r *fieldsRecorder) Info(_ string, keyValues ...interface{}) { r.keyValues = keyValues }
+
+func (r *fieldsRecorder) Warn(_ string, keyValues ...interface{}) { r.keyValues = keyValues }
+
+func (r *fieldsRecorder) Error(_ string, keyValues ...interface{}) { r.keyValues = keyValues }
+
+func TestShardConsumerLogFields(t *testing.T) {
+	recorder := &fieldsRecorder{}
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+		WithLogger(logger.NewStructuredLogger(recorder))
+	w := NewWorker(shutdownRecorderFactory{}, kclConfig)
+
+	consumer := w.newShardConsumer(&par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}}).(*PollingShardConsumer)
+	consumer.getLogger().Infof("processing")
+	assert.Equal(t, []interface{}{"stream", "stream", "workerID", "worker", "shardID", "0001"}, recorder.keyValues)
+}
+
+// drainProcessor signals the first batch, finishes it after the delay or once its context is canceled and
+// checkpoints at shutdown
+type drainProcessor struct {
+	started    chan struct{}
+	startOnce  sync.Once
+	delay      time.Duration
+	batchErr   error
+	shutdowns  []kcl.ShutdownReason
+	checkpoint error
+}
+
+func (p *drainProcessor) Initialize(*kcl.InitializationInput) {}
+
+func (p *drainProcessor) ProcessRecords(input *kcl.ProcessRecordsInput) error {
+	p.startOnce.Do(func() { close(p.started) })
+	select {
+	case <-time.After(p.delay):
+	case <-input.Context.Done():
+	}
+	p.batchErr = input.Context.Err()
+	return nil
+}
+
+func (p *drainProcessor) Shutdown(input *kcl.ShutdownInput) {
+	p.shutdowns = append(p.shutdowns, input.ShutdownReason)
+	p.checkpoint = input.Checkpointer.Checkpoint(aws.String("100"))
+}
+
+// startDrainTestWorker runs the consumer of a leased shard like the event loop of a started worker
+func startDrainTestWorker(t *testing.T, processor *drainProcessor) (*Worker, *mockCheckpointer, func()) {
+	server := newGetRecordsServer(t)
+	checkpointer := newMockCheckpointer()
+	w := NewWorker(processorFactory{processor}, config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker")).
+		WithKinesis(newTestKinesisClient(server.URL)).
+		WithCheckpointer(checkpointer)
+
+	stop := make(chan struct{})
+	w.stop = &stop
+	w.ctx, w.cancel = context.WithCancel(context.Background())
+	w.waitGroup = &sync.WaitGroup{}
+
+	shard := &par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}, LeaseTimeout: time.Now().Add(time.Minute)}
+	assert.Nil(t, checkpointer.GetLease(shard, "worker"))
+	w.waitGroup.Add(1)
+	go func() {
+		defer w.waitGroup.Done()
+		w.runShardConsumer(shard)
+	}()
+
+	<-processor.started
+	return w, checkpointer, server.Close
+}
+
+func TestShutdownDrainsInFlightBatch(t *testing.T) {
+	processor := &drainProcessor{started: make(chan struct{}), delay: 100 * time.Millisecond}
+	w, checkpointer, closeServer := startDrainTestWorker(t, processor)
+	defer closeServer()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.Nil(t, w.ShutdownWithContext(ctx))
+
+	// the in-flight batch has been finished, the final checkpoint is stored before the lease is released
+	assert.Nil(t, processor.batchErr)
+	assert.Equal(t, []kcl.ShutdownReason{kcl.REQUESTED}, processor.shutdowns)
+	assert.Nil(t, processor.checkpoint)
+	assert.Equal(t, "100", checkpointer.checkpoints["0001"])
+	assert.NotContains(t, checkpointer.owners, "0001")
+
+	// shutting down again is a no-op
+	assert.Nil(t, w.ShutdownWithContext(ctx))
+}
+
+func TestShutdownDrainTimeout(t *testing.T) {
+	processor := &drainProcessor{started: make(chan struct{}), delay: time.Hour}
+	w, _, closeServer := startDrainTestWorker(t, processor)
+	defer closeServer()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := w.ShutdownWithContext(ctx)
+	assert.Equal(t, ErrShutdownTimeout{Shards: []string{"0001"}}, err)
+	assert.Equal(t, "shard consumers did not shut down in time: 0001", err.Error())
+
+	// the batch which did not drain in time is canceled
+	w.waitGroup.Wait()
+	assert.Equal(t, context.Canceled, processor.batchErr)
+}
+
+func TestAcquireLeases(t *testing.T) {
+	newLeaseWorker := func(workerID string, table *chk.MemoryLeaseTable) *Worker {
+		kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", workerID).
+			WithMaxLeasesForWorker(3).
+			WithMaxLeasesToAcquireAtOneTime(2)
+		w := NewWorker(processorFactory{}, kclConfig).
+			WithCheckpointer(chk.NewMemoryCheckpoint(kclConfig).WithLeaseTable(table))
+		w.shardStatus = map[string]*par.ShardStatus{}
+		for _, shard := range newTestShards(4) {
+			w.shardStatus[shard.ID] = shard
+		}
+		return w
+	}
+
+	// the first worker doesn't grab every shard at once, leaving some for its peers
+	table, _ := chk.NewMemoryLeaseTable("")
+	worker1 := newLeaseWorker("worker_1", table)
+	worker2 := newLeaseWorker("worker_2", table)
+	assert.Equal(t, 2, len(worker1.acquireLeases()))
+	assert.Equal(t, 2, len(worker2.acquireLeases()))
+	assert.Empty(t, worker1.acquireLeases())
+
+	// a worker never holds more than MaxLeasesForWorker leases
+	table, _ = chk.NewMemoryLeaseTable("")
+	worker3 := newLeaseWorker("worker_3", table)
+	assert.Equal(t, 2, len(worker3.acquireLeases()))
+	assert.Equal(t, 1, len(worker3.acquireLeases()))
+	assert.Empty(t, worker3.acquireLeases())
+}
+
+func TestLeaseHandoff(t *testing.T) {
+	table, _ := chk.NewMemoryLeaseTable("")
+	newStealingWorker := func(workerID string) *Worker {
+		kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", workerID).
+			WithFailoverTimeMillis(1000).
+			WithMaxLeasesToAcquireAtOneTime(2).
+			WithLeaseStealing(true)
+		w := NewWorker(processorFactory{}, kclConfig).
+			WithCheckpointer(chk.NewMemoryCheckpoint(kclConfig).WithLeaseTable(table))
+		w.shardStatus = map[string]*par.ShardStatus{}
+		w.shardClaims = map[string]time.Time{}
+		for _, shard := range newTestShards(2) {
+			shard.Checkpoint = "deadbeef"
+			w.shardStatus[shard.ID] = shard
+		}
+		return w
+	}
+
+	owner := newStealingWorker("worker_1")
+	assert.Equal(t, 2, len(owner.acquireLeases()))
+	for _, shard := range owner.shardStatus {
+		assert.Nil(t, owner.checkpointer.CheckpointSequence(shard))
+	}
+
+	// the claiming worker waits for the lease owner to hand off the shard
+	stealer := newStealingWorker("worker_2")
+	assert.Empty(t, stealer.acquireLeases())
+	assert.Nil(t, stealer.rebalance())
+	assert.Equal(t, 1, len(stealer.shardClaims))
+	var claimed string
+	for shardID := range stealer.shardClaims {
+		claimed = shardID
+	}
+	assert.Empty(t, stealer.acquireLeases())
+
+	// the lease owner is signaled, checkpoints without stomping on the claim and releases the lease
+	_, err := owner.checkpointer.ListActiveWorkers(owner.shardStatus)
+	assert.Nil(t, err)
+	sc := &commonShardConsumer{shard: owner.shardStatus[claimed], kclConfig: owner.kclConfig}
+	assert.True(t, sc.isLeaseRequested())
+	sc.shard.SetCheckpoint("deadcafe")
+	assert.Nil(t, owner.checkpointer.CheckpointSequence(sc.shard))
+	assert.Nil(t, owner.checkpointer.RemoveLeaseOwner(claimed))
+
+	acquired := stealer.acquireLeases()
+	assert.Equal(t, 1, len(acquired))
+	assert.Equal(t, claimed, acquired[0].ID)
+	assert.Equal(t, "deadcafe", acquired[0].GetCheckpoint())
+	assert.Empty(t, stealer.shardClaims)
+}
+
+func TestLeaseHandoffTimeout(t *testing.T) {
+	kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+		WithLeaseStealing(true)
+	table, _ := chk.NewMemoryLeaseTable("")
+	w := NewWorker(processorFactory{}, kclConfig).
+		WithCheckpointer(chk.NewMemoryCheckpoint(kclConfig).WithLeaseTable(table))
+	w.shardClaims = map[string]time.Time{}
+	shard := newTestShards(1)[0]
+	shard.Checkpoint = "deadbeef"
+	assert.Nil(t, chk.NewMemoryCheckpoint(config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "owner")).
+		WithLeaseTable(table).GetLease(shard, "owner"))
+
+	// the steal completes once the handoff has timed out
+	w.shardClaims[shard.ID] = time.Now()
+	assert.False(t, w.isHandedOff(shard))
+	w.shardClaims[shard.ID] = time.Now().Add(-time.Duration(kclConfig.LeaseStealingHandoffTimeoutMillis) * time.Millisecond)
+	assert.True(t, w.isHandedOff(shard))
+}
+
+func TestReclaimLeasesOnStartup(t *testing.T) {
+	table, _ := chk.NewMemoryLeaseTable("")
+	newRestartedWorker := func(reclaim bool) *Worker {
+		kclConfig := config.NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").
+			WithReclaimLeasesOnStartup(reclaim)
+		w := NewWorker(processorFactory{}, kclConfig).
+			WithCheckpointer(chk.NewMemoryCheckpoint(kclConfig).WithLeaseTable(table))
+		w.shardStatus = map[string]*par.ShardStatus{}
+		for _, shard := range newTestShards(4) {
+			shard.Checkpoint = "deadbeef"
+			w.shardStatus[shard.ID] = shard
+		}
+		return w
+	}
+
+	// the worker held the leases of 3 shards before its restart
+	previous := newRestartedWorker(false)
+	for _, shard := range newTestShards(3) {
+		shard.Checkpoint = "deadbeef"
+		assert.Nil(t, previous.checkpointer.GetLease(shard, "worker"))
+	}
+
+	assert.Equal(t, 1, len(newRestartedWorker(false).acquireLeases()))
+
+	// the owners of the leases are read by the lease scan instead of one read per shard
+	w := newRestartedWorker(true)
+	counting := &leaseOwnerCountingCheckpointer{Checkpointer: w.checkpointer}
+	w.checkpointer = counting
+	assert.Equal(t, 3, len(w.reclaimLeases()))
+	assert.Zero(t, counting.leaseOwnerReads)
+
+	w = newRestartedWorker(true)
+	assert.Equal(t, 4, len(w.acquireLeases()))
+	assert.True(t, w.leasesReclaimed)
+	for _, shard := range w.shardStatus {
+		assert.Equal(t, "worker", shard.GetLeaseOwner())
+		assert.Equal(t, "deadbeef", shard.GetCheckpoint())
+	}
+}
+
+// leaseOwnerCountingCheckpointer counts the reads of single lease owners
+type leaseOwnerCountingCheckpointer struct {
+	chk.Checkpointer
+	leaseOwnerReads int
+}
+
+func (c *leaseOwnerCountingCheckpointer) GetLeaseOwner(shardID string) (string, error) {
+	c.leaseOwnerReads++
+	return c.Checkpointer.GetLeaseOwner(shardID)
+}
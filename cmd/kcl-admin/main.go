@@ -0,0 +1,141 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Command kcl-admin administers the lease table of a KCL application.
+//
+// Usage:
+//
+//	kcl-admin -app <application> [flags] list
+//	kcl-admin -app <application> [flags] show <shard ID>
+//	kcl-admin -app <application> [flags] release <shard ID>
+//	kcl-admin -app <application> [flags] reset <shard ID> <sequence number>
+//	kcl-admin -app <application> [flags] delete <shard ID>
+//	kcl-admin -app <application> [flags] delete-table
+//
+// The leases are kept in DynamoDB by default, Redis and the file of a memory checkpointer are selected with
+// -redis and -file.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/admin"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+)
+
+func main() {
+	app := flag.String("app", "", "the name of the KCL application")
+	table := flag.String("table", "", "the name of the lease table, the application name by default")
+	region := flag.String("region", "us-west-2", "the AWS region of the DynamoDB table")
+	endpoint := flag.String("endpoint", "", "the DynamoDB endpoint, e.g. of a local DynamoDB")
+	redisAddress := flag.String("redis", "", "the address of the Redis server keeping the leases")
+	redisPassword := flag.String("redis-password", "", "the password of the Redis server")
+	file := flag.String("file", "", "the file of the memory checkpointer keeping the leases")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s -app <application> [flags] list|show|release|reset|delete|delete-table [args]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *app == "" || flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// the lease table does not depend on the stream consumed by the application
+	kclConfig := config.NewKinesisClientLibConfig(*app, *app, *region, "kcl-admin")
+	if *table != "" {
+		kclConfig.WithTableName(*table)
+	}
+	switch {
+	case *redisAddress != "":
+		config.WithRedisCheckpointer(*redisAddress, *redisPassword, 0)(kclConfig)
+	case *file != "":
+		kclConfig.WithMemoryCheckpointer(*file)
+	case *endpoint != "":
+		kclConfig.WithDynamoDBEndpoint(*endpoint)
+	}
+
+	a, err := admin.NewFromConfig(kclConfig)
+	if err == nil {
+		err = run(a, flag.Arg(0), flag.Args()[1:])
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "kcl-admin:", err)
+		os.Exit(1)
+	}
+}
+
+func run(a *admin.Admin, command string, args []string) error {
+	argsRequired := map[string]int{"list": 0, "show": 1, "release": 1, "reset": 2, "delete": 1, "delete-table": 0}
+	n, ok := argsRequired[command]
+	if !ok {
+		return fmt.Errorf("unknown command %q", command)
+	}
+	if len(args) != n {
+		return fmt.Errorf("%s expects %d arguments, got %d", command, n, len(args))
+	}
+
+	switch command {
+	case "list":
+		leases, err := a.Leases()
+		if err != nil {
+			return err
+		}
+		printLeases(leases...)
+	case "show":
+		lease, err := a.Lease(args[0])
+		if err != nil {
+			return err
+		}
+		printLeases(*lease)
+	case "release":
+		return a.ReleaseLease(args[0])
+	case "reset":
+		return a.ResetCheckpoint(args[0], args[1])
+	case "delete":
+		return a.DeleteLease(args[0])
+	case "delete-table":
+		return a.DeleteLeaseTable()
+	}
+	return nil
+}
+
+func printLeases(leases ...admin.Lease) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SHARD\tOWNER\tLEASE TIMEOUT\tCHECKPOINT\tSUB-SEQUENCE\tPENDING\tCLAIM")
+	for _, lease := range leases {
+		var leaseTimeout, subSequenceNumber string
+		if !lease.LeaseTimeout.IsZero() {
+			leaseTimeout = lease.LeaseTimeout.Format(time.RFC3339)
+		}
+		if lease.SubSequenceNumber != nil {
+			subSequenceNumber = fmt.Sprint(aws.ToInt64(lease.SubSequenceNumber))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", lease.ShardID, lease.Owner, leaseTimeout, lease.Checkpoint,
+			subSequenceNumber, lease.PendingCheckpoint, lease.ClaimRequest)
+	}
+	w.Flush()
+}
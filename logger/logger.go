@@ -58,6 +58,13 @@ type Logger interface {
 	WithFields(keyValues Fields) Logger
 }
 
+// LevelSetter is implemented by loggers whose level can be changed at runtime, e.g. by a configuration update of
+// the worker. The logrus, zap, zerolog and slog loggers of the library implement it.
+type LevelSetter interface {
+	// SetLevel changes the level to debug, info, warn, error or fatal
+	SetLevel(level string) error
+}
+
 // Configuration stores the config for the logger
 // For some loggers there can only be one level across writers, for such the level of Console is picked by default
 type Configuration struct {
@@ -24,6 +24,7 @@ import (
 	"testing"
 
 	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestLogrusLoggerWithConfig(t *testing.T) {
@@ -51,3 +52,63 @@ func TestLogrusLogger(t *testing.T) {
 	contextLogger.Debugf("Starting with logrus")
 	contextLogger.Infof("Logrus is awesome")
 }
+
+func TestLogrusLoggerSetLevel(t *testing.T) {
+	lLogger := logrus.New()
+	log := NewLogrusLogger(lLogger)
+
+	assert.Nil(t, log.(LevelSetter).SetLevel(Debug))
+	assert.Equal(t, logrus.DebugLevel, lLogger.GetLevel())
+
+	// the level is shared by the loggers derived by WithFields
+	contextLogger := log.WithFields(Fields{"key1": "value1"})
+	assert.Nil(t, contextLogger.(LevelSetter).SetLevel(Warn))
+	assert.Equal(t, logrus.WarnLevel, lLogger.GetLevel())
+
+	assert.NotNil(t, log.(LevelSetter).SetLevel("verbose"))
+}
+
+// recordingLogger records the last message and key/value pairs
+type recordingLogger struct {
+	level     string
+	msg       string
+	keyValues []interface{}
+}
+
+func (r *recordingLogger) record(level, msg string, keyValues []interface{}) {
+	r.level, r.msg, r.keyValues = level, msg, keyValues
+}
+
+func (r *recordingLogger) Debug(msg string, keyValues ...interface{}) {
+	r.record(Debug, msg, keyValues)
+}
+
+func (r *recordingLogger) Info(msg string, keyValues ...interface{}) {
+	r.record(Info, msg, keyValues)
+}
+
+func (r *recordingLogger) Warn(msg string, keyValues ...interface{}) {
+	r.record(Warn, msg, keyValues)
+}
+
+func (r *recordingLogger) Error(msg string, keyValues ...interface{}) {
+	r.record(Error, msg, keyValues)
+}
+
+func TestStructuredLogger(t *testing.T) {
+	recorder := &recordingLogger{}
+	log := NewStructuredLogger(recorder)
+
+	log.Infof("Structured logger is %s", "awesome")
+	assert.Equal(t, Info, recorder.level)
+	assert.Equal(t, "Structured logger is awesome", recorder.msg)
+	assert.Empty(t, recorder.keyValues)
+
+	contextLogger := log.WithFields(Fields{"workerID": "worker"}).WithFields(Fields{"shardID": "0001", "stream": "stream"})
+	contextLogger.Warnf("with fields")
+	assert.Equal(t, Warn, recorder.level)
+	assert.Equal(t, []interface{}{"workerID", "worker", "shardID", "0001", "stream", "stream"}, recorder.keyValues)
+
+	assert.Panics(t, func() { contextLogger.Panicf("panic") })
+	assert.Equal(t, Error, recorder.level)
+}
@@ -22,6 +22,7 @@
 package logger
 
 import (
+	"fmt"
 	"io"
 	"os"
 
@@ -151,6 +152,34 @@ func (l *LogrusLogEntry) WithFields(fields Fields) Logger {
 	}
 }
 
+// SetLevel changes the level of the logrus logger, the loggers derived by WithFields share the level.
+func (l *LogrusLogger) SetLevel(level string) error {
+	lLevel, err := logrus.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+
+	switch lLogger := l.logger.(type) {
+	case *logrus.Logger:
+		lLogger.SetLevel(lLevel)
+	case *logrus.Entry:
+		lLogger.Logger.SetLevel(lLevel)
+	default:
+		return fmt.Errorf("cannot change the level of %T", l.logger)
+	}
+	return nil
+}
+
+// SetLevel changes the level of the logrus logger of the entry.
+func (l *LogrusLogEntry) SetLevel(level string) error {
+	lLevel, err := logrus.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	l.entry.Logger.SetLevel(lLevel)
+	return nil
+}
+
 func getFormatter(isJSON bool) logrus.Formatter {
 	if isJSON {
 		return &logrus.JSONFormatter{}
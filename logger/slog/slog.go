@@ -0,0 +1,102 @@
+//go:build go1.21
+
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package slog
+
+import (
+	"errors"
+	"fmt"
+	uslog "log/slog"
+	"os"
+
+	"github.com/vmware/vmware-go-kcl-v2/logger"
+)
+
+type slogLogger struct {
+	log *uslog.Logger
+	// level is the level of the handler of the logger if it is known
+	level *uslog.LevelVar
+}
+
+// NewSlogLogger adapts existing slog logger to Logger interface. The fields are added as attributes of the records.
+func NewSlogLogger(log *uslog.Logger) logger.Logger {
+	return &slogLogger{log: log}
+}
+
+// NewSlogLoggerWithLevel adapts existing slog logger whose handler is configured with the level, so that it can be
+// changed by SetLevel
+func NewSlogLoggerWithLevel(log *uslog.Logger, level *uslog.LevelVar) logger.Logger {
+	return &slogLogger{log: log, level: level}
+}
+
+var slogLevels = map[string]uslog.Level{
+	logger.Debug: uslog.LevelDebug,
+	logger.Info:  uslog.LevelInfo,
+	logger.Warn:  uslog.LevelWarn,
+	logger.Error: uslog.LevelError,
+	// Fatalf logs at the error level before exiting
+	logger.Fatal: uslog.LevelError,
+}
+
+// SetLevel changes the level of a logger created by NewSlogLoggerWithLevel, the level of a logger adapted by
+// NewSlogLogger is left to the application
+func (l *slogLogger) SetLevel(level string) error {
+	if l.level == nil {
+		return errors.New("the level of an adapted slog logger cannot be changed")
+	}
+	slogLevel, ok := slogLevels[level]
+	if !ok {
+		return fmt.Errorf("unknown level %s", level)
+	}
+	l.level.Set(slogLevel)
+	return nil
+}
+
+func (l *slogLogger) Debugf(format string, args ...interface{}) {
+	l.log.Debug(fmt.Sprintf(format, args...))
+}
+
+func (l *slogLogger) Infof(format string, args ...interface{}) {
+	l.log.Info(fmt.Sprintf(format, args...))
+}
+
+func (l *slogLogger) Warnf(format string, args ...interface{}) {
+	l.log.Warn(fmt.Sprintf(format, args...))
+}
+
+func (l *slogLogger) Errorf(format string, args ...interface{}) {
+	l.log.Error(fmt.Sprintf(format, args...))
+}
+
+func (l *slogLogger) Fatalf(format string, args ...interface{}) {
+	l.log.Error(fmt.Sprintf(format, args...))
+	os.Exit(1)
+}
+
+func (l *slogLogger) Panicf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	l.log.Error(msg)
+	panic(msg)
+}
+
+func (l *slogLogger) WithFields(fields logger.Fields) logger.Logger {
+	return &slogLogger{log: l.log.With(logger.KeyValues(fields)...), level: l.level}
+}
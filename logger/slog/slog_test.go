@@ -0,0 +1,66 @@
+//go:build go1.21
+
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package slog
+
+import (
+	"bytes"
+	"encoding/json"
+	uslog "log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/vmware-go-kcl-v2/logger"
+)
+
+func TestSlogLogger(t *testing.T) {
+	var buf bytes.Buffer
+	log := NewSlogLogger(uslog.New(uslog.NewJSONHandler(&buf, nil)))
+
+	contextLogger := log.WithFields(logger.Fields{"key1": "value1"})
+	contextLogger.Debugf("Starting with slog")
+	contextLogger.Infof("Slog is %s", "awesome")
+
+	// the debug record is below the default level
+	var record map[string]interface{}
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, "Slog is awesome", record["msg"])
+	assert.Equal(t, "INFO", record["level"])
+	assert.Equal(t, "value1", record["key1"])
+}
+
+func TestSlogLoggerSetLevel(t *testing.T) {
+	var buf bytes.Buffer
+	level := &uslog.LevelVar{}
+	log := NewSlogLoggerWithLevel(uslog.New(uslog.NewJSONHandler(&buf, &uslog.HandlerOptions{Level: level})), level)
+	contextLogger := log.WithFields(logger.Fields{"key1": "value1"})
+
+	contextLogger.Debugf("hidden")
+	assert.Equal(t, 0, buf.Len())
+	assert.Nil(t, contextLogger.(logger.LevelSetter).SetLevel(logger.Debug))
+	contextLogger.Debugf("shown")
+	assert.Contains(t, buf.String(), "shown")
+	assert.NotNil(t, log.(logger.LevelSetter).SetLevel("verbose"))
+
+	// the level of an adapted logger is left to the application
+	assert.NotNil(t, NewSlogLogger(uslog.Default()).(logger.LevelSetter).SetLevel(logger.Debug))
+}
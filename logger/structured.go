@@ -0,0 +1,98 @@
+/*
+ * Copyright (c) 2022 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package logger
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// StructuredLogger is the minimal interface of a leveled logger with structured key/value pairs. The key/value pairs
+// alternate between keys and values, e.g. it is implemented by *slog.Logger.
+type StructuredLogger interface {
+	Debug(msg string, keyValues ...interface{})
+
+	Info(msg string, keyValues ...interface{})
+
+	Warn(msg string, keyValues ...interface{})
+
+	Error(msg string, keyValues ...interface{})
+}
+
+type structuredLogger struct {
+	logger    StructuredLogger
+	keyValues []interface{}
+}
+
+// NewStructuredLogger adapts a structured logger to the Logger interface. The fields are passed to the structured
+// logger as key/value pairs.
+func NewStructuredLogger(logger StructuredLogger) Logger {
+	return &structuredLogger{logger: logger}
+}
+
+func (l *structuredLogger) Debugf(format string, args ...interface{}) {
+	l.logger.Debug(fmt.Sprintf(format, args...), l.keyValues...)
+}
+
+func (l *structuredLogger) Infof(format string, args ...interface{}) {
+	l.logger.Info(fmt.Sprintf(format, args...), l.keyValues...)
+}
+
+func (l *structuredLogger) Warnf(format string, args ...interface{}) {
+	l.logger.Warn(fmt.Sprintf(format, args...), l.keyValues...)
+}
+
+func (l *structuredLogger) Errorf(format string, args ...interface{}) {
+	l.logger.Error(fmt.Sprintf(format, args...), l.keyValues...)
+}
+
+func (l *structuredLogger) Fatalf(format string, args ...interface{}) {
+	l.logger.Error(fmt.Sprintf(format, args...), l.keyValues...)
+	os.Exit(1)
+}
+
+func (l *structuredLogger) Panicf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	l.logger.Error(msg, l.keyValues...)
+	panic(msg)
+}
+
+func (l *structuredLogger) WithFields(fields Fields) Logger {
+	return &structuredLogger{
+		logger:    l.logger,
+		keyValues: append(append([]interface{}{}, l.keyValues...), KeyValues(fields)...),
+	}
+}
+
+// KeyValues converts the fields to alternating keys and values sorted by key.
+func KeyValues(fields Fields) []interface{} {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	keyValues := make([]interface{}, 0, 2*len(fields))
+	for _, k := range keys {
+		keyValues = append(keyValues, k, fields[k])
+	}
+	return keyValues
+}
@@ -22,6 +22,8 @@
 package zap
 
 import (
+	"errors"
+	"fmt"
 	"os"
 
 	"github.com/vmware/vmware-go-kcl-v2/logger"
@@ -32,6 +34,9 @@ import (
 
 type ZapLogger struct {
 	sugaredLogger *uzap.SugaredLogger
+	// levels are the levels of the cores created by NewZapLoggerWithConfig, they are shared by the loggers derived
+	// by WithFields
+	levels []uzap.AtomicLevel
 }
 
 // NewZapLogger adapts existing sugared zap logger to Logger interface.
@@ -54,16 +59,19 @@ func NewZapLogger(logger *uzap.SugaredLogger) logger.Logger {
 // zap Sugared logger.
 func NewZapLoggerWithConfig(config logger.Configuration) logger.Logger {
 	cores := []zapcore.Core{}
+	levels := []uzap.AtomicLevel{}
 
 	if config.EnableConsole {
-		level := getZapLevel(config.ConsoleLevel)
+		level := uzap.NewAtomicLevelAt(getZapLevel(config.ConsoleLevel))
+		levels = append(levels, level)
 		writer := zapcore.Lock(os.Stdout)
 		core := zapcore.NewCore(getEncoder(config.ConsoleJSONFormat), writer, level)
 		cores = append(cores, core)
 	}
 
 	if config.EnableFile {
-		level := getZapLevel(config.FileLevel)
+		level := uzap.NewAtomicLevelAt(getZapLevel(config.FileLevel))
+		levels = append(levels, level)
 		writer := zapcore.AddSync(&lumberjack.Logger{
 			Filename:   config.Filename,
 			MaxSize:    config.MaxSizeMB,
@@ -87,9 +95,26 @@ func NewZapLoggerWithConfig(config logger.Configuration) logger.Logger {
 
 	return &ZapLogger{
 		sugaredLogger: logger,
+		levels:        levels,
 	}
 }
 
+// SetLevel changes the level of the console and the file of a logger created by NewZapLoggerWithConfig. The level
+// of a zap logger adapted by NewZapLogger is left to the application.
+func (l *ZapLogger) SetLevel(level string) error {
+	if len(l.levels) == 0 {
+		return errors.New("the level of an adapted zap logger cannot be changed")
+	}
+	zapLevel, ok := zapLevels[level]
+	if !ok {
+		return fmt.Errorf("unknown level %s", level)
+	}
+	for _, atomicLevel := range l.levels {
+		atomicLevel.SetLevel(zapLevel)
+	}
+	return nil
+}
+
 func (l *ZapLogger) Debugf(format string, args ...interface{}) {
 	l.sugaredLogger.Debugf(format, args...)
 }
@@ -111,7 +136,7 @@ func (l *ZapLogger) Fatalf(format string, args ...interface{}) {
 }
 
 func (l *ZapLogger) Panicf(format string, args ...interface{}) {
-	l.sugaredLogger.Fatalf(format, args...)
+	l.sugaredLogger.Panicf(format, args...)
 }
 
 func (l *ZapLogger) WithFields(fields logger.Fields) logger.Logger {
@@ -121,7 +146,7 @@ func (l *ZapLogger) WithFields(fields logger.Fields) logger.Logger {
 		f = append(f, v)
 	}
 	newLogger := l.sugaredLogger.With(f...)
-	return &ZapLogger{newLogger}
+	return &ZapLogger{newLogger, l.levels}
 }
 
 func getEncoder(isJSON bool) zapcore.Encoder {
@@ -133,19 +158,17 @@ func getEncoder(isJSON bool) zapcore.Encoder {
 	return zapcore.NewConsoleEncoder(encoderConfig)
 }
 
+var zapLevels = map[string]zapcore.Level{
+	logger.Debug: zapcore.DebugLevel,
+	logger.Info:  zapcore.InfoLevel,
+	logger.Warn:  zapcore.WarnLevel,
+	logger.Error: zapcore.ErrorLevel,
+	logger.Fatal: zapcore.FatalLevel,
+}
+
 func getZapLevel(level string) zapcore.Level {
-	switch level {
-	case logger.Info:
-		return zapcore.InfoLevel
-	case logger.Warn:
-		return zapcore.WarnLevel
-	case logger.Debug:
-		return zapcore.DebugLevel
-	case logger.Error:
-		return zapcore.ErrorLevel
-	case logger.Fatal:
-		return zapcore.FatalLevel
-	default:
-		return zapcore.InfoLevel
+	if zapLevel, ok := zapLevels[level]; ok {
+		return zapLevel
 	}
+	return zapcore.InfoLevel
 }
@@ -37,3 +37,15 @@ func TestZapLogger(t *testing.T) {
 	contextLogger.Debugf("Starting with zap")
 	contextLogger.Infof("Zap is awesome")
 }
+
+func TestZapLoggerSetLevel(t *testing.T) {
+	log := zap.NewZapLoggerWithConfig(logger.Configuration{EnableConsole: true, ConsoleLevel: logger.Info})
+	contextLogger := log.WithFields(logger.Fields{"key1": "value1"})
+	assert.Nil(t, contextLogger.(logger.LevelSetter).SetLevel(logger.Debug))
+	assert.NotNil(t, log.(logger.LevelSetter).SetLevel("verbose"))
+
+	// the level of an adapted logger is left to the application
+	zapLogger, err := uzap.NewProduction()
+	assert.Nil(t, err)
+	assert.NotNil(t, zap.NewZapLogger(zapLogger.Sugar()).(logger.LevelSetter).SetLevel(logger.Debug))
+}
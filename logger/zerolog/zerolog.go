@@ -23,14 +23,39 @@
 package zerolog
 
 import (
+	"fmt"
+	"os"
+	"sync/atomic"
+
 	"github.com/rs/zerolog"
 	"github.com/vmware/vmware-go-kcl-v2/logger"
 	"gopkg.in/natefinch/lumberjack.v2"
-	"os"
 )
 
 type zeroLogger struct {
 	log zerolog.Logger
+	// level is shared by the loggers derived by WithFields
+	level *levelHook
+}
+
+// levelHook discards the events below its level, so that the level can be changed after the loggers have been
+// derived by WithFields
+type levelHook struct {
+	level int32
+}
+
+func (h *levelHook) Run(e *zerolog.Event, level zerolog.Level, _ string) {
+	if level < zerolog.Level(atomic.LoadInt32(&h.level)) {
+		e.Discard()
+	}
+}
+
+func newZeroLogger(log zerolog.Logger) *zeroLogger {
+	level := &levelHook{level: int32(log.GetLevel())}
+	if log.GetLevel() > zerolog.DebugLevel {
+		log = log.Level(zerolog.DebugLevel)
+	}
+	return &zeroLogger{log: log.Hook(level), level: level}
 }
 
 // NewZerologLogger creates a new logger.Logger backed by RS Zerolog using a default config
@@ -50,6 +75,12 @@ func NewZerologLogger() logger.Logger {
 	})
 }
 
+// NewZerologLoggerFromLogger adapts existing zerolog logger to Logger interface.
+// The call is responsible for configuring zerolog logger appropriately.
+func NewZerologLoggerFromLogger(log zerolog.Logger) logger.Logger {
+	return newZeroLogger(log)
+}
+
 // NewZerologLoggerWithConfig creates a new logger.Logger backed by RS Zerolog using the provided config
 func NewZerologLoggerWithConfig(config logger.Configuration) logger.Logger {
 	var consoleHandler *zerolog.ConsoleWriter
@@ -82,7 +113,17 @@ func NewZerologLoggerWithConfig(config logger.Configuration) logger.Logger {
 		finalLogger = zerolog.New(consoleHandler).Level(getZeroLogLevel(config.ConsoleLevel)).With().Timestamp().Logger()
 	}
 
-	return &zeroLogger{log: finalLogger}
+	return newZeroLogger(finalLogger)
+}
+
+// SetLevel changes the level of the logger, the loggers derived by WithFields share the level
+func (z *zeroLogger) SetLevel(level string) error {
+	zeroLevel, ok := zeroLogLevels[level]
+	if !ok {
+		return fmt.Errorf("unknown level %s", level)
+	}
+	atomic.StoreInt32(&z.level.level, int32(zeroLevel))
+	return nil
 }
 
 func (z *zeroLogger) Debugf(format string, args ...interface{}) {
@@ -112,29 +153,28 @@ func (z *zeroLogger) Panicf(format string, args ...interface{}) {
 func (z *zeroLogger) WithFields(keyValues logger.Fields) logger.Logger {
 	newLogger := z.log.With()
 	for k, v := range keyValues {
-		newLogger.Interface(k, v)
+		newLogger = newLogger.Interface(k, v)
 	}
 
 	return &zeroLogger{
-		log: newLogger.Logger(),
+		log:   newLogger.Logger(),
+		level: z.level,
 	}
 }
 
+var zeroLogLevels = map[string]zerolog.Level{
+	logger.Debug: zerolog.DebugLevel,
+	logger.Info:  zerolog.InfoLevel,
+	logger.Warn:  zerolog.WarnLevel,
+	logger.Error: zerolog.ErrorLevel,
+	logger.Fatal: zerolog.FatalLevel,
+}
+
 func getZeroLogLevel(level string) zerolog.Level {
-	switch level {
-	case logger.Info:
-		return zerolog.InfoLevel
-	case logger.Warn:
-		return zerolog.WarnLevel
-	case logger.Debug:
-		return zerolog.DebugLevel
-	case logger.Error:
-		return zerolog.ErrorLevel
-	case logger.Fatal:
-		return zerolog.FatalLevel
-	default:
-		return zerolog.InfoLevel
+	if zeroLevel, ok := zeroLogLevels[level]; ok {
+		return zeroLevel
 	}
+	return zerolog.InfoLevel
 }
 
 func normalizeConfig(config *logger.Configuration) {
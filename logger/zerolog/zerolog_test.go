@@ -1,8 +1,14 @@
 package zerolog
 
 import (
-	"github.com/vmware/vmware-go-kcl-v2/logger"
+	"bytes"
+	"encoding/json"
 	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/vmware-go-kcl-v2/logger"
 )
 
 func TestZeroLogLoggerWithConfig(t *testing.T) {
@@ -30,3 +36,35 @@ func TestZeroLogLogger(t *testing.T) {
 	contextLogger.Debugf("Starting with zerolog")
 	contextLogger.Infof("Zerolog is awesome")
 }
+
+func TestZeroLogLoggerFromLogger(t *testing.T) {
+	var buf bytes.Buffer
+	log := NewZerologLoggerFromLogger(zerolog.New(&buf))
+
+	log.WithFields(logger.Fields{"key1": "value1"}).Infof("Zerolog is %s", "awesome")
+
+	var record map[string]interface{}
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, "Zerolog is awesome", record["message"])
+	assert.Equal(t, "value1", record["key1"])
+}
+
+func TestZeroLogLoggerSetLevel(t *testing.T) {
+	var buf bytes.Buffer
+	log := NewZerologLoggerFromLogger(zerolog.New(&buf).Level(zerolog.InfoLevel))
+	contextLogger := log.WithFields(logger.Fields{"key1": "value1"})
+
+	contextLogger.Debugf("hidden")
+	assert.Equal(t, 0, buf.Len())
+
+	// the level is shared with the derived loggers
+	assert.Nil(t, log.(logger.LevelSetter).SetLevel(logger.Debug))
+	contextLogger.Debugf("shown")
+	assert.Contains(t, buf.String(), "shown")
+
+	buf.Reset()
+	assert.Nil(t, log.(logger.LevelSetter).SetLevel(logger.Error))
+	contextLogger.Warnf("hidden")
+	assert.Equal(t, 0, buf.Len())
+	assert.NotNil(t, log.(logger.LevelSetter).SetLevel("verbose"))
+}
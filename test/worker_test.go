@@ -33,6 +33,7 @@ import (
 	cfg "github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
 	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/metrics"
 	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/metrics/cloudwatch"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/metrics/emf"
 	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/metrics/prometheus"
 	wk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/worker"
 	"github.com/vmware/vmware-go-kcl-v2/logger"
@@ -345,5 +346,9 @@ func getMetricsConfig(kclConfig *cfg.KinesisClientLibConfiguration, service stri
 		return prometheus.NewMonitoringService(":8080", regionName, kclConfig.Logger)
 	}
 
+	if service == "emf" {
+		return emf.NewMonitoringServiceWithOptions(os.Stdout, kclConfig.Logger, emf.DefaultEMFMetricsBufferDuration)
+	}
+
 	return nil
 }